@@ -0,0 +1,112 @@
+package agnostic
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRecord = `{"$type":"com.example.thing","text":"hello","count":3,"tags":["a","b"]}`
+
+func TestRecordCIDKeyOrder(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	a, err := RecordCID(json.RawMessage(testRecord))
+	require.NoError(err)
+	// DAG-CBOR encoding doesn't depend on JSON key order or whitespace
+	b, err := RecordCID(json.RawMessage(`{ "tags": ["a", "b"], "count": 3, "text": "hello", "$type": "com.example.thing" }`))
+	require.NoError(err)
+	assert.Equal(a, b)
+
+	c, err := RecordCID(json.RawMessage(`{"$type":"com.example.thing","text":"goodbye","count":3,"tags":["a","b"]}`))
+	require.NoError(err)
+	assert.NotEqual(a, c)
+
+	_, err = RecordCID(json.RawMessage(`{"$type":"com.example.thing","ratio":1.5}`))
+	assert.Error(err)
+}
+
+func TestGetRecordVerifyCID(t *testing.T) {
+	require := require.New(t)
+
+	expected, err := RecordCID(json.RawMessage(testRecord))
+	require.NoError(err)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("cid") {
+			t.Errorf("unexpected empty cid param")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"uri":"at://did:plc:abc/com.example.thing/1","cid":"`+expected.String()+`","value":`+testRecord+`}`)
+	}))
+	defer srv.Close()
+
+	out, err := RepoGetRecord(context.Background(), &xrpc.Client{Host: srv.URL}, "", "com.example.thing", "did:plc:abc", "1")
+	require.NoError(err)
+	require.NoError(out.VerifyCID())
+
+	tampered := json.RawMessage(`{"$type":"com.example.thing","text":"tampered"}`)
+	out.Value = &tampered
+	require.Error(out.VerifyCID())
+}
+
+func TestApplyWritesRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Writes []map[string]any `json:"writes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		assert.Len(body.Writes, 2)
+		assert.Equal("com.atproto.repo.applyWrites#create", body.Writes[0]["$type"])
+		assert.Equal("hello", body.Writes[0]["value"].(map[string]any)["text"])
+		assert.Equal("com.atproto.repo.applyWrites#delete", body.Writes[1]["$type"])
+
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"commit":{"cid":"bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm","rev":"3l3qo2vutsw2b"},"results":[{"$type":"com.atproto.repo.applyWrites#createResult","uri":"at://did:plc:abc/com.example.thing/1","cid":"bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"},{"$type":"com.atproto.repo.applyWrites#deleteResult"}]}`)
+	}))
+	defer srv.Close()
+
+	out, err := RepoApplyWrites(context.Background(), &xrpc.Client{Host: srv.URL}, &RepoApplyWrites_Input{
+		Repo: "did:plc:abc",
+		Writes: []*RepoApplyWrites_Input_Writes_Elem{
+			{RepoApplyWrites_Create: &RepoApplyWrites_Create{
+				Collection: "com.example.thing",
+				Value:      json.RawMessage(testRecord),
+			}},
+			{RepoApplyWrites_Delete: &RepoApplyWrites_Delete{
+				Collection: "com.example.thing",
+				Rkey:       "2",
+			}},
+		},
+	})
+	require.NoError(err)
+	require.Len(out.Results, 2)
+	assert.Equal("at://did:plc:abc/com.example.thing/1", out.Results[0].RepoApplyWrites_CreateResult.Uri)
+	assert.NotNil(out.Results[1].RepoApplyWrites_DeleteResult)
+	assert.Equal("3l3qo2vutsw2b", out.Commit.Rev)
+}
+
+func TestApplyWritesEmptyOutput(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	out, err := RepoApplyWrites(context.Background(), &xrpc.Client{Host: srv.URL}, &RepoApplyWrites_Input{Repo: "did:plc:abc"})
+	require.NoError(t, err)
+	assert.Nil(t, out.Commit)
+	assert.Empty(t, out.Results)
+}
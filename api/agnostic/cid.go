@@ -0,0 +1,55 @@
+package agnostic
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+
+	"github.com/ipfs/go-cid"
+)
+
+// RecordCID computes the CID of a record in JSON form: the hash of its
+// DAG-CBOR encoding, which is what a PDS returns for the record and expects
+// in swapRecord fields. The record must be valid atproto data (eg, no
+// floats), with blobs and links in their JSON forms.
+func RecordCID(record json.RawMessage) (cid.Cid, error) {
+	b, err := data.JSONToCBOR(record)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("record is not valid atproto data: %w", err)
+	}
+	return data.ComputeCID(b)
+}
+
+// VerifyCID checks that the record's value matches the CID the server
+// returned for it. It returns an error if the server didn't return a CID.
+func (o *RepoGetRecord_Output) VerifyCID() error {
+	if o.Cid == nil {
+		return fmt.Errorf("no CID returned for record: %s", o.Uri)
+	}
+	return verifyCID(o.Uri, *o.Cid, o.Value)
+}
+
+// VerifyCID checks that the record's value matches the CID the server
+// returned for it.
+func (r *RepoListRecords_Record) VerifyCID() error {
+	return verifyCID(r.Uri, r.Cid, r.Value)
+}
+
+func verifyCID(uri, expected string, value *json.RawMessage) error {
+	if value == nil {
+		return fmt.Errorf("no value returned for record: %s", uri)
+	}
+	want, err := cid.Decode(expected)
+	if err != nil {
+		return fmt.Errorf("invalid CID returned for record %s: %w", uri, err)
+	}
+	got, err := RecordCID(*value)
+	if err != nil {
+		return err
+	}
+	if !got.Equals(want) {
+		return fmt.Errorf("record CID mismatch for %s: got %s, expected %s", uri, got, want)
+	}
+	return nil
+}
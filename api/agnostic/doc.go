@@ -0,0 +1,12 @@
+// Package agnostic has client helpers for the com.atproto.repo endpoints
+// which don't depend on a record's Lexicon schema.
+//
+// They mirror the generated functions in api/atproto, but records are passed
+// in as any value which marshals to a JSON object, and returned as raw JSON
+// (json.RawMessage), instead of going through the registry of known record
+// types in lex/util. This allows working with records of custom Lexicons
+// without generating Go types for them.
+//
+// RecordCID computes the CID of a JSON record the same way a PDS does, for
+// use in compare-and-swap fields, or checking a fetched record's CID.
+package agnostic
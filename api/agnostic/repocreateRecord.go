@@ -0,0 +1,49 @@
+package agnostic
+
+// schema: com.atproto.repo.createRecord
+
+import (
+	"context"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// RepoCreateRecord_Input is the input argument to a com.atproto.repo.createRecord call.
+type RepoCreateRecord_Input struct {
+	// collection: The NSID of the record collection.
+	Collection string `json:"collection"`
+	// record: The record to create; anything which marshals to a JSON object, with a $type.
+	Record any `json:"record"`
+	// repo: The handle or DID of the repo.
+	Repo string `json:"repo"`
+	// rkey: The key of the record.
+	Rkey *string `json:"rkey,omitempty"`
+	// swapCommit: Compare and swap with the previous commit by CID.
+	SwapCommit *string `json:"swapCommit,omitempty"`
+	// validate: Validate the record against its Lexicon? Unset means validate only if the server knows the Lexicon.
+	Validate *bool `json:"validate,omitempty"`
+}
+
+// RepoCreateRecord_Output is the output of a com.atproto.repo.createRecord call.
+type RepoCreateRecord_Output struct {
+	Cid              string      `json:"cid"`
+	Commit           *CommitMeta `json:"commit,omitempty"`
+	Uri              string      `json:"uri"`
+	ValidationStatus *string     `json:"validationStatus,omitempty"`
+}
+
+// CommitMeta is a "commitMeta" in the com.atproto.repo.defs schema.
+type CommitMeta struct {
+	Cid string `json:"cid"`
+	Rev string `json:"rev"`
+}
+
+// RepoCreateRecord calls the XRPC method "com.atproto.repo.createRecord".
+func RepoCreateRecord(ctx context.Context, c *xrpc.Client, input *RepoCreateRecord_Input) (*RepoCreateRecord_Output, error) {
+	var out RepoCreateRecord_Output
+	if err := c.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.repo.createRecord", nil, input, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
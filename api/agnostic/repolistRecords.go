@@ -0,0 +1,51 @@
+package agnostic
+
+// schema: com.atproto.repo.listRecords
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// RepoListRecords_Output is the output of a com.atproto.repo.listRecords call.
+type RepoListRecords_Output struct {
+	Cursor  *string                   `json:"cursor,omitempty"`
+	Records []*RepoListRecords_Record `json:"records"`
+}
+
+// RepoListRecords_Record is a "record" in the com.atproto.repo.listRecords schema.
+type RepoListRecords_Record struct {
+	Cid   string           `json:"cid"`
+	Uri   string           `json:"uri"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// RepoListRecords calls the XRPC method "com.atproto.repo.listRecords".
+//
+// collection: The NSID of the record type.
+// cursor: Where to continue from; empty for the first page.
+// limit: The number of records to return; zero for the server's default.
+// repo: The handle or DID of the repo.
+// reverse: Reverse the order of the returned records?
+func RepoListRecords(ctx context.Context, c *xrpc.Client, collection string, cursor string, limit int64, repo string, reverse bool) (*RepoListRecords_Output, error) {
+	var out RepoListRecords_Output
+
+	params := map[string]interface{}{
+		"collection": collection,
+		"repo":       repo,
+		"reverse":    reverse,
+	}
+	if cursor != "" {
+		params["cursor"] = cursor
+	}
+	if limit > 0 {
+		params["limit"] = limit
+	}
+	if err := c.Do(ctx, xrpc.Query, "", "com.atproto.repo.listRecords", params, nil, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
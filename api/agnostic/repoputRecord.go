@@ -0,0 +1,45 @@
+package agnostic
+
+// schema: com.atproto.repo.putRecord
+
+import (
+	"context"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// RepoPutRecord_Input is the input argument to a com.atproto.repo.putRecord call.
+type RepoPutRecord_Input struct {
+	// collection: The NSID of the record collection.
+	Collection string `json:"collection"`
+	// record: The record to write; anything which marshals to a JSON object, with a $type.
+	Record any `json:"record"`
+	// repo: The handle or DID of the repo.
+	Repo string `json:"repo"`
+	// rkey: The key of the record.
+	Rkey string `json:"rkey"`
+	// swapCommit: Compare and swap with the previous commit by CID.
+	SwapCommit *string `json:"swapCommit,omitempty"`
+	// swapRecord: Compare and swap with the previous record by CID.
+	SwapRecord *string `json:"swapRecord,omitempty"`
+	// validate: Validate the record against its Lexicon? Unset means validate only if the server knows the Lexicon.
+	Validate *bool `json:"validate,omitempty"`
+}
+
+// RepoPutRecord_Output is the output of a com.atproto.repo.putRecord call.
+type RepoPutRecord_Output struct {
+	Cid              string      `json:"cid"`
+	Commit           *CommitMeta `json:"commit,omitempty"`
+	Uri              string      `json:"uri"`
+	ValidationStatus *string     `json:"validationStatus,omitempty"`
+}
+
+// RepoPutRecord calls the XRPC method "com.atproto.repo.putRecord".
+func RepoPutRecord(ctx context.Context, c *xrpc.Client, input *RepoPutRecord_Input) (*RepoPutRecord_Output, error) {
+	var out RepoPutRecord_Output
+	if err := c.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.repo.putRecord", nil, input, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
@@ -65,6 +65,7 @@ type AdminDefs_ActionViewDetail_Subject struct {
 	AdminDefs_RepoViewNotFound   *AdminDefs_RepoViewNotFound
 	AdminDefs_RecordView         *AdminDefs_RecordView
 	AdminDefs_RecordViewNotFound *AdminDefs_RecordViewNotFound
+	Unknown                      *util.UnknownUnionVariant
 }
 
 func (t *AdminDefs_ActionViewDetail_Subject) MarshalJSON() ([]byte, error) {
@@ -84,6 +85,9 @@ func (t *AdminDefs_ActionViewDetail_Subject) MarshalJSON() ([]byte, error) {
 		t.AdminDefs_RecordViewNotFound.LexiconTypeID = "com.atproto.admin.defs#recordViewNotFound"
 		return json.Marshal(t.AdminDefs_RecordViewNotFound)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *AdminDefs_ActionViewDetail_Subject) UnmarshalJSON(b []byte) error {
@@ -107,6 +111,7 @@ func (t *AdminDefs_ActionViewDetail_Subject) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.AdminDefs_RecordViewNotFound)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -114,6 +119,7 @@ func (t *AdminDefs_ActionViewDetail_Subject) UnmarshalJSON(b []byte) error {
 type AdminDefs_ActionView_Subject struct {
 	AdminDefs_RepoRef *AdminDefs_RepoRef
 	RepoStrongRef     *RepoStrongRef
+	Unknown           *util.UnknownUnionVariant
 }
 
 func (t *AdminDefs_ActionView_Subject) MarshalJSON() ([]byte, error) {
@@ -125,6 +131,9 @@ func (t *AdminDefs_ActionView_Subject) MarshalJSON() ([]byte, error) {
 		t.RepoStrongRef.LexiconTypeID = "com.atproto.repo.strongRef"
 		return json.Marshal(t.RepoStrongRef)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *AdminDefs_ActionView_Subject) UnmarshalJSON(b []byte) error {
@@ -142,6 +151,7 @@ func (t *AdminDefs_ActionView_Subject) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.RepoStrongRef)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -159,6 +169,7 @@ type AdminDefs_BlobView struct {
 type AdminDefs_BlobView_Details struct {
 	AdminDefs_ImageDetails *AdminDefs_ImageDetails
 	AdminDefs_VideoDetails *AdminDefs_VideoDetails
+	Unknown                *util.UnknownUnionVariant
 }
 
 func (t *AdminDefs_BlobView_Details) MarshalJSON() ([]byte, error) {
@@ -170,6 +181,9 @@ func (t *AdminDefs_BlobView_Details) MarshalJSON() ([]byte, error) {
 		t.AdminDefs_VideoDetails.LexiconTypeID = "com.atproto.admin.defs#videoDetails"
 		return json.Marshal(t.AdminDefs_VideoDetails)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *AdminDefs_BlobView_Details) UnmarshalJSON(b []byte) error {
@@ -187,6 +201,7 @@ func (t *AdminDefs_BlobView_Details) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.AdminDefs_VideoDetails)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -321,6 +336,7 @@ type AdminDefs_ReportViewDetail_Subject struct {
 	AdminDefs_RepoViewNotFound   *AdminDefs_RepoViewNotFound
 	AdminDefs_RecordView         *AdminDefs_RecordView
 	AdminDefs_RecordViewNotFound *AdminDefs_RecordViewNotFound
+	Unknown                      *util.UnknownUnionVariant
 }
 
 func (t *AdminDefs_ReportViewDetail_Subject) MarshalJSON() ([]byte, error) {
@@ -340,6 +356,9 @@ func (t *AdminDefs_ReportViewDetail_Subject) MarshalJSON() ([]byte, error) {
 		t.AdminDefs_RecordViewNotFound.LexiconTypeID = "com.atproto.admin.defs#recordViewNotFound"
 		return json.Marshal(t.AdminDefs_RecordViewNotFound)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *AdminDefs_ReportViewDetail_Subject) UnmarshalJSON(b []byte) error {
@@ -363,6 +382,7 @@ func (t *AdminDefs_ReportViewDetail_Subject) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.AdminDefs_RecordViewNotFound)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -370,6 +390,7 @@ func (t *AdminDefs_ReportViewDetail_Subject) UnmarshalJSON(b []byte) error {
 type AdminDefs_ReportView_Subject struct {
 	AdminDefs_RepoRef *AdminDefs_RepoRef
 	RepoStrongRef     *RepoStrongRef
+	Unknown           *util.UnknownUnionVariant
 }
 
 func (t *AdminDefs_ReportView_Subject) MarshalJSON() ([]byte, error) {
@@ -381,6 +402,9 @@ func (t *AdminDefs_ReportView_Subject) MarshalJSON() ([]byte, error) {
 		t.RepoStrongRef.LexiconTypeID = "com.atproto.repo.strongRef"
 		return json.Marshal(t.RepoStrongRef)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *AdminDefs_ReportView_Subject) UnmarshalJSON(b []byte) error {
@@ -398,6 +422,7 @@ func (t *AdminDefs_ReportView_Subject) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.RepoStrongRef)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
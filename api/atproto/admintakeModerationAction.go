@@ -29,6 +29,7 @@ type AdminTakeModerationAction_Input struct {
 type AdminTakeModerationAction_Input_Subject struct {
 	AdminDefs_RepoRef *AdminDefs_RepoRef
 	RepoStrongRef     *RepoStrongRef
+	Unknown           *util.UnknownUnionVariant
 }
 
 func (t *AdminTakeModerationAction_Input_Subject) MarshalJSON() ([]byte, error) {
@@ -40,6 +41,9 @@ func (t *AdminTakeModerationAction_Input_Subject) MarshalJSON() ([]byte, error)
 		t.RepoStrongRef.LexiconTypeID = "com.atproto.repo.strongRef"
 		return json.Marshal(t.RepoStrongRef)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *AdminTakeModerationAction_Input_Subject) UnmarshalJSON(b []byte) error {
@@ -57,6 +61,7 @@ func (t *AdminTakeModerationAction_Input_Subject) UnmarshalJSON(b []byte) error
 		return json.Unmarshal(b, t.RepoStrongRef)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -23,6 +23,7 @@ type ModerationCreateReport_Input struct {
 type ModerationCreateReport_Input_Subject struct {
 	AdminDefs_RepoRef *AdminDefs_RepoRef
 	RepoStrongRef     *RepoStrongRef
+	Unknown           *util.UnknownUnionVariant
 }
 
 func (t *ModerationCreateReport_Input_Subject) MarshalJSON() ([]byte, error) {
@@ -34,6 +35,9 @@ func (t *ModerationCreateReport_Input_Subject) MarshalJSON() ([]byte, error) {
 		t.RepoStrongRef.LexiconTypeID = "com.atproto.repo.strongRef"
 		return json.Marshal(t.RepoStrongRef)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *ModerationCreateReport_Input_Subject) UnmarshalJSON(b []byte) error {
@@ -51,6 +55,7 @@ func (t *ModerationCreateReport_Input_Subject) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.RepoStrongRef)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -68,6 +73,7 @@ type ModerationCreateReport_Output struct {
 type ModerationCreateReport_Output_Subject struct {
 	AdminDefs_RepoRef *AdminDefs_RepoRef
 	RepoStrongRef     *RepoStrongRef
+	Unknown           *util.UnknownUnionVariant
 }
 
 func (t *ModerationCreateReport_Output_Subject) MarshalJSON() ([]byte, error) {
@@ -79,6 +85,9 @@ func (t *ModerationCreateReport_Output_Subject) MarshalJSON() ([]byte, error) {
 		t.RepoStrongRef.LexiconTypeID = "com.atproto.repo.strongRef"
 		return json.Marshal(t.RepoStrongRef)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *ModerationCreateReport_Output_Subject) UnmarshalJSON(b []byte) error {
@@ -96,6 +105,7 @@ func (t *ModerationCreateReport_Output_Subject) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.RepoStrongRef)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -8,6 +8,19 @@ import (
 	"github.com/bluesky-social/indigo/lex/util"
 )
 
+// SyncSubscribeRepos_Account is a "account" in the com.atproto.sync.subscribeRepos schema.
+//
+// Represents a change to an account's status on a host (eg, PDS or Relay).
+type SyncSubscribeRepos_Account struct {
+	// active: Indicates that the account has a repository which can be fetched from the host that emitted this event.
+	Active bool   `json:"active" cborgen:"active"`
+	Did    string `json:"did" cborgen:"did"`
+	Seq    int64  `json:"seq" cborgen:"seq"`
+	// status: If active=false, this optional field indicates a reason for why the account is not active.
+	Status *string `json:"status,omitempty" cborgen:"status,omitempty"`
+	Time   string  `json:"time" cborgen:"time"`
+}
+
 // SyncSubscribeRepos_Commit is a "commit" in the com.atproto.sync.subscribeRepos schema.
 type SyncSubscribeRepos_Commit struct {
 	Blobs []util.LexLink `json:"blobs" cborgen:"blobs"`
@@ -64,6 +64,7 @@ type ActorDefs_Preferences_Elem struct {
 	ActorDefs_PersonalDetailsPref *ActorDefs_PersonalDetailsPref
 	ActorDefs_FeedViewPref        *ActorDefs_FeedViewPref
 	ActorDefs_ThreadViewPref      *ActorDefs_ThreadViewPref
+	Unknown                       *util.UnknownUnionVariant
 }
 
 func (t *ActorDefs_Preferences_Elem) MarshalJSON() ([]byte, error) {
@@ -91,6 +92,9 @@ func (t *ActorDefs_Preferences_Elem) MarshalJSON() ([]byte, error) {
 		t.ActorDefs_ThreadViewPref.LexiconTypeID = "app.bsky.actor.defs#threadViewPref"
 		return json.Marshal(t.ActorDefs_ThreadViewPref)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *ActorDefs_Preferences_Elem) UnmarshalJSON(b []byte) error {
@@ -120,6 +124,7 @@ func (t *ActorDefs_Preferences_Elem) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.ActorDefs_ThreadViewPref)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -30,6 +30,7 @@ type ActorProfile struct {
 
 type ActorProfile_Labels struct {
 	LabelDefs_SelfLabels *comatprototypes.LabelDefs_SelfLabels
+	Unknown              *util.UnknownUnionVariant
 }
 
 func (t *ActorProfile_Labels) MarshalJSON() ([]byte, error) {
@@ -37,6 +38,9 @@ func (t *ActorProfile_Labels) MarshalJSON() ([]byte, error) {
 		t.LabelDefs_SelfLabels.LexiconTypeID = "com.atproto.label.defs#selfLabels"
 		return json.Marshal(t.LabelDefs_SelfLabels)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *ActorProfile_Labels) UnmarshalJSON(b []byte) error {
@@ -51,6 +55,7 @@ func (t *ActorProfile_Labels) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.LabelDefs_SelfLabels)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -64,6 +69,9 @@ func (t *ActorProfile_Labels) MarshalCBOR(w io.Writer) error {
 	if t.LabelDefs_SelfLabels != nil {
 		return t.LabelDefs_SelfLabels.MarshalCBOR(w)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalCBOR(w)
+	}
 	return fmt.Errorf("cannot cbor marshal empty enum")
 }
 func (t *ActorProfile_Labels) UnmarshalCBOR(r io.Reader) error {
@@ -78,6 +86,7 @@ func (t *ActorProfile_Labels) UnmarshalCBOR(r io.Reader) error {
 		return t.LabelDefs_SelfLabels.UnmarshalCBOR(bytes.NewReader(b))
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, CBOR: b}
 		return nil
 	}
 }
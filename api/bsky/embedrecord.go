@@ -67,6 +67,7 @@ type EmbedRecord_ViewRecord_Embeds_Elem struct {
 	EmbedExternal_View        *EmbedExternal_View
 	EmbedRecord_View          *EmbedRecord_View
 	EmbedRecordWithMedia_View *EmbedRecordWithMedia_View
+	Unknown                   *util.UnknownUnionVariant
 }
 
 func (t *EmbedRecord_ViewRecord_Embeds_Elem) MarshalJSON() ([]byte, error) {
@@ -86,6 +87,9 @@ func (t *EmbedRecord_ViewRecord_Embeds_Elem) MarshalJSON() ([]byte, error) {
 		t.EmbedRecordWithMedia_View.LexiconTypeID = "app.bsky.embed.recordWithMedia#view"
 		return json.Marshal(t.EmbedRecordWithMedia_View)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *EmbedRecord_ViewRecord_Embeds_Elem) UnmarshalJSON(b []byte) error {
@@ -109,6 +113,7 @@ func (t *EmbedRecord_ViewRecord_Embeds_Elem) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.EmbedRecordWithMedia_View)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -119,6 +124,7 @@ type EmbedRecord_View_Record struct {
 	EmbedRecord_ViewBlocked  *EmbedRecord_ViewBlocked
 	FeedDefs_GeneratorView   *FeedDefs_GeneratorView
 	GraphDefs_ListView       *GraphDefs_ListView
+	Unknown                  *util.UnknownUnionVariant
 }
 
 func (t *EmbedRecord_View_Record) MarshalJSON() ([]byte, error) {
@@ -142,6 +148,9 @@ func (t *EmbedRecord_View_Record) MarshalJSON() ([]byte, error) {
 		t.GraphDefs_ListView.LexiconTypeID = "app.bsky.graph.defs#listView"
 		return json.Marshal(t.GraphDefs_ListView)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *EmbedRecord_View_Record) UnmarshalJSON(b []byte) error {
@@ -168,6 +177,7 @@ func (t *EmbedRecord_View_Record) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.GraphDefs_ListView)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
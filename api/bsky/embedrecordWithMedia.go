@@ -27,6 +27,7 @@ type EmbedRecordWithMedia struct {
 type EmbedRecordWithMedia_Media struct {
 	EmbedImages   *EmbedImages
 	EmbedExternal *EmbedExternal
+	Unknown       *util.UnknownUnionVariant
 }
 
 func (t *EmbedRecordWithMedia_Media) MarshalJSON() ([]byte, error) {
@@ -38,6 +39,9 @@ func (t *EmbedRecordWithMedia_Media) MarshalJSON() ([]byte, error) {
 		t.EmbedExternal.LexiconTypeID = "app.bsky.embed.external"
 		return json.Marshal(t.EmbedExternal)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *EmbedRecordWithMedia_Media) UnmarshalJSON(b []byte) error {
@@ -55,6 +59,7 @@ func (t *EmbedRecordWithMedia_Media) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.EmbedExternal)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -71,6 +76,9 @@ func (t *EmbedRecordWithMedia_Media) MarshalCBOR(w io.Writer) error {
 	if t.EmbedExternal != nil {
 		return t.EmbedExternal.MarshalCBOR(w)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalCBOR(w)
+	}
 	return fmt.Errorf("cannot cbor marshal empty enum")
 }
 func (t *EmbedRecordWithMedia_Media) UnmarshalCBOR(r io.Reader) error {
@@ -88,6 +96,7 @@ func (t *EmbedRecordWithMedia_Media) UnmarshalCBOR(r io.Reader) error {
 		return t.EmbedExternal.UnmarshalCBOR(bytes.NewReader(b))
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, CBOR: b}
 		return nil
 	}
 }
@@ -104,6 +113,7 @@ type EmbedRecordWithMedia_View struct {
 type EmbedRecordWithMedia_View_Media struct {
 	EmbedImages_View   *EmbedImages_View
 	EmbedExternal_View *EmbedExternal_View
+	Unknown            *util.UnknownUnionVariant
 }
 
 func (t *EmbedRecordWithMedia_View_Media) MarshalJSON() ([]byte, error) {
@@ -115,6 +125,9 @@ func (t *EmbedRecordWithMedia_View_Media) MarshalJSON() ([]byte, error) {
 		t.EmbedExternal_View.LexiconTypeID = "app.bsky.embed.external#view"
 		return json.Marshal(t.EmbedExternal_View)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *EmbedRecordWithMedia_View_Media) UnmarshalJSON(b []byte) error {
@@ -132,6 +145,7 @@ func (t *EmbedRecordWithMedia_View_Media) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.EmbedExternal_View)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
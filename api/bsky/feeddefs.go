@@ -37,6 +37,7 @@ type FeedDefs_FeedViewPost struct {
 
 type FeedDefs_FeedViewPost_Reason struct {
 	FeedDefs_ReasonRepost *FeedDefs_ReasonRepost
+	Unknown               *util.UnknownUnionVariant
 }
 
 func (t *FeedDefs_FeedViewPost_Reason) MarshalJSON() ([]byte, error) {
@@ -44,6 +45,9 @@ func (t *FeedDefs_FeedViewPost_Reason) MarshalJSON() ([]byte, error) {
 		t.FeedDefs_ReasonRepost.LexiconTypeID = "app.bsky.feed.defs#reasonRepost"
 		return json.Marshal(t.FeedDefs_ReasonRepost)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *FeedDefs_FeedViewPost_Reason) UnmarshalJSON(b []byte) error {
@@ -58,6 +62,7 @@ func (t *FeedDefs_FeedViewPost_Reason) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.FeedDefs_ReasonRepost)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -118,6 +123,7 @@ type FeedDefs_PostView_Embed struct {
 	EmbedExternal_View        *EmbedExternal_View
 	EmbedRecord_View          *EmbedRecord_View
 	EmbedRecordWithMedia_View *EmbedRecordWithMedia_View
+	Unknown                   *util.UnknownUnionVariant
 }
 
 func (t *FeedDefs_PostView_Embed) MarshalJSON() ([]byte, error) {
@@ -137,6 +143,9 @@ func (t *FeedDefs_PostView_Embed) MarshalJSON() ([]byte, error) {
 		t.EmbedRecordWithMedia_View.LexiconTypeID = "app.bsky.embed.recordWithMedia#view"
 		return json.Marshal(t.EmbedRecordWithMedia_View)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *FeedDefs_PostView_Embed) UnmarshalJSON(b []byte) error {
@@ -160,6 +169,7 @@ func (t *FeedDefs_PostView_Embed) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.EmbedRecordWithMedia_View)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -183,6 +193,7 @@ type FeedDefs_ReplyRef_Parent struct {
 	FeedDefs_PostView     *FeedDefs_PostView
 	FeedDefs_NotFoundPost *FeedDefs_NotFoundPost
 	FeedDefs_BlockedPost  *FeedDefs_BlockedPost
+	Unknown               *util.UnknownUnionVariant
 }
 
 func (t *FeedDefs_ReplyRef_Parent) MarshalJSON() ([]byte, error) {
@@ -198,6 +209,9 @@ func (t *FeedDefs_ReplyRef_Parent) MarshalJSON() ([]byte, error) {
 		t.FeedDefs_BlockedPost.LexiconTypeID = "app.bsky.feed.defs#blockedPost"
 		return json.Marshal(t.FeedDefs_BlockedPost)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *FeedDefs_ReplyRef_Parent) UnmarshalJSON(b []byte) error {
@@ -218,6 +232,7 @@ func (t *FeedDefs_ReplyRef_Parent) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.FeedDefs_BlockedPost)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -226,6 +241,7 @@ type FeedDefs_ReplyRef_Root struct {
 	FeedDefs_PostView     *FeedDefs_PostView
 	FeedDefs_NotFoundPost *FeedDefs_NotFoundPost
 	FeedDefs_BlockedPost  *FeedDefs_BlockedPost
+	Unknown               *util.UnknownUnionVariant
 }
 
 func (t *FeedDefs_ReplyRef_Root) MarshalJSON() ([]byte, error) {
@@ -241,6 +257,9 @@ func (t *FeedDefs_ReplyRef_Root) MarshalJSON() ([]byte, error) {
 		t.FeedDefs_BlockedPost.LexiconTypeID = "app.bsky.feed.defs#blockedPost"
 		return json.Marshal(t.FeedDefs_BlockedPost)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *FeedDefs_ReplyRef_Root) UnmarshalJSON(b []byte) error {
@@ -261,6 +280,7 @@ func (t *FeedDefs_ReplyRef_Root) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.FeedDefs_BlockedPost)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -273,6 +293,7 @@ type FeedDefs_SkeletonFeedPost struct {
 
 type FeedDefs_SkeletonFeedPost_Reason struct {
 	FeedDefs_SkeletonReasonRepost *FeedDefs_SkeletonReasonRepost
+	Unknown                       *util.UnknownUnionVariant
 }
 
 func (t *FeedDefs_SkeletonFeedPost_Reason) MarshalJSON() ([]byte, error) {
@@ -280,6 +301,9 @@ func (t *FeedDefs_SkeletonFeedPost_Reason) MarshalJSON() ([]byte, error) {
 		t.FeedDefs_SkeletonReasonRepost.LexiconTypeID = "app.bsky.feed.defs#skeletonReasonRepost"
 		return json.Marshal(t.FeedDefs_SkeletonReasonRepost)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *FeedDefs_SkeletonFeedPost_Reason) UnmarshalJSON(b []byte) error {
@@ -294,6 +318,7 @@ func (t *FeedDefs_SkeletonFeedPost_Reason) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.FeedDefs_SkeletonReasonRepost)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -321,6 +346,7 @@ type FeedDefs_ThreadViewPost_Parent struct {
 	FeedDefs_ThreadViewPost *FeedDefs_ThreadViewPost
 	FeedDefs_NotFoundPost   *FeedDefs_NotFoundPost
 	FeedDefs_BlockedPost    *FeedDefs_BlockedPost
+	Unknown                 *util.UnknownUnionVariant
 }
 
 func (t *FeedDefs_ThreadViewPost_Parent) MarshalJSON() ([]byte, error) {
@@ -336,6 +362,9 @@ func (t *FeedDefs_ThreadViewPost_Parent) MarshalJSON() ([]byte, error) {
 		t.FeedDefs_BlockedPost.LexiconTypeID = "app.bsky.feed.defs#blockedPost"
 		return json.Marshal(t.FeedDefs_BlockedPost)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *FeedDefs_ThreadViewPost_Parent) UnmarshalJSON(b []byte) error {
@@ -356,6 +385,7 @@ func (t *FeedDefs_ThreadViewPost_Parent) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.FeedDefs_BlockedPost)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -364,6 +394,7 @@ type FeedDefs_ThreadViewPost_Replies_Elem struct {
 	FeedDefs_ThreadViewPost *FeedDefs_ThreadViewPost
 	FeedDefs_NotFoundPost   *FeedDefs_NotFoundPost
 	FeedDefs_BlockedPost    *FeedDefs_BlockedPost
+	Unknown                 *util.UnknownUnionVariant
 }
 
 func (t *FeedDefs_ThreadViewPost_Replies_Elem) MarshalJSON() ([]byte, error) {
@@ -379,6 +410,9 @@ func (t *FeedDefs_ThreadViewPost_Replies_Elem) MarshalJSON() ([]byte, error) {
 		t.FeedDefs_BlockedPost.LexiconTypeID = "app.bsky.feed.defs#blockedPost"
 		return json.Marshal(t.FeedDefs_BlockedPost)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *FeedDefs_ThreadViewPost_Replies_Elem) UnmarshalJSON(b []byte) error {
@@ -399,6 +433,7 @@ func (t *FeedDefs_ThreadViewPost_Replies_Elem) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.FeedDefs_BlockedPost)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -32,6 +32,7 @@ type FeedGenerator struct {
 
 type FeedGenerator_Labels struct {
 	LabelDefs_SelfLabels *comatprototypes.LabelDefs_SelfLabels
+	Unknown              *util.UnknownUnionVariant
 }
 
 func (t *FeedGenerator_Labels) MarshalJSON() ([]byte, error) {
@@ -39,6 +40,9 @@ func (t *FeedGenerator_Labels) MarshalJSON() ([]byte, error) {
 		t.LabelDefs_SelfLabels.LexiconTypeID = "com.atproto.label.defs#selfLabels"
 		return json.Marshal(t.LabelDefs_SelfLabels)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *FeedGenerator_Labels) UnmarshalJSON(b []byte) error {
@@ -53,6 +57,7 @@ func (t *FeedGenerator_Labels) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.LabelDefs_SelfLabels)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -66,6 +71,9 @@ func (t *FeedGenerator_Labels) MarshalCBOR(w io.Writer) error {
 	if t.LabelDefs_SelfLabels != nil {
 		return t.LabelDefs_SelfLabels.MarshalCBOR(w)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalCBOR(w)
+	}
 	return fmt.Errorf("cannot cbor marshal empty enum")
 }
 func (t *FeedGenerator_Labels) UnmarshalCBOR(r io.Reader) error {
@@ -80,6 +88,7 @@ func (t *FeedGenerator_Labels) UnmarshalCBOR(r io.Reader) error {
 		return t.LabelDefs_SelfLabels.UnmarshalCBOR(bytes.NewReader(b))
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, CBOR: b}
 		return nil
 	}
 }
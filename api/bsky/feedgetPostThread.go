@@ -22,6 +22,7 @@ type FeedGetPostThread_Output_Thread struct {
 	FeedDefs_ThreadViewPost *FeedDefs_ThreadViewPost
 	FeedDefs_NotFoundPost   *FeedDefs_NotFoundPost
 	FeedDefs_BlockedPost    *FeedDefs_BlockedPost
+	Unknown                 *util.UnknownUnionVariant
 }
 
 func (t *FeedGetPostThread_Output_Thread) MarshalJSON() ([]byte, error) {
@@ -37,6 +38,9 @@ func (t *FeedGetPostThread_Output_Thread) MarshalJSON() ([]byte, error) {
 		t.FeedDefs_BlockedPost.LexiconTypeID = "app.bsky.feed.defs#blockedPost"
 		return json.Marshal(t.FeedDefs_BlockedPost)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *FeedGetPostThread_Output_Thread) UnmarshalJSON(b []byte) error {
@@ -57,6 +61,7 @@ func (t *FeedGetPostThread_Output_Thread) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.FeedDefs_BlockedPost)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -23,7 +23,7 @@ type FeedPost struct {
 	LexiconTypeID string          `json:"$type,const=app.bsky.feed.post" cborgen:"$type,const=app.bsky.feed.post"`
 	CreatedAt     string          `json:"createdAt" cborgen:"createdAt"`
 	Embed         *FeedPost_Embed `json:"embed,omitempty" cborgen:"embed,omitempty"`
-	// entities: Deprecated: replaced by app.bsky.richtext.facet.
+	// Deprecated: replaced by app.bsky.richtext.facet.
 	Entities []*FeedPost_Entity `json:"entities,omitempty" cborgen:"entities,omitempty"`
 	Facets   []*RichtextFacet   `json:"facets,omitempty" cborgen:"facets,omitempty"`
 	Labels   *FeedPost_Labels   `json:"labels,omitempty" cborgen:"labels,omitempty"`
@@ -39,6 +39,7 @@ type FeedPost_Embed struct {
 	EmbedExternal        *EmbedExternal
 	EmbedRecord          *EmbedRecord
 	EmbedRecordWithMedia *EmbedRecordWithMedia
+	Unknown              *util.UnknownUnionVariant
 }
 
 func (t *FeedPost_Embed) MarshalJSON() ([]byte, error) {
@@ -58,6 +59,9 @@ func (t *FeedPost_Embed) MarshalJSON() ([]byte, error) {
 		t.EmbedRecordWithMedia.LexiconTypeID = "app.bsky.embed.recordWithMedia"
 		return json.Marshal(t.EmbedRecordWithMedia)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *FeedPost_Embed) UnmarshalJSON(b []byte) error {
@@ -81,6 +85,7 @@ func (t *FeedPost_Embed) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.EmbedRecordWithMedia)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -103,6 +108,9 @@ func (t *FeedPost_Embed) MarshalCBOR(w io.Writer) error {
 	if t.EmbedRecordWithMedia != nil {
 		return t.EmbedRecordWithMedia.MarshalCBOR(w)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalCBOR(w)
+	}
 	return fmt.Errorf("cannot cbor marshal empty enum")
 }
 func (t *FeedPost_Embed) UnmarshalCBOR(r io.Reader) error {
@@ -126,6 +134,7 @@ func (t *FeedPost_Embed) UnmarshalCBOR(r io.Reader) error {
 		return t.EmbedRecordWithMedia.UnmarshalCBOR(bytes.NewReader(b))
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, CBOR: b}
 		return nil
 	}
 }
@@ -142,6 +151,7 @@ type FeedPost_Entity struct {
 
 type FeedPost_Labels struct {
 	LabelDefs_SelfLabels *comatprototypes.LabelDefs_SelfLabels
+	Unknown              *util.UnknownUnionVariant
 }
 
 func (t *FeedPost_Labels) MarshalJSON() ([]byte, error) {
@@ -149,6 +159,9 @@ func (t *FeedPost_Labels) MarshalJSON() ([]byte, error) {
 		t.LabelDefs_SelfLabels.LexiconTypeID = "com.atproto.label.defs#selfLabels"
 		return json.Marshal(t.LabelDefs_SelfLabels)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *FeedPost_Labels) UnmarshalJSON(b []byte) error {
@@ -163,6 +176,7 @@ func (t *FeedPost_Labels) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.LabelDefs_SelfLabels)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -176,6 +190,9 @@ func (t *FeedPost_Labels) MarshalCBOR(w io.Writer) error {
 	if t.LabelDefs_SelfLabels != nil {
 		return t.LabelDefs_SelfLabels.MarshalCBOR(w)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalCBOR(w)
+	}
 	return fmt.Errorf("cannot cbor marshal empty enum")
 }
 func (t *FeedPost_Labels) UnmarshalCBOR(r io.Reader) error {
@@ -190,6 +207,7 @@ func (t *FeedPost_Labels) UnmarshalCBOR(r io.Reader) error {
 		return t.LabelDefs_SelfLabels.UnmarshalCBOR(bytes.NewReader(b))
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, CBOR: b}
 		return nil
 	}
 }
@@ -32,6 +32,7 @@ type RichtextFacet_Features_Elem struct {
 	RichtextFacet_Mention *RichtextFacet_Mention
 	RichtextFacet_Link    *RichtextFacet_Link
 	RichtextFacet_Tag     *RichtextFacet_Tag
+	Unknown               *util.UnknownUnionVariant
 }
 
 func (t *RichtextFacet_Features_Elem) MarshalJSON() ([]byte, error) {
@@ -47,6 +48,9 @@ func (t *RichtextFacet_Features_Elem) MarshalJSON() ([]byte, error) {
 		t.RichtextFacet_Tag.LexiconTypeID = "app.bsky.richtext.facet#tag"
 		return json.Marshal(t.RichtextFacet_Tag)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalJSON()
+	}
 	return nil, fmt.Errorf("cannot marshal empty enum")
 }
 func (t *RichtextFacet_Features_Elem) UnmarshalJSON(b []byte) error {
@@ -67,6 +71,7 @@ func (t *RichtextFacet_Features_Elem) UnmarshalJSON(b []byte) error {
 		return json.Unmarshal(b, t.RichtextFacet_Tag)
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 		return nil
 	}
 }
@@ -86,6 +91,9 @@ func (t *RichtextFacet_Features_Elem) MarshalCBOR(w io.Writer) error {
 	if t.RichtextFacet_Tag != nil {
 		return t.RichtextFacet_Tag.MarshalCBOR(w)
 	}
+	if t.Unknown != nil {
+		return t.Unknown.MarshalCBOR(w)
+	}
 	return fmt.Errorf("cannot cbor marshal empty enum")
 }
 func (t *RichtextFacet_Features_Elem) UnmarshalCBOR(r io.Reader) error {
@@ -106,6 +114,7 @@ func (t *RichtextFacet_Features_Elem) UnmarshalCBOR(r io.Reader) error {
 		return t.RichtextFacet_Tag.UnmarshalCBOR(bytes.NewReader(b))
 
 	default:
+		t.Unknown = &util.UnknownUnionVariant{Type: typ, CBOR: b}
 		return nil
 	}
 }
@@ -0,0 +1,166 @@
+// Package awskms implements an atproto [crypto.Signer] backed by an AWS KMS
+// asymmetric signing key, so the private key never leaves KMS.
+//
+// The key must have key spec ECC_NIST_P256 or ECC_SECG_P256K1, and key usage
+// SIGN_VERIFY. Requests are made directly against the KMS JSON API, signed
+// with AWS Signature Version 4.
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/util/awssig"
+)
+
+// Signer signs with a single KMS key
+type Signer struct {
+	// KeyID is the key's ID, ARN, or alias ("alias/...")
+	KeyID string
+	// Endpoint defaults to the regional KMS endpoint
+	Endpoint     string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	Client *http.Client
+
+	lk  sync.Mutex
+	pub crypto.PublicKey
+}
+
+var _ crypto.Signer = (*Signer)(nil)
+
+func NewSigner(keyID, region, accessKey, secretKey string) *Signer {
+	return &Signer{
+		KeyID:     keyID,
+		Endpoint:  "https://kms." + region + ".amazonaws.com",
+		Region:    region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// PublicKey fetches the key's public half from KMS, once
+func (s *Signer) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.pub != nil {
+		return s.pub, nil
+	}
+
+	var out struct {
+		PublicKey string
+		KeyUsage  string
+	}
+	if err := s.call(ctx, "GetPublicKey", map[string]string{"KeyId": s.KeyID}, &out); err != nil {
+		return nil, err
+	}
+	if out.KeyUsage != "SIGN_VERIFY" {
+		return nil, fmt.Errorf("kms key %s has usage %s, not SIGN_VERIFY", s.KeyID, out.KeyUsage)
+	}
+	der, err := base64.StdEncoding.DecodeString(out.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("decoding kms public key: %w", err)
+	}
+	pub, err := crypto.ParsePublicDERBytes(der)
+	if err != nil {
+		return nil, err
+	}
+	s.pub = pub
+	return pub, nil
+}
+
+// SignDigest has KMS sign a SHA-256 digest
+func (s *Signer) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	var out struct {
+		Signature string
+	}
+	if err := s.call(ctx, "Sign", map[string]string{
+		"KeyId":            s.KeyID,
+		"Message":          base64.StdEncoding.EncodeToString(digest),
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": "ECDSA_SHA_256",
+	}, &out); err != nil {
+		return nil, err
+	}
+	der, err := base64.StdEncoding.DecodeString(out.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("decoding kms signature: %w", err)
+	}
+
+	// KMS returns ASN.1 DER signatures
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("parsing kms signature: %w", err)
+	}
+	if sig.R.BitLen() > 256 || sig.S.BitLen() > 256 {
+		return nil, fmt.Errorf("kms signature out of range")
+	}
+	compact := make([]byte, 64)
+	sig.R.FillBytes(compact[:32])
+	sig.S.FillBytes(compact[32:])
+	return compact, nil
+}
+
+type kmsError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+func (s *Signer) call(ctx context.Context, action string, in any, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(s.Endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	s.signRequest(req, body, time.Now())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("kms %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var kerr kmsError
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(b, &kerr) == nil && kerr.Type != "" {
+			return fmt.Errorf("kms %s: %s: %s", action, kerr.Type, kerr.Message)
+		}
+		return fmt.Errorf("kms %s: %s", action, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// signRequest adds AWS Signature Version 4 headers to req, covering the host
+// and every header already set
+func (s *Signer) signRequest(req *http.Request, body []byte, t time.Time) {
+	creds := awssig.Credentials{
+		AccessKeyID:     s.AccessKey,
+		SecretAccessKey: s.SecretKey,
+		SessionToken:    s.SessionToken,
+	}
+	awssig.Sign(req, creds, s.Region, "kms", awssig.PayloadHash(body), t)
+}
@@ -0,0 +1,92 @@
+package awskms
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKMS answers GetPublicKey and Sign for a single P-256 key
+func fakeKMS(t *testing.T, key *ecdsa.PrivateKey) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(kmsError{Type: "MissingAuthenticationToken"})
+			return
+		}
+
+		var in map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Fatal(err)
+		}
+		if in["KeyId"] != "alias/pds" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(kmsError{Type: "NotFoundException", Message: "no such key"})
+			return
+		}
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"PublicKey": base64.StdEncoding.EncodeToString(der),
+				"KeyUsage":  "SIGN_VERIFY",
+			})
+		case "TrentService.Sign":
+			digest, err := base64.StdEncoding.DecodeString(in["Message"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig, err := ecdsa.SignASN1(rand.Reader, key, digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			json.NewEncoder(w).Encode(map[string]string{"Signature": base64.StdEncoding.EncodeToString(sig)})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestKMSSigner(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	srv := fakeKMS(t, key)
+	defer srv.Close()
+
+	s := NewSigner("alias/pds", "us-east-1", "AKID", "secret")
+	s.Endpoint = srv.URL
+	sk, err := crypto.NewSignerKey(ctx, s)
+	assert.NoError(err)
+
+	msg := []byte("a repo commit")
+	for i := 0; i < 10; i++ {
+		sig, err := sk.HashAndSign(msg)
+		assert.NoError(err)
+		pub, err := sk.PublicKey()
+		assert.NoError(err)
+		assert.NoError(pub.HashAndVerify(msg, sig))
+	}
+
+	missing := NewSigner("alias/other", "us-east-1", "AKID", "secret")
+	missing.Endpoint = srv.URL
+	_, err = crypto.NewSignerKey(ctx, missing)
+	assert.ErrorContains(err, "NotFoundException")
+}
@@ -8,4 +8,6 @@
 //   - K-256/secp256r1, internally implemented using https://gitlab.com/yawning/secp256k1-voi
 //
 // "Low-S" signatures are enforced for both key types, both when creating signatures and during verification, as required by the atproto specification.
+//
+// Keys held by an external backend, such as a cloud KMS or an HSM, can implement the [Signer] interface and be wrapped with [NewSignerKey] to get a regular [PrivateKey]. Implementations live in the awskms and hsm sub-packages.
 package crypto
@@ -0,0 +1,10 @@
+// Package hsm implements an atproto [crypto.Signer] backed by a hardware
+// security module, or anything else with a PKCS#11 interface (such as
+// SoftHSM or a cloud HSM client library).
+//
+// The key pair should be generated on the token as a P-256 or K-256 EC key,
+// with the same CKA_LABEL on the private and public key objects.
+//
+// The PKCS#11 bindings use cgo, so this package is empty when built without
+// it.
+package hsm
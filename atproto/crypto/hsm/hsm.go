@@ -0,0 +1,187 @@
+//go:build cgo
+
+package hsm
+
+import (
+	"context"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/miekg/pkcs11"
+)
+
+// Signer signs with an EC key pair on a PKCS#11 token. PKCS#11 sessions
+// can't be used concurrently, so signing is serialized.
+type Signer struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	priv    pkcs11.ObjectHandle
+	pub     crypto.PublicKey
+
+	lk sync.Mutex
+}
+
+var _ crypto.Signer = (*Signer)(nil)
+
+// Config says which key to use, and how to get at it
+type Config struct {
+	// Module is the path to the vendor's PKCS#11 shared library
+	Module string
+	// TokenLabel picks the token (slot) holding the key
+	TokenLabel string
+	// KeyLabel is the CKA_LABEL of both the private and public key objects
+	KeyLabel string
+	// Pin logs in as the token's user
+	Pin string
+}
+
+// Open loads the PKCS#11 module, logs in to the token and finds the key
+// pair. Close the signer when done with it.
+func Open(cfg Config) (*Signer, error) {
+	p := pkcs11.New(cfg.Module)
+	if p == nil {
+		return nil, fmt.Errorf("failed to load pkcs11 module %s", cfg.Module)
+	}
+	if err := p.Initialize(); err != nil && !isError(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		p.Destroy()
+		return nil, fmt.Errorf("initializing pkcs11 module: %w", err)
+	}
+
+	s := &Signer{ctx: p}
+	if err := s.open(cfg); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Signer) open(cfg Config) error {
+	slots, err := s.ctx.GetSlotList(true)
+	if err != nil {
+		return fmt.Errorf("listing pkcs11 slots: %w", err)
+	}
+	slot, found := uint(0), false
+	for _, sl := range slots {
+		ti, err := s.ctx.GetTokenInfo(sl)
+		if err != nil {
+			return fmt.Errorf("getting pkcs11 token info: %w", err)
+		}
+		if strings.TrimSpace(ti.Label) == cfg.TokenLabel {
+			slot, found = sl, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("no pkcs11 token labelled %q", cfg.TokenLabel)
+	}
+
+	sess, err := s.ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		return fmt.Errorf("opening pkcs11 session: %w", err)
+	}
+	s.session = sess
+	if err := s.ctx.Login(sess, pkcs11.CKU_USER, cfg.Pin); err != nil && !isError(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		return fmt.Errorf("logging in to pkcs11 token: %w", err)
+	}
+
+	s.priv, err = s.findObject(pkcs11.CKO_PRIVATE_KEY, cfg.KeyLabel)
+	if err != nil {
+		return err
+	}
+	pubObj, err := s.findObject(pkcs11.CKO_PUBLIC_KEY, cfg.KeyLabel)
+	if err != nil {
+		return err
+	}
+
+	attrs, err := s.ctx.GetAttributeValue(sess, pubObj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("reading pkcs11 public key: %w", err)
+	}
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(attrs[0].Value, &curve); err != nil {
+		return fmt.Errorf("parsing pkcs11 key curve: %w", err)
+	}
+	// CKA_EC_POINT should be a DER octet string, but some tokens return the
+	// bare point
+	point := attrs[1].Value
+	var wrapped []byte
+	if rest, err := asn1.Unmarshal(point, &wrapped); err == nil && len(rest) == 0 {
+		point = wrapped
+	}
+	s.pub, err = crypto.ParsePublicUncompressedBytesCurve(curve, point)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Signer) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	if err := s.ctx.FindObjectsInit(s.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}); err != nil {
+		return 0, fmt.Errorf("finding pkcs11 key: %w", err)
+	}
+	objs, _, err := s.ctx.FindObjects(s.session, 2)
+	s.ctx.FindObjectsFinal(s.session)
+	if err != nil {
+		return 0, fmt.Errorf("finding pkcs11 key: %w", err)
+	}
+	switch len(objs) {
+	case 0:
+		return 0, fmt.Errorf("no pkcs11 EC key labelled %q", label)
+	case 1:
+		return objs[0], nil
+	default:
+		return 0, fmt.Errorf("more than one pkcs11 EC key labelled %q", label)
+	}
+}
+
+// PublicKey returns the public half of the token's key pair
+func (s *Signer) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	return s.pub, nil
+}
+
+// SignDigest has the token sign a SHA-256 digest. CKM_ECDSA signatures are
+// already in the compact (r || s) encoding.
+func (s *Signer) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if err := s.ctx.SignInit(s.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, s.priv); err != nil {
+		return nil, fmt.Errorf("pkcs11 sign init: %w", err)
+	}
+	sig, err := s.ctx.Sign(s.session, digest)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11 sign: %w", err)
+	}
+	return sig, nil
+}
+
+// Close logs out and unloads the module
+func (s *Signer) Close() error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	if s.session != 0 {
+		s.ctx.Logout(s.session)
+		s.ctx.CloseSession(s.session)
+		s.session = 0
+	}
+	err := s.ctx.Finalize()
+	s.ctx.Destroy()
+	return err
+}
+
+func isError(err error, code uint) bool {
+	var perr pkcs11.Error
+	return errors.As(err, &perr) && uint(perr) == code
+}
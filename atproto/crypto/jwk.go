@@ -0,0 +1,156 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// JSON Web Key representation (RFC 7517) of a P-256 or K-256 key. Private
+// keys have the "d" field set.
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+	D       string `json:"d,omitempty"`
+
+	// Optional fields, not set or checked by this package, but passed through
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+}
+
+// The JWK "crv" names for the supported curves. K-256 is as specified in RFC 8812.
+const (
+	jwkCurveP256 = "P-256"
+	jwkCurveK256 = "secp256k1"
+)
+
+// Outputs the JWK representation of a public key.
+func PublicKeyJWK(pub PublicKey) (*JWK, error) {
+	var crv, alg string
+	switch pub.(type) {
+	case *PublicKeyP256:
+		crv, alg = jwkCurveP256, "ES256"
+	case *PublicKeyK256:
+		crv, alg = jwkCurveK256, "ES256K"
+	default:
+		return nil, fmt.Errorf("unsupported key type for JWK: %T", pub)
+	}
+	// uncompressed point: 0x04 || X || Y
+	pt := pub.UncompressedBytes()
+	if len(pt) != 65 {
+		return nil, fmt.Errorf("unexpected public key length: %d", len(pt))
+	}
+	return &JWK{
+		KeyType:   "EC",
+		Curve:     crv,
+		X:         base64.RawURLEncoding.EncodeToString(pt[1:33]),
+		Y:         base64.RawURLEncoding.EncodeToString(pt[33:]),
+		Algorithm: alg,
+	}, nil
+}
+
+// Outputs the JWK representation of a private key, including the public key fields.
+func PrivateKeyJWK(priv PrivateKeyExportable) (*JWK, error) {
+	pub, err := priv.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := PublicKeyJWK(pub)
+	if err != nil {
+		return nil, err
+	}
+	jwk.D = base64.RawURLEncoding.EncodeToString(priv.Bytes())
+	return jwk, nil
+}
+
+// Loads a [PublicKey] from a JWK. The private key field, if present, is ignored.
+func ParsePublicJWK(jwk *JWK) (PublicKey, error) {
+	if jwk.KeyType != "EC" {
+		return nil, fmt.Errorf("unsupported JWK key type: %q", jwk.KeyType)
+	}
+	x, err := jwkCoordinate(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK x: %w", err)
+	}
+	y, err := jwkCoordinate(jwk.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK y: %w", err)
+	}
+	pt := append(append([]byte{0x04}, x...), y...)
+
+	switch jwk.Curve {
+	case jwkCurveP256:
+		return ParsePublicUncompressedBytesCurve(oidCurveP256, pt)
+	case jwkCurveK256:
+		return ParsePublicUncompressedBytesCurve(oidCurveK256, pt)
+	default:
+		return nil, fmt.Errorf("unsupported JWK curve: %q", jwk.Curve)
+	}
+}
+
+// Loads a private key from a JWK, checking that the public key fields match.
+func ParsePrivateJWK(jwk *JWK) (PrivateKeyExportable, error) {
+	pub, err := ParsePublicJWK(jwk)
+	if err != nil {
+		return nil, err
+	}
+	if jwk.D == "" {
+		return nil, fmt.Errorf("JWK has no private key")
+	}
+	d, err := jwkCoordinate(jwk.D)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK d: %w", err)
+	}
+
+	var priv PrivateKeyExportable
+	switch pub.(type) {
+	case *PublicKeyP256:
+		priv, err = ParsePrivateBytesP256(d)
+	case *PublicKeyK256:
+		priv, err = ParsePrivateBytesK256(d)
+	}
+	if err != nil {
+		return nil, err
+	}
+	derived, err := priv.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	if !derived.Equal(pub) {
+		return nil, fmt.Errorf("JWK public key does not match private key")
+	}
+	return priv, nil
+}
+
+// Loads a [PublicKey] from JSON encoded JWK bytes.
+func ParsePublicJWKBytes(data []byte) (PublicKey, error) {
+	var jwk JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, fmt.Errorf("invalid JWK: %w", err)
+	}
+	return ParsePublicJWK(&jwk)
+}
+
+// Loads a private key from JSON encoded JWK bytes.
+func ParsePrivateJWKBytes(data []byte) (PrivateKeyExportable, error) {
+	var jwk JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, fmt.Errorf("invalid JWK: %w", err)
+	}
+	return ParsePrivateJWK(&jwk)
+}
+
+// Both supported curves use 32-byte coordinates and scalars.
+func jwkCoordinate(s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("expected 32 bytes, got %d", len(b))
+	}
+	return b, nil
+}
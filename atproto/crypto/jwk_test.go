@@ -0,0 +1,64 @@
+package crypto
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWKRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	privP256, err := GeneratePrivateKeyP256()
+	assert.NoError(err)
+	privK256, err := GeneratePrivateKeyK256()
+	assert.NoError(err)
+
+	for _, priv := range []PrivateKeyExportable{privP256, privK256} {
+		jwk, err := PrivateKeyJWK(priv)
+		assert.NoError(err)
+		b, err := json.Marshal(jwk)
+		assert.NoError(err)
+		parsed, err := ParsePrivateJWKBytes(b)
+		assert.NoError(err)
+		assert.True(priv.Equal(parsed))
+
+		pub, err := priv.PublicKey()
+		assert.NoError(err)
+		pubJWK, err := PublicKeyJWK(pub)
+		assert.NoError(err)
+		assert.Empty(pubJWK.D)
+		parsedPub, err := ParsePublicJWK(pubJWK)
+		assert.NoError(err)
+		assert.True(pub.Equal(parsedPub))
+
+		_, err = ParsePrivateJWK(pubJWK)
+		assert.Error(err)
+	}
+
+	// a private key with someone else's public key is rejected
+	mismatched, err := PrivateKeyJWK(privP256)
+	assert.NoError(err)
+	other, err := GeneratePrivateKeyP256()
+	assert.NoError(err)
+	otherJWK, err := PrivateKeyJWK(other)
+	assert.NoError(err)
+	mismatched.X, mismatched.Y = otherJWK.X, otherJWK.Y
+	_, err = ParsePrivateJWK(mismatched)
+	assert.Error(err)
+}
+
+func TestJWKFixture(t *testing.T) {
+	assert := assert.New(t)
+
+	// P-256 example key from RFC 7517, appendix A.2
+	fixture := []byte(`{"kty":"EC","crv":"P-256","x":"MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4","y":"4Etl6SRW2YiLUrN5vfvVHuhp7x8PxltmWWlbbM4IFyM","d":"870MB6gfuTJ4HtUnUvYMyJpr5eUZNP4Bk43bVdj3eAE","use":"enc","kid":"1"}`)
+	priv, err := ParsePrivateJWKBytes(fixture)
+	assert.NoError(err)
+	jwk, err := PrivateKeyJWK(priv)
+	assert.NoError(err)
+	assert.Equal("MKBCTNIcKUSDii11ySs3526iDZ8AiTo7Tu6KPAqv7D4", jwk.X)
+	assert.Equal("870MB6gfuTJ4HtUnUvYMyJpr5eUZNP4Bk43bVdj3eAE", jwk.D)
+	assert.Equal("ES256", jwk.Algorithm)
+}
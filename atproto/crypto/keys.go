@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"encoding/asn1"
 	"errors"
 	"fmt"
 	"strings"
@@ -131,3 +132,48 @@ func ParsePublicDIDKey(didKey string) (PublicKey, error) {
 	mb := strings.TrimPrefix(didKey, "did:key:")
 	return ParsePublicMultibase(mb)
 }
+
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidCurveP256      = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidCurveK256      = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+// Loads a [PublicKey] from an ASN.1 DER encoded X.509 SubjectPublicKeyInfo, as returned by most KMS and HSM APIs.
+//
+// The curve is indicated in the encoding. Golang's x509 package doesn't support K-256, so the structure is parsed directly. See [PublicKeyDERBytes] for the reverse.
+func ParsePublicDERBytes(der []byte) (PublicKey, error) {
+	var spki pkixPublicKey
+	rest, err := asn1.Unmarshal(der, &spki)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DER public key: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("invalid DER public key: trailing data")
+	}
+	if !spki.Algorithm.Algorithm.Equal(oidPublicKeyECDSA) {
+		return nil, fmt.Errorf("unsupported DER public key algorithm: %s", spki.Algorithm.Algorithm)
+	}
+	return ParsePublicUncompressedBytesCurve(spki.Algorithm.Parameters, spki.PublicKey.Bytes)
+}
+
+// Loads a [PublicKey] from uncompressed point bytes, with the named curve given as an ASN.1 object identifier.
+func ParsePublicUncompressedBytesCurve(curve asn1.ObjectIdentifier, data []byte) (PublicKey, error) {
+	// careful to not return a typed nil as a non-nil interface on error
+	switch {
+	case curve.Equal(oidCurveP256):
+		pub, err := ParsePublicUncompressedBytesP256(data)
+		if err != nil {
+			return nil, err
+		}
+		return pub, nil
+	case curve.Equal(oidCurveK256):
+		pub, err := ParsePublicUncompressedBytesK256(data)
+		if err != nil {
+			return nil, err
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported atproto key curve: %s", curve)
+	}
+}
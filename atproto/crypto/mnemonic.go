@@ -0,0 +1,93 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Words in a generated mnemonic. 24 words is 256 bits of entropy.
+const MnemonicWords = 24
+
+// Salt for deriving keys from a mnemonic seed. Changing this changes every derived key.
+const mnemonicDerivationSalt = "atproto mnemonic key derivation v1"
+
+// Creates a new random BIP-39 mnemonic phrase (English word list), which can be used with [DerivePrivateKeyP256] or [DerivePrivateKeyK256].
+func GenerateMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(MnemonicWords / 3 * 32)
+	if err != nil {
+		return "", fmt.Errorf("mnemonic generation failed: %w", err)
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// Checks that a mnemonic phrase is made of words from the BIP-39 English word list, with a valid checksum.
+func ValidateMnemonic(mnemonic string) error {
+	if _, err := bip39.EntropyFromMnemonic(normalizeMnemonic(mnemonic)); err != nil {
+		return fmt.Errorf("invalid mnemonic: %w", err)
+	}
+	return nil
+}
+
+// Deterministically derives a [PrivateKeyP256] from a mnemonic phrase, an optional passphrase, and a derivation path.
+//
+// The path separates keys for different purposes derived from the same phrase; for example "plc/rotation/0". Any string can be used, but the same path always gives the same key, and keys for the two curve types never coincide.
+//
+// The mnemonic is turned in to a seed as in BIP-39 (so the passphrase is the BIP-39 "password"), then the seed is expanded with HKDF-SHA256, using the curve and path as context. This is not BIP-32 hierarchical derivation, and keys will not match other wallets.
+func DerivePrivateKeyP256(mnemonic, passphrase, path string) (*PrivateKeyP256, error) {
+	r, err := mnemonicKeyReader(mnemonic, passphrase, "p256", path)
+	if err != nil {
+		return nil, err
+	}
+	// an out of range scalar is astronomically unlikely; if one comes up, keep reading
+	for i := 0; i < 16; i++ {
+		b := make([]byte, 32)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if k, err := ParsePrivateBytesP256(b); err == nil {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to derive a valid P-256 key")
+}
+
+// Deterministically derives a [PrivateKeyK256] from a mnemonic phrase, an optional passphrase, and a derivation path. See [DerivePrivateKeyP256] for details.
+func DerivePrivateKeyK256(mnemonic, passphrase, path string) (*PrivateKeyK256, error) {
+	r, err := mnemonicKeyReader(mnemonic, passphrase, "k256", path)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < 16; i++ {
+		b := make([]byte, 32)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if k, err := ParsePrivateBytesK256(b); err == nil {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to derive a valid K-256 key")
+}
+
+func mnemonicKeyReader(mnemonic, passphrase, curve, path string) (io.Reader, error) {
+	if path == "" {
+		return nil, fmt.Errorf("key derivation path is required")
+	}
+	seed, err := bip39.NewSeedWithErrorChecking(normalizeMnemonic(mnemonic), norm.NFKD.String(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("invalid mnemonic: %w", err)
+	}
+	info := "atproto:" + curve + ":" + path
+	return hkdf.New(sha256.New, seed, []byte(mnemonicDerivationSalt), []byte(info)), nil
+}
+
+// Lower-cases and collapses whitespace, since phrases are often written down and typed back in by hand.
+func normalizeMnemonic(mnemonic string) string {
+	return strings.Join(strings.Fields(strings.ToLower(norm.NFKD.String(mnemonic))), " ")
+}
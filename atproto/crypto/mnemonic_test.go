@@ -0,0 +1,62 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMnemonicDerivation(t *testing.T) {
+	assert := assert.New(t)
+
+	m, err := GenerateMnemonic()
+	assert.NoError(err)
+	assert.Equal(MnemonicWords, len(strings.Fields(m)))
+	assert.NoError(ValidateMnemonic(m))
+
+	// hand-typed phrases are normalized
+	retyped := "  " + strings.ToUpper(strings.ReplaceAll(m, " ", "  \n")) + " "
+	assert.NoError(ValidateMnemonic(retyped))
+
+	k1, err := DerivePrivateKeyK256(m, "", "plc/rotation/0")
+	assert.NoError(err)
+	k2, err := DerivePrivateKeyK256(retyped, "", "plc/rotation/0")
+	assert.NoError(err)
+	assert.True(k1.Equal(k2))
+
+	other, err := DerivePrivateKeyK256(m, "", "plc/rotation/1")
+	assert.NoError(err)
+	assert.False(k1.Equal(other))
+
+	withPass, err := DerivePrivateKeyK256(m, "hunter2", "plc/rotation/0")
+	assert.NoError(err)
+	assert.False(k1.Equal(withPass))
+
+	_, err = DerivePrivateKeyK256(m, "", "")
+	assert.Error(err)
+
+	// right words, bad checksum
+	assert.Error(ValidateMnemonic(strings.Repeat("abandon ", 12)))
+	assert.Error(ValidateMnemonic("not a real mnemonic phrase at all"))
+}
+
+func TestMnemonicFixtures(t *testing.T) {
+	assert := assert.New(t)
+
+	// these must never change, or phrases people have written down will
+	// stop giving them their keys back
+	m := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+	p256, err := DerivePrivateKeyP256(m, "", "plc/rotation/0")
+	assert.NoError(err)
+	pub, err := p256.PublicKey()
+	assert.NoError(err)
+	assert.Equal("did:key:zDnaeWCrM8EYLDsoPabLHNrxTLLaPGPbA2cLmRXyUsL8euqBy", pub.DIDKey())
+
+	k256, err := DerivePrivateKeyK256(m, "", "plc/rotation/0")
+	assert.NoError(err)
+	pub, err = k256.PublicKey()
+	assert.NoError(err)
+	assert.Equal("did:key:zQ3sheEF9iWpjQYoKEUh9oyPnZDdWaFmugnS2pHGXmaUNQWtr", pub.DIDKey())
+}
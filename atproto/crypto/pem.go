@@ -0,0 +1,193 @@
+package crypto
+
+import (
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+// Golang's x509 package doesn't support K-256, so the ASN.1 structures for
+// both curves are handled directly here.
+
+type pkixAlgorithm struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.ObjectIdentifier
+}
+
+// RFC 5280 SubjectPublicKeyInfo
+type pkixPublicKey struct {
+	Algorithm pkixAlgorithm
+	PublicKey asn1.BitString
+}
+
+// RFC 5208 PrivateKeyInfo
+type pkcs8PrivateKey struct {
+	Version    int
+	Algorithm  pkixAlgorithm
+	PrivateKey []byte
+}
+
+// RFC 5915 / SEC 1 ECPrivateKey
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+func curveOID(pub PublicKey) (asn1.ObjectIdentifier, error) {
+	switch pub.(type) {
+	case *PublicKeyP256:
+		return oidCurveP256, nil
+	case *PublicKeyK256:
+		return oidCurveK256, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", pub)
+	}
+}
+
+// Serializes a public key as an ASN.1 DER encoded X.509 SubjectPublicKeyInfo, which can be parsed by [ParsePublicDERBytes].
+func PublicKeyDERBytes(pub PublicKey) ([]byte, error) {
+	oid, err := curveOID(pub)
+	if err != nil {
+		return nil, err
+	}
+	pt := pub.UncompressedBytes()
+	return asn1.Marshal(pkixPublicKey{
+		Algorithm: pkixAlgorithm{Algorithm: oidPublicKeyECDSA, Parameters: oid},
+		PublicKey: asn1.BitString{Bytes: pt, BitLength: 8 * len(pt)},
+	})
+}
+
+// Serializes a private key as ASN.1 DER encoded PKCS#8, the format used by openssl and most other tooling.
+func PrivateKeyPKCS8Bytes(priv PrivateKeyExportable) ([]byte, error) {
+	pub, err := priv.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	oid, err := curveOID(pub)
+	if err != nil {
+		return nil, err
+	}
+	pt := pub.UncompressedBytes()
+	inner, err := asn1.Marshal(ecPrivateKey{
+		Version:    1,
+		PrivateKey: priv.Bytes(),
+		PublicKey:  asn1.BitString{Bytes: pt, BitLength: 8 * len(pt)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs8PrivateKey{
+		Algorithm:  pkixAlgorithm{Algorithm: oidPublicKeyECDSA, Parameters: oid},
+		PrivateKey: inner,
+	})
+}
+
+// Loads a private key from ASN.1 DER encoded PKCS#8 bytes.
+func ParsePrivatePKCS8Bytes(der []byte) (PrivateKeyExportable, error) {
+	var p8 pkcs8PrivateKey
+	rest, err := asn1.Unmarshal(der, &p8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#8 private key: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("invalid PKCS#8 private key: trailing data")
+	}
+	if !p8.Algorithm.Algorithm.Equal(oidPublicKeyECDSA) {
+		return nil, fmt.Errorf("unsupported PKCS#8 key algorithm: %s", p8.Algorithm.Algorithm)
+	}
+	return parseECPrivateKey(p8.Algorithm.Parameters, p8.PrivateKey)
+}
+
+// Loads a private key from ASN.1 DER encoded SEC 1 bytes ("EC PRIVATE KEY"). The curve may be given by the caller, or in the encoding.
+func parseECPrivateKey(curve asn1.ObjectIdentifier, der []byte) (PrivateKeyExportable, error) {
+	var ec ecPrivateKey
+	if _, err := asn1.Unmarshal(der, &ec); err != nil {
+		return nil, fmt.Errorf("invalid EC private key: %w", err)
+	}
+	if ec.Version != 1 {
+		return nil, fmt.Errorf("unsupported EC private key version: %d", ec.Version)
+	}
+	if len(ec.NamedCurveOID) > 0 {
+		if len(curve) > 0 && !curve.Equal(ec.NamedCurveOID) {
+			return nil, fmt.Errorf("EC private key curve does not match")
+		}
+		curve = ec.NamedCurveOID
+	}
+
+	// leading zeros may have been stripped
+	if len(ec.PrivateKey) > 32 {
+		return nil, fmt.Errorf("invalid EC private key length: %d", len(ec.PrivateKey))
+	}
+	d := new(big.Int).SetBytes(ec.PrivateKey).FillBytes(make([]byte, 32))
+
+	var priv PrivateKeyExportable
+	var err error
+	switch {
+	case curve.Equal(oidCurveP256):
+		priv, err = ParsePrivateBytesP256(d)
+	case curve.Equal(oidCurveK256):
+		priv, err = ParsePrivateBytesK256(d)
+	default:
+		return nil, fmt.Errorf("unsupported atproto key curve: %s", curve)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+// Serializes a public key as a PEM "PUBLIC KEY" block.
+func PublicKeyPEM(pub PublicKey) (string, error) {
+	der, err := PublicKeyDERBytes(pub)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// Serializes a private key as a PKCS#8 PEM "PRIVATE KEY" block.
+func PrivateKeyPEM(priv PrivateKeyExportable) (string, error) {
+	der, err := PrivateKeyPKCS8Bytes(priv)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// Loads a [PublicKey] from a PEM "PUBLIC KEY" block.
+func ParsePublicPEM(encoded string) (PublicKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("unexpected PEM block type: %s", block.Type)
+	}
+	return ParsePublicDERBytes(block.Bytes)
+}
+
+// Loads a private key from a PEM block. Both PKCS#8 ("PRIVATE KEY") and SEC 1 ("EC PRIVATE KEY", the default output of "openssl ecparam -genkey") are supported.
+func ParsePrivatePEM(encoded string) (PrivateKeyExportable, error) {
+	rest := []byte(encoded)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM private key block found")
+		}
+		switch block.Type {
+		case "PRIVATE KEY":
+			return ParsePrivatePKCS8Bytes(block.Bytes)
+		case "EC PRIVATE KEY":
+			return parseECPrivateKey(nil, block.Bytes)
+		case "EC PARAMETERS":
+			// openssl ecparam writes the curve parameters first; skip them
+			continue
+		default:
+			return nil, fmt.Errorf("unexpected PEM block type: %s", block.Type)
+		}
+	}
+}
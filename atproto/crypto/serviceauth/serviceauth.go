@@ -0,0 +1,232 @@
+// Package serviceauth mints and validates atproto inter-service auth tokens.
+//
+// These are short-lived JWTs signed with an account's atproto signing key
+// (ES256 for P-256, ES256K for K-256). The issuer ("iss") is the account's
+// DID, the audience ("aud") is the DID of the service being called, and the
+// optional "lxm" claim pins the token to a single XRPC method.
+package serviceauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Wrapped by every validation failure which is the token's fault, as
+// opposed to a failure to resolve the issuer's identity
+var ErrInvalidToken = errors.New("invalid service auth token")
+
+type Claims struct {
+	Iss string `json:"iss"`
+	Aud string `json:"aud"`
+	Exp int64  `json:"exp"`
+	Iat int64  `json:"iat,omitempty"`
+	Lxm string `json:"lxm,omitempty"`
+	Jti string `json:"jti,omitempty"`
+}
+
+type header struct {
+	Typ string `json:"typ,omitempty"`
+	Alg string `json:"alg"`
+}
+
+// The JWT "alg" for a key type
+func algForKey(pub crypto.PublicKey) (string, error) {
+	switch pub.(type) {
+	case *crypto.PublicKeyP256:
+		return "ES256", nil
+	case *crypto.PublicKeyK256:
+		return "ES256K", nil
+	default:
+		return "", fmt.Errorf("unsupported key type for service auth: %T", pub)
+	}
+}
+
+// Mint signs a token letting the bearer call aud on behalf of iss, valid for
+// the given duration. lxm may be empty, for a token good for any method.
+func Mint(key crypto.PrivateKey, iss syntax.DID, aud string, lxm string, ttl time.Duration) (string, error) {
+	pub, err := key.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	alg, err := algForKey(pub)
+	if err != nil {
+		return "", err
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	hdr, err := json.Marshal(header{Typ: "JWT", Alg: alg})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(Claims{
+		Iss: iss.String(),
+		Aud: aud,
+		Exp: now.Add(ttl).Unix(),
+		Iat: now.Unix(),
+		Lxm: lxm,
+		Jti: base64.RawURLEncoding.EncodeToString(jti),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sig, err := key.HashAndSign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Validator checks tokens addressed to a single service
+type Validator struct {
+	// Audience is this service's DID, which tokens must be addressed to
+	Audience string
+	// Dir resolves issuers' signing keys
+	Dir identity.Directory
+	// RequireLxm rejects tokens without an "lxm" claim, instead of treating
+	// them as good for any method
+	RequireLxm bool
+	// RejectReplays makes tokens single use: each token's "jti" is
+	// remembered until it expires, and tokens without one are rejected
+	RejectReplays bool
+
+	lk sync.Mutex
+	// issuer and jti of tokens seen, to when they expire
+	seen map[string]int64
+}
+
+// replays remembered before expired ones are swept out
+const maxSeenTokens = 10000
+
+// checkReplay records a token's jti, failing if it was seen before
+func (v *Validator) checkReplay(claims *Claims) error {
+	if claims.Jti == "" {
+		return fmt.Errorf("%w: token has no jti", ErrInvalidToken)
+	}
+	key := claims.Iss + " " + claims.Jti
+
+	v.lk.Lock()
+	defer v.lk.Unlock()
+	if v.seen == nil {
+		v.seen = make(map[string]int64)
+	}
+	if _, ok := v.seen[key]; ok {
+		return fmt.Errorf("%w: token has already been used", ErrInvalidToken)
+	}
+	if len(v.seen) >= maxSeenTokens {
+		now := time.Now().Unix()
+		for k, exp := range v.seen {
+			if now >= exp {
+				delete(v.seen, k)
+			}
+		}
+	}
+	v.seen[key] = claims.Exp
+	return nil
+}
+
+// Validate checks a token, returning the DID it was issued by. lxm is the
+// NSID of the method being called, or empty to skip that check. With
+// RejectReplays, a token only validates once.
+//
+// If the signature doesn't verify, the issuer's identity is purged from the
+// directory and the check is retried once, in case the key was rotated.
+func (v *Validator) Validate(ctx context.Context, token string, lxm string) (syntax.DID, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var hdr header
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(hb, &hdr) != nil {
+		return "", fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	var claims Claims
+	cb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(cb, &claims) != nil {
+		return "", fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	if claims.Aud != v.Audience {
+		return "", fmt.Errorf("%w: token is not addressed to this service", ErrInvalidToken)
+	}
+	if time.Now().Unix() >= claims.Exp {
+		return "", fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if claims.Lxm == "" && v.RequireLxm {
+		return "", fmt.Errorf("%w: token is not bound to a method", ErrInvalidToken)
+	}
+	if claims.Lxm != "" && lxm != "" && claims.Lxm != lxm {
+		return "", fmt.Errorf("%w: token is for a different method", ErrInvalidToken)
+	}
+
+	iss, err := syntax.ParseDID(claims.Iss)
+	if err != nil {
+		return "", fmt.Errorf("%w: bad issuer: %w", ErrInvalidToken, err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	err = v.verify(ctx, iss, hdr.Alg, signed, sig)
+	if errors.Is(err, crypto.ErrInvalidSignature) {
+		if err := v.Dir.Purge(ctx, iss.AtIdentifier()); err != nil {
+			return "", err
+		}
+		err = v.verify(ctx, iss, hdr.Alg, signed, sig)
+	}
+	if errors.Is(err, crypto.ErrInvalidSignature) {
+		return "", fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	if err != nil {
+		return "", err
+	}
+	// only once the signature checks out, so forged tokens can't fill the
+	// cache
+	if v.RejectReplays {
+		if err := v.checkReplay(&claims); err != nil {
+			return "", err
+		}
+	}
+	return iss, nil
+}
+
+func (v *Validator) verify(ctx context.Context, iss syntax.DID, alg string, signed, sig []byte) error {
+	ident, err := v.Dir.LookupDID(ctx, iss)
+	if err != nil {
+		return fmt.Errorf("resolving service auth issuer: %w", err)
+	}
+	pub, err := ident.PublicKey()
+	if err != nil {
+		return fmt.Errorf("%w: issuer has no signing key", ErrInvalidToken)
+	}
+	if expected, err := algForKey(pub); err != nil || expected != alg {
+		return fmt.Errorf("%w: unexpected signing algorithm", ErrInvalidToken)
+	}
+	if err := pub.HashAndVerify(signed, sig); err != nil {
+		// eg, wrong length for the key type
+		return crypto.ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package serviceauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/stretchr/testify/assert"
+)
+
+// rotatingDirectory serves a stale identity until purged
+type rotatingDirectory struct {
+	identity.MockDirectory
+	fresh identity.Identity
+}
+
+func (d *rotatingDirectory) Purge(ctx context.Context, a syntax.AtIdentifier) error {
+	d.Insert(d.fresh)
+	return nil
+}
+
+func identityForKey(t *testing.T, did syntax.DID, priv crypto.PrivateKey) identity.Identity {
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity.Identity{
+		DID: did,
+		Keys: map[string]identity.Key{
+			"atproto": {Type: "Multikey", PublicKeyMultibase: pub.Multibase()},
+		},
+	}
+}
+
+func TestMintAndValidate(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	iss := syntax.DID("did:plc:abc123")
+
+	privP256, err := crypto.GeneratePrivateKeyP256()
+	assert.NoError(err)
+	privK256, err := crypto.GeneratePrivateKeyK256()
+	assert.NoError(err)
+
+	for _, priv := range []crypto.PrivateKey{privP256, privK256} {
+		dir := identity.NewMockDirectory()
+		dir.Insert(identityForKey(t, iss, priv))
+		v := &Validator{Audience: "did:web:feeds.example.com", Dir: &dir}
+
+		tok, err := Mint(priv, iss, "did:web:feeds.example.com", "app.bsky.feed.getFeedSkeleton", time.Minute)
+		assert.NoError(err)
+		got, err := v.Validate(ctx, tok, "app.bsky.feed.getFeedSkeleton")
+		assert.NoError(err)
+		assert.Equal(iss, got)
+
+		_, err = v.Validate(ctx, tok, "com.atproto.repo.createRecord")
+		assert.True(errors.Is(err, ErrInvalidToken))
+
+		other, err := Mint(priv, iss, "did:web:other.example.com", "", time.Minute)
+		assert.NoError(err)
+		_, err = v.Validate(ctx, other, "")
+		assert.True(errors.Is(err, ErrInvalidToken))
+
+		expired, err := Mint(priv, iss, "did:web:feeds.example.com", "", -time.Minute)
+		assert.NoError(err)
+		_, err = v.Validate(ctx, expired, "")
+		assert.True(errors.Is(err, ErrInvalidToken))
+
+		anyMethod, err := Mint(priv, iss, "did:web:feeds.example.com", "", time.Minute)
+		assert.NoError(err)
+		_, err = v.Validate(ctx, anyMethod, "app.bsky.feed.getFeedSkeleton")
+		assert.NoError(err)
+		v.RequireLxm = true
+		_, err = v.Validate(ctx, anyMethod, "app.bsky.feed.getFeedSkeleton")
+		assert.True(errors.Is(err, ErrInvalidToken))
+	}
+}
+
+func TestValidateRejectReplays(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	iss := syntax.DID("did:plc:abc123")
+
+	priv, err := crypto.GeneratePrivateKeyP256()
+	assert.NoError(err)
+	dir := identity.NewMockDirectory()
+	dir.Insert(identityForKey(t, iss, priv))
+	v := &Validator{Audience: "did:web:pds.example.com", Dir: &dir, RejectReplays: true}
+
+	tok, err := Mint(priv, iss, "did:web:pds.example.com", "com.atproto.server.createAccount", time.Minute)
+	assert.NoError(err)
+	_, err = v.Validate(ctx, tok, "com.atproto.server.createAccount")
+	assert.NoError(err)
+	_, err = v.Validate(ctx, tok, "com.atproto.server.createAccount")
+	assert.True(errors.Is(err, ErrInvalidToken))
+
+	// a different token from the same issuer is fine
+	again, err := Mint(priv, iss, "did:web:pds.example.com", "com.atproto.server.createAccount", time.Minute)
+	assert.NoError(err)
+	_, err = v.Validate(ctx, again, "com.atproto.server.createAccount")
+	assert.NoError(err)
+
+	// a token which doesn't validate isn't remembered
+	wrongMethod, err := Mint(priv, iss, "did:web:pds.example.com", "com.atproto.server.createAccount", time.Minute)
+	assert.NoError(err)
+	_, err = v.Validate(ctx, wrongMethod, "com.atproto.repo.createRecord")
+	assert.True(errors.Is(err, ErrInvalidToken))
+	_, err = v.Validate(ctx, wrongMethod, "com.atproto.server.createAccount")
+	assert.NoError(err)
+}
+
+func TestValidateRotatedKey(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	iss := syntax.DID("did:plc:abc123")
+
+	oldKey, err := crypto.GeneratePrivateKeyK256()
+	assert.NoError(err)
+	newKey, err := crypto.GeneratePrivateKeyK256()
+	assert.NoError(err)
+
+	dir := &rotatingDirectory{MockDirectory: identity.NewMockDirectory(), fresh: identityForKey(t, iss, newKey)}
+	dir.Insert(identityForKey(t, iss, oldKey))
+	v := &Validator{Audience: "did:web:pds.example.com", Dir: dir}
+
+	tok, err := Mint(newKey, iss, "did:web:pds.example.com", "", time.Minute)
+	assert.NoError(err)
+	got, err := v.Validate(ctx, tok, "")
+	assert.NoError(err)
+	assert.Equal(iss, got)
+
+	// the old key no longer verifies, even after a refresh
+	stale, err := Mint(oldKey, iss, "did:web:pds.example.com", "", time.Minute)
+	assert.NoError(err)
+	_, err = v.Validate(ctx, stale, "")
+	assert.True(errors.Is(err, ErrInvalidToken))
+}
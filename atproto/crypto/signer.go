@@ -0,0 +1,95 @@
+package crypto
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// Common interface for signing backends which hold the secret key material
+// themselves, such as a cloud KMS or a hardware security module. The key
+// never has to be in memory; wrap a Signer with [NewSignerKey] to use it
+// anywhere a [PrivateKey] is expected.
+type Signer interface {
+	// The public key corresponding to the backend's private key. Either a
+	// [PublicKeyP256] or a [PublicKeyK256].
+	PublicKey(ctx context.Context) (PublicKey, error)
+
+	// Signs an already-computed SHA-256 digest. Returns the "compact" 64-byte
+	// (r || s) encoding of the ECDSA signature, which need not be "low-S".
+	SignDigest(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+// Implements the [PrivateKey] interface on top of a [Signer]. The secret key
+// material is not available, so this never implements [PrivateKeyExportable].
+type SignerKey struct {
+	signer Signer
+	pub    PublicKey
+	curveN *big.Int
+}
+
+var _ PrivateKey = (*SignerKey)(nil)
+
+var curveN_K256, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", 16)
+
+// Fetches the public key from the [Signer] once, and returns a [SignerKey]
+// which signs through it.
+func NewSignerKey(ctx context.Context, s Signer) (*SignerKey, error) {
+	pub, err := s.PublicKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching signer public key: %w", err)
+	}
+
+	k := &SignerKey{signer: s, pub: pub}
+	switch pub.(type) {
+	case *PublicKeyP256:
+		k.curveN = curveN_P256
+	case *PublicKeyK256:
+		k.curveN = curveN_K256
+	default:
+		return nil, fmt.Errorf("unsupported signer key type: %T", pub)
+	}
+	return k, nil
+}
+
+// Checks if the two keys are backed by the same key pair, by comparing public keys.
+func (k *SignerKey) Equal(other PrivateKey) bool {
+	otherPub, err := other.PublicKey()
+	if err != nil {
+		return false
+	}
+	return k.pub.Equal(otherPub)
+}
+
+// Outputs the [PublicKey] of the backing [Signer].
+func (k *SignerKey) PublicKey() (PublicKey, error) {
+	return k.pub, nil
+}
+
+// First hashes the raw bytes, then has the [Signer] sign the digest. The
+// signature is normalized to "low-S", and verified against the public key
+// before being returned, so a misbehaving backend can't produce invalid
+// signatures.
+func (k *SignerKey) HashAndSign(content []byte) ([]byte, error) {
+	hash := sha256.Sum256(content)
+	sig, err := k.signer.SignDigest(context.Background(), hash[:])
+	if err != nil {
+		return nil, fmt.Errorf("external signer: %w", err)
+	}
+	if len(sig) != 64 {
+		return nil, fmt.Errorf("external signer returned a %d byte signature, expected 64", len(sig))
+	}
+
+	s := new(big.Int).SetBytes(sig[32:])
+	if s.Cmp(new(big.Int).Rsh(k.curveN, 1)) == 1 {
+		s.Sub(k.curveN, s)
+		sig = append(sig[:32:32], make([]byte, 32)...)
+		s.FillBytes(sig[32:])
+	}
+
+	if err := k.pub.HashAndVerify(content, sig); err != nil {
+		return nil, fmt.Errorf("external signer produced an invalid signature: %w", err)
+	}
+	return sig, nil
+}
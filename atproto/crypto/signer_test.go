@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memSigner is a Signer backed by an in-memory key, which returns "high-S"
+// signatures to exercise normalization
+type memSigner struct {
+	msg   []byte
+	priv  PrivateKey
+	other PrivateKey
+}
+
+func (m *memSigner) PublicKey(ctx context.Context) (PublicKey, error) {
+	return m.priv.PublicKey()
+}
+
+func (m *memSigner) SignDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	// our keys only hash-and-sign, so sign the message the digest came from
+	if [32]byte(digest) != sha256.Sum256(m.msg) {
+		return nil, fmt.Errorf("unexpected digest")
+	}
+	k := m.priv
+	if m.other != nil {
+		k = m.other
+	}
+	sig, err := k.HashAndSign(m.msg)
+	if err != nil {
+		return nil, err
+	}
+	n := curveN_P256
+	if _, ok := k.(*PrivateKeyK256); ok {
+		n = curveN_K256
+	}
+	s := new(big.Int).SetBytes(sig[32:])
+	s.Sub(n, s)
+	s.FillBytes(sig[32:])
+	return sig, nil
+}
+
+func TestSignerKey(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	msg := []byte("test-message")
+
+	privP256, err := GeneratePrivateKeyP256()
+	assert.NoError(err)
+	privK256, err := GeneratePrivateKeyK256()
+	assert.NoError(err)
+
+	for _, priv := range []PrivateKey{privP256, privK256} {
+		sk, err := NewSignerKey(ctx, &memSigner{msg: msg, priv: priv})
+		assert.NoError(err)
+		assert.True(sk.Equal(priv))
+
+		sig, err := sk.HashAndSign(msg)
+		assert.NoError(err)
+		pub, err := priv.PublicKey()
+		assert.NoError(err)
+		assert.NoError(pub.HashAndVerify(msg, sig))
+	}
+
+	// a backend signing with the wrong key is caught
+	sk, err := NewSignerKey(ctx, &memSigner{msg: msg, priv: privP256, other: privK256})
+	assert.NoError(err)
+	_, err = sk.HashAndSign(msg)
+	assert.Error(err)
+}
+
+func TestParsePublicDERBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	// P-256 SubjectPublicKeyInfo, as produced by openssl or a KMS
+	privP256, err := GeneratePrivateKeyP256()
+	assert.NoError(err)
+	pubP256, err := privP256.PublicKey()
+	assert.NoError(err)
+	der, err := x509.MarshalPKIXPublicKey(&pubP256.(*PublicKeyP256).pubP256)
+	assert.NoError(err)
+	parsed, err := ParsePublicDERBytes(der)
+	assert.NoError(err)
+	assert.True(pubP256.Equal(parsed))
+
+	// golang's x509 can't encode K-256, so build the structure by hand
+	privK256, err := GeneratePrivateKeyK256()
+	assert.NoError(err)
+	pubK256, err := privK256.PublicKey()
+	assert.NoError(err)
+	type algo struct {
+		Algorithm  asn1.ObjectIdentifier
+		Parameters asn1.ObjectIdentifier
+	}
+	der, err = asn1.Marshal(struct {
+		Algorithm algo
+		PublicKey asn1.BitString
+	}{
+		Algorithm: algo{oidPublicKeyECDSA, oidCurveK256},
+		PublicKey: asn1.BitString{Bytes: pubK256.UncompressedBytes(), BitLength: 8 * 65},
+	})
+	assert.NoError(err)
+	parsed, err = ParsePublicDERBytes(der)
+	assert.NoError(err)
+	assert.True(pubK256.Equal(parsed))
+
+	_, err = ParsePublicDERBytes([]byte("junk"))
+	assert.Error(err)
+}
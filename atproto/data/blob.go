@@ -0,0 +1,150 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+)
+
+// A blob reference found inside a record, along with its location.
+type BlobRef struct {
+	Blob
+
+	// JSON-path-style location of the blob within the record, eg "$.embed.images[0].image"
+	Path string
+}
+
+// Walks a decoded record and returns all of the blob references it contains, in a deterministic (sorted-key, depth-first) order.
+//
+// The record can be data parsed with [UnmarshalJSON] (containing [Blob] values), or generic map-of-interfaces data decoded directly from JSON or CBOR, in which case blob objects (with "$type": "blob") and legacy blob objects (with "cid" and "mimeType" fields) are recognized structurally. Malformed blob objects result in an error.
+func ExtractBlobs(record any) ([]BlobRef, error) {
+	var out []BlobRef
+	if err := walkBlobs(record, "$", &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func walkBlobs(v any, path string, out *[]BlobRef) error {
+	switch val := v.(type) {
+	case Blob:
+		*out = append(*out, BlobRef{Blob: val, Path: path})
+	case *Blob:
+		if val != nil {
+			*out = append(*out, BlobRef{Blob: *val, Path: path})
+		}
+	case []any:
+		for i, elem := range val {
+			if err := walkBlobs(elem, path+"["+strconv.Itoa(i)+"]", out); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		blob, ok, err := blobFromMap(val)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if ok {
+			*out = append(*out, BlobRef{Blob: blob, Path: path})
+			return nil
+		}
+		for _, k := range sortedKeys(val) {
+			if err := walkBlobs(val[k], blobPathJoin(path, k), out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checks if a generic map is a blob (or legacy blob) object. returns false if the map is some other type of object.
+func blobFromMap(obj map[string]any) (Blob, bool, error) {
+	var blob Blob
+	if typ, ok := obj["$type"]; ok {
+		if typ != "blob" {
+			return blob, false, nil
+		}
+		ref, err := linkFromValue(obj["ref"])
+		if err != nil {
+			return blob, false, fmt.Errorf("blob 'ref': %w", err)
+		}
+		blob.Ref = ref
+		mimeType, ok := obj["mimeType"].(string)
+		if !ok || mimeType == "" {
+			return blob, false, fmt.Errorf("blob missing 'mimeType'")
+		}
+		blob.MimeType = mimeType
+		size, ok := intFromValue(obj["size"])
+		if !ok || size < 0 {
+			return blob, false, fmt.Errorf("blob 'size' must be a non-negative integer")
+		}
+		blob.Size = size
+		return blob, true, nil
+	}
+
+	// legacy blobs have exactly two fields: a string CID and a mimetype
+	cidStr, ok := obj["cid"].(string)
+	if !ok || len(obj) != 2 {
+		return blob, false, nil
+	}
+	mimeType, ok := obj["mimeType"].(string)
+	if !ok {
+		return blob, false, nil
+	}
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		return blob, false, fmt.Errorf("legacy blob CID: %w", err)
+	}
+	return Blob{Ref: CIDLink(c), MimeType: mimeType, Size: -1}, true, nil
+}
+
+func linkFromValue(v any) (CIDLink, error) {
+	switch val := v.(type) {
+	case CIDLink:
+		return val, nil
+	case cid.Cid:
+		return CIDLink(val), nil
+	case *cid.Cid:
+		if val != nil {
+			return CIDLink(*val), nil
+		}
+	case map[string]any:
+		s, ok := val["$link"].(string)
+		if ok && len(val) == 1 {
+			c, err := cid.Decode(s)
+			if err != nil {
+				return CIDLink{}, err
+			}
+			return CIDLink(c), nil
+		}
+	}
+	return CIDLink{}, fmt.Errorf("expected cid-link, got %T", v)
+}
+
+func intFromValue(v any) (int64, bool) {
+	switch val := v.(type) {
+	case int64:
+		return val, true
+	case int:
+		return int64(val), true
+	case uint64:
+		return int64(val), true
+	case float64:
+		if val == float64(int64(val)) {
+			return int64(val), true
+		}
+	case interface{ Int64() (int64, error) }:
+		i, err := val.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func blobPathJoin(path, key string) string {
+	if key != "" && !strings.ContainsAny(key, " .[]'\"") {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
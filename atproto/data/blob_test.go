@@ -0,0 +1,50 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const blobRecordJSON = `{
+	"$type": "app.bsky.feed.post",
+	"text": "two images",
+	"embed": {
+		"$type": "app.bsky.embed.images",
+		"images": [
+			{"alt": "one", "image": {"$type": "blob", "ref": {"$link": "bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity"}, "mimeType": "image/jpeg", "size": 10000}},
+			{"alt": "two", "image": {"cid": "bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity", "mimeType": "image/png"}}
+		]
+	},
+	"odd key": {"$type": "blob", "ref": {"$link": "bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity"}, "mimeType": "video/mp4", "size": 3}
+}`
+
+func TestExtractBlobs(t *testing.T) {
+	assert := assert.New(t)
+
+	parsed, err := UnmarshalJSON([]byte(blobRecordJSON))
+	assert.NoError(err)
+	var generic map[string]any
+	assert.NoError(json.Unmarshal([]byte(blobRecordJSON), &generic))
+
+	for _, rec := range []any{parsed, generic} {
+		refs, err := ExtractBlobs(rec)
+		assert.NoError(err)
+		assert.Equal(3, len(refs))
+		if len(refs) != 3 {
+			continue
+		}
+		assert.Equal("$.embed.images[0].image", refs[0].Path)
+		assert.Equal("image/jpeg", refs[0].MimeType)
+		assert.Equal(int64(10000), refs[0].Size)
+		assert.Equal("bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity", refs[0].Ref.String())
+		assert.Equal("$.embed.images[1].image", refs[1].Path)
+		assert.Equal(int64(-1), refs[1].Size)
+		assert.Equal(`$["odd key"]`, refs[2].Path)
+		assert.Equal("video/mp4", refs[2].MimeType)
+	}
+
+	_, err = ExtractBlobs(map[string]any{"bad": map[string]any{"$type": "blob", "size": 1}})
+	assert.Error(err)
+}
@@ -0,0 +1,212 @@
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Encodes generic atproto data as canonical JSON.
+//
+// The output is deterministic for a given data model value, which makes it suitable for computing hashes or signatures over JSON representations. The rules are:
+//
+//   - no insignificant whitespace
+//   - object keys are sorted by their UTF-8 byte representation
+//   - strings are escaped minimally (no HTML escaping of '<', '>', or '&')
+//   - integers are written in plain base-10 form; floats are only allowed if they are integral and within the 53-bit safe range, and are written as integers
+//   - cid-links are written as {"$link": "<base32 CIDv1 string>"}
+//   - bytes are written as {"$bytes": "<base64, standard alphabet, no padding>"}
+//   - blobs are written as objects with "$type", "mimeType", "ref", and "size" fields; legacy blobs as "cid" and "mimeType"
+//
+// Accepted input types are nil, bool, string, golang integer types, float32/float64 (see above), [json.Number], slices, string-keyed maps, [CIDLink], [cid.Cid], [Bytes], []byte, and [Blob] (or pointers to any of these).
+func MarshalCanonicalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func sortedKeys(obj map[string]any) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// largest integer which can be represented exactly by a float64
+const maxSafeFloatInt = 1<<53 - 1
+
+func writeCanonical(buf *bytes.Buffer, v any) error {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		if val {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case string:
+		return writeCanonicalString(buf, val)
+	case int:
+		buf.WriteString(strconv.FormatInt(int64(val), 10))
+	case int8:
+		buf.WriteString(strconv.FormatInt(int64(val), 10))
+	case int16:
+		buf.WriteString(strconv.FormatInt(int64(val), 10))
+	case int32:
+		buf.WriteString(strconv.FormatInt(int64(val), 10))
+	case int64:
+		buf.WriteString(strconv.FormatInt(val, 10))
+	case uint:
+		buf.WriteString(strconv.FormatUint(uint64(val), 10))
+	case uint8:
+		buf.WriteString(strconv.FormatUint(uint64(val), 10))
+	case uint16:
+		buf.WriteString(strconv.FormatUint(uint64(val), 10))
+	case uint32:
+		buf.WriteString(strconv.FormatUint(uint64(val), 10))
+	case uint64:
+		buf.WriteString(strconv.FormatUint(val, 10))
+	case float32:
+		return writeCanonicalFloat(buf, float64(val))
+	case float64:
+		return writeCanonicalFloat(buf, val)
+	case json.Number:
+		i, err := val.Int64()
+		if err != nil {
+			return fmt.Errorf("non-integer numbers not allowed in canonical JSON: %s", val)
+		}
+		buf.WriteString(strconv.FormatInt(i, 10))
+	case CIDLink:
+		return writeCanonicalLink(buf, cid.Cid(val))
+	case *CIDLink:
+		if val == nil {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeCanonicalLink(buf, cid.Cid(*val))
+	case cid.Cid:
+		return writeCanonicalLink(buf, val)
+	case *cid.Cid:
+		if val == nil {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeCanonicalLink(buf, *val)
+	case Bytes:
+		writeCanonicalBytes(buf, val)
+	case []byte:
+		writeCanonicalBytes(buf, val)
+	case Blob:
+		return writeCanonicalBlob(buf, val)
+	case *Blob:
+		if val == nil {
+			buf.WriteString("null")
+			return nil
+		}
+		return writeCanonicalBlob(buf, *val)
+	case []any:
+		buf.WriteByte('[')
+		for i, elem := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]any:
+		buf.WriteByte('{')
+		for i, k := range sortedKeys(val) {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalString(buf, k); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, val[k]); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		norm, err := normalizeValue(v)
+		if err != nil {
+			return err
+		}
+		return writeCanonical(buf, norm)
+	}
+	return nil
+}
+
+func writeCanonicalString(buf *bytes.Buffer, s string) error {
+	var sb bytes.Buffer
+	enc := json.NewEncoder(&sb)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	// Encode always appends a trailing newline
+	buf.Write(bytes.TrimSuffix(sb.Bytes(), []byte("\n")))
+	return nil
+}
+
+func writeCanonicalFloat(buf *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("NaN and infinite numbers not allowed in canonical JSON")
+	}
+	if f != math.Trunc(f) {
+		return fmt.Errorf("non-integer numbers not allowed in canonical JSON: %v", f)
+	}
+	if math.Abs(f) > maxSafeFloatInt {
+		return fmt.Errorf("float outside safe integer range for canonical JSON: %v", f)
+	}
+	buf.WriteString(strconv.FormatInt(int64(f), 10))
+	return nil
+}
+
+func writeCanonicalLink(buf *bytes.Buffer, c cid.Cid) error {
+	if !c.Defined() {
+		return fmt.Errorf("undefined cid-link can not be encoded")
+	}
+	buf.WriteString(`{"$link":"`)
+	buf.WriteString(c.String())
+	buf.WriteString(`"}`)
+	return nil
+}
+
+func writeCanonicalBytes(buf *bytes.Buffer, b []byte) {
+	buf.WriteString(`{"$bytes":"`)
+	buf.WriteString(base64.RawStdEncoding.EncodeToString(b))
+	buf.WriteString(`"}`)
+}
+
+func writeCanonicalBlob(buf *bytes.Buffer, b Blob) error {
+	if b.Size < 0 {
+		if !b.Ref.Defined() {
+			return fmt.Errorf("undefined blob ref can not be encoded")
+		}
+		return writeCanonical(buf, map[string]any{
+			"cid":      b.Ref.String(),
+			"mimeType": b.MimeType,
+		})
+	}
+	return writeCanonical(buf, map[string]any{
+		"$type":    "blob",
+		"mimeType": b.MimeType,
+		"ref":      b.Ref,
+		"size":     b.Size,
+	})
+}
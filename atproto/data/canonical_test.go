@@ -0,0 +1,77 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := cid.Decode("bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a")
+	assert.NoError(err)
+
+	fixtures := []struct {
+		val any
+		out string
+	}{
+		{val: map[string]any{}, out: `{}`},
+		{val: map[string]any{"b": 1, "a": 2, "aa": 3}, out: `{"a":2,"aa":3,"b":1}`},
+		{val: map[string]any{"text": "<a> & b", "n": int64(-12)}, out: `{"n":-12,"text":"<a> & b"}`},
+		{val: map[string]any{"f": 3.0}, out: `{"f":3}`},
+		{val: map[string]any{"l": CIDLink(c)}, out: `{"l":{"$link":"bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a"}}`},
+		{val: map[string]any{"b": Bytes("hello")}, out: `{"b":{"$bytes":"aGVsbG8"}}`},
+		{val: map[string]any{"arr": []any{true, nil, "x"}}, out: `{"arr":[true,null,"x"]}`},
+		{val: map[string]any{"strs": []string{"z", "y"}}, out: `{"strs":["z","y"]}`},
+		{
+			val: map[string]any{"img": Blob{Ref: CIDLink(c), MimeType: "image/png", Size: 123}},
+			out: `{"img":{"$type":"blob","mimeType":"image/png","ref":{"$link":"bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a"},"size":123}}`,
+		},
+	}
+
+	for _, f := range fixtures {
+		out, err := MarshalCanonicalJSON(f.val)
+		assert.NoError(err)
+		assert.Equal(f.out, string(out))
+	}
+
+	bad := []any{
+		map[string]any{"f": 1.5},
+		map[string]any{"l": CIDLink(cid.Undef)},
+		map[int]any{1: "a"},
+		map[string]any{"c": make(chan int)},
+	}
+	for _, v := range bad {
+		_, err := MarshalCanonicalJSON(v)
+		assert.Error(err)
+	}
+}
+
+func TestCanonicalJSONRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	raw := `{
+		"$type": "app.bsky.feed.post",
+		"text": "hello world",
+		"createdAt": "2023-06-01T00:00:00.000Z",
+		"embed": {
+			"$type": "app.bsky.embed.images",
+			"images": [{"alt": "", "image": {"$type": "blob", "ref": {"$link": "bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity"}, "mimeType": "image/jpeg", "size": 10000}}]
+		},
+		"sig": {"$bytes": "nFERjvLLiw9qm45JrqH9QTzyC2Lu1Xb4ne6+sBrCzI0"}
+	}`
+	obj, err := UnmarshalJSON([]byte(raw))
+	assert.NoError(err)
+
+	first, err := MarshalCanonicalJSON(obj)
+	assert.NoError(err)
+
+	again, err := UnmarshalJSON(first)
+	assert.NoError(err)
+	second, err := MarshalCanonicalJSON(again)
+	assert.NoError(err)
+	assert.Equal(string(first), string(second))
+	assert.Equal(`{"$type":"app.bsky.feed.post","createdAt":"2023-06-01T00:00:00.000Z","embed":{"$type":"app.bsky.embed.images","images":[{"alt":"","image":{"$type":"blob","mimeType":"image/jpeg","ref":{"$link":"bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity"},"size":10000}}]},"sig":{"$bytes":"nFERjvLLiw9qm45JrqH9QTzyC2Lu1Xb4ne6+sBrCzI0"},"text":"hello world"}`, string(first))
+}
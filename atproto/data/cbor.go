@@ -0,0 +1,488 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	cborMajUint   = 0
+	cborMajNegInt = 1
+	cborMajBytes  = 2
+	cborMajString = 3
+	cborMajArray  = 4
+	cborMajMap    = 5
+	cborMajTag    = 6
+	cborMajOther  = 7
+
+	// DAG-CBOR tag for CIDs
+	cborTagCID = 42
+)
+
+var (
+	// Returned (wrapped) when CBOR input exceeds [DecodeLimits.MaxSize]
+	ErrCBORTooLarge = errors.New("CBOR data exceeds size limit")
+	// Returned (wrapped) when CBOR input is nested deeper than [DecodeLimits.MaxDepth]
+	ErrCBORTooDeep = errors.New("CBOR data exceeds nesting depth limit")
+)
+
+// Resource limits applied when decoding untrusted DAG-CBOR data. Zero (or negative) fields use the value from [DefaultDecodeLimits], so callers can set only the limits they care about.
+type DecodeLimits struct {
+	// Maximum total size of the encoded object, in bytes
+	MaxSize int64
+	// Maximum nesting depth of maps and arrays (the top-level map is depth 1)
+	MaxDepth int
+}
+
+// fills in unset limits from the defaults
+func (l DecodeLimits) withDefaults() DecodeLimits {
+	if l.MaxSize <= 0 {
+		l.MaxSize = DefaultDecodeLimits.MaxSize
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = DefaultDecodeLimits.MaxDepth
+	}
+	return l
+}
+
+// Limits used by [UnmarshalCBOR]. These are generous compared to the size of typical records, but bound memory usage on malicious input.
+var DefaultDecodeLimits = DecodeLimits{
+	MaxSize:  2 * 1024 * 1024,
+	MaxDepth: 32,
+}
+
+// Parses DAG-CBOR bytes in to a generic atproto data model object, using [DefaultDecodeLimits].
+//
+// The top-level value must be a map with string keys. CIDs (tag 42) are returned as [CIDLink], byte strings as [Bytes], and blob objects as [Blob]. Floats, non-canonical integer encodings, indefinite-length values, and trailing bytes are errors.
+func UnmarshalCBOR(b []byte) (map[string]any, error) {
+	return UnmarshalCBORReader(bytes.NewReader(b), DefaultDecodeLimits)
+}
+
+// Parses a single DAG-CBOR object from a reader, enforcing the given limits. The entire reader is consumed; trailing data is an error.
+//
+// Input is read incrementally, and allocations are bounded by the input actually read (not by length prefixes in the data), so oversized or maliciously nested objects are rejected with an error wrapping [ErrCBORTooLarge] or [ErrCBORTooDeep] before they can cause large allocations.
+func UnmarshalCBORReader(r io.Reader, limits DecodeLimits) (map[string]any, error) {
+	limits = limits.withDefaults()
+	lr := &limitedReader{r: r, remaining: limits.MaxSize}
+	dec := cborDecoder{r: bufio.NewReader(lr), limits: limits}
+	val, err := dec.decodeValue(1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.r.ReadByte(); err != io.EOF {
+		if errors.Is(err, ErrCBORTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("unexpected trailing data after CBOR object")
+	}
+	obj, ok := val.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected CBOR map at top level")
+	}
+	return obj, nil
+}
+
+// reader wrapper which returns ErrCBORTooLarge if more than the limit of bytes is available
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.remaining <= 0 {
+		// check if there is any more data at all
+		var one [1]byte
+		n, err := lr.r.Read(one[:])
+		if n > 0 {
+			return 0, ErrCBORTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > lr.remaining {
+		p = p[:lr.remaining]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	return n, err
+}
+
+// Encodes generic atproto data as DAG-CBOR.
+//
+// Map keys are sorted in DAG-CBOR canonical order (shorter keys first, then bytewise). Accepts the same input types as [MarshalCanonicalJSON].
+func MarshalCBOR(obj map[string]any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeCBOR(&buf, obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Computes the CID (CIDv1, dag-cbor codec, sha2-256 hash) for DAG-CBOR encoded bytes, as used for records and other repository blocks.
+func ComputeCID(cborBytes []byte) (cid.Cid, error) {
+	return cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256).Sum(cborBytes)
+}
+
+// Converts a DAG-CBOR encoded object to (canonical) atproto JSON.
+func CBORToJSON(cborBytes []byte) ([]byte, error) {
+	obj, err := UnmarshalCBOR(cborBytes)
+	if err != nil {
+		return nil, err
+	}
+	return MarshalCanonicalJSON(obj)
+}
+
+// Converts an atproto JSON object to DAG-CBOR.
+func JSONToCBOR(jsonBytes []byte) ([]byte, error) {
+	obj, err := UnmarshalJSON(jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	return MarshalCBOR(obj)
+}
+
+type cborDecoder struct {
+	r      *bufio.Reader
+	limits DecodeLimits
+}
+
+var errCBORFloat = errors.New("floats not allowed in atproto data")
+
+func (d *cborDecoder) readHeader() (byte, uint64, error) {
+	first, err := d.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	maj := first >> 5
+	low := first & 0x1f
+	if maj == cborMajOther && low >= 25 && low <= 27 {
+		return 0, 0, errCBORFloat
+	}
+
+	var buf [8]byte
+	var val uint64
+	switch {
+	case low < 24:
+		return maj, uint64(low), nil
+	case low == 24:
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return 0, 0, unexpectedEOF(err)
+		}
+		val = uint64(b)
+		if val < 24 {
+			return 0, 0, fmt.Errorf("non-canonical CBOR integer encoding")
+		}
+	case low == 25:
+		if _, err := io.ReadFull(d.r, buf[:2]); err != nil {
+			return 0, 0, unexpectedEOF(err)
+		}
+		val = uint64(binary.BigEndian.Uint16(buf[:2]))
+		if val <= math.MaxUint8 {
+			return 0, 0, fmt.Errorf("non-canonical CBOR integer encoding")
+		}
+	case low == 26:
+		if _, err := io.ReadFull(d.r, buf[:4]); err != nil {
+			return 0, 0, unexpectedEOF(err)
+		}
+		val = uint64(binary.BigEndian.Uint32(buf[:4]))
+		if val <= math.MaxUint16 {
+			return 0, 0, fmt.Errorf("non-canonical CBOR integer encoding")
+		}
+	case low == 27:
+		if _, err := io.ReadFull(d.r, buf[:8]); err != nil {
+			return 0, 0, unexpectedEOF(err)
+		}
+		val = binary.BigEndian.Uint64(buf[:8])
+		if val <= math.MaxUint32 {
+			return 0, 0, fmt.Errorf("non-canonical CBOR integer encoding")
+		}
+	default:
+		return 0, 0, fmt.Errorf("indefinite-length or reserved CBOR encoding not allowed: 0x%x", first)
+	}
+	return maj, val, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (d *cborDecoder) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(d.limits.MaxSize) {
+		return nil, fmt.Errorf("%w: %d byte string", ErrCBORTooLarge, n)
+	}
+	// don't trust the length prefix for allocation; read incrementally
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, d.r, int64(n)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *cborDecoder) decodeValue(depth int) (any, error) {
+	maj, val, err := d.readHeader()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	switch maj {
+	case cborMajUint:
+		if val > math.MaxInt64 {
+			return nil, fmt.Errorf("integer out of int64 range")
+		}
+		return int64(val), nil
+	case cborMajNegInt:
+		if val > math.MaxInt64 {
+			return nil, fmt.Errorf("integer out of int64 range")
+		}
+		return -1 - int64(val), nil
+	case cborMajBytes:
+		b, err := d.readBytes(val)
+		if err != nil {
+			return nil, err
+		}
+		return Bytes(b), nil
+	case cborMajString:
+		b, err := d.readBytes(val)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case cborMajArray:
+		if err := d.checkContainer(depth, val); err != nil {
+			return nil, err
+		}
+		out := make([]any, 0)
+		for i := uint64(0); i < val; i++ {
+			elem, err := d.decodeValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, elem)
+		}
+		return out, nil
+	case cborMajMap:
+		if err := d.checkContainer(depth, val); err != nil {
+			return nil, err
+		}
+		obj := make(map[string]any)
+		var prev string
+		for i := uint64(0); i < val; i++ {
+			kmaj, klen, err := d.readHeader()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			if kmaj != cborMajString {
+				return nil, fmt.Errorf("CBOR map keys must be strings")
+			}
+			kb, err := d.readBytes(klen)
+			if err != nil {
+				return nil, err
+			}
+			k := string(kb)
+			if i > 0 && !cborKeyLess(prev, k) {
+				return nil, fmt.Errorf("CBOR map keys not in canonical order (or duplicated): %q", k)
+			}
+			prev = k
+			elem, err := d.decodeValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			obj[k] = elem
+		}
+		if typ, ok := obj["$type"]; ok && typ == "blob" {
+			return blobFromCBORMap(obj)
+		}
+		return obj, nil
+	case cborMajTag:
+		if val != cborTagCID {
+			return nil, fmt.Errorf("unsupported CBOR tag: %d", val)
+		}
+		bmaj, blen, err := d.readHeader()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if bmaj != cborMajBytes {
+			return nil, fmt.Errorf("CID tag must wrap a byte string")
+		}
+		b, err := d.readBytes(blen)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < 2 || b[0] != 0 {
+			return nil, fmt.Errorf("CBOR CIDs must have binary multibase prefix")
+		}
+		c, err := cid.Cast(b[1:])
+		if err != nil {
+			return nil, err
+		}
+		return CIDLink(c), nil
+	case cborMajOther:
+		switch val {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("unsupported CBOR simple value: %d", val)
+		}
+	}
+	return nil, fmt.Errorf("unexpected CBOR major type: %d", maj)
+}
+
+func (d *cborDecoder) checkContainer(depth int, count uint64) error {
+	if depth > d.limits.MaxDepth {
+		return fmt.Errorf("%w (%d)", ErrCBORTooDeep, d.limits.MaxDepth)
+	}
+	// every entry takes at least one byte
+	if count > uint64(d.limits.MaxSize) {
+		return fmt.Errorf("%w: %d entries", ErrCBORTooLarge, count)
+	}
+	return nil
+}
+
+func blobFromCBORMap(obj map[string]any) (Blob, error) {
+	b, _, err := blobFromMap(obj)
+	return b, err
+}
+
+// DAG-CBOR canonical map key ordering: length first, then bytewise
+func cborKeyLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func writeCBORHeader(buf *bytes.Buffer, maj byte, val uint64) {
+	var b [9]byte
+	switch {
+	case val < 24:
+		buf.WriteByte(maj<<5 | byte(val))
+	case val <= math.MaxUint8:
+		buf.Write([]byte{maj<<5 | 24, byte(val)})
+	case val <= math.MaxUint16:
+		b[0] = maj<<5 | 25
+		binary.BigEndian.PutUint16(b[1:3], uint16(val))
+		buf.Write(b[:3])
+	case val <= math.MaxUint32:
+		b[0] = maj<<5 | 26
+		binary.BigEndian.PutUint32(b[1:5], uint32(val))
+		buf.Write(b[:5])
+	default:
+		b[0] = maj<<5 | 27
+		binary.BigEndian.PutUint64(b[1:9], val)
+		buf.Write(b[:9])
+	}
+}
+
+func writeCBORInt(buf *bytes.Buffer, n int64) {
+	if n >= 0 {
+		writeCBORHeader(buf, cborMajUint, uint64(n))
+	} else {
+		writeCBORHeader(buf, cborMajNegInt, uint64(-1-n))
+	}
+}
+
+func writeCBORLink(buf *bytes.Buffer, c cid.Cid) error {
+	if !c.Defined() {
+		return fmt.Errorf("undefined cid-link can not be encoded")
+	}
+	raw := c.Bytes()
+	writeCBORHeader(buf, cborMajTag, cborTagCID)
+	writeCBORHeader(buf, cborMajBytes, uint64(len(raw)+1))
+	buf.WriteByte(0)
+	buf.Write(raw)
+	return nil
+}
+
+func encodeCBOR(buf *bytes.Buffer, v any) error {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteByte(cborMajOther<<5 | 22)
+	case bool:
+		if val {
+			buf.WriteByte(cborMajOther<<5 | 21)
+		} else {
+			buf.WriteByte(cborMajOther<<5 | 20)
+		}
+	case string:
+		writeCBORHeader(buf, cborMajString, uint64(len(val)))
+		buf.WriteString(val)
+	case int:
+		writeCBORInt(buf, int64(val))
+	case int64:
+		writeCBORInt(buf, val)
+	case float64:
+		if val != math.Trunc(val) || math.Abs(val) > maxSafeFloatInt {
+			return fmt.Errorf("non-integer numbers not allowed in atproto data: %v", val)
+		}
+		writeCBORInt(buf, int64(val))
+	case CIDLink:
+		return writeCBORLink(buf, cid.Cid(val))
+	case cid.Cid:
+		return writeCBORLink(buf, val)
+	case Bytes:
+		writeCBORHeader(buf, cborMajBytes, uint64(len(val)))
+		buf.Write(val)
+	case []byte:
+		writeCBORHeader(buf, cborMajBytes, uint64(len(val)))
+		buf.Write(val)
+	case Blob:
+		if val.Size < 0 {
+			return encodeCBOR(buf, map[string]any{
+				"cid":      val.Ref.String(),
+				"mimeType": val.MimeType,
+			})
+		}
+		return encodeCBOR(buf, map[string]any{
+			"$type":    "blob",
+			"mimeType": val.MimeType,
+			"ref":      val.Ref,
+			"size":     val.Size,
+		})
+	case []any:
+		writeCBORHeader(buf, cborMajArray, uint64(len(val)))
+		for _, elem := range val {
+			if err := encodeCBOR(buf, elem); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return cborKeyLess(keys[i], keys[j])
+		})
+		writeCBORHeader(buf, cborMajMap, uint64(len(val)))
+		for _, k := range keys {
+			writeCBORHeader(buf, cborMajString, uint64(len(k)))
+			buf.WriteString(k)
+			if err := encodeCBOR(buf, val[k]); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+		}
+	default:
+		// re-use the canonical JSON type normalization for other integer types, typed slices, etc
+		norm, err := normalizeValue(v)
+		if err != nil {
+			return err
+		}
+		return encodeCBOR(buf, norm)
+	}
+	return nil
+}
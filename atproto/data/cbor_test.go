@@ -0,0 +1,117 @@
+package data
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCBORRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	cborBytes, err := os.ReadFile("testdata/feedpost_record.cbor")
+	assert.NoError(err)
+
+	obj, err := UnmarshalCBOR(cborBytes)
+	assert.NoError(err)
+	assert.Equal("app.bsky.feed.post", obj["$type"])
+	assert.Equal("Who the hell do you think you are", obj["text"])
+
+	refs, err := ExtractBlobs(obj)
+	assert.NoError(err)
+	assert.Equal(1, len(refs))
+	assert.Equal("$.embed.media.images[0].image", refs[0].Path)
+	assert.Equal("bafkreieqq463374bbcbeq7gpmet5rvrpeqow6t4rtjzrkhnlumdylagaqa", refs[0].Ref.String())
+
+	out, err := MarshalCBOR(obj)
+	assert.NoError(err)
+	assert.Equal(cborBytes, out)
+
+	// round-trip through JSON
+	jsonBytes, err := CBORToJSON(cborBytes)
+	assert.NoError(err)
+	again, err := JSONToCBOR(jsonBytes)
+	assert.NoError(err)
+	assert.Equal(cborBytes, again)
+
+	c1, err := ComputeCID(cborBytes)
+	assert.NoError(err)
+	c2, err := ComputeCID(again)
+	assert.NoError(err)
+	assert.Equal(c1, c2)
+	assert.Equal("bafyrei", c1.String()[:7])
+}
+
+func TestCBORInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	invalid := [][]byte{
+		// not a map
+		{0x01},
+		// float value
+		{0xa1, 0x61, 0x61, 0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0},
+		// non-canonical integer
+		{0xa1, 0x61, 0x61, 0x18, 0x01},
+		// keys out of order
+		{0xa2, 0x62, 0x61, 0x61, 0x01, 0x61, 0x62, 0x01},
+		// indefinite length map
+		{0xbf, 0x61, 0x61, 0x01, 0xff},
+		// trailing bytes
+		{0xa0, 0x00},
+		// truncated
+		{0xa1, 0x61},
+		// string length prefix way beyond data
+		{0xa1, 0x61, 0x61, 0x7b, 0x0f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	for _, b := range invalid {
+		_, err := UnmarshalCBOR(b)
+		assert.Error(err, "%x", b)
+	}
+}
+
+func TestCBORDecodeLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	cborBytes, err := os.ReadFile("testdata/feedpost_record.cbor")
+	assert.NoError(err)
+
+	// exactly at the size limit is fine
+	limits := DecodeLimits{MaxSize: int64(len(cborBytes)), MaxDepth: 8}
+	_, err = UnmarshalCBORReader(bytes.NewReader(cborBytes), limits)
+	assert.NoError(err)
+
+	limits.MaxSize = int64(len(cborBytes) - 1)
+	_, err = UnmarshalCBORReader(bytes.NewReader(cborBytes), limits)
+	assert.ErrorIs(err, ErrCBORTooLarge)
+
+	limits = DecodeLimits{MaxSize: 4096, MaxDepth: 3}
+	_, err = UnmarshalCBORReader(bytes.NewReader(cborBytes), limits)
+	assert.ErrorIs(err, ErrCBORTooDeep)
+
+	// unset limits are the defaults, not zero
+	_, err = UnmarshalCBORReader(bytes.NewReader(cborBytes), DecodeLimits{})
+	assert.NoError(err)
+	_, err = UnmarshalCBORReader(bytes.NewReader(cborBytes), DecodeLimits{MaxDepth: 8})
+	assert.NoError(err)
+	_, err = UnmarshalCBORReader(bytes.NewReader(cborBytes), DecodeLimits{MaxDepth: 3})
+	assert.ErrorIs(err, ErrCBORTooDeep)
+
+	// deeply nested arrays: {"a": [[[[...]]]]}
+	nested := []byte{0xa1, 0x61, 0x61}
+	for i := 0; i < 1000; i++ {
+		nested = append(nested, 0x81)
+	}
+	nested = append(nested, 0x01)
+	_, err = UnmarshalCBOR(nested)
+	assert.ErrorIs(err, ErrCBORTooDeep)
+
+	// length prefixes larger than the limit are rejected without reading
+	hugeArray := []byte{0xa1, 0x61, 0x61, 0x9b, 0x0f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	_, err = UnmarshalCBOR(hugeArray)
+	assert.ErrorIs(err, ErrCBORTooLarge)
+	hugeBytes := []byte{0xa1, 0x61, 0x61, 0x5a, 0x0f, 0xff, 0xff, 0xff}
+	_, err = UnmarshalCBOR(hugeBytes)
+	assert.ErrorIs(err, ErrCBORTooLarge)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+
+	"github.com/urfave/cli/v2"
+)
+
+func main() {
+	app := cli.App{
+		Name:  "atp-data",
+		Usage: "informal debugging CLI tool for atproto data (records and other DAG-CBOR blocks)",
+	}
+	app.Commands = []*cli.Command{
+		&cli.Command{
+			Name:      "inspect",
+			Usage:     "parse a JSON or DAG-CBOR object (auto-detected), print CID, round-trip status, and pretty JSON",
+			ArgsUsage: "<file or '-'>",
+			Action:    runInspect,
+		},
+		&cli.Command{
+			Name:      "cbor-to-json",
+			Usage:     "convert a DAG-CBOR object to atproto JSON",
+			ArgsUsage: "<file or '-'>",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "compact",
+					Usage: "output canonical (whitespace-free) JSON",
+				},
+			},
+			Action: runCBORToJSON,
+		},
+		&cli.Command{
+			Name:      "json-to-cbor",
+			Usage:     "convert an atproto JSON object to DAG-CBOR",
+			ArgsUsage: "<file or '-'>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "output",
+					Aliases:  []string{"o"},
+					Usage:    "path to write CBOR bytes to ('-' for stdout)",
+					Required: true,
+				},
+			},
+			Action: runJSONToCBOR,
+		},
+		&cli.Command{
+			Name:      "cid",
+			Usage:     "compute the CID of a JSON or DAG-CBOR object (auto-detected)",
+			ArgsUsage: "<file or '-'>",
+			Action:    runCID,
+		},
+	}
+	h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	slog.SetDefault(slog.New(h))
+	app.RunAndExitOnError()
+}
+
+func readInput(cctx *cli.Context) ([]byte, error) {
+	p := cctx.Args().First()
+	if p == "" {
+		return nil, fmt.Errorf("need to provide file path (or '-' for stdin) as an argument")
+	}
+	if p == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(p)
+}
+
+// JSON objects always start with '{' (after whitespace); DAG-CBOR maps never do
+func isJSON(b []byte) bool {
+	trimmed := bytes.TrimSpace(b)
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// parses input in either format, returning the object and DAG-CBOR encoding
+func parseInput(b []byte) (map[string]any, []byte, error) {
+	var obj map[string]any
+	var err error
+	if isJSON(b) {
+		obj, err = data.UnmarshalJSON(b)
+	} else {
+		obj, err = data.UnmarshalCBOR(b)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	cborBytes, err := data.MarshalCBOR(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	return obj, cborBytes, nil
+}
+
+func prettyJSON(obj map[string]any) ([]byte, error) {
+	b, err := data.MarshalCanonicalJSON(obj)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, b, "", "  "); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func runInspect(cctx *cli.Context) error {
+	b, err := readInput(cctx)
+	if err != nil {
+		return err
+	}
+	obj, cborBytes, err := parseInput(b)
+	if err != nil {
+		return err
+	}
+	c, err := data.ComputeCID(cborBytes)
+	if err != nil {
+		return err
+	}
+
+	format := "dag-cbor"
+	if isJSON(b) {
+		format = "json"
+	}
+	fmt.Printf("format: %s\n", format)
+	fmt.Printf("cid: %s\n", c)
+	fmt.Printf("cbor size: %d bytes\n", len(cborBytes))
+	typ, _ := obj["$type"].(string)
+	if typ != "" {
+		fmt.Printf("$type: %s\n", typ)
+	}
+
+	// verify that re-encoding is stable
+	again, err := data.UnmarshalCBOR(cborBytes)
+	if err != nil {
+		return fmt.Errorf("re-parsing encoded CBOR: %w", err)
+	}
+	againBytes, err := data.MarshalCBOR(again)
+	if err != nil {
+		return err
+	}
+	stable := bytes.Equal(cborBytes, againBytes)
+	if format == "dag-cbor" {
+		stable = stable && bytes.Equal(b, cborBytes)
+	}
+	if stable {
+		fmt.Println("round-trip: ok")
+	} else {
+		fmt.Println("round-trip: MISMATCH (input was not in canonical form)")
+	}
+
+	blobs, err := data.ExtractBlobs(obj)
+	if err != nil {
+		return err
+	}
+	for _, ref := range blobs {
+		fmt.Printf("blob: %s %s %s (%d bytes)\n", ref.Path, ref.Ref, ref.MimeType, ref.Size)
+	}
+
+	pretty, err := prettyJSON(obj)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(pretty))
+	return nil
+}
+
+func runCBORToJSON(cctx *cli.Context) error {
+	b, err := readInput(cctx)
+	if err != nil {
+		return err
+	}
+	obj, err := data.UnmarshalCBOR(b)
+	if err != nil {
+		return err
+	}
+	c, err := data.ComputeCID(b)
+	if err != nil {
+		return err
+	}
+	slog.Info("parsed CBOR", "cid", c)
+
+	var out []byte
+	if cctx.Bool("compact") {
+		out, err = data.MarshalCanonicalJSON(obj)
+	} else {
+		out, err = prettyJSON(obj)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func runJSONToCBOR(cctx *cli.Context) error {
+	b, err := readInput(cctx)
+	if err != nil {
+		return err
+	}
+	cborBytes, err := data.JSONToCBOR(b)
+	if err != nil {
+		return err
+	}
+	c, err := data.ComputeCID(cborBytes)
+	if err != nil {
+		return err
+	}
+
+	p := cctx.String("output")
+	if p == "-" {
+		_, err = os.Stdout.Write(cborBytes)
+	} else {
+		err = os.WriteFile(p, cborBytes, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("wrote CBOR", "cid", c, "size", len(cborBytes))
+	return nil
+}
+
+func runCID(cctx *cli.Context) error {
+	b, err := readInput(cctx)
+	if err != nil {
+		return err
+	}
+	_, cborBytes, err := parseInput(b)
+	if err != nil {
+		return err
+	}
+	c, err := data.ComputeCID(cborBytes)
+	if err != nil {
+		return err
+	}
+	fmt.Println(c)
+	return nil
+}
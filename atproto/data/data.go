@@ -0,0 +1,219 @@
+package data
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Represents a "cid-link" in the atproto data model.
+//
+// Serialized as an object with a single "$link" field (string CID) in JSON, and as a tag-42 CID in DAG-CBOR.
+type CIDLink cid.Cid
+
+// Represents a byte array in the atproto data model.
+//
+// Serialized as an object with a single "$bytes" field (base64 string, no padding) in JSON, and as a native byte string in DAG-CBOR.
+type Bytes []byte
+
+// Represents a blob reference in the atproto data model.
+//
+// A Size of -1 indicates a "legacy" blob reference, which has a string CID and no size.
+type Blob struct {
+	Ref      CIDLink
+	MimeType string
+	Size     int64
+}
+
+func (ll CIDLink) String() string {
+	return cid.Cid(ll).String()
+}
+
+func (ll CIDLink) Defined() bool {
+	return cid.Cid(ll).Defined()
+}
+
+// Parses JSON bytes in to a generic atproto data model object.
+//
+// The top-level value must be an object. Nested "$link", "$bytes", and blob objects are converted to [CIDLink], [Bytes], and [Blob] respectively. Numbers are parsed as int64; non-integer numbers are an error.
+func UnmarshalJSON(b []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var raw any
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected trailing data after JSON object")
+	}
+	obj, ok := raw.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected JSON object at top level")
+	}
+	out, err := parseJSONObject(obj)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := out.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("top-level JSON object can not be a special atproto type")
+	}
+	return m, nil
+}
+
+func parseJSONValue(v any) (any, error) {
+	switch val := v.(type) {
+	case nil, bool, string:
+		return val, nil
+	case json.Number:
+		i, err := val.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("non-integer numbers not allowed in atproto data: %s", val)
+		}
+		return i, nil
+	case []any:
+		out := make([]any, len(val))
+		for i, elem := range val {
+			p, err := parseJSONValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = p
+		}
+		return out, nil
+	case map[string]any:
+		return parseJSONObject(val)
+	default:
+		return nil, fmt.Errorf("unexpected JSON value type: %T", v)
+	}
+}
+
+func parseJSONObject(obj map[string]any) (any, error) {
+	if link, ok := obj["$link"]; ok && len(obj) == 1 {
+		s, ok := link.(string)
+		if !ok {
+			return nil, fmt.Errorf("$link field must be a string")
+		}
+		c, err := cid.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing $link CID: %w", err)
+		}
+		return CIDLink(c), nil
+	}
+	if raw, ok := obj["$bytes"]; ok && len(obj) == 1 {
+		s, ok := raw.(string)
+		if !ok {
+			return nil, fmt.Errorf("$bytes field must be a string")
+		}
+		b, err := base64.RawStdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing $bytes base64: %w", err)
+		}
+		return Bytes(b), nil
+	}
+	if typ, ok := obj["$type"]; ok && typ == "blob" {
+		return parseJSONBlob(obj)
+	}
+
+	out := make(map[string]any, len(obj))
+	for k, v := range obj {
+		p, err := parseJSONValue(v)
+		if err != nil {
+			return nil, err
+		}
+		out[k] = p
+	}
+	return out, nil
+}
+
+func parseJSONBlob(obj map[string]any) (Blob, error) {
+	var blob Blob
+	ref, ok := obj["ref"].(map[string]any)
+	if !ok {
+		return blob, fmt.Errorf("blob missing 'ref' object")
+	}
+	link, err := parseJSONObject(ref)
+	if err != nil {
+		return blob, err
+	}
+	ll, ok := link.(CIDLink)
+	if !ok {
+		return blob, fmt.Errorf("blob 'ref' must be a $link")
+	}
+	blob.Ref = ll
+	mimeType, ok := obj["mimeType"].(string)
+	if !ok || mimeType == "" {
+		return blob, fmt.Errorf("blob missing 'mimeType'")
+	}
+	blob.MimeType = mimeType
+	num, ok := obj["size"].(json.Number)
+	if !ok {
+		return blob, fmt.Errorf("blob missing 'size'")
+	}
+	size, err := num.Int64()
+	if err != nil || size < 0 {
+		return blob, fmt.Errorf("blob 'size' must be a non-negative integer")
+	}
+	blob.Size = size
+	return blob, nil
+}
+
+// converts less common golang types (other integer widths, pointers, typed slices and maps) to the basic set of data model types
+func normalizeValue(v any) (any, error) {
+	if n, ok := v.(json.Number); ok {
+		i, err := n.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("non-integer numbers not allowed in atproto data: %s", n)
+		}
+		return i, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("integer out of int64 range")
+		}
+		return int64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return rv.Elem().Interface(), nil
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, nil
+		}
+		elems := make([]any, rv.Len())
+		for i := range elems {
+			elems[i] = rv.Index(i).Interface()
+		}
+		return elems, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map keys must be strings: %T", v)
+		}
+		if rv.IsNil() {
+			return nil, nil
+		}
+		obj := make(map[string]any, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			obj[iter.Key().String()] = iter.Value().Interface()
+		}
+		return obj, nil
+	}
+	return nil, fmt.Errorf("unsupported type for atproto data: %T", v)
+}
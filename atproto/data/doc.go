@@ -0,0 +1,6 @@
+// Package data supports schema-less serialization and deserialization of atproto data
+//
+// The atproto data model is a subset of the IPLD data model, with a small number of special types ("CID links", "bytes", and "blobs") which have distinct representations in JSON and CBOR. This package provides golang types for those special types, and helpers for working with generic (map-of-interfaces) record data without needing Lexicon-generated code.
+//
+// Data model is specified at: https://atproto.com/specs/data-model
+package data
@@ -16,6 +16,7 @@ import (
 type CacheDirectory struct {
 	Inner             Directory
 	ErrTTL            time.Duration
+	HitTTL            time.Duration
 	handleCache       *expirable.LRU[syntax.Handle, HandleEntry]
 	identityCache     *expirable.LRU[syntax.DID, IdentityEntry]
 	didLookupChans    sync.Map
@@ -70,6 +71,7 @@ var _ Directory = (*CacheDirectory)(nil)
 func NewCacheDirectory(inner Directory, capacity int, hitTTL, errTTL time.Duration) CacheDirectory {
 	return CacheDirectory{
 		ErrTTL:        errTTL,
+		HitTTL:        hitTTL,
 		Inner:         inner,
 		handleCache:   expirable.NewLRU[syntax.Handle, HandleEntry](capacity, nil, hitTTL),
 		identityCache: expirable.NewLRU[syntax.DID, IdentityEntry](capacity, nil, hitTTL),
@@ -80,6 +82,10 @@ func (d *CacheDirectory) IsHandleStale(e *HandleEntry) bool {
 	if e.Err != nil && time.Since(e.Updated) > d.ErrTTL {
 		return true
 	}
+	// entries loaded from a snapshot were resolved before they were added
+	if d.HitTTL > 0 && time.Since(e.Updated) > d.HitTTL {
+		return true
+	}
 	return false
 }
 
@@ -87,6 +93,9 @@ func (d *CacheDirectory) IsIdentityStale(e *IdentityEntry) bool {
 	if e.Err != nil && time.Since(e.Updated) > d.ErrTTL {
 		return true
 	}
+	if d.HitTTL > 0 && time.Since(e.Updated) > d.HitTTL {
+		return true
+	}
 	return false
 }
 
@@ -65,7 +65,8 @@ func (d *BaseDirectory) ResolveDIDWeb(ctx context.Context, did syntax.DID) (*DID
 		return nil, fmt.Errorf("did:web hostname has disallowed TLD: %s", hostname)
 	}
 
-	// TODO: use a more robust client
+	// NOTE: hostnames are attacker-controlled; services should give the
+	// directory an HTTPClient which refuses to connect to internal addresses
 	// TODO: allow ctx to specify unsafe http:// resolution, for testing?
 
 	if d.DIDWebLimitFunc != nil {
@@ -74,7 +75,11 @@ func (d *BaseDirectory) ResolveDIDWeb(ctx context.Context, did syntax.DID) (*DID
 		}
 	}
 
-	resp, err := http.Get("https://" + hostname + "/.well-known/did.json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+hostname+"/.well-known/did.json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.HTTPClient.Do(req)
 	// look for NXDOMAIN
 	var dnsErr *net.DNSError
 	if errors.As(err, &dnsErr) {
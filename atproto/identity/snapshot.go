@@ -0,0 +1,172 @@
+package identity
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Version of the snapshot file format written by [CacheDirectory.WriteSnapshot]
+const snapshotVersion = 1
+
+// A snapshot is gzipped JSON lines: a header, then one line per identity,
+// least recently used first.
+type snapshotHeader struct {
+	Version int       `json:"v"`
+	Created time.Time `json:"created"`
+	Count   int       `json:"count"`
+}
+
+type snapshotEntry struct {
+	DID         syntax.DID         `json:"did"`
+	Handle      syntax.Handle      `json:"handle,omitempty"`
+	AlsoKnownAs []string           `json:"aka,omitempty"`
+	Services    map[string]Service `json:"svc,omitempty"`
+	Keys        map[string]Key     `json:"keys,omitempty"`
+	Updated     time.Time          `json:"t"`
+}
+
+// WriteSnapshot writes every successfully resolved identity in the cache to w,
+// so that another process can start with a warm cache using LoadSnapshot.
+// Failed lookups aren't included. Returns the number of identities written.
+func (d *CacheDirectory) WriteSnapshot(w io.Writer) (int, error) {
+	var entries []snapshotEntry
+	// from the oldest (least recently used) to newest, so that loading keeps
+	// the same order. Values pads the slice with zero entries in place of
+	// expired ones, which the nil check skips.
+	for _, e := range d.identityCache.Values() {
+		if e.Err != nil || e.Identity == nil || d.IsIdentityStale(&e) {
+			continue
+		}
+		ident := e.Identity
+		entries = append(entries, snapshotEntry{
+			DID:         ident.DID,
+			Handle:      ident.Handle,
+			AlsoKnownAs: ident.AlsoKnownAs,
+			Services:    ident.Services,
+			Keys:        ident.Keys,
+			Updated:     e.Updated,
+		})
+	}
+
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion, Created: time.Now().UTC(), Count: len(entries)}); err != nil {
+		return 0, err
+	}
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return i, err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// LoadSnapshot adds the identities in a snapshot (from WriteSnapshot) to the
+// cache. Identities resolved more than maxAge ago are skipped, as are any
+// older than the cache's own HitTTL; zero means no limit. Identities already
+// in the cache are only replaced by newer ones. Returns the number loaded.
+func (d *CacheDirectory) LoadSnapshot(r io.Reader, maxAge time.Duration) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("reading identity snapshot: %w", err)
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(bufio.NewReader(gz))
+
+	var hdr snapshotHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return 0, fmt.Errorf("reading identity snapshot header: %w", err)
+	}
+	if hdr.Version != snapshotVersion {
+		return 0, fmt.Errorf("unsupported identity snapshot version: %d", hdr.Version)
+	}
+
+	now := time.Now()
+	loaded := 0
+	for {
+		var e snapshotEntry
+		if err := dec.Decode(&e); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return loaded, fmt.Errorf("reading identity snapshot entry: %w", err)
+		}
+		age := now.Sub(e.Updated)
+		if (maxAge > 0 && age > maxAge) || (d.HitTTL > 0 && age > d.HitTTL) {
+			continue
+		}
+		if _, err := syntax.ParseDID(e.DID.String()); err != nil {
+			continue
+		}
+		if existing, ok := d.identityCache.Peek(e.DID); ok && !existing.Updated.Before(e.Updated) {
+			continue
+		}
+
+		handle := e.Handle
+		if _, err := syntax.ParseHandle(handle.String()); err != nil {
+			handle = syntax.HandleInvalid
+		}
+		ident := Identity{
+			DID:         e.DID,
+			Handle:      handle,
+			AlsoKnownAs: e.AlsoKnownAs,
+			Services:    e.Services,
+			Keys:        e.Keys,
+		}
+		d.identityCache.Add(e.DID, IdentityEntry{Updated: e.Updated, Identity: &ident})
+		if !handle.IsInvalidHandle() {
+			d.handleCache.Add(handle, HandleEntry{Updated: e.Updated, DID: e.DID})
+		}
+		loaded++
+	}
+	return loaded, nil
+}
+
+// SaveSnapshotFile writes a snapshot to path, replacing any existing file only
+// once the new snapshot is complete.
+func (d *CacheDirectory) SaveSnapshotFile(path string) (int, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := d.WriteSnapshot(f)
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// LoadSnapshotFile loads a snapshot written by SaveSnapshotFile. A missing
+// file is not an error, so this can be called unconditionally at startup.
+func (d *CacheDirectory) LoadSnapshotFile(path string, maxAge time.Duration) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return d.LoadSnapshot(f, maxAge)
+}
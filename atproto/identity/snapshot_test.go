@@ -0,0 +1,124 @@
+package identity
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheSnapshot(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	inner := NewMockDirectory()
+	id1 := Identity{
+		DID:         syntax.DID("did:plc:abc111"),
+		Handle:      syntax.Handle("handle.example.com"),
+		AlsoKnownAs: []string{"at://handle.example.com"},
+		Services: map[string]Service{
+			"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: "https://pds.example.com"},
+		},
+		Keys: map[string]Key{
+			"atproto": {Type: "Multikey", PublicKeyMultibase: "zQ3shunBKsXixLxKtC5qeSG9E4J5RkGN57im31pcTzbNQnm5w"},
+		},
+	}
+	id2 := Identity{
+		DID:    syntax.DID("did:plc:abc222"),
+		Handle: syntax.HandleInvalid,
+	}
+	inner.Insert(id1)
+	inner.Insert(id2)
+
+	warm := NewCacheDirectory(&inner, 100, time.Hour, time.Minute)
+	_, err := warm.LookupHandle(ctx, id1.Handle)
+	assert.NoError(err)
+	_, err = warm.LookupDID(ctx, id2.DID)
+	assert.NoError(err)
+	// failed lookups aren't snapshotted
+	warm.LookupDID(ctx, syntax.DID("did:plc:abc999"))
+
+	var buf bytes.Buffer
+	n, err := warm.WriteSnapshot(&buf)
+	assert.NoError(err)
+	assert.Equal(2, n)
+
+	// a fresh cache in front of an empty directory can resolve everything
+	// from the snapshot
+	empty := NewMockDirectory()
+	cold := NewCacheDirectory(&empty, 100, time.Hour, time.Minute)
+	n, err = cold.LoadSnapshot(bytes.NewReader(buf.Bytes()), 0)
+	assert.NoError(err)
+	assert.Equal(2, n)
+
+	out, err := cold.LookupHandle(ctx, id1.Handle)
+	assert.NoError(err)
+	assert.Equal(&id1, out)
+	out, err = cold.LookupDID(ctx, id2.DID)
+	assert.NoError(err)
+	assert.True(out.Handle.IsInvalidHandle())
+
+	// and via a file
+	path := filepath.Join(t.TempDir(), "identities.snapshot")
+	n, err = cold.SaveSnapshotFile(path)
+	assert.NoError(err)
+	assert.Equal(2, n)
+	fromFile := NewCacheDirectory(&empty, 100, time.Hour, time.Minute)
+	n, err = fromFile.LoadSnapshotFile(path, 0)
+	assert.NoError(err)
+	assert.Equal(2, n)
+	n, err = fromFile.LoadSnapshotFile(filepath.Join(t.TempDir(), "missing"), 0)
+	assert.NoError(err)
+	assert.Equal(0, n)
+}
+
+func TestCacheSnapshotStaleness(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	src := NewCacheDirectory(nil, 100, 0, time.Minute)
+	old := Identity{DID: syntax.DID("did:plc:abc111"), Handle: syntax.Handle("old.example.com")}
+	recent := Identity{DID: syntax.DID("did:plc:abc222"), Handle: syntax.Handle("recent.example.com")}
+	src.identityCache.Add(old.DID, IdentityEntry{Updated: time.Now().Add(-3 * time.Hour), Identity: &old})
+	src.identityCache.Add(recent.DID, IdentityEntry{Updated: time.Now().Add(-10 * time.Minute), Identity: &recent})
+
+	var buf bytes.Buffer
+	n, err := src.WriteSnapshot(&buf)
+	assert.NoError(err)
+	assert.Equal(2, n)
+
+	// explicit bound
+	empty := NewMockDirectory()
+	dst := NewCacheDirectory(&empty, 100, 0, time.Minute)
+	n, err = dst.LoadSnapshot(bytes.NewReader(buf.Bytes()), time.Hour)
+	assert.NoError(err)
+	assert.Equal(1, n)
+	_, found := dst.identityCache.Peek(old.DID)
+	assert.False(found)
+
+	// the cache's own TTL also applies, including after loading: an entry
+	// resolved 10 minutes ago only has 5 minutes left with a 15 minute TTL
+	dst = NewCacheDirectory(&empty, 100, 15*time.Minute, time.Minute)
+	n, err = dst.LoadSnapshot(bytes.NewReader(buf.Bytes()), 0)
+	assert.NoError(err)
+	assert.Equal(1, n)
+	entry, ok := dst.identityCache.Get(recent.DID)
+	assert.True(ok)
+	assert.False(dst.IsIdentityStale(&entry))
+	entry.Updated = entry.Updated.Add(-6 * time.Minute)
+	assert.True(dst.IsIdentityStale(&entry))
+
+	// newer entries in the cache aren't clobbered
+	newer := Identity{DID: recent.DID, Handle: syntax.Handle("renamed.example.com")}
+	dst.identityCache.Add(newer.DID, IdentityEntry{Updated: time.Now(), Identity: &newer})
+	_, err = dst.LoadSnapshot(bytes.NewReader(buf.Bytes()), 0)
+	assert.NoError(err)
+	out, err := dst.LookupDID(ctx, recent.DID)
+	assert.NoError(err)
+	assert.Equal(newer.Handle, out.Handle)
+}
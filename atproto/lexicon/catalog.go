@@ -0,0 +1,132 @@
+package lexicon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Interface type for a resolver or container of Lexicon schemas, and methods for validating generic data against those schemas.
+type Catalog interface {
+	// Looks up a schema definition by reference (an NSID, optionally with a fragment, eg "app.bsky.feed.post" or "app.bsky.actor.defs#profileView").
+	Resolve(ref string) (*Schema, error)
+}
+
+// A resolved schema definition, with its fully-qualified name.
+type Schema struct {
+	// Fully-qualified reference: NSID plus definition name; "main" definitions have no fragment
+	ID  string
+	Def SchemaDef
+}
+
+// Trivial in-memory Lexicon [Catalog] implementation.
+type BaseCatalog struct {
+	schemas map[string]Schema
+}
+
+func NewBaseCatalog() BaseCatalog {
+	return BaseCatalog{
+		schemas: make(map[string]Schema),
+	}
+}
+
+func (c *BaseCatalog) Resolve(ref string) (*Schema, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("tried to resolve empty string name")
+	}
+	// default to #main if name doesn't have a fragment
+	ref = strings.TrimSuffix(ref, "#main")
+	s, ok := c.schemas[ref]
+	if !ok {
+		return nil, fmt.Errorf("schema not found in catalog: %s", ref)
+	}
+	return &s, nil
+}
+
+// Inserts a schema loaded from a JSON file in to the catalog.
+func (c *BaseCatalog) AddSchemaFile(sf SchemaFile) error {
+	if sf.Lexicon != 1 {
+		return fmt.Errorf("unsupported lexicon language version: %d", sf.Lexicon)
+	}
+	if sf.ID == "" {
+		return fmt.Errorf("schema file missing 'id'")
+	}
+	for frag, def := range sf.Defs {
+		name := sf.ID
+		if frag != "main" {
+			name = sf.ID + "#" + frag
+		}
+		if _, ok := c.schemas[name]; ok {
+			return fmt.Errorf("catalog already contained a schema with name: %s", name)
+		}
+		if err := def.CheckSchema(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		// records and XRPC endpoints can only be the "main" definition in a file
+		if frag != "main" {
+			switch def.Type {
+			case "record", "query", "procedure", "subscription":
+				return fmt.Errorf("%s: %s definitions must be 'main'", name, def.Type)
+			}
+		}
+		qualifyRefs(sf.ID, &def)
+		c.schemas[name] = Schema{
+			ID:  name,
+			Def: def,
+		}
+	}
+	return nil
+}
+
+// Recursively loads all '.json' files from a directory in to the catalog.
+func (c *BaseCatalog) LoadDirectory(dirPath string) error {
+	return filepath.WalkDir(dirPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, ".json") {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var sf SchemaFile
+		if err = json.Unmarshal(b, &sf); err != nil {
+			return fmt.Errorf("parsing %s: %w", p, err)
+		}
+		if err = c.AddSchemaFile(sf); err != nil {
+			return fmt.Errorf("loading %s: %w", p, err)
+		}
+		return nil
+	})
+}
+
+// rewrites local references (eg, "#thing") to fully-qualified references, so definitions can be resolved out of the context of their schema file
+func qualifyRefs(id string, def *SchemaDef) {
+	if strings.HasPrefix(def.Ref, "#") {
+		def.Ref = id + def.Ref
+	}
+	for i, r := range def.Refs {
+		if strings.HasPrefix(r, "#") {
+			def.Refs[i] = id + r
+		}
+	}
+	for _, sub := range []*SchemaDef{def.Record, def.Parameters, def.Items} {
+		if sub != nil {
+			qualifyRefs(id, sub)
+		}
+	}
+	for _, body := range []*SchemaBody{def.Input, def.Output, def.Message} {
+		if body != nil && body.Schema != nil {
+			qualifyRefs(id, body.Schema)
+		}
+	}
+	for k, p := range def.Properties {
+		qualifyRefs(id, &p)
+		def.Properties[k] = p
+	}
+}
@@ -0,0 +1,10 @@
+/*
+Package lexicon implements loading of Lexicon schema files, and validation of schema-less atproto data against those schemas.
+
+Schemas are loaded in to a [Catalog], which resolves references between schema definitions. Data (eg, records parsed with the atproto/data package) can then be validated against a named definition with [ValidateRecord] or [ValidateValue].
+
+Validation can run in one of two modes: [StrictMode] rejects unexpected fields and unrecognized open-union variants, while [LenientMode] accepts them and reports a [ValidationWarning] instead. This allows write paths to enforce a strict policy while ingest pipelines tolerate data from newer Lexicon versions, using the same implementation.
+
+The Lexicon language is specified at: https://atproto.com/specs/lexicon
+*/
+package lexicon
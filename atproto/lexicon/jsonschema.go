@@ -0,0 +1,260 @@
+package lexicon
+
+import (
+	"fmt"
+	"strings"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Converts a Lexicon definition (and all the definitions it references) to a standalone JSON Schema (draft 2020-12) document, describing the atproto JSON representation of the data.
+//
+// Referenced definitions are included under "$defs", keyed by their fully-qualified Lexicon reference. Some Lexicon constraints have no JSON Schema equivalent and are approximated or dropped: string lengths are counted in UTF-8 bytes (Lexicon) versus code points (JSON Schema), so "maxLength" is kept as a looser bound, "minGraphemes" becomes "minLength", and other grapheme limits are omitted. Open unions accept any object with an unrecognized "$type". Objects allow additional properties, following the Lexicon evolution rules.
+//
+// XRPC endpoint definitions can not be converted directly; export their input or output schemas instead.
+func ExportJSONSchema(cat Catalog, ref string) (map[string]any, error) {
+	s, err := cat.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	conv := jsonSchemaConverter{
+		cat:  cat,
+		defs: make(map[string]any),
+	}
+	root, err := conv.convertTop(s)
+	if err != nil {
+		return nil, err
+	}
+	// resolve referenced definitions; converting one may add more
+	for len(conv.pending) > 0 {
+		next := conv.pending[0]
+		conv.pending = conv.pending[1:]
+		rs, err := cat.Resolve(next)
+		if err != nil {
+			return nil, err
+		}
+		out, err := conv.convertTop(rs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", next, err)
+		}
+		conv.defs[next] = out
+	}
+
+	root["$schema"] = jsonSchemaDialect
+	root["$id"] = "lex:" + s.ID
+	if len(conv.defs) > 0 {
+		root["$defs"] = conv.defs
+	}
+	return root, nil
+}
+
+type jsonSchemaConverter struct {
+	cat     Catalog
+	defs    map[string]any
+	pending []string
+}
+
+func (c *jsonSchemaConverter) convertTop(s *Schema) (map[string]any, error) {
+	switch s.Def.Type {
+	case "record":
+		out, err := c.convert(*s.Def.Record)
+		if err != nil {
+			return nil, err
+		}
+		// records always include their $type
+		props, _ := out["properties"].(map[string]any)
+		props["$type"] = map[string]any{"const": strings.TrimSuffix(s.ID, "#main")}
+		out["required"] = append([]string{"$type"}, s.Def.Record.Required...)
+		if s.Def.Description != "" {
+			out["description"] = s.Def.Description
+		}
+		return out, nil
+	case "query", "procedure", "subscription":
+		return nil, fmt.Errorf("XRPC endpoint definitions can not be exported as JSON Schema: %s", s.ID)
+	case "token":
+		out := map[string]any{"const": s.ID}
+		if s.Def.Description != "" {
+			out["description"] = s.Def.Description
+		}
+		return out, nil
+	}
+	return c.convert(s.Def)
+}
+
+func (c *jsonSchemaConverter) ref(ref string) map[string]any {
+	ref = strings.TrimSuffix(ref, "#main")
+	if _, ok := c.defs[ref]; !ok {
+		// placeholder, so each definition is only queued once
+		c.defs[ref] = nil
+		c.pending = append(c.pending, ref)
+	}
+	return map[string]any{"$ref": "#/$defs/" + strings.ReplaceAll(ref, "#", "%23")}
+}
+
+func (c *jsonSchemaConverter) convert(def SchemaDef) (map[string]any, error) {
+	out := map[string]any{}
+	if def.Description != "" {
+		out["description"] = def.Description
+	}
+	switch def.Type {
+	case "null":
+		out["type"] = "null"
+	case "boolean":
+		out["type"] = "boolean"
+		setIfPresent(out, "const", def.Const)
+		setIfPresent(out, "default", def.Default)
+	case "integer":
+		out["type"] = "integer"
+		if def.Minimum != nil {
+			out["minimum"] = *def.Minimum
+		}
+		if def.Maximum != nil {
+			out["maximum"] = *def.Maximum
+		}
+		if len(def.Enum) > 0 {
+			out["enum"] = def.Enum
+		}
+		setIfPresent(out, "const", def.Const)
+		setIfPresent(out, "default", def.Default)
+	case "string":
+		out["type"] = "string"
+		if def.MaxLength != nil {
+			out["maxLength"] = *def.MaxLength
+		}
+		if def.MinGraphemes != nil {
+			out["minLength"] = *def.MinGraphemes
+		}
+		if def.Format != "" {
+			out["format"] = jsonSchemaFormat(def.Format)
+		}
+		if len(def.Enum) > 0 {
+			out["enum"] = def.Enum
+		}
+		if len(def.KnownValues) > 0 {
+			out["examples"] = def.KnownValues
+		}
+		setIfPresent(out, "const", def.Const)
+		setIfPresent(out, "default", def.Default)
+	case "bytes":
+		b := map[string]any{"type": "string", "contentEncoding": "base64"}
+		out["type"] = "object"
+		out["properties"] = map[string]any{"$bytes": b}
+		out["required"] = []string{"$bytes"}
+		out["additionalProperties"] = false
+	case "cid-link":
+		out["type"] = "object"
+		out["properties"] = map[string]any{"$link": map[string]any{"type": "string"}}
+		out["required"] = []string{"$link"}
+		out["additionalProperties"] = false
+	case "blob":
+		mimeType := map[string]any{"type": "string"}
+		if len(def.Accept) > 0 && !contains(def.Accept, "*/*") {
+			var patterns []string
+			for _, a := range def.Accept {
+				if strings.HasSuffix(a, "/*") {
+					patterns = append(patterns, "^"+strings.ReplaceAll(strings.TrimSuffix(a, "*"), "+", `\+`))
+				} else {
+					patterns = append(patterns, "^"+strings.ReplaceAll(a, "+", `\+`)+"$")
+				}
+			}
+			mimeType["pattern"] = strings.Join(patterns, "|")
+		}
+		size := map[string]any{"type": "integer", "minimum": 0}
+		if def.MaxSize != nil {
+			size["maximum"] = *def.MaxSize
+		}
+		out["type"] = "object"
+		out["properties"] = map[string]any{
+			"$type":    map[string]any{"const": "blob"},
+			"ref":      map[string]any{"type": "object", "properties": map[string]any{"$link": map[string]any{"type": "string"}}, "required": []string{"$link"}},
+			"mimeType": mimeType,
+			"size":     size,
+		}
+		out["required"] = []string{"$type", "ref", "mimeType", "size"}
+	case "unknown":
+		out["type"] = "object"
+	case "object", "params":
+		props := map[string]any{}
+		for k, p := range def.Properties {
+			ps, err := c.convert(p)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			if contains(def.Nullable, k) {
+				ps = map[string]any{"anyOf": []any{ps, map[string]any{"type": "null"}}}
+			}
+			props[k] = ps
+		}
+		out["type"] = "object"
+		out["properties"] = props
+		if len(def.Required) > 0 {
+			out["required"] = def.Required
+		}
+	case "array":
+		items, err := c.convert(*def.Items)
+		if err != nil {
+			return nil, err
+		}
+		out["type"] = "array"
+		out["items"] = items
+		if def.MinLength != nil {
+			out["minItems"] = *def.MinLength
+		}
+		if def.MaxLength != nil {
+			out["maxItems"] = *def.MaxLength
+		}
+	case "ref":
+		r := c.ref(def.Ref)
+		if len(out) == 0 {
+			return r, nil
+		}
+		out["allOf"] = []any{r}
+	case "union":
+		var variants []any
+		var known []string
+		for _, ref := range def.Refs {
+			name := strings.TrimSuffix(ref, "#main")
+			known = append(known, name)
+			variants = append(variants, map[string]any{
+				"allOf": []any{
+					c.ref(ref),
+					map[string]any{
+						"properties": map[string]any{"$type": map[string]any{"const": name}},
+						"required":   []string{"$type"},
+					},
+				},
+			})
+		}
+		if !def.Closed {
+			variants = append(variants, map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"$type": map[string]any{"type": "string", "not": map[string]any{"enum": known}},
+				},
+				"required": []string{"$type"},
+			})
+		}
+		out["oneOf"] = variants
+	default:
+		return nil, fmt.Errorf("can not convert %s definition to JSON Schema", def.Type)
+	}
+	return out, nil
+}
+
+func setIfPresent(m map[string]any, k string, v any) {
+	if v != nil {
+		m[k] = v
+	}
+}
+
+// most Lexicon string formats have no JSON Schema equivalent, and are passed through as (ignored) custom formats
+func jsonSchemaFormat(format string) string {
+	switch format {
+	case "datetime":
+		return "date-time"
+	case "uri":
+		return "uri"
+	default:
+		return format
+	}
+}
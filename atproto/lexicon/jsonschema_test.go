@@ -0,0 +1,38 @@
+package lexicon
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportJSONSchema(t *testing.T) {
+	assert := assert.New(t)
+	cat := loadTestCatalog(t)
+
+	doc, err := ExportJSONSchema(cat, "example.lexicon.record")
+	assert.NoError(err)
+	assert.Equal("https://json-schema.org/draft/2020-12/schema", doc["$schema"])
+	assert.Equal("object", doc["type"])
+	assert.Equal([]string{"$type", "text", "createdAt"}, doc["required"])
+
+	props := doc["properties"].(map[string]any)
+	assert.Equal(map[string]any{"const": "example.lexicon.record"}, props["$type"])
+	assert.Equal(map[string]any{"type": "string", "format": "date-time"}, props["createdAt"])
+	assert.Equal(map[string]any{"$ref": "#/$defs/example.lexicon.record%23objB"}, props["other"])
+
+	embed := props["embed"].(map[string]any)
+	assert.Equal(3, len(embed["oneOf"].([]any)))
+	closed := props["closedEmbed"].(map[string]any)
+	assert.Equal(1, len(closed["oneOf"].([]any)))
+
+	defs := doc["$defs"].(map[string]any)
+	assert.Equal(2, len(defs))
+	assert.Contains(defs, "example.lexicon.record#objA")
+	assert.Contains(defs, "example.lexicon.record#objB")
+
+	// output should be serializable
+	_, err = json.Marshal(doc)
+	assert.NoError(err)
+}
@@ -0,0 +1,154 @@
+package lexicon
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Serialization helper for a single Lexicon schema file.
+type SchemaFile struct {
+	Lexicon     int                  `json:"lexicon"`
+	ID          string               `json:"id"`
+	Revision    *int                 `json:"revision,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Defs        map[string]SchemaDef `json:"defs"`
+}
+
+// A single definition within a Lexicon schema file.
+//
+// This is a "flat" representation of all the Lexicon definition types: which fields are relevant depends on the Type. Nested definitions (object properties, array items, etc) use the same struct.
+type SchemaDef struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+
+	// record
+	Key    string     `json:"key,omitempty"`
+	Record *SchemaDef `json:"record,omitempty"`
+
+	// query, procedure, subscription
+	Parameters *SchemaDef    `json:"parameters,omitempty"`
+	Input      *SchemaBody   `json:"input,omitempty"`
+	Output     *SchemaBody   `json:"output,omitempty"`
+	Message    *SchemaBody   `json:"message,omitempty"`
+	Errors     []SchemaError `json:"errors,omitempty"`
+
+	// object, params
+	Required   []string             `json:"required,omitempty"`
+	Nullable   []string             `json:"nullable,omitempty"`
+	Properties map[string]SchemaDef `json:"properties,omitempty"`
+
+	// array
+	Items *SchemaDef `json:"items,omitempty"`
+
+	// string, bytes, array
+	MinLength *int `json:"minLength,omitempty"`
+	MaxLength *int `json:"maxLength,omitempty"`
+
+	// string
+	Format       string   `json:"format,omitempty"`
+	MinGraphemes *int     `json:"minGraphemes,omitempty"`
+	MaxGraphemes *int     `json:"maxGraphemes,omitempty"`
+	KnownValues  []string `json:"knownValues,omitempty"`
+
+	// integer
+	Minimum *int64 `json:"minimum,omitempty"`
+	Maximum *int64 `json:"maximum,omitempty"`
+
+	// string, integer, boolean
+	Enum    []any `json:"enum,omitempty"`
+	Const   any   `json:"const,omitempty"`
+	Default any   `json:"default,omitempty"`
+
+	// blob
+	Accept  []string `json:"accept,omitempty"`
+	MaxSize *int64   `json:"maxSize,omitempty"`
+
+	// ref
+	Ref string `json:"ref,omitempty"`
+
+	// union
+	Refs   []string `json:"refs,omitempty"`
+	Closed bool     `json:"closed,omitempty"`
+}
+
+// Request or response body of an XRPC endpoint definition.
+type SchemaBody struct {
+	Description string     `json:"description,omitempty"`
+	Encoding    string     `json:"encoding,omitempty"`
+	Schema      *SchemaDef `json:"schema,omitempty"`
+}
+
+// Named error which an XRPC endpoint may return.
+type SchemaError struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Checks that the definition is internally consistent: the type is known, and type-specific fields are well-formed. Does not resolve references.
+func (d *SchemaDef) CheckSchema() error {
+	switch d.Type {
+	case "record":
+		if d.Record == nil {
+			return fmt.Errorf("record definition missing 'record' object")
+		}
+		switch d.Key {
+		case "tid", "nsid", "any", "":
+		default:
+			if !strings.HasPrefix(d.Key, "literal:") {
+				return fmt.Errorf("unknown record key type: %s", d.Key)
+			}
+		}
+		return d.Record.CheckSchema()
+	case "query", "procedure", "subscription":
+		if d.Parameters != nil {
+			if err := d.Parameters.CheckSchema(); err != nil {
+				return err
+			}
+		}
+		for _, body := range []*SchemaBody{d.Input, d.Output, d.Message} {
+			if body != nil && body.Schema != nil {
+				if err := body.Schema.CheckSchema(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case "object", "params":
+		for _, k := range d.Required {
+			if _, ok := d.Properties[k]; !ok {
+				return fmt.Errorf("required field not in properties: %s", k)
+			}
+		}
+		for k, p := range d.Properties {
+			if err := p.CheckSchema(); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+		}
+		return nil
+	case "array":
+		if d.Items == nil {
+			return fmt.Errorf("array definition missing 'items'")
+		}
+		return d.Items.CheckSchema()
+	case "ref":
+		if d.Ref == "" {
+			return fmt.Errorf("empty ref")
+		}
+		return nil
+	case "union":
+		if len(d.Refs) == 0 && d.Closed {
+			return fmt.Errorf("closed union with no refs")
+		}
+		return nil
+	case "string", "integer", "boolean", "bytes", "cid-link", "blob", "unknown", "token", "null":
+		if d.MinLength != nil && d.MaxLength != nil && *d.MinLength > *d.MaxLength {
+			return fmt.Errorf("minLength greater than maxLength")
+		}
+		if d.Minimum != nil && d.Maximum != nil && *d.Minimum > *d.Maximum {
+			return fmt.Errorf("minimum greater than maximum")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown definition type: %s", d.Type)
+	}
+}
@@ -0,0 +1,423 @@
+package lexicon
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/rivo/uniseg"
+)
+
+// Policy for handling data which is allowed by the Lexicon language's evolution rules, but not described by the schema being validated against.
+type ValidationMode int
+
+const (
+	// Rejects unexpected object fields and unrecognized open-union variants. Appropriate for write paths, where data should match the current schemas exactly.
+	StrictMode ValidationMode = iota
+	// Allows unexpected object fields and unrecognized open-union variants, reporting them as warnings. Appropriate for ingest pipelines, which may see data created against newer versions of a schema.
+	LenientMode
+)
+
+func (m ValidationMode) String() string {
+	switch m {
+	case StrictMode:
+		return "strict"
+	case LenientMode:
+		return "lenient"
+	default:
+		return "unknown"
+	}
+}
+
+// Non-fatal validation issue, reported in [LenientMode] for data which would be rejected in [StrictMode].
+type ValidationWarning struct {
+	// location of the issue within the data, eg "$.embed.images[0]"
+	Path    string
+	Message string
+}
+
+func (w ValidationWarning) String() string {
+	return w.Path + ": " + w.Message
+}
+
+// Error type for validation failures, including the location of the problem within the data.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+type validator struct {
+	cat      Catalog
+	mode     ValidationMode
+	warnings []ValidationWarning
+}
+
+// Validates generic record data against the "main" record definition of the given collection NSID.
+//
+// The record data is expected to be in the form returned by [data.UnmarshalJSON]. The "$type" field, if present, must match the collection. Returns any warnings (only in [LenientMode]), or an error (typically a [*ValidationError]) if the data is invalid.
+func ValidateRecord(cat Catalog, recordData map[string]any, collection string, mode ValidationMode) ([]ValidationWarning, error) {
+	s, err := cat.Resolve(collection)
+	if err != nil {
+		return nil, err
+	}
+	if s.Def.Type != "record" {
+		return nil, fmt.Errorf("schema is not of record type: %s", collection)
+	}
+	if typ, ok := recordData["$type"]; ok && typ != collection {
+		return nil, &ValidationError{Path: "$", Message: fmt.Sprintf("record $type does not match collection: %v", typ)}
+	}
+	v := validator{cat: cat, mode: mode}
+	if err := v.validateObject(*s.Def.Record, recordData, "$"); err != nil {
+		return nil, err
+	}
+	return v.warnings, nil
+}
+
+// Validates a generic data value against an arbitrary (non-record, non-endpoint) schema definition, referenced by name.
+func ValidateValue(cat Catalog, val any, ref string, mode ValidationMode) ([]ValidationWarning, error) {
+	s, err := cat.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	v := validator{cat: cat, mode: mode}
+	if err := v.validateDef(s.Def, val, "$"); err != nil {
+		return nil, err
+	}
+	return v.warnings, nil
+}
+
+func (v *validator) fail(path, format string, args ...any) error {
+	return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// in strict mode, returns an error; in lenient mode, records a warning
+func (v *validator) unexpected(path, format string, args ...any) error {
+	if v.mode == StrictMode {
+		return v.fail(path, format, args...)
+	}
+	v.warnings = append(v.warnings, ValidationWarning{Path: path, Message: fmt.Sprintf(format, args...)})
+	return nil
+}
+
+func (v *validator) validateDef(def SchemaDef, val any, path string) error {
+	switch def.Type {
+	case "null":
+		if val != nil {
+			return v.fail(path, "expected null")
+		}
+		return nil
+	case "boolean":
+		b, ok := val.(bool)
+		if !ok {
+			return v.fail(path, "expected boolean, got %T", val)
+		}
+		if def.Const != nil && def.Const != b {
+			return v.fail(path, "boolean does not match const value")
+		}
+		return nil
+	case "integer":
+		return v.validateInteger(def, val, path)
+	case "string":
+		return v.validateString(def, val, path)
+	case "bytes":
+		b, ok := val.(data.Bytes)
+		if !ok {
+			return v.fail(path, "expected bytes, got %T", val)
+		}
+		if def.MinLength != nil && len(b) < *def.MinLength {
+			return v.fail(path, "bytes too short (%d < %d)", len(b), *def.MinLength)
+		}
+		if def.MaxLength != nil && len(b) > *def.MaxLength {
+			return v.fail(path, "bytes too long (%d > %d)", len(b), *def.MaxLength)
+		}
+		return nil
+	case "cid-link":
+		if _, ok := val.(data.CIDLink); !ok {
+			return v.fail(path, "expected cid-link, got %T", val)
+		}
+		return nil
+	case "blob":
+		return v.validateBlob(def, val, path)
+	case "unknown":
+		// any object, including blobs (which the data model parses from
+		// objects with "$type": "blob")
+		switch val.(type) {
+		case map[string]any, data.Blob:
+			return nil
+		}
+		return v.fail(path, "expected object for unknown type, got %T", val)
+	case "object":
+		obj, ok := val.(map[string]any)
+		if !ok {
+			return v.fail(path, "expected object, got %T", val)
+		}
+		return v.validateObject(def, obj, path)
+	case "array":
+		arr, ok := val.([]any)
+		if !ok {
+			return v.fail(path, "expected array, got %T", val)
+		}
+		if def.MinLength != nil && len(arr) < *def.MinLength {
+			return v.fail(path, "array too short (%d < %d)", len(arr), *def.MinLength)
+		}
+		if def.MaxLength != nil && len(arr) > *def.MaxLength {
+			return v.fail(path, "array too long (%d > %d)", len(arr), *def.MaxLength)
+		}
+		for i, elem := range arr {
+			if err := v.validateDef(*def.Items, elem, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "ref":
+		s, err := v.cat.Resolve(def.Ref)
+		if err != nil {
+			return v.fail(path, "%s", err)
+		}
+		return v.validateDef(s.Def, val, path)
+	case "union":
+		return v.validateUnion(def, val, path)
+	case "token":
+		return v.fail(path, "tokens can not be used as data values")
+	case "record", "query", "procedure", "subscription", "params":
+		return v.fail(path, "%s definitions can not be validated as data values", def.Type)
+	default:
+		return v.fail(path, "unhandled schema type: %s", def.Type)
+	}
+}
+
+func (v *validator) validateObject(def SchemaDef, obj map[string]any, path string) error {
+	for _, k := range def.Required {
+		if _, ok := obj[k]; !ok {
+			return v.fail(path, "required field missing: %s", k)
+		}
+	}
+	for _, k := range sortedKeys(obj) {
+		val := obj[k]
+		fieldPath := path + "." + k
+		prop, ok := def.Properties[k]
+		if !ok {
+			if k == "$type" {
+				continue
+			}
+			if err := v.unexpected(fieldPath, "unexpected field"); err != nil {
+				return err
+			}
+			continue
+		}
+		if val == nil && contains(def.Nullable, k) {
+			continue
+		}
+		if err := v.validateDef(prop, val, fieldPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *validator) validateUnion(def SchemaDef, val any, path string) error {
+	obj, ok := val.(map[string]any)
+	if !ok {
+		return v.fail(path, "expected object for union, got %T", val)
+	}
+	typ, ok := obj["$type"].(string)
+	if !ok {
+		return v.fail(path, "union data missing $type")
+	}
+	for _, ref := range def.Refs {
+		// "#main" is implied for union refs, and $type values
+		if strings.TrimSuffix(ref, "#main") != strings.TrimSuffix(typ, "#main") {
+			continue
+		}
+		s, err := v.cat.Resolve(ref)
+		if err != nil {
+			return v.fail(path, "%s", err)
+		}
+		return v.validateDef(s.Def, val, path)
+	}
+	if def.Closed {
+		return v.fail(path, "$type not in closed union: %s", typ)
+	}
+	return v.unexpected(path, "unrecognized union variant: %s", typ)
+}
+
+func (v *validator) validateInteger(def SchemaDef, val any, path string) error {
+	var n int64
+	switch i := val.(type) {
+	case int64:
+		n = i
+	case int:
+		n = int64(i)
+	default:
+		return v.fail(path, "expected integer, got %T", val)
+	}
+	if def.Const != nil && !numEqual(def.Const, n) {
+		return v.fail(path, "integer does not match const value")
+	}
+	if len(def.Enum) > 0 {
+		found := false
+		for _, e := range def.Enum {
+			if numEqual(e, n) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return v.fail(path, "integer value not in enum: %d", n)
+		}
+	}
+	if def.Minimum != nil && n < *def.Minimum {
+		return v.fail(path, "integer below minimum (%d < %d)", n, *def.Minimum)
+	}
+	if def.Maximum != nil && n > *def.Maximum {
+		return v.fail(path, "integer above maximum (%d > %d)", n, *def.Maximum)
+	}
+	return nil
+}
+
+func (v *validator) validateString(def SchemaDef, val any, path string) error {
+	s, ok := val.(string)
+	if !ok {
+		return v.fail(path, "expected string, got %T", val)
+	}
+	if def.Const != nil && def.Const != s {
+		return v.fail(path, "string does not match const value")
+	}
+	if len(def.Enum) > 0 {
+		found := false
+		for _, e := range def.Enum {
+			if e == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return v.fail(path, "string value not in enum: %s", s)
+		}
+	}
+	if def.MinLength != nil && len(s) < *def.MinLength {
+		return v.fail(path, "string too short (%d < %d bytes)", len(s), *def.MinLength)
+	}
+	if def.MaxLength != nil && len(s) > *def.MaxLength {
+		return v.fail(path, "string too long (%d > %d bytes)", len(s), *def.MaxLength)
+	}
+	if def.MinGraphemes != nil || def.MaxGraphemes != nil {
+		g := uniseg.GraphemeClusterCount(s)
+		if def.MinGraphemes != nil && g < *def.MinGraphemes {
+			return v.fail(path, "string too short (%d < %d graphemes)", g, *def.MinGraphemes)
+		}
+		if def.MaxGraphemes != nil && g > *def.MaxGraphemes {
+			return v.fail(path, "string too long (%d > %d graphemes)", g, *def.MaxGraphemes)
+		}
+	}
+	if def.Format != "" {
+		if err := checkStringFormat(def.Format, s); err != nil {
+			return v.fail(path, "invalid %s: %s", def.Format, err)
+		}
+	}
+	return nil
+}
+
+func checkStringFormat(format, s string) error {
+	var err error
+	switch format {
+	case "at-identifier":
+		_, err = syntax.ParseAtIdentifier(s)
+	case "at-uri":
+		_, err = syntax.ParseATURI(s)
+	case "cid":
+		_, err = syntax.ParseCID(s)
+	case "datetime":
+		_, err = syntax.ParseDatetime(s)
+	case "did":
+		_, err = syntax.ParseDID(s)
+	case "handle":
+		_, err = syntax.ParseHandle(s)
+	case "nsid":
+		_, err = syntax.ParseNSID(s)
+	case "uri":
+		_, err = syntax.ParseURI(s)
+	case "language":
+		_, err = syntax.ParseLanguage(s)
+	case "tid":
+		_, err = syntax.ParseTID(s)
+	case "record-key":
+		_, err = syntax.ParseRecordKey(s)
+	default:
+		err = fmt.Errorf("unknown string format: %s", format)
+	}
+	return err
+}
+
+func (v *validator) validateBlob(def SchemaDef, val any, path string) error {
+	blob, ok := val.(data.Blob)
+	if !ok {
+		return v.fail(path, "expected blob, got %T", val)
+	}
+	if len(def.Accept) > 0 {
+		accepted := false
+		for _, pattern := range def.Accept {
+			if acceptMimeType(pattern, blob.MimeType) {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return v.fail(path, "blob mimetype not accepted: %s", blob.MimeType)
+		}
+	}
+	if def.MaxSize != nil && blob.Size > *def.MaxSize {
+		return v.fail(path, "blob too large (%d > %d bytes)", blob.Size, *def.MaxSize)
+	}
+	return nil
+}
+
+// matches mimetype patterns like "image/*" or "*/*"
+func acceptMimeType(pattern, mimeType string) bool {
+	if pattern == "*/*" || pattern == mimeType {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mimeType, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
+
+// schema JSON numbers (enums, consts) are parsed as float64
+func numEqual(schemaVal any, n int64) bool {
+	rv := reflect.ValueOf(schemaVal)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == float64(n)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == n
+	}
+	return false
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(obj map[string]any) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
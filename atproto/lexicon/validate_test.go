@@ -0,0 +1,95 @@
+package lexicon
+
+import (
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadTestCatalog(t *testing.T) Catalog {
+	cat := NewBaseCatalog()
+	if err := cat.LoadDirectory("testdata/lexicons"); err != nil {
+		t.Fatal(err)
+	}
+	return &cat
+}
+
+func TestValidateRecord(t *testing.T) {
+	assert := assert.New(t)
+	cat := loadTestCatalog(t)
+
+	valid := []string{
+		`{"$type": "example.lexicon.record", "text": "hello", "createdAt": "2023-01-01T00:00:00Z"}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "count": 3, "note": null, "kind": "a", "tags": ["x", "y"]}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "embed": {"$type": "example.lexicon.record#objA", "a": 1}}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "other": {"b": "did:web:example.com"}}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "link": {"$link": "bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity"}}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "image": {"$type": "blob", "ref": {"$link": "bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity"}, "mimeType": "image/png", "size": 10}}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "meta": {"anything": [1, 2]}}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "meta": {"$type": "blob", "ref": {"$link": "bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity"}, "mimeType": "video/mp4", "size": 10}}`,
+	}
+	for _, raw := range valid {
+		rec, err := data.UnmarshalJSON([]byte(raw))
+		assert.NoError(err)
+		for _, mode := range []ValidationMode{StrictMode, LenientMode} {
+			warnings, err := ValidateRecord(cat, rec, "example.lexicon.record", mode)
+			assert.NoError(err, raw)
+			assert.Empty(warnings)
+		}
+	}
+
+	invalid := []string{
+		`{"$type": "example.lexicon.other", "text": "hello", "createdAt": "2023-01-01T00:00:00Z"}`,
+		`{"createdAt": "2023-01-01T00:00:00Z"}`,
+		`{"text": "this is more than ten graphemes", "createdAt": "2023-01-01T00:00:00Z"}`,
+		`{"text": "hello", "createdAt": "yesterday"}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "count": 11}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "kind": "c"}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "tags": ["a", "b", "c", "d"]}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "embed": {"$type": "example.lexicon.record#objA"}}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "closedEmbed": {"$type": "example.lexicon.record#objB"}}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "other": {"b": "not-a-did"}}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "image": {"$type": "blob", "ref": {"$link": "bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity"}, "mimeType": "video/mp4", "size": 10}}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "link": "bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity"}`,
+		`{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "meta": "not an object"}`,
+	}
+	for _, raw := range invalid {
+		rec, err := data.UnmarshalJSON([]byte(raw))
+		assert.NoError(err)
+		for _, mode := range []ValidationMode{StrictMode, LenientMode} {
+			_, err := ValidateRecord(cat, rec, "example.lexicon.record", mode)
+			assert.Error(err, raw)
+		}
+	}
+}
+
+func TestValidationModes(t *testing.T) {
+	assert := assert.New(t)
+	cat := loadTestCatalog(t)
+
+	raw := `{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "extra": true, "embed": {"$type": "example.lexicon.record#objC", "c": 1}}`
+	rec, err := data.UnmarshalJSON([]byte(raw))
+	assert.NoError(err)
+
+	_, err = ValidateRecord(cat, rec, "example.lexicon.record", StrictMode)
+	assert.Error(err)
+	verr, ok := err.(*ValidationError)
+	assert.True(ok)
+	assert.Equal("$.embed", verr.Path)
+
+	warnings, err := ValidateRecord(cat, rec, "example.lexicon.record", LenientMode)
+	assert.NoError(err)
+	assert.Equal([]ValidationWarning{
+		{Path: "$.embed", Message: "unrecognized union variant: example.lexicon.record#objC"},
+		{Path: "$.extra", Message: "unexpected field"},
+	}, warnings)
+
+	// known variants are still validated in lenient mode
+	raw = `{"text": "hello", "createdAt": "2023-01-01T00:00:00Z", "embed": {"$type": "example.lexicon.record#objA", "a": "one"}}`
+	rec, err = data.UnmarshalJSON([]byte(raw))
+	assert.NoError(err)
+	_, err = ValidateRecord(cat, rec, "example.lexicon.record", LenientMode)
+	assert.Error(err)
+}
@@ -0,0 +1,243 @@
+package backfill
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/util/awssig"
+)
+
+// ErrObjectNotFound is returned by an ObjectStore for keys which don't exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStore is a read-only view of a bucket (or directory) of objects
+type ObjectStore interface {
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// ArchiveEntry is one line of an archive manifest: the object holding a
+// repo's CAR, and optionally the rev it was snapshotted at.
+type ArchiveEntry struct {
+	Did  string `json:"did"`
+	Key  string `json:"key"`
+	Rev  string `json:"rev,omitempty"`
+	Size int64  `json:"size,omitempty"`
+}
+
+// ArchiveSource is a RepoSource which reads pre-fetched repo CARs from object
+// storage, so large re-indexing runs can be done from a snapshot instead of
+// calling getRepo on the live network.
+//
+// The archive is described by a manifest object with one JSON ArchiveEntry
+// per line. Manifest and CAR objects whose keys end in ".gz" are gunzipped.
+type ArchiveSource struct {
+	Store ObjectStore
+	// Fallback, if set, is used for repos which aren't in the manifest
+	Fallback RepoSource
+
+	entries map[string]ArchiveEntry
+}
+
+// NewArchiveSource loads the manifest at manifestKey from the store.
+func NewArchiveSource(ctx context.Context, store ObjectStore, manifestKey string) (*ArchiveSource, error) {
+	rc, err := openObject(ctx, store, manifestKey)
+	if err != nil {
+		return nil, fmt.Errorf("opening archive manifest: %w", err)
+	}
+	defer rc.Close()
+
+	entries := make(map[string]ArchiveEntry)
+	scan := bufio.NewScanner(rc)
+	scan.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scan.Scan() {
+		line++
+		if len(strings.TrimSpace(scan.Text())) == 0 {
+			continue
+		}
+		var ent ArchiveEntry
+		if err := json.Unmarshal(scan.Bytes(), &ent); err != nil {
+			return nil, fmt.Errorf("archive manifest line %d: %w", line, err)
+		}
+		if ent.Did == "" || ent.Key == "" {
+			return nil, fmt.Errorf("archive manifest line %d: missing did or key", line)
+		}
+		entries[ent.Did] = ent
+	}
+	if err := scan.Err(); err != nil {
+		return nil, fmt.Errorf("reading archive manifest: %w", err)
+	}
+
+	return &ArchiveSource{
+		Store:   store,
+		entries: entries,
+	}, nil
+}
+
+// Repos returns the DIDs of all repos in the archive, sorted, so that callers
+// can enqueue jobs for them.
+func (a *ArchiveSource) Repos() []string {
+	out := make([]string, 0, len(a.entries))
+	for did := range a.entries {
+		out = append(out, did)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Entry returns the manifest entry for a repo.
+func (a *ArchiveSource) Entry(did string) (ArchiveEntry, bool) {
+	ent, ok := a.entries[did]
+	return ent, ok
+}
+
+func (a *ArchiveSource) GetRepo(ctx context.Context, did string) (io.ReadCloser, error) {
+	ent, ok := a.entries[did]
+	if !ok {
+		if a.Fallback != nil {
+			return a.Fallback.GetRepo(ctx, did)
+		}
+		return nil, fmt.Errorf("%w: %s is not in the archive", ErrRepoNotFound, did)
+	}
+
+	rc, err := openObject(ctx, a.Store, ent.Key)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil, fmt.Errorf("%w: archive object %s is missing", ErrRepoNotFound, ent.Key)
+		}
+		return nil, err
+	}
+	return rc, nil
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	src io.Closer
+}
+
+func (g gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.src.Close()
+}
+
+// opens an object, transparently decompressing ".gz" keys
+func openObject(ctx context.Context, store ObjectStore, key string) (io.ReadCloser, error) {
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(key, ".gz") {
+		return rc, nil
+	}
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("decompressing %s: %w", key, err)
+	}
+	return gzipReadCloser{Reader: gz, src: rc}, nil
+}
+
+// DirObjectStore is an ObjectStore backed by a local directory, such as a
+// downloaded snapshot or a bucket mounted with a FUSE adapter.
+type DirObjectStore struct {
+	Root string
+}
+
+func (d *DirObjectStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	rel := filepath.FromSlash(key)
+	if !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("invalid object key: %q", key)
+	}
+	fi, err := os.Open(filepath.Join(d.Root, rel))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+		}
+		return nil, err
+	}
+	return fi, nil
+}
+
+// S3Credentials are used to sign requests to S3, or to any service with an
+// S3-compatible API (GCS with HMAC keys, R2, MinIO, ...).
+type S3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Region defaults to us-east-1. GCS accepts "auto".
+	Region string
+}
+
+// HTTPObjectStore is an ObjectStore which fetches objects over HTTP(S) from
+// BaseURL + "/" + key. This covers public or presigned buckets with no
+// credentials, and private S3-compatible buckets when S3 is set, eg:
+//
+//	https://my-bucket.s3.us-west-2.amazonaws.com/snapshots/2024-01-01
+//	https://storage.googleapis.com/my-bucket/snapshots/2024-01-01
+type HTTPObjectStore struct {
+	BaseURL string
+	Client  *http.Client
+	S3      *S3Credentials
+}
+
+func (h *HTTPObjectStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	// escaped the way SigV4 expects, so the path we sign is the path we send
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(h.BaseURL, "/")+"/"+awssig.EscapePath(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.S3 != nil {
+		signS3Request(req, h.S3, time.Now())
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+	default:
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching object %s: status %d: %s", key, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+}
+
+// signS3Request adds AWS Signature Version 4 headers to a request with no
+// body. The host and every header already set are signed.
+func signS3Request(req *http.Request, creds *S3Credentials, now time.Time) {
+	region := creds.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	payloadHash := req.Header.Get("x-amz-content-sha256")
+	if payloadHash == "" {
+		payloadHash = awssig.UnsignedPayload
+		req.Header.Set("x-amz-content-sha256", payloadHash)
+	}
+	awssig.Sign(req, awssig.Credentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}, region, "s3", payloadHash, now)
+}
@@ -4,14 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	// Blank import to register types for CBORGEN
 	_ "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/mst"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/repomgr"
 	"github.com/ipfs/go-cid"
@@ -45,6 +51,13 @@ type Store interface {
 	GetNextEnqueuedJob(ctx context.Context) (Job, error)
 }
 
+// RepoSource provides the CAR for a repo being backfilled
+type RepoSource interface {
+	// GetRepo returns the full repo CAR. It returns an error wrapping
+	// ErrRepoNotFound if the source doesn't have the repo.
+	GetRepo(ctx context.Context, did string) (io.ReadCloser, error)
+}
+
 // Backfiller is a struct which handles backfilling a repo
 type Backfiller struct {
 	Name               string
@@ -62,12 +75,47 @@ type Backfiller struct {
 	NSIDFilter   string
 	CheckoutPath string
 
+	// Collections, if set, limits backfills to records in these collections
+	// (NSIDs), in place of NSIDFilter. Records in other collections are never
+	// decoded or passed to the handlers, and nor are buffered ops for them.
+	// Jobs implementing CollectionsJob can override this per job.
+	Collections []string
+
+	// Source, if set, provides repo CARs instead of fetching them from
+	// CheckoutPath (for example an ArchiveSource reading from a snapshot)
+	Source RepoSource
+
+	// Number of records (or buffered ops) between checkpoints, for jobs
+	// which implement CheckpointJob. Zero disables checkpointing.
+	CheckpointInterval int
+
+	// Directory, if set, is used to fetch each repo from its own PDS rather
+	// than from CheckoutPath
+	Directory identity.Directory
+
+	// RateController, if set, paces getRepo requests per host based on the
+	// rate limit feedback each host gives, in place of the single fixed
+	// SyncRequestsPerSecond limit. ParallelBackfills is still the overall
+	// limit on jobs in progress.
+	RateController *RateController
+
+	// HandleReplayConflict, if set, is called for each conflict found while
+	// replaying buffered commits after a backfill (see ReplayConflict). The
+	// ops are replayed regardless; if unset, conflicts are logged.
+	HandleReplayConflict func(ctx context.Context, repo string, conflict *ReplayConflict)
+
 	syncLimiter *rate.Limiter
 
 	magicHeaderKey string
 	magicHeaderVal string
 
 	stop chan chan struct{}
+
+	progressLk sync.Mutex
+	inFlight   map[string]*jobProgress
+
+	// for walking backfilled repos, one per job in progress
+	nodeCaches mst.NodeCachePool
 }
 
 var (
@@ -85,14 +133,22 @@ var ErrJobComplete = errors.New("job is complete")
 // ErrJobNotFound is returned when trying to buffer an op for a job that doesn't exist
 var ErrJobNotFound = errors.New("job not found")
 
+// ErrRepoNotFound is returned by a RepoSource which doesn't have the requested repo
+var ErrRepoNotFound = errors.New("repo not found")
+
 var tracer = otel.Tracer("backfiller")
 
 type BackfillOptions struct {
 	ParallelBackfills     int
 	ParallelRecordCreates int
 	NSIDFilter            string
+	Collections           []string
 	SyncRequestsPerSecond int
 	CheckoutPath          string
+	CheckpointInterval    int
+	// AdaptiveRateControl sets up a RateController starting each host at
+	// SyncRequestsPerSecond with up to ParallelBackfills concurrent requests
+	AdaptiveRateControl bool
 }
 
 func DefaultBackfillOptions() *BackfillOptions {
@@ -102,6 +158,7 @@ func DefaultBackfillOptions() *BackfillOptions {
 		NSIDFilter:            "",
 		SyncRequestsPerSecond: 2,
 		CheckoutPath:          "https://bsky.social/xrpc/com.atproto.sync.getRepo",
+		CheckpointInterval:    1000,
 	}
 }
 
@@ -117,6 +174,10 @@ func NewBackfiller(
 	if opts == nil {
 		opts = DefaultBackfillOptions()
 	}
+	var rc *RateController
+	if opts.AdaptiveRateControl {
+		rc = NewRateController(float64(opts.SyncRequestsPerSecond), opts.ParallelBackfills)
+	}
 	return &Backfiller{
 		Name:                  name,
 		Store:                 store,
@@ -126,8 +187,11 @@ func NewBackfiller(
 		ParallelBackfills:     opts.ParallelBackfills,
 		ParallelRecordCreates: opts.ParallelRecordCreates,
 		NSIDFilter:            opts.NSIDFilter,
+		Collections:           opts.Collections,
 		syncLimiter:           rate.NewLimiter(rate.Limit(opts.SyncRequestsPerSecond), 1),
 		CheckoutPath:          opts.CheckoutPath,
+		CheckpointInterval:    opts.CheckpointInterval,
+		RateController:        rc,
 		stop:                  make(chan chan struct{}),
 	}
 }
@@ -141,10 +205,16 @@ func (b *Backfiller) Start() {
 
 	sem := make(chan struct{}, b.ParallelBackfills)
 
+	stopReporting := make(chan struct{})
+	if jc, ok := b.Store.(JobCounter); ok {
+		go b.reportJobStates(ctx, jc, stopReporting)
+	}
+
 	for {
 		select {
 		case stopped := <-b.stop:
 			log.Info("stopping backfill processor")
+			close(stopReporting)
 			close(stopped)
 			return
 		default:
@@ -189,6 +259,14 @@ func (b *Backfiller) Stop() {
 
 // FlushBuffer processes buffered operations for a job
 func (b *Backfiller) FlushBuffer(ctx context.Context, job Job) int {
+	return b.flushBuffer(ctx, job, nil)
+}
+
+// flushBuffer processes buffered operations for a job. With the backfilled
+// repo, buffered commits from jobs implementing CommitJob are checked
+// against it: those already included in it are skipped, and the rest are
+// checked for conflicts as they are replayed.
+func (b *Backfiller) flushBuffer(ctx context.Context, job Job, r *repo.Repo) int {
 	ctx, span := tracer.Start(ctx, "FlushBuffer")
 	defer span.End()
 	log := slog.With("source", "backfiller_buffer_flush", "repo", job.Repo())
@@ -197,9 +275,20 @@ func (b *Backfiller) FlushBuffer(ctx context.Context, job Job) int {
 
 	repo := job.Repo()
 
+	cj, _ := job.(CheckpointJob)
+	var cp *Checkpoint
+	if cj != nil && b.CheckpointInterval > 0 {
+		cp = cj.Checkpoint()
+	}
+	prefixes := b.recordPrefixes(job)
+
 	// Flush buffered operations, clear the buffer, and mark the job as "complete"
 	// Clearning and marking are handled by the job interface
-	err := job.FlushBufferedOps(ctx, func(kind, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) error {
+	apply := func(kind, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) error {
+		if !matchPrefixes(prefixes, path) {
+			backfillOpsBuffered.WithLabelValues(b.Name).Dec()
+			return nil
+		}
 		switch repomgr.EventKind(kind) {
 		case repomgr.EvtKindCreateRecord:
 			err := b.HandleCreateRecord(ctx, repo, path, rec, cid)
@@ -219,8 +308,34 @@ func (b *Backfiller) FlushBuffer(ctx context.Context, job Job) int {
 		}
 		backfillOpsBuffered.WithLabelValues(b.Name).Dec()
 		processed++
+		if cp != nil {
+			cp.BufferedOpsApplied++
+			if processed%b.CheckpointInterval == 0 {
+				if err := cj.SetCheckpoint(ctx, cp); err != nil {
+					log.Error("failed to save checkpoint", "error", err)
+				}
+			}
+		}
 		return nil
-	})
+	}
+
+	var err error
+	if cmj, ok := job.(CommitJob); ok && r != nil {
+		rp := newReplayer(r)
+		err = cmj.FlushBufferedCommits(ctx, func(c *BufferedCommit) error {
+			if rp.stale(c) {
+				// already in the backfilled repo
+				backfillOpsBuffered.WithLabelValues(b.Name).Sub(float64(len(c.Ops)))
+				return nil
+			}
+			for _, conflict := range rp.apply(ctx, c, func(path string) bool { return matchPrefixes(prefixes, path) }) {
+				b.reportConflict(ctx, repo, conflict)
+			}
+			return flushOps([]*BufferedCommit{c}, apply)
+		})
+	} else {
+		err = job.FlushBufferedOps(ctx, apply)
+	}
 	if err != nil {
 		log.Error("failed to flush buffered ops", "error", err)
 	}
@@ -231,42 +346,62 @@ func (b *Backfiller) FlushBuffer(ctx context.Context, job Job) int {
 		log.Error("failed to set job state", "error", err)
 	}
 
-	return processed
-}
+	// The checkpoint would only get in the way if the repo is backfilled again
+	if cj != nil {
+		if err := cj.SetCheckpoint(ctx, nil); err != nil {
+			log.Error("failed to clear checkpoint", "error", err)
+		}
+	}
 
-type recordQueueItem struct {
-	recordPath string
-	nodeCid    cid.Cid
+	return processed
 }
 
-type recordResult struct {
-	recordPath string
-	err        error
+func (b *Backfiller) reportConflict(ctx context.Context, repo string, conflict *ReplayConflict) {
+	backfillReplayConflicts.WithLabelValues(b.Name, conflict.Reason).Inc()
+	if b.HandleReplayConflict != nil {
+		b.HandleReplayConflict(ctx, repo, conflict)
+		return
+	}
+	slog.Warn("conflict replaying buffered ops", "source", "backfiller_buffer_flush", "repo", repo,
+		"reason", conflict.Reason, "rev", conflict.Rev, "since", conflict.Since, "kind", conflict.Kind, "path", conflict.Path)
 }
 
-// BackfillRepo backfills a repo
-func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) {
-	ctx, span := tracer.Start(ctx, "BackfillRepo")
-	defer span.End()
-
-	start := time.Now()
-
-	repoDid := job.Repo()
-
-	log := slog.With("source", "backfiller_backfill_repo", "repo", repoDid)
-	log.Info(fmt.Sprintf("processing backfill for %s", repoDid))
+// fetchRepo returns the CAR for a repo, from Source if set, or otherwise
+// from the repo's PDS (with Directory) or CheckoutPath.
+func (b *Backfiller) fetchRepo(ctx context.Context, did string) (io.ReadCloser, error) {
+	if b.Source != nil {
+		return b.Source.GetRepo(ctx, did)
+	}
 
-	url := fmt.Sprintf("%s?did=%s", b.CheckoutPath, repoDid)
+	url := fmt.Sprintf("%s?did=%s", b.CheckoutPath, did)
+	if b.Directory != nil {
+		d, err := syntax.ParseDID(did)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRepoNotFound, err)
+		}
+		ident, err := b.Directory.LookupDID(ctx, d)
+		if err != nil {
+			return nil, fmt.Errorf("resolving repo PDS: %w", err)
+		}
+		pds := ident.PDSEndpoint()
+		if pds == "" {
+			return nil, fmt.Errorf("%w: no PDS for %s", ErrRepoNotFound, did)
+		}
+		url = fmt.Sprintf("%s/xrpc/com.atproto.sync.getRepo?did=%s", strings.TrimSuffix(pds, "/"), did)
+	}
 
 	// GET and CAR decode the body
+	transport := http.DefaultTransport
+	if b.RateController != nil {
+		transport = b.RateController.Transport(transport)
+	}
 	client := &http.Client{
-		Transport: otelhttp.NewTransport(http.DefaultTransport),
+		Transport: otelhttp.NewTransport(transport),
 		Timeout:   600 * time.Second,
 	}
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		log.Error("failed to create request", "error", err)
-		return
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Accept", "application/vnd.ipld.car")
@@ -275,39 +410,100 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) {
 		req.Header.Set(b.magicHeaderKey, b.magicHeaderVal)
 	}
 
-	b.syncLimiter.Wait(ctx)
+	if b.RateController == nil {
+		b.syncLimiter.Wait(ctx)
+	}
 
+	host := req.URL.Host
+	backfillFetchRequests.WithLabelValues(b.Name, host).Inc()
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Error("failed to send request", "error", err)
-		return
+		backfillFetchErrors.WithLabelValues(b.Name, host, "request_failed").Inc()
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		log.Info("failed to get repo", "status", resp.StatusCode)
-		reason := "unknown error"
-		if resp.StatusCode == http.StatusBadRequest {
-			reason = "repo not found"
+		resp.Body.Close()
+		backfillFetchErrors.WithLabelValues(b.Name, host, strconv.Itoa(resp.StatusCode)).Inc()
+		switch resp.StatusCode {
+		case http.StatusBadRequest:
+			return nil, fmt.Errorf("%w (status %d)", ErrRepoNotFound, resp.StatusCode)
+		case http.StatusTooManyRequests:
+			return nil, fmt.Errorf("%w by %s", ErrRateLimited, req.URL.Host)
 		}
-		state := fmt.Sprintf("failed (%s)", reason)
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
 
-		// Mark the job as "failed"
-		err := job.SetState(ctx, state)
-		if err != nil {
+// failJob marks a job as failed and drops its buffered ops
+func (b *Backfiller) failJob(ctx context.Context, job Job, state string) {
+	log := slog.With("source", "backfiller", "name", b.Name, "repo", job.Repo())
+
+	// Mark the job as "failed"
+	err := job.SetState(ctx, state)
+	if err != nil {
+		log.Error("failed to set job state", "error", err)
+	}
+
+	// Clear buffered ops
+	err = job.ClearBufferedOps(ctx)
+	if err != nil {
+		log.Error("failed to clear buffered ops", "error", err)
+	}
+}
+
+type recordQueueItem struct {
+	seq        int
+	recordPath string
+	nodeCid    cid.Cid
+}
+
+type recordResult struct {
+	seq        int
+	recordPath string
+	err        error
+}
+
+// BackfillRepo backfills a repo
+func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) {
+	ctx, span := tracer.Start(ctx, "BackfillRepo")
+	defer span.End()
+
+	start := time.Now()
+
+	repoDid := job.Repo()
+
+	log := slog.With("source", "backfiller_backfill_repo", "repo", repoDid)
+	log.Info(fmt.Sprintf("processing backfill for %s", repoDid))
+
+	progress := b.startProgress(repoDid)
+	defer b.finishProgress(progress)
+
+	body, err := b.fetchRepo(ctx, repoDid)
+	if err != nil && errors.Is(err, ErrRateLimited) {
+		// Try again later; the rate controller will hold off the next attempt
+		// until the host is ready for it
+		log.Info("rate limited fetching repo, re-enqueueing", "error", err)
+		if err := job.SetState(ctx, StateEnqueued); err != nil {
 			log.Error("failed to set job state", "error", err)
 		}
-
-		// Clear buffered ops
-		err = job.ClearBufferedOps(ctx)
-		if err != nil {
-			log.Error("failed to clear buffered ops", "error", err)
+		return
+	}
+	if err != nil {
+		log.Info("failed to get repo", "error", err)
+		reason := "unknown error"
+		if errors.Is(err, ErrRepoNotFound) {
+			reason = "repo not found"
 		}
+		b.failJob(ctx, job, fmt.Sprintf("failed (%s)", reason))
 		return
 	}
 
 	instrumentedReader := instrumentedReader{
-		source:  resp.Body,
+		source:  body,
 		counter: backfillBytesProcessed.WithLabelValues(b.Name),
+		total:   &progress.bytes,
 	}
 
 	defer instrumentedReader.Close()
@@ -315,23 +511,37 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) {
 	r, err := repo.ReadRepoFromCar(ctx, instrumentedReader)
 	if err != nil {
 		log.Error("failed to read repo from car", "error", err)
-
-		state := "failed (couldn't read repo CAR from response body)"
-
-		// Mark the job as "failed"
-		err := job.SetState(ctx, state)
-		if err != nil {
-			log.Error("failed to set job state", "error", err)
-		}
-
-		// Clear buffered ops
-		err = job.ClearBufferedOps(ctx)
-		if err != nil {
-			log.Error("failed to clear buffered ops", "error", err)
-		}
+		b.failJob(ctx, job, "failed (couldn't read repo CAR from response body)")
 		return
 	}
+	nc := b.nodeCaches.Get()
+	defer b.nodeCaches.Put(nc)
+	r.SetNodeCache(nc)
+
+	// Pick up from the job's checkpoint if it was interrupted part way through
+	// this same version of the repo
+	data := r.DataCid().String()
+	cj, _ := job.(CheckpointJob)
+	if b.CheckpointInterval <= 0 {
+		cj = nil
+	}
+	var cp *Checkpoint
+	if cj != nil {
+		cp = cj.Checkpoint()
+		if cp != nil && cp.Data != data {
+			log.Info("repo has changed since checkpoint, starting over", "checkpoint_data", cp.Data, "data", data)
+			cp = nil
+		} else if cp != nil {
+			log.Info("resuming backfill from checkpoint", "last_path", cp.LastPath, "records_processed", cp.RecordsProcessed, "records_done", cp.RecordsDone)
+		}
+	}
+	if cp == nil {
+		cp = &Checkpoint{Data: data}
+	}
+	resumeAfter := cp.LastPath
+	prefixes := b.recordPrefixes(job)
 
+	progress.phase.Store(phaseRecords)
 	numRecords := 0
 	numRoutines := b.ParallelRecordCreates
 	recordQueue := make(chan recordQueueItem, numRoutines)
@@ -342,11 +552,28 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) {
 	// Producer routine
 	go func() {
 		defer close(recordQueue)
-		r.ForEach(ctx, b.NSIDFilter, func(recordPath string, nodeCid cid.Cid) error {
-			numRecords++
-			recordQueue <- recordQueueItem{recordPath: recordPath, nodeCid: nodeCid}
-			return nil
-		})
+		if cp.RecordsDone {
+			return
+		}
+		// Walk just the key range of each prefix, so records outside them
+		// aren't visited at all
+		for _, prefix := range prefixes {
+			walkFrom := prefix
+			if resumeAfter > walkFrom {
+				walkFrom = resumeAfter
+			}
+			r.ForEach(ctx, walkFrom, func(recordPath string, nodeCid cid.Cid) error {
+				if !strings.HasPrefix(recordPath, prefix) {
+					return repo.ErrDoneIterating
+				}
+				if resumeAfter != "" && recordPath <= resumeAfter {
+					return nil
+				}
+				recordQueue <- recordQueueItem{seq: numRecords, recordPath: recordPath, nodeCid: nodeCid}
+				numRecords++
+				return nil
+			})
+		}
 	}()
 
 	// Consumer routines
@@ -355,31 +582,35 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) {
 		go func() {
 			defer wg.Done()
 			for item := range recordQueue {
+				fail := func(err error) {
+					recordResults <- recordResult{seq: item.seq, recordPath: item.recordPath, err: err}
+				}
 				blk, err := r.Blockstore().Get(ctx, item.nodeCid)
 				if err != nil {
-					recordResults <- recordResult{recordPath: item.recordPath, err: fmt.Errorf("failed to get blocks for record: %w", err)}
+					fail(fmt.Errorf("failed to get blocks for record: %w", err))
 					continue
 				}
 				rec, err := lexutil.CborDecodeValue(blk.RawData())
 				if err != nil {
-					recordResults <- recordResult{recordPath: item.recordPath, err: fmt.Errorf("failed to decode record: %w", err)}
+					fail(fmt.Errorf("failed to decode record: %w", err))
 					continue
 				}
 
 				recM, ok := rec.(typegen.CBORMarshaler)
 				if !ok {
-					recordResults <- recordResult{recordPath: item.recordPath, err: fmt.Errorf("failed to cast record to CBORMarshaler")}
+					fail(fmt.Errorf("failed to cast record to CBORMarshaler"))
 					continue
 				}
 
 				err = b.HandleCreateRecord(ctx, repoDid, item.recordPath, &recM, &item.nodeCid)
 				if err != nil {
-					recordResults <- recordResult{recordPath: item.recordPath, err: fmt.Errorf("failed to handle create record: %w", err)}
+					fail(fmt.Errorf("failed to handle create record: %w", err))
 					continue
 				}
 
 				backfillRecordsProcessed.WithLabelValues(b.Name).Inc()
-				recordResults <- recordResult{recordPath: item.recordPath, err: err}
+				progress.records.Add(1)
+				recordResults <- recordResult{seq: item.seq, recordPath: item.recordPath}
 			}
 		}()
 	}
@@ -389,10 +620,18 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) {
 	// Handle results
 	go func() {
 		defer resultWG.Done()
+		progress := newProgressTracker(cp)
+		lastSaved := cp.RecordsProcessed
 		for result := range recordResults {
 			if result.err != nil {
 				log.Error("Error processing record", "record", result.recordPath, "error", result.err)
 			}
+			if progress.done(result.seq, result.recordPath) && cj != nil && cp.RecordsProcessed-lastSaved >= int64(b.CheckpointInterval) {
+				if err := cj.SetCheckpoint(ctx, cp); err != nil {
+					log.Error("failed to save checkpoint", "error", err)
+				}
+				lastSaved = cp.RecordsProcessed
+			}
 		}
 	}()
 
@@ -400,8 +639,16 @@ func (b *Backfiller) BackfillRepo(ctx context.Context, job Job) {
 	close(recordResults)
 	resultWG.Wait()
 
+	if cj != nil {
+		cp.RecordsDone = true
+		if err := cj.SetCheckpoint(ctx, cp); err != nil {
+			log.Error("failed to save checkpoint", "error", err)
+		}
+	}
+
 	// Process buffered operations, marking the job as "complete" when done
-	numProcessed := b.FlushBuffer(ctx, job)
+	progress.phase.Store(phaseFlushing)
+	numProcessed := b.flushBuffer(ctx, job, r)
 
 	log.Info("backfill complete",
 		"buffered_records_processed", numProcessed,
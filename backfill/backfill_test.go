@@ -112,3 +112,65 @@ func (ts *testState) handleDelete(ctx context.Context, repo string, path string)
 	ts.lk.Unlock()
 	return nil
 }
+
+func TestJobPriority(t *testing.T) {
+	ctx := context.Background()
+	mem := backfill.NewMemstore()
+
+	for _, repo := range []string{"did:plc:a", "did:plc:b", "did:plc:c"} {
+		if err := mem.EnqueueJob(repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mem.EnqueueJobWithPriority("did:plc:d", backfill.PriorityAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.SetJobPriority(ctx, "did:plc:b", backfill.PriorityActive); err != nil {
+		t.Fatal(err)
+	}
+	// activity on an enqueued repo raises its priority
+	if _, err := mem.BufferOp(ctx, "did:plc:c", "create", "app.bsky.feed.post/1", nil, &cid.Undef); err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	for {
+		j, err := mem.GetNextEnqueuedJob(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j == nil {
+			break
+		}
+		order = append(order, j.Repo())
+		if err := j.SetState(ctx, backfill.StateInProgress); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{"did:plc:d", "did:plc:b", "did:plc:c", "did:plc:a"}
+	if len(order) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, order)
+		}
+	}
+
+	// a job put back in the enqueued state is picked up again
+	j, err := mem.GetJob(ctx, "did:plc:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.SetState(ctx, backfill.StateEnqueued); err != nil {
+		t.Fatal(err)
+	}
+	next, err := mem.GetNextEnqueuedJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == nil || next.Repo() != "did:plc:a" {
+		t.Fatalf("expected re-enqueued job to be returned, got %v", next)
+	}
+}
@@ -0,0 +1,79 @@
+package backfill
+
+import "context"
+
+// Checkpoint records how far through a repo a backfill job has got, so that a
+// job interrupted by a restart can carry on from where it left off instead of
+// reprocessing the whole repo.
+type Checkpoint struct {
+	// Data is the CID of the MST root being backfilled. A checkpoint is only
+	// resumed from if the repo's records are unchanged; otherwise records
+	// before LastPath may be stale and the job starts again from scratch.
+	Data string
+	// LastPath is the last record path, in MST order, such that it and every
+	// record before it have been handled
+	LastPath string
+	// RecordsProcessed is the number of records handled up to LastPath
+	RecordsProcessed int64
+	// RecordsDone is set once every record in the repo has been handled, and
+	// only buffered ops remain to be applied
+	RecordsDone bool
+	// BufferedOpsApplied is the number of buffered ops flushed so far
+	BufferedOpsApplied int64
+}
+
+// CheckpointJob is implemented by jobs which can save their progress. The
+// Backfiller checkpoints jobs which implement it every CheckpointInterval
+// records, and clears the checkpoint once the job is complete.
+type CheckpointJob interface {
+	Job
+
+	// Checkpoint returns a copy of the job's last checkpoint, or nil
+	Checkpoint() *Checkpoint
+	// SetCheckpoint saves a copy of cp, or clears the checkpoint if cp is nil.
+	// It must not block on a FlushBufferedOps call in progress for the job.
+	SetCheckpoint(ctx context.Context, cp *Checkpoint) error
+}
+
+// progressTracker follows records which are handed out in MST order but
+// finish out of order on parallel workers, and advances a checkpoint over the
+// longest prefix of them which is done. It is not safe for concurrent use.
+type progressTracker struct {
+	cp      *Checkpoint
+	next    int
+	pending map[int]string
+}
+
+func newProgressTracker(cp *Checkpoint) *progressTracker {
+	return &progressTracker{
+		cp:      cp,
+		pending: make(map[int]string),
+	}
+}
+
+// done marks the record with sequence number seq as handled, and returns true
+// if that moved the checkpoint forward.
+func (p *progressTracker) done(seq int, path string) bool {
+	p.pending[seq] = path
+
+	advanced := false
+	for {
+		path, ok := p.pending[p.next]
+		if !ok {
+			return advanced
+		}
+		delete(p.pending, p.next)
+		p.next++
+		p.cp.LastPath = path
+		p.cp.RecordsProcessed++
+		advanced = true
+	}
+}
+
+func copyCheckpoint(cp *Checkpoint) *Checkpoint {
+	if cp == nil {
+		return nil
+	}
+	c := *cp
+	return &c
+}
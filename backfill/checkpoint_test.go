@@ -0,0 +1,182 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/repo"
+
+	"github.com/ipfs/go-cid"
+	typegen "github.com/whyrusleeping/cbor-gen"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestProgressTracker(t *testing.T) {
+	cp := &Checkpoint{}
+	p := newProgressTracker(cp)
+
+	if p.done(1, "b") {
+		t.Fatal("checkpoint advanced past an unfinished record")
+	}
+	if p.done(2, "c") {
+		t.Fatal("checkpoint advanced past an unfinished record")
+	}
+	if !p.done(0, "a") {
+		t.Fatal("checkpoint didn't advance")
+	}
+	if cp.LastPath != "c" || cp.RecordsProcessed != 3 {
+		t.Fatalf("unexpected checkpoint: %+v", cp)
+	}
+	if !p.done(3, "d") || cp.LastPath != "d" {
+		t.Fatalf("unexpected checkpoint: %+v", cp)
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	did := "did:plc:testcheckpoint"
+
+	car, err := os.ReadFile("../testing/testdata/paul_staging.repo.car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "paul_staging.car"), car, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ent, _ := json.Marshal(ArchiveEntry{Did: did, Key: "paul_staging.car"})
+	if err := os.WriteFile(filepath.Join(dir, "manifest.jsonl"), ent, 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewArchiveSource(ctx, &DirObjectStore{Root: dir}, "manifest.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(car))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	if err := r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		paths = append(paths, k)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) < 4 {
+		t.Fatalf("test repo has too few records: %d", len(paths))
+	}
+	data := r.DataCid().String()
+
+	var lk sync.Mutex
+	var created []string
+	newBackfiller := func(store Store) *Backfiller {
+		bf := NewBackfiller("checkpoint-test", store,
+			func(ctx context.Context, repo string, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) error {
+				lk.Lock()
+				created = append(created, path)
+				lk.Unlock()
+				return nil
+			},
+			nil, nil, nil)
+		bf.ParallelRecordCreates = 4
+		bf.CheckpointInterval = 1
+		bf.Source = src
+		return bf
+	}
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "backfill.sqlite")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&GormDBJob{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a job which got half way through the repo before the process
+	// was stopped
+	store := NewGormstore(db)
+	if err := store.EnqueueJob(did); err != nil {
+		t.Fatal(err)
+	}
+	job, err := store.GetNextEnqueuedJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.SetState(ctx, StateInProgress); err != nil {
+		t.Fatal(err)
+	}
+	half := len(paths) / 2
+	if err := job.(CheckpointJob).SetCheckpoint(ctx, &Checkpoint{Data: data, LastPath: paths[half-1], RecordsProcessed: int64(half)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// On restart the job is queued again with its checkpoint
+	store = NewGormstore(db)
+	if err := store.LoadJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	job, err = store.GetNextEnqueuedJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil {
+		t.Fatal("interrupted job was not re-enqueued")
+	}
+	if cp := job.(CheckpointJob).Checkpoint(); cp == nil || cp.LastPath != paths[half-1] {
+		t.Fatalf("checkpoint not loaded: %+v", cp)
+	}
+	if err := job.SetState(ctx, StateInProgress); err != nil {
+		t.Fatal(err)
+	}
+	newBackfiller(store).BackfillRepo(ctx, job)
+
+	if job.State() != StateComplete {
+		t.Fatalf("expected job to be complete, got %s", job.State())
+	}
+	if len(created) != len(paths)-half {
+		t.Fatalf("expected %d records after resuming, got %d", len(paths)-half, len(created))
+	}
+	for _, p := range created {
+		if p <= paths[half-1] {
+			t.Fatalf("record %s before the checkpoint was processed again", p)
+		}
+	}
+
+	// The checkpoint is cleared once the job completes
+	var dbj GormDBJob
+	if err := db.Where("repo = ?", did).First(&dbj).Error; err != nil {
+		t.Fatal(err)
+	}
+	if dbj.checkpoint() != nil || dbj.State != StateComplete {
+		t.Fatalf("unexpected job row after completion: %+v", dbj)
+	}
+
+	// A checkpoint from a different version of the repo is ignored
+	created = nil
+	mem := NewMemstore()
+	if err := mem.EnqueueJob(did); err != nil {
+		t.Fatal(err)
+	}
+	job, err = mem.GetNextEnqueuedJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.SetState(ctx, StateInProgress); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.(CheckpointJob).SetCheckpoint(ctx, &Checkpoint{Data: "bafyreigpqhwaoh6ktpxe6vqdt6ubmzlp4xmah2ttkpaoyzq7m4mzmzvwzy", LastPath: paths[half-1], RecordsProcessed: int64(half)}); err != nil {
+		t.Fatal(err)
+	}
+	newBackfiller(mem).BackfillRepo(ctx, job)
+	if len(created) != len(paths) {
+		t.Fatalf("expected all %d records to be processed, got %d", len(paths), len(created))
+	}
+}
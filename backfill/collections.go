@@ -0,0 +1,63 @@
+package backfill
+
+import (
+	"sort"
+	"strings"
+)
+
+// CollectionsJob is implemented by jobs which can be limited to backfilling
+// only some collections. A job's collections take the place of the
+// Backfiller's Collections; jobs with none use the Backfiller's.
+type CollectionsJob interface {
+	Job
+
+	Collections() []string
+}
+
+// recordPrefixes returns the record path prefixes a job should walk, in MST
+// order. An empty prefix means the whole repo.
+func (b *Backfiller) recordPrefixes(job Job) []string {
+	collections := b.Collections
+	if cj, ok := job.(CollectionsJob); ok {
+		if jc := cj.Collections(); len(jc) > 0 {
+			collections = jc
+		}
+	}
+	if len(collections) == 0 {
+		return []string{b.NSIDFilter}
+	}
+
+	prefixes := make([]string, 0, len(collections))
+	seen := make(map[string]bool)
+	for _, c := range collections {
+		p := strings.TrimSuffix(c, "/") + "/"
+		if !seen[p] {
+			seen[p] = true
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+func matchPrefixes(prefixes []string, path string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinCollections and splitCollections are how stores persist a job's
+// collections in a single column or field
+func joinCollections(collections []string) string {
+	return strings.Join(collections, ",")
+}
+
+func splitCollections(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -0,0 +1,121 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/repo"
+
+	"github.com/ipfs/go-cid"
+	typegen "github.com/whyrusleeping/cbor-gen"
+)
+
+func TestCollectionFilter(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	did := "did:plc:testcollections"
+
+	car, err := os.ReadFile("../testing/testdata/paul_staging.repo.car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "repo.car"), car, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ent, _ := json.Marshal(ArchiveEntry{Did: did, Key: "repo.car"})
+	if err := os.WriteFile(filepath.Join(dir, "manifest.jsonl"), ent, 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewArchiveSource(ctx, &DirObjectStore{Root: dir}, "manifest.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(car))
+	if err != nil {
+		t.Fatal(err)
+	}
+	perCollection := make(map[string]int)
+	if err := r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		perCollection[strings.SplitN(k, "/", 2)[0]]++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var collections []string
+	for c := range perCollection {
+		collections = append(collections, c)
+	}
+	sort.Strings(collections)
+	if len(collections) < 2 {
+		t.Fatalf("test repo only has collections %v", collections)
+	}
+
+	run := func(bf *Backfiller, mem *Memstore) []string {
+		var lk sync.Mutex
+		var created []string
+		bf.HandleCreateRecord = func(ctx context.Context, repo string, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) error {
+			lk.Lock()
+			created = append(created, path)
+			lk.Unlock()
+			return nil
+		}
+		job, err := mem.GetNextEnqueuedJob(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := job.SetState(ctx, StateInProgress); err != nil {
+			t.Fatal(err)
+		}
+		bf.BackfillRepo(ctx, job)
+		return created
+	}
+
+	// the backfiller's collections apply to every job
+	mem := NewMemstore()
+	bf := NewBackfiller("collections-test", mem, nil, nil, nil, nil)
+	bf.Source = src
+	bf.Collections = collections[:1]
+	if err := mem.EnqueueJob(did); err != nil {
+		t.Fatal(err)
+	}
+	created := run(bf, mem)
+	if _, misses := bf.nodeCaches.Stats(); misses == 0 {
+		t.Fatal("expected the repo to be walked through a node cache")
+	}
+	if len(created) != perCollection[collections[0]] {
+		t.Fatalf("expected %d %s records, got %d", perCollection[collections[0]], collections[0], len(created))
+	}
+	for _, p := range created {
+		if !strings.HasPrefix(p, collections[0]+"/") {
+			t.Fatalf("record %s is outside the filter", p)
+		}
+	}
+
+	// and a job's own collections take their place
+	mem = NewMemstore()
+	bf.Store = mem
+	if err := mem.EnqueueJob(did); err != nil {
+		t.Fatal(err)
+	}
+	want := collections[len(collections)-1]
+	if err := mem.SetJobCollections(ctx, did, []string{want}); err != nil {
+		t.Fatal(err)
+	}
+	created = run(bf, mem)
+	if len(created) != perCollection[want] {
+		t.Fatalf("expected %d %s records, got %d", perCollection[want], want, len(created))
+	}
+	for _, p := range created {
+		if !strings.HasPrefix(p, want+"/") {
+			t.Fatalf("record %s is outside the job's filter", p)
+		}
+	}
+}
@@ -14,11 +14,18 @@ import (
 type Gormjob struct {
 	repo        string
 	state       string
+	priority    int
+	collections []string
 	lk          sync.Mutex
-	bufferedOps map[string][]*bufferedOp
+	buffered    commitBuffer
 
-	dbj *GormDBJob
-	db  *gorm.DB
+	// checkpoint has its own lock so it can be saved while ops are flushed
+	cpLk       sync.Mutex
+	checkpoint *Checkpoint
+
+	dbj   *GormDBJob
+	db    *gorm.DB
+	store *Gormstore
 
 	createdAt time.Time
 	updatedAt time.Time
@@ -26,21 +33,34 @@ type Gormjob struct {
 
 type GormDBJob struct {
 	gorm.Model
-	Repo  string `gorm:"unique;index"`
-	State string `gorm:"index"`
+	Repo     string `gorm:"unique;index"`
+	State    string `gorm:"index"`
+	Priority int
+	// Collections the job is limited to, comma separated
+	Collections string
+
+	// Checkpoint of an interrupted job. CheckpointData is empty if the job
+	// has no checkpoint.
+	CheckpointData     string
+	CheckpointPath     string
+	RecordsProcessed   int64
+	RecordsDone        bool
+	BufferedOpsApplied int64
 }
 
 // Gormstore is a gorm-backed implementation of the Backfill Store interface
 type Gormstore struct {
-	lk   sync.RWMutex
-	jobs map[string]*Gormjob
-	db   *gorm.DB
+	lk    sync.RWMutex
+	jobs  map[string]*Gormjob
+	queue *jobQueue
+	db    *gorm.DB
 }
 
 func NewGormstore(db *gorm.DB) *Gormstore {
 	return &Gormstore{
-		jobs: make(map[string]*Gormjob),
-		db:   db,
+		jobs:  make(map[string]*Gormjob),
+		queue: newJobQueue(),
+		db:    db,
 	}
 }
 
@@ -52,8 +72,9 @@ func (s *Gormstore) LoadJobs(ctx context.Context) error {
 
 	for {
 		var dbjobs []*GormDBJob
-		// Load all jobs from the database
-		if err := s.db.Limit(limit).Offset(offset).Find(&dbjobs).Error; err != nil {
+		// Load all jobs from the database, in creation order so that jobs of
+		// equal priority keep their place in the queue
+		if err := s.db.Order("id").Limit(limit).Offset(offset).Find(&dbjobs).Error; err != nil {
 			return err
 		}
 		if len(dbjobs) == 0 {
@@ -64,17 +85,33 @@ func (s *Gormstore) LoadJobs(ctx context.Context) error {
 		// Convert them to in-memory jobs
 		for i := range dbjobs {
 			dbj := dbjobs[i]
+
+			// A job which was in progress when we last stopped goes back in
+			// the queue, and resumes from its checkpoint when it is picked up
+			if dbj.State == StateInProgress {
+				dbj.State = StateEnqueued
+				if err := s.db.Save(dbj).Error; err != nil {
+					return err
+				}
+			}
+
 			j := &Gormjob{
 				repo:        dbj.Repo,
 				state:       dbj.State,
-				bufferedOps: map[string][]*bufferedOp{},
+				priority:    dbj.Priority,
+				collections: splitCollections(dbj.Collections),
+				checkpoint:  dbj.checkpoint(),
 				createdAt:   dbj.CreatedAt,
 				updatedAt:   dbj.UpdatedAt,
 
-				dbj: dbj,
-				db:  s.db,
+				dbj:   dbj,
+				db:    s.db,
+				store: s,
 			}
 			s.jobs[dbj.Repo] = j
+			if dbj.State == StateEnqueued {
+				s.queue.add(dbj.Repo, dbj.Priority)
+			}
 		}
 	}
 
@@ -82,10 +119,17 @@ func (s *Gormstore) LoadJobs(ctx context.Context) error {
 }
 
 func (s *Gormstore) EnqueueJob(repo string) error {
+	return s.EnqueueJobWithPriority(repo, PriorityDefault)
+}
+
+// EnqueueJobWithPriority creates a job which will be started ahead of any
+// enqueued jobs with a lower priority.
+func (s *Gormstore) EnqueueJobWithPriority(repo string, priority int) error {
 	// Persist the job to the database
 	dbj := &GormDBJob{
-		Repo:  repo,
-		State: StateEnqueued,
+		Repo:     repo,
+		State:    StateEnqueued,
+		Priority: priority,
 	}
 	if err := s.db.Create(dbj).Error; err != nil {
 		if err == gorm.ErrDuplicatedKey {
@@ -104,21 +148,80 @@ func (s *Gormstore) EnqueueJob(repo string) error {
 	}
 
 	j := &Gormjob{
-		repo:        repo,
-		createdAt:   time.Now(),
-		updatedAt:   time.Now(),
-		state:       StateEnqueued,
-		bufferedOps: map[string][]*bufferedOp{},
-
-		dbj: dbj,
-		db:  s.db,
+		repo:      repo,
+		createdAt: time.Now(),
+		updatedAt: time.Now(),
+		state:     StateEnqueued,
+		priority:  priority,
+
+		dbj:   dbj,
+		db:    s.db,
+		store: s,
 	}
 	s.jobs[repo] = j
+	s.queue.add(repo, priority)
+
+	return nil
+}
+
+// SetJobPriority changes the priority of a job. It only has an effect while
+// the job is still enqueued.
+func (s *Gormstore) SetJobPriority(ctx context.Context, repo string, priority int) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	j, ok := s.jobs[repo]
+	if !ok {
+		return ErrJobNotFound
+	}
+
+	j.lk.Lock()
+	j.priority = priority
+	j.dbj.Priority = priority
+	state := j.state
+	err := j.db.Model(j.dbj).Update("priority", priority).Error
+	j.lk.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if state == StateEnqueued {
+		s.queue.add(repo, priority)
+	}
+	return nil
+}
 
+// SetJobCollections limits a job to backfilling the given collections, or
+// clears the limit if there are none.
+func (s *Gormstore) SetJobCollections(ctx context.Context, repo string, collections []string) error {
+	s.lk.RLock()
+	j, ok := s.jobs[repo]
+	s.lk.RUnlock()
+	if !ok {
+		return ErrJobNotFound
+	}
+
+	j.lk.Lock()
+	defer j.lk.Unlock()
+	joined := joinCollections(collections)
+	if err := j.db.Model(j.dbj).Update("collections", joined).Error; err != nil {
+		return err
+	}
+	j.dbj.Collections = joined
+	j.collections = append([]string(nil), collections...)
 	return nil
 }
 
 func (s *Gormstore) BufferOp(ctx context.Context, repo, kind, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) (bool, error) {
+	return s.BufferCommit(ctx, repo, &BufferedCommit{
+		Ops: []*BufferedOp{{Kind: kind, Path: path, Record: rec, Cid: cid}},
+	})
+}
+
+// BufferCommit buffers the ops of a commit for a job, to be replayed in rev
+// order once the job's backfill is done, and returns true if they were
+// buffered.
+func (s *Gormstore) BufferCommit(ctx context.Context, repo string, c *BufferedCommit) (bool, error) {
 	s.lk.RLock()
 
 	// If the job doesn't exist, we can't buffer an op for it
@@ -129,26 +232,45 @@ func (s *Gormstore) BufferOp(ctx context.Context, repo, kind, path string, rec *
 	}
 
 	j.lk.Lock()
-	defer j.lk.Unlock()
 
 	switch j.state {
 	case StateComplete:
+		j.lk.Unlock()
 		return false, ErrJobComplete
 	case StateInProgress:
 	// keep going and buffer the op
+	case StateEnqueued:
+		// the op will be picked up by the backfill, but activity on the repo
+		// moves it up the queue
+		bump := j.priority < PriorityActive
+		j.lk.Unlock()
+		if bump {
+			return false, s.SetJobPriority(ctx, repo, PriorityActive)
+		}
+		return false, nil
 	default:
+		j.lk.Unlock()
 		return false, nil
 	}
+	defer j.lk.Unlock()
 
-	j.bufferedOps[path] = append(j.bufferedOps[path], &bufferedOp{
-		kind: kind,
-		rec:  rec,
-		cid:  cid,
-	})
+	j.buffered.add(c)
 	j.updatedAt = time.Now()
 	return true, nil
 }
 
+// CountJobsByState returns the number of jobs in each state.
+func (s *Gormstore) CountJobsByState(ctx context.Context) (map[string]int, error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+
+	counts := make(map[string]int)
+	for _, j := range s.jobs {
+		counts[j.State()]++
+	}
+	return counts, nil
+}
+
 func (s *Gormstore) GetJob(ctx context.Context, repo string) (Job, error) {
 	s.lk.RLock()
 	defer s.lk.RUnlock()
@@ -160,22 +282,42 @@ func (s *Gormstore) GetJob(ctx context.Context, repo string) (Job, error) {
 	return j, nil
 }
 
+// GetNextEnqueuedJob returns the enqueued job with the highest priority,
+// removing it from the queue. Setting the job's state back to enqueued puts
+// it back in the queue.
 func (s *Gormstore) GetNextEnqueuedJob(ctx context.Context) (Job, error) {
-	s.lk.RLock()
-	defer s.lk.RUnlock()
+	s.lk.Lock()
+	defer s.lk.Unlock()
 
-	for _, j := range s.jobs {
-		if j.State() == StateEnqueued {
+	for {
+		repo, ok := s.queue.pop()
+		if !ok {
+			return nil, nil
+		}
+		if j := s.jobs[repo]; j != nil && j.State() == StateEnqueued {
 			return j, nil
 		}
 	}
-	return nil, nil
 }
 
 func (j *Gormjob) Repo() string {
 	return j.repo
 }
 
+func (j *Gormjob) Priority() int {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	return j.priority
+}
+
+func (j *Gormjob) Collections() []string {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	return append([]string(nil), j.collections...)
+}
+
 func (j *Gormjob) State() string {
 	j.lk.Lock()
 	defer j.lk.Unlock()
@@ -185,29 +327,50 @@ func (j *Gormjob) State() string {
 
 func (j *Gormjob) SetState(ctx context.Context, state string) error {
 	j.lk.Lock()
-	defer j.lk.Unlock()
-
 	j.state = state
 	j.updatedAt = time.Now()
+	priority := j.priority
 
-	// Persist the job to the database
+	// Persist the job to the database. Only the state column is written so
+	// that a checkpoint saved concurrently isn't overwritten.
 	j.dbj.State = state
-	return j.db.Save(j.dbj).Error
+	err := j.db.Model(j.dbj).Update("state", state).Error
+	j.lk.Unlock()
+
+	if state == StateEnqueued && j.store != nil {
+		j.store.lk.Lock()
+		j.store.queue.add(j.repo, priority)
+		j.store.lk.Unlock()
+	}
+	return err
 }
 
 func (j *Gormjob) FlushBufferedOps(ctx context.Context, fn func(kind, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) error) error {
 	j.lk.Lock()
 	defer j.lk.Unlock()
 
-	for path, ops := range j.bufferedOps {
-		for _, op := range ops {
-			if err := fn(op.kind, path, op.rec, op.cid); err != nil {
-				return err
-			}
+	if err := flushOps(j.buffered.sorted(), fn); err != nil {
+		return err
+	}
+
+	j.buffered.reset()
+	j.state = StateComplete
+
+	return nil
+}
+
+// FlushBufferedCommits calls fn for each buffered commit in rev order.
+func (j *Gormjob) FlushBufferedCommits(ctx context.Context, fn func(c *BufferedCommit) error) error {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	for _, c := range j.buffered.sorted() {
+		if err := fn(c); err != nil {
+			return err
 		}
 	}
 
-	j.bufferedOps = map[string][]*bufferedOp{}
+	j.buffered.reset()
 	j.state = StateComplete
 
 	return nil
@@ -217,7 +380,56 @@ func (j *Gormjob) ClearBufferedOps(ctx context.Context) error {
 	j.lk.Lock()
 	defer j.lk.Unlock()
 
-	j.bufferedOps = map[string][]*bufferedOp{}
+	j.buffered.reset()
 	j.updatedAt = time.Now()
 	return nil
 }
+
+func (dbj *GormDBJob) checkpoint() *Checkpoint {
+	if dbj.CheckpointData == "" {
+		return nil
+	}
+	return &Checkpoint{
+		Data:               dbj.CheckpointData,
+		LastPath:           dbj.CheckpointPath,
+		RecordsProcessed:   dbj.RecordsProcessed,
+		RecordsDone:        dbj.RecordsDone,
+		BufferedOpsApplied: dbj.BufferedOpsApplied,
+	}
+}
+
+func (j *Gormjob) Checkpoint() *Checkpoint {
+	j.cpLk.Lock()
+	defer j.cpLk.Unlock()
+
+	return copyCheckpoint(j.checkpoint)
+}
+
+func (j *Gormjob) SetCheckpoint(ctx context.Context, cp *Checkpoint) error {
+	j.cpLk.Lock()
+	defer j.cpLk.Unlock()
+
+	if cp == nil {
+		cp = &Checkpoint{}
+	}
+
+	// Only update the checkpoint columns, since this may be called while
+	// another goroutine holds j.lk and is working with j.dbj
+	err := j.db.Model(&GormDBJob{}).Where("id = ?", j.dbj.ID).Updates(map[string]any{
+		"checkpoint_data":      cp.Data,
+		"checkpoint_path":      cp.LastPath,
+		"records_processed":    cp.RecordsProcessed,
+		"records_done":         cp.RecordsDone,
+		"buffered_ops_applied": cp.BufferedOpsApplied,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	if cp.Data == "" {
+		j.checkpoint = nil
+	} else {
+		j.checkpoint = copyCheckpoint(cp)
+	}
+	return nil
+}
@@ -10,17 +10,19 @@ import (
 	typegen "github.com/whyrusleeping/cbor-gen"
 )
 
-type bufferedOp struct {
-	kind string
-	rec  *typegen.CBORMarshaler
-	cid  *cid.Cid
-}
-
 type Memjob struct {
 	repo        string
 	state       string
+	priority    int
+	collections []string
 	lk          sync.Mutex
-	bufferedOps map[string][]*bufferedOp
+	buffered    commitBuffer
+
+	// checkpoint has its own lock so it can be saved while ops are flushed
+	cpLk       sync.Mutex
+	checkpoint *Checkpoint
+
+	store *Memstore
 
 	createdAt time.Time
 	updatedAt time.Time
@@ -28,17 +30,25 @@ type Memjob struct {
 
 // Memstore is a simple in-memory implementation of the Backfill Store interface
 type Memstore struct {
-	lk   sync.RWMutex
-	jobs map[string]*Memjob
+	lk    sync.RWMutex
+	jobs  map[string]*Memjob
+	queue *jobQueue
 }
 
 func NewMemstore() *Memstore {
 	return &Memstore{
-		jobs: make(map[string]*Memjob),
+		jobs:  make(map[string]*Memjob),
+		queue: newJobQueue(),
 	}
 }
 
 func (s *Memstore) EnqueueJob(repo string) error {
+	return s.EnqueueJobWithPriority(repo, PriorityDefault)
+}
+
+// EnqueueJobWithPriority creates a job which will be started ahead of any
+// enqueued jobs with a lower priority.
+func (s *Memstore) EnqueueJobWithPriority(repo string, priority int) error {
 	s.lk.Lock()
 	defer s.lk.Unlock()
 
@@ -47,17 +57,66 @@ func (s *Memstore) EnqueueJob(repo string) error {
 	}
 
 	j := &Memjob{
-		repo:        repo,
-		createdAt:   time.Now(),
-		updatedAt:   time.Now(),
-		state:       StateEnqueued,
-		bufferedOps: map[string][]*bufferedOp{},
+		repo:      repo,
+		createdAt: time.Now(),
+		updatedAt: time.Now(),
+		state:     StateEnqueued,
+		priority:  priority,
+		store:     s,
 	}
 	s.jobs[repo] = j
+	s.queue.add(repo, priority)
+	return nil
+}
+
+// SetJobPriority changes the priority of a job. It only has an effect while
+// the job is still enqueued.
+func (s *Memstore) SetJobPriority(ctx context.Context, repo string, priority int) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	j, ok := s.jobs[repo]
+	if !ok {
+		return ErrJobNotFound
+	}
+
+	j.lk.Lock()
+	j.priority = priority
+	state := j.state
+	j.lk.Unlock()
+
+	if state == StateEnqueued {
+		s.queue.add(repo, priority)
+	}
+	return nil
+}
+
+// SetJobCollections limits a job to backfilling the given collections, or
+// clears the limit if there are none.
+func (s *Memstore) SetJobCollections(ctx context.Context, repo string, collections []string) error {
+	s.lk.RLock()
+	j, ok := s.jobs[repo]
+	s.lk.RUnlock()
+	if !ok {
+		return ErrJobNotFound
+	}
+
+	j.lk.Lock()
+	defer j.lk.Unlock()
+	j.collections = append([]string(nil), collections...)
 	return nil
 }
 
 func (s *Memstore) BufferOp(ctx context.Context, repo, kind, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) (bool, error) {
+	return s.BufferCommit(ctx, repo, &BufferedCommit{
+		Ops: []*BufferedOp{{Kind: kind, Path: path, Record: rec, Cid: cid}},
+	})
+}
+
+// BufferCommit buffers the ops of a commit for a job, to be replayed in rev
+// order once the job's backfill is done, and returns true if they were
+// buffered.
+func (s *Memstore) BufferCommit(ctx context.Context, repo string, c *BufferedCommit) (bool, error) {
 	s.lk.Lock()
 
 	// If the job doesn't exist, we can't buffer an op for it
@@ -68,26 +127,45 @@ func (s *Memstore) BufferOp(ctx context.Context, repo, kind, path string, rec *t
 	}
 
 	j.lk.Lock()
-	defer j.lk.Unlock()
 
 	switch j.state {
 	case StateComplete:
+		j.lk.Unlock()
 		return false, ErrJobComplete
 	case StateInProgress:
 	// keep going and buffer the op
+	case StateEnqueued:
+		// the op will be picked up by the backfill, but activity on the repo
+		// moves it up the queue
+		bump := j.priority < PriorityActive
+		j.lk.Unlock()
+		if bump {
+			return false, s.SetJobPriority(ctx, repo, PriorityActive)
+		}
+		return false, nil
 	default:
+		j.lk.Unlock()
 		return false, nil
 	}
+	defer j.lk.Unlock()
 
-	j.bufferedOps[path] = append(j.bufferedOps[path], &bufferedOp{
-		kind: kind,
-		rec:  rec,
-		cid:  cid,
-	})
+	j.buffered.add(c)
 	j.updatedAt = time.Now()
 	return true, nil
 }
 
+// CountJobsByState returns the number of jobs in each state.
+func (s *Memstore) CountJobsByState(ctx context.Context) (map[string]int, error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+
+	counts := make(map[string]int)
+	for _, j := range s.jobs {
+		counts[j.State()]++
+	}
+	return counts, nil
+}
+
 func (s *Memstore) GetJob(ctx context.Context, repo string) (Job, error) {
 	s.lk.RLock()
 	defer s.lk.RUnlock()
@@ -99,22 +177,42 @@ func (s *Memstore) GetJob(ctx context.Context, repo string) (Job, error) {
 	return j, nil
 }
 
+// GetNextEnqueuedJob returns the enqueued job with the highest priority,
+// removing it from the queue. Setting the job's state back to enqueued puts
+// it back in the queue.
 func (s *Memstore) GetNextEnqueuedJob(ctx context.Context) (Job, error) {
-	s.lk.RLock()
-	defer s.lk.RUnlock()
+	s.lk.Lock()
+	defer s.lk.Unlock()
 
-	for _, j := range s.jobs {
-		if j.State() == StateEnqueued {
+	for {
+		repo, ok := s.queue.pop()
+		if !ok {
+			return nil, nil
+		}
+		if j := s.jobs[repo]; j != nil && j.State() == StateEnqueued {
 			return j, nil
 		}
 	}
-	return nil, nil
 }
 
 func (j *Memjob) Repo() string {
 	return j.repo
 }
 
+func (j *Memjob) Priority() int {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	return j.priority
+}
+
+func (j *Memjob) Collections() []string {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	return append([]string(nil), j.collections...)
+}
+
 func (j *Memjob) State() string {
 	j.lk.Lock()
 	defer j.lk.Unlock()
@@ -124,10 +222,16 @@ func (j *Memjob) State() string {
 
 func (j *Memjob) SetState(ctx context.Context, state string) error {
 	j.lk.Lock()
-	defer j.lk.Unlock()
-
 	j.state = state
 	j.updatedAt = time.Now()
+	priority := j.priority
+	j.lk.Unlock()
+
+	if state == StateEnqueued && j.store != nil {
+		j.store.lk.Lock()
+		j.store.queue.add(j.repo, priority)
+		j.store.lk.Unlock()
+	}
 	return nil
 }
 
@@ -135,15 +239,28 @@ func (j *Memjob) FlushBufferedOps(ctx context.Context, fn func(kind, path string
 	j.lk.Lock()
 	defer j.lk.Unlock()
 
-	for path, ops := range j.bufferedOps {
-		for _, op := range ops {
-			if err := fn(op.kind, path, op.rec, op.cid); err != nil {
-				return err
-			}
+	if err := flushOps(j.buffered.sorted(), fn); err != nil {
+		return err
+	}
+
+	j.buffered.reset()
+	j.state = StateComplete
+
+	return nil
+}
+
+// FlushBufferedCommits calls fn for each buffered commit in rev order.
+func (j *Memjob) FlushBufferedCommits(ctx context.Context, fn func(c *BufferedCommit) error) error {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	for _, c := range j.buffered.sorted() {
+		if err := fn(c); err != nil {
+			return err
 		}
 	}
 
-	j.bufferedOps = map[string][]*bufferedOp{}
+	j.buffered.reset()
 	j.state = StateComplete
 
 	return nil
@@ -153,7 +270,22 @@ func (j *Memjob) ClearBufferedOps(ctx context.Context) error {
 	j.lk.Lock()
 	defer j.lk.Unlock()
 
-	j.bufferedOps = map[string][]*bufferedOp{}
+	j.buffered.reset()
 	j.updatedAt = time.Now()
 	return nil
 }
+
+func (j *Memjob) Checkpoint() *Checkpoint {
+	j.cpLk.Lock()
+	defer j.cpLk.Unlock()
+
+	return copyCheckpoint(j.checkpoint)
+}
+
+func (j *Memjob) SetCheckpoint(ctx context.Context, cp *Checkpoint) error {
+	j.cpLk.Lock()
+	defer j.cpLk.Unlock()
+
+	j.checkpoint = copyCheckpoint(cp)
+	return nil
+}
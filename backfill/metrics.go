@@ -29,3 +29,34 @@ var backfillBytesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "backfill_bytes_processed_total",
 	Help: "The total number of backfill bytes processed",
 }, []string{"backfiller_name"})
+
+var backfillJobsByState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "backfill_jobs",
+	Help: "The number of backfill jobs in the store, by state",
+}, []string{"backfiller_name", "state"})
+
+var backfillJobsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "backfill_jobs_in_flight",
+	Help: "The number of backfill jobs currently being processed",
+}, []string{"backfiller_name"})
+
+var backfillJobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "backfill_job_duration_seconds",
+	Help:    "How long backfill jobs take to process",
+	Buckets: prometheus.ExponentialBuckets(0.1, 4, 10),
+}, []string{"backfiller_name"})
+
+var backfillFetchRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "backfill_fetch_requests_total",
+	Help: "The total number of repo fetches, by host",
+}, []string{"backfiller_name", "host"})
+
+var backfillFetchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "backfill_fetch_errors_total",
+	Help: "The total number of failed repo fetches, by host and reason",
+}, []string{"backfiller_name", "host", "reason"})
+
+var backfillReplayConflicts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "backfill_replay_conflicts_total",
+	Help: "The total number of conflicts found replaying buffered ops, by reason",
+}, []string{"backfiller_name", "reason"})
@@ -0,0 +1,95 @@
+package backfill
+
+import "container/heap"
+
+// Job priorities. Enqueued jobs with a higher priority are started first, and
+// jobs with equal priority are started in the order they were enqueued.
+// Callers can use any int; these are the levels the stores and palomar use.
+const (
+	PriorityLow     = -10
+	PriorityDefault = 0
+	// PriorityActive is given to enqueued repos which show up with live
+	// activity on the firehose, so recently active accounts are indexed
+	// ahead of a long-tail crawl.
+	PriorityActive = 10
+	// PriorityAdmin is for jobs explicitly requested by an operator.
+	PriorityAdmin = 100
+)
+
+type queueItem struct {
+	repo     string
+	priority int
+	seq      uint64
+	idx      int
+}
+
+// jobQueue is a priority queue of enqueued repos. It is not safe for
+// concurrent use; stores guard it with their own lock.
+type jobQueue struct {
+	items []*queueItem
+	index map[string]*queueItem
+	seq   uint64
+}
+
+func newJobQueue() *jobQueue {
+	return &jobQueue{index: make(map[string]*queueItem)}
+}
+
+func (q *jobQueue) Len() int { return len(q.items) }
+
+func (q *jobQueue) Less(i, j int) bool {
+	if q.items[i].priority != q.items[j].priority {
+		return q.items[i].priority > q.items[j].priority
+	}
+	return q.items[i].seq < q.items[j].seq
+}
+
+func (q *jobQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].idx = i
+	q.items[j].idx = j
+}
+
+func (q *jobQueue) Push(x any) {
+	it := x.(*queueItem)
+	it.idx = len(q.items)
+	q.items = append(q.items, it)
+}
+
+func (q *jobQueue) Pop() any {
+	n := len(q.items)
+	it := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	return it
+}
+
+// add queues a repo, or updates its priority if it is already queued.
+func (q *jobQueue) add(repo string, priority int) {
+	if it, ok := q.index[repo]; ok {
+		it.priority = priority
+		heap.Fix(q, it.idx)
+		return
+	}
+	q.seq++
+	it := &queueItem{repo: repo, priority: priority, seq: q.seq}
+	q.index[repo] = it
+	heap.Push(q, it)
+}
+
+// pop removes and returns the highest priority repo.
+func (q *jobQueue) pop() (string, bool) {
+	if len(q.items) == 0 {
+		return "", false
+	}
+	it := heap.Pop(q).(*queueItem)
+	delete(q.index, it.repo)
+	return it.repo, true
+}
+
+func (q *jobQueue) remove(repo string) {
+	if it, ok := q.index[repo]; ok {
+		heap.Remove(q, it.idx)
+		delete(q.index, repo)
+	}
+}
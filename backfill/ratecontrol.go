@@ -0,0 +1,321 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrRateLimited is returned when a host responds with HTTP 429. The
+// RateController will already have backed off that host.
+var ErrRateLimited = errors.New("rate limited")
+
+// RateController adapts the number of concurrent requests, and the request
+// rate, to each host based on the responses it sends back. Concurrency is
+// increased by one after each window of successes and halved on a 429
+// (AIMD), and the rate is paced to fit within any ratelimit-* quota the host
+// advertises.
+type RateController struct {
+	MinConcurrency int
+	MaxConcurrency int
+	// InitialRate is the requests per second allowed to a host before we've
+	// heard anything back from it, and MaxRate the most it will be raised to
+	InitialRate float64
+	MaxRate     float64
+
+	lk    sync.Mutex
+	hosts map[string]*hostController
+}
+
+// NewRateController creates a RateController which starts each host at
+// requestsPerSecond and a concurrency of 1, allowing up to maxConcurrency.
+func NewRateController(requestsPerSecond float64, maxConcurrency int) *RateController {
+	return &RateController{
+		MinConcurrency: 1,
+		MaxConcurrency: max(1, maxConcurrency),
+		InitialRate:    requestsPerSecond,
+		MaxRate:        requestsPerSecond * 10,
+		hosts:          make(map[string]*hostController),
+	}
+}
+
+type hostController struct {
+	host    string
+	limiter *rate.Limiter
+
+	lk          sync.Mutex
+	limit       int
+	inFlight    int
+	successes   int
+	pausedUntil time.Time
+	waiters     []chan struct{}
+}
+
+// HostStatus is a snapshot of the controller's view of a host
+type HostStatus struct {
+	Host        string    `json:"host"`
+	Concurrency int       `json:"concurrency"`
+	InFlight    int       `json:"inFlight"`
+	Rate        float64   `json:"rate"`
+	PausedUntil time.Time `json:"pausedUntil"`
+}
+
+func (c *RateController) host(host string) *hostController {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	h, ok := c.hosts[host]
+	if !ok {
+		h = &hostController{
+			host:    host,
+			limiter: rate.NewLimiter(rate.Limit(c.InitialRate), 1),
+			limit:   max(1, c.MinConcurrency),
+		}
+		c.hosts[host] = h
+	}
+	return h
+}
+
+// Hosts returns the current state of every host the controller has seen.
+func (c *RateController) Hosts() []HostStatus {
+	c.lk.Lock()
+	hosts := make([]*hostController, 0, len(c.hosts))
+	for _, h := range c.hosts {
+		hosts = append(hosts, h)
+	}
+	c.lk.Unlock()
+
+	out := make([]HostStatus, 0, len(hosts))
+	for _, h := range hosts {
+		h.lk.Lock()
+		out = append(out, HostStatus{
+			Host:        h.host,
+			Concurrency: h.limit,
+			InFlight:    h.inFlight,
+			Rate:        float64(h.limiter.Limit()),
+			PausedUntil: h.pausedUntil,
+		})
+		h.lk.Unlock()
+	}
+	return out
+}
+
+// Acquire waits for a slot to make a request to host. Every successful
+// Acquire must be followed by a call to Release.
+func (c *RateController) Acquire(ctx context.Context, host string) error {
+	h := c.host(host)
+
+	for {
+		h.lk.Lock()
+		if h.inFlight < h.limit {
+			h.inFlight++
+			h.lk.Unlock()
+			break
+		}
+		ch := make(chan struct{})
+		h.waiters = append(h.waiters, ch)
+		h.lk.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			h.lk.Lock()
+			woken := true
+			for i, w := range h.waiters {
+				if w == ch {
+					h.waiters = append(h.waiters[:i], h.waiters[i+1:]...)
+					woken = false
+					break
+				}
+			}
+			if woken {
+				// pass on the turn we were given
+				h.wake()
+			}
+			h.lk.Unlock()
+			return ctx.Err()
+		}
+	}
+
+	h.lk.Lock()
+	paused := time.Until(h.pausedUntil)
+	h.lk.Unlock()
+	if paused > 0 {
+		t := time.NewTimer(paused)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			c.Release(host, nil, ctx.Err())
+			return ctx.Err()
+		}
+	}
+
+	if err := h.limiter.Wait(ctx); err != nil {
+		c.Release(host, nil, err)
+		return err
+	}
+	return nil
+}
+
+// Release frees a slot taken by Acquire, adjusting the host's limits based
+// on the response (or error) the request got.
+func (c *RateController) Release(host string, resp *http.Response, err error) {
+	h := c.host(host)
+
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	h.inFlight--
+	now := time.Now()
+
+	switch {
+	case resp != nil && resp.StatusCode == http.StatusTooManyRequests:
+		h.limit = max(c.MinConcurrency, h.limit/2)
+		h.successes = 0
+		h.limiter.SetLimit(max(h.limiter.Limit()/2, rate.Limit(0.1)))
+		until := now.Add(time.Second)
+		if t, ok := retryAfter(resp.Header, now); ok {
+			until = t
+		}
+		if until.After(h.pausedUntil) {
+			h.pausedUntil = until
+		}
+		slog.Warn("backing off rate limited host", "source", "backfiller_rate_control", "host", host, "concurrency", h.limit, "rate", float64(h.limiter.Limit()), "paused_until", h.pausedUntil)
+	case err == nil && resp != nil && resp.StatusCode < 500:
+		h.successes++
+		if h.successes >= h.limit {
+			h.successes = 0
+			h.limit = min(c.MaxConcurrency, h.limit+1)
+			if l := h.limiter.Limit(); l < rate.Limit(c.InitialRate) {
+				h.limiter.SetLimit(min(l*2, rate.Limit(c.InitialRate)))
+			}
+		}
+	}
+
+	if resp != nil {
+		c.observeQuota(h, resp.Header, now)
+	}
+
+	h.wake()
+}
+
+// wake lets through as many waiters as there are free slots. Woken waiters
+// check again for a slot, so waking too many is harmless. Called with h.lk
+// held.
+func (h *hostController) wake() {
+	for n := h.limit - h.inFlight; n > 0 && len(h.waiters) > 0; n-- {
+		close(h.waiters[0])
+		h.waiters = h.waiters[1:]
+	}
+}
+
+// observeQuota paces requests to spread what's left of an advertised quota
+// over the rest of its window. Called with h.lk held.
+func (c *RateController) observeQuota(h *hostController, hdr http.Header, now time.Time) {
+	remaining, err := strconv.ParseFloat(hdr.Get("ratelimit-remaining"), 64)
+	if err != nil {
+		return
+	}
+	reset, ok := parseReset(hdr.Get("ratelimit-reset"), now)
+	if !ok || !reset.After(now) {
+		return
+	}
+
+	if remaining <= 0 {
+		if reset.After(h.pausedUntil) {
+			h.pausedUntil = reset
+		}
+		return
+	}
+	r := remaining / reset.Sub(now).Seconds()
+	h.limiter.SetLimit(rate.Limit(min(r, c.MaxRate)))
+}
+
+// retryAfter parses a Retry-After header, or failing that ratelimit-reset
+func retryAfter(hdr http.Header, now time.Time) (time.Time, bool) {
+	if v := hdr.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return now.Add(time.Duration(secs) * time.Second), true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return t, true
+		}
+	}
+	return parseReset(hdr.Get("ratelimit-reset"), now)
+}
+
+// parseReset handles ratelimit-reset as either a unix timestamp (as sent by
+// the PDS) or a number of seconds from now (as in the IETF draft).
+func parseReset(v string, now time.Time) (time.Time, bool) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return time.Time{}, false
+	}
+	if n > 1_000_000_000 {
+		return time.Unix(n, 0), true
+	}
+	return now.Add(time.Duration(n) * time.Second), true
+}
+
+// Transport returns an http.RoundTripper which makes each request through
+// the controller. The slot is held until the response body has been read or
+// closed, so long downloads like getRepo count against the host's
+// concurrency.
+func (c *RateController) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &rateControlledTransport{c: c, base: base}
+}
+
+type rateControlledTransport struct {
+	c    *RateController
+	base http.RoundTripper
+}
+
+func (t *rateControlledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := t.c.Acquire(req.Context(), host); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.c.Release(host, nil, err)
+		return nil, err
+	}
+	resp.Body = &releasingBody{
+		ReadCloser: resp.Body,
+		release:    func() { t.c.Release(host, resp, nil) },
+	}
+	return resp, nil
+}
+
+// releasingBody calls release once, at EOF or on Close
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.release)
+	}
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,93 @@
+package backfill
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateController(t *testing.T) {
+	var limited atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ratelimit-remaining", "100")
+		w.Header().Set("ratelimit-reset", strconv.FormatInt(time.Now().Add(10*time.Second).Unix(), 10))
+		if limited.Load() {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	rc := NewRateController(1000, 8)
+	client := &http.Client{Transport: rc.Transport(nil)}
+	get := func() int {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	status := func() HostStatus {
+		hosts := rc.Hosts()
+		if len(hosts) != 1 {
+			t.Fatalf("expected one host, got %d", len(hosts))
+		}
+		return hosts[0]
+	}
+
+	for i := 0; i < 20; i++ {
+		get()
+	}
+	st := status()
+	if st.Concurrency <= 1 {
+		t.Fatalf("concurrency didn't increase after successes: %+v", st)
+	}
+	if st.InFlight != 0 {
+		t.Fatalf("slots not released: %+v", st)
+	}
+	// 100 requests left over 10 seconds
+	if st.Rate > 11 {
+		t.Fatalf("rate not paced to advertised quota: %+v", st)
+	}
+
+	limited.Store(true)
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", code)
+	}
+	after := status()
+	if after.Concurrency != max(1, st.Concurrency/2) {
+		t.Fatalf("concurrency not halved after 429: %d -> %d", st.Concurrency, after.Concurrency)
+	}
+	if !after.PausedUntil.After(time.Now()) {
+		t.Fatalf("host not paused after 429: %+v", after)
+	}
+
+	// requests wait out the pause
+	limited.Store(false)
+	start := time.Now()
+	get()
+	if time.Since(start) < 500*time.Millisecond {
+		t.Fatal("request was not held back by Retry-After")
+	}
+}
+
+func TestParseReset(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	if ts, ok := parseReset("1700000060", now); !ok || !ts.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unix timestamp reset parsed as %s", ts)
+	}
+	if ts, ok := parseReset("30", now); !ok || !ts.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("delta seconds reset parsed as %s", ts)
+	}
+	if _, ok := parseReset("soon", now); ok {
+		t.Fatal("invalid reset parsed")
+	}
+}
@@ -0,0 +1,695 @@
+package backfill
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	"github.com/redis/go-redis/v9"
+	typegen "github.com/whyrusleeping/cbor-gen"
+)
+
+// ErrLeaseLost is returned when changing the state of a job which another
+// backfiller has claimed since the lease on it expired
+var ErrLeaseLost = errors.New("job lease lost")
+
+// RedisStore is a Redis-backed implementation of the Backfill Store
+// interface, which lets several backfiller processes share a queue.
+//
+// Every state change is a single Lua script, so transitions are atomic. A
+// backfiller claims a job with a lease which it renews while processing the
+// job; if the process dies, the lease lapses and the job is put back in the
+// queue for another backfiller to pick up (resuming from its checkpoint).
+// Buffered ops are kept in Redis too, so any process consuming the firehose
+// can buffer ops for a job being processed elsewhere. They are kept in a
+// sorted set with every score 0, as members named "rev\nseq\ncommit", so
+// they pop in rev order and then in the order they were buffered.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+	owner  string
+
+	// LeaseDuration is how long a claimed job is held without being renewed
+	LeaseDuration time.Duration
+
+	lk     sync.Mutex
+	leases map[string]chan struct{}
+}
+
+// NewRedisStore creates a store keeping its data under keys starting with
+// prefix, so several independent queues can share one Redis. The scripts
+// touch several keys at once, so on Redis Cluster the prefix must contain a
+// hash tag (eg "{backfill}:") to keep them all in one slot.
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	id := make([]byte, 8)
+	rand.Read(id)
+	host, _ := os.Hostname()
+
+	return &RedisStore{
+		client:        client,
+		prefix:        prefix,
+		owner:         host + "-" + hex.EncodeToString(id),
+		LeaseDuration: 2 * time.Minute,
+		leases:        make(map[string]chan struct{}),
+	}
+}
+
+// RedisJob is a job in a RedisStore
+type RedisJob struct {
+	repo  string
+	store *RedisStore
+
+	lk          sync.Mutex
+	state       string
+	collections []string
+	checkpoint  *Checkpoint
+}
+
+func (s *RedisStore) jobKey(repo string) string { return s.prefix + "job:" + repo }
+func (s *RedisStore) opsKey(repo string) string { return s.prefix + "ops:" + repo }
+func (s *RedisStore) queueKey() string          { return s.prefix + "queue" }
+func (s *RedisStore) leasesKey() string         { return s.prefix + "leases" }
+func (s *RedisStore) statesKey() string         { return s.prefix + "states" }
+func (s *RedisStore) seqKey() string            { return s.prefix + "seq" }
+
+// queueScore orders the queue by priority (highest first), then by seq.
+// Scores are compared as doubles, which are exact well past any realistic
+// seq for priorities within +/- 2^12.
+const luaQueueScore = `
+local function score(priority, seq)
+	return seq - tonumber(priority) * 1e12
+end
+`
+
+// KEYS: job, queue, states, seq
+// ARGV: repo, priority, now
+var redisEnqueueScript = redis.NewScript(luaQueueScore + `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 'exists'
+end
+local seq = redis.call('INCR', KEYS[4])
+redis.call('HSET', KEYS[1], 'state', 'enqueued', 'priority', ARGV[2], 'seq', seq, 'owner', '', 'created', ARGV[3], 'updated', ARGV[3])
+redis.call('ZADD', KEYS[2], score(ARGV[2], seq), ARGV[1])
+redis.call('HINCRBY', KEYS[3], 'enqueued', 1)
+return 'ok'
+`)
+
+// KEYS: job, queue
+// ARGV: repo, priority
+var redisPriorityScript = redis.NewScript(luaQueueScore + `
+local job = redis.call('HMGET', KEYS[1], 'state', 'priority', 'seq')
+if not job[1] then
+	return 'notfound'
+end
+redis.call('HSET', KEYS[1], 'priority', ARGV[2])
+if job[1] == 'enqueued' then
+	redis.call('ZADD', KEYS[2], 'XX', score(ARGV[2], job[3]), ARGV[1])
+end
+return 'ok'
+`)
+
+// KEYS: job
+// ARGV: collections
+var redisCollectionsScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 'notfound'
+end
+redis.call('HSET', KEYS[1], 'collections', ARGV[1])
+return 'ok'
+`)
+
+// KEYS: queue, leases, states, seq
+// ARGV: job key prefix, owner, now (ms), lease (ms)
+var redisClaimScript = redis.NewScript(luaQueueScore + `
+local now = tonumber(ARGV[3])
+
+-- put jobs whose lease has lapsed back in the queue
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now, 'LIMIT', 0, 100)
+for _, repo in ipairs(expired) do
+	local jk = ARGV[1] .. repo
+	redis.call('ZREM', KEYS[2], repo)
+	local job = redis.call('HMGET', jk, 'state', 'priority')
+	if job[1] == 'in_progress' then
+		local seq = redis.call('INCR', KEYS[4])
+		redis.call('HSET', jk, 'state', 'enqueued', 'owner', '', 'seq', seq, 'updated', now)
+		redis.call('HINCRBY', KEYS[3], 'in_progress', -1)
+		redis.call('HINCRBY', KEYS[3], 'enqueued', 1)
+		redis.call('ZADD', KEYS[1], score(job[2], seq), repo)
+	end
+end
+
+while true do
+	local popped = redis.call('ZPOPMIN', KEYS[1])
+	if #popped == 0 then
+		return false
+	end
+	local repo = popped[1]
+	local jk = ARGV[1] .. repo
+	if redis.call('HGET', jk, 'state') == 'enqueued' then
+		redis.call('HSET', jk, 'state', 'in_progress', 'owner', ARGV[2], 'updated', now)
+		redis.call('HINCRBY', KEYS[3], 'enqueued', -1)
+		redis.call('HINCRBY', KEYS[3], 'in_progress', 1)
+		redis.call('ZADD', KEYS[2], now + tonumber(ARGV[4]), repo)
+		return repo
+	end
+end
+`)
+
+// KEYS: job, queue, leases, states, seq
+// ARGV: repo, new state, owner, now (ms), lease (ms)
+var redisSetStateScript = redis.NewScript(luaQueueScore + `
+local job = redis.call('HMGET', KEYS[1], 'state', 'owner', 'priority')
+local cur = job[1]
+if not cur then
+	return 'notfound'
+end
+if cur == 'in_progress' and job[2] and job[2] ~= '' and job[2] ~= ARGV[3] then
+	return 'leaselost'
+end
+
+local new = ARGV[2]
+local now = tonumber(ARGV[4])
+if cur ~= new then
+	redis.call('HINCRBY', KEYS[4], cur, -1)
+	redis.call('HINCRBY', KEYS[4], new, 1)
+end
+redis.call('HSET', KEYS[1], 'state', new, 'updated', now)
+
+if new == 'enqueued' then
+	local seq = redis.call('INCR', KEYS[5])
+	redis.call('HSET', KEYS[1], 'owner', '', 'seq', seq)
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	redis.call('ZADD', KEYS[2], score(job[3], seq), ARGV[1])
+elseif new == 'in_progress' then
+	redis.call('HSET', KEYS[1], 'owner', ARGV[3])
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	redis.call('ZADD', KEYS[3], now + tonumber(ARGV[5]), ARGV[1])
+else
+	redis.call('HSET', KEYS[1], 'owner', '')
+	redis.call('ZREM', KEYS[2], ARGV[1])
+	redis.call('ZREM', KEYS[3], ARGV[1])
+end
+return 'ok'
+`)
+
+// KEYS: job, leases
+// ARGV: repo, owner, now (ms), lease (ms)
+var redisRenewScript = redis.NewScript(`
+local job = redis.call('HMGET', KEYS[1], 'state', 'owner')
+if job[1] ~= 'in_progress' or job[2] ~= ARGV[2] then
+	return 0
+end
+redis.call('ZADD', KEYS[2], tonumber(ARGV[3]) + tonumber(ARGV[4]), ARGV[1])
+return 1
+`)
+
+// KEYS: job, ops, queue
+// ARGV: repo, commit, active priority, rev
+var redisBufferCommitScript = redis.NewScript(luaQueueScore + `
+local job = redis.call('HMGET', KEYS[1], 'state', 'priority', 'seq', 'last_rev')
+local state = job[1]
+if not state then
+	return 'notfound'
+elseif state == 'complete' then
+	return 'complete'
+elseif state == 'in_progress' then
+	local rev = ARGV[4]
+	local last = job[4] or ''
+	if rev == '' then
+		rev = last
+	elseif rev > last then
+		redis.call('HSET', KEYS[1], 'last_rev', rev)
+	end
+	local n = redis.call('HINCRBY', KEYS[1], 'op_seq', 1)
+	redis.call('ZADD', KEYS[2], 0, rev .. '\n' .. string.format('%020d', n) .. '\n' .. ARGV[2])
+	return 'buffered'
+elseif state == 'enqueued' and tonumber(job[2]) < tonumber(ARGV[3]) then
+	redis.call('HSET', KEYS[1], 'priority', ARGV[3])
+	redis.call('ZADD', KEYS[3], 'XX', score(ARGV[3], job[3]), ARGV[1])
+end
+return 'skipped'
+`)
+
+// KEYS: job, ops, states, leases
+// ARGV: repo, owner, now (ms)
+var redisCompleteFlushScript = redis.NewScript(`
+if redis.call('ZCARD', KEYS[2]) > 0 then
+	return 'pending'
+end
+local job = redis.call('HMGET', KEYS[1], 'state', 'owner')
+if not job[1] then
+	return 'notfound'
+end
+if job[1] == 'in_progress' and job[2] and job[2] ~= '' and job[2] ~= ARGV[2] then
+	return 'leaselost'
+end
+if job[1] ~= 'complete' then
+	redis.call('HINCRBY', KEYS[3], job[1], -1)
+	redis.call('HINCRBY', KEYS[3], 'complete', 1)
+end
+redis.call('HSET', KEYS[1], 'state', 'complete', 'owner', '', 'updated', ARGV[3])
+redis.call('ZREM', KEYS[4], ARGV[1])
+return 'ok'
+`)
+
+func scriptResult(res any, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	str, _ := res.(string)
+	return str, nil
+}
+
+func (s *RedisStore) EnqueueJob(repo string) error {
+	return s.EnqueueJobWithPriority(repo, PriorityDefault)
+}
+
+// EnqueueJobWithPriority creates a job which will be started ahead of any
+// enqueued jobs with a lower priority.
+func (s *RedisStore) EnqueueJobWithPriority(repo string, priority int) error {
+	ctx := context.Background()
+	res, err := scriptResult(redisEnqueueScript.Run(ctx, s.client,
+		[]string{s.jobKey(repo), s.queueKey(), s.statesKey(), s.seqKey()},
+		repo, priority, time.Now().UnixMilli()).Result())
+	if err != nil {
+		return err
+	}
+	if res == "exists" {
+		return fmt.Errorf("job already exists for repo %s", repo)
+	}
+	return nil
+}
+
+// SetJobPriority changes the priority of a job. It only has an effect while
+// the job is still enqueued.
+func (s *RedisStore) SetJobPriority(ctx context.Context, repo string, priority int) error {
+	res, err := scriptResult(redisPriorityScript.Run(ctx, s.client,
+		[]string{s.jobKey(repo), s.queueKey()},
+		repo, priority).Result())
+	if err != nil {
+		return err
+	}
+	if res == "notfound" {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// SetJobCollections limits a job to backfilling the given collections, or
+// clears the limit if there are none.
+func (s *RedisStore) SetJobCollections(ctx context.Context, repo string, collections []string) error {
+	res, err := scriptResult(redisCollectionsScript.Run(ctx, s.client,
+		[]string{s.jobKey(repo)},
+		joinCollections(collections)).Result())
+	if err != nil {
+		return err
+	}
+	if res == "notfound" {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// redisOp is how buffered ops are serialized in Redis
+type redisOp struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+	Cid  string `json:"cid,omitempty"`
+	Rec  []byte `json:"rec,omitempty"`
+}
+
+// redisCommit is how buffered commits are serialized in Redis; the rev is
+// kept in the member name
+type redisCommit struct {
+	Since *string   `json:"since,omitempty"`
+	Ops   []redisOp `json:"ops"`
+}
+
+func (s *RedisStore) BufferOp(ctx context.Context, repo, kind, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) (bool, error) {
+	return s.BufferCommit(ctx, repo, &BufferedCommit{
+		Ops: []*BufferedOp{{Kind: kind, Path: path, Record: rec, Cid: cid}},
+	})
+}
+
+// BufferCommit buffers the ops of a commit for a job, to be replayed in rev
+// order once the job's backfill is done, and returns true if they were
+// buffered.
+func (s *RedisStore) BufferCommit(ctx context.Context, repo string, c *BufferedCommit) (bool, error) {
+	rc := redisCommit{Since: c.Since, Ops: make([]redisOp, 0, len(c.Ops))}
+	for _, bop := range c.Ops {
+		op := redisOp{Kind: bop.Kind, Path: bop.Path}
+		if bop.Cid != nil {
+			op.Cid = bop.Cid.String()
+		}
+		if bop.Record != nil && *bop.Record != nil {
+			buf := new(bytes.Buffer)
+			if err := (*bop.Record).MarshalCBOR(buf); err != nil {
+				return false, fmt.Errorf("failed to marshal record: %w", err)
+			}
+			op.Rec = buf.Bytes()
+		}
+		rc.Ops = append(rc.Ops, op)
+	}
+	b, err := json.Marshal(rc)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := scriptResult(redisBufferCommitScript.Run(ctx, s.client,
+		[]string{s.jobKey(repo), s.opsKey(repo), s.queueKey()},
+		repo, b, PriorityActive, c.Rev).Result())
+	if err != nil {
+		return false, err
+	}
+	switch res {
+	case "notfound":
+		return false, ErrJobNotFound
+	case "complete":
+		return false, ErrJobComplete
+	case "buffered":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (s *RedisStore) GetJob(ctx context.Context, repo string) (Job, error) {
+	j, err := s.loadJob(ctx, repo)
+	if err != nil || j == nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (s *RedisStore) loadJob(ctx context.Context, repo string) (*RedisJob, error) {
+	vals, err := s.client.HGetAll(ctx, s.jobKey(repo)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(vals) == 0 {
+		return nil, nil
+	}
+
+	j := &RedisJob{
+		repo:        repo,
+		store:       s,
+		state:       vals["state"],
+		collections: splitCollections(vals["collections"]),
+	}
+	if vals["cp_data"] != "" {
+		j.checkpoint = &Checkpoint{
+			Data:     vals["cp_data"],
+			LastPath: vals["cp_path"],
+		}
+		j.checkpoint.RecordsProcessed, _ = strconv.ParseInt(vals["cp_records"], 10, 64)
+		j.checkpoint.RecordsDone = vals["cp_done"] == "1"
+		j.checkpoint.BufferedOpsApplied, _ = strconv.ParseInt(vals["cp_ops"], 10, 64)
+	}
+	return j, nil
+}
+
+// GetNextEnqueuedJob claims the enqueued job with the highest priority,
+// marking it as in progress and taking out a lease on it. Jobs whose lease
+// has lapsed are put back in the queue first.
+func (s *RedisStore) GetNextEnqueuedJob(ctx context.Context) (Job, error) {
+	res, err := redisClaimScript.Run(ctx, s.client,
+		[]string{s.queueKey(), s.leasesKey(), s.statesKey(), s.seqKey()},
+		s.jobKey(""), s.owner, time.Now().UnixMilli(), s.LeaseDuration.Milliseconds()).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	repo, _ := res.(string)
+	if repo == "" {
+		return nil, nil
+	}
+
+	j, err := s.loadJob(ctx, repo)
+	if err != nil || j == nil {
+		return nil, err
+	}
+	s.holdLease(repo)
+	return j, nil
+}
+
+// holdLease renews the lease on a job until it leaves the in progress state
+func (s *RedisStore) holdLease(repo string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if _, ok := s.leases[repo]; ok {
+		return
+	}
+	stop := make(chan struct{})
+	s.leases[repo] = stop
+
+	go func() {
+		log := slog.With("source", "backfill_redis_store", "repo", repo)
+		t := time.NewTicker(s.LeaseDuration / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+			}
+			ok, err := redisRenewScript.Run(context.Background(), s.client,
+				[]string{s.jobKey(repo), s.leasesKey()},
+				repo, s.owner, time.Now().UnixMilli(), s.LeaseDuration.Milliseconds()).Int()
+			if err != nil {
+				log.Error("failed to renew job lease", "error", err)
+				continue
+			}
+			if ok == 0 {
+				s.releaseLease(repo)
+				return
+			}
+		}
+	}()
+}
+
+func (s *RedisStore) releaseLease(repo string) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if stop, ok := s.leases[repo]; ok {
+		close(stop)
+		delete(s.leases, repo)
+	}
+}
+
+// CountJobsByState returns the number of jobs in each state.
+func (s *RedisStore) CountJobsByState(ctx context.Context) (map[string]int, error) {
+	vals, err := s.client.HGetAll(ctx, s.statesKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(vals))
+	for state, v := range vals {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad count for state %q: %w", state, err)
+		}
+		if n > 0 {
+			counts[state] = n
+		}
+	}
+	return counts, nil
+}
+
+func (j *RedisJob) Repo() string {
+	return j.repo
+}
+
+func (j *RedisJob) Collections() []string {
+	return append([]string(nil), j.collections...)
+}
+
+// State returns the job's current state in Redis, or the last state seen if
+// Redis can't be reached.
+func (j *RedisJob) State() string {
+	state, err := j.store.client.HGet(context.Background(), j.store.jobKey(j.repo), "state").Result()
+
+	j.lk.Lock()
+	defer j.lk.Unlock()
+	if err == nil {
+		j.state = state
+	}
+	return j.state
+}
+
+func (j *RedisJob) SetState(ctx context.Context, state string) error {
+	s := j.store
+	res, err := scriptResult(redisSetStateScript.Run(ctx, s.client,
+		[]string{s.jobKey(j.repo), s.queueKey(), s.leasesKey(), s.statesKey(), s.seqKey()},
+		j.repo, state, s.owner, time.Now().UnixMilli(), s.LeaseDuration.Milliseconds()).Result())
+	if err != nil {
+		return err
+	}
+	switch res {
+	case "notfound":
+		return ErrJobNotFound
+	case "leaselost":
+		s.releaseLease(j.repo)
+		return ErrLeaseLost
+	}
+
+	if state == StateInProgress {
+		s.holdLease(j.repo)
+	} else {
+		s.releaseLease(j.repo)
+	}
+
+	j.lk.Lock()
+	j.state = state
+	j.lk.Unlock()
+	return nil
+}
+
+// FlushBufferedOps applies buffered ops in rev order, then marks the job
+// complete once no more are waiting.
+func (j *RedisJob) FlushBufferedOps(ctx context.Context, fn func(kind, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) error) error {
+	return j.FlushBufferedCommits(ctx, func(c *BufferedCommit) error {
+		return flushOps([]*BufferedCommit{c}, fn)
+	})
+}
+
+// FlushBufferedCommits calls fn for each buffered commit in rev order, then
+// marks the job complete once no more are waiting. Commits buffered while
+// the flush is running are applied in the same pass.
+func (j *RedisJob) FlushBufferedCommits(ctx context.Context, fn func(c *BufferedCommit) error) error {
+	s := j.store
+	for {
+		raw, err := s.client.ZPopMin(ctx, s.opsKey(j.repo), 100).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+
+		for _, z := range raw {
+			member, _ := z.Member.(string)
+			c, err := decodeRedisCommit(member)
+			if err != nil {
+				return err
+			}
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+
+		if len(raw) > 0 {
+			continue
+		}
+
+		res, err := scriptResult(redisCompleteFlushScript.Run(ctx, s.client,
+			[]string{s.jobKey(j.repo), s.opsKey(j.repo), s.statesKey(), s.leasesKey()},
+			j.repo, s.owner, time.Now().UnixMilli()).Result())
+		if err != nil {
+			return err
+		}
+		switch res {
+		case "pending":
+			continue
+		case "notfound":
+			return ErrJobNotFound
+		case "leaselost":
+			s.releaseLease(j.repo)
+			return ErrLeaseLost
+		}
+
+		s.releaseLease(j.repo)
+		j.lk.Lock()
+		j.state = StateComplete
+		j.lk.Unlock()
+		return nil
+	}
+}
+
+func decodeRedisCommit(member string) (*BufferedCommit, error) {
+	parts := strings.SplitN(member, "\n", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed buffered commit")
+	}
+	var rc redisCommit
+	if err := json.Unmarshal([]byte(parts[2]), &rc); err != nil {
+		return nil, fmt.Errorf("failed to decode buffered commit: %w", err)
+	}
+
+	c := &BufferedCommit{Rev: parts[0], Since: rc.Since}
+	for _, op := range rc.Ops {
+		var recp *typegen.CBORMarshaler
+		if len(op.Rec) > 0 {
+			rec, err := lexutil.CborDecodeValue(op.Rec)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode buffered record: %w", err)
+			}
+			recM, ok := rec.(typegen.CBORMarshaler)
+			if !ok {
+				return nil, fmt.Errorf("failed to cast buffered record to CBORMarshaler")
+			}
+			recp = &recM
+		}
+		var cidp *cid.Cid
+		if op.Cid != "" {
+			oc, err := cid.Decode(op.Cid)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode buffered op CID: %w", err)
+			}
+			cidp = &oc
+		}
+		c.Ops = append(c.Ops, &BufferedOp{Kind: op.Kind, Path: op.Path, Record: recp, Cid: cidp})
+	}
+	return c, nil
+}
+
+func (j *RedisJob) ClearBufferedOps(ctx context.Context) error {
+	return j.store.client.Del(ctx, j.store.opsKey(j.repo)).Err()
+}
+
+func (j *RedisJob) Checkpoint() *Checkpoint {
+	j.lk.Lock()
+	defer j.lk.Unlock()
+
+	return copyCheckpoint(j.checkpoint)
+}
+
+func (j *RedisJob) SetCheckpoint(ctx context.Context, cp *Checkpoint) error {
+	key := j.store.jobKey(j.repo)
+	var err error
+	if cp == nil || cp.Data == "" {
+		err = j.store.client.HDel(ctx, key, "cp_data", "cp_path", "cp_records", "cp_done", "cp_ops").Err()
+		cp = nil
+	} else {
+		done := "0"
+		if cp.RecordsDone {
+			done = "1"
+		}
+		err = j.store.client.HSet(ctx, key,
+			"cp_data", cp.Data,
+			"cp_path", cp.LastPath,
+			"cp_records", cp.RecordsProcessed,
+			"cp_done", done,
+			"cp_ops", cp.BufferedOpsApplied,
+		).Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	j.lk.Lock()
+	j.checkpoint = copyCheckpoint(cp)
+	j.lk.Unlock()
+	return nil
+}
@@ -0,0 +1,140 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/redis/go-redis/v9"
+	typegen "github.com/whyrusleeping/cbor-gen"
+)
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	a := NewRedisStore(client, "test:")
+	b := NewRedisStore(client, "test:")
+
+	for _, repo := range []string{"did:plc:one", "did:plc:two", "did:plc:three"} {
+		if err := a.EnqueueJob(repo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.EnqueueJob("did:plc:one"); err == nil {
+		t.Fatal("expected error enqueueing a duplicate job")
+	}
+	if err := b.SetJobPriority(ctx, "did:plc:three", PriorityAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	// jobs are claimed in priority order, and only once
+	job, err := a.GetNextEnqueuedJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Repo() != "did:plc:three" || job.State() != StateInProgress {
+		t.Fatalf("unexpected first job: %s %s", job.Repo(), job.State())
+	}
+	other, err := b.GetNextEnqueuedJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Repo() != "did:plc:one" {
+		t.Fatalf("unexpected second job: %s", other.Repo())
+	}
+
+	// ops are buffered for in-progress jobs and replayed in order, from any
+	// instance
+	rec := typegen.CBORMarshaler(&bsky.FeedPost{Text: "hello", CreatedAt: "2024-01-01T00:00:00Z"})
+	c, _ := cid.Decode("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+	for _, kind := range []string{"create", "update", "delete"} {
+		buffered, err := b.BufferOp(ctx, job.Repo(), kind, "app.bsky.feed.post/abc", &rec, &c)
+		if err != nil || !buffered {
+			t.Fatalf("op not buffered: %v", err)
+		}
+	}
+	if buffered, err := a.BufferOp(ctx, "did:plc:two", "create", "app.bsky.feed.post/abc", &rec, &c); err != nil || buffered {
+		t.Fatalf("op for an enqueued job shouldn't be buffered: %v", err)
+	}
+	if _, err := a.BufferOp(ctx, "did:plc:missing", "create", "app.bsky.feed.post/abc", &rec, &c); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("expected ErrJobNotFound, got %v", err)
+	}
+
+	var kinds []string
+	err = job.FlushBufferedOps(ctx, func(kind, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) error {
+		kinds = append(kinds, kind)
+		if post, ok := (*rec).(*bsky.FeedPost); !ok || post.Text != "hello" {
+			t.Fatalf("buffered record not round tripped: %#v", *rec)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kinds) != 3 || kinds[0] != "create" || kinds[2] != "delete" {
+		t.Fatalf("unexpected replayed ops: %v", kinds)
+	}
+	if job.State() != StateComplete {
+		t.Fatalf("expected job to be complete, got %s", job.State())
+	}
+	if _, err := b.BufferOp(ctx, job.Repo(), "create", "app.bsky.feed.post/abc", &rec, &c); !errors.Is(err, ErrJobComplete) {
+		t.Fatalf("expected ErrJobComplete, got %v", err)
+	}
+
+	// the buffered op moved the enqueued job up the queue
+	bumped, err := a.GetNextEnqueuedJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bumped == nil || bumped.Repo() != "did:plc:two" {
+		t.Fatalf("expected to claim did:plc:two, got %v", bumped)
+	}
+	if next, err := a.GetNextEnqueuedJob(ctx); err != nil || next != nil {
+		t.Fatalf("expected empty queue, got %v %v", next, err)
+	}
+
+	// when b's lease lapses (simulated by stopping renewal and backdating
+	// it), its job goes back in the queue, and b can no longer change its
+	// state
+	b.releaseLease(other.Repo())
+	if err := client.ZAdd(ctx, "test:leases", redis.Z{Score: 0, Member: other.Repo()}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	reclaimed, err := a.GetNextEnqueuedJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed == nil || reclaimed.Repo() != other.Repo() {
+		t.Fatalf("expired job wasn't reclaimed: %v", reclaimed)
+	}
+	if err := other.SetState(ctx, StateComplete); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected ErrLeaseLost, got %v", err)
+	}
+
+	counts, err := a.CountJobsByState(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[StateComplete] != 1 || counts[StateInProgress] != 2 || counts[StateEnqueued] != 0 {
+		t.Fatalf("unexpected job counts: %v", counts)
+	}
+
+	// checkpoints are stored with the job
+	cp := &Checkpoint{Data: "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm", LastPath: "app.bsky.feed.post/abc", RecordsProcessed: 12}
+	if err := reclaimed.(CheckpointJob).SetCheckpoint(ctx, cp); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := b.GetJob(ctx, reclaimed.Repo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.(CheckpointJob).Checkpoint(); got == nil || *got != *cp {
+		t.Fatalf("checkpoint not persisted: %+v", got)
+	}
+}
@@ -0,0 +1,184 @@
+package backfill
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/bluesky-social/indigo/mst"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/bluesky-social/indigo/util"
+
+	"github.com/ipfs/go-cid"
+	typegen "github.com/whyrusleeping/cbor-gen"
+)
+
+// BufferedOp is a record operation received while its repo was being
+// backfilled
+type BufferedOp struct {
+	Kind   string
+	Path   string
+	Record *typegen.CBORMarshaler
+	Cid    *cid.Cid
+}
+
+// BufferedCommit is the ops from one commit, with the commit's rev and the
+// rev it follows on from (since), if known. Ops buffered without a rev are
+// given the rev of the commit buffered before them.
+type BufferedCommit struct {
+	Rev   string
+	Since *string
+	Ops   []*BufferedOp
+}
+
+// CommitJob is implemented by jobs which keep buffered ops grouped by
+// commit. When flushing a CommitJob, the Backfiller skips commits already
+// included in the backfilled repo and checks the rest for conflicts before
+// replaying them.
+type CommitJob interface {
+	Job
+
+	// FlushBufferedCommits calls fn for each buffered commit in rev order,
+	// then clears the buffer and marks the job as "complete"
+	FlushBufferedCommits(ctx context.Context, fn func(c *BufferedCommit) error) error
+}
+
+// Reasons for a ReplayConflict
+const (
+	// ConflictGap is a commit whose since isn't the rev replayed before it,
+	// so commits between the two are missing
+	ConflictGap = "gap"
+	// ConflictMissingRecord is an update or delete of a record which isn't in
+	// the backfilled repo and wasn't created by an earlier buffered op
+	ConflictMissingRecord = "missing_record"
+	// ConflictRecordExists is a create of a record which already exists
+	ConflictRecordExists = "record_exists"
+)
+
+// ReplayConflict describes a buffered commit or op which doesn't follow on
+// from the state of the repo when it is replayed. The op is still replayed,
+// but the caller may want to backfill the repo again.
+type ReplayConflict struct {
+	Reason string
+	Rev    string
+	Since  string
+	// Kind and Path are set for op conflicts
+	Kind string
+	Path string
+}
+
+// commitBuffer holds a job's buffered commits for the in-memory stores
+type commitBuffer struct {
+	commits []*BufferedCommit
+	lastRev string
+}
+
+func (cb *commitBuffer) add(c *BufferedCommit) {
+	cc := *c
+	if cc.Rev == "" {
+		cc.Rev = cb.lastRev
+	} else if cc.Rev > cb.lastRev {
+		cb.lastRev = cc.Rev
+	}
+	cb.commits = append(cb.commits, &cc)
+}
+
+// sorted orders the buffered commits by rev, keeping arrival order for
+// commits with the same rev, and returns them
+func (cb *commitBuffer) sorted() []*BufferedCommit {
+	sort.SliceStable(cb.commits, func(i, j int) bool {
+		return cb.commits[i].Rev < cb.commits[j].Rev
+	})
+	return cb.commits
+}
+
+func (cb *commitBuffer) reset() {
+	cb.commits = nil
+}
+
+// flushOps calls fn for each op of each commit in order
+func flushOps(commits []*BufferedCommit, fn func(kind, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) error) error {
+	for _, c := range commits {
+		for _, op := range c.Ops {
+			if err := fn(op.Kind, op.Path, op.Record, op.Cid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replayer tracks the state of a repo while buffered commits are replayed
+// on top of the backfilled snapshot.
+type replayer struct {
+	snapshotRev string
+	lastRev     string
+	tree        *mst.MerkleSearchTree
+	// records changed by replayed ops; cid.Undef for deleted records
+	overlay map[string]cid.Cid
+}
+
+func newReplayer(r *repo.Repo) *replayer {
+	rev := r.SignedCommit().Rev
+	return &replayer{
+		snapshotRev: rev,
+		lastRev:     rev,
+		tree:        mst.LoadMST(util.CborStore(r.Blockstore()), r.DataCid()),
+		overlay:     make(map[string]cid.Cid),
+	}
+}
+
+// stale reports whether a commit is already included in the snapshot
+func (rp *replayer) stale(c *BufferedCommit) bool {
+	return rp.snapshotRev != "" && c.Rev != "" && c.Rev <= rp.snapshotRev
+}
+
+func (rp *replayer) exists(ctx context.Context, path string) (bool, error) {
+	if c, ok := rp.overlay[path]; ok {
+		return c.Defined(), nil
+	}
+	_, err := rp.tree.Get(ctx, path)
+	if errors.Is(err, mst.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// apply checks a commit against the current state, returning any
+// conflicts, and then updates the state with its ops. match selects the ops
+// to check.
+func (rp *replayer) apply(ctx context.Context, c *BufferedCommit, match func(path string) bool) []*ReplayConflict {
+	var conflicts []*ReplayConflict
+	if c.Rev != "" && c.Rev != rp.lastRev {
+		if c.Since != nil && rp.lastRev != "" && *c.Since != rp.lastRev {
+			conflicts = append(conflicts, &ReplayConflict{Reason: ConflictGap, Rev: c.Rev, Since: *c.Since})
+		}
+		rp.lastRev = c.Rev
+	}
+
+	for _, op := range c.Ops {
+		if match(op.Path) {
+			var reason string
+			exists, err := rp.exists(ctx, op.Path)
+			switch {
+			case err != nil:
+				// can't tell; don't report a conflict we aren't sure of
+			case repomgr.EventKind(op.Kind) == repomgr.EvtKindCreateRecord && exists:
+				reason = ConflictRecordExists
+			case repomgr.EventKind(op.Kind) != repomgr.EvtKindCreateRecord && !exists:
+				reason = ConflictMissingRecord
+			}
+			if reason != "" {
+				conflicts = append(conflicts, &ReplayConflict{Reason: reason, Rev: c.Rev, Kind: op.Kind, Path: op.Path})
+			}
+		}
+
+		if repomgr.EventKind(op.Kind) == repomgr.EvtKindDeleteRecord || op.Cid == nil {
+			rp.overlay[op.Path] = cid.Undef
+		} else {
+			rp.overlay[op.Path] = *op.Cid
+		}
+	}
+	return conflicts
+}
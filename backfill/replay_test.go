@@ -0,0 +1,148 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/redis/go-redis/v9"
+	typegen "github.com/whyrusleeping/cbor-gen"
+)
+
+// bufferingStore is the part of the stores' API used to buffer commits
+type bufferingStore interface {
+	Store
+	EnqueueJob(repo string) error
+	BufferCommit(ctx context.Context, repo string, c *BufferedCommit) (bool, error)
+}
+
+func TestReplayBufferedCommits(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	did := "did:plc:testreplay"
+
+	// greenground is at rev 3k67up3j7hf2x, with two follows
+	car, err := os.ReadFile("../testing/testdata/greenground.repo.car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "repo.car"), car, 0644); err != nil {
+		t.Fatal(err)
+	}
+	ent, _ := json.Marshal(ArchiveEntry{Did: did, Key: "repo.car"})
+	if err := os.WriteFile(filepath.Join(dir, "manifest.jsonl"), ent, 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewArchiveSource(ctx, &DirObjectStore{Root: dir}, "manifest.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := typegen.CBORMarshaler(&bsky.GraphFollow{Subject: "did:plc:other", CreatedAt: "2024-01-01T00:00:00Z"})
+	c, _ := cid.Decode("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+	op := func(kind, path string) *BufferedOp {
+		return &BufferedOp{Kind: kind, Path: path, Record: &rec, Cid: &c}
+	}
+	str := func(s string) *string { return &s }
+
+	// commits arrive out of order; replayed in rev order, only the last has
+	// conflicts
+	commits := []*BufferedCommit{
+		{Rev: "3k67up3j7hg23", Since: str("3k67up3j7hg22"), Ops: []*BufferedOp{op("update", "app.bsky.graph.follow/new")}},
+		{Rev: "3k67up3j7hg22", Since: str("3k67up3j7hf2x"), Ops: []*BufferedOp{op("create", "app.bsky.graph.follow/new")}},
+		{Rev: "3k67up3j7hf2a", Ops: []*BufferedOp{op("create", "app.bsky.graph.follow/stale")}},
+		{Rev: "3k67up3j7hg25", Since: str("3k67up3j7hg24"), Ops: []*BufferedOp{
+			op("update", "app.bsky.graph.follow/missing"),
+			op("create", "app.bsky.graph.follow/3k5vze25v5a26"),
+		}},
+	}
+	wantOps := []string{
+		"create app.bsky.graph.follow/new",
+		"update app.bsky.graph.follow/new",
+		"update app.bsky.graph.follow/missing",
+		"create app.bsky.graph.follow/3k5vze25v5a26",
+	}
+	wantConflicts := []string{
+		ConflictGap + " 3k67up3j7hg25 ",
+		ConflictMissingRecord + " 3k67up3j7hg25 app.bsky.graph.follow/missing",
+		ConflictRecordExists + " 3k67up3j7hg25 app.bsky.graph.follow/3k5vze25v5a26",
+	}
+
+	stores := map[string]func() bufferingStore{
+		"memstore": func() bufferingStore { return NewMemstore() },
+		"redis": func() bufferingStore {
+			client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+			return NewRedisStore(client, "test:")
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+			if err := store.EnqueueJob(did); err != nil {
+				t.Fatal(err)
+			}
+			job, err := store.GetNextEnqueuedJob(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := job.SetState(ctx, StateInProgress); err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range commits {
+				if buffered, err := store.BufferCommit(ctx, did, c); err != nil || !buffered {
+					t.Fatalf("commit not buffered: %v", err)
+				}
+			}
+
+			var lk sync.Mutex
+			var ops, conflicts []string
+			record := func(kind, path string) {
+				lk.Lock()
+				ops = append(ops, kind+" "+path)
+				lk.Unlock()
+			}
+			bf := NewBackfiller("replay-test", store, nil, nil, nil, nil)
+			bf.Source = src
+			bf.HandleCreateRecord = func(ctx context.Context, repo string, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) error {
+				record("create", path)
+				return nil
+			}
+			bf.HandleUpdateRecord = func(ctx context.Context, repo string, path string, rec *typegen.CBORMarshaler, cid *cid.Cid) error {
+				record("update", path)
+				return nil
+			}
+			bf.HandleReplayConflict = func(ctx context.Context, repo string, c *ReplayConflict) {
+				conflicts = append(conflicts, c.Reason+" "+c.Rev+" "+c.Path)
+			}
+			bf.BackfillRepo(ctx, job)
+
+			// the two backfilled records come first
+			if len(ops) != 2+len(wantOps) {
+				t.Fatalf("unexpected ops: %v", ops)
+			}
+			for i, want := range wantOps {
+				if ops[2+i] != want {
+					t.Fatalf("unexpected replay order: %v", ops[2:])
+				}
+			}
+			if len(conflicts) != len(wantConflicts) {
+				t.Fatalf("unexpected conflicts: %v", conflicts)
+			}
+			for i, want := range wantConflicts {
+				if conflicts[i] != want {
+					t.Fatalf("unexpected conflicts: %v", conflicts)
+				}
+			}
+			if job.State() != StateComplete {
+				t.Fatalf("expected job to be complete, got %s", job.State())
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// JobCounter is implemented by stores which can count their jobs by state,
+// for the backfill_jobs metric and the status endpoint.
+type JobCounter interface {
+	CountJobsByState(ctx context.Context) (map[string]int, error)
+}
+
+// Phases of an in-flight job, as reported by the status endpoint
+const (
+	phaseFetching = "fetching"
+	phaseRecords  = "records"
+	phaseFlushing = "flushing"
+)
+
+// jobProgress tracks a job while BackfillRepo is working on it
+type jobProgress struct {
+	repo    string
+	started time.Time
+	bytes   atomic.Int64
+	records atomic.Int64
+	phase   atomic.Value
+}
+
+// JobStatus is the progress of an in-flight job
+type JobStatus struct {
+	Repo             string    `json:"repo"`
+	Phase            string    `json:"phase"`
+	StartedAt        time.Time `json:"startedAt"`
+	Seconds          float64   `json:"seconds"`
+	BytesRead        int64     `json:"bytesRead"`
+	RecordsProcessed int64     `json:"recordsProcessed"`
+	BytesPerSecond   float64   `json:"bytesPerSecond"`
+	RecordsPerSecond float64   `json:"recordsPerSecond"`
+}
+
+// Status is a snapshot of what a Backfiller is doing
+type Status struct {
+	Name string `json:"name"`
+	// JobsByState is only set if the store implements JobCounter
+	JobsByState map[string]int `json:"jobsByState,omitempty"`
+	InFlight    []JobStatus    `json:"inFlight"`
+	// Hosts is only set if the backfiller has a RateController
+	Hosts []HostStatus `json:"hosts,omitempty"`
+}
+
+func (b *Backfiller) startProgress(repo string) *jobProgress {
+	p := &jobProgress{repo: repo, started: time.Now()}
+	p.phase.Store(phaseFetching)
+
+	b.progressLk.Lock()
+	defer b.progressLk.Unlock()
+	if b.inFlight == nil {
+		b.inFlight = make(map[string]*jobProgress)
+	}
+	b.inFlight[repo] = p
+	backfillJobsInFlight.WithLabelValues(b.Name).Inc()
+	return p
+}
+
+func (b *Backfiller) finishProgress(p *jobProgress) {
+	b.progressLk.Lock()
+	defer b.progressLk.Unlock()
+	delete(b.inFlight, p.repo)
+	backfillJobsInFlight.WithLabelValues(b.Name).Dec()
+	backfillJobDuration.WithLabelValues(b.Name).Observe(time.Since(p.started).Seconds())
+}
+
+// Status reports the backfiller's in-flight jobs, longest running first.
+func (b *Backfiller) Status(ctx context.Context) (*Status, error) {
+	st := &Status{Name: b.Name, InFlight: []JobStatus{}}
+
+	if jc, ok := b.Store.(JobCounter); ok {
+		counts, err := jc.CountJobsByState(ctx)
+		if err != nil {
+			return nil, err
+		}
+		st.JobsByState = counts
+	}
+
+	now := time.Now()
+	b.progressLk.Lock()
+	for _, p := range b.inFlight {
+		js := JobStatus{
+			Repo:             p.repo,
+			Phase:            p.phase.Load().(string),
+			StartedAt:        p.started,
+			Seconds:          now.Sub(p.started).Seconds(),
+			BytesRead:        p.bytes.Load(),
+			RecordsProcessed: p.records.Load(),
+		}
+		if js.Seconds > 0 {
+			js.BytesPerSecond = float64(js.BytesRead) / js.Seconds
+			js.RecordsPerSecond = float64(js.RecordsProcessed) / js.Seconds
+		}
+		st.InFlight = append(st.InFlight, js)
+	}
+	b.progressLk.Unlock()
+	sort.Slice(st.InFlight, func(i, j int) bool {
+		return st.InFlight[i].StartedAt.Before(st.InFlight[j].StartedAt)
+	})
+
+	if b.RateController != nil {
+		st.Hosts = b.RateController.Hosts()
+		sort.Slice(st.Hosts, func(i, j int) bool { return st.Hosts[i].Host < st.Hosts[j].Host })
+	}
+	return st, nil
+}
+
+// StatusHandler serves the backfiller's Status as JSON.
+func (b *Backfiller) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st, err := b.Status(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	})
+}
+
+// reportJobStates periodically updates the backfill_jobs metric from the
+// store, until stop is closed.
+func (b *Backfiller) reportJobStates(ctx context.Context, jc JobCounter, stop <-chan struct{}) {
+	log := slog.With("source", "backfiller", "name", b.Name)
+	t := time.NewTicker(15 * time.Second)
+	defer t.Stop()
+
+	update := func() {
+		counts, err := jc.CountJobsByState(ctx)
+		if err != nil {
+			log.Error("failed to count jobs by state", "error", err)
+			return
+		}
+		backfillJobsByState.DeletePartialMatch(map[string]string{"backfiller_name": b.Name})
+		for state, n := range counts {
+			backfillJobsByState.WithLabelValues(b.Name, state).Set(float64(n))
+		}
+	}
+
+	update()
+	for {
+		select {
+		case <-t.C:
+			update()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusHandler(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemstore()
+	for _, did := range []string{"did:plc:a", "did:plc:b", "did:plc:c"} {
+		if err := mem.EnqueueJob(did); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job, err := mem.GetNextEnqueuedJob(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.SetState(ctx, StateInProgress); err != nil {
+		t.Fatal(err)
+	}
+
+	bf := NewBackfiller("status-test", mem, nil, nil, nil, nil)
+	p := bf.startProgress(job.Repo())
+	p.records.Add(10)
+
+	rec := httptest.NewRecorder()
+	bf.StatusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/backfill/status", nil))
+
+	var st Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.JobsByState[StateEnqueued] != 2 || st.JobsByState[StateInProgress] != 1 {
+		t.Fatalf("unexpected job counts: %v", st.JobsByState)
+	}
+	if len(st.InFlight) != 1 || st.InFlight[0].Repo != job.Repo() || st.InFlight[0].RecordsProcessed != 10 || st.InFlight[0].Phase != phaseFetching {
+		t.Fatalf("unexpected in-flight jobs: %+v", st.InFlight)
+	}
+
+	bf.finishProgress(p)
+	st2, err := bf.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st2.InFlight) != 0 {
+		t.Fatalf("finished job still in flight: %+v", st2.InFlight)
+	}
+}
@@ -2,6 +2,7 @@ package backfill
 
 import (
 	"io"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -9,11 +10,16 @@ import (
 type instrumentedReader struct {
 	source  io.ReadCloser
 	counter prometheus.Counter
+	// total, if set, also counts the bytes read
+	total *atomic.Int64
 }
 
 func (r instrumentedReader) Read(b []byte) (int, error) {
 	n, err := r.source.Read(b)
 	r.counter.Add(float64(n))
+	if r.total != nil {
+		r.total.Add(int64(n))
+	}
 	return n, err
 }
 
@@ -24,6 +30,9 @@ func (r instrumentedReader) Close() error {
 	for err == nil {
 		n, err = r.source.Read(buf[:])
 		r.counter.Add(float64(n))
+		if r.total != nil {
+			r.total.Add(int64(n))
+		}
 	}
 	closeerr := r.source.Close()
 	if err != nil && err != io.EOF {
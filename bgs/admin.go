@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/models"
 	"github.com/labstack/echo/v4"
 	dto "github.com/prometheus/client_model/go"
@@ -448,6 +449,43 @@ func (bgs *BGS) handleAdminCompactRepo(e echo.Context) error {
 	})
 }
 
+func (bgs *BGS) handleAdminCollectGarbage(e echo.Context) error {
+	ctx, span := otel.Tracer("bgs").Start(context.Background(), "adminCollectGarbage")
+	defer span.End()
+
+	did := e.QueryParam("did")
+	if did == "" {
+		return fmt.Errorf("must pass a did")
+	}
+
+	opts := carstore.DefaultGCOptions()
+	if strings.ToLower(e.QueryParam("dry")) == "true" {
+		opts.DryRun = true
+	}
+	if win := e.QueryParam("window"); win != "" {
+		d, err := time.ParseDuration(win)
+		if err != nil {
+			return fmt.Errorf("invalid safety window: %w", err)
+		}
+		opts.SafetyWindow = d
+	}
+
+	u, err := bgs.lookupUserByDid(ctx, did)
+	if err != nil {
+		return fmt.Errorf("no such user: %w", err)
+	}
+
+	stats, err := bgs.repoman.CarStore().CollectGarbage(ctx, u.ID, opts)
+	if err != nil {
+		return fmt.Errorf("garbage collection failed: %w", err)
+	}
+
+	return e.JSON(200, map[string]any{
+		"success": "true",
+		"stats":   stats,
+	})
+}
+
 func (bgs *BGS) handleAdminCompactAllRepos(e echo.Context) error {
 	ctx, span := otel.Tracer("bgs").Start(context.Background(), "adminCompactAllRepos")
 	defer span.End()
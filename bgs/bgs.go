@@ -19,6 +19,7 @@ import (
 	"github.com/bluesky-social/indigo/api"
 	atproto "github.com/bluesky-social/indigo/api/atproto"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	label "github.com/bluesky-social/indigo/api/label"
 	"github.com/bluesky-social/indigo/blobs"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/did"
@@ -87,6 +88,9 @@ type BGS struct {
 
 	// Management of Compaction
 	compactor *Compactor
+
+	// optional relaying of labeler streams
+	labels *LabelRelay
 }
 
 type PDSResync struct {
@@ -291,7 +295,7 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 			}
 		default:
 			sendHeader := true
-			if ctx.Path() == "/xrpc/com.atproto.sync.subscribeRepos" {
+			if ctx.Path() == "/xrpc/com.atproto.sync.subscribeRepos" || ctx.Path() == "/xrpc/com.atproto.label.subscribeLabels" {
 				sendHeader = false
 			}
 
@@ -313,6 +317,7 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 	// TODO: this API is temporary until we formalize what we want here
 
 	e.GET("/xrpc/com.atproto.sync.subscribeRepos", bgs.EventsHandler)
+	e.GET("/xrpc/com.atproto.label.subscribeLabels", bgs.EventsHandler)
 	e.GET("/xrpc/com.atproto.sync.getRecord", bgs.HandleComAtprotoSyncGetRecord)
 	e.GET("/xrpc/com.atproto.sync.getRepo", bgs.HandleComAtprotoSyncGetRepo)
 	e.GET("/xrpc/com.atproto.sync.getBlocks", bgs.HandleComAtprotoSyncGetBlocks)
@@ -341,6 +346,7 @@ func (bgs *BGS) StartWithListener(listen net.Listener) error {
 	admin.POST("/repo/reverseTakedown", bgs.handleAdminReverseTakedown)
 	admin.POST("/repo/compact", bgs.handleAdminCompactRepo)
 	admin.POST("/repo/compactAll", bgs.handleAdminCompactAllRepos)
+	admin.POST("/repo/gc", bgs.handleAdminCollectGarbage)
 	admin.POST("/repo/reset", bgs.handleAdminResetRepo)
 
 	// PDS-related Admin API
@@ -372,9 +378,29 @@ func (bgs *BGS) Shutdown() []error {
 
 	bgs.compactor.Shutdown()
 
+	if bgs.labels != nil {
+		bgs.labels.Shutdown()
+	}
+
 	return errs
 }
 
+// StartLabelRelay subscribes to the label streams of the given labeler hosts,
+// and relays them: on their own at com.atproto.label.subscribeLabels, and to
+// subscribeRepos consumers which ask for them.
+func (bgs *BGS) StartLabelRelay(hosts []string, retention time.Duration) error {
+	lr, err := NewLabelRelay(bgs.db, bgs.ssl)
+	if err != nil {
+		return err
+	}
+	lr.Retention = retention
+	if err := lr.Start(hosts); err != nil {
+		return err
+	}
+	bgs.labels = lr
+	return nil
+}
+
 type HealthStatus struct {
 	Status  string `json:"status"`
 	Message string `json:"msg,omitempty"`
@@ -503,7 +529,13 @@ func (bgs *BGS) cleanupConsumer(id uint64) {
 	delete(bgs.consumers, id)
 }
 
+// EventsHandler streams repo events (subscribeRepos) or relayed labels
+// (subscribeLabels). subscribeRepos consumers can also get labels in the same
+// stream, as "#labels" frames, by passing labels=true (new labels only) or a
+// labelCursor; label sequence numbers are separate from repo event ones.
 func (bgs *BGS) EventsHandler(c echo.Context) error {
+	labelsOnly := c.Path() == "/xrpc/com.atproto.label.subscribeLabels"
+
 	var since *int64
 	if sinceVal := c.QueryParam("cursor"); sinceVal != "" {
 		sval, err := strconv.ParseInt(sinceVal, 10, 64)
@@ -513,6 +545,22 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 		since = &sval
 	}
 
+	wantLabels := labelsOnly || c.QueryParam("labels") == "true"
+	var labelSince *int64
+	if labelsOnly {
+		labelSince = since
+	} else if lcVal := c.QueryParam("labelCursor"); lcVal != "" {
+		lval, err := strconv.ParseInt(lcVal, 10, 64)
+		if err != nil {
+			return err
+		}
+		labelSince = &lval
+		wantLabels = true
+	}
+	if wantLabels && bgs.labels == nil {
+		return echo.NewHTTPError(http.StatusNotImplemented, "label relaying is not enabled")
+	}
+
 	ctx, cancel := context.WithCancel(c.Request().Context())
 	defer cancel()
 
@@ -578,11 +626,26 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 
 	ident := c.RealIP() + "-" + c.Request().UserAgent()
 
-	evts, cleanup, err := bgs.events.Subscribe(ctx, ident, func(evt *events.XRPCStreamEvent) bool { return true }, since)
-	if err != nil {
-		return err
+	// a nil channel is never ready, so leaves out that kind of event
+	var evts <-chan *events.XRPCStreamEvent
+	if !labelsOnly {
+		repoEvts, cleanup, err := bgs.events.Subscribe(ctx, ident, func(evt *events.XRPCStreamEvent) bool { return true }, since)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		evts = repoEvts
+	}
+
+	var labelEvts <-chan *label.SubscribeLabels_Labels
+	if wantLabels {
+		lblEvts, cleanup, err := bgs.labels.Subscribe(ctx, labelSince)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		labelEvts = lblEvts
 	}
-	defer cleanup()
 
 	// Keep track of the consumer for metrics and admin endpoints
 	consumer := SocketConsumer{
@@ -606,6 +669,32 @@ func (bgs *BGS) EventsHandler(c echo.Context) error {
 	header := events.EventHeader{Op: events.EvtKindMessage}
 	for {
 		select {
+		case lbls, ok := <-labelEvts:
+			if !ok {
+				// closed if we fell too far behind
+				return nil
+			}
+			wc, err := conn.NextWriter(websocket.BinaryMessage)
+			if err != nil {
+				log.Errorf("failed to get next writer: %s", err)
+				return err
+			}
+			lheader := events.EventHeader{Op: events.EvtKindMessage, MsgType: "#labels"}
+			if err := lheader.MarshalCBOR(wc); err != nil {
+				return fmt.Errorf("failed to write header: %w", err)
+			}
+			if err := lbls.MarshalCBOR(wc); err != nil {
+				return fmt.Errorf("failed to write labels: %w", err)
+			}
+			if err := wc.Close(); err != nil {
+				log.Warnf("failed to flush-close our event write: %s", err)
+				return nil
+			}
+
+			lastWriteLk.Lock()
+			lastWrite = time.Now()
+			lastWriteLk.Unlock()
+			sentCounter.Inc()
 		case evt, ok := <-evts:
 			if !ok {
 				return nil
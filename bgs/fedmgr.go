@@ -297,7 +297,7 @@ func (s *Slurper) subscribeWithRedialer(ctx context.Context, host *models.PDS, s
 		delete(s.active, host.Host)
 	}()
 
-	d := websocket.Dialer{}
+	d := websocket.Dialer{EnableCompression: true}
 
 	protocol := "ws"
 	if s.ssl {
@@ -0,0 +1,314 @@
+package bgs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	label "github.com/bluesky-social/indigo/api/label"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+// Labeler is an upstream labeling service whose label stream is relayed
+type Labeler struct {
+	gorm.Model
+	Host string `gorm:"unique"`
+	// last upstream sequence number received
+	Cursor int64
+}
+
+// RelayedLabels is a batch of labels received from a labeler. Seq is the
+// relay's own sequence number, separate from repo event sequence numbers.
+type RelayedLabels struct {
+	Seq       int64     `gorm:"primarykey"`
+	LabelerID uint      `gorm:"index"`
+	CreatedAt time.Time `gorm:"index"`
+	// CBOR-encoded label.SubscribeLabels_Labels, as received from upstream
+	Raw []byte
+}
+
+// LabelRelay subscribes to the label streams (com.atproto.label.subscribeLabels)
+// of a set of labelers, persists the label batches with its own sequence
+// numbers, and fans them out to subscribers.
+type LabelRelay struct {
+	db  *gorm.DB
+	ssl bool
+
+	// Retention is how long relayed labels are kept for replay
+	Retention time.Duration
+
+	lk        sync.Mutex
+	subs      map[uint64]chan *label.SubscribeLabels_Labels
+	nextSubID uint64
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// label subscribers which fall this far behind are disconnected
+const labelSubBuffer = 1000
+
+func NewLabelRelay(db *gorm.DB, ssl bool) (*LabelRelay, error) {
+	if err := db.AutoMigrate(Labeler{}, RelayedLabels{}); err != nil {
+		return nil, fmt.Errorf("migrating label relay tables: %w", err)
+	}
+	return &LabelRelay{
+		db:        db,
+		ssl:       ssl,
+		Retention: 72 * time.Hour,
+		subs:      make(map[uint64]chan *label.SubscribeLabels_Labels),
+		shutdown:  make(chan struct{}),
+	}, nil
+}
+
+// Start connects to each of the given labeler hosts (hostnames, optionally
+// with a port), resuming from the last cursor received from each.
+func (lr *LabelRelay) Start(hosts []string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-lr.shutdown
+		cancel()
+	}()
+
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		var l Labeler
+		if err := lr.db.Where(Labeler{Host: h}).FirstOrCreate(&l).Error; err != nil {
+			return fmt.Errorf("loading labeler %s: %w", h, err)
+		}
+		lr.wg.Add(1)
+		go func() {
+			defer lr.wg.Done()
+			lr.subscribeWithRedialer(ctx, l)
+		}()
+	}
+
+	lr.wg.Add(1)
+	go func() {
+		defer lr.wg.Done()
+		lr.pruneLoop(ctx)
+	}()
+	return nil
+}
+
+func (lr *LabelRelay) Shutdown() {
+	close(lr.shutdown)
+	lr.wg.Wait()
+}
+
+func (lr *LabelRelay) subscribeWithRedialer(ctx context.Context, l Labeler) {
+	d := websocket.Dialer{EnableCompression: true}
+
+	protocol := "ws"
+	if lr.ssl {
+		protocol = "wss"
+	}
+
+	var backoff int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		url := fmt.Sprintf("%s://%s/xrpc/com.atproto.label.subscribeLabels?cursor=%d", protocol, l.Host, l.Cursor)
+		con, _, err := d.DialContext(ctx, url, nil)
+		if err != nil {
+			log.Warnw("dialing labeler failed", "host", l.Host, "err", err, "backoff", backoff)
+			select {
+			case <-time.After(sleepForBackoff(backoff)):
+			case <-ctx.Done():
+				return
+			}
+			if backoff < 15 {
+				backoff++
+			}
+			continue
+		}
+		backoff = 0
+
+		log.Infow("connected to labeler", "host", l.Host, "cursor", l.Cursor)
+		if err := lr.handleConnection(ctx, &l, con); err != nil && !errors.Is(err, context.Canceled) {
+			log.Warnw("connection to labeler failed", "host", l.Host, "err", err)
+		}
+	}
+}
+
+func (lr *LabelRelay) handleConnection(ctx context.Context, l *Labeler, con *websocket.Conn) error {
+	rsc := &events.RepoStreamCallbacks{
+		LabelLabels: func(evt *label.SubscribeLabels_Labels) error {
+			if err := lr.ingest(l, evt); err != nil {
+				return fmt.Errorf("relaying labels from %s (%d): %w", l.Host, evt.Seq, err)
+			}
+			l.Cursor = evt.Seq
+			return nil
+		},
+		RepoInfo: func(info *comatproto.SyncSubscribeRepos_Info) error {
+			log.Infow("labeler info event", "name", info.Name, "message", info.Message, "host", l.Host)
+			return nil
+		},
+		Error: func(errf *events.ErrorFrame) error {
+			if errf.Error == "FutureCursor" {
+				if err := lr.db.Model(&Labeler{}).Where("id = ?", l.ID).Update("cursor", 0).Error; err != nil {
+					return err
+				}
+				l.Cursor = 0
+				return fmt.Errorf("got FutureCursor frame, reset cursor tracking for labeler")
+			}
+			return fmt.Errorf("error frame: %s: %s", errf.Error, errf.Message)
+		},
+	}
+
+	sched := sequential.NewScheduler("labeler-"+l.Host, rsc.EventHandler)
+	return events.HandleRepoStream(ctx, con, sched)
+}
+
+// ingest persists a batch of labels from a labeler, and sends it to all
+// subscribers with the relay's sequence number
+func (lr *LabelRelay) ingest(l *Labeler, evt *label.SubscribeLabels_Labels) error {
+	if len(evt.Labels) == 0 {
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	if err := evt.MarshalCBOR(buf); err != nil {
+		return err
+	}
+
+	// held while writing, so that sequence numbers are sent in order
+	lr.lk.Lock()
+	defer lr.lk.Unlock()
+
+	row := RelayedLabels{LabelerID: l.ID, Raw: buf.Bytes()}
+	if err := lr.db.Create(&row).Error; err != nil {
+		return err
+	}
+	if err := lr.db.Model(&Labeler{}).Where("id = ?", l.ID).Update("cursor", evt.Seq).Error; err != nil {
+		return err
+	}
+	labelsRelayedCounter.WithLabelValues(l.Host).Add(float64(len(evt.Labels)))
+
+	out := &label.SubscribeLabels_Labels{Labels: evt.Labels, Seq: row.Seq}
+	for id, ch := range lr.subs {
+		select {
+		case ch <- out:
+		default:
+			log.Warnw("dropping slow label subscriber", "id", id)
+			close(ch)
+			delete(lr.subs, id)
+		}
+	}
+	return nil
+}
+
+// Subscribe returns a channel of label batches, starting after the given
+// relay sequence number (or with new labels only, if since is nil). The
+// channel is closed if the subscriber falls too far behind.
+func (lr *LabelRelay) Subscribe(ctx context.Context, since *int64) (<-chan *label.SubscribeLabels_Labels, func(), error) {
+	live := make(chan *label.SubscribeLabels_Labels, labelSubBuffer)
+
+	lr.lk.Lock()
+	id := lr.nextSubID
+	lr.nextSubID++
+	lr.subs[id] = live
+	lr.lk.Unlock()
+
+	cleanup := func() {
+		lr.lk.Lock()
+		defer lr.lk.Unlock()
+		if ch, ok := lr.subs[id]; ok {
+			close(ch)
+			delete(lr.subs, id)
+		}
+	}
+
+	if since == nil {
+		return live, cleanup, nil
+	}
+
+	// replay persisted labels first. The live subscription is registered
+	// before reading them, so nothing is missed in between; anything it
+	// already holds which was also replayed is skipped.
+	out := make(chan *label.SubscribeLabels_Labels)
+	go func() {
+		defer close(out)
+		last := *since
+		for {
+			var rows []RelayedLabels
+			if err := lr.db.Where("seq > ?", last).Order("seq asc").Limit(500).Find(&rows).Error; err != nil {
+				log.Errorw("failed to replay relayed labels", "err", err)
+				return
+			}
+			if len(rows) == 0 {
+				break
+			}
+			for _, row := range rows {
+				var evt label.SubscribeLabels_Labels
+				if err := evt.UnmarshalCBOR(bytes.NewReader(row.Raw)); err != nil {
+					log.Errorw("failed to decode relayed labels", "seq", row.Seq, "err", err)
+					continue
+				}
+				evt.Seq = row.Seq
+				select {
+				case out <- &evt:
+				case <-ctx.Done():
+					return
+				}
+				last = row.Seq
+			}
+		}
+
+		for {
+			select {
+			case evt, ok := <-live:
+				if !ok {
+					return
+				}
+				if evt.Seq <= last {
+					continue
+				}
+				select {
+				case out <- evt:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, cleanup, nil
+}
+
+func (lr *LabelRelay) pruneLoop(ctx context.Context) {
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if lr.Retention <= 0 {
+				continue
+			}
+			res := lr.db.Where("created_at < ?", time.Now().Add(-lr.Retention)).Delete(&RelayedLabels{})
+			if res.Error != nil {
+				log.Errorw("failed to prune relayed labels", "err", res.Error)
+			} else if res.RowsAffected > 0 {
+				log.Infow("pruned relayed labels", "count", res.RowsAffected)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
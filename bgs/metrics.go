@@ -22,6 +22,11 @@ var eventsHandleDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
 	Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
 }, []string{"pds"})
 
+var labelsRelayedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "labels_relayed_counter",
+	Help: "The total number of labels received from labelers and relayed",
+}, []string{"labeler"})
+
 var repoCommitsReceivedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "repo_commits_received_counter",
 	Help: "The total number of events received",
@@ -3,6 +3,7 @@ package blobs
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 )
@@ -14,6 +15,18 @@ type BlobStore interface {
 	GetBlob(ctx context.Context, cid string, did string) ([]byte, error)
 }
 
+// StreamingBlobStore is a BlobStore that can move blobs without holding
+// them in memory, and can delete them
+type StreamingBlobStore interface {
+	BlobStore
+
+	// PutBlobStream stores size bytes read from r
+	PutBlobStream(ctx context.Context, cid string, did string, r io.Reader, size int64) error
+	GetBlobStream(ctx context.Context, cid string, did string) (io.ReadCloser, error)
+	// DeleteBlob removes a blob; deleting a missing blob is not an error
+	DeleteBlob(ctx context.Context, cid string, did string) error
+}
+
 type DiskBlobStore struct {
 	Dir string
 }
@@ -38,3 +51,55 @@ func (dbs *DiskBlobStore) GetBlob(ctx context.Context, cid string, did string) (
 	}
 	return os.ReadFile(filepath.Join(dbs.Dir, did, cid))
 }
+
+func (dbs *DiskBlobStore) PutBlobStream(ctx context.Context, cid string, did string, r io.Reader, size int64) error {
+	udir := filepath.Join(dbs.Dir, did)
+	if err := os.MkdirAll(udir, 0775); err != nil {
+		return err
+	}
+
+	// write to a temp file first so readers never see a partial blob
+	tmp, err := os.CreateTemp(udir, ".tmp-"+cid)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if n != size {
+		tmp.Close()
+		return fmt.Errorf("blob size mismatch: expected %d bytes, got %d", size, n)
+	}
+	if err := tmp.Chmod(0664); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(udir, cid))
+}
+
+func (dbs *DiskBlobStore) GetBlobStream(ctx context.Context, cid string, did string) (io.ReadCloser, error) {
+	fi, err := os.Open(filepath.Join(dbs.Dir, did, cid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, NotFoundErr
+		}
+		return nil, err
+	}
+	return fi, nil
+}
+
+func (dbs *DiskBlobStore) DeleteBlob(ctx context.Context, cid string, did string) error {
+	err := os.Remove(filepath.Join(dbs.Dir, did, cid))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/util/awssig"
+)
+
+// S3BlobStore stores blobs in an S3 compatible bucket. Blobs are content
+// addressed, under the key <Prefix><did>/<cid>.
+type S3BlobStore struct {
+	// Endpoint is the service URL, eg "https://s3.us-east-1.amazonaws.com"
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+
+	// PathStyle addresses the bucket as Endpoint/Bucket/key instead of as a
+	// subdomain of Endpoint. Most S3 compatible services want this.
+	PathStyle bool
+
+	Client *http.Client
+}
+
+func NewS3BlobStore(endpoint, region, bucket, accessKey, secretKey string) *S3BlobStore {
+	return &S3BlobStore{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+func (s3 *S3BlobStore) PutBlob(ctx context.Context, cid string, did string, blob []byte) error {
+	return s3.PutBlobStream(ctx, cid, did, bytes.NewReader(blob), int64(len(blob)))
+}
+
+func (s3 *S3BlobStore) GetBlob(ctx context.Context, cid string, did string) ([]byte, error) {
+	r, err := s3.GetBlobStream(ctx, cid, did)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func (s3 *S3BlobStore) PutBlobStream(ctx context.Context, cid string, did string, r io.Reader, size int64) error {
+	// the body is streamed, so it can't be part of the signature
+	resp, err := s3.do(ctx, "PUT", s3.key(cid, did), r, size, awssig.UnsignedPayload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s3 *S3BlobStore) GetBlobStream(ctx context.Context, cid string, did string) (io.ReadCloser, error) {
+	resp, err := s3.do(ctx, "GET", s3.key(cid, did), nil, 0, awssig.EmptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s3 *S3BlobStore) DeleteBlob(ctx context.Context, cid string, did string) error {
+	resp, err := s3.do(ctx, "DELETE", s3.key(cid, did), nil, 0, awssig.EmptyPayloadHash)
+	if err != nil {
+		if err == NotFoundErr {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s3 *S3BlobStore) key(cid, did string) string {
+	return s3.Prefix + did + "/" + cid
+}
+
+// objectURL returns the object's URL with its path escaped the way SigV4
+// expects, so the path we sign is the path we send
+func (s3 *S3BlobStore) objectURL(key string) (string, error) {
+	u, err := url.Parse(s3.Endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+
+	path := "/" + awssig.EscapePath(key)
+	if s3.PathStyle {
+		path = "/" + awssig.EscapePath(s3.Bucket) + path
+	} else {
+		u.Host = s3.Bucket + "." + u.Host
+	}
+	return u.Scheme + "://" + u.Host + path, nil
+}
+
+func (s3 *S3BlobStore) do(ctx context.Context, method, key string, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	ourl, err := s3.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, ourl, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s3.signRequest(req, payloadHash, time.Now())
+
+	resp, err := s3.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, NotFoundErr
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, msg)
+	}
+	return resp, nil
+}
+
+// signRequest adds AWS Signature Version 4 headers to req, covering the host
+// and every header already set
+func (s3 *S3BlobStore) signRequest(req *http.Request, payloadHash string, t time.Time) {
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	creds := awssig.Credentials{AccessKeyID: s3.AccessKey, SecretAccessKey: s3.SecretKey}
+	awssig.Sign(req, creds, s3.Region, "s3", payloadHash, t)
+}
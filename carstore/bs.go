@@ -45,6 +45,41 @@ type CarStore struct {
 
 	lscLk          sync.Mutex
 	lastShardCache map[models.Uid]*CarShard
+
+	// held while rewriting a user's shards (compaction and garbage
+	// collection), so only one rewrite of a user runs at a time
+	shardLkLk  sync.Mutex
+	shardLocks map[models.Uid]*userLock
+}
+
+type userLock struct {
+	lk    sync.Mutex
+	count int
+}
+
+// lockUserShards waits for any other compaction or garbage collection of
+// the user to finish, returning a func to release the lock
+func (cs *CarStore) lockUserShards(user models.Uid) func() {
+	cs.shardLkLk.Lock()
+	ulk, ok := cs.shardLocks[user]
+	if !ok {
+		ulk = &userLock{}
+		cs.shardLocks[user] = ulk
+	}
+	ulk.count++
+	cs.shardLkLk.Unlock()
+
+	ulk.lk.Lock()
+
+	return func() {
+		cs.shardLkLk.Lock()
+		ulk.lk.Unlock()
+		ulk.count--
+		if ulk.count == 0 {
+			delete(cs.shardLocks, user)
+		}
+		cs.shardLkLk.Unlock()
+	}
 }
 
 func NewCarStore(meta *gorm.DB, root string) (*CarStore, error) {
@@ -68,6 +103,7 @@ func NewCarStore(meta *gorm.DB, root string) (*CarStore, error) {
 		meta:           meta,
 		rootDir:        root,
 		lastShardCache: make(map[models.Uid]*CarShard),
+		shardLocks:     make(map[models.Uid]*userLock),
 	}, nil
 }
 
@@ -1280,6 +1316,9 @@ func (cs *CarStore) CompactUserShards(ctx context.Context, user models.Uid, skip
 
 	span.SetAttributes(attribute.Int64("user", int64(user)))
 
+	unlock := cs.lockUserShards(user)
+	defer unlock()
+
 	var shards []CarShard
 	if err := cs.meta.WithContext(ctx).Find(&shards, "usr = ?", user).Error; err != nil {
 		return nil, err
@@ -0,0 +1,261 @@
+package carstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repo"
+
+	blockformat "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type GCOptions struct {
+	// Shards written more recently than this are left alone, and their
+	// commits are treated as retained: anything they reference is kept.
+	// This protects writes which are in progress while the GC runs.
+	SafetyWindow time.Duration
+
+	// Only report what would be reclaimed
+	DryRun bool
+}
+
+func DefaultGCOptions() GCOptions {
+	return GCOptions{
+		SafetyWindow: time.Hour,
+	}
+}
+
+type GCStats struct {
+	DryRun          bool `json:"dryRun"`
+	Shards          int  `json:"shards"`
+	SkippedShards   int  `json:"skippedShards"`
+	RetainedCommits int  `json:"retainedCommits"`
+	TotalBlocks     int  `json:"totalBlocks"`
+	OrphanedBlocks  int  `json:"orphanedBlocks"`
+	// size of the orphaned blocks' data
+	OrphanedBytes int64 `json:"orphanedBytes"`
+	// shards which were (or would be) rewritten without their orphaned
+	// blocks, and those which were entirely orphaned
+	ShardsRewritten int `json:"shardsRewritten"`
+	ShardsDeleted   int `json:"shardsDeleted"`
+}
+
+// CollectGarbage reclaims the space used by a user's blocks which aren't
+// referenced by any retained commit: the current head, and the commits of all
+// shards inside the safety window. Blocks are kept if they are reachable from
+// those commits through the MST; previous commits are not followed.
+//
+// Unlike compaction, this doesn't rely on stale block tracking, so it also
+// cleans up blocks that tracking missed (eg, duplicates, or blocks written by
+// imports which were never referenced). It reads every block of the repo, so
+// is more expensive than compaction, which it waits for (and vice versa) if
+// run on the same user at the same time.
+func (cs *CarStore) CollectGarbage(ctx context.Context, user models.Uid, opts GCOptions) (*GCStats, error) {
+	ctx, span := otel.Tracer("carstore").Start(ctx, "CollectGarbage")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int64("user", int64(user)), attribute.Bool("dryRun", opts.DryRun))
+
+	unlock := cs.lockUserShards(user)
+	defer unlock()
+
+	var shards []CarShard
+	if err := cs.meta.WithContext(ctx).Find(&shards, "usr = ?", user).Error; err != nil {
+		return nil, err
+	}
+
+	stats := &GCStats{
+		DryRun: opts.DryRun,
+		Shards: len(shards),
+	}
+	if len(shards) == 0 {
+		return stats, nil
+	}
+
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i].Seq < shards[j].Seq
+	})
+
+	// the latest shard is always retained, since it defines the repo head
+	cutoff := time.Now().Add(-opts.SafetyWindow)
+	retained := make(map[uint]bool)
+	roots := make(map[cid.Cid]bool)
+	for i, sh := range shards {
+		if i == len(shards)-1 || sh.CreatedAt.After(cutoff) {
+			retained[sh.ID] = true
+			roots[sh.Root.CID] = true
+		}
+	}
+	stats.SkippedShards = len(retained)
+	stats.RetainedCommits = len(roots)
+
+	// read every block once, recording its links, and which blocks (and how
+	// much data) each shard holds
+	type shardBlock struct {
+		c    cid.Cid
+		size int
+	}
+	links := make(map[cid.Cid][]cid.Cid)
+	shardBlocks := make(map[uint][]shardBlock)
+	for i := range shards {
+		sh := &shards[i]
+		if err := cs.iterateShardBlocks(ctx, sh, func(blk blockformat.Block) error {
+			stats.TotalBlocks++
+			c := blk.Cid()
+			if !retained[sh.ID] {
+				shardBlocks[sh.ID] = append(shardBlocks[sh.ID], shardBlock{c: c, size: len(blk.RawData())})
+			}
+			if _, ok := links[c]; ok {
+				return nil
+			}
+
+			out, err := blockLinks(blk, roots[c])
+			if err != nil {
+				return fmt.Errorf("reading links of block %s in shard %d: %w", c, sh.ID, err)
+			}
+			links[c] = out
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	// mark everything reachable from the retained commits
+	keep := make(map[cid.Cid]bool)
+	var todo []cid.Cid
+	for c := range roots {
+		todo = append(todo, c)
+	}
+	for len(todo) > 0 {
+		c := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		if keep[c] {
+			continue
+		}
+		out, ok := links[c]
+		if !ok {
+			// not stored here (eg, a blob)
+			continue
+		}
+		keep[c] = true
+		todo = append(todo, out...)
+	}
+
+	var rewrite, remove []CarShard
+	for _, sh := range shards {
+		if retained[sh.ID] {
+			continue
+		}
+
+		var live, dead int
+		for _, b := range shardBlocks[sh.ID] {
+			if keep[b.c] {
+				live++
+			} else {
+				dead++
+				stats.OrphanedBytes += int64(b.size)
+			}
+		}
+		stats.OrphanedBlocks += dead
+
+		switch {
+		case dead == 0:
+		case live == 0:
+			remove = append(remove, sh)
+		default:
+			rewrite = append(rewrite, sh)
+		}
+	}
+	stats.ShardsRewritten = len(rewrite)
+	stats.ShardsDeleted = len(remove)
+
+	span.SetAttributes(
+		attribute.Int("blocks", stats.TotalBlocks),
+		attribute.Int("orphaned", stats.OrphanedBlocks),
+		attribute.Int("rewrite", len(rewrite)),
+		attribute.Int("delete", len(remove)),
+	)
+
+	if opts.DryRun || (len(rewrite) == 0 && len(remove) == 0) {
+		return stats, nil
+	}
+
+	shardsById := make(map[uint]CarShard)
+	var shardIds []uint
+	for _, sh := range shards {
+		shardsById[sh.ID] = sh
+		shardIds = append(shardIds, sh.ID)
+	}
+
+	// block refs are loaded before any shards are replaced, for cleaning up
+	// stale refs at the end
+	brefs, err := cs.getBlockRefsForShards(ctx, shardIds)
+	if err != nil {
+		return nil, fmt.Errorf("getting block refs failed: %w", err)
+	}
+	var staleRefs []staleRef
+	if err := cs.meta.WithContext(ctx).Find(&staleRefs, "usr = ?", user).Error; err != nil {
+		return nil, err
+	}
+
+	removedShards := make(map[uint]bool)
+	var todelete []*CarShard
+	for i := range rewrite {
+		sh := &rewrite[i]
+		b := &compBucket{shards: []shardStat{{ID: sh.ID, Seq: sh.Seq}}}
+		if err := cs.compactBucket(ctx, user, b, shardsById, keep); err != nil {
+			return nil, fmt.Errorf("rewriting shard %d: %w", sh.ID, err)
+		}
+		todelete = append(todelete, sh)
+	}
+	for i := range remove {
+		todelete = append(todelete, &remove[i])
+	}
+	for _, sh := range todelete {
+		removedShards[sh.ID] = true
+	}
+
+	if err := cs.deleteShards(ctx, todelete); err != nil {
+		return nil, fmt.Errorf("deleting shards: %w", err)
+	}
+
+	if err := cs.deleteStaleRefs(ctx, user, brefs, staleRefs, removedShards); err != nil {
+		return nil, err
+	}
+
+	log.Debugw("collected garbage", "uid", user, "orphaned", stats.OrphanedBlocks, "rewritten", stats.ShardsRewritten, "deleted", stats.ShardsDeleted)
+
+	return stats, nil
+}
+
+// blockLinks returns the CIDs a block links to. For retained commits, only the
+// MST root is followed, not the previous commit.
+func blockLinks(blk blockformat.Block, isCommit bool) ([]cid.Cid, error) {
+	if isCommit {
+		var sc repo.SignedCommit
+		if err := sc.UnmarshalCBOR(bytes.NewReader(blk.RawData())); err != nil {
+			return nil, fmt.Errorf("decoding commit: %w", err)
+		}
+		return []cid.Cid{sc.Data}, nil
+	}
+
+	if blk.Cid().Prefix().Codec != cid.DagCBOR {
+		return []cid.Cid{}, nil
+	}
+
+	out := []cid.Cid{}
+	if err := cbg.ScanForLinks(bytes.NewReader(blk.RawData()), func(c cid.Cid) {
+		out = append(out, c)
+	}); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -249,6 +249,200 @@ func TestRepeatedCompactions(t *testing.T) {
 	checkRepo(t, cs, buf, recs)
 }
 
+// setupGCRepo writes a repo for user 1 with 40 commits, some of which delete
+// records, so there are orphaned blocks to collect
+func setupGCRepo(t *testing.T, cs *CarStore) (cid.Cid, string, []cid.Cid) {
+	ctx := context.TODO()
+
+	ds, err := cs.NewDeltaSession(ctx, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ncid, rev, err := setupRepo(ctx, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ds.CloseWithRoot(ctx, ncid, rev); err != nil {
+		t.Fatal(err)
+	}
+
+	var recs []cid.Cid
+	head := ncid
+	var lastRec string
+	for i := 0; i < 40; i++ {
+		ds, err := cs.NewDeltaSession(ctx, 1, &rev)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr, err := repo.OpenRepo(ctx, ds, head, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%4 == 3 {
+			if err := rr.DeleteRecord(ctx, lastRec); err != nil {
+				t.Fatal(err)
+			}
+			recs = recs[:len(recs)-1]
+		} else {
+			rc, tid, err := rr.CreateRecord(ctx, "app.bsky.feed.post", &appbsky.FeedPost{
+				Text: fmt.Sprintf("hey look its a tweet %d", time.Now().UnixNano()),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recs = append(recs, rc)
+			lastRec = "app.bsky.feed.post/" + tid
+		}
+
+		kmgr := &util.FakeKeyManager{}
+		nroot, nrev, err := rr.Commit(ctx, kmgr.SignForUser)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rev = nrev
+
+		if err := ds.CalcDiff(ctx, nroot); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ds.CloseWithRoot(ctx, nroot, rev); err != nil {
+			t.Fatal(err)
+		}
+
+		head = nroot
+	}
+	return head, rev, recs
+}
+
+func TestConcurrentGCAndCompaction(t *testing.T) {
+	ctx := context.TODO()
+
+	cs, cleanup, err := testCarStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	_, _, recs := setupGCRepo(t, cs)
+
+	// both rewrite the same shards, so one has to wait for the other
+	errs := make(chan error, 2)
+	go func() {
+		_, err := cs.CollectGarbage(ctx, 1, GCOptions{})
+		errs <- err
+	}()
+	go func() {
+		_, err := cs.CompactUserShards(ctx, 1, false)
+		errs <- err
+	}()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := cs.ReadUserCar(ctx, 1, "", true, buf); err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, cs, buf, recs)
+}
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.TODO()
+
+	cs, cleanup, err := testCarStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+
+	head, rev, recs := setupGCRepo(t, cs)
+
+	// everything is inside the default safety window
+	st, err := cs.CollectGarbage(ctx, 1, DefaultGCOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.OrphanedBlocks != 0 || st.SkippedShards != st.Shards {
+		t.Fatalf("expected all shards to be skipped: %#v", st)
+	}
+
+	dry, err := cs.CollectGarbage(ctx, 1, GCOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dry.OrphanedBlocks == 0 || dry.ShardsRewritten+dry.ShardsDeleted == 0 {
+		t.Fatalf("expected orphaned blocks: %#v", dry)
+	}
+	stat, err := cs.Stat(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stat) != dry.Shards {
+		t.Fatal("dry run changed shards")
+	}
+
+	st, err = cs.CollectGarbage(ctx, 1, GCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.OrphanedBlocks != dry.OrphanedBlocks || st.ShardsDeleted != dry.ShardsDeleted {
+		t.Fatalf("GC doesn't match dry run: %#v != %#v", st, dry)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := cs.ReadUserCar(ctx, 1, "", true, buf); err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, cs, buf, recs)
+
+	st, err = cs.CollectGarbage(ctx, 1, GCOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.OrphanedBlocks != 0 {
+		t.Fatalf("expected no orphaned blocks after GC: %#v", st)
+	}
+
+	// the repo can still be written to
+	ds, err := cs.NewDeltaSession(ctx, 1, &rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, err := repo.OpenRepo(ctx, ds, head, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, _, err := rr.CreateRecord(ctx, "app.bsky.feed.post", &appbsky.FeedPost{Text: "after gc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs = append(recs, rc)
+	kmgr := &util.FakeKeyManager{}
+	nroot, nrev, err := rr.Commit(ctx, kmgr.SignForUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.CalcDiff(ctx, nroot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.CloseWithRoot(ctx, nroot, nrev); err != nil {
+		t.Fatal(err)
+	}
+
+	buf = new(bytes.Buffer)
+	if err := cs.ReadUserCar(ctx, 1, "", true, buf); err != nil {
+		t.Fatal(err)
+	}
+	checkRepo(t, cs, buf, recs)
+}
+
 func checkRepo(t *testing.T, cs *CarStore, r io.Reader, expRecs []cid.Cid) {
 	t.Helper()
 	rep, err := repo.ReadRepoFromCar(context.TODO(), r)
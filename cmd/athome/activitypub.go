@@ -0,0 +1,608 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/util"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Read-only ActivityPub: each account is an actor whose outbox is its posts,
+// as Notes, so it can be looked up and followed from Mastodon and other
+// fediverse servers. Follows are accepted, but followers aren't stored, so
+// new posts aren't delivered to them.
+
+const (
+	activityJSONType = "application/activity+json"
+	asContext        = "https://www.w3.org/ns/activitystreams"
+	asPublic         = "https://www.w3.org/ns/activitystreams#Public"
+	// the actor's username, as in @bsky@example.com, after the path athome
+	// serves pages under
+	apUsername = "bsky"
+	// posts per outbox page
+	apOutboxPageSize = 20
+	// largest activity or remote document read
+	apMaxBodySize = 1 << 20
+	// how long delivering an Accept to a follower's inbox may take
+	apDeliveryTimeout = 30 * time.Second
+)
+
+// activityPub is the configuration for serving ActivityPub; one key signs for
+// all the accounts served
+type activityPub struct {
+	key    *rsa.PrivateKey
+	pubPEM string
+	// for fetching remote actors and delivering to their inboxes, which may
+	// be anywhere
+	client *http.Client
+}
+
+// newActivityPub loads the actors' RSA private key from a PEM file
+func newActivityPub(keyPath string) (*activityPub, error) {
+	b, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKeyPEM(b)
+	if err != nil {
+		return nil, fmt.Errorf("ActivityPub key %s: %w", keyPath, err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &activityPub{
+		key:    key,
+		pubPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub})),
+		client: util.SafeHTTPClient(),
+	}, nil
+}
+
+type apActor struct {
+	Context                   []any             `json:"@context"`
+	ID                        string            `json:"id"`
+	Type                      string            `json:"type"`
+	PreferredUsername         string            `json:"preferredUsername"`
+	Name                      string            `json:"name"`
+	Summary                   string            `json:"summary"`
+	URL                       string            `json:"url"`
+	Inbox                     string            `json:"inbox"`
+	Outbox                    string            `json:"outbox"`
+	Icon                      *apImage          `json:"icon,omitempty"`
+	Image                     *apImage          `json:"image,omitempty"`
+	PublicKey                 apPublicKey       `json:"publicKey"`
+	ManuallyApprovesFollowers bool              `json:"manuallyApprovesFollowers"`
+	Discoverable              bool              `json:"discoverable"`
+	Attachment                []apPropertyValue `json:"attachment,omitempty"`
+}
+
+type apImage struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type apPublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type apPropertyValue struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type apNote struct {
+	Context      string       `json:"@context,omitempty"`
+	ID           string       `json:"id"`
+	Type         string       `json:"type"`
+	AttributedTo string       `json:"attributedTo"`
+	InReplyTo    string       `json:"inReplyTo,omitempty"`
+	Content      string       `json:"content"`
+	URL          string       `json:"url"`
+	Published    string       `json:"published"`
+	To           []string     `json:"to"`
+	Sensitive    bool         `json:"sensitive"`
+	Summary      string       `json:"summary,omitempty"`
+	Attachment   []apDocument `json:"attachment,omitempty"`
+}
+
+type apDocument struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType"`
+	URL       string `json:"url"`
+	Name      string `json:"name,omitempty"`
+}
+
+type apCreate struct {
+	ID        string   `json:"id"`
+	Type      string   `json:"type"`
+	Actor     string   `json:"actor"`
+	Published string   `json:"published"`
+	To        []string `json:"to"`
+	Object    *apNote  `json:"object"`
+}
+
+type apOutbox struct {
+	Context    string `json:"@context"`
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	TotalItems int64  `json:"totalItems"`
+	First      string `json:"first"`
+}
+
+type apOutboxPage struct {
+	Context      string     `json:"@context"`
+	ID           string     `json:"id"`
+	Type         string     `json:"type"`
+	PartOf       string     `json:"partOf"`
+	Next         string     `json:"next,omitempty"`
+	OrderedItems []apCreate `json:"orderedItems"`
+}
+
+func (srv *Server) apActorURL(c echo.Context) string {
+	return srv.siteURL(c) + "/bsky/ap/actor"
+}
+
+func (srv *Server) apKeyID(c echo.Context) string {
+	return srv.apActorURL(c) + "#main-key"
+}
+
+// wantsActivity is whether a request for an HTML page is from an ActivityPub
+// server (eg, a Mastodon search for the page's URL), which gets the actor or
+// Note instead
+func wantsActivity(c echo.Context) bool {
+	for _, part := range strings.Split(c.Request().Header.Get("Accept"), ",") {
+		mt, _, _ := strings.Cut(part, ";")
+		switch strings.ToLower(strings.TrimSpace(mt)) {
+		case activityJSONType, "application/ld+json":
+			return true
+		}
+	}
+	return false
+}
+
+// writeActivity returns an ActivityPub document, with an HTTP Signature, so
+// servers can check it came from the actor's key without fetching it again
+func (srv *Server) writeActivity(c echo.Context, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	h := c.Response().Header()
+	h.Add("Vary", "Accept")
+	if err := signHeaders(nil, h, b, srv.apKeyID(c), srv.ap.key); err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, activityJSONType+"; charset=utf-8", b)
+}
+
+// apProfile is the profile of the account a request is for, unless it is
+// hidden by the label policy
+func (srv *Server) apProfile(c echo.Context) (*appbsky.ActorDefs_ProfileViewDetailed, error) {
+	handle := srv.reqHandle(c)
+	pv, err := appbsky.ActorGetProfile(c.Request().Context(), srv.xrpcc, handle.String())
+	if err != nil {
+		slog.Warn("failed to fetch handle", "handle", handle, "err", err)
+		return nil, echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
+	}
+	if srv.labels.decide(pv.Labels).Hide {
+		return nil, echo.NewHTTPError(404, "account not available")
+	}
+	return pv, nil
+}
+
+func (srv *Server) APActor(c echo.Context) error {
+	pv, err := srv.apProfile(c)
+	if err != nil {
+		return err
+	}
+	base := srv.siteURL(c)
+	actorURL := srv.apActorURL(c)
+
+	actor := &apActor{
+		Context:           []any{asContext, "https://w3id.org/security/v1"},
+		ID:                actorURL,
+		Type:              "Person",
+		PreferredUsername: apUsername,
+		Name:              "@" + pv.Handle,
+		URL:               base + "/bsky",
+		Inbox:             base + "/bsky/ap/inbox",
+		Outbox:            base + "/bsky/ap/outbox",
+		PublicKey: apPublicKey{
+			ID:           srv.apKeyID(c),
+			Owner:        actorURL,
+			PublicKeyPem: srv.ap.pubPEM,
+		},
+		Discoverable: true,
+		Attachment: []apPropertyValue{{
+			Type:  "PropertyValue",
+			Name:  "Bluesky",
+			Value: fmt.Sprintf(`<a href="https://bsky.app/profile/%s" rel="me">@%s</a>`, url.PathEscape(pv.Did), html.EscapeString(pv.Handle)),
+		}},
+	}
+	if pv.DisplayName != nil && *pv.DisplayName != "" {
+		actor.Name = *pv.DisplayName
+	}
+	if pv.Description != nil {
+		actor.Summary = "<p>" + renderRichText(*pv.Description, nil, "") + "</p>"
+	}
+	if pv.Avatar != nil {
+		actor.Icon = &apImage{Type: "Image", URL: *pv.Avatar}
+	}
+	if pv.Banner != nil {
+		actor.Image = &apImage{Type: "Image", URL: *pv.Banner}
+	}
+	return srv.writeActivity(c, actor)
+}
+
+// APOutbox is the account's own top-level posts (the same as the RSS feed),
+// newest first. The collection itself only has the count; pages are
+// ?page=true, with a cursor for older ones.
+func (srv *Server) APOutbox(c echo.Context) error {
+	pv, err := srv.apProfile(c)
+	if err != nil {
+		return err
+	}
+	outboxURL := srv.siteURL(c) + "/bsky/ap/outbox"
+
+	if c.QueryParam("page") == "" {
+		var total int64
+		if pv.PostsCount != nil {
+			total = *pv.PostsCount
+		}
+		return srv.writeActivity(c, &apOutbox{
+			Context:    asContext,
+			ID:         outboxURL,
+			Type:       "OrderedCollection",
+			TotalItems: total,
+			First:      outboxURL + "?page=true",
+		})
+	}
+
+	cursor := c.QueryParam("cursor")
+	if len(cursor) > maxCursorLen {
+		return echo.NewHTTPError(400, "invalid 'cursor' parameter")
+	}
+	af, err := appbsky.FeedGetAuthorFeed(c.Request().Context(), srv.xrpcc, pv.Did, cursor, "posts_no_replies", apOutboxPageSize)
+	if err != nil {
+		slog.Warn("failed to fetch author feed", "did", pv.Did, "err", err)
+		return err
+	}
+
+	page := &apOutboxPage{
+		Context:      asContext,
+		ID:           outboxURL + "?page=true",
+		Type:         "OrderedCollectionPage",
+		PartOf:       outboxURL,
+		OrderedItems: []apCreate{},
+	}
+	if cursor != "" {
+		page.ID += "&cursor=" + url.QueryEscape(cursor)
+	}
+	for _, item := range af.Feed {
+		// reposts are the other account's posts
+		if item.Reason != nil || item.Post == nil || item.Post.Author == nil || item.Post.Author.Did != pv.Did {
+			continue
+		}
+		note, ok := srv.apNote(c, item.Post)
+		if !ok || note.InReplyTo != "" {
+			continue
+		}
+		page.OrderedItems = append(page.OrderedItems, apCreate{
+			ID:        note.ID + "#create",
+			Type:      "Create",
+			Actor:     note.AttributedTo,
+			Published: note.Published,
+			To:        note.To,
+			Object:    note,
+		})
+	}
+	if af.Cursor != nil && *af.Cursor != "" && len(af.Feed) > 0 {
+		page.Next = outboxURL + "?page=true&cursor=" + url.QueryEscape(*af.Cursor)
+	}
+	return srv.writeActivity(c, page)
+}
+
+// APNote is one of the account's posts, as a Note
+func (srv *Server) APNote(c echo.Context) error {
+	ctx := c.Request().Context()
+	pv, err := srv.apProfile(c)
+	if err != nil {
+		return err
+	}
+	rkey, err := syntax.ParseRecordKey(c.Param("rkey"))
+	if err != nil {
+		return echo.NewHTTPError(400, fmt.Sprintf("invalid record key: %s", c.Param("rkey")))
+	}
+
+	uri := fmt.Sprintf("at://%s/app.bsky.feed.post/%s", pv.Did, rkey)
+	out, err := appbsky.FeedGetPosts(ctx, srv.xrpcc, []string{uri})
+	if err != nil {
+		slog.Warn("failed to fetch post", "uri", uri, "err", err)
+		return err
+	}
+	if len(out.Posts) == 0 {
+		return echo.NewHTTPError(404, "post not found")
+	}
+	note, ok := srv.apNote(c, out.Posts[0])
+	if !ok {
+		return echo.NewHTTPError(404, "post not available")
+	}
+	note.Context = asContext
+	return srv.writeActivity(c, note)
+}
+
+// apNote converts one of the account's posts to a Note. Posts hidden by the
+// label policy aren't federated; those with a warning are marked sensitive,
+// which Mastodon shows behind the labels.
+func (srv *Server) apNote(c echo.Context, post *appbsky.FeedDefs_PostView) (*apNote, bool) {
+	rec, ok := post.Record.Val.(*appbsky.FeedPost)
+	if !ok {
+		return nil, false
+	}
+	aturi, err := syntax.ParseATURI(post.Uri)
+	if err != nil {
+		return nil, false
+	}
+	var mod labelDecision
+	if post.Author != nil {
+		mod = srv.labels.decide(post.Labels, post.Author.Labels)
+	} else {
+		mod = srv.labels.decide(post.Labels)
+	}
+	if mod.Hide {
+		return nil, false
+	}
+
+	published, err := syntax.ParseDatetimeTime(rec.CreatedAt)
+	if err != nil {
+		published, err = syntax.ParseDatetimeTime(post.IndexedAt)
+		if err != nil {
+			return nil, false
+		}
+	}
+
+	base := srv.siteURL(c)
+	note := &apNote{
+		ID:           base + "/bsky/ap/note/" + aturi.RecordKey().String(),
+		Type:         "Note",
+		AttributedTo: srv.apActorURL(c),
+		URL:          base + "/bsky/post/" + aturi.RecordKey().String(),
+		Published:    published.UTC().Format(time.RFC3339),
+		To:           []string{asPublic},
+		Sensitive:    mod.Warn,
+	}
+	if mod.Warn {
+		note.Summary = strings.Join(mod.Labels, ", ")
+	}
+	if rec.Reply != nil && rec.Reply.Parent != nil {
+		note.InReplyTo = srv.apObjectURL(c, rec.Reply.Parent.Uri, post.Author)
+	}
+
+	var content strings.Builder
+	content.WriteString("<p>" + renderRichText(rec.Text, rec.Facets, "") + "</p>")
+	if e := post.Embed; e != nil {
+		ev := normalizeEmbed(e.EmbedImages_View, e.EmbedExternal_View, e.EmbedRecord_View, e.EmbedRecordWithMedia_View, e.Unknown, post.Uri, "", maxQuoteDepth)
+		if ev != nil {
+			for _, img := range ev.Images {
+				note.Attachment = append(note.Attachment, apDocument{Type: "Document", MediaType: "image/jpeg", URL: img.Fullsize, Name: img.Alt})
+			}
+			// the rest can't be attached, so are linked to, as Mastodon does
+			if ev.External != nil {
+				fmt.Fprintf(&content, `<p><a href="%s">%s</a></p>`, html.EscapeString(ev.External.URL), html.EscapeString(ev.External.Title))
+			}
+			if ev.Video != nil {
+				fmt.Fprintf(&content, `<p><a href="%s">%s</a></p>`, html.EscapeString(ev.Video.PostURL), "Video on Bluesky")
+			}
+			if ev.Quote != nil && ev.Quote.URL != "" {
+				fmt.Fprintf(&content, `<p>RE: <a href="%s">%s</a></p>`, html.EscapeString(ev.Quote.URL), html.EscapeString(ev.Quote.URL))
+			}
+		}
+	}
+	note.Content = content.String()
+	return note, true
+}
+
+// apObjectURL is the ActivityPub id for a post: this actor's Note for the
+// account's own posts, otherwise the bsky.app page
+func (srv *Server) apObjectURL(c echo.Context, uri string, self *appbsky.ActorDefs_ProfileViewBasic) string {
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return ""
+	}
+	if self != nil && aturi.Authority().String() == self.Did {
+		return srv.siteURL(c) + "/bsky/ap/note/" + aturi.RecordKey().String()
+	}
+	return bskyAppLink(uri, "post")
+}
+
+// apActivity is the parts of an incoming activity the inbox looks at
+type apActivity struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  json.RawMessage `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// apRemoteActor is the parts of another server's actor athome uses
+type apRemoteActor struct {
+	ID        string `json:"id"`
+	Inbox     string `json:"inbox"`
+	PublicKey struct {
+		ID           string `json:"id"`
+		Owner        string `json:"owner"`
+		PublicKeyPem string `json:"publicKeyPem"`
+	} `json:"publicKey"`
+}
+
+// apID is the id of an object, which may be given as just its id, or in full
+func apID(raw json.RawMessage) string {
+	var id string
+	if err := json.Unmarshal(raw, &id); err == nil {
+		return id
+	}
+	var obj struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		return obj.ID
+	}
+	return ""
+}
+
+// APInbox accepts follows, once their signature checks out. Everything else
+// sent to the inbox is ignored, as athome is read-only.
+func (srv *Server) APInbox(c echo.Context) error {
+	req := c.Request()
+	body, err := io.ReadAll(io.LimitReader(req.Body, apMaxBodySize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > apMaxBodySize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "activity too large")
+	}
+	var act apActivity
+	if err := json.Unmarshal(body, &act); err != nil {
+		return echo.NewHTTPError(400, "invalid activity")
+	}
+	actorURL := srv.apActorURL(c)
+	if act.Type != "Follow" || apID(act.Object) != actorURL {
+		return c.NoContent(http.StatusAccepted)
+	}
+	if _, err := srv.apProfile(c); err != nil {
+		return err
+	}
+
+	follower := apID(act.Actor)
+	sig, err := parseSignatureHeader(req.Header.Get("Signature"))
+	if err != nil {
+		return echo.NewHTTPError(401, err.Error())
+	}
+	ctx := req.Context()
+	keyDoc, err := srv.ap.fetchActor(ctx, sig.KeyID, srv.apKeyID(c))
+	if err != nil {
+		slog.Warn("failed to fetch ActivityPub key", "keyId", sig.KeyID, "err", err)
+		return echo.NewHTTPError(401, "could not fetch signing key")
+	}
+	if keyDoc.PublicKey.ID != sig.KeyID || keyDoc.PublicKey.Owner != follower {
+		return echo.NewHTTPError(401, "signing key doesn't belong to the follower")
+	}
+	pub, err := parsePublicKeyPEM(keyDoc.PublicKey.PublicKeyPem)
+	if err != nil {
+		return echo.NewHTTPError(401, err.Error())
+	}
+	if err := verifyRequest(req, body, sig, pub); err != nil {
+		return echo.NewHTTPError(401, fmt.Sprintf("invalid signature: %s", err))
+	}
+
+	// the key is usually part of the actor, but needn't be
+	remote := keyDoc
+	if keyDoc.ID != follower {
+		remote, err = srv.ap.fetchActor(ctx, follower, srv.apKeyID(c))
+		if err != nil {
+			slog.Warn("failed to fetch ActivityPub actor", "actor", follower, "err", err)
+			return echo.NewHTTPError(400, "could not fetch actor")
+		}
+	}
+
+	sum := sha256.Sum256([]byte(act.ID))
+	accept, err := json.Marshal(map[string]any{
+		"@context": asContext,
+		"id":       actorURL + "#accepts/" + hex.EncodeToString(sum[:16]),
+		"type":     "Accept",
+		"actor":    actorURL,
+		"object":   json.RawMessage(body),
+	})
+	if err != nil {
+		return err
+	}
+	keyID := srv.apKeyID(c)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), apDeliveryTimeout)
+		defer cancel()
+		if err := srv.ap.deliver(ctx, remote.Inbox, accept, keyID); err != nil {
+			slog.Warn("failed to deliver ActivityPub Accept", "inbox", remote.Inbox, "err", err)
+			return
+		}
+		slog.Info("accepted ActivityPub follow", "actor", actorURL, "follower", follower)
+	}()
+	return c.NoContent(http.StatusAccepted)
+}
+
+// fetchActor fetches a remote actor (or key) document, with a signed request
+// for servers which require them
+func (ap *activityPub) fetchActor(ctx context.Context, id, keyID string) (*apRemoteActor, error) {
+	u, err := url.Parse(id)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid actor URL %q", id)
+	}
+	u.Fragment = ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", activityJSONType)
+	if err := signRequest(req, nil, keyID, ap.key); err != nil {
+		return nil, err
+	}
+	resp, err := ap.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: status %d", u, resp.StatusCode)
+	}
+	var actor apRemoteActor
+	if err := json.NewDecoder(io.LimitReader(resp.Body, apMaxBodySize)).Decode(&actor); err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", u, err)
+	}
+	return &actor, nil
+}
+
+// deliver POSTs an activity to an inbox
+func (ap *activityPub) deliver(ctx context.Context, inbox string, activity []byte, keyID string) error {
+	u, err := url.Parse(inbox)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid inbox URL %q", inbox)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(activity))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", activityJSONType)
+	if err := signRequest(req, activity, keyID, ap.key); err != nil {
+		return err
+	}
+	resp, err := ap.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, apMaxBodySize))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Atom 1.0 (RFC 4287)
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   atomPerson  `xml:"author"`
+	Icon     string      `xml:"icon,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Updated   string     `xml:"updated"`
+	Published string     `xml:"published,omitempty"`
+	Links     []atomLink `xml:"link"`
+	Author    atomPerson `xml:"author"`
+	Content   atomText   `xml:"content"`
+}
+
+// WebRepoAtom is the Atom version of WebRepoRSS, with the same posts. Entries
+// are identified by their AT-URI, which stays the same whichever host the
+// feed is read from.
+func (srv *Server) WebRepoAtom(c echo.Context) error {
+	ctx := c.Request().Context()
+	handle := srv.reqHandle(c)
+
+	pv, err := appbsky.ActorGetProfile(ctx, srv.xrpcc, handle.String())
+	if err != nil {
+		slog.Warn("failed to fetch handle", "handle", handle, "err", err)
+		// TODO: only if "not found"
+		return echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
+	}
+
+	af, err := appbsky.FeedGetAuthorFeed(ctx, srv.xrpcc, handle.String(), "", "", 30)
+	if err != nil {
+		slog.Warn("failed to fetch author feed", "handle", handle, "err", err)
+		return err
+	}
+
+	homeURL := srv.siteURL(c) + "/bsky"
+	author := atomPerson{
+		Name: "@" + handle.String(),
+		URI:  homeURL,
+	}
+	if pv.DisplayName != nil && *pv.DisplayName != "" {
+		author.Name = *pv.DisplayName + " (@" + handle.String() + ")"
+	}
+
+	var updated time.Time
+	entries := []atomEntry{}
+	for _, p := range af.Feed {
+		// same selection as the RSS feed: own, top-level posts
+		if p.Post.Author.Did != pv.Did {
+			continue
+		}
+		aturi, err := syntax.ParseATURI(p.Post.Uri)
+		if err != nil {
+			return err
+		}
+		rec, ok := p.Post.Record.Val.(*appbsky.FeedPost)
+		if !ok || rec.Reply != nil {
+			continue
+		}
+
+		// entries must have an updated time, so fall back to when the post
+		// was indexed if the record's own timestamp is unusable
+		created, err := syntax.ParseDatetimeTime(rec.CreatedAt)
+		if err != nil {
+			created, err = syntax.ParseDatetimeTime(p.Post.IndexedAt)
+			if err != nil {
+				slog.Warn("skipping post without a valid timestamp", "uri", p.Post.Uri)
+				continue
+			}
+		}
+		created = created.UTC()
+		if created.After(updated) {
+			updated = created
+		}
+
+		entries = append(entries, atomEntry{
+			ID:        p.Post.Uri,
+			Title:     atomEntryTitle(rec.Text),
+			Updated:   created.Format(time.RFC3339),
+			Published: created.Format(time.RFC3339),
+			Links: []atomLink{{
+				Href: srv.siteURL(c) + "/bsky/post/" + aturi.RecordKey().String(),
+				Rel:  "alternate",
+				Type: "text/html",
+			}},
+			Author:  author,
+			Content: atomText{Type: "text", Body: rec.Text},
+		})
+	}
+	if updated.IsZero() {
+		updated = time.Now().UTC()
+	}
+
+	title := "@" + handle.String()
+	if pv.DisplayName != nil {
+		title = title + " - " + *pv.DisplayName
+	}
+	feed := &atomFeed{
+		ID:      "at://" + pv.Did,
+		Title:   title,
+		Updated: updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: homeURL, Rel: "alternate", Type: "text/html"},
+			{Href: homeURL + "/atom.xml", Rel: "self", Type: "application/atom+xml"},
+		},
+		Author:  author,
+		Entries: entries,
+	}
+	if pv.Description != nil {
+		feed.Subtitle = *pv.Description
+	}
+	if pv.Avatar != nil {
+		feed.Icon = *pv.Avatar
+	}
+
+	b, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return srv.writeCached(c, "application/atom+xml; charset=utf-8", append([]byte(xml.Header), b...))
+}
+
+// atomEntryTitle is the start of the post text, since posts have no title
+func atomEntryTitle(text string) string {
+	const maxLen = 80
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) == 0 {
+		return "(post)"
+	}
+	for i, r := range runes {
+		if r == '\n' {
+			runes = runes[:i]
+			break
+		}
+	}
+	if len(runes) > maxLen {
+		return string(runes[:maxLen-1]) + "…"
+	}
+	return string(runes)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+// renderCachedPage is renderPage for pages CDNs and browsers may cache, with
+// an ETag so unchanged pages can be revalidated
+func (srv *Server) renderCachedPage(c echo.Context, name string, data pongo2.Context) error {
+	c.Response().Header().Add("Vary", "Accept")
+	if wantsJSON(c) {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		return srv.writeCached(c, echo.MIMEApplicationJSONCharsetUTF8, append(b, '\n'))
+	}
+	var buf bytes.Buffer
+	if err := c.Echo().Renderer.Render(&buf, name, data, c); err != nil {
+		return err
+	}
+	return srv.writeCached(c, echo.MIMETextHTMLCharsetUTF8, buf.Bytes())
+}
+
+// writeCached writes a 200 response with Cache-Control and an ETag of the
+// body, or 304 Not Modified if the request's If-None-Match has that ETag.
+// Pages are hydrated from the AppView on every request (which is cached
+// anyway), so hashing the output is cheaper than tracking what went into it,
+// and also covers the language and templates it was rendered with.
+func (srv *Server) writeCached(c echo.Context, contentType string, body []byte) error {
+	sum := sha256.Sum256(body)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+
+	h := c.Response().Header()
+	h.Set("ETag", etag)
+	if srv.pageMaxAge > 0 {
+		h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(srv.pageMaxAge.Seconds())))
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	if etagMatch(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.Blob(http.StatusOK, contentType, body)
+}
+
+// etagMatch is the weak comparison of RFC 9110 section 13.1.2, which
+// If-None-Match uses: CDNs mark ETags weak when they compress responses
+func etagMatch(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
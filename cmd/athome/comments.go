@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+// parsePostURL accepts a post AT-URI, a bsky.app post link, or an athome post
+// link (https://<handle>/bsky/post/<rkey>), and returns the repo and record key.
+func parsePostURL(raw string) (syntax.AtIdentifier, syntax.RecordKey, error) {
+	if strings.HasPrefix(raw, "at://") {
+		aturi, err := syntax.ParseATURI(raw)
+		if err != nil {
+			return syntax.AtIdentifier{}, "", err
+		}
+		if aturi.Collection() != "app.bsky.feed.post" || aturi.RecordKey() == "" {
+			return syntax.AtIdentifier{}, "", fmt.Errorf("not a post AT-URI: %s", raw)
+		}
+		return aturi.Authority(), aturi.RecordKey(), nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return syntax.AtIdentifier{}, "", err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	var ident, rkey string
+	switch {
+	case len(parts) == 4 && parts[0] == "profile" && parts[2] == "post":
+		ident, rkey = parts[1], parts[3]
+	case len(parts) == 3 && parts[0] == "bsky" && parts[1] == "post":
+		ident, rkey = u.Hostname(), parts[2]
+	default:
+		return syntax.AtIdentifier{}, "", fmt.Errorf("not a post URL: %s", raw)
+	}
+	atid, err := syntax.ParseAtIdentifier(ident)
+	if err != nil {
+		return syntax.AtIdentifier{}, "", err
+	}
+	rk, err := syntax.ParseRecordKey(rkey)
+	if err != nil {
+		return syntax.AtIdentifier{}, "", err
+	}
+	return *atid, rk, nil
+}
+
+// WebComments renders the replies to one of the account's posts as a compact
+// HTML fragment, for embedding as a comments section on another site (see
+// static/comments.js). Only posts by the account this host serves are
+// rendered, so an athome instance can't be used to embed arbitrary threads.
+func (srv *Server) WebComments(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := c.Request()
+	handle := srv.reqHandle(c)
+
+	raw := c.QueryParam("url")
+	if raw == "" {
+		return echo.NewHTTPError(400, "missing 'url' parameter")
+	}
+	atid, rkey, err := parsePostURL(raw)
+	if err != nil {
+		return echo.NewHTTPError(400, fmt.Sprintf("invalid post URL: %s", err))
+	}
+
+	self, err := srv.dir.LookupHandle(ctx, handle)
+	if err != nil {
+		slog.Warn("failed to resolve handle", "handle", handle, "err", err)
+		return echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
+	}
+	author, err := srv.dir.Lookup(ctx, atid)
+	if err != nil {
+		slog.Warn("failed to resolve post author", "ident", atid, "err", err)
+		return echo.NewHTTPError(404, "post not found")
+	}
+	if author.DID != self.DID {
+		return echo.NewHTTPError(404, "post not found")
+	}
+
+	aturi := fmt.Sprintf("at://%s/app.bsky.feed.post/%s", self.DID, rkey)
+	tpv, err := appbsky.FeedGetPostThread(ctx, srv.xrpcc, 6, 0, aturi)
+	if err != nil {
+		slog.Warn("failed to fetch post", "aturi", aturi, "err", err)
+		// TODO: only if "not found"
+		return echo.NewHTTPError(404, "post not found")
+	}
+	if tpv.Thread == nil || tpv.Thread.FeedDefs_ThreadViewPost == nil {
+		return echo.NewHTTPError(404, "post not found")
+	}
+
+	data := pongo2.Context{
+		"did":      self.DID.String(),
+		"handle":   handle.String(),
+		"rkey":     rkey.String(),
+		"postView": tpv.Thread.FeedDefs_ThreadViewPost,
+		// the fragment is shown on other sites, so links back here must be absolute
+		"baseURL": fmt.Sprintf("%s://%s", c.Scheme(), req.Host),
+	}
+	// replies change, but not so fast that every page view of a popular blog
+	// post needs to reach the AppView
+	c.Response().Header().Set("Cache-Control", "public, max-age=60")
+	return c.Render(http.StatusOK, "comments.html", data)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/labstack/echo/v4"
+)
+
+// siteConfig is one custom domain in the domains file, which is a JSON object
+// keyed by domain:
+//
+//	{
+//	  "blog.example.com": {"handle": "alice.example.com", "title": "Alice's Blog"},
+//	  "bob.example.net": {"handle": "bob.bsky.social"}
+//	}
+type siteConfig struct {
+	Handle string `json:"handle"`
+	// optional, shown in the sidebar instead of the handle
+	Title string `json:"title,omitempty"`
+}
+
+// site is the account a request is for, available to templates as "site"
+type site struct {
+	Domain string
+	Handle syntax.Handle
+	Title  string
+	// the domain is in the domains file (rather than being the handle itself)
+	Custom bool
+}
+
+// domainMap maps custom domains to the accounts they serve, so one instance
+// can host accounts whose handle isn't the domain they are served on
+type domainMap struct {
+	path string
+
+	lk    sync.RWMutex
+	sites map[string]site
+}
+
+func loadDomainMap(path string) (*domainMap, error) {
+	dm := &domainMap{path: path}
+	if err := dm.reload(); err != nil {
+		return nil, err
+	}
+	return dm, nil
+}
+
+// reload re-reads the domains file. If it is invalid, the current mapping is
+// kept.
+func (dm *domainMap) reload() error {
+	b, err := os.ReadFile(dm.path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]siteConfig
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("parsing domains file %s: %w", dm.path, err)
+	}
+
+	sites := make(map[string]site, len(raw))
+	for domain, sc := range raw {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		h, err := syntax.ParseHandle(sc.Handle)
+		if err != nil {
+			return fmt.Errorf("domain %s: %w", domain, err)
+		}
+		sites[domain] = site{
+			Domain: domain,
+			Handle: h.Normalize(),
+			Title:  sc.Title,
+			Custom: true,
+		}
+	}
+
+	dm.lk.Lock()
+	dm.sites = sites
+	dm.lk.Unlock()
+	slog.Info("loaded domains file", "path", dm.path, "domains", len(sites))
+	return nil
+}
+
+func (dm *domainMap) lookup(domain string) (site, bool) {
+	dm.lk.RLock()
+	defer dm.lk.RUnlock()
+	s, ok := dm.sites[domain]
+	return s, ok
+}
+
+// resolveSite works out which account a request is for: a custom domain from
+// the domains file, or else a host which is itself a handle, or else the
+// default handle
+func (srv *Server) resolveSite(c echo.Context) site {
+	host := strings.ToLower(strings.SplitN(c.Request().Host, ":", 2)[0])
+	if srv.domains != nil {
+		if s, ok := srv.domains.lookup(host); ok {
+			return s
+		}
+	}
+	handle, err := syntax.ParseHandle(host)
+	if err != nil {
+		slog.Warn("host is not a valid handle, fallback to default", "host", host)
+		handle = srv.defaultHandle
+	}
+	return site{Domain: host, Handle: handle}
+}
+
+// siteURL is the base URL of the site a request is for, for absolute links:
+// the custom domain if there is one, otherwise the handle
+func (srv *Server) siteURL(c echo.Context) string {
+	s, ok := c.Get("site").(site)
+	if !ok {
+		s = srv.resolveSite(c)
+	}
+	if s.Custom {
+		return "https://" + s.Domain
+	}
+	return "https://" + s.Handle.String()
+}
+
+// siteMiddleware resolves the site once per request, for handlers (through
+// reqHandle) and templates
+func (srv *Server) siteMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Set("site", srv.resolveSite(c))
+		return next(c)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// quotes nested deeper than this are only linked to, not rendered
+const maxQuoteDepth = 1
+
+func init() {
+	if err := pongo2.RegisterFilter("embedview", filterEmbedView); err != nil {
+		panic(err)
+	}
+}
+
+// embedView is a post embed, flattened for templates: the AppView returns each
+// kind of embed (and recordWithMedia, which combines a quote with media) as a
+// different union variant, at every level of quoting.
+type embedView struct {
+	Images   []embedViewImage
+	External *embedExternal
+	Video    *embedVideo
+	Quote    *embedQuote
+	// a feed generator or list, rather than a post
+	Record *embedRecord
+}
+
+type embedViewImage struct {
+	Thumb    string
+	Fullsize string
+	Alt      string
+}
+
+type embedExternal struct {
+	URL         string
+	Domain      string
+	Title       string
+	Description string
+	Thumb       string
+}
+
+// app.bsky.embed.video isn't in this tree's lexicons, so arrives as an unknown
+// union variant and is decoded from its JSON
+type embedVideo struct {
+	Playlist    string `json:"playlist"`
+	Thumbnail   string `json:"thumbnail"`
+	Alt         string `json:"alt"`
+	AspectRatio *struct {
+		Width  int64 `json:"width"`
+		Height int64 `json:"height"`
+	} `json:"aspectRatio"`
+	// CSS aspect-ratio value, from AspectRatio (or 16:9)
+	CSSAspectRatio string `json:"-"`
+	// where to watch it (on bsky.app) if the browser can't play the playlist
+	PostURL string `json:"-"`
+}
+
+type embedQuote struct {
+	URL       string
+	Author    *appbsky.ActorDefs_ProfileViewBasic
+	Post      *appbsky.FeedPost
+	Labels    []*comatproto.LabelDefs_Label
+	CreatedAt string
+	// the quoted post's own embed; nil past maxQuoteDepth
+	Embed *embedView
+	// it has an embed which wasn't rendered, because of the depth limit
+	Truncated bool
+	NotFound  bool
+	Blocked   bool
+}
+
+type embedRecord struct {
+	URL         string
+	Kind        string
+	Title       string
+	Description string
+	Avatar      string
+}
+
+// filterEmbedView normalizes a post view's embed:
+// {% with embed=post|embedview:selfDID %}. Links to selfDID's own posts go to
+// athome's post pages.
+func filterEmbedView(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	post, ok := in.Interface().(*appbsky.FeedDefs_PostView)
+	if !ok || post == nil || post.Embed == nil {
+		return pongo2.AsValue(nil), nil
+	}
+	selfDID := param.String()
+	embed := post.Embed
+	ev := normalizeEmbed(embed.EmbedImages_View, embed.EmbedExternal_View, embed.EmbedRecord_View, embed.EmbedRecordWithMedia_View, embed.Unknown, post.Uri, selfDID, 0)
+	if ev == nil {
+		return pongo2.AsValue(nil), nil
+	}
+	return pongo2.AsValue(ev), nil
+}
+
+// normalizeEmbed takes the variants of an embed union; the top-level
+// (FeedDefs_PostView_Embed) and quoted (EmbedRecord_ViewRecord_Embeds_Elem)
+// unions have the same ones. postURI is the post the embed is in.
+func normalizeEmbed(images *appbsky.EmbedImages_View, external *appbsky.EmbedExternal_View, record *appbsky.EmbedRecord_View, rwm *appbsky.EmbedRecordWithMedia_View, unknown *lexutil.UnknownUnionVariant, postURI, selfDID string, depth int) *embedView {
+	ev := &embedView{}
+	if rwm != nil {
+		record = rwm.Record
+		if rwm.Media != nil {
+			images, external, unknown = rwm.Media.EmbedImages_View, rwm.Media.EmbedExternal_View, rwm.Media.Unknown
+		}
+	}
+
+	if images != nil {
+		for _, img := range images.Images {
+			if img == nil {
+				continue
+			}
+			ev.Images = append(ev.Images, embedViewImage{Thumb: img.Thumb, Fullsize: img.Fullsize, Alt: img.Alt})
+		}
+	}
+	if external != nil && external.External != nil {
+		ev.External = normalizeExternal(external.External)
+	}
+	if unknown != nil && unknown.Type == "app.bsky.embed.video#view" {
+		ev.Video = normalizeVideo(unknown)
+		if ev.Video != nil {
+			ev.Video.PostURL = bskyAppLink(postURI, "post")
+		}
+	}
+	if record != nil && record.Record != nil {
+		normalizeRecord(ev, record.Record, selfDID, depth)
+	}
+
+	if len(ev.Images) == 0 && ev.External == nil && ev.Video == nil && ev.Quote == nil && ev.Record == nil {
+		return nil
+	}
+	return ev
+}
+
+func normalizeExternal(ext *appbsky.EmbedExternal_ViewExternal) *embedExternal {
+	// only web links
+	u, err := url.Parse(ext.Uri)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil
+	}
+	ee := &embedExternal{
+		URL:         u.String(),
+		Domain:      strings.TrimPrefix(u.Hostname(), "www."),
+		Title:       ext.Title,
+		Description: summarizeText(ext.Description, metaDescriptionLen),
+	}
+	if ext.Thumb != nil {
+		ee.Thumb = *ext.Thumb
+	}
+	return ee
+}
+
+func normalizeVideo(uv *lexutil.UnknownUnionVariant) *embedVideo {
+	var v embedVideo
+	if err := json.Unmarshal(uv.JSON, &v); err != nil || v.Playlist == "" {
+		return nil
+	}
+	v.CSSAspectRatio = "16 / 9"
+	if ar := v.AspectRatio; ar != nil && ar.Width > 0 && ar.Height > 0 {
+		v.CSSAspectRatio = fmt.Sprintf("%d / %d", ar.Width, ar.Height)
+	}
+	return &v
+}
+
+func normalizeRecord(ev *embedView, rec *appbsky.EmbedRecord_View_Record, selfDID string, depth int) {
+	switch {
+	case rec.EmbedRecord_ViewRecord != nil:
+		vr := rec.EmbedRecord_ViewRecord
+		q := &embedQuote{
+			URL:       postLink(vr.Uri, vr.Author, selfDID),
+			Author:    vr.Author,
+			Labels:    vr.Labels,
+			CreatedAt: vr.IndexedAt,
+		}
+		if vr.Value != nil {
+			if fp, ok := vr.Value.Val.(*appbsky.FeedPost); ok {
+				q.Post = fp
+				q.CreatedAt = fp.CreatedAt
+			}
+		}
+		for _, e := range vr.Embeds {
+			if e == nil {
+				continue
+			}
+			if depth >= maxQuoteDepth {
+				q.Truncated = true
+				break
+			}
+			q.Embed = normalizeEmbed(e.EmbedImages_View, e.EmbedExternal_View, e.EmbedRecord_View, e.EmbedRecordWithMedia_View, e.Unknown, vr.Uri, selfDID, depth+1)
+			break
+		}
+		ev.Quote = q
+	case rec.EmbedRecord_ViewNotFound != nil:
+		ev.Quote = &embedQuote{NotFound: true}
+	case rec.EmbedRecord_ViewBlocked != nil:
+		ev.Quote = &embedQuote{Blocked: true}
+	case rec.FeedDefs_GeneratorView != nil:
+		gv := rec.FeedDefs_GeneratorView
+		r := &embedRecord{Kind: "Feed", Title: gv.DisplayName, URL: bskyAppLink(gv.Uri, "feed")}
+		if gv.Creator != nil {
+			r.Kind = "Feed by @" + gv.Creator.Handle
+		}
+		if gv.Description != nil {
+			r.Description = summarizeText(*gv.Description, metaDescriptionLen)
+		}
+		if gv.Avatar != nil {
+			r.Avatar = *gv.Avatar
+		}
+		ev.Record = r
+	case rec.GraphDefs_ListView != nil:
+		lv := rec.GraphDefs_ListView
+		r := &embedRecord{Kind: "List", Title: lv.Name, URL: bskyAppLink(lv.Uri, "lists")}
+		if lv.Creator != nil {
+			r.Kind = "List by @" + lv.Creator.Handle
+		}
+		if lv.Description != nil {
+			r.Description = summarizeText(*lv.Description, metaDescriptionLen)
+		}
+		if lv.Avatar != nil {
+			r.Avatar = *lv.Avatar
+		}
+		ev.Record = r
+	}
+}
+
+// postLink is the athome page for the account's own posts, and the bsky.app
+// page for anybody else's
+func postLink(uri string, author *appbsky.ActorDefs_ProfileViewBasic, selfDID string) string {
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return ""
+	}
+	if author != nil && selfDID != "" && author.Did == selfDID {
+		return "/bsky/post/" + aturi.RecordKey().String()
+	}
+	return bskyAppLink(uri, "post")
+}
+
+// bskyAppLink is the bsky.app page for a record, where segment is the path
+// segment for its collection (eg "post", "feed", "lists")
+func bskyAppLink(uri, segment string) string {
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("https://bsky.app/profile/%s/%s/%s", aturi.Authority(), segment, aturi.RecordKey())
+}
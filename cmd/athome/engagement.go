@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+// app.bsky.feed.getQuotes isn't in this tree's lexicons, so the output is
+// declared here
+type feedGetQuotesOutput struct {
+	Uri    string                       `json:"uri"`
+	Cid    *string                      `json:"cid,omitempty"`
+	Cursor *string                      `json:"cursor,omitempty"`
+	Posts  []*appbsky.FeedDefs_PostView `json:"posts"`
+}
+
+func feedGetQuotes(ctx context.Context, c *xrpc.Client, uri string, cursor string, limit int64) (*feedGetQuotesOutput, error) {
+	var out feedGetQuotesOutput
+
+	params := map[string]interface{}{
+		"uri":   uri,
+		"limit": limit,
+	}
+	if cursor != "" {
+		params["cursor"] = cursor
+	}
+	if err := c.Do(ctx, xrpc.Query, "", "app.bsky.feed.getQuotes", params, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// WebPostEngagement is who liked or reposted one of the account's posts, or
+// the posts quoting it, depending on the last path segment. Pages are
+// paginated like the profile.
+func (srv *Server) WebPostEngagement(c echo.Context) error {
+	ctx := c.Request().Context()
+	data := pongo2.Context{}
+	handle := srv.reqHandle(c)
+
+	rkey, err := syntax.ParseRecordKey(c.Param("rkey"))
+	if err != nil {
+		return echo.NewHTTPError(400, fmt.Sprintf("invalid post record key: %s", err))
+	}
+	tab := path.Base(c.Path())
+	pg, err := parseFeedPage(c)
+	if err != nil {
+		return err
+	}
+
+	pv, err := appbsky.ActorGetProfile(ctx, srv.xrpcc, handle.String())
+	if err != nil {
+		slog.Warn("failed to fetch handle", "handle", handle, "err", err)
+		// TODO: only if "not found"
+		return echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
+	}
+	did := pv.Did
+	data["did"] = did
+
+	aturi := fmt.Sprintf("at://%s/app.bsky.feed.post/%s", did, rkey)
+	posts, err := appbsky.FeedGetPosts(ctx, srv.xrpcc, []string{aturi})
+	if err != nil {
+		slog.Warn("failed to fetch post", "aturi", aturi, "err", err)
+		return err
+	}
+	if len(posts.Posts) == 0 || posts.Posts[0] == nil {
+		return echo.NewHTTPError(404, fmt.Sprintf("post not found: %s", rkey))
+	}
+	post := posts.Posts[0]
+	// feed_post renders feed items
+	data["postItem"] = &appbsky.FeedDefs_FeedViewPost{Post: post}
+	data["rkey"] = rkey.String()
+	data["tab"] = tab
+
+	pageURL := srv.siteURL(c) + "/bsky/post/" + rkey.String() + "/" + tab
+	data["meta"] = postMeta(post, pageURL)
+	if srv.labels.decide(post.Labels, post.Author.Labels).Hide {
+		data["meta"] = pageMeta{Title: "@" + post.Author.Handle, URL: pageURL}
+	}
+
+	var next *string
+	switch tab {
+	case "likes":
+		out, err := appbsky.FeedGetLikes(ctx, srv.xrpcc, "", pg.cursor, pg.limit, aturi)
+		if err != nil {
+			slog.Warn("failed to fetch likes", "aturi", aturi, "err", err)
+			break
+		}
+		actors := make([]*appbsky.ActorDefs_ProfileView, 0, len(out.Likes))
+		for _, l := range out.Likes {
+			if l != nil && l.Actor != nil {
+				actors = append(actors, l.Actor)
+			}
+		}
+		data["actors"] = actors
+		if len(out.Likes) > 0 {
+			next = out.Cursor
+		}
+	case "reposts":
+		out, err := appbsky.FeedGetRepostedBy(ctx, srv.xrpcc, "", pg.cursor, pg.limit, aturi)
+		if err != nil {
+			slog.Warn("failed to fetch reposts", "aturi", aturi, "err", err)
+			break
+		}
+		data["actors"] = out.RepostedBy
+		if len(out.RepostedBy) > 0 {
+			next = out.Cursor
+		}
+	case "quotes":
+		out, err := feedGetQuotes(ctx, srv.xrpcc, aturi, pg.cursor, pg.limit)
+		if err != nil {
+			slog.Warn("failed to fetch quotes", "aturi", aturi, "err", err)
+			break
+		}
+		quotes := make([]*appbsky.FeedDefs_FeedViewPost, 0, len(out.Posts))
+		for _, p := range out.Posts {
+			if p != nil {
+				quotes = append(quotes, &appbsky.FeedDefs_FeedViewPost{Post: p})
+			}
+		}
+		data["quotes"] = quotes
+		if len(out.Posts) > 0 {
+			next = out.Cursor
+		}
+	default:
+		return echo.NewHTTPError(404, "not found")
+	}
+	if next != nil && *next != "" {
+		data["nextURL"] = pg.nextURL(*next)
+	}
+	if pg.cursor != "" {
+		data["prevURL"] = pg.prevURL()
+		data["firstURL"] = pg.firstURL()
+	}
+	return srv.renderPage(c, http.StatusOK, "engagement.html", data)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+// most feed generators shown on the lists page; accounts rarely have more
+const maxActorFeeds = 100
+
+func init() {
+	if err := pongo2.RegisterFilter("rkey", filterRecordKey); err != nil {
+		panic(err)
+	}
+}
+
+// filterRecordKey is the record key of an AT-URI, for links to athome's pages
+// for records: {{ list.Uri|rkey }}
+func filterRecordKey(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	aturi, err := syntax.ParseATURI(in.String())
+	if err != nil {
+		return pongo2.AsValue(""), nil
+	}
+	return pongo2.AsValue(aturi.RecordKey().String()), nil
+}
+
+// listSummary is a list on the lists page
+type listSummary struct {
+	*appbsky.GraphDefs_ListView
+	IsCuration bool `json:"isCuration"`
+}
+
+func isCurationList(lv *appbsky.GraphDefs_ListView) bool {
+	return lv.Purpose != nil && *lv.Purpose == "app.bsky.graph.defs#curatelist"
+}
+
+// WebLists is the account's lists and feed generators. Lists are paginated;
+// feeds are all on the first page.
+func (srv *Server) WebLists(c echo.Context) error {
+	ctx := c.Request().Context()
+	data := pongo2.Context{}
+	handle := srv.reqHandle(c)
+
+	pv, err := appbsky.ActorGetProfile(ctx, srv.xrpcc, handle.String())
+	if err != nil {
+		slog.Warn("failed to fetch handle", "handle", handle, "err", err)
+		// TODO: only if "not found"
+		return echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
+	}
+	data["did"] = pv.Did
+	data["profileView"] = pv
+	data["meta"] = pageMeta{Title: "Lists and feeds by @" + pv.Handle, URL: srv.siteURL(c) + "/bsky/lists"}
+
+	pg, err := parseFeedPage(c)
+	if err != nil {
+		return err
+	}
+	gl, err := appbsky.GraphGetLists(ctx, srv.xrpcc, pv.Did, pg.cursor, pg.limit)
+	if err != nil {
+		slog.Warn("failed to fetch lists", "did", pv.Did, "err", err)
+		// TODO: show some error?
+	} else {
+		lists := make([]listSummary, 0, len(gl.Lists))
+		for _, lv := range gl.Lists {
+			if lv != nil {
+				lists = append(lists, listSummary{GraphDefs_ListView: lv, IsCuration: isCurationList(lv)})
+			}
+		}
+		data["lists"] = lists
+		if gl.Cursor != nil && len(gl.Lists) > 0 {
+			data["nextURL"] = pg.nextURL(*gl.Cursor)
+		}
+	}
+	if pg.cursor != "" {
+		data["prevURL"] = pg.prevURL()
+		data["firstURL"] = pg.firstURL()
+	} else {
+		af, err := appbsky.FeedGetActorFeeds(ctx, srv.xrpcc, pv.Did, "", maxActorFeeds)
+		if err != nil {
+			slog.Warn("failed to fetch feed generators", "did", pv.Did, "err", err)
+		} else {
+			data["feeds"] = af.Feeds
+		}
+	}
+	return srv.renderPage(c, http.StatusOK, "lists.html", data)
+}
+
+// WebFeed is one of the account's feed generators: its description, and a
+// page of the feed, paginated like the profile
+func (srv *Server) WebFeed(c echo.Context) error {
+	ctx := c.Request().Context()
+	data := pongo2.Context{}
+	handle := srv.reqHandle(c)
+
+	rkey, err := syntax.ParseRecordKey(c.Param("rkey"))
+	if err != nil {
+		return echo.NewHTTPError(400, fmt.Sprintf("invalid feed record key: %s", err))
+	}
+	pg, err := parseFeedPage(c)
+	if err != nil {
+		return err
+	}
+
+	pv, err := appbsky.ActorGetProfile(ctx, srv.xrpcc, handle.String())
+	if err != nil {
+		slog.Warn("failed to fetch handle", "handle", handle, "err", err)
+		// TODO: only if "not found"
+		return echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
+	}
+	data["did"] = pv.Did
+	data["profileView"] = pv
+
+	aturi := fmt.Sprintf("at://%s/app.bsky.feed.generator/%s", pv.Did, rkey)
+	gen, err := appbsky.FeedGetFeedGenerator(ctx, srv.xrpcc, aturi)
+	if err != nil {
+		slog.Warn("failed to fetch feed generator", "aturi", aturi, "err", err)
+		// TODO: only if "not found"
+		return echo.NewHTTPError(404, fmt.Sprintf("feed not found: %s", rkey))
+	}
+	data["feedView"] = gen.View
+	data["rkey"] = rkey.String()
+	data["meta"] = feedMeta(gen.View, srv.siteURL(c)+"/bsky/feed/"+rkey.String())
+
+	// the feed is served by its generator, which might be down
+	feedOK := false
+	if gen.IsOnline && gen.IsValid {
+		ff, err := appbsky.FeedGetFeed(ctx, srv.xrpcc, pg.cursor, aturi, pg.limit)
+		if err != nil {
+			slog.Warn("failed to fetch feed", "aturi", aturi, "err", err)
+		} else {
+			feedOK = true
+			data["feedItems"] = ff.Feed
+			if ff.Cursor != nil && *ff.Cursor != "" && len(ff.Feed) > 0 {
+				data["nextURL"] = pg.nextURL(*ff.Cursor)
+			}
+		}
+	}
+	data["feedOK"] = feedOK
+	if pg.cursor != "" {
+		data["prevURL"] = pg.prevURL()
+		data["firstURL"] = pg.firstURL()
+	}
+	return srv.renderPage(c, http.StatusOK, "feed.html", data)
+}
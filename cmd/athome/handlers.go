@@ -1,8 +1,11 @@
 package main
 
 import (
+	"encoding/xml"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
@@ -13,17 +16,18 @@ import (
 )
 
 func (srv *Server) reqHandle(c echo.Context) syntax.Handle {
-	host := c.Request().Host
-	host = strings.SplitN(host, ":", 2)[0]
-	handle, err := syntax.ParseHandle(host)
-	if err != nil {
-		slog.Warn("host is not a valid handle, fallback to default", "host", host)
-		handle = srv.defaultHandle
+	if s, ok := c.Get("site").(site); ok {
+		return s.Handle
 	}
-	return handle
+	return srv.resolveSite(c).Handle
 }
 
+// WebHome renders the profile on custom domains, which belong to the account
+// entirely; elsewhere, the root may be (eg) a blog proxying /bsky to athome.
 func (srv *Server) WebHome(c echo.Context) error {
+	if s, ok := c.Get("site").(site); ok && s.Custom {
+		return srv.WebProfile(c)
+	}
 	return c.Redirect(http.StatusFound, "/bsky")
 }
 
@@ -37,8 +41,10 @@ func (srv *Server) WebRepoCar(c echo.Context) error {
 }
 
 func (srv *Server) WebPost(c echo.Context) error {
+	if srv.ap != nil && wantsActivity(c) {
+		return srv.APNote(c)
+	}
 	ctx := c.Request().Context()
-	req := c.Request()
 	data := pongo2.Context{}
 	handle := srv.reqHandle(c)
 	// TODO: parse rkey
@@ -54,20 +60,66 @@ func (srv *Server) WebPost(c echo.Context) error {
 	did := pv.Did
 	data["did"] = did
 
+	depth, err := threadParam(c, "depth", srv.threadDepth)
+	if err != nil {
+		return err
+	}
+	parents, err := threadParam(c, "parents", defaultThreadParents)
+	if err != nil {
+		return err
+	}
+
 	// then fetch the post thread (with extra context)
 	aturi := fmt.Sprintf("at://%s/app.bsky.feed.post/%s", did, rkey)
-	tpv, err := appbsky.FeedGetPostThread(ctx, srv.xrpcc, 8, 8, aturi)
+	tpv, err := appbsky.FeedGetPostThread(ctx, srv.xrpcc, int64(depth), int64(parents), aturi)
 	if err != nil {
 		slog.Warn("failed to fetch post", "aturi", aturi, "err", err)
 		// TODO: only if "not found"
 		return echo.NewHTTPError(404, "post not found: %s", handle)
 	}
+	switch {
+	case tpv.Thread == nil || tpv.Thread.FeedDefs_NotFoundPost != nil:
+		return echo.NewHTTPError(404, fmt.Sprintf("post not found: %s", rkey))
+	case tpv.Thread.FeedDefs_BlockedPost != nil:
+		return echo.NewHTTPError(403, "post is not available")
+	case tpv.Thread.FeedDefs_ThreadViewPost == nil:
+		return echo.NewHTTPError(404, "post is not available")
+	}
 	data["postView"] = tpv.Thread.FeedDefs_ThreadViewPost
-	data["requestURI"] = fmt.Sprintf("https://%s%s", req.Host, req.URL.Path)
-	return c.Render(http.StatusOK, "post.html", data)
+	postURL := srv.siteURL(c) + "/bsky/post/" + rkey
+	post := tpv.Thread.FeedDefs_ThreadViewPost.Post
+	data["meta"] = postMeta(post, postURL)
+	if srv.labels.decide(post.Labels, post.Author.Labels).Hide {
+		data["meta"] = pageMeta{Title: "@" + post.Author.Handle, URL: postURL}
+	}
+	data["oembedURL"] = oembedLink(fmt.Sprintf("%s://%s", c.Scheme(), c.Request().Host), postURL)
+	return srv.renderCachedPage(c, "post.html", data)
+}
+
+const (
+	// most levels of replies (or parents) a request can ask for
+	maxThreadDepth       = 20
+	defaultThreadParents = 8
+)
+
+// threadParam reads an optional thread depth query parameter (eg "depth"),
+// capped at maxThreadDepth
+func threadParam(c echo.Context, name string, def int) (int, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, echo.NewHTTPError(400, fmt.Sprintf("invalid '%s' parameter", name))
+	}
+	return min(n, maxThreadDepth), nil
 }
 
 func (srv *Server) WebProfile(c echo.Context) error {
+	if srv.ap != nil && wantsActivity(c) {
+		return srv.APActor(c)
+	}
 	ctx := c.Request().Context()
 	data := pongo2.Context{}
 	handle := srv.reqHandle(c)
@@ -78,23 +130,205 @@ func (srv *Server) WebProfile(c echo.Context) error {
 		// TODO: only if "not found"
 		return echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
 	} else {
-		req := c.Request()
 		data["profileView"] = pv
-		data["requestURI"] = fmt.Sprintf("https://%s%s", req.Host, req.URL.Path)
+		data["meta"] = profileMeta(pv, srv.siteURL(c)+"/bsky")
+		if srv.labels.decide(pv.Labels).Hide {
+			// no description or images in link previews either
+			data["meta"] = pageMeta{Title: "@" + pv.Handle, URL: srv.siteURL(c) + "/bsky"}
+		}
 	}
 	did := pv.Did
 	data["did"] = did
 
-	af, err := appbsky.FeedGetAuthorFeed(ctx, srv.xrpcc, handle.String(), "", "", 100)
+	pg, err := parseFeedPage(c)
+	if err != nil {
+		return err
+	}
+	af, err := appbsky.FeedGetAuthorFeed(ctx, srv.xrpcc, handle.String(), pg.cursor, "", pg.limit)
 	if err != nil {
 		slog.Warn("failed to fetch author feed", "handle", handle, "err", err)
 		// TODO: show some error?
 	} else {
 		data["authorFeed"] = af.Feed
-		//slog.Warn("author feed", "feed", af.Feed)
+		if af.Cursor != nil && len(af.Feed) > 0 {
+			data["nextURL"] = pg.nextURL(*af.Cursor)
+		}
+	}
+	if pg.cursor != "" {
+		data["prevURL"] = pg.prevURL()
+		data["firstURL"] = pg.firstURL()
+	}
+
+	return srv.renderCachedPage(c, "profile.html", data)
+}
+
+const (
+	defaultFeedPageSize = 50
+	maxFeedPageSize     = 100
+	// AppView cursors are short; anything much longer isn't one
+	maxCursorLen = 256
+	// earlier pages remembered for "previous" links
+	maxPageHistory = 10
+)
+
+// feedPage is a page of a feed. AppView cursors only go forwards, so the
+// cursors of earlier pages are carried along in repeated "prev" query
+// parameters (the first page being an empty cursor), up to maxPageHistory.
+type feedPage struct {
+	cursor string
+	limit  int64
+	prev   []string
+	// limit was set in the request, so is kept in links
+	explicitLimit bool
+	// other query parameters kept in links (eg, a search query)
+	extra url.Values
+}
+
+func parseFeedPage(c echo.Context) (*feedPage, error) {
+	q := c.QueryParams()
+	pg := &feedPage{
+		cursor: q.Get("cursor"),
+		limit:  defaultFeedPageSize,
+		prev:   q["prev"],
+	}
+	if len(pg.cursor) > maxCursorLen {
+		return nil, echo.NewHTTPError(400, "invalid 'cursor' parameter")
 	}
+	if len(pg.prev) > maxPageHistory {
+		pg.prev = pg.prev[len(pg.prev)-maxPageHistory:]
+	}
+	for _, p := range pg.prev {
+		if len(p) > maxCursorLen {
+			return nil, echo.NewHTTPError(400, "invalid 'prev' parameter")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, echo.NewHTTPError(400, "invalid 'limit' parameter")
+		}
+		pg.limit = int64(min(n, maxFeedPageSize))
+		pg.explicitLimit = true
+	}
+	return pg, nil
+}
 
-	return c.Render(http.StatusOK, "profile.html", data)
+func (pg *feedPage) link(cursor string, prev []string) string {
+	q := url.Values{}
+	for k, vs := range pg.extra {
+		q[k] = vs
+	}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	for _, p := range prev {
+		q.Add("prev", p)
+	}
+	if pg.explicitLimit {
+		q.Set("limit", strconv.FormatInt(pg.limit, 10))
+	}
+	if len(q) == 0 {
+		// relative to the current path, without any query
+		return "?"
+	}
+	return "?" + q.Encode()
+}
+
+func (pg *feedPage) nextURL(next string) string {
+	prev := append(pg.prev[:len(pg.prev):len(pg.prev)], pg.cursor)
+	if len(prev) > maxPageHistory {
+		prev = prev[len(prev)-maxPageHistory:]
+	}
+	return pg.link(next, prev)
+}
+
+// prevURL goes back one page; past the remembered history, it goes to the
+// first page
+func (pg *feedPage) prevURL() string {
+	if len(pg.prev) == 0 {
+		return pg.firstURL()
+	}
+	last := len(pg.prev) - 1
+	return pg.link(pg.prev[last], pg.prev[:last])
+}
+
+func (pg *feedPage) firstURL() string {
+	return pg.link("", nil)
+}
+
+// WebList renders one of the account's lists: its metadata, and either a page
+// of members or (for curation lists) a page of the list feed. Both are
+// paginated like the profile.
+func (srv *Server) WebList(c echo.Context) error {
+	ctx := c.Request().Context()
+	data := pongo2.Context{}
+	handle := srv.reqHandle(c)
+
+	rkey, err := syntax.ParseRecordKey(c.Param("rkey"))
+	if err != nil {
+		return echo.NewHTTPError(400, fmt.Sprintf("invalid list record key: %s", err))
+	}
+	showFeed := strings.HasSuffix(c.Path(), "/feed")
+	pg, err := parseFeedPage(c)
+	if err != nil {
+		return err
+	}
+
+	pv, err := appbsky.ActorGetProfile(ctx, srv.xrpcc, handle.String())
+	if err != nil {
+		slog.Warn("failed to fetch handle", "handle", handle, "err", err)
+		// TODO: only if "not found"
+		return echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
+	}
+	did := pv.Did
+	data["did"] = did
+	data["profileView"] = pv
+
+	aturi := fmt.Sprintf("at://%s/app.bsky.graph.list/%s", did, rkey)
+	// when showing the feed, only the list metadata is needed, not members
+	memberCursor, memberLimit := pg.cursor, pg.limit
+	if showFeed {
+		memberCursor, memberLimit = "", 1
+	}
+	lv, err := appbsky.GraphGetList(ctx, srv.xrpcc, memberCursor, memberLimit, aturi)
+	if err != nil {
+		slog.Warn("failed to fetch list", "aturi", aturi, "err", err)
+		// TODO: only if "not found"
+		return echo.NewHTTPError(404, fmt.Sprintf("list not found: %s", rkey))
+	}
+	data["listView"] = lv.List
+	data["rkey"] = rkey.String()
+	// moderation lists don't have a feed
+	isCuration := isCurationList(lv.List)
+	data["isCuration"] = isCuration
+
+	if showFeed {
+		if !isCuration {
+			return echo.NewHTTPError(404, "list has no feed")
+		}
+		lf, err := appbsky.FeedGetListFeed(ctx, srv.xrpcc, pg.cursor, pg.limit, aturi)
+		if err != nil {
+			slog.Warn("failed to fetch list feed", "aturi", aturi, "err", err)
+			// TODO: show some error?
+		} else {
+			data["listFeed"] = lf.Feed
+			if lf.Cursor != nil && len(lf.Feed) > 0 {
+				data["nextURL"] = pg.nextURL(*lf.Cursor)
+			}
+		}
+	} else {
+		data["listItems"] = lv.Items
+		if lv.Cursor != nil && len(lv.Items) > 0 {
+			data["nextURL"] = pg.nextURL(*lv.Cursor)
+		}
+	}
+	if pg.cursor != "" {
+		data["prevURL"] = pg.prevURL()
+		data["firstURL"] = pg.firstURL()
+	}
+	data["showFeed"] = showFeed
+	data["meta"] = listMeta(lv.List, srv.siteURL(c)+"/bsky/list/"+rkey.String())
+	return srv.renderPage(c, http.StatusOK, "list.html", data)
 }
 
 // https://medium.com/@etiennerouzeaud/a-rss-feed-valid-in-go-edfc22e410c7
@@ -149,7 +383,7 @@ func (srv *Server) WebRepoRSS(c echo.Context) error {
 		}
 		posts = append(posts, Item{
 			Title:       "@" + handle.String() + " post",
-			Link:        fmt.Sprintf("https://%s/bsky/post/%s", handle, aturi.RecordKey().String()),
+			Link:        srv.siteURL(c) + "/bsky/post/" + aturi.RecordKey().String(),
 			Description: rec.Text,
 			PubDate:     rec.CreatedAt,
 		})
@@ -166,9 +400,13 @@ func (srv *Server) WebRepoRSS(c echo.Context) error {
 	feed := &rss{
 		Version:     "2.0",
 		Description: desc,
-		Link:        fmt.Sprintf("https://%s/bsky", handle.String()),
+		Link:        srv.siteURL(c) + "/bsky",
 		Title:       title,
 		Item:        posts,
 	}
-	return c.XML(http.StatusOK, feed)
+	b, err := xml.Marshal(feed)
+	if err != nil {
+		return err
+	}
+	return srv.writeCached(c, echo.MIMEApplicationXMLCharsetUTF8, append([]byte(xml.Header), b...))
 }
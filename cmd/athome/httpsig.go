@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTP Signatures (draft-cavage-http-signatures-12) with rsa-sha256, which is
+// what Mastodon and most other ActivityPub servers use to authenticate
+// requests between servers
+
+// how far a signed Date may be from our clock
+const maxSignatureSkew = time.Hour
+
+// bodyDigest is the value of a Digest header for body
+func bodyDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// signingString builds the string covered by a signature. For responses, req
+// is nil and "(request-target)" can't be signed.
+func signingString(req *http.Request, h http.Header, headers []string) (string, error) {
+	lines := make([]string, 0, len(headers))
+	for _, name := range headers {
+		switch name {
+		case "(request-target)":
+			if req == nil {
+				return "", errors.New("(request-target) can only be signed in requests")
+			}
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s", strings.ToLower(req.Method), req.URL.RequestURI()))
+		case "host":
+			host := h.Get("Host")
+			if host == "" && req != nil {
+				host = req.Host
+				if host == "" {
+					host = req.URL.Host
+				}
+			}
+			lines = append(lines, "host: "+host)
+		default:
+			vals := h.Values(name)
+			if len(vals) == 0 {
+				return "", fmt.Errorf("signed header %q missing", name)
+			}
+			lines = append(lines, name+": "+strings.Join(vals, ", "))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// signHeaders adds Date (unless already set) and Digest headers to h, then a
+// Signature over them (and, for requests, the method, path and host)
+func signHeaders(req *http.Request, h http.Header, body []byte, keyID string, key *rsa.PrivateKey) error {
+	if h.Get("Date") == "" {
+		h.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	h.Set("Digest", bodyDigest(body))
+	headers := []string{"date", "digest"}
+	if req != nil {
+		headers = []string{"(request-target)", "host", "date", "digest"}
+	}
+
+	s, err := signingString(req, h, headers)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(s))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return err
+	}
+	h.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// signRequest signs an outgoing request, whose body (if any) is body
+func signRequest(req *http.Request, body []byte, keyID string, key *rsa.PrivateKey) error {
+	return signHeaders(req, req.Header, body, keyID, key)
+}
+
+// httpSignature is a parsed Signature header
+type httpSignature struct {
+	KeyID     string
+	Algorithm string
+	Headers   []string
+	Signature []byte
+}
+
+func parseSignatureHeader(v string) (*httpSignature, error) {
+	if v == "" {
+		return nil, errors.New("request is not signed")
+	}
+	sig := &httpSignature{Headers: []string{"date"}}
+	for _, part := range strings.Split(v, ",") {
+		k, val, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid signature parameter %q", part)
+		}
+		val = strings.Trim(val, `"`)
+		switch k {
+		case "keyId":
+			sig.KeyID = val
+		case "algorithm":
+			sig.Algorithm = val
+		case "headers":
+			sig.Headers = strings.Fields(strings.ToLower(val))
+		case "signature":
+			b, err := base64.StdEncoding.DecodeString(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid signature encoding: %w", err)
+			}
+			sig.Signature = b
+		}
+	}
+	if sig.KeyID == "" || len(sig.Signature) == 0 {
+		return nil, errors.New("signature missing keyId or signature")
+	}
+	return sig, nil
+}
+
+// verifyRequest checks an incoming request's signature, which must cover the
+// request target, host, date and body digest, against key. The caller looks
+// up the key from sig.KeyID.
+func verifyRequest(req *http.Request, body []byte, sig *httpSignature, key *rsa.PublicKey) error {
+	switch sig.Algorithm {
+	case "", "rsa-sha256", "hs2019":
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", sig.Algorithm)
+	}
+	for _, need := range []string{"(request-target)", "host", "date", "digest"} {
+		found := false
+		for _, h := range sig.Headers {
+			found = found || h == need
+		}
+		if !found {
+			return fmt.Errorf("signature doesn't cover %s", need)
+		}
+	}
+
+	date, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("invalid Date header: %w", err)
+	}
+	if d := time.Since(date); d > maxSignatureSkew || d < -maxSignatureSkew {
+		return errors.New("signature date out of range")
+	}
+	if req.Header.Get("Digest") != bodyDigest(body) {
+		return errors.New("body digest mismatch")
+	}
+
+	s, err := signingString(req, req.Header, sig.Headers)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256([]byte(s))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig.Signature)
+}
+
+// parsePublicKeyPEM parses an actor's publicKeyPem, which is PKIX (or, from
+// some servers, PKCS #1)
+func parsePublicKeyPEM(s string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("no PEM data in public key")
+	}
+	if k, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rk, ok := k.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not RSA")
+	}
+	return rk, nil
+}
+
+// parsePrivateKeyPEM parses an RSA private key file, PKCS #1 ("BEGIN RSA
+// PRIVATE KEY") or PKCS #8 ("BEGIN PRIVATE KEY")
+func parsePrivateKeyPEM(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data in private key file")
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rk, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA")
+	}
+	return rk, nil
+}
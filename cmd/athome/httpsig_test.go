@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSignatureHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	sig, err := parseSignatureHeader(`keyId="https://example.com/actor#main-key",algorithm="rsa-sha256",headers="(request-target) Host Date Digest",signature="c2lnbmVk"`)
+	if assert.NoError(err) {
+		assert.Equal("https://example.com/actor#main-key", sig.KeyID)
+		assert.Equal("rsa-sha256", sig.Algorithm)
+		assert.Equal([]string{"(request-target)", "host", "date", "digest"}, sig.Headers)
+		assert.Equal([]byte("signed"), sig.Signature)
+	}
+
+	sig, err = parseSignatureHeader(`keyId="key", signature="c2lnbmVk"`)
+	if assert.NoError(err) {
+		assert.Equal("", sig.Algorithm)
+		assert.Equal([]string{"date"}, sig.Headers)
+	}
+
+	for _, v := range []string{
+		"",
+		`keyId="key"`,
+		`signature="c2lnbmVk"`,
+		`keyId="",signature="c2lnbmVk"`,
+		`keyId="key",signature=""`,
+		`keyId="key",signature="not base64!"`,
+		`keyId="key",signature`,
+	} {
+		_, err := parseSignatureHeader(v)
+		assert.Error(err, v)
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"type":"Follow"}`)
+
+	fixtures := []struct {
+		name string
+		// changes the request after it's signed
+		tamper func(req *http.Request, sig *httpSignature) []byte
+		key    *rsa.PublicKey
+		ok     bool
+	}{
+		{
+			name: "valid",
+			ok:   true,
+		},
+		{
+			name: "hs2019",
+			tamper: func(req *http.Request, sig *httpSignature) []byte {
+				sig.Algorithm = "hs2019"
+				return body
+			},
+			ok: true,
+		},
+		{
+			name: "wrong key",
+			key:  &other.PublicKey,
+		},
+		{
+			name: "unsupported algorithm",
+			tamper: func(req *http.Request, sig *httpSignature) []byte {
+				sig.Algorithm = "hmac-sha256"
+				return body
+			},
+		},
+		{
+			name: "body changed",
+			tamper: func(req *http.Request, sig *httpSignature) []byte {
+				return []byte(`{"type":"Undo"}`)
+			},
+		},
+		{
+			name: "body and digest changed",
+			tamper: func(req *http.Request, sig *httpSignature) []byte {
+				b := []byte(`{"type":"Undo"}`)
+				req.Header.Set("Digest", bodyDigest(b))
+				return b
+			},
+		},
+		{
+			name: "path changed",
+			tamper: func(req *http.Request, sig *httpSignature) []byte {
+				req.URL.Path = "/users/bob/inbox"
+				return body
+			},
+		},
+		{
+			name: "host changed",
+			tamper: func(req *http.Request, sig *httpSignature) []byte {
+				req.Host = "evil.example"
+				return body
+			},
+		},
+		{
+			name: "digest not covered",
+			tamper: func(req *http.Request, sig *httpSignature) []byte {
+				sig.Headers = []string{"(request-target)", "host", "date"}
+				return body
+			},
+		},
+		{
+			name: "request target not covered",
+			tamper: func(req *http.Request, sig *httpSignature) []byte {
+				sig.Headers = []string{"date"}
+				return body
+			},
+		},
+		{
+			name: "missing date",
+			tamper: func(req *http.Request, sig *httpSignature) []byte {
+				req.Header.Del("Date")
+				return body
+			},
+		},
+		{
+			name: "stale date",
+			tamper: func(req *http.Request, sig *httpSignature) []byte {
+				req.Header.Set("Date", time.Now().Add(-2*time.Hour).UTC().Format(http.TimeFormat))
+				return body
+			},
+		},
+		{
+			name: "future date",
+			tamper: func(req *http.Request, sig *httpSignature) []byte {
+				req.Header.Set("Date", time.Now().Add(2*time.Hour).UTC().Format(http.TimeFormat))
+				return body
+			},
+		},
+	}
+
+	for _, f := range fixtures {
+		req := httptest.NewRequest("POST", "https://athome.example/users/alice/inbox", bytes.NewReader(body))
+		if err := signRequest(req, body, "https://remote.example/actor#main-key", key); err != nil {
+			t.Fatal(err)
+		}
+		sig, err := parseSignatureHeader(req.Header.Get("Signature"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := body
+		if f.tamper != nil {
+			got = f.tamper(req, sig)
+		}
+		pub := &key.PublicKey
+		if f.key != nil {
+			pub = f.key
+		}
+
+		err = verifyRequest(req, got, sig, pub)
+		if f.ok {
+			assert.NoError(err, f.name)
+		} else {
+			assert.Error(err, f.name)
+		}
+	}
+}
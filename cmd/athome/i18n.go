@@ -0,0 +1,190 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/language"
+)
+
+// Message catalogs are JSON objects in locales/, one per language (named by
+// BCP-47 tag, eg "pt-BR.json"), mapping the English text used in templates to
+// its translation. Messages missing from a catalog are shown in English.
+//
+//go:embed locales/*.json
+var LocaleFS embed.FS
+
+func init() {
+	if err := pongo2.RegisterTag("trans", tagTransParser); err != nil {
+		panic(err)
+	}
+}
+
+// catalog is the messages for one language
+type catalog struct {
+	Lang     language.Tag
+	messages map[string]string
+}
+
+// translate looks up msg and formats it with args, fmt style
+func (cat *catalog) translate(msg string, args ...any) string {
+	if cat != nil {
+		if t, ok := cat.messages[msg]; ok && t != "" {
+			msg = t
+		}
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// localizer picks the catalog for each request
+type localizer struct {
+	def      *catalog
+	catalogs []*catalog
+	matcher  language.Matcher
+}
+
+// loadLocalizer reads the embedded catalogs. English is built in, as the
+// templates are written in it; defaultLang is used when a request doesn't ask
+// for any language there is a catalog for.
+func loadLocalizer(defaultLang string) (*localizer, error) {
+	catalogs := []*catalog{{Lang: language.English}}
+	files, err := LocaleFS.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), ".json")
+		tag, err := language.Parse(name)
+		if err != nil {
+			return nil, fmt.Errorf("message catalog %s: %w", f.Name(), err)
+		}
+		b, err := LocaleFS.ReadFile(path.Join("locales", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		cat := &catalog{Lang: tag}
+		if err := json.Unmarshal(b, &cat.messages); err != nil {
+			return nil, fmt.Errorf("message catalog %s: %w", f.Name(), err)
+		}
+		catalogs = append(catalogs, cat)
+	}
+
+	tags := make([]language.Tag, len(catalogs))
+	for i, cat := range catalogs {
+		tags[i] = cat.Lang
+	}
+	l := &localizer{
+		catalogs: catalogs,
+		matcher:  language.NewMatcher(tags),
+	}
+
+	tag, err := language.Parse(defaultLang)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default language: %w", err)
+	}
+	for _, cat := range catalogs {
+		if cat.Lang == tag {
+			l.def = cat
+		}
+	}
+	if l.def == nil {
+		return nil, fmt.Errorf("no message catalog for default language %q", defaultLang)
+	}
+	return l, nil
+}
+
+// negotiate picks the catalog for a request: a ?lang= parameter, then the
+// Accept-Language header, then the default
+func (l *localizer) negotiate(c echo.Context) *catalog {
+	var prefs []language.Tag
+	if v := c.QueryParam("lang"); v != "" {
+		if tag, err := language.Parse(v); err == nil {
+			prefs = append(prefs, tag)
+		}
+	}
+	if accept, _, err := language.ParseAcceptLanguage(c.Request().Header.Get("Accept-Language")); err == nil {
+		prefs = append(prefs, accept...)
+	}
+	if len(prefs) == 0 {
+		return l.def
+	}
+	_, idx, conf := l.matcher.Match(prefs...)
+	if conf == language.No {
+		return l.def
+	}
+	return l.catalogs[idx]
+}
+
+// localeMiddleware makes the request's catalog available to templates, as
+// "i18n" (for the trans tag) and "lang" (its BCP-47 tag)
+func (l *localizer) localeMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Add("Vary", "Accept-Language")
+		c.Set("i18n", l.negotiate(c))
+		return next(c)
+	}
+}
+
+// {% trans "message" [args...] %} writes the message translated into the
+// request's language, formatted with args (eg, %d for a count), escaped
+type tagTransNode struct {
+	msg  string
+	args []pongo2.IEvaluator
+}
+
+func (node *tagTransNode) Execute(ctx *pongo2.ExecutionContext, writer pongo2.TemplateWriter) *pongo2.Error {
+	cat, _ := ctx.Public["i18n"].(*catalog)
+	var args []any
+	for _, a := range node.args {
+		v, err := a.Evaluate(ctx)
+		if err != nil {
+			return err
+		}
+		args = append(args, transArg(v))
+	}
+	writer.WriteString(html.EscapeString(cat.translate(node.msg, args...)))
+	return nil
+}
+
+// transArg dereferences pointers (the AppView's optional counts are *int64),
+// with nil as the zero value
+func transArg(v *pongo2.Value) any {
+	rv := reflect.ValueOf(v.Interface())
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return reflect.Zero(rv.Type().Elem()).Interface()
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return ""
+	}
+	return rv.Interface()
+}
+
+func tagTransParser(doc *pongo2.Parser, start *pongo2.Token, arguments *pongo2.Parser) (pongo2.INodeTag, *pongo2.Error) {
+	msg := arguments.MatchType(pongo2.TokenString)
+	if msg == nil {
+		return nil, arguments.Error("trans-tag needs a message string.", nil)
+	}
+	node := &tagTransNode{msg: msg.Val}
+	for arguments.Remaining() > 0 {
+		arguments.Match(pongo2.TokenSymbol, ",")
+		expr, err := arguments.ParseExpression()
+		if err != nil {
+			return nil, err
+		}
+		node.args = append(node.args, expr)
+	}
+	return node, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/language"
+)
+
+func TestNegotiate(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := loadLocalizer("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+
+	fixtures := []struct {
+		query  string
+		accept string
+		lang   language.Tag
+	}{
+		{"", "", language.English},
+		{"", "de", language.German},
+		{"", "de-DE,de;q=0.9,en;q=0.8", language.German},
+		{"", "es-MX", language.Spanish},
+		{"", "fr", language.English},
+		{"", "fr-FR,fr;q=0.9,es;q=0.5", language.Spanish},
+		{"", "es;q=0.5, de;q=0.8", language.German},
+		{"", "en-GB,de;q=0.5", language.English},
+		{"", "*", language.English},
+		{"", "not a language!", language.English},
+		{"es", "de", language.Spanish},
+		{"fr", "de", language.German},
+		{"not a language!", "de", language.German},
+		{"de", "", language.German},
+	}
+	for _, f := range fixtures {
+		req := httptest.NewRequest("GET", "/?lang="+url.QueryEscape(f.query), nil)
+		if f.accept != "" {
+			req.Header.Set("Accept-Language", f.accept)
+		}
+		c := e.NewContext(req, httptest.NewRecorder())
+		cat := l.negotiate(c)
+		if assert.NotNil(cat) {
+			assert.Equal(f.lang, cat.Lang, "lang=%q Accept-Language: %q", f.query, f.accept)
+		}
+	}
+
+	// a default other than English
+	l, err = loadLocalizer("de")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for accept, lang := range map[string]language.Tag{
+		"":   language.German,
+		"fr": language.German,
+		"en": language.English,
+		"es": language.Spanish,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Language", accept)
+		cat := l.negotiate(e.NewContext(req, httptest.NewRecorder()))
+		assert.Equal(lang, cat.Lang, "Accept-Language: %q", accept)
+	}
+
+	_, err = loadLocalizer("fr")
+	assert.Error(err)
+}
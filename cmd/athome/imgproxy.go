@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/util"
+
+	"github.com/flosch/pongo2/v6"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// largest image fetched from upstream
+	maxImageBytes = 16 * 1024 * 1024
+	// largest image decoded, against decompression bombs
+	maxImagePixels = 50 * 1000 * 1000
+)
+
+// imagePreset is a size images are served at. Images are scaled down to fit
+// within Width x Height, keeping their aspect ratio, and never scaled up.
+type imagePreset struct {
+	Width   int
+	Height  int
+	Quality int
+}
+
+// the presets templates use; any of them can be overridden, and more added,
+// with --image-presets
+var defaultImagePresets = map[string]imagePreset{
+	"avatar":   {Width: 128, Height: 128, Quality: 85},
+	"banner":   {Width: 1500, Height: 500, Quality: 85},
+	"thumb":    {Width: 1000, Height: 1000, Quality: 80},
+	"fullsize": {Width: 2000, Height: 2000, Quality: 85},
+}
+
+// parseImagePresets applies "name=WxH" or "name=WxH:quality" overrides to the
+// default presets
+func parseImagePresets(overrides []string) (map[string]imagePreset, error) {
+	out := make(map[string]imagePreset, len(defaultImagePresets))
+	for name, p := range defaultImagePresets {
+		out[name] = p
+	}
+	for _, o := range overrides {
+		name, spec, ok := strings.Cut(o, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid image preset %q (expected name=WxH[:quality])", o)
+		}
+		p := imagePreset{Quality: 85}
+		dims, q, hasQuality := strings.Cut(spec, ":")
+		ws, hs, ok := strings.Cut(dims, "x")
+		if !ok {
+			return nil, fmt.Errorf("invalid image preset %q (expected name=WxH[:quality])", o)
+		}
+		var err error
+		if p.Width, err = strconv.Atoi(ws); err != nil || p.Width <= 0 {
+			return nil, fmt.Errorf("invalid image preset width: %q", o)
+		}
+		if p.Height, err = strconv.Atoi(hs); err != nil || p.Height <= 0 {
+			return nil, fmt.Errorf("invalid image preset height: %q", o)
+		}
+		if hasQuality {
+			if p.Quality, err = strconv.Atoi(q); err != nil || p.Quality < 1 || p.Quality > 100 {
+				return nil, fmt.Errorf("invalid image preset quality: %q", o)
+			}
+		}
+		out[name] = p
+	}
+	return out, nil
+}
+
+// imageProxy serves the images (avatars, banners, embeds) on a site's pages
+// from athome itself, so pages don't make viewers' browsers fetch from the
+// CDN. Images are fetched from the CDN, resized to a preset, re-encoded as
+// JPEG, and cached on disk. Blobs are content-addressed, so cached images
+// never go stale; the least recently used are removed once the cache is over
+// its size, and fetches are rate limited.
+//
+// Only the images of the account a site is for are also fetched from its PDS
+// when the CDN doesn't have them: otherwise anyone could have the proxy fetch
+// from whatever PDS their DID document names.
+type imageProxy struct {
+	cdnHost string
+	dir     string
+	presets map[string]imagePreset
+	ident   identity.Directory
+	client  *http.Client
+	flight  singleflight.Group
+	// nil if cache misses aren't rate limited
+	limiter *rateLimiter
+
+	// cached files, to their size; zero maxBytes is unbounded
+	cacheLk    sync.Mutex
+	cached     *lru.Cache[string, int64]
+	cacheBytes int64
+	maxBytes   int64
+}
+
+func newImageProxy(cdnHost, dir string, presets map[string]imagePreset, ident identity.Directory, maxBytes int64) (*imageProxy, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating image cache dir: %w", err)
+	}
+	ip := &imageProxy{
+		cdnHost:  strings.TrimSuffix(cdnHost, "/"),
+		dir:      dir,
+		presets:  presets,
+		ident:    ident,
+		client:   util.SafeHTTPClient(),
+		maxBytes: maxBytes,
+	}
+	// the bound is in bytes, not entries
+	cached, err := lru.NewWithEvict[string, int64](math.MaxInt32, ip.evicted)
+	if err != nil {
+		return nil, err
+	}
+	ip.cached = cached
+	if err := ip.loadCache(); err != nil {
+		return nil, fmt.Errorf("reading image cache dir: %w", err)
+	}
+	return ip, nil
+}
+
+// loadCache picks up the images cached by earlier runs, oldest first, and
+// trims the cache to its size
+func (ip *imageProxy) loadCache() error {
+	type cachedFile struct {
+		path string
+		info fs.FileInfo
+	}
+	var files []cachedFile
+	err := filepath.WalkDir(ip.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".jpg" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, cachedFile{path: path, info: info})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	for _, f := range files {
+		ip.addCached(f.path, f.info.Size())
+	}
+	return nil
+}
+
+// addCached records a file in the cache, removing the least recently used
+// files while the cache is over its size
+func (ip *imageProxy) addCached(path string, size int64) {
+	ip.cacheLk.Lock()
+	defer ip.cacheLk.Unlock()
+	if old, ok := ip.cached.Peek(path); ok {
+		ip.cacheBytes -= old
+	}
+	ip.cached.Add(path, size)
+	ip.cacheBytes += size
+	for ip.maxBytes > 0 && ip.cacheBytes > ip.maxBytes && ip.cached.Len() > 1 {
+		ip.cached.RemoveOldest()
+	}
+}
+
+// evicted removes a file dropped from the cache. Called by the LRU, with
+// cacheLk held.
+func (ip *imageProxy) evicted(path string, size int64) {
+	ip.cacheBytes -= size
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("failed to remove cached image", "path", path, "err", err)
+	}
+}
+
+// siteDID is the DID of the account the request's site is for, the only
+// account whose images are fetched from its PDS
+func (ip *imageProxy) siteDID(c echo.Context) (syntax.DID, bool) {
+	s, ok := c.Get("site").(site)
+	if !ok {
+		return "", false
+	}
+	ident, err := ip.ident.LookupHandle(c.Request().Context(), s.Handle)
+	if err != nil {
+		return "", false
+	}
+	return ident.DID, true
+}
+
+// URL rewrites an AppView image URL (eg,
+// https://cdn.bsky.app/img/avatar/plain/<did>/<cid>@jpeg) to the proxy at
+// baseURL. Other URLs, and unknown presets, are returned unchanged.
+func (ip *imageProxy) URL(baseURL, src, preset string) string {
+	if ip == nil {
+		return src
+	}
+	if _, ok := ip.presets[preset]; !ok {
+		return src
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return src
+	}
+	// img/<kind>/plain/<did>/<cid>@<format>
+	parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	if len(parts) != 5 || parts[0] != "img" || parts[2] != "plain" {
+		return src
+	}
+	did, cid := parts[3], strings.SplitN(parts[4], "@", 2)[0]
+	return baseURL + "/bsky/img/" + preset + "/" + did + "/" + cid
+}
+
+// HandleImage is the /img/:preset/<did>/<cid> route (also under /bsky)
+func (ip *imageProxy) HandleImage(c echo.Context) error {
+	preset, ok := ip.presets[c.Param("preset")]
+	if !ok {
+		return echo.NewHTTPError(404, "unknown image preset")
+	}
+	didStr, cidStr, ok := strings.Cut(c.Param("*"), "/")
+	if !ok {
+		return echo.NewHTTPError(404, "not an image path")
+	}
+	did, err := syntax.ParseDID(didStr)
+	if err != nil {
+		return echo.NewHTTPError(400, "invalid DID")
+	}
+	cid, err := syntax.ParseCID(cidStr)
+	if err != nil {
+		return echo.NewHTTPError(400, "invalid CID")
+	}
+	path := ip.cachePath(did, cid, preset)
+	if _, ok := ip.cached.Get(path); !ok {
+		// only fetching and resizing counts against rate limits, since a page
+		// has many images
+		if err := ip.limiter.allow(c); err != nil {
+			return err
+		}
+		// concurrent requests for the same image only fetch it once. the
+		// fetch is shared, so it isn't cancelled with any one request.
+		ctx := context.WithoutCancel(c.Request().Context())
+		served, _ := ip.siteDID(c)
+		_, err, _ = ip.flight.Do(path, func() (any, error) {
+			return nil, ip.fetch(ctx, did, cid, preset, path, did == served)
+		})
+		if err != nil {
+			slog.Warn("failed to proxy image", "did", did, "cid", cid, "err", err)
+			return echo.NewHTTPError(http.StatusBadGateway, "image unavailable")
+		}
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		// removed from under the cache; fetched again next time
+		ip.cacheLk.Lock()
+		ip.cached.Remove(path)
+		ip.cacheLk.Unlock()
+		return echo.NewHTTPError(http.StatusBadGateway, "image unavailable")
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	resp := c.Response()
+	resp.Header().Set("Content-Type", "image/jpeg")
+	resp.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	resp.Header().Set("ETag", `"`+filepath.Base(path)+`"`)
+	http.ServeContent(resp, c.Request(), "", st.ModTime(), f)
+	return nil
+}
+
+// cachePath includes the preset's settings, so changing a preset doesn't
+// serve images at the old size
+func (ip *imageProxy) cachePath(did syntax.DID, cid syntax.CID, p imagePreset) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%dx%d:%d", did, cid, p.Width, p.Height, p.Quality)))
+	name := hex.EncodeToString(h[:16])
+	return filepath.Join(ip.dir, name[:2], name+".jpg")
+}
+
+// fetch gets an image from the CDN, or if fromPDS, failing that from its
+// account's PDS, and caches it at path
+func (ip *imageProxy) fetch(ctx context.Context, did syntax.DID, cid syntax.CID, p imagePreset, path string, fromPDS bool) error {
+	body, err := ip.download(ctx, fmt.Sprintf("%s/img/feed_fullsize/plain/%s/%s@jpeg", ip.cdnHost, did, cid))
+	if err != nil && !fromPDS {
+		return err
+	}
+	if err != nil {
+		slog.Info("image not available from CDN, trying PDS", "did", did, "cid", cid, "err", err)
+		ident, lerr := ip.ident.LookupDID(ctx, did)
+		if lerr != nil {
+			return lerr
+		}
+		pds := ident.PDSEndpoint()
+		if pds == "" {
+			return fmt.Errorf("no PDS for %s", did)
+		}
+		body, err = ip.download(ctx, fmt.Sprintf("%s/xrpc/com.atproto.sync.getBlob?did=%s&cid=%s", pds, url.QueryEscape(did.String()), url.QueryEscape(cid.String())))
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("decoding image: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return fmt.Errorf("image too large to resize (%dx%d)", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("decoding image: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resizeImage(img, p.Width, p.Height), &jpeg.Options{Quality: p.Quality}); err != nil {
+		return err
+	}
+
+	// write then rename, so a partial file is never served
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	ip.addCached(path, int64(buf.Len()))
+	return nil
+}
+
+func (ip *imageProxy) download(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "athome/"+version)
+	resp, err := ip.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: HTTP %d", u, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxImageBytes {
+		return nil, fmt.Errorf("fetching %s: image larger than %d bytes", u, maxImageBytes)
+	}
+	return body, nil
+}
+
+// resizeImage scales src down to fit within maxW x maxH, averaging the source
+// pixels covered by each output pixel. Images which already fit are only
+// converted. Transparency is flattened onto white, since JPEG has none.
+func resizeImage(src image.Image, maxW, maxH int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Over)
+	if sw <= maxW && sh <= maxH {
+		return rgba
+	}
+
+	scale := min(float64(maxW)/float64(sw), float64(maxH)/float64(sh))
+	dw, dh := max(1, int(float64(sw)*scale)), max(1, int(float64(sh)*scale))
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+			var r, g, bl, a, n int
+			for sy := y0; sy < y1; sy++ {
+				off := sy*rgba.Stride + x0*4
+				for sx := x0; sx < x1; sx++ {
+					r += int(rgba.Pix[off])
+					g += int(rgba.Pix[off+1])
+					bl += int(rgba.Pix[off+2])
+					a += int(rgba.Pix[off+3])
+					off += 4
+					n++
+				}
+			}
+			o := y*dst.Stride + x*4
+			dst.Pix[o] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(bl / n)
+			dst.Pix[o+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// imageURLFunc is the "img" template function, {{ img(url, "preset") }}.
+// URLs are absolute, for fragments embedded in other sites.
+func imageURLFunc(ip *imageProxy, c echo.Context) func(src, preset *pongo2.Value) string {
+	baseURL := fmt.Sprintf("%s://%s", c.Scheme(), c.Request().Host)
+	return func(src, preset *pongo2.Value) string {
+		return ip.URL(baseURL, src.String(), preset.String())
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	const cid = "bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity"
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the CDN has other.test's image, but not site.test's or missing.test's
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "did:plc:other") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(buf.Bytes())
+	}))
+	defer cdn.Close()
+	var lk sync.Mutex
+	pdsFetches := map[string]int{}
+	pds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lk.Lock()
+		pdsFetches[r.URL.Query().Get("did")]++
+		lk.Unlock()
+		w.Write(buf.Bytes())
+	}))
+	defer pds.Close()
+
+	dir := identity.NewMockDirectory()
+	for _, name := range []string{"site", "other", "missing"} {
+		dir.Insert(identity.Identity{
+			DID:      syntax.DID("did:plc:" + name),
+			Handle:   syntax.Handle(name + ".test"),
+			Services: map[string]identity.Service{"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: pds.URL}},
+		})
+	}
+	presets, err := parseImagePresets(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := newImageProxy(cdn.URL, t.TempDir(), presets, &dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip.client = http.DefaultClient
+
+	// every account's images are rewritten to the proxy
+	for _, did := range []string{"did:plc:site", "did:plc:other"} {
+		assert.Equal("https://site.test/bsky/img/avatar/"+did+"/"+cid, ip.URL("https://site.test", "https://cdn.bsky.app/img/avatar/plain/"+did+"/"+cid+"@jpeg", "avatar"))
+	}
+	assert.Equal("https://example.com/a.png", ip.URL("https://site.test", "https://example.com/a.png", "avatar"))
+	assert.Equal("https://cdn.bsky.app/img/avatar/plain/did:plc:site/"+cid+"@jpeg", ip.URL("https://site.test", "https://cdn.bsky.app/img/avatar/plain/did:plc:site/"+cid+"@jpeg", "huge"))
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("site", site{Domain: "site.test", Handle: "site.test"})
+			return next(c)
+		}
+	})
+	e.GET("/img/:preset/*", ip.HandleImage)
+	get := func(did string) int {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest("GET", "/img/avatar/"+did+"/"+cid, nil))
+		return rec.Code
+	}
+
+	// from the CDN, for any account
+	assert.Equal(http.StatusOK, get("did:plc:other"))
+	// from the site account's PDS, when the CDN doesn't have it
+	assert.Equal(http.StatusOK, get("did:plc:site"))
+	// but not from other accounts' PDS hosts
+	assert.Equal(http.StatusBadGateway, get("did:plc:missing"))
+	assert.Equal(map[string]int{"did:plc:site": 1}, pdsFetches)
+
+	// and cached
+	assert.Equal(http.StatusOK, get("did:plc:site"))
+	assert.Equal(1, pdsFetches["did:plc:site"])
+}
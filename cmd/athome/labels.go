@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// what to do with content which has a given label
+type labelAction string
+
+const (
+	labelShow labelAction = "show"
+	// render collapsed, behind a click-to-reveal warning
+	labelWarn labelAction = "warn"
+	// don't render at all, just a placeholder
+	labelHide labelAction = "hide"
+)
+
+func (a labelAction) rank() int {
+	switch a {
+	case labelHide:
+		return 2
+	case labelWarn:
+		return 1
+	}
+	return 0
+}
+
+// labelPolicy maps label values to actions; labels which aren't listed are
+// shown.
+type labelPolicy map[string]labelAction
+
+// athome pages are public and logged out, so they follow what the Bluesky app
+// does for logged-out viewers with adult content disabled
+var defaultLabelPolicy = labelPolicy{
+	"!hide":               labelHide,
+	"!warn":               labelWarn,
+	"!no-unauthenticated": labelHide,
+	"porn":                labelHide,
+	"sexual":              labelWarn,
+	"nudity":              labelWarn,
+	"graphic-media":       labelWarn,
+	"gore":                labelWarn,
+}
+
+// parseLabelPolicy applies "label=action" overrides to the default policy
+func parseLabelPolicy(overrides []string) (labelPolicy, error) {
+	lp := make(labelPolicy, len(defaultLabelPolicy))
+	for val, act := range defaultLabelPolicy {
+		lp[val] = act
+	}
+	for _, o := range overrides {
+		val, act, ok := strings.Cut(o, "=")
+		if !ok || val == "" {
+			return nil, fmt.Errorf("invalid label policy %q (expected label=show|warn|hide)", o)
+		}
+		switch a := labelAction(strings.ToLower(act)); a {
+		case labelShow, labelWarn, labelHide:
+			lp[val] = a
+		default:
+			return nil, fmt.Errorf("invalid label policy action %q (expected show, warn or hide)", act)
+		}
+	}
+	return lp, nil
+}
+
+// labelDecision is how a post or profile should be rendered, available to
+// templates through moderate()
+type labelDecision struct {
+	Hide bool
+	Warn bool
+	// the labels which caused the decision, for the warning text
+	Labels []string
+}
+
+// decide combines the actions for a set of labels: the strictest wins.
+// Negation labels cancel out the same label from the same source.
+func (lp labelPolicy) decide(labels ...[]*comatproto.LabelDefs_Label) labelDecision {
+	active := make(map[string]bool)
+	for _, set := range labels {
+		for _, l := range set {
+			if l == nil {
+				continue
+			}
+			key := l.Src + " " + l.Val
+			if l.Neg != nil && *l.Neg {
+				delete(active, key)
+				continue
+			}
+			active[key] = true
+		}
+	}
+
+	var act labelAction = labelShow
+	vals := make(map[string]labelAction)
+	for key := range active {
+		val := key[strings.IndexByte(key, ' ')+1:]
+		a, ok := lp[val]
+		if !ok || a == labelShow {
+			continue
+		}
+		vals[val] = a
+		if a.rank() > act.rank() {
+			act = a
+		}
+	}
+
+	d := labelDecision{Hide: act == labelHide, Warn: act == labelWarn}
+	for val, a := range vals {
+		if a == act {
+			d.Labels = append(d.Labels, val)
+		}
+	}
+	sort.Strings(d.Labels)
+	return d
+}
+
+// moderateFunc is the "moderate" template function, for post views, profiles
+// and quoted posts: {% with mod=moderate(post) %}. Post decisions include the
+// labels on the author's account.
+func moderateFunc(lp labelPolicy) func(v *pongo2.Value) labelDecision {
+	return func(v *pongo2.Value) labelDecision {
+		switch x := v.Interface().(type) {
+		case *appbsky.FeedDefs_PostView:
+			if x == nil {
+				break
+			}
+			if x.Author != nil {
+				return lp.decide(x.Labels, x.Author.Labels)
+			}
+			return lp.decide(x.Labels)
+		case *appbsky.ActorDefs_ProfileViewDetailed:
+			if x != nil {
+				return lp.decide(x.Labels)
+			}
+		case *appbsky.ActorDefs_ProfileViewBasic:
+			if x != nil {
+				return lp.decide(x.Labels)
+			}
+		case *embedQuote:
+			if x == nil {
+				break
+			}
+			if x.Author != nil {
+				return lp.decide(x.Labels, x.Author.Labels)
+			}
+			return lp.decide(x.Labels)
+		}
+		return labelDecision{}
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	slogging "log/slog"
 	"os"
+	"time"
+
+	"github.com/bluesky-social/indigo/util/cliutil"
 
 	"github.com/carlmjohnson/versioninfo"
 	"github.com/urfave/cli/v2"
@@ -34,6 +37,7 @@ func run(args []string) error {
 		&cli.Command{
 			Name:   "serve",
 			Usage:  "run the server",
+			Before: cliutil.ApplyConfig("athome"),
 			Action: serve,
 			Flags: []cli.Flag{
 				&cli.StringFlag{
@@ -42,6 +46,28 @@ func run(args []string) error {
 					Value:   "https://api.bsky.app",
 					EnvVars: []string{"ATP_APPVIEW_HOST"},
 				},
+				&cli.DurationFlag{
+					Name:    "appview-cache-ttl",
+					Usage:   "how long to cache AppView responses for (zero to disable)",
+					Value:   30 * time.Second,
+					EnvVars: []string{"ATHOME_APPVIEW_CACHE_TTL"},
+				},
+				&cli.StringSliceFlag{
+					Name:    "appview-cache-method-ttl",
+					Usage:   "cache TTL for a specific AppView method, as nsid=duration (eg app.bsky.feed.getAuthorFeed=1m); zero disables caching it",
+					EnvVars: []string{"ATHOME_APPVIEW_CACHE_METHOD_TTL"},
+				},
+				&cli.DurationFlag{
+					Name:    "appview-cache-stale",
+					Usage:   "how long expired AppView responses are kept, to serve pages while the AppView is failing",
+					Value:   10 * time.Minute,
+					EnvVars: []string{"ATHOME_APPVIEW_CACHE_STALE"},
+				},
+				&cli.StringFlag{
+					Name:    "appview-cache-redis-url",
+					Usage:   "redis to cache AppView responses in, shared between instances (default: in memory)",
+					EnvVars: []string{"ATHOME_APPVIEW_CACHE_REDIS_URL"},
+				},
 				&cli.StringFlag{
 					Name:     "bind",
 					Usage:    "Specify the local IP/port to bind to",
@@ -49,6 +75,86 @@ func run(args []string) error {
 					Value:    ":8200",
 					EnvVars:  []string{"ATHOME_BIND"},
 				},
+				&cli.StringSliceFlag{
+					Name:    "comments-allowed-origins",
+					Usage:   "origins (eg, https://blog.example.com) of other sites which may embed the comments widget",
+					EnvVars: []string{"ATHOME_COMMENTS_ALLOWED_ORIGINS"},
+				},
+				&cli.StringFlag{
+					Name:    "domains-file",
+					Usage:   "JSON file mapping custom domains to the handles they serve (reloaded on SIGHUP)",
+					EnvVars: []string{"ATHOME_DOMAINS_FILE"},
+				},
+				&cli.StringFlag{
+					Name:    "image-cache-dir",
+					Usage:   "directory to cache resized images in; setting it serves images through athome, rather than from the CDN",
+					EnvVars: []string{"ATHOME_IMAGE_CACHE_DIR"},
+				},
+				&cli.Int64Flag{
+					Name:    "image-cache-max-mb",
+					Usage:   "size the image cache is kept under, removing the least recently used images; 0 for no limit",
+					Value:   1024,
+					EnvVars: []string{"ATHOME_IMAGE_CACHE_MAX_MB"},
+				},
+				&cli.StringFlag{
+					Name:    "image-cdn-host",
+					Usage:   "method and hostname of the image CDN the proxy fetches from",
+					Value:   "https://cdn.bsky.app",
+					EnvVars: []string{"ATHOME_IMAGE_CDN_HOST"},
+				},
+				&cli.StringSliceFlag{
+					Name:    "image-presets",
+					Usage:   "image proxy sizes, as name=WxH or name=WxH:quality (overriding or adding to avatar, banner, thumb and fullsize)",
+					EnvVars: []string{"ATHOME_IMAGE_PRESETS"},
+				},
+				&cli.StringSliceFlag{
+					Name:    "label-policy",
+					Usage:   "how to render content with a moderation label, as label=show|warn|hide (eg graphic-media=hide), overriding the defaults",
+					EnvVars: []string{"ATHOME_LABEL_POLICY"},
+				},
+				&cli.StringFlag{
+					Name:    "template-dir",
+					Usage:   "directory of templates overriding the built-in ones (any not in it are built-in)",
+					EnvVars: []string{"ATHOME_TEMPLATE_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "static-dir",
+					Usage:   "directory of static files overriding the built-in ones (any not in it are built-in)",
+					EnvVars: []string{"ATHOME_STATIC_DIR"},
+				},
+				&cli.StringFlag{
+					Name:    "activitypub-key",
+					Usage:   "RSA private key (PEM) for ActivityPub; enables read-only federation of the accounts served",
+					EnvVars: []string{"ATHOME_ACTIVITYPUB_KEY"},
+				},
+				&cli.StringFlag{
+					Name:    "default-lang",
+					Usage:   "language of the UI when a request's Accept-Language doesn't match any catalog",
+					Value:   "en",
+					EnvVars: []string{"ATHOME_DEFAULT_LANG"},
+				},
+				&cli.DurationFlag{
+					Name:    "page-cache-max-age",
+					Usage:   "how long CDNs and browsers may cache profile, post and RSS/Atom responses before revalidating them (zero to always revalidate)",
+					Value:   1 * time.Minute,
+					EnvVars: []string{"ATHOME_PAGE_CACHE_MAX_AGE"},
+				},
+				&cli.StringFlag{
+					Name:    "rate-limit",
+					Usage:   "requests each client IP can make to content routes, as count/window (eg 120/1m: 120 at once, refilling over a minute); unlimited if not set",
+					EnvVars: []string{"ATHOME_RATE_LIMIT"},
+				},
+				&cli.StringSliceFlag{
+					Name:    "trusted-proxies",
+					Usage:   "IPs or CIDR ranges of reverse proxies whose X-Forwarded-For header is used for client IPs",
+					EnvVars: []string{"ATHOME_TRUSTED_PROXIES"},
+				},
+				&cli.IntFlag{
+					Name:    "thread-depth",
+					Usage:   "levels of replies shown on post pages by default (pages can ask for up to 20 with ?depth=)",
+					Value:   6,
+					EnvVars: []string{"ATHOME_THREAD_DEPTH"},
+				},
 				&cli.BoolFlag{
 					Name:     "debug",
 					Usage:    "Enable debug mode",
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+)
+
+// longest description put in link preview tags; platforms truncate beyond
+// about this anyway
+const metaDescriptionLen = 200
+
+// pageMeta is what link previews (OpenGraph and Twitter Cards) show for a
+// page; it is rendered by base.html, and included in JSON responses.
+type pageMeta struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	// absolute image URL, if any
+	Image string `json:"image,omitempty"`
+	// show the image as a large card, rather than a thumbnail
+	LargeImage bool `json:"largeImage,omitempty"`
+	// canonical URL of the page
+	URL string `json:"url"`
+}
+
+// summarizeText reduces post (or profile) text to a single-paragraph
+// description of at most max characters, cutting at a word boundary.
+func summarizeText(text string, max int) string {
+	text = strings.Join(strings.FieldsFunc(text, unicode.IsSpace), " ")
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	cut := max - 1
+	for i := cut; i > max/2; i-- {
+		if runes[i] == ' ' {
+			cut = i
+			break
+		}
+	}
+	return strings.TrimRight(string(runes[:cut]), " .,;:") + "…"
+}
+
+func profileMeta(pv *appbsky.ActorDefs_ProfileViewDetailed, canonicalURL string) pageMeta {
+	m := pageMeta{
+		Title: "@" + pv.Handle,
+		URL:   canonicalURL,
+	}
+	if pv.DisplayName != nil && *pv.DisplayName != "" {
+		m.Title = *pv.DisplayName + " (@" + pv.Handle + ")"
+	}
+	if pv.Description != nil {
+		m.Description = summarizeText(*pv.Description, metaDescriptionLen)
+	}
+	switch {
+	case pv.Banner != nil:
+		m.Image = *pv.Banner
+		m.LargeImage = true
+	case pv.Avatar != nil:
+		m.Image = *pv.Avatar
+	}
+	return m
+}
+
+func postMeta(post *appbsky.FeedDefs_PostView, canonicalURL string) pageMeta {
+	m := pageMeta{
+		Title: "@" + post.Author.Handle,
+		URL:   canonicalURL,
+	}
+	if post.Author.DisplayName != nil && *post.Author.DisplayName != "" {
+		m.Title = *post.Author.DisplayName + " (@" + post.Author.Handle + ")"
+	}
+	if rec, ok := post.Record.Val.(*appbsky.FeedPost); ok {
+		m.Description = summarizeText(rec.Text, metaDescriptionLen)
+	}
+	if img := embedImage(post.Embed); img != "" {
+		m.Image = img
+		m.LargeImage = true
+	} else if post.Author.Avatar != nil {
+		m.Image = *post.Author.Avatar
+	}
+	return m
+}
+
+func listMeta(lv *appbsky.GraphDefs_ListView, canonicalURL string) pageMeta {
+	m := pageMeta{
+		Title: lv.Name,
+		URL:   canonicalURL,
+	}
+	if lv.Creator != nil {
+		m.Title = lv.Name + " (list by @" + lv.Creator.Handle + ")"
+	}
+	if lv.Description != nil {
+		m.Description = summarizeText(*lv.Description, metaDescriptionLen)
+	}
+	if lv.Avatar != nil {
+		m.Image = *lv.Avatar
+	}
+	return m
+}
+
+func feedMeta(gv *appbsky.FeedDefs_GeneratorView, canonicalURL string) pageMeta {
+	m := pageMeta{
+		Title: gv.DisplayName,
+		URL:   canonicalURL,
+	}
+	if gv.Creator != nil {
+		m.Title = gv.DisplayName + " (feed by @" + gv.Creator.Handle + ")"
+	}
+	if gv.Description != nil {
+		m.Description = summarizeText(*gv.Description, metaDescriptionLen)
+	}
+	if gv.Avatar != nil {
+		m.Image = *gv.Avatar
+	}
+	return m
+}
+
+// embedImage returns the first image of a post embed (including link card
+// thumbnails), or an empty string
+func embedImage(embed *appbsky.FeedDefs_PostView_Embed) string {
+	if embed == nil {
+		return ""
+	}
+	images, external := embed.EmbedImages_View, embed.EmbedExternal_View
+	if rwm := embed.EmbedRecordWithMedia_View; rwm != nil && rwm.Media != nil {
+		images, external = rwm.Media.EmbedImages_View, rwm.Media.EmbedExternal_View
+	}
+	if images != nil && len(images.Images) > 0 {
+		return images.Images[0].Fullsize
+	}
+	if external != nil && external.External != nil && external.External.Thumb != nil {
+		return *external.External.Thumb
+	}
+	return ""
+}
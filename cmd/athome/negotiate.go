@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+// renderPage renders a page template, or returns the template's data as JSON
+// if the request asked for that, so other frontends can be built on athome
+// without scraping HTML
+func (srv *Server) renderPage(c echo.Context, code int, name string, data pongo2.Context) error {
+	c.Response().Header().Add("Vary", "Accept")
+	if wantsJSON(c) {
+		return c.JSON(code, data)
+	}
+	return c.Render(code, name, data)
+}
+
+// wantsJSON is whether the request has ?format=json, or an Accept header which
+// prefers application/json to HTML
+func wantsJSON(c echo.Context) bool {
+	switch c.QueryParam("format") {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+	accept := c.Request().Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	jsonQ, htmlQ := acceptQuality(accept, "application/json"), acceptQuality(accept, "text/html")
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// acceptQuality is the q-value an Accept header gives a media type; only exact
+// matches and */* count, so browsers (which send text/html and */*) get HTML
+func acceptQuality(accept, mediaType string) float64 {
+	best := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		if mt != mediaType && mt != "*/*" {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && k == "q" {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		// an exact match takes precedence over the wildcard
+		if mt == mediaType {
+			return q
+		}
+		best = max(best, q)
+	}
+	return best
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	oembedDefaultWidth = 550
+	oembedMinWidth     = 220
+)
+
+// https://oembed.com/#section2.3
+type oembedResponse struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Title        string `json:"title,omitempty"`
+	AuthorName   string `json:"author_name"`
+	AuthorURL    string `json:"author_url"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	CacheAge     int    `json:"cache_age"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	// the height depends on the text, so isn't known up front
+	Height *int `json:"height"`
+}
+
+// oembedLink is the discovery URL for a post page. It is under /bsky, so it
+// also works when only /bsky is proxied to athome.
+func oembedLink(baseURL, postURL string) string {
+	return baseURL + "/bsky/oembed?format=json&url=" + url.QueryEscape(postURL)
+}
+
+// WebOEmbed returns oEmbed JSON for one of the account's posts, given as any
+// URL parsePostURL accepts. As with comments, posts by other accounts are not
+// served.
+func (srv *Server) WebOEmbed(c echo.Context) error {
+	ctx := c.Request().Context()
+	req := c.Request()
+	handle := srv.reqHandle(c)
+
+	if f := c.QueryParam("format"); f != "" && f != "json" {
+		return echo.NewHTTPError(http.StatusNotImplemented, "only the json format is supported")
+	}
+	raw := c.QueryParam("url")
+	if raw == "" {
+		return echo.NewHTTPError(400, "missing 'url' parameter")
+	}
+	atid, rkey, err := parsePostURL(raw)
+	if err != nil {
+		return echo.NewHTTPError(404, fmt.Sprintf("invalid post URL: %s", err))
+	}
+
+	width := oembedDefaultWidth
+	if mw := c.QueryParam("maxwidth"); mw != "" {
+		n, err := strconv.Atoi(mw)
+		if err != nil || n < oembedMinWidth {
+			return echo.NewHTTPError(400, fmt.Sprintf("'maxwidth' must be at least %d", oembedMinWidth))
+		}
+		width = min(n, width)
+	}
+
+	self, err := srv.dir.LookupHandle(ctx, handle)
+	if err != nil {
+		slog.Warn("failed to resolve handle", "handle", handle, "err", err)
+		return echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
+	}
+	author, err := srv.dir.Lookup(ctx, atid)
+	if err != nil || author.DID != self.DID {
+		return echo.NewHTTPError(404, "post not found")
+	}
+
+	aturi := fmt.Sprintf("at://%s/app.bsky.feed.post/%s", self.DID, rkey)
+	out, err := appbsky.FeedGetPosts(ctx, srv.xrpcc, []string{aturi})
+	if err != nil {
+		slog.Warn("failed to fetch post", "aturi", aturi, "err", err)
+		return echo.NewHTTPError(404, "post not found")
+	}
+	if len(out.Posts) == 0 {
+		return echo.NewHTTPError(404, "post not found")
+	}
+	post := out.Posts[0]
+	rec, ok := post.Record.Val.(*appbsky.FeedPost)
+	if !ok {
+		return echo.NewHTTPError(404, "post not found")
+	}
+
+	// the snippet is shown on other sites, so links back here must be absolute
+	baseURL := fmt.Sprintf("%s://%s", c.Scheme(), req.Host)
+	data := pongo2.Context{
+		"post":    post,
+		"lines":   strings.Split(rec.Text, "\n"),
+		"postURL": fmt.Sprintf("%s/bsky/post/%s", baseURL, rkey),
+		"baseURL": baseURL,
+		"width":   width,
+	}
+	if post.Embed != nil && post.Embed.EmbedImages_View != nil {
+		data["images"] = post.Embed.EmbedImages_View.Images
+	}
+	var buf bytes.Buffer
+	if err := c.Echo().Renderer.Render(&buf, "oembed.html", data, c); err != nil {
+		return err
+	}
+
+	resp := oembedResponse{
+		Version:      "1.0",
+		Type:         "rich",
+		AuthorName:   "@" + post.Author.Handle,
+		AuthorURL:    baseURL + "/bsky",
+		ProviderName: "Bluesky",
+		ProviderURL:  baseURL + "/bsky",
+		CacheAge:     3600,
+		HTML:         strings.TrimSpace(buf.String()),
+		Width:        width,
+	}
+	if post.Author.DisplayName != nil && *post.Author.DisplayName != "" {
+		resp.AuthorName = *post.Author.DisplayName + " (@" + post.Author.Handle + ")"
+	}
+	if t := summarizeText(rec.Text, 100); t != "" {
+		resp.Title = t
+	}
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, resp)
+}
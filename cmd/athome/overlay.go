@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// overlayFS serves files from a directory on disk, falling back to the
+// embedded files for anything the directory doesn't have. This lets operators
+// theme a site by overriding only some templates or static files.
+type overlayFS struct {
+	upper fs.FS
+	lower fs.FS
+}
+
+// newOverlayFS overlays dir on the sub directory of an embedded FS. An empty
+// dir serves the embedded files alone. If required is false, a missing dir is
+// treated the same as an empty one.
+func newOverlayFS(dir string, embedded fs.FS, sub string, required bool) (fs.FS, error) {
+	lower, err := fs.Sub(embedded, sub)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return lower, nil
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		if !required && errors.Is(err, fs.ErrNotExist) {
+			return lower, nil
+		}
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &overlayFS{upper: os.DirFS(dir), lower: lower}, nil
+}
+
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	return o.lower.Open(name)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bluesky-social/indigo/util/ratelimit"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+// clients tracked for rate limiting; the least recently seen are forgotten
+// (and so start again with a full bucket)
+const maxRateLimitClients = 100_000
+
+// rateLimiter is a token bucket per client IP, for content routes
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	lk      sync.Mutex
+	clients *lru.Cache[string, *rate.Limiter]
+}
+
+// newRateLimiter parses a budget written as "<requests>/<window>", eg
+// "120/1m": clients can make that many requests at once, and the bucket
+// refills evenly over the window
+func newRateLimiter(s string) (*rateLimiter, error) {
+	burst, window, err := ratelimit.ParseBudget(s)
+	if err != nil {
+		return nil, err
+	}
+	clients, err := lru.New[string, *rate.Limiter](maxRateLimitClients)
+	if err != nil {
+		return nil, err
+	}
+	return &rateLimiter{
+		limit:   rate.Limit(float64(burst) / window.Seconds()),
+		burst:   burst,
+		clients: clients,
+	}, nil
+}
+
+func (rl *rateLimiter) client(key string) *rate.Limiter {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	lim, ok := rl.clients.Get(key)
+	if !ok {
+		lim = rate.NewLimiter(rl.limit, rl.burst)
+		rl.clients.Add(key, lim)
+	}
+	return lim
+}
+
+// rateLimitKey is the client an IP belongs to. IPv6 clients usually have a
+// whole /64, so are counted by that.
+func rateLimitKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is6() {
+		if p, err := addr.Prefix(64); err == nil {
+			return p.String()
+		}
+	}
+	return addr.String()
+}
+
+// rateLimited is whether a route is limited by the middleware: static files,
+// health checks and metrics aren't, and nor are images (which a page has many
+// of), whose proxy only limits the requests it has to fetch and resize
+func rateLimited(path string) bool {
+	for _, p := range []string{"/static/", "/img/", "/bsky/img/"} {
+		if strings.HasPrefix(path, p) {
+			return false
+		}
+	}
+	switch path {
+	case "/_health", "/metrics", "/robots.txt", "/favicon.ico":
+		return false
+	}
+	return true
+}
+
+// middleware responds 429 Too Many Requests, with Retry-After, to clients
+// which have used up their budget
+func (rl *rateLimiter) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !rateLimited(c.Path()) {
+			return next(c)
+		}
+		if err := rl.allow(c); err != nil {
+			return err
+		}
+		return next(c)
+	}
+}
+
+// allow counts a request against its client's budget, returning a 429 error
+// if it's used up. A nil rateLimiter allows everything.
+func (rl *rateLimiter) allow(c echo.Context) error {
+	if rl == nil {
+		return nil
+	}
+	r := rl.client(rateLimitKey(c.RealIP())).Reserve()
+	if delay := r.Delay(); delay > 0 {
+		// the request isn't served, so doesn't use up a token
+		r.Cancel()
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+		return echo.NewHTTPError(429, "too many requests")
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	assert := assert.New(t)
+
+	fixtures := []struct {
+		ip  string
+		key string
+	}{
+		{"203.0.113.5", "203.0.113.5"},
+		{"::ffff:203.0.113.5", "203.0.113.5"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2::ffff", "2001:db8:1:2::/64"},
+		{"2001:db8:1:3::1", "2001:db8:1:3::/64"},
+		{"not an ip", "not an ip"},
+		{"", ""},
+	}
+	for _, f := range fixtures {
+		assert.Equal(f.key, rateLimitKey(f.ip), f.ip)
+	}
+}
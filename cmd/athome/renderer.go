@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"path/filepath"
 
 	"github.com/flosch/pongo2/v6"
@@ -17,13 +18,13 @@ var TemplateFS embed.FS
 
 type RendererLoader struct {
 	prefix string
-	fs     *embed.FS
+	fs     fs.FS
 }
 
-func NewRendererLoader(prefix string, fs *embed.FS) pongo2.TemplateLoader {
+func NewRendererLoader(prefix string, fsys fs.FS) pongo2.TemplateLoader {
 	return &RendererLoader{
 		prefix: prefix,
-		fs:     fs,
+		fs:     fsys,
 	}
 }
 func (l *RendererLoader) Abs(_, name string) string {
@@ -38,7 +39,7 @@ func (l *RendererLoader) Abs(_, name string) string {
 }
 
 func (l *RendererLoader) Get(path string) (io.Reader, error) {
-	b, err := l.fs.ReadFile(path)
+	b, err := fs.ReadFile(l.fs, path)
 	if err != nil {
 		return nil, fmt.Errorf("reading template %q failed: %w", path, err)
 	}
@@ -48,11 +49,19 @@ func (l *RendererLoader) Get(path string) (io.Reader, error) {
 type Renderer struct {
 	TemplateSet *pongo2.TemplateSet
 	Debug       bool
+	// rewrites image URLs in templates; nil leaves them pointing upstream
+	Images *imageProxy
+	// what templates do with labeled content
+	Labels labelPolicy
 }
 
-func NewRenderer(prefix string, fs *embed.FS, debug bool) *Renderer {
+// NewRenderer loads templates from fsys. In debug mode templates aren't
+// cached, so edits show up on the next request.
+func NewRenderer(prefix string, fsys fs.FS, debug bool) *Renderer {
+	set := pongo2.NewSet(prefix, NewRendererLoader(prefix, fsys))
+	set.Debug = debug
 	return &Renderer{
-		TemplateSet: pongo2.NewSet(prefix, NewRendererLoader(prefix, fs)),
+		TemplateSet: set,
 		Debug:       debug,
 	}
 }
@@ -67,16 +76,22 @@ func (r Renderer) Render(w io.Writer, name string, data interface{}, c echo.Cont
 			return errors.New("no pongo2.Context data was passed")
 		}
 	}
-
-	var t *pongo2.Template
-	var err error
-
-	if r.Debug {
-		t, err = pongo2.FromFile(name)
-	} else {
-		t, err = r.TemplateSet.FromFile(name)
+	if ctx == nil {
+		ctx = pongo2.Context{}
+	}
+	if s, ok := c.Get("site").(site); ok {
+		ctx["site"] = s
+	}
+	ctx["img"] = imageURLFunc(r.Images, c)
+	ctx["moderate"] = moderateFunc(r.Labels)
+	cat, _ := c.Get("i18n").(*catalog)
+	ctx["i18n"] = cat
+	ctx["lang"] = "en"
+	if cat != nil {
+		ctx["lang"] = cat.Lang.String()
 	}
 
+	t, err := r.TemplateSet.FromFile(name)
 	if err != nil {
 		return err
 	}
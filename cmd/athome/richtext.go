@@ -0,0 +1,157 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+func init() {
+	if err := pongo2.RegisterFilter("richtext", filterRichText); err != nil {
+		panic(err)
+	}
+}
+
+// filterRichText renders a post record's text with its facets as HTML:
+// {{ post.Record.Val|richtext:selfDID }}. Mentions of selfDID link to the
+// account's own athome profile; pass an empty string where links must leave
+// the site (eg, the comments widget).
+func filterRichText(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	post, ok := in.Interface().(*appbsky.FeedPost)
+	if !ok || post == nil {
+		return pongo2.AsValue(in.String()), nil
+	}
+	return pongo2.AsSafeValue(renderRichText(post.Text, post.Facets, param.String())), nil
+}
+
+// renderRichText escapes text and turns its facets into links, and line
+// breaks into <br>.
+//
+// Facet indices are UTF-8 byte offsets into the text (not UTF-16 code units,
+// which is what JavaScript string indices are, nor runes). Facets which are
+// out of range, don't fall on character boundaries, overlap an earlier facet,
+// or have no feature we know how to link are skipped, leaving their text as
+// plain text.
+func renderRichText(text string, facets []*appbsky.RichtextFacet, selfDID string) string {
+	return highlightRichText(text, facets, selfDID, nil)
+}
+
+// highlightRichText is renderRichText, also wrapping (case-insensitive)
+// matches of any of terms in <mark>, for search results
+func highlightRichText(text string, facets []*appbsky.RichtextFacet, selfDID string, terms []string) string {
+	type span struct {
+		start, end int
+		href       string
+	}
+	var spans []span
+	for _, f := range facets {
+		if f == nil || f.Index == nil {
+			continue
+		}
+		start, end := int(f.Index.ByteStart), int(f.Index.ByteEnd)
+		if start < 0 || end > len(text) || start >= end {
+			continue
+		}
+		if !utf8.RuneStart(text[start]) || (end < len(text) && !utf8.RuneStart(text[end])) {
+			continue
+		}
+		if href := facetHref(f, selfDID); href != "" {
+			spans = append(spans, span{start: start, end: end, href: href})
+		}
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	pos := 0
+	for _, s := range spans {
+		if s.start < pos {
+			// overlaps the previous facet
+			continue
+		}
+		writeText(&b, text[pos:s.start], terms)
+		b.WriteString(`<a href="`)
+		b.WriteString(html.EscapeString(s.href))
+		b.WriteString(`" rel="nofollow ugc noopener">`)
+		writeText(&b, text[s.start:s.end], terms)
+		b.WriteString("</a>")
+		pos = s.end
+	}
+	writeText(&b, text[pos:], terms)
+	return b.String()
+}
+
+// facetHref is where a facet links to, from its first feature which can be
+// linked, or "" if none can
+func facetHref(f *appbsky.RichtextFacet, selfDID string) string {
+	for _, feat := range f.Features {
+		switch {
+		case feat == nil:
+		case feat.RichtextFacet_Mention != nil:
+			did := feat.RichtextFacet_Mention.Did
+			if !strings.HasPrefix(did, "did:") {
+				continue
+			}
+			if selfDID != "" && did == selfDID {
+				return "/bsky"
+			}
+			return "https://bsky.app/profile/" + url.PathEscape(did)
+		case feat.RichtextFacet_Link != nil:
+			// only web links; no javascript: and the like
+			u, err := url.Parse(feat.RichtextFacet_Link.Uri)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				continue
+			}
+			return u.String()
+		case feat.RichtextFacet_Tag != nil:
+			tag := strings.TrimPrefix(feat.RichtextFacet_Tag.Tag, "#")
+			if tag == "" {
+				continue
+			}
+			return "https://bsky.app/hashtag/" + url.PathEscape(tag)
+		}
+	}
+	return ""
+}
+
+func writeText(b *strings.Builder, s string, terms []string) {
+	s = strings.ToValidUTF8(s, "�")
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			b.WriteString("<br>")
+		}
+		writeHighlighted(b, line, terms)
+	}
+}
+
+// writeHighlighted escapes s, with matches of terms in <mark>. Matching is by
+// simple case folding, so only finds matches the same length in bytes as the
+// term, which is all of them outside a few scripts.
+func writeHighlighted(b *strings.Builder, s string, terms []string) {
+	pos := 0
+	for i := 0; i < len(s); {
+		n := 0
+		for _, t := range terms {
+			if len(t) > n && i+len(t) <= len(s) && strings.EqualFold(s[i:i+len(t)], t) {
+				n = len(t)
+			}
+		}
+		if n == 0 {
+			_, size := utf8.DecodeRuneInString(s[i:])
+			i += size
+			continue
+		}
+		b.WriteString(html.EscapeString(s[pos:i]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(s[i : i+n]))
+		b.WriteString("</mark>")
+		i += n
+		pos = i
+	}
+	b.WriteString(html.EscapeString(s[pos:]))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"testing"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func facet(start, end int64, feat *appbsky.RichtextFacet_Features_Elem) *appbsky.RichtextFacet {
+	return &appbsky.RichtextFacet{
+		Index:    &appbsky.RichtextFacet_ByteSlice{ByteStart: start, ByteEnd: end},
+		Features: []*appbsky.RichtextFacet_Features_Elem{feat},
+	}
+}
+
+func linkFacet(start, end int64, uri string) *appbsky.RichtextFacet {
+	return facet(start, end, &appbsky.RichtextFacet_Features_Elem{RichtextFacet_Link: &appbsky.RichtextFacet_Link{Uri: uri}})
+}
+
+func mentionFacet(start, end int64, did string) *appbsky.RichtextFacet {
+	return facet(start, end, &appbsky.RichtextFacet_Features_Elem{RichtextFacet_Mention: &appbsky.RichtextFacet_Mention{Did: did}})
+}
+
+func tagFacet(start, end int64, tag string) *appbsky.RichtextFacet {
+	return facet(start, end, &appbsky.RichtextFacet_Features_Elem{RichtextFacet_Tag: &appbsky.RichtextFacet_Tag{Tag: tag}})
+}
+
+func TestHighlightRichText(t *testing.T) {
+	assert := assert.New(t)
+
+	const a = `" rel="nofollow ugc noopener">`
+	fixtures := []struct {
+		name   string
+		text   string
+		facets []*appbsky.RichtextFacet
+		terms  []string
+		out    string
+	}{
+		{
+			name: "escaped",
+			text: `<b>"hi" & bye</b>`,
+			out:  `&lt;b&gt;&#34;hi&#34; &amp; bye&lt;/b&gt;`,
+		},
+		{
+			name: "line breaks",
+			text: "one\ntwo",
+			out:  "one<br>two",
+		},
+		{
+			name:   "byte offsets after multibyte text",
+			text:   "héllo 👋 example.com",
+			facets: []*appbsky.RichtextFacet{linkFacet(12, 23, "https://example.com")},
+			out:    `héllo 👋 <a href="https://example.com` + a + `example.com</a>`,
+		},
+		{
+			name:   "not on a rune boundary",
+			text:   "👋 hi",
+			facets: []*appbsky.RichtextFacet{linkFacet(1, 4, "https://example.com")},
+			out:    "👋 hi",
+		},
+		{
+			name:   "ends inside a rune",
+			text:   "hi 👋",
+			facets: []*appbsky.RichtextFacet{linkFacet(0, 5, "https://example.com")},
+			out:    "hi 👋",
+		},
+		{
+			name:   "past the end",
+			text:   "hi",
+			facets: []*appbsky.RichtextFacet{linkFacet(0, 10, "https://example.com")},
+			out:    "hi",
+		},
+		{
+			name:   "negative start",
+			text:   "hi",
+			facets: []*appbsky.RichtextFacet{linkFacet(-1, 2, "https://example.com")},
+			out:    "hi",
+		},
+		{
+			name:   "empty",
+			text:   "hi",
+			facets: []*appbsky.RichtextFacet{linkFacet(1, 1, "https://example.com")},
+			out:    "hi",
+		},
+		{
+			name: "overlapping",
+			text: "@alice.test @bob.test",
+			facets: []*appbsky.RichtextFacet{
+				mentionFacet(0, 11, "did:plc:alice"),
+				mentionFacet(6, 21, "did:plc:bob"),
+			},
+			out: `<a href="https://bsky.app/profile/did:plc:alice` + a + `@alice.test</a> @bob.test`,
+		},
+		{
+			name: "out of order",
+			text: "@alice.test @bob.test",
+			facets: []*appbsky.RichtextFacet{
+				mentionFacet(12, 21, "did:plc:bob"),
+				mentionFacet(0, 11, "did:plc:alice"),
+			},
+			out: `<a href="https://bsky.app/profile/did:plc:alice` + a + `@alice.test</a> <a href="https://bsky.app/profile/did:plc:bob` + a + `@bob.test</a>`,
+		},
+		{
+			name:   "javascript link",
+			text:   "click me",
+			facets: []*appbsky.RichtextFacet{linkFacet(0, 8, "javascript:alert(1)")},
+			out:    "click me",
+		},
+		{
+			name:   "link without host",
+			text:   "click me",
+			facets: []*appbsky.RichtextFacet{linkFacet(0, 8, "https:/click")},
+			out:    "click me",
+		},
+		{
+			name:   "link escaped",
+			text:   "click me",
+			facets: []*appbsky.RichtextFacet{linkFacet(0, 8, `https://example.com/?q="x"&y=1`)},
+			out:    `<a href="https://example.com/?q=&#34;x&#34;&amp;y=1` + a + `click me</a>`,
+		},
+		{
+			name:   "mention of self",
+			text:   "@alice.test",
+			facets: []*appbsky.RichtextFacet{mentionFacet(0, 11, "did:plc:self")},
+			out:    `<a href="/bsky` + a + `@alice.test</a>`,
+		},
+		{
+			name:   "mention without a DID",
+			text:   "@alice.test",
+			facets: []*appbsky.RichtextFacet{mentionFacet(0, 11, "alice.test")},
+			out:    "@alice.test",
+		},
+		{
+			name:   "tag",
+			text:   "#日本",
+			facets: []*appbsky.RichtextFacet{tagFacet(0, 7, "日本")},
+			out:    `<a href="https://bsky.app/hashtag/%E6%97%A5%E6%9C%AC` + a + `#日本</a>`,
+		},
+		{
+			name:   "no known feature",
+			text:   "hi",
+			facets: []*appbsky.RichtextFacet{facet(0, 2, &appbsky.RichtextFacet_Features_Elem{}), {Index: nil}, nil},
+			out:    "hi",
+		},
+		{
+			name:  "highlighted",
+			text:  "Hello World & <world>",
+			terms: []string{"world"},
+			out:   "Hello <mark>World</mark> &amp; &lt;<mark>world</mark>&gt;",
+		},
+		{
+			name:   "highlighted in a link",
+			text:   "see example.com",
+			facets: []*appbsky.RichtextFacet{linkFacet(4, 15, "https://example.com")},
+			terms:  []string{"EXAMPLE"},
+			out:    `see <a href="https://example.com` + a + `<mark>example</mark>.com</a>`,
+		},
+	}
+
+	for _, f := range fixtures {
+		assert.Equal(f.out, highlightRichText(f.text, f.facets, "did:plc:self", f.terms), f.name)
+	}
+}
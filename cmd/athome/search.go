@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+
+	"github.com/flosch/pongo2/v6"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// longest search query accepted
+	maxSearchQueryLen = 300
+	// shortest term highlighted in results; single letters would light up
+	// most of every post
+	minHighlightLen = 2
+)
+
+func init() {
+	if err := pongo2.RegisterFilter("highlight", filterHighlight); err != nil {
+		panic(err)
+	}
+}
+
+// searchHighlight is the terms to highlight in search results, and the
+// account whose mentions link to its athome profile
+type searchHighlight struct {
+	SelfDID string
+	Terms   []string
+}
+
+// filterHighlight is the richtext filter, also highlighting search terms:
+// {{ post.Record.Val|highlight:highlight }}
+func filterHighlight(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+	post, ok := in.Interface().(*appbsky.FeedPost)
+	if !ok || post == nil {
+		return pongo2.AsValue(in.String()), nil
+	}
+	var hl searchHighlight
+	if h, ok := param.Interface().(*searchHighlight); ok && h != nil {
+		hl = *h
+	}
+	return pongo2.AsSafeValue(highlightRichText(post.Text, post.Facets, hl.SelfDID, hl.Terms)), nil
+}
+
+// searchQuery splits a query into the query sent to the AppView, and the
+// terms to highlight. Only the account's own posts are searched, so any
+// from: in the query is dropped (the AppView would search another account's
+// posts). Other operators (eg, since: or lang:) are passed through, but not
+// highlighted.
+func searchQuery(q string) (string, []string) {
+	var keep, terms []string
+	for _, tok := range strings.Fields(q) {
+		op, _, isOp := strings.Cut(tok, ":")
+		if isOp && strings.EqualFold(op, "from") {
+			continue
+		}
+		keep = append(keep, tok)
+		if isOp && !strings.HasPrefix(tok, `"`) {
+			continue
+		}
+		t := strings.Trim(tok, `"'()`)
+		if utf8.RuneCountInString(t) < minHighlightLen || strings.HasPrefix(t, "-") {
+			continue
+		}
+		dup := false
+		for _, seen := range terms {
+			dup = dup || strings.EqualFold(seen, t)
+		}
+		if !dup {
+			terms = append(terms, t)
+		}
+	}
+	return strings.Join(keep, " "), terms
+}
+
+// WebSearch searches the account's posts: /bsky/search?q=, paginated like the
+// profile
+func (srv *Server) WebSearch(c echo.Context) error {
+	ctx := c.Request().Context()
+	data := pongo2.Context{}
+	handle := srv.reqHandle(c)
+
+	q := strings.TrimSpace(c.QueryParam("q"))
+	if len(q) > maxSearchQueryLen {
+		return echo.NewHTTPError(400, "search query too long")
+	}
+	pg, err := parseFeedPage(c)
+	if err != nil {
+		return err
+	}
+
+	pv, err := appbsky.ActorGetProfile(ctx, srv.xrpcc, handle.String())
+	if err != nil {
+		slog.Warn("failed to fetch handle", "handle", handle, "err", err)
+		// TODO: only if "not found"
+		return echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
+	}
+	data["did"] = pv.Did
+	data["profileView"] = pv
+	data["q"] = q
+	data["meta"] = pageMeta{Title: "Search posts by @" + pv.Handle, URL: srv.siteURL(c) + "/bsky/search"}
+
+	query, terms := searchQuery(q)
+	if query == "" {
+		return srv.renderPage(c, http.StatusOK, "search.html", data)
+	}
+	data["highlight"] = &searchHighlight{SelfDID: pv.Did, Terms: terms}
+	pg.extra = url.Values{"q": {q}}
+
+	out, err := appbsky.FeedSearchPosts(ctx, srv.xrpcc, pg.cursor, pg.limit, query+" from:"+pv.Did)
+	if err != nil {
+		slog.Warn("failed to search posts", "did", pv.Did, "q", q, "err", err)
+		data["searchFailed"] = true
+		return srv.renderPage(c, http.StatusOK, "search.html", data)
+	}
+	// feed_post renders feed items
+	results := make([]*appbsky.FeedDefs_FeedViewPost, 0, len(out.Posts))
+	for _, p := range out.Posts {
+		if p != nil {
+			results = append(results, &appbsky.FeedDefs_FeedViewPost{Post: p})
+		}
+	}
+	data["results"] = results
+	data["hitsTotal"] = out.HitsTotal
+	if out.Cursor != nil && *out.Cursor != "" && len(out.Posts) > 0 {
+		data["nextURL"] = pg.nextURL(*out.Cursor)
+	}
+	if pg.cursor != "" {
+		data["prevURL"] = pg.prevURL()
+		data["firstURL"] = pg.firstURL()
+	}
+	return srv.renderPage(c, http.StatusOK, "search.html", data)
+}
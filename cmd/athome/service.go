@@ -4,10 +4,11 @@ import (
 	"context"
 	"embed"
 	"errors"
-	"io/fs"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,9 +18,11 @@ import (
 	"github.com/bluesky-social/indigo/xrpc"
 
 	"github.com/flosch/pongo2/v6"
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/labstack/echo-contrib/echoprometheus"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/redis/go-redis/v9"
 	slogecho "github.com/samber/slog-echo"
 	"github.com/urfave/cli/v2"
 )
@@ -33,12 +36,27 @@ type Server struct {
 	dir           identity.Directory // TODO: unused?
 	xrpcc         *xrpc.Client
 	defaultHandle syntax.Handle
+	// levels of replies shown on post pages, unless the request asks otherwise
+	threadDepth int
+	// how long CDNs and browsers may cache profile, post and feed responses
+	// before revalidating them
+	pageMaxAge time.Duration
+	// custom domains; nil if not configured
+	domains *domainMap
+	// nil if image proxying isn't configured
+	images *imageProxy
+	labels labelPolicy
+	// nil if ActivityPub isn't configured
+	ap *activityPub
+	// where each page of each account's sitemap starts listing posts
+	sitemapCursors *lru.Cache[string, string]
 }
 
 func serve(cctx *cli.Context) error {
 	debug := cctx.Bool("debug")
 	httpAddress := cctx.String("bind")
 	appviewHost := cctx.String("appview-host")
+	commentsOrigins := cctx.StringSlice("comments-allowed-origins")
 
 	dh, err := syntax.ParseHandle("atproto.com")
 	if err != nil {
@@ -50,6 +68,24 @@ func serve(cctx *cli.Context) error {
 		Host:   appviewHost,
 		// Headers: version
 	}
+	if ttl := cctx.Duration("appview-cache-ttl"); ttl > 0 {
+		// every page fetches the profile, and popular pages get reloaded a lot
+		cfg := xrpc.DefaultCacheConfig()
+		cfg.TTL = ttl
+		cfg.StaleTTL = cctx.Duration("appview-cache-stale")
+		cfg.MethodTTL, err = appviewMethodTTLs(ttl, cctx.StringSlice("appview-cache-method-ttl"))
+		if err != nil {
+			return err
+		}
+		if rurl := cctx.String("appview-cache-redis-url"); rurl != "" {
+			opts, err := redis.ParseURL(rurl)
+			if err != nil {
+				return fmt.Errorf("parsing redis url: %w", err)
+			}
+			cfg.Store = xrpc.NewRedisCacheStore(redis.NewClient(opts), "athome:appview:")
+		}
+		xrpcc.Cache = xrpc.NewResponseCache(cfg)
+	}
 	e := echo.New()
 
 	// httpd
@@ -63,6 +99,61 @@ func serve(cctx *cli.Context) error {
 		xrpcc:         xrpcc,
 		dir:           identity.DefaultDirectory(),
 		defaultHandle: dh,
+		threadDepth:   min(cctx.Int("thread-depth"), maxThreadDepth),
+		pageMaxAge:    cctx.Duration("page-cache-max-age"),
+	}
+	if path := cctx.String("domains-file"); path != "" {
+		dm, err := loadDomainMap(path)
+		if err != nil {
+			return err
+		}
+		srv.domains = dm
+	}
+	srv.sitemapCursors, err = lru.New[string, string](10_000)
+	if err != nil {
+		return err
+	}
+	srv.labels, err = parseLabelPolicy(cctx.StringSlice("label-policy"))
+	if err != nil {
+		return err
+	}
+	if path := cctx.String("activitypub-key"); path != "" {
+		srv.ap, err = newActivityPub(path)
+		if err != nil {
+			return err
+		}
+	}
+	loc, err := loadLocalizer(cctx.String("default-lang"))
+	if err != nil {
+		return err
+	}
+
+	// in debug mode, templates and static files are read from the source
+	// tree (if run from it) by default, so edits show up without a rebuild
+	templateDir, staticDir := cctx.String("template-dir"), cctx.String("static-dir")
+	if debug && templateDir == "" {
+		templateDir = "templates"
+	}
+	if debug && staticDir == "" {
+		staticDir = "static"
+	}
+	templates, err := newOverlayFS(templateDir, TemplateFS, "templates", cctx.IsSet("template-dir"))
+	if err != nil {
+		return fmt.Errorf("template dir: %w", err)
+	}
+	statics, err := newOverlayFS(staticDir, StaticFS, "static", cctx.IsSet("static-dir"))
+	if err != nil {
+		return fmt.Errorf("static dir: %w", err)
+	}
+	if dir := cctx.String("image-cache-dir"); dir != "" {
+		presets, err := parseImagePresets(cctx.StringSlice("image-presets"))
+		if err != nil {
+			return err
+		}
+		srv.images, err = newImageProxy(cctx.String("image-cdn-host"), dir, presets, srv.dir, cctx.Int64("image-cache-max-mb")*1024*1024)
+		if err != nil {
+			return err
+		}
 	}
 	srv.httpd = &http.Server{
 		Handler:        srv,
@@ -78,7 +169,28 @@ func serve(cctx *cli.Context) error {
 	e.Use(echoprometheus.NewMiddleware("athome"))
 	e.Use(middleware.BodyLimit("64M"))
 	e.HTTPErrorHandler = srv.errorHandler
-	e.Renderer = NewRenderer("templates/", &TemplateFS, debug)
+	renderer := NewRenderer("", templates, debug)
+	renderer.Images = srv.images
+	renderer.Labels = srv.labels
+	e.Renderer = renderer
+	e.Use(srv.siteMiddleware)
+	e.Use(loc.localeMiddleware)
+	if limit := cctx.String("rate-limit"); limit != "" || cctx.IsSet("trusted-proxies") {
+		e.IPExtractor, err = util.TrustedProxyIPExtractor(cctx.StringSlice("trusted-proxies"))
+		if err != nil {
+			return err
+		}
+		if limit != "" {
+			rl, err := newRateLimiter(limit)
+			if err != nil {
+				return err
+			}
+			e.Use(rl.middleware)
+			if srv.images != nil {
+				srv.images.limiter = rl
+			}
+		}
+	}
 	e.Use(middleware.SecureWithConfig(middleware.SecureConfig{
 		ContentTypeNosniff: "nosniff",
 		XFrameOptions:      "SAMEORIGIN",
@@ -94,17 +206,7 @@ func serve(cctx *cli.Context) error {
 		RedirectCode: http.StatusFound,
 	}))
 
-	staticHandler := http.FileServer(func() http.FileSystem {
-		if debug {
-			return http.FS(os.DirFS("static"))
-		}
-		fsys, err := fs.Sub(StaticFS, "static")
-		if err != nil {
-			slog.Error("static template error", "err", err)
-			os.Exit(-1)
-		}
-		return http.FS(fsys)
-	}())
+	staticHandler := http.FileServer(http.FS(statics))
 
 	e.GET("/static/*", echo.WrapHandler(http.StripPrefix("/static/", staticHandler)))
 	e.GET("/_health", srv.HandleHealthCheck)
@@ -118,8 +220,50 @@ func serve(cctx *cli.Context) error {
 	e.GET("/", srv.WebHome)
 	e.GET("/bsky", srv.WebProfile)
 	e.GET("/bsky/post/:rkey", srv.WebPost)
+	e.GET("/bsky/post/:rkey/likes", srv.WebPostEngagement)
+	e.GET("/bsky/post/:rkey/reposts", srv.WebPostEngagement)
+	e.GET("/bsky/post/:rkey/quotes", srv.WebPostEngagement)
+	e.GET("/oembed", srv.WebOEmbed)
+	e.GET("/bsky/oembed", srv.WebOEmbed)
+	e.GET("/bsky/list/:rkey", srv.WebList)
+	e.GET("/bsky/list/:rkey/feed", srv.WebList)
+	e.GET("/bsky/lists", srv.WebLists)
+	e.GET("/bsky/search", srv.WebSearch)
+	e.GET("/bsky/feed/:rkey", srv.WebFeed)
 	e.GET("/bsky/repo.car", srv.WebRepoCar)
 	e.GET("/bsky/rss.xml", srv.WebRepoRSS)
+	e.GET("/bsky/atom.xml", srv.WebRepoAtom)
+	e.GET("/.well-known/webfinger", srv.WebFinger)
+	e.GET("/sitemap.xml", srv.WebSitemap)
+	e.GET("/bsky/sitemap.xml", srv.WebSitemap)
+	e.GET("/bsky/sitemap-posts.xml", srv.WebSitemapPosts)
+	if srv.images != nil {
+		// like oembed, links use the /bsky path, which works when only /bsky
+		// is proxied
+		e.GET("/img/:preset/*", srv.images.HandleImage)
+		e.GET("/bsky/img/:preset/*", srv.images.HandleImage)
+	}
+
+	if srv.ap != nil {
+		e.GET("/bsky/ap/actor", srv.APActor)
+		e.GET("/bsky/ap/outbox", srv.APOutbox)
+		e.GET("/bsky/ap/note/:rkey", srv.APNote)
+		e.POST("/bsky/ap/inbox", srv.APInbox)
+	}
+
+	// embeddable comments widget. the fragment is fetched cross-origin by
+	// comments.js, so only those sites which have been configured can use it.
+	// with no origins configured, it only works on the same origin (eg, a blog
+	// proxying /bsky to athome).
+	var commentsMiddleware []echo.MiddlewareFunc
+	if len(commentsOrigins) > 0 {
+		commentsMiddleware = append(commentsMiddleware, middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins: commentsOrigins,
+			AllowMethods: []string{http.MethodGet},
+		}))
+	}
+	e.GET("/bsky/comments", srv.WebComments, commentsMiddleware...)
+	e.GET("/bsky/comments.js", echo.WrapHandler(http.StripPrefix("/bsky/", staticHandler)))
 
 	// Start the server
 	slog.Info("starting server", "bind", httpAddress)
@@ -136,6 +280,20 @@ func serve(cctx *cli.Context) error {
 	quit := make(chan struct{})
 	exitSignals := make(chan os.Signal, 1)
 	signal.Notify(exitSignals, syscall.SIGINT, syscall.SIGTERM)
+
+	// SIGHUP re-reads the domains file, so domains can be added without a
+	// restart
+	if srv.domains != nil {
+		reloadSignals := make(chan os.Signal, 1)
+		signal.Notify(reloadSignals, syscall.SIGHUP)
+		go func() {
+			for range reloadSignals {
+				if err := srv.domains.reload(); err != nil {
+					slog.Error("failed to reload domains file", "err", err)
+				}
+			}
+		}()
+	}
 	go func() {
 		sig := <-exitSignals
 		slog.Info("received OS exit signal", "signal", sig)
@@ -159,18 +317,43 @@ type GenericStatus struct {
 	Message string `json:"msg,omitempty"`
 }
 
+// appviewMethodTTLs returns the per-method cache TTLs: profiles and lists
+// change less often than feeds and threads, so are kept for longer by
+// default. Overrides are "nsid=duration".
+func appviewMethodTTLs(ttl time.Duration, overrides []string) (map[string]time.Duration, error) {
+	out := map[string]time.Duration{
+		"app.bsky.actor.getProfile": 2 * ttl,
+		"app.bsky.graph.getList":    2 * ttl,
+	}
+	for _, o := range overrides {
+		nsid, val, ok := strings.Cut(o, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid method cache TTL %q (expected nsid=duration)", o)
+		}
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid method cache TTL %q: %w", o, err)
+		}
+		out[strings.TrimSpace(nsid)] = d
+	}
+	return out, nil
+}
+
 func (srv *Server) errorHandler(err error, c echo.Context) {
 	code := http.StatusInternalServerError
+	msg := http.StatusText(code)
 	if he, ok := err.(*echo.HTTPError); ok {
 		code = he.Code
+		msg = fmt.Sprint(he.Message)
 	}
 	if code >= 500 {
 		slog.Warn("athome-http-internal-error", "err", err)
 	}
 	data := pongo2.Context{
 		"statusCode": code,
+		"message":    msg,
 	}
-	c.Render(code, "error.html", data)
+	srv.renderPage(c, code, "error.html", data)
 }
 
 func (srv *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// posts per sitemap; the protocol allows up to 50,000, but each page is
+	// listed from the PDS 100 records at a time
+	sitemapPageSize = 1000
+	// most sitemap pages listed in the index
+	maxSitemapPages = 500
+)
+
+// sitemaps.org protocol
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// WebSitemap lists the profile and the account's post pages. Accounts with
+// more than one page of posts get a sitemap index instead, pointing to
+// WebSitemapPosts pages.
+func (srv *Server) WebSitemap(c echo.Context) error {
+	ctx := c.Request().Context()
+	handle := srv.reqHandle(c)
+
+	pv, err := appbsky.ActorGetProfile(ctx, srv.xrpcc, handle.String())
+	if err != nil {
+		slog.Warn("failed to fetch handle", "handle", handle, "err", err)
+		return echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
+	}
+	if srv.labels.decide(pv.Labels).Hide {
+		return echo.NewHTTPError(404, "no sitemap for this account")
+	}
+
+	base := srv.siteURL(c) + "/bsky"
+	var posts int64
+	if pv.PostsCount != nil {
+		posts = *pv.PostsCount
+	}
+	if posts <= sitemapPageSize {
+		urls, _, err := srv.sitemapPosts(ctx, syntax.DID(pv.Did), 0, base)
+		if err != nil {
+			return err
+		}
+		set := &sitemapURLSet{URLs: append([]sitemapURL{{Loc: base}}, urls...)}
+		return writeSitemap(c, set)
+	}
+
+	pages := min(int((posts+sitemapPageSize-1)/sitemapPageSize), maxSitemapPages)
+	idx := &sitemapIndex{}
+	for i := 0; i < pages; i++ {
+		idx.Sitemaps = append(idx.Sitemaps, sitemapURL{Loc: fmt.Sprintf("%s/sitemap-posts.xml?page=%d", base, i)})
+	}
+	return writeSitemap(c, idx)
+}
+
+// WebSitemapPosts is one page of post URLs from the sitemap index
+func (srv *Server) WebSitemapPosts(c echo.Context) error {
+	ctx := c.Request().Context()
+	handle := srv.reqHandle(c)
+
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 0 || page >= maxSitemapPages {
+		return echo.NewHTTPError(400, "invalid 'page' parameter")
+	}
+
+	pv, err := appbsky.ActorGetProfile(ctx, srv.xrpcc, handle.String())
+	if err != nil {
+		slog.Warn("failed to fetch handle", "handle", handle, "err", err)
+		return echo.NewHTTPError(404, fmt.Sprintf("handle not found: %s", handle))
+	}
+	if srv.labels.decide(pv.Labels).Hide {
+		return echo.NewHTTPError(404, "no sitemap for this account")
+	}
+
+	base := srv.siteURL(c) + "/bsky"
+	urls, found, err := srv.sitemapPosts(ctx, syntax.DID(pv.Did), page, base)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return echo.NewHTTPError(404, "no such sitemap page")
+	}
+	if page == 0 {
+		urls = append([]sitemapURL{{Loc: base}}, urls...)
+	}
+	return writeSitemap(c, &sitemapURLSet{URLs: urls})
+}
+
+// sitemapPosts lists a page of the account's posts from its PDS (which,
+// unlike the AppView, returns every post). Starting cursors of pages are
+// remembered, so later pages don't need listing from the start every time.
+func (srv *Server) sitemapPosts(ctx context.Context, did syntax.DID, page int, base string) ([]sitemapURL, bool, error) {
+	ident, err := srv.dir.LookupDID(ctx, did)
+	if err != nil {
+		return nil, false, err
+	}
+	pds := &xrpc.Client{
+		Client: util.RobustHTTPClient(),
+		Host:   ident.PDSEndpoint(),
+	}
+
+	// find the closest page we know where to start
+	start, cursor := 0, ""
+	for p := page; p > 0; p-- {
+		if cur, ok := srv.sitemapCursors.Get(sitemapCursorKey(did, p)); ok {
+			start, cursor = p, cur
+			break
+		}
+	}
+
+	var urls []sitemapURL
+	for p := start; p <= page; p++ {
+		if p > 0 {
+			srv.sitemapCursors.Add(sitemapCursorKey(did, p), cursor)
+		}
+		urls = urls[:0]
+		for len(urls) < sitemapPageSize {
+			out, err := comatproto.RepoListRecords(ctx, pds, "app.bsky.feed.post", cursor, 100, did.String(), false, "", "")
+			if err != nil {
+				return nil, false, fmt.Errorf("listing posts: %w", err)
+			}
+			for _, rec := range out.Records {
+				if u, ok := sitemapPostURL(base, rec); ok {
+					urls = append(urls, u)
+				}
+			}
+			if out.Cursor == nil || *out.Cursor == "" || len(out.Records) == 0 {
+				// reached the end of the posts
+				return urls, p == page, nil
+			}
+			cursor = *out.Cursor
+		}
+	}
+	return urls, true, nil
+}
+
+func sitemapCursorKey(did syntax.DID, page int) string {
+	return did.String() + " " + strconv.Itoa(page)
+}
+
+func sitemapPostURL(base string, rec *comatproto.RepoListRecords_Record) (sitemapURL, bool) {
+	aturi, err := syntax.ParseATURI(rec.Uri)
+	if err != nil {
+		return sitemapURL{}, false
+	}
+	u := sitemapURL{Loc: base + "/post/" + aturi.RecordKey().String()}
+	if rec.Value != nil {
+		if fp, ok := rec.Value.Val.(*appbsky.FeedPost); ok {
+			if t, err := syntax.ParseDatetimeTime(fp.CreatedAt); err == nil {
+				u.LastMod = t.UTC().Format(time.RFC3339)
+			}
+		}
+	}
+	return u, true
+}
+
+func writeSitemap(c echo.Context, v any) error {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.Blob(http.StatusOK, "application/xml; charset=utf-8", append([]byte(xml.Header), b...))
+}
@@ -0,0 +1,45 @@
+// athome comments widget.
+//
+// Include this script on a page, and add a container element with the URL of
+// the Bluesky post announcing the page:
+//
+//   <div data-bsky-comments="https://bsky.app/profile/example.com/post/3k44dfbw2zr2h"></div>
+//   <script src="https://example.com/bsky/comments.js" async></script>
+//
+// The replies are fetched from the athome instance this script was loaded
+// from. If that is a different origin from the page, the page's origin needs
+// to be in athome's --comments-allowed-origins.
+(function () {
+  var script = document.currentScript;
+  var base = new URL(script ? script.src : "/", window.location.href).origin;
+
+  function load(el) {
+    var postURL = el.getAttribute("data-bsky-comments");
+    if (!postURL) {
+      return;
+    }
+    fetch(base + "/bsky/comments?url=" + encodeURIComponent(postURL))
+      .then(function (resp) {
+        if (!resp.ok) {
+          throw new Error("HTTP " + resp.status);
+        }
+        return resp.text();
+      })
+      .then(function (html) {
+        el.innerHTML = html;
+      })
+      .catch(function (err) {
+        console.warn("failed to load Bluesky comments", postURL, err);
+      });
+  }
+
+  function loadAll() {
+    document.querySelectorAll("[data-bsky-comments]").forEach(load);
+  }
+
+  if (document.readyState === "loading") {
+    document.addEventListener("DOMContentLoaded", loadAll);
+  } else {
+    loadAll();
+  }
+})();
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/labstack/echo/v4"
+)
+
+// WebFinger (RFC 7033) JSON Resource Descriptor
+type jrd struct {
+	Subject string    `json:"subject"`
+	Aliases []string  `json:"aliases,omitempty"`
+	Links   []jrdLink `json:"links"`
+}
+
+type jrdLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+// WebFinger resolves the account a domain serves, queried as
+// acct:<handle>@<domain> (or acct:bsky@<domain>, the ActivityPub actor), its
+// DID, or its profile URL, to the profile page, DID document and (if
+// configured) ActivityPub actor
+func (srv *Server) WebFinger(c echo.Context) error {
+	resource := c.QueryParam("resource")
+	if resource == "" {
+		return echo.NewHTTPError(400, "missing 'resource' parameter")
+	}
+	pv, err := srv.apProfile(c)
+	if err != nil {
+		return err
+	}
+
+	base := srv.siteURL(c)
+	domain := strings.TrimPrefix(base, "https://")
+	acct := "acct:" + pv.Handle + "@" + domain
+	apAcct := "acct:" + apUsername + "@" + domain
+	profileURL := base + "/bsky"
+
+	known := []string{acct, pv.Did, profileURL}
+	if srv.ap != nil {
+		known = append(known, apAcct, srv.apActorURL(c))
+	}
+	found := false
+	for _, k := range known {
+		// handles and domains are case-insensitive
+		found = found || strings.EqualFold(resource, k)
+	}
+	if !found {
+		return echo.NewHTTPError(404, "unknown resource")
+	}
+
+	out := &jrd{
+		Subject: acct,
+		Aliases: []string{pv.Did, profileURL},
+		Links: []jrdLink{
+			{Rel: "http://webfinger.net/rel/profile-page", Type: "text/html", Href: profileURL},
+		},
+	}
+	if doc := didDocumentURL(syntax.DID(pv.Did)); doc != "" {
+		out.Links = append(out.Links, jrdLink{Rel: "describedby", Type: "application/did+json", Href: doc})
+	}
+	if srv.ap != nil {
+		// Mastodon checks the subject is the actor's preferredUsername
+		out.Subject = apAcct
+		out.Aliases = append(out.Aliases, acct, srv.apActorURL(c))
+		out.Links = append(out.Links, jrdLink{Rel: "self", Type: activityJSONType, Href: srv.apActorURL(c)})
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("Access-Control-Allow-Origin", "*")
+	return c.Blob(http.StatusOK, "application/jrd+json; charset=utf-8", b)
+}
+
+// didDocumentURL is where a DID's document can be fetched over HTTPS
+func didDocumentURL(did syntax.DID) string {
+	switch did.Method() {
+	case "plc":
+		return identity.DefaultPLCURL + "/" + did.String()
+	case "web":
+		return "https://" + did.Identifier() + "/.well-known/did.json"
+	}
+	return ""
+}
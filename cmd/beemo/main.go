@@ -4,13 +4,9 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
@@ -75,9 +71,13 @@ func run(args []string) error {
 		&cli.StringFlag{
 			Name: "slack-webhook-url",
 			// eg: https://hooks.slack.com/services/X1234
-			Usage:    "full URL of slack webhook",
-			Required: true,
-			EnvVars:  []string{"SLACK_WEBHOOK_URL"},
+			Usage:   "full URL of slack webhook (required unless using a rules file)",
+			EnvVars: []string{"SLACK_WEBHOOK_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "rules-file",
+			Usage:   "path to a JSON file of notification rules and destinations (see README)",
+			EnvVars: []string{"BEEMO_RULES_FILE"},
 		},
 		&cli.IntFlag{
 			Name:    "poll-period",
@@ -97,12 +97,27 @@ func run(args []string) error {
 }
 
 func pollNewReports(cctx *cli.Context) error {
+	ctx := context.TODO()
 	// record last-seen report timestamp
 	since := time.Now()
 	// NOTE: uncomment this for testing
 	//since = time.Now().Add(time.Duration(-12) * time.Hour)
 	period := time.Duration(cctx.Int("poll-period")) * time.Second
 
+	var rules *RulesConfig
+	if path := cctx.String("rules-file"); path != "" {
+		cfg, err := LoadRulesConfig(path)
+		if err != nil {
+			return err
+		}
+		rules = cfg
+	} else if cctx.String("slack-webhook-url") != "" {
+		rules = DefaultRulesConfig(cctx.String("slack-webhook-url"))
+	} else {
+		return fmt.Errorf("either --slack-webhook-url or --rules-file is required")
+	}
+	notifier := NewNotifier(rules, cctx.String("pds-host"), cctx.String("admin-host"))
+
 	// create a new session
 	xrpcc := &xrpc.Client{
 		Client: util.RobustHTTPClient(),
@@ -110,7 +125,7 @@ func pollNewReports(cctx *cli.Context) error {
 		Auth:   &xrpc.AuthInfo{Handle: cctx.String("handle")},
 	}
 
-	auth, err := comatproto.ServerCreateSession(context.TODO(), xrpcc, &comatproto.ServerCreateSession_Input{
+	auth, err := comatproto.ServerCreateSession(ctx, xrpcc, &comatproto.ServerCreateSession_Input{
 		Identifier: xrpcc.Auth.Handle,
 		Password:   cctx.String("password"),
 	})
@@ -126,10 +141,10 @@ func pollNewReports(cctx *cli.Context) error {
 	if len(adminToken) > 0 {
 		xrpcc.AdminToken = &adminToken
 	}
-	log.Infof("report polling bot starting up...")
+	log.Infof("report polling bot starting up with %d notification rules...", len(rules.Rules))
 	// can flip this bool to false to prevent spamming slack channel on startup
 	if true {
-		err := sendSlackMsg(cctx, fmt.Sprintf("restarted bot, monitoring for reports since `%s`...", since.Format(time.RFC3339)))
+		err := notifier.SendAll(ctx, fmt.Sprintf("restarted bot, monitoring for reports since `%s`...", since.Format(time.RFC3339)))
 		if err != nil {
 			return err
 		}
@@ -137,7 +152,7 @@ func pollNewReports(cctx *cli.Context) error {
 	for {
 		// refresh session
 		xrpcc.Auth.AccessJwt = xrpcc.Auth.RefreshJwt
-		refresh, err := comatproto.ServerRefreshSession(context.TODO(), xrpcc)
+		refresh, err := comatproto.ServerRefreshSession(ctx, xrpcc)
 		if err != nil {
 			return err
 		}
@@ -147,11 +162,14 @@ func pollNewReports(cctx *cli.Context) error {
 		// AdminGetModerationReports(ctx context.Context, c *xrpc.Client, actionType string, actionedBy string, cursor string, ignoreSubjects []string, limit int64, reporters []string, resolved bool, reverse bool, subject string) (*AdminGetModerationReports_Output, error)
 		resolved := false
 		var limit int64 = 50
-		mrr, err := comatproto.AdminGetModerationReports(context.TODO(), xrpcc, "", "", "", nil, limit, nil, resolved, false, "")
+		mrr, err := comatproto.AdminGetModerationReports(ctx, xrpcc, "", "", "", nil, limit, nil, resolved, false, "")
 		if err != nil {
 			return err
 		}
-		// this works out to iterate from newest to oldest, which is the behavior we want (report only newest, then break)
+		// this works out to iterate from newest to oldest; rules which only
+		// want the newest report(s) rely on that order
+		var newReports []*comatproto.AdminDefs_ReportView
+		newest := since
 		for _, report := range mrr.Reports {
 			if len(report.ResolvedByActionIds) > 0 {
 				continue
@@ -161,63 +179,19 @@ func pollNewReports(cctx *cli.Context) error {
 				return fmt.Errorf("invalid time format for 'createdAt': %w", err)
 			}
 			if createdAt.After(since) {
-				shortType := ""
-				if report.ReasonType != nil && strings.Contains(*report.ReasonType, "#") {
-					shortType = strings.SplitN(*report.ReasonType, "#", 2)[1]
-				}
-				// ok, we found a "new" report, need to notify
-				msg := fmt.Sprintf("⚠️ New report at `%s` ⚠️\n", report.CreatedAt)
-				msg += fmt.Sprintf("report id: `%d`\t", report.Id)
-				msg += fmt.Sprintf("recent unresolved: `%d`\t", len(mrr.Reports))
-				msg += fmt.Sprintf("instance: `%s`\n", cctx.String("pds-host"))
-				msg += fmt.Sprintf("reasonType: `%s`\t", shortType)
-				msg += fmt.Sprintf("Admin: %s/reports/%d\n", cctx.String("admin-host"), report.Id)
-				//msg += fmt.Sprintf("reportedByDid: `%s`\n", report.ReportedByDid)
-				log.Infof("found new report, notifying slack: %s", report)
-				err := sendSlackMsg(cctx, msg)
-				if err != nil {
-					return fmt.Errorf("failed to send slack message: %w", err)
+				newReports = append(newReports, report)
+				if createdAt.After(newest) {
+					newest = createdAt
 				}
-				since = createdAt
-				break
 			} else {
 				log.Debugf("skipping report: %s", report)
 			}
 		}
+		if err := notifier.HandleReports(ctx, newReports, len(mrr.Reports), time.Now()); err != nil {
+			return fmt.Errorf("failed to send notification: %w", err)
+		}
+		since = newest
 		log.Infof("... sleeping for %s", period)
 		time.Sleep(period)
 	}
 }
-
-type SlackWebhookBody struct {
-	Text string `json:"text"`
-}
-
-// sends a simple slack message to a channel via "incoming webhook"
-// The slack incoming webhook must be already configured in the slack workplace.
-func sendSlackMsg(cctx *cli.Context, msg string) error {
-	// loosely based on: https://golangcode.com/send-slack-messages-without-a-library/
-
-	webhookUrl := cctx.String("slack-webhook-url")
-	body, _ := json.Marshal(SlackWebhookBody{Text: msg})
-	req, err := http.NewRequest(http.MethodPost, webhookUrl, bytes.NewBuffer(body))
-	if err != nil {
-		return err
-	}
-	req.Header.Add("Content-Type", "application/json")
-	client := util.RobustHTTPClient()
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-
-	defer resp.Body.Close()
-
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(resp.Body)
-	if resp.StatusCode != 200 || buf.String() != "ok" {
-		// TODO: in some cases print body? eg, if short and text
-		return fmt.Errorf("failed slack webhook POST request. status=%d", resp.StatusCode)
-	}
-	return nil
-}
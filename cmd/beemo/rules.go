@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/util"
+)
+
+const (
+	// EventReport matches each new moderation report
+	EventReport = "report"
+	// EventReportSpike fires when the number of new (matching) reports in a
+	// window reaches a threshold
+	EventReportSpike = "report_spike"
+)
+
+// RulesConfig routes moderation events to notification destinations
+type RulesConfig struct {
+	Destinations map[string]*Destination `json:"destinations"`
+	Rules        []*NotifyRule           `json:"rules"`
+}
+
+// Destination is somewhere to send notifications: a Slack incoming webhook,
+// or any other webhook accepting a JSON POST
+type Destination struct {
+	// Type is "slack" (the default) or "webhook"
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type NotifyRule struct {
+	Name  string `json:"name"`
+	Event string `json:"event"`
+	// ReasonTypes limits the rule to reports with these reason types (either
+	// full, like "com.atproto.moderation.defs#reasonSpam", or just "reasonSpam")
+	ReasonTypes []string `json:"reasonTypes,omitempty"`
+	// SubjectType limits the rule to reports on "account" or "record" subjects
+	SubjectType string `json:"subjectType,omitempty"`
+
+	// Threshold and Window configure report_spike rules. Cooldown is how long
+	// to wait before alerting on the same rule again, and defaults to Window.
+	Threshold int      `json:"threshold,omitempty"`
+	Window    Duration `json:"window,omitempty"`
+	Cooldown  Duration `json:"cooldown,omitempty"`
+
+	// MaxPerPoll caps how many individual reports a report rule notifies
+	// about each poll, newest first (0 for no limit)
+	MaxPerPoll int `json:"maxPerPoll,omitempty"`
+
+	QuietHours *QuietHours `json:"quietHours,omitempty"`
+
+	Destinations []string `json:"destinations"`
+}
+
+// QuietHours suppresses a rule's notifications between two times of day,
+// eg "22:00" to "07:00". Ranges may wrap midnight.
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone,omitempty"`
+
+	loc        *time.Location
+	start, end int
+}
+
+// Duration is a time.Duration which JSON-decodes from strings like "10m"
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func LoadRulesConfig(path string) (*RulesConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg RulesConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing rules config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rules config: %w", err)
+	}
+	return &cfg, nil
+}
+
+// DefaultRulesConfig is the behavior without a rules file: the newest new
+// report each poll is sent to a single Slack webhook
+func DefaultRulesConfig(slackWebhookURL string) *RulesConfig {
+	cfg := &RulesConfig{
+		Destinations: map[string]*Destination{
+			"default": {Type: "slack", URL: slackWebhookURL},
+		},
+		Rules: []*NotifyRule{
+			{Name: "new-reports", Event: EventReport, MaxPerPoll: 1, Destinations: []string{"default"}},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+func (cfg *RulesConfig) Validate() error {
+	for name, d := range cfg.Destinations {
+		if d.Type == "" {
+			d.Type = "slack"
+		}
+		if d.Type != "slack" && d.Type != "webhook" {
+			return fmt.Errorf("destination %s: unknown type %q", name, d.Type)
+		}
+		if d.URL == "" {
+			return fmt.Errorf("destination %s: missing url", name)
+		}
+	}
+	names := make(map[string]bool)
+	for i, r := range cfg.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate rule name: %s", r.Name)
+		}
+		names[r.Name] = true
+		switch r.Event {
+		case EventReport:
+		case EventReportSpike:
+			if r.Threshold <= 0 || r.Window.Duration <= 0 {
+				return fmt.Errorf("rule %s: report_spike needs a threshold and window", r.Name)
+			}
+			if r.Cooldown.Duration == 0 {
+				r.Cooldown = r.Window
+			}
+		default:
+			return fmt.Errorf("rule %s: unknown event type %q", r.Name, r.Event)
+		}
+		if r.SubjectType != "" && r.SubjectType != "account" && r.SubjectType != "record" {
+			return fmt.Errorf("rule %s: subjectType must be account or record", r.Name)
+		}
+		if len(r.Destinations) == 0 {
+			return fmt.Errorf("rule %s: no destinations", r.Name)
+		}
+		for _, d := range r.Destinations {
+			if cfg.Destinations[d] == nil {
+				return fmt.Errorf("rule %s: unknown destination %s", r.Name, d)
+			}
+		}
+		if r.QuietHours != nil {
+			if err := r.QuietHours.parse(); err != nil {
+				return fmt.Errorf("rule %s: quietHours: %w", r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (qh *QuietHours) parse() error {
+	loc := time.UTC
+	if qh.Timezone != "" {
+		l, err := time.LoadLocation(qh.Timezone)
+		if err != nil {
+			return err
+		}
+		loc = l
+	}
+	start, err := parseTimeOfDay(qh.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseTimeOfDay(qh.End)
+	if err != nil {
+		return err
+	}
+	qh.loc, qh.start, qh.end = loc, start, end
+	return nil
+}
+
+// parseTimeOfDay returns minutes since midnight
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (want HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Active reports whether t falls within the quiet hours
+func (qh *QuietHours) Active(t time.Time) bool {
+	t = t.In(qh.loc)
+	m := t.Hour()*60 + t.Minute()
+	if qh.start <= qh.end {
+		return m >= qh.start && m < qh.end
+	}
+	return m >= qh.start || m < qh.end
+}
+
+// Matches reports whether a report is covered by the rule's filters
+func (r *NotifyRule) Matches(report *comatproto.AdminDefs_ReportView) bool {
+	if len(r.ReasonTypes) > 0 {
+		if report.ReasonType == nil {
+			return false
+		}
+		found := false
+		for _, rt := range r.ReasonTypes {
+			if *report.ReasonType == rt || strings.HasSuffix(*report.ReasonType, "#"+rt) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	switch r.SubjectType {
+	case "account":
+		return report.Subject != nil && report.Subject.AdminDefs_RepoRef != nil
+	case "record":
+		return report.Subject != nil && report.Subject.RepoStrongRef != nil
+	}
+	return true
+}
+
+// Notifier applies notification rules to moderation reports
+type Notifier struct {
+	Config    *RulesConfig
+	PDSHost   string
+	AdminHost string
+
+	client *http.Client
+	// per report_spike rule: times of recent matching reports, and when it
+	// last fired
+	recent    map[string][]time.Time
+	lastFired map[string]time.Time
+}
+
+func NewNotifier(cfg *RulesConfig, pdsHost, adminHost string) *Notifier {
+	return &Notifier{
+		Config:    cfg,
+		PDSHost:   pdsHost,
+		AdminHost: adminHost,
+		client:    util.SafeHTTPClient(),
+		recent:    make(map[string][]time.Time),
+		lastFired: make(map[string]time.Time),
+	}
+}
+
+// HandleReports processes a poll's new reports, newest first. unresolved is
+// the total number of recent unresolved reports, for context.
+func (n *Notifier) HandleReports(ctx context.Context, reports []*comatproto.AdminDefs_ReportView, unresolved int, now time.Time) error {
+	for _, r := range n.Config.Rules {
+		var err error
+		switch r.Event {
+		case EventReport:
+			err = n.handleReportRule(ctx, r, reports, unresolved, now)
+		case EventReportSpike:
+			err = n.handleSpikeRule(ctx, r, reports, now)
+		}
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) handleReportRule(ctx context.Context, r *NotifyRule, reports []*comatproto.AdminDefs_ReportView, unresolved int, now time.Time) error {
+	sent := 0
+	for _, report := range reports {
+		if r.MaxPerPoll > 0 && sent >= r.MaxPerPoll {
+			break
+		}
+		if !r.Matches(report) {
+			continue
+		}
+		sent++
+		if r.QuietHours != nil && r.QuietHours.Active(now) {
+			log.Infof("quiet hours for rule %s, not notifying about report %d", r.Name, report.Id)
+			continue
+		}
+		log.Infof("found new report, notifying for rule %s: %d", r.Name, report.Id)
+		if err := n.send(ctx, r, n.reportMessage(report, unresolved)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) handleSpikeRule(ctx context.Context, r *NotifyRule, reports []*comatproto.AdminDefs_ReportView, now time.Time) error {
+	recent := n.recent[r.Name]
+	for _, report := range reports {
+		if !r.Matches(report) {
+			continue
+		}
+		createdAt, err := time.Parse(time.RFC3339, report.CreatedAt)
+		if err != nil {
+			continue
+		}
+		recent = append(recent, createdAt)
+	}
+
+	// drop reports which have aged out of the window
+	cutoff := now.Add(-r.Window.Duration)
+	kept := recent[:0]
+	for _, t := range recent {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	n.recent[r.Name] = kept
+
+	if len(kept) < r.Threshold {
+		return nil
+	}
+	if last, ok := n.lastFired[r.Name]; ok && now.Sub(last) < r.Cooldown.Duration {
+		return nil
+	}
+	n.lastFired[r.Name] = now
+	if r.QuietHours != nil && r.QuietHours.Active(now) {
+		log.Infof("quiet hours for rule %s, not notifying about spike of %d reports", r.Name, len(kept))
+		return nil
+	}
+
+	msg := fmt.Sprintf("📈 Report spike: `%d` new reports in the last `%s` (threshold `%d`, rule `%s`)\n", len(kept), r.Window, r.Threshold, r.Name)
+	msg += fmt.Sprintf("instance: `%s`\n", n.PDSHost)
+	msg += fmt.Sprintf("Admin: %s/reports\n", n.AdminHost)
+	log.Infof("report spike for rule %s, notifying: %d reports", r.Name, len(kept))
+	return n.send(ctx, r, msg)
+}
+
+func (n *Notifier) reportMessage(report *comatproto.AdminDefs_ReportView, unresolved int) string {
+	shortType := ""
+	if report.ReasonType != nil && strings.Contains(*report.ReasonType, "#") {
+		shortType = strings.SplitN(*report.ReasonType, "#", 2)[1]
+	}
+	msg := fmt.Sprintf("⚠️ New report at `%s` ⚠️\n", report.CreatedAt)
+	msg += fmt.Sprintf("report id: `%d`\t", report.Id)
+	msg += fmt.Sprintf("recent unresolved: `%d`\t", unresolved)
+	msg += fmt.Sprintf("instance: `%s`\n", n.PDSHost)
+	msg += fmt.Sprintf("reasonType: `%s`\t", shortType)
+	msg += fmt.Sprintf("Admin: %s/reports/%d\n", n.AdminHost, report.Id)
+	return msg
+}
+
+// SendAll sends a message to every configured destination, eg on startup
+func (n *Notifier) SendAll(ctx context.Context, msg string) error {
+	for name, d := range n.Config.Destinations {
+		if err := n.sendTo(ctx, d, msg); err != nil {
+			return fmt.Errorf("destination %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) send(ctx context.Context, r *NotifyRule, msg string) error {
+	for _, name := range r.Destinations {
+		if err := n.sendTo(ctx, n.Config.Destinations[name], msg); err != nil {
+			return fmt.Errorf("destination %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+type WebhookBody struct {
+	Text string `json:"text"`
+}
+
+func (n *Notifier) sendTo(ctx context.Context, d *Destination, msg string) error {
+	body, _ := json.Marshal(WebhookBody{Text: msg})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(resp.Body)
+	if d.Type == "slack" {
+		if resp.StatusCode != 200 || buf.String() != "ok" {
+			return fmt.Errorf("failed slack webhook POST request. status=%d", resp.StatusCode)
+		}
+		return nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed webhook POST request. status=%d", resp.StatusCode)
+	}
+	return nil
+}
@@ -96,6 +96,11 @@ func run(args []string) {
 			Name:  "crawl-insecure-ws",
 			Usage: "when connecting to PDS instances, use ws:// instead of wss://",
 		},
+		&cli.DurationFlag{
+			Name:    "did-revalidate-interval",
+			Usage:   "if set, revalidate cached DID documents this often with conditional requests, instead of caching them for 24 hours",
+			EnvVars: []string{"BGS_DID_REVALIDATE_INTERVAL"},
+		},
 		&cli.BoolFlag{
 			Name:  "aggregation",
 			Value: false,
@@ -139,6 +144,17 @@ func run(args []string) {
 			EnvVars: []string{"MAX_METADB_CONNECTIONS"},
 			Value:   40,
 		},
+		&cli.StringSliceFlag{
+			Name:    "labelers",
+			Usage:   "hostnames of labeling services whose label streams should be relayed",
+			EnvVars: []string{"BGS_LABELERS"},
+		},
+		&cli.DurationFlag{
+			Name:    "label-retention",
+			Usage:   "how long relayed labels are kept for replay",
+			Value:   72 * time.Hour,
+			EnvVars: []string{"BGS_LABEL_RETENTION"},
+		},
 	}
 
 	app.Action = Bigsky
@@ -256,7 +272,12 @@ func Bigsky(cctx *cli.Context) error {
 	}
 	mr.AddHandler("web", &webr)
 
-	cachedidr := plc.NewCachingDidResolver(mr, time.Hour*24, 500_000)
+	var cachedidr did.Resolver = plc.NewCachingDidResolver(mr, time.Hour*24, 500_000)
+	if iv := cctx.Duration("did-revalidate-interval"); iv > 0 {
+		f := did.NewConditionalFetcher(cctx.String("plc-host"), iv, 500_000)
+		f.Insecure = cctx.Bool("crawl-insecure-ws")
+		cachedidr = f
+	}
 
 	kmgr := indexer.NewKeyManager(cachedidr, nil)
 
@@ -346,6 +367,13 @@ func Bigsky(cctx *cli.Context) error {
 		}
 	}
 
+	if labelers := cctx.StringSlice("labelers"); len(labelers) > 0 {
+		log.Infow("relaying labels", "labelers", labelers)
+		if err := bgs.StartLabelRelay(labelers, cctx.Duration("label-retention")); err != nil {
+			return fmt.Errorf("failed to start label relay: %w", err)
+		}
+	}
+
 	// set up metrics endpoint
 	go func() {
 		if err := bgs.StartMetrics(cctx.String("metrics-listen")); err != nil {
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	apnsProductionURL = "https://api.push.apple.com"
+	apnsSandboxURL    = "https://api.sandbox.push.apple.com"
+
+	// APNs rejects provider tokens older than an hour, and throttles
+	// refreshing more often than every 20 minutes
+	apnsTokenLifetime = 45 * time.Minute
+)
+
+// APNSPusher delivers to iOS devices through the Apple Push Notification
+// service, with token-based (.p8 signing key) authentication
+type APNSPusher struct {
+	Host string
+	// DefaultTopic is the app bundle ID used for subscriptions which didn't
+	// register with one
+	DefaultTopic string
+
+	keyID  string
+	teamID string
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	lk       sync.Mutex
+	token    string
+	tokenAge time.Time
+}
+
+func NewAPNSPusher(keyFile, keyID, teamID, topic string, sandbox bool) (*APNSPusher, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading APNs key: %w", err)
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing APNs key: %w", err)
+	}
+
+	host := apnsProductionURL
+	if sandbox {
+		host = apnsSandboxURL
+	}
+	return &APNSPusher{
+		Host:         host,
+		DefaultTopic: topic,
+		keyID:        keyID,
+		teamID:       teamID,
+		key:          key,
+		// the default transport negotiates HTTP/2, which APNs requires
+		client: &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+// providerToken returns the cached JWT for APNs, signing a new one when the
+// current one is near expiry (or after APNs rejected it)
+func (ap *APNSPusher) providerToken(forceRefresh bool) (string, error) {
+	ap.lk.Lock()
+	defer ap.lk.Unlock()
+
+	if ap.token != "" && !forceRefresh && time.Since(ap.tokenAge) < apnsTokenLifetime {
+		return ap.token, nil
+	}
+
+	now := time.Now()
+	tok := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": ap.teamID,
+		"iat": now.Unix(),
+	})
+	tok.Header["kid"] = ap.keyID
+	signed, err := tok.SignedString(ap.key)
+	if err != nil {
+		return "", fmt.Errorf("signing APNs provider token: %w", err)
+	}
+	ap.token = signed
+	ap.tokenAge = now
+	return signed, nil
+}
+
+type apnsAlert struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+type apnsAps struct {
+	Alert          apnsAlert `json:"alert"`
+	Sound          string    `json:"sound,omitempty"`
+	MutableContent int       `json:"mutable-content,omitempty"`
+}
+
+func (ap *APNSPusher) Push(ctx context.Context, token string, msg *Message) error {
+	payload := map[string]any{
+		"aps": apnsAps{
+			Alert:          apnsAlert{Title: msg.Title, Body: msg.Body},
+			Sound:          "default",
+			MutableContent: 1,
+		},
+	}
+	for k, v := range msg.Data {
+		payload[k] = v
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	topic := msg.AppId
+	if topic == "" {
+		topic = ap.DefaultTopic
+	}
+
+	err = ap.send(ctx, token, topic, body, false)
+	var pe *PushError
+	if errors.As(err, &pe) && pe.Reason == "ExpiredProviderToken" {
+		err = ap.send(ctx, token, topic, body, true)
+	}
+	return err
+}
+
+func (ap *APNSPusher) send(ctx context.Context, token, topic string, body []byte, refresh bool) error {
+	pt, err := ap.providerToken(refresh)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ap.Host+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("authorization", "bearer "+pt)
+	req.Header.Set("apns-topic", topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+
+	resp, err := ap.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var reason struct {
+		Reason string `json:"reason"`
+	}
+	rb, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	_ = json.Unmarshal(rb, &reason)
+
+	pe := &PushError{
+		StatusCode: resp.StatusCode,
+		Reason:     reason.Reason,
+	}
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		// "Unregistered": the app is no longer installed
+		pe.Invalid = true
+	case resp.StatusCode == http.StatusBadRequest && (reason.Reason == "BadDeviceToken" || reason.Reason == "DeviceTokenNotForTopic"):
+		pe.Invalid = true
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		pe.Retry = true
+		pe.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return pe
+}
+
+// parseRetryAfter reads a Retry-After header in seconds; HTTP dates aren't
+// used by APNs or FCM
+func parseRetryAfter(v string) time.Duration {
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
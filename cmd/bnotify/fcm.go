@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	fcmScope   = "https://www.googleapis.com/auth/firebase.messaging"
+	fcmBaseURL = "https://fcm.googleapis.com"
+)
+
+// the parts of a Google service account key file used for FCM
+type serviceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// FCMPusher delivers to Android (and web) devices through the Firebase Cloud
+// Messaging HTTP v1 API, authenticated as a service account
+type FCMPusher struct {
+	BaseURL string
+
+	account serviceAccount
+	key     *rsa.PrivateKey
+	client  *http.Client
+
+	lk          sync.Mutex
+	accessToken string
+	expires     time.Time
+}
+
+func NewFCMPusher(credentialsFile string) (*FCMPusher, error) {
+	b, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading FCM credentials: %w", err)
+	}
+	var sa serviceAccount
+	if err := json.Unmarshal(b, &sa); err != nil {
+		return nil, fmt.Errorf("parsing FCM credentials: %w", err)
+	}
+	if sa.ProjectID == "" || sa.ClientEmail == "" || sa.TokenURI == "" {
+		return nil, fmt.Errorf("FCM credentials file is not a service account key")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(sa.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("parsing FCM service account key: %w", err)
+	}
+
+	return &FCMPusher{
+		BaseURL: fcmBaseURL,
+		account: sa,
+		key:     key,
+		client:  &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+// getAccessToken exchanges a signed assertion for an OAuth access token (the
+// JWT bearer grant), caching it until shortly before it expires
+func (fp *FCMPusher) getAccessToken(ctx context.Context) (string, error) {
+	fp.lk.Lock()
+	defer fp.lk.Unlock()
+
+	if fp.accessToken != "" && time.Now().Before(fp.expires) {
+		return fp.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   fp.account.ClientEmail,
+		"scope": fcmScope,
+		"aud":   fp.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(fp.key)
+	if err != nil {
+		return "", fmt.Errorf("signing FCM token request: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", fp.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := fp.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting FCM access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("requesting FCM access token (%d): %s", resp.StatusCode, string(b))
+	}
+
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("decoding FCM access token: %w", err)
+	}
+
+	fp.accessToken = out.AccessToken
+	fp.expires = now.Add(time.Duration(out.ExpiresIn)*time.Second - time.Minute)
+	return fp.accessToken, nil
+}
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification fcmNotification   `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+type fcmError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+func (fp *FCMPusher) Push(ctx context.Context, token string, msg *Message) error {
+	at, err := fp.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"message": fcmMessage{
+			Token:        token,
+			Notification: fcmNotification{Title: msg.Title, Body: msg.Body},
+			Data:         msg.Data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	u := fmt.Sprintf("%s/v1/projects/%s/messages:send", fp.BaseURL, fp.account.ProjectID)
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+at)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := fp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var fe fcmError
+	rb, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	_ = json.Unmarshal(rb, &fe)
+
+	pe := &PushError{
+		StatusCode: resp.StatusCode,
+		Reason:     fe.Error.Status,
+	}
+	for _, d := range fe.Error.Details {
+		if d.ErrorCode != "" {
+			pe.Reason = d.ErrorCode
+		}
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || pe.Reason == "UNREGISTERED":
+		pe.Invalid = true
+	case resp.StatusCode == http.StatusUnauthorized:
+		// access token revoked early; fetch a new one next time
+		fp.lk.Lock()
+		fp.accessToken = ""
+		fp.lk.Unlock()
+		pe.Retry = true
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		pe.Retry = true
+		pe.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return pe
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	bsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/parallel"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/repomgr"
+
+	"github.com/carlmjohnson/versioninfo"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	ReasonReply   = "reply"
+	ReasonMention = "mention"
+	ReasonFollow  = "follow"
+)
+
+// Notification is a single event to push to an account's devices
+type Notification struct {
+	// DID of the account being notified
+	Recipient string
+	// DID of the account which caused the notification
+	Author string
+	Reason string
+	// at-uri of the post or follow record
+	Uri  string
+	Text string
+}
+
+// Consumer turns firehose commits into notifications
+type Consumer struct {
+	bgsHost    string
+	store      *Store
+	dispatcher *Dispatcher
+	logger     *slog.Logger
+	workers    int
+}
+
+func NewConsumer(bgsHost string, store *Store, dispatcher *Dispatcher, workers int, logger *slog.Logger) *Consumer {
+	return &Consumer{
+		bgsHost:    bgsHost,
+		store:      store,
+		dispatcher: dispatcher,
+		logger:     logger,
+		workers:    workers,
+	}
+}
+
+// Run subscribes to the firehose, reconnecting from the saved cursor after
+// connection failures, until the context is done
+func (c *Consumer) Run(ctx context.Context) error {
+	for {
+		err := c.subscribe(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.logger.Error("firehose subscription ended, reconnecting", "err", err)
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Consumer) subscribe(ctx context.Context) error {
+	cur, err := c.store.GetCursor(ctx)
+	if err != nil {
+		return fmt.Errorf("get last cursor: %w", err)
+	}
+
+	u, err := url.Parse(c.bgsHost)
+	if err != nil {
+		return fmt.Errorf("invalid bgs host URI: %w", err)
+	}
+	u.Path = "xrpc/com.atproto.sync.subscribeRepos"
+	if cur != 0 {
+		u.RawQuery = fmt.Sprintf("cursor=%d", cur)
+	}
+
+	c.logger.Info("subscribing to firehose", "url", u.String())
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), http.Header{
+		"User-Agent": []string{fmt.Sprintf("bnotify/%s", versioninfo.Short())},
+	})
+	if err != nil {
+		return fmt.Errorf("events dial failed: %w", err)
+	}
+
+	rsc := &events.RepoStreamCallbacks{
+		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
+			defer func() {
+				if evt.Seq%100 == 0 {
+					if err := c.store.UpdateCursor(ctx, evt.Seq); err != nil {
+						c.logger.Error("failed to persist cursor", "err", err)
+					}
+				}
+			}()
+			return c.handleCommit(ctx, evt)
+		},
+	}
+
+	sched := parallel.NewScheduler(c.workers, 1000, u.Host, rsc.EventHandler)
+	return events.HandleRepoStream(ctx, con, sched)
+}
+
+// interesting reports whether any op in a commit could notify someone,
+// so the CAR slice only gets parsed when needed
+func interesting(evt *comatproto.SyncSubscribeRepos_Commit) bool {
+	for _, op := range evt.Ops {
+		if repomgr.EventKind(op.Action) != repomgr.EvtKindCreateRecord {
+			continue
+		}
+		if strings.HasPrefix(op.Path, "app.bsky.feed.post/") || strings.HasPrefix(op.Path, "app.bsky.graph.follow/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Consumer) handleCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) error {
+	if evt.TooBig || !interesting(evt) {
+		return nil
+	}
+	logEvt := c.logger.With("repo", evt.Repo, "rev", evt.Rev, "seq", evt.Seq)
+
+	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(evt.Blocks))
+	if err != nil {
+		logEvt.Error("reading repo from car", "size_bytes", len(evt.Blocks), "err", err)
+		return nil
+	}
+
+	for _, op := range evt.Ops {
+		if repomgr.EventKind(op.Action) != repomgr.EvtKindCreateRecord {
+			continue
+		}
+		collection, _, _ := strings.Cut(op.Path, "/")
+		if collection != "app.bsky.feed.post" && collection != "app.bsky.graph.follow" {
+			continue
+		}
+
+		_, rec, err := r.GetRecord(ctx, op.Path)
+		if err != nil {
+			logEvt.Error("fetching record from event CAR slice", "op_path", op.Path, "err", err)
+			continue
+		}
+
+		uri := "at://" + evt.Repo + "/" + op.Path
+		var notifs []*Notification
+		switch rec := rec.(type) {
+		case *bsky.FeedPost:
+			notifs = postNotifications(evt.Repo, uri, rec)
+		case *bsky.GraphFollow:
+			if n := followNotification(evt.Repo, uri, rec); n != nil {
+				notifs = append(notifs, n)
+			}
+		}
+
+		for _, n := range notifs {
+			if err := c.dispatcher.Enqueue(ctx, n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// postNotifications builds the notifications for a new post: one for the
+// author of the post being replied to, and one for each mentioned account.
+// Nobody is notified about their own post, or twice about the same post.
+func postNotifications(author, uri string, post *bsky.FeedPost) []*Notification {
+	var out []*Notification
+	notified := map[string]bool{author: true}
+
+	if post.Reply != nil && post.Reply.Parent != nil {
+		if parent, err := syntax.ParseATURI(post.Reply.Parent.Uri); err == nil {
+			if did, err := parent.Authority().AsDID(); err == nil && !notified[did.String()] {
+				notified[did.String()] = true
+				out = append(out, &Notification{
+					Recipient: did.String(),
+					Author:    author,
+					Reason:    ReasonReply,
+					Uri:       uri,
+					Text:      post.Text,
+				})
+			}
+		}
+	}
+
+	for _, facet := range post.Facets {
+		for _, feat := range facet.Features {
+			if feat.RichtextFacet_Mention == nil {
+				continue
+			}
+			did, err := syntax.ParseDID(feat.RichtextFacet_Mention.Did)
+			if err != nil || notified[did.String()] {
+				continue
+			}
+			notified[did.String()] = true
+			out = append(out, &Notification{
+				Recipient: did.String(),
+				Author:    author,
+				Reason:    ReasonMention,
+				Uri:       uri,
+				Text:      post.Text,
+			})
+		}
+	}
+	return out
+}
+
+func followNotification(author, uri string, follow *bsky.GraphFollow) *Notification {
+	did, err := syntax.ParseDID(follow.Subject)
+	if err != nil || did.String() == author {
+		return nil
+	}
+	return &Notification{
+		Recipient: did.String(),
+		Author:    author,
+		Reason:    ReasonFollow,
+		Uri:       uri,
+	}
+}
@@ -0,0 +1,274 @@
+// bnotify is a push notification gateway: apps register device tokens for
+// an account, and replies, mentions, and follows seen on the firehose are
+// delivered to those devices through APNs (iOS) and FCM (Android, web).
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto/serviceauth"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/util/ratelimit"
+
+	"github.com/carlmjohnson/versioninfo"
+	_ "github.com/joho/godotenv/autoload"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	cli "github.com/urfave/cli/v2"
+	"golang.org/x/time/rate"
+)
+
+func main() {
+	if err := run(os.Args); err != nil {
+		slog.Error("exiting", "err", err)
+		os.Exit(-1)
+	}
+}
+
+func run(args []string) error {
+
+	app := cli.App{
+		Name:    "bnotify",
+		Usage:   "push notification gateway (APNs and FCM)",
+		Version: versioninfo.Short(),
+	}
+
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:    "atp-bgs-host",
+			Usage:   "hostname and port of BGS to subscribe to",
+			Value:   "wss://bsky.network",
+			EnvVars: []string{"ATP_BGS_HOST"},
+		},
+		&cli.StringFlag{
+			Name:    "atp-plc-host",
+			Usage:   "method, hostname, and port of PLC registry",
+			Value:   identity.DefaultPLCURL,
+			EnvVars: []string{"ATP_PLC_HOST"},
+		},
+		&cli.StringFlag{
+			Name:    "database-url",
+			Usage:   "database for device subscriptions and the firehose cursor",
+			Value:   "sqlite://data/bnotify/bnotify.db",
+			EnvVars: []string{"DATABASE_URL"},
+		},
+		&cli.IntFlag{
+			Name:    "max-metadb-connections",
+			EnvVars: []string{"MAX_METADB_CONNECTIONS"},
+			Value:   40,
+		},
+	}
+
+	app.Commands = []*cli.Command{
+		runCmd,
+	}
+
+	return app.Run(args)
+}
+
+var runCmd = &cli.Command{
+	Name:  "run",
+	Usage: "run the registration API and push delivery from the firehose",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "service-did",
+			Usage:    "DID of this service, which registerPush calls and service auth tokens are addressed to",
+			Required: true,
+			EnvVars:  []string{"BNOTIFY_SERVICE_DID"},
+		},
+		&cli.StringFlag{
+			Name:    "bind",
+			Usage:   "IP or address, and port, to listen on for HTTP APIs",
+			Value:   ":2470",
+			EnvVars: []string{"BNOTIFY_BIND"},
+		},
+		&cli.StringFlag{
+			Name:    "metrics-listen",
+			Usage:   "IP or address, and port, to listen on for metrics APIs",
+			Value:   ":2471",
+			EnvVars: []string{"BNOTIFY_METRICS_LISTEN"},
+		},
+		&cli.StringFlag{
+			Name:    "apns-key-file",
+			Usage:   "path to the APNs token signing key (.p8); enables iOS delivery",
+			EnvVars: []string{"BNOTIFY_APNS_KEY_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "apns-key-id",
+			Usage:   "key ID of the APNs signing key",
+			EnvVars: []string{"BNOTIFY_APNS_KEY_ID"},
+		},
+		&cli.StringFlag{
+			Name:    "apns-team-id",
+			Usage:   "Apple developer team ID",
+			EnvVars: []string{"BNOTIFY_APNS_TEAM_ID"},
+		},
+		&cli.StringFlag{
+			Name:    "apns-topic",
+			Usage:   "app bundle ID, for devices which register without an appId",
+			EnvVars: []string{"BNOTIFY_APNS_TOPIC"},
+		},
+		&cli.BoolFlag{
+			Name:    "apns-sandbox",
+			Usage:   "deliver through the APNs development environment",
+			EnvVars: []string{"BNOTIFY_APNS_SANDBOX"},
+		},
+		&cli.StringFlag{
+			Name:    "fcm-credentials-file",
+			Usage:   "path to a Google service account key (JSON) for FCM; enables Android and web delivery",
+			EnvVars: []string{"BNOTIFY_FCM_CREDENTIALS_FILE", "GOOGLE_APPLICATION_CREDENTIALS"},
+		},
+		&cli.IntFlag{
+			Name:    "batch-size",
+			Usage:   "max notifications delivered per batch",
+			Value:   DefaultDispatcherConfig().BatchSize,
+			EnvVars: []string{"BNOTIFY_BATCH_SIZE"},
+		},
+		&cli.DurationFlag{
+			Name:    "batch-interval",
+			Usage:   "max time a notification waits for its batch to fill",
+			Value:   DefaultDispatcherConfig().BatchInterval,
+			EnvVars: []string{"BNOTIFY_BATCH_INTERVAL"},
+		},
+		&cli.IntFlag{
+			Name:    "push-concurrency",
+			Usage:   "max concurrent requests to push services",
+			Value:   DefaultDispatcherConfig().Concurrency,
+			EnvVars: []string{"BNOTIFY_PUSH_CONCURRENCY"},
+		},
+		&cli.IntFlag{
+			Name:    "push-max-attempts",
+			Usage:   "max tries for a delivery failing with transient errors",
+			Value:   DefaultDispatcherConfig().MaxAttempts,
+			EnvVars: []string{"BNOTIFY_PUSH_MAX_ATTEMPTS"},
+		},
+		&cli.IntFlag{
+			Name:    "firehose-workers",
+			Usage:   "number of workers processing firehose events",
+			Value:   8,
+			EnvVars: []string{"BNOTIFY_FIREHOSE_WORKERS"},
+		},
+		&cli.IntFlag{
+			Name:    "plc-rate-limit",
+			Usage:   "max number of requests per second to PLC registry",
+			Value:   100,
+			EnvVars: []string{"BNOTIFY_PLC_RATE_LIMIT"},
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+			Level: slog.LevelInfo,
+		}))
+		slog.SetDefault(logger)
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+
+		db, err := cliutil.SetupDatabase(cctx.String("database-url"), cctx.Int("max-metadb-connections"))
+		if err != nil {
+			return err
+		}
+		store, err := NewStore(db)
+		if err != nil {
+			return err
+		}
+
+		base := identity.BaseDirectory{
+			PLCURL: cctx.String("atp-plc-host"),
+			HTTPClient: http.Client{
+				Timeout:   time.Second * 15,
+				Transport: ratelimit.Transport(nil),
+			},
+			PLCLimiter:            rate.NewLimiter(rate.Limit(cctx.Int("plc-rate-limit")), 1),
+			TryAuthoritativeDNS:   true,
+			SkipDNSDomainSuffixes: []string{".bsky.social"},
+		}
+		dir := identity.NewCacheDirectory(&base, 500_000, time.Hour*24, time.Minute*2)
+
+		pushers, err := configurePushers(cctx)
+		if err != nil {
+			return err
+		}
+		if len(pushers) == 0 {
+			return fmt.Errorf("no push services configured (need --apns-key-file or --fcm-credentials-file)")
+		}
+
+		cfg := DispatcherConfig{
+			BatchSize:     cctx.Int("batch-size"),
+			BatchInterval: cctx.Duration("batch-interval"),
+			Concurrency:   cctx.Int("push-concurrency"),
+			MaxAttempts:   cctx.Int("push-max-attempts"),
+			QueueSize:     DefaultDispatcherConfig().QueueSize,
+		}
+		dispatcher := NewDispatcher(store, &dir, pushers, cfg, logger.With("component", "dispatcher"))
+		go dispatcher.Run(ctx)
+
+		validator := &serviceauth.Validator{
+			Audience: cctx.String("service-did"),
+			Dir:      &dir,
+		}
+		srv := NewServer(store, validator, cctx.String("service-did"), pushers, logger.With("component", "api"))
+		go func() {
+			if err := srv.RunAPI(cctx.String("bind")); err != nil && err != http.ErrServerClosed {
+				slog.Error("API server failed", "err", err)
+				cancel()
+			}
+		}()
+
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", promhttp.Handler())
+			if err := http.ListenAndServe(cctx.String("metrics-listen"), mux); err != nil {
+				slog.Error("failed to start metrics endpoint", "err", err)
+			}
+		}()
+
+		consumer := NewConsumer(cctx.String("atp-bgs-host"), store, dispatcher, cctx.Int("firehose-workers"), logger.With("component", "firehose"))
+		err = consumer.Run(ctx)
+		slog.Info("shutting down", "err", err)
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to shut down API server", "err", err)
+		}
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	},
+}
+
+// configurePushers sets up each push service which has credentials
+func configurePushers(cctx *cli.Context) (map[string]Pusher, error) {
+	pushers := make(map[string]Pusher)
+
+	if keyFile := cctx.String("apns-key-file"); keyFile != "" {
+		if cctx.String("apns-key-id") == "" || cctx.String("apns-team-id") == "" {
+			return nil, fmt.Errorf("APNs needs --apns-key-id and --apns-team-id")
+		}
+		ap, err := NewAPNSPusher(keyFile, cctx.String("apns-key-id"), cctx.String("apns-team-id"), cctx.String("apns-topic"), cctx.Bool("apns-sandbox"))
+		if err != nil {
+			return nil, err
+		}
+		pushers["apns"] = ap
+	}
+
+	if credsFile := cctx.String("fcm-credentials-file"); credsFile != "" {
+		fp, err := NewFCMPusher(credsFile)
+		if err != nil {
+			return nil, err
+		}
+		pushers["fcm"] = fp
+	}
+
+	return pushers, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var registrations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bnotify_registrations_total",
+	Help: "Number of device registrations, by platform",
+}, []string{"platform"})
+
+var notificationsQueued = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bnotify_notifications_queued_total",
+	Help: "Number of notifications built from the firehose, by reason",
+}, []string{"reason"})
+
+var notificationsSkipped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "bnotify_notifications_skipped_total",
+	Help: "Number of notifications for accounts with no registered devices",
+})
+
+var deliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bnotify_deliveries_total",
+	Help: "Number of push deliveries to devices, by push service and result",
+}, []string{"pusher", "result"})
+
+var deliveryRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bnotify_delivery_retries_total",
+	Help: "Number of push deliveries retried after transient errors",
+}, []string{"pusher"})
+
+var batchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "bnotify_batch_duration_seconds",
+	Help:    "Time to look up and deliver a batch of notifications",
+	Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+})
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// Message is the platform-agnostic content of a push notification
+type Message struct {
+	Title string
+	Body  string
+	// Data is passed through to the app, for routing the tap to the right view
+	Data map[string]string
+	// AppId is the app the device token belongs to (the APNs topic)
+	AppId string
+}
+
+// Pusher delivers messages to devices on one push platform
+type Pusher interface {
+	Push(ctx context.Context, token string, msg *Message) error
+}
+
+// ErrTokenInvalid is wrapped by push errors meaning the device token should
+// be forgotten: the app was uninstalled, or the token was never valid
+var ErrTokenInvalid = errors.New("device token is no longer valid")
+
+// PushError is a failed delivery, as reported by the push service
+type PushError struct {
+	StatusCode int
+	Reason     string
+	// Retry is set for transient failures (rate limiting, service errors)
+	Retry      bool
+	RetryAfter time.Duration
+	// Invalid is set when the device token should be removed
+	Invalid bool
+}
+
+func (pe *PushError) Error() string {
+	return fmt.Sprintf("push failed (%d): %s", pe.StatusCode, pe.Reason)
+}
+
+func (pe *PushError) Unwrap() error {
+	if pe.Invalid {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+type DispatcherConfig struct {
+	// BatchSize is the most notifications looked up and sent together
+	BatchSize int
+	// BatchInterval is the longest a notification waits for its batch to fill
+	BatchInterval time.Duration
+	// Concurrency is the number of deliveries in flight at once
+	Concurrency int
+	// MaxAttempts is the number of tries for a delivery with transient errors
+	MaxAttempts int
+	// QueueSize is the number of notifications buffered ahead of delivery
+	QueueSize int
+}
+
+func DefaultDispatcherConfig() DispatcherConfig {
+	return DispatcherConfig{
+		BatchSize:     500,
+		BatchInterval: time.Second,
+		Concurrency:   50,
+		MaxAttempts:   4,
+		QueueSize:     10_000,
+	}
+}
+
+// Dispatcher batches notifications, looks up the recipients' devices, and
+// delivers to each device's push platform
+type Dispatcher struct {
+	store   *Store
+	dir     identity.Directory
+	pushers map[string]Pusher
+	cfg     DispatcherConfig
+	logger  *slog.Logger
+
+	queue chan *Notification
+}
+
+func NewDispatcher(store *Store, dir identity.Directory, pushers map[string]Pusher, cfg DispatcherConfig, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		store:   store,
+		dir:     dir,
+		pushers: pushers,
+		cfg:     cfg,
+		logger:  logger,
+		queue:   make(chan *Notification, cfg.QueueSize),
+	}
+}
+
+// Enqueue adds a notification for delivery, blocking if the queue is full
+func (d *Dispatcher) Enqueue(ctx context.Context, n *Notification) error {
+	select {
+	case d.queue <- n:
+		notificationsQueued.WithLabelValues(n.Reason).Inc()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run delivers batches of queued notifications until the context is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.BatchInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Notification, 0, d.cfg.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		d.deliverBatch(ctx, batch)
+		batch = make([]*Notification, 0, d.cfg.BatchSize)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-d.queue:
+			batch = append(batch, n)
+			if len(batch) >= d.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (d *Dispatcher) deliverBatch(ctx context.Context, batch []*Notification) {
+	start := time.Now()
+	defer func() {
+		batchDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	dids := make([]string, 0, len(batch))
+	seen := make(map[string]bool)
+	for _, n := range batch {
+		if !seen[n.Recipient] {
+			seen[n.Recipient] = true
+			dids = append(dids, n.Recipient)
+		}
+	}
+
+	subs, err := d.store.SubscriptionsFor(ctx, dids)
+	if err != nil {
+		d.logger.Error("failed to look up subscriptions", "err", err, "batch_size", len(batch))
+		return
+	}
+
+	sem := make(chan struct{}, d.cfg.Concurrency)
+	var wg sync.WaitGroup
+	for _, n := range batch {
+		devices := subs[n.Recipient]
+		if len(devices) == 0 {
+			notificationsSkipped.Inc()
+			continue
+		}
+
+		msg := d.buildMessage(ctx, n)
+		for _, sub := range devices {
+			pusher, ok := d.pushers[sub.Platform]
+			if !ok {
+				deliveries.WithLabelValues(sub.Platform, "unsupported").Inc()
+				continue
+			}
+
+			m := *msg
+			m.AppId = sub.AppId
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(sub *PushSubscription, pusher Pusher) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				d.deliver(ctx, sub, pusher, &m)
+			}(sub, pusher)
+		}
+	}
+	wg.Wait()
+}
+
+// deliver sends a message to one device, retrying transient failures with
+// backoff and removing tokens the platform reports as invalid
+func (d *Dispatcher) deliver(ctx context.Context, sub *PushSubscription, pusher Pusher, msg *Message) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := pusher.Push(ctx, sub.Token, msg)
+		if err == nil {
+			deliveries.WithLabelValues(sub.Platform, "delivered").Inc()
+			return
+		}
+
+		var pe *PushError
+		if errors.As(err, &pe) && pe.Invalid {
+			deliveries.WithLabelValues(sub.Platform, "invalid_token").Inc()
+			d.logger.Info("removing invalid device token", "did", sub.Did, "platform", sub.Platform, "reason", pe.Reason)
+			if err := d.store.RemoveToken(ctx, sub.Token); err != nil {
+				d.logger.Error("failed to remove device token", "did", sub.Did, "err", err)
+			}
+			return
+		}
+
+		// errors other than PushError are network failures, which are worth retrying
+		retry := pe == nil || pe.Retry
+		if !retry || attempt >= d.cfg.MaxAttempts {
+			deliveries.WithLabelValues(sub.Platform, "failed").Inc()
+			d.logger.Warn("push delivery failed", "did", sub.Did, "platform", sub.Platform, "attempts", attempt, "err", err)
+			return
+		}
+
+		wait := backoff
+		if pe != nil && pe.RetryAfter > wait {
+			wait = pe.RetryAfter
+		}
+		deliveryRetries.WithLabelValues(sub.Platform).Inc()
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+	}
+}
+
+func (d *Dispatcher) buildMessage(ctx context.Context, n *Notification) *Message {
+	author := n.Author
+	if did, err := syntax.ParseDID(n.Author); err == nil {
+		if ident, err := d.dir.LookupDID(ctx, did); err == nil && ident.Handle != syntax.HandleInvalid {
+			author = "@" + ident.Handle.String()
+		}
+	}
+
+	var title string
+	switch n.Reason {
+	case ReasonReply:
+		title = author + " replied to your post"
+	case ReasonMention:
+		title = author + " mentioned you"
+	case ReasonFollow:
+		title = author + " followed you"
+	}
+
+	return &Message{
+		Title: title,
+		Body:  truncate(n.Text, 200),
+		Data: map[string]string{
+			"reason":    n.Reason,
+			"uri":       n.Uri,
+			"author":    n.Author,
+			"recipient": n.Recipient,
+		},
+	}
+}
+
+// truncate shortens text to at most max runes, marking the cut with an ellipsis
+func truncate(text string, max int) string {
+	r := []rune(text)
+	if len(r) <= max {
+		return text
+	}
+	return string(r[:max-1]) + "…"
+}
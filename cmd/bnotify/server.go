@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/crypto/serviceauth"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	slogecho "github.com/samber/slog-echo"
+)
+
+const registerPushNSID = "app.bsky.notification.registerPush"
+
+// registerPush platforms, and the push service which delivers to each
+var platformPushers = map[string]string{
+	"ios":     "apns",
+	"android": "fcm",
+	"web":     "fcm",
+}
+
+// Server is the subscription registration API
+type Server struct {
+	store      *Store
+	validator  *serviceauth.Validator
+	serviceDID string
+	pushers    map[string]Pusher
+	logger     *slog.Logger
+	echo       *echo.Echo
+}
+
+type HealthStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"msg,omitempty"`
+}
+
+func NewServer(store *Store, validator *serviceauth.Validator, serviceDID string, pushers map[string]Pusher, logger *slog.Logger) *Server {
+	s := &Server{
+		store:      store,
+		validator:  validator,
+		serviceDID: serviceDID,
+		pushers:    pushers,
+		logger:     logger,
+	}
+
+	e := echo.New()
+	e.HideBanner = true
+	e.Use(slogecho.New(logger))
+	e.Use(middleware.Recover())
+	e.Use(middleware.BodyLimit("64K"))
+
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		code := 500
+		msg := "InternalServerError"
+		if he, ok := err.(*echo.HTTPError); ok {
+			code = he.Code
+			if m, ok := he.Message.(string); ok {
+				msg = m
+			}
+		}
+		if code >= 500 {
+			logger.Warn("HTTP request error", "statusCode", code, "path", c.Path(), "err", err)
+		}
+		c.JSON(code, map[string]string{"error": http.StatusText(code), "message": msg})
+	}
+
+	e.GET("/_health", s.handleHealthCheck)
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	e.POST("/xrpc/"+registerPushNSID, s.handleRegisterPush)
+	s.echo = e
+
+	return s
+}
+
+func (s *Server) RunAPI(listen string) error {
+	s.logger.Info("starting push registration API", "bind", listen)
+	return s.echo.Start(listen)
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.echo.Shutdown(ctx)
+}
+
+func (s *Server) handleHealthCheck(c echo.Context) error {
+	if err := s.store.db.Exec("SELECT 1").Error; err != nil {
+		s.logger.Error("healthcheck can't connect to database", "err", err)
+		return c.JSON(500, HealthStatus{Status: "error", Message: "can't connect to database"})
+	}
+	return c.JSON(200, HealthStatus{Status: "ok"})
+}
+
+// handleRegisterPush records a device token for the calling account. Calls
+// are authenticated with a service auth token issued by the account (usually
+// proxied through the account's PDS) and addressed to this service's DID.
+func (s *Server) handleRegisterPush(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	auth := c.Request().Header.Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || token == "" {
+		return echo.NewHTTPError(http.StatusUnauthorized, "service auth token required")
+	}
+	did, err := s.validator.Validate(ctx, token, registerPushNSID)
+	if errors.Is(err, serviceauth.ErrInvalidToken) {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, "failed to resolve token issuer")
+	}
+
+	var body appbsky.NotificationRegisterPush_Input
+	if err := c.Bind(&body); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if body.ServiceDid != s.serviceDID {
+		return echo.NewHTTPError(http.StatusBadRequest, "serviceDid does not match this service")
+	}
+	if body.Token == "" || len(body.Token) > 4096 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid device token")
+	}
+	pusher, ok := platformPushers[body.Platform]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "unsupported platform: "+body.Platform)
+	}
+	if _, ok := s.pushers[pusher]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "push delivery is not configured for platform: "+body.Platform)
+	}
+
+	sub := &PushSubscription{
+		Did:      did.String(),
+		Platform: pusher,
+		Token:    body.Token,
+		AppId:    body.AppId,
+	}
+	if err := s.store.Register(ctx, sub); err != nil {
+		return err
+	}
+	registrations.WithLabelValues(body.Platform).Inc()
+	s.logger.Info("registered device", "did", did, "platform", body.Platform, "app_id", body.AppId)
+
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maximum number of devices registered for a single account; registering
+// another drops the least recently registered
+const maxSubscriptionsPerDID = 25
+
+// PushSubscription is a device registered (via registerPush) to receive
+// notifications for an account
+type PushSubscription struct {
+	ID        uint   `gorm:"primarykey"`
+	Did       string `gorm:"index"`
+	Platform  string
+	Token     string `gorm:"uniqueIndex"`
+	AppId     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type LastSeq struct {
+	ID  uint `gorm:"primarykey"`
+	Seq int64
+}
+
+// Store persists push subscriptions and the firehose cursor
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) (*Store, error) {
+	if err := db.AutoMigrate(&PushSubscription{}, &LastSeq{}); err != nil {
+		return nil, fmt.Errorf("migrating subscription store: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Register adds a device for an account. Tokens are unique: re-registering a
+// token moves it to the given account (eg, after switching accounts in the
+// app).
+func (s *Store) Register(ctx context.Context, sub *PushSubscription) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "token"}},
+			DoUpdates: clause.AssignmentColumns([]string{"did", "platform", "app_id", "updated_at"}),
+		}).Create(sub).Error; err != nil {
+			return err
+		}
+
+		var stale []uint
+		if err := tx.Model(&PushSubscription{}).Where("did = ?", sub.Did).Order("updated_at desc").Offset(maxSubscriptionsPerDID).Pluck("id", &stale).Error; err != nil {
+			return err
+		}
+		if len(stale) > 0 {
+			return tx.Delete(&PushSubscription{}, stale).Error
+		}
+		return nil
+	})
+}
+
+// SubscriptionsFor returns the devices registered for any of the given
+// accounts, grouped by DID
+func (s *Store) SubscriptionsFor(ctx context.Context, dids []string) (map[string][]*PushSubscription, error) {
+	out := make(map[string][]*PushSubscription)
+	if len(dids) == 0 {
+		return out, nil
+	}
+
+	var subs []*PushSubscription
+	if err := s.db.WithContext(ctx).Where("did IN ?", dids).Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		out[sub.Did] = append(out[sub.Did], sub)
+	}
+	return out, nil
+}
+
+// RemoveToken deletes a device the push service reported as no longer valid
+func (s *Store) RemoveToken(ctx context.Context, token string) error {
+	return s.db.WithContext(ctx).Where("token = ?", token).Delete(&PushSubscription{}).Error
+}
+
+func (s *Store) GetCursor(ctx context.Context) (int64, error) {
+	var last LastSeq
+	if err := s.db.WithContext(ctx).Find(&last).Error; err != nil {
+		return 0, err
+	}
+	if last.ID == 0 {
+		return 0, s.db.WithContext(ctx).Create(&LastSeq{ID: 1}).Error
+	}
+	return last.Seq, nil
+}
+
+func (s *Store) UpdateCursor(ctx context.Context, seq int64) error {
+	return s.db.WithContext(ctx).Model(&LastSeq{}).Where("id = 1").Update("seq", seq).Error
+}
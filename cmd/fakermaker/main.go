@@ -54,6 +54,15 @@ func run(args []string) {
 			Usage:   "number of parallel threads to use",
 			Value:   runtime.NumCPU(),
 		},
+		&cli.Int64Flag{
+			Name:    "seed",
+			Usage:   "seed for reproducible generation (0 for random)",
+			EnvVars: []string{"FAKERMAKER_SEED"},
+		},
+	}
+	app.Before = func(cctx *cli.Context) error {
+		fakedata.SetSeed(cctx.Int64("seed"))
+		return nil
 	}
 	app.Commands = []*cli.Command{
 		&cli.Command{
@@ -148,11 +157,66 @@ func run(args []string) {
 					Usage: "portion of posts to include images",
 					Value: 0.25,
 				},
+				&cli.Float64Flag{
+					Name:  "frac-video",
+					Usage: "portion of posts to include a video (instead of images)",
+					Value: 0.05,
+				},
 				&cli.Float64Flag{
 					Name:  "frac-mention",
 					Usage: "of posts created, fraction to include mentions in",
 					Value: 0.50,
 				},
+				&cli.Float64Flag{
+					Name:  "frac-threadgate",
+					Usage: "portion of posts to restrict replies to with a threadgate",
+					Value: 0.05,
+				},
+			},
+		},
+		&cli.Command{
+			Name:   "gen-lists",
+			Usage:  "creates lists and starter packs for accounts",
+			Action: genLists,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "catalog",
+					Usage: "file path of account catalog JSON file",
+					Value: "data/fakermaker/accounts.json",
+				},
+				&cli.IntFlag{
+					Name:  "max-lists",
+					Usage: "create up to this many lists for each account",
+					Value: 2,
+				},
+				&cli.IntFlag{
+					Name:  "max-list-size",
+					Usage: "add up to this many accounts to each list",
+					Value: 20,
+				},
+				&cli.IntFlag{
+					Name:  "max-starter-packs",
+					Usage: "create up to this many starter packs for each celebrity account",
+					Value: 1,
+				},
+			},
+		},
+		&cli.Command{
+			Name:   "gen-labelers",
+			Usage:  "declares some regular accounts as labelers",
+			Action: genLabelers,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "catalog",
+					Usage: "file path of account catalog JSON file",
+					Value: "data/fakermaker/accounts.json",
+				},
+				&cli.IntFlag{
+					Name:    "count",
+					Aliases: []string{"n"},
+					Usage:   "number of accounts (the first regular accounts in the catalog) to declare as labelers",
+					Value:   3,
+				},
 			},
 		},
 		&cli.Command{
@@ -347,7 +411,9 @@ func genPosts(cctx *cli.Context) error {
 	pdsHost := cctx.String("pds-host")
 	maxPosts := cctx.Int("max-posts")
 	fracImage := cctx.Float64("frac-image")
+	fracVideo := cctx.Float64("frac-video")
 	fracMention := cctx.Float64("frac-mention")
+	fracThreadgate := cctx.Float64("frac-threadgate")
 	jobs := cctx.Int("jobs")
 
 	accChan := make(chan fakedata.AccountContext, len(catalog.Celebs)+len(catalog.Regulars))
@@ -359,7 +425,7 @@ func genPosts(cctx *cli.Context) error {
 				if err != nil {
 					return err
 				}
-				if err = fakedata.GenPosts(xrpcc, catalog, &acc, maxPosts, fracImage, fracMention); err != nil {
+				if err = fakedata.GenPosts(xrpcc, catalog, &acc, maxPosts, fracImage, fracVideo, fracMention, fracThreadgate); err != nil {
 					return err
 				}
 			}
@@ -374,6 +440,73 @@ func genPosts(cctx *cli.Context) error {
 	return eg.Wait()
 }
 
+func genLists(cctx *cli.Context) error {
+	catalog, err := fakedata.ReadAccountCatalog(cctx.String("catalog"))
+	if err != nil {
+		return err
+	}
+
+	pdsHost := cctx.String("pds-host")
+	maxLists := cctx.Int("max-lists")
+	maxListSize := cctx.Int("max-list-size")
+	maxPacks := cctx.Int("max-starter-packs")
+	jobs := cctx.Int("jobs")
+
+	accChan := make(chan fakedata.AccountContext, len(catalog.Celebs)+len(catalog.Regulars))
+	eg := new(errgroup.Group)
+	for i := 0; i < jobs; i++ {
+		eg.Go(func() error {
+			for acc := range accChan {
+				xrpcc, err := fakedata.AccountXrpcClient(pdsHost, &acc)
+				if err != nil {
+					return err
+				}
+				if err = fakedata.GenLists(xrpcc, catalog, &acc, maxLists, maxListSize); err != nil {
+					return err
+				}
+				// starter packs are mostly made by well-followed accounts
+				if acc.AccountType == "celebrity" {
+					if err = fakedata.GenStarterPacks(xrpcc, catalog, &acc, maxPacks, maxListSize); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+	}
+
+	for _, acc := range append(catalog.Celebs, catalog.Regulars...) {
+		accChan <- acc
+	}
+	close(accChan)
+	return eg.Wait()
+}
+
+func genLabelers(cctx *cli.Context) error {
+	catalog, err := fakedata.ReadAccountCatalog(cctx.String("catalog"))
+	if err != nil {
+		return err
+	}
+
+	count := cctx.Int("count")
+	if count > len(catalog.Regulars) {
+		return fmt.Errorf("not enough regular accounts for %d labelers", count)
+	}
+
+	pdsHost := cctx.String("pds-host")
+	for i := 0; i < count; i++ {
+		acc := catalog.Regulars[i]
+		xrpcc, err := fakedata.AccountXrpcClient(pdsHost, &acc)
+		if err != nil {
+			return err
+		}
+		if err := fakedata.GenLabeler(xrpcc, &acc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func genInteractions(cctx *cli.Context) error {
 	catalog, err := fakedata.ReadAccountCatalog(cctx.String("catalog"))
 	if err != nil {
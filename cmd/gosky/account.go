@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
 
 	cli "github.com/urfave/cli/v2"
 )
@@ -18,11 +20,15 @@ var accountCmd = &cli.Command{
 	Usage: "sub-commands for auth session and account management",
 	Subcommands: []*cli.Command{
 		createSessionCmd,
+		oauthLoginCmd,
+		oauthLogoutCmd,
 		newAccountCmd,
 		refreshAuthTokenCmd,
 		resetPasswordCmd,
 		requestAccountDeletionCmd,
 		deleteAccountCmd,
+		deactivateAccountCmd,
+		migrateAccountCmd,
 	},
 }
 
@@ -213,3 +219,115 @@ var deleteAccountCmd = &cli.Command{
 		return nil
 	},
 }
+
+var deactivateAccountCmd = &cli.Command{
+	Name:  "deactivate",
+	Usage: "deactivate the logged-in account on its PDS (dry run, then --execute)",
+	Description: `Without --execute, writes the deactivation request to the --plan file,
+printing what it does, without submitting anything. Running again with
+--execute and the same --plan submits it, after the account's DID is typed
+in to confirm. With --delay on the dry run, the plan can only be executed
+once the delay has passed; plans expire a day after that.`,
+	Flags: append([]cli.Flag{
+		&cli.TimestampFlag{
+			Name:   "delete-after",
+			Usage:  "ask the PDS to delete the account if it is still deactivated after this time (RFC 3339)",
+			Layout: time.RFC3339,
+		},
+	}, guardedFlags...),
+	Action: func(cctx *cli.Context) error {
+		ctx := cctx.Context
+		xrpcc, err := cliutil.GetXrpcClient(cctx, true)
+		if err != nil {
+			return err
+		}
+		did := xrpcc.Auth.Did
+
+		if !cctx.Bool("execute") {
+			body := map[string]any{}
+			if t := cctx.Timestamp("delete-after"); t != nil {
+				body["deleteAfter"] = t.UTC().Format(time.RFC3339)
+			}
+			return writeGuardedPlan(cctx, "deactivate-account", did, body, deactivateWarnings(xrpcc.Host, body))
+		}
+
+		plan, err := readGuardedPlan(cctx, "deactivate-account", did)
+		if err != nil {
+			return err
+		}
+		var body map[string]any
+		if err := json.Unmarshal(plan.Operation, &body); err != nil {
+			return fmt.Errorf("parsing plan operation: %w", err)
+		}
+		if err := confirmGuardedPlan(plan, deactivateWarnings(xrpcc.Host, body)); err != nil {
+			return err
+		}
+		if err := xrpcc.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.server.deactivateAccount", nil, body, nil); err != nil {
+			return err
+		}
+		finishGuardedPlan(cctx)
+
+		fmt.Println("account deactivated")
+		return nil
+	},
+}
+
+func deactivateWarnings(host string, body map[string]any) []string {
+	out := []string{
+		fmt.Sprintf("the account's repository stops being served by %s", host),
+		"relays and apps hide the account and everything it has posted while it is deactivated",
+	}
+	if da, ok := body["deleteAfter"]; ok {
+		out = append(out, fmt.Sprintf("the PDS may PERMANENTLY DELETE the account and all its data after %v, unless it is reactivated before then", da))
+	} else {
+		out = append(out, "it can be undone by reactivating the account (com.atproto.server.activateAccount)")
+	}
+	return out
+}
+
+var oauthLoginCmd = &cli.Command{
+	Name:      "login",
+	Usage:     "log in with OAuth in a web browser, instead of using an app password",
+	ArgsUsage: `<handle-or-did>`,
+	Action: func(cctx *cli.Context) error {
+		args, err := needArgs(cctx, "handle-or-did")
+		if err != nil {
+			return err
+		}
+
+		fname := cctx.String("oauth-session")
+		if fname == "" {
+			return fmt.Errorf("no --oauth-session path to store the login in")
+		}
+
+		sess, err := cliutil.OAuthLogin(cctx.Context, args[0], func(authURL string) {
+			fmt.Fprintf(os.Stderr, "open this URL in your browser to log in:\n\n  %s\n\n", authURL)
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := sess.Save(fname); err != nil {
+			return err
+		}
+
+		fmt.Printf("logged in as %s (%s) on %s\n", sess.Handle, sess.Did, sess.PDS)
+		return nil
+	},
+}
+
+var oauthLogoutCmd = &cli.Command{
+	Name:  "logout",
+	Usage: "remove the stored OAuth session",
+	Action: func(cctx *cli.Context) error {
+		fname := cctx.String("oauth-session")
+		if fname == "" {
+			return nil
+		}
+
+		if err := os.Remove(fname); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	},
+}
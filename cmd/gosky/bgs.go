@@ -34,6 +34,7 @@ var bgsAdminCmd = &cli.Command{
 		bgsSetNewSubsEnabledCmd,
 		bgsCompactRepo,
 		bgsCompactAll,
+		bgsCollectGarbage,
 		bgsResetRepo,
 	},
 }
@@ -360,6 +361,73 @@ var bgsCompactRepo = &cli.Command{
 	},
 }
 
+var bgsCollectGarbage = &cli.Command{
+	Name:      "gc-repo",
+	Usage:     "reclaim space used by blocks no longer referenced by the repo",
+	ArgsUsage: "<did>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "dry",
+			Usage: "only report what would be reclaimed",
+		},
+		&cli.DurationFlag{
+			Name:  "window",
+			Usage: "leave shards written more recently than this alone",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		uu, err := url.Parse(cctx.String("bgs") + "/admin/repo/gc")
+		if err != nil {
+			return err
+		}
+
+		q := uu.Query()
+		did := cctx.Args().First()
+		q.Add("did", did)
+
+		if cctx.Bool("dry") {
+			q.Add("dry", "true")
+		}
+
+		if cctx.IsSet("window") {
+			q.Add("window", cctx.Duration("window").String())
+		}
+
+		uu.RawQuery = q.Encode()
+
+		req, err := http.NewRequest("POST", uu.String(), nil)
+		if err != nil {
+			return err
+		}
+
+		auth := cctx.String("key")
+		req.Header.Set("Authorization", "Bearer "+auth)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != 200 {
+			var e xrpc.XRPCError
+			if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+				return err
+			}
+
+			return &e
+		}
+
+		var out map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return err
+		}
+
+		fmt.Println(out)
+
+		return nil
+	},
+}
+
 var bgsCompactAll = &cli.Command{
 	Name: "compact-all",
 	Flags: []cli.Flag{
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/ipfs/go-cid"
+	cli "github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+)
+
+// blobUsage is a blob referenced from one or more records in a repo.
+type blobUsage struct {
+	MimeType string
+	Size     int64
+	Refs     []string
+}
+
+var syncAuditBlobsCmd = &cli.Command{
+	Name:      "audit-blobs",
+	Usage:     "check that every blob referenced in an account's repo is available from its PDS",
+	ArgsUsage: `<at-identifier>`,
+	Description: `Downloads the repo, finds every blob reference in its records, and checks
+each one against the PDS. By default this is a HEAD request comparing size
+and MIME type; with --verify-content each blob is downloaded and its CID
+recomputed.
+
+Also reports blobs which the PDS lists for the account (listBlobs) but are
+not referenced from any record.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name: "host",
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "number of blobs to check in parallel",
+			Value: 4,
+		},
+		&cli.BoolFlag{
+			Name:  "verify-content",
+			Usage: "download each blob and check its hash",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		args, err := needArgs(cctx, "at-identifier")
+		if err != nil {
+			return err
+		}
+		atid, err := syntax.ParseAtIdentifier(args[0])
+		if err != nil {
+			return err
+		}
+		ident, err := identity.DefaultDirectory().Lookup(ctx, *atid)
+		if err != nil {
+			return err
+		}
+
+		xrpcc, err := cliutil.GetXrpcClient(cctx, false)
+		if err != nil {
+			return err
+		}
+		xrpcc.Host = ident.PDSEndpoint()
+		if h := cctx.String("host"); h != "" {
+			xrpcc.Host = h
+		}
+		if xrpcc.Host == "" {
+			return fmt.Errorf("no PDS endpoint for identity")
+		}
+		did := ident.DID.String()
+
+		repoBytes, err := comatproto.SyncGetRepo(ctx, xrpcc, did, "")
+		if err != nil {
+			return fmt.Errorf("fetching repo: %w", err)
+		}
+		usage, err := repoBlobUsage(ctx, repoBytes, did)
+		if err != nil {
+			return err
+		}
+
+		listed := make(map[string]bool)
+		var cursor string
+		for {
+			resp, err := comatproto.SyncListBlobs(ctx, xrpcc, cursor, did, 500, "")
+			if err != nil {
+				return fmt.Errorf("listing blobs: %w", err)
+			}
+			for _, c := range resp.Cids {
+				listed[c] = true
+			}
+			if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Cids) == 0 {
+				break
+			}
+			cursor = *resp.Cursor
+		}
+
+		var cids []string
+		for c := range usage {
+			cids = append(cids, c)
+		}
+		sort.Strings(cids)
+
+		verify := cctx.Bool("verify-content")
+		var lk sync.Mutex
+		problems := make(map[string]string)
+		eg := new(errgroup.Group)
+		eg.SetLimit(cctx.Int("concurrency"))
+		for _, c := range cids {
+			c := c
+			eg.Go(func() error {
+				var msg string
+				if verify {
+					msg = verifyBlobContent(ctx, xrpcc, did, c, usage[c])
+				} else {
+					msg = checkBlobHead(ctx, xrpcc, did, c, usage[c])
+				}
+				if msg != "" {
+					lk.Lock()
+					problems[c] = msg
+					lk.Unlock()
+				}
+				return nil
+			})
+		}
+		eg.Wait()
+
+		for _, c := range cids {
+			msg, bad := problems[c]
+			if !bad && !listed[c] {
+				msg = "not included in listBlobs"
+			}
+			if msg == "" {
+				continue
+			}
+			fmt.Printf("%s\t%s\n", c, msg)
+			for _, ref := range usage[c].Refs {
+				fmt.Printf("\treferenced by %s\n", ref)
+			}
+		}
+
+		var unreferenced []string
+		for c := range listed {
+			if _, ok := usage[c]; !ok {
+				unreferenced = append(unreferenced, c)
+			}
+		}
+		sort.Strings(unreferenced)
+		for _, c := range unreferenced {
+			fmt.Printf("%s\tlisted by PDS but not referenced by any record\n", c)
+		}
+
+		fmt.Printf("\n%d blobs referenced, %d listed by PDS, %d problems, %d unreferenced\n", len(usage), len(listed), len(problems), len(unreferenced))
+		if len(problems) > 0 {
+			return fmt.Errorf("%d blobs missing or mismatched", len(problems))
+		}
+		return nil
+	},
+}
+
+// repoBlobUsage walks every record in a repo CAR and collects its blob
+// references, keyed by CID string.
+func repoBlobUsage(ctx context.Context, repoBytes []byte, did string) (map[string]*blobUsage, error) {
+	blocks, err := readCarBlocks(repoBytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading repo CAR: %w", err)
+	}
+	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(repoBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading repo CAR: %w", err)
+	}
+
+	out := make(map[string]*blobUsage)
+	err = r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		blk, ok := blocks[v]
+		if !ok {
+			return fmt.Errorf("record block missing from CAR: %s", k)
+		}
+		rec, err := data.UnmarshalCBOR(blk)
+		if err != nil {
+			return fmt.Errorf("decoding %s: %w", k, err)
+		}
+		refs, err := data.ExtractBlobs(rec)
+		if err != nil {
+			return fmt.Errorf("finding blobs in %s: %w", k, err)
+		}
+		for _, ref := range refs {
+			c := ref.Ref.String()
+			u, ok := out[c]
+			if !ok {
+				u = &blobUsage{MimeType: ref.MimeType, Size: ref.Size}
+				out[c] = u
+			}
+			u.Refs = append(u.Refs, "at://"+did+"/"+k+" "+ref.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// checkBlobHead does a HEAD request for a blob, returning a description of
+// any problem, or an empty string if it looks fine.
+func checkBlobHead(ctx context.Context, xrpcc *xrpc.Client, did, c string, u *blobUsage) string {
+	q := url.Values{"did": {did}, "cid": {c}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, xrpcc.Host+"/xrpc/com.atproto.sync.getBlob?"+q.Encode(), nil)
+	if err != nil {
+		return err.Error()
+	}
+	hc := xrpcc.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return fmt.Sprintf("request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusMethodNotAllowed:
+		// not every PDS supports HEAD; fall back to fetching it
+		return verifyBlobContent(ctx, xrpcc, did, c, u)
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest:
+		return "missing"
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+
+	if l := resp.Header.Get("Content-Length"); l != "" && u.Size > 0 {
+		if n, err := strconv.ParseInt(l, 10, 64); err == nil && n != u.Size {
+			return fmt.Sprintf("size %d, but record says %d", n, u.Size)
+		}
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && u.MimeType != "" && ct != u.MimeType {
+		return fmt.Sprintf("content type %s, but record says %s", ct, u.MimeType)
+	}
+	return ""
+}
+
+// verifyBlobContent downloads a blob and checks that it hashes to its CID.
+func verifyBlobContent(ctx context.Context, xrpcc *xrpc.Client, did, c string, u *blobUsage) string {
+	expect, err := cid.Decode(c)
+	if err != nil {
+		return fmt.Sprintf("invalid CID: %s", err)
+	}
+	b, err := comatproto.SyncGetBlob(ctx, xrpcc, c, did)
+	if err != nil {
+		var xerr *xrpc.XRPCError
+		if errors.As(err, &xerr) && xerr.ErrStr == "BlobNotFound" {
+			return "missing"
+		}
+		return fmt.Sprintf("download failed: %s", err)
+	}
+
+	got, err := expect.Prefix().Sum(b)
+	if err != nil {
+		return fmt.Sprintf("hashing: %s", err)
+	}
+	if !got.Equals(expect) {
+		return fmt.Sprintf("content hashes to %s", got)
+	}
+	if u.Size > 0 && int64(len(b)) != u.Size {
+		return fmt.Sprintf("size %d, but record says %d", len(b), u.Size)
+	}
+	return ""
+}
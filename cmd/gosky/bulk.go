@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/lexicon"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+// maximum number of writes the PDS accepts in a single applyWrites call
+const maxApplyWritesOps = 200
+
+var applyWritesCmd = &cli.Command{
+	Name:  "apply-writes",
+	Usage: "submit a JSONL file of create/update/delete operations in batched applyWrites calls",
+	Description: `Each line of the input file is a JSON object like:
+
+   {"action": "create", "collection": "app.bsky.feed.post", "rkey": "optional", "value": {...}}
+   {"action": "update", "collection": "app.bsky.feed.post", "rkey": "3k...", "value": {...}}
+   {"action": "delete", "collection": "app.bsky.feed.post", "rkey": "3k..."}
+
+All operations are validated before any are submitted.`,
+	ArgsUsage: `<jsonl-file>`,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "batch-size",
+			Usage: "number of operations per applyWrites call",
+			Value: maxApplyWritesOps,
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "validate and print batches, but don't submit anything",
+		},
+		&cli.StringFlag{
+			Name:  "lexicons",
+			Usage: "directory of lexicon schemas to validate record values against",
+		},
+		&cli.BoolFlag{
+			Name:  "no-server-validate",
+			Usage: "ask the PDS to skip lexicon validation of records",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		args, err := needArgs(cctx, "jsonl-file")
+		if err != nil {
+			return err
+		}
+
+		batchSize := cctx.Int("batch-size")
+		if batchSize < 1 || batchSize > maxApplyWritesOps {
+			return fmt.Errorf("batch size must be between 1 and %d", maxApplyWritesOps)
+		}
+
+		cat, err := loadLexiconCatalog(cctx.String("lexicons"))
+		if err != nil {
+			return err
+		}
+
+		writes, err := readBulkWrites(args[0], cat)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "validated %d operations\n", len(writes))
+
+		dryRun := cctx.Bool("dry-run")
+		var xrpcc *xrpc.Client
+		if !dryRun {
+			xrpcc, err = cliutil.GetXrpcClient(cctx, true)
+			if err != nil {
+				return err
+			}
+		}
+
+		validate := !cctx.Bool("no-server-validate")
+		for start := 0; start < len(writes); start += batchSize {
+			end := start + batchSize
+			if end > len(writes) {
+				end = len(writes)
+			}
+			if dryRun {
+				fmt.Printf("batch %d-%d:\n", start+1, end)
+				for _, w := range writes[start:end] {
+					fmt.Printf("\t%s %s/%s\n", w.Action, w.Collection, w.Rkey)
+				}
+				continue
+			}
+
+			body := map[string]any{
+				"repo":     xrpcc.Auth.Did,
+				"validate": validate,
+				"writes":   applyWritesBody(writes[start:end]),
+			}
+			if err := xrpcc.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.repo.applyWrites", nil, body, nil); err != nil {
+				return fmt.Errorf("applyWrites failed for operations %d-%d (earlier batches were committed): %w", start+1, end, err)
+			}
+			fmt.Fprintf(os.Stderr, "committed operations %d-%d\n", start+1, end)
+		}
+		return nil
+	},
+}
+
+// loadLexiconCatalog loads all schemas in dir, or returns a nil catalog if
+// dir is empty.
+func loadLexiconCatalog(dir string) (lexicon.Catalog, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	bc := lexicon.NewBaseCatalog()
+	if err := bc.LoadDirectory(dir); err != nil {
+		return nil, err
+	}
+	return &bc, nil
+}
+
+type bulkWrite struct {
+	Action     string          `json:"action"`
+	Collection string          `json:"collection"`
+	Rkey       string          `json:"rkey,omitempty"`
+	Value      json.RawMessage `json:"value,omitempty"`
+}
+
+func readBulkWrites(path string, cat lexicon.Catalog) ([]bulkWrite, error) {
+	fi, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	var out []bulkWrite
+	scan := bufio.NewScanner(fi)
+	// records can be larger than the default line limit
+	scan.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	line := 0
+	for scan.Scan() {
+		line++
+		if len(scan.Bytes()) == 0 {
+			continue
+		}
+		var w bulkWrite
+		if err := json.Unmarshal(scan.Bytes(), &w); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := w.normalize(cat); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		out = append(out, w)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// validates the operation, and fills in the record $type if it was missing
+func (w *bulkWrite) normalize(cat lexicon.Catalog) error {
+	if _, err := syntax.ParseNSID(w.Collection); err != nil {
+		return fmt.Errorf("invalid collection: %w", err)
+	}
+	if w.Rkey != "" {
+		if _, err := syntax.ParseRecordKey(w.Rkey); err != nil {
+			return fmt.Errorf("invalid rkey: %w", err)
+		}
+	}
+
+	switch w.Action {
+	case "create", "update":
+		if w.Action == "update" && w.Rkey == "" {
+			return fmt.Errorf("update operations require an rkey")
+		}
+		if len(w.Value) == 0 {
+			return fmt.Errorf("%s operations require a record value", w.Action)
+		}
+		rec, err := data.UnmarshalJSON(w.Value)
+		if err != nil {
+			return fmt.Errorf("invalid record value: %w", err)
+		}
+		if typ, ok := rec["$type"]; !ok {
+			rec["$type"] = w.Collection
+		} else if typ != w.Collection {
+			return fmt.Errorf("record $type does not match collection: %v", typ)
+		}
+		if cat != nil {
+			if _, err := lexicon.ValidateRecord(cat, rec, w.Collection, lexicon.StrictMode); err != nil {
+				return fmt.Errorf("record failed lexicon validation: %w", err)
+			}
+		}
+		b, err := data.MarshalCanonicalJSON(rec)
+		if err != nil {
+			return err
+		}
+		w.Value = b
+	case "delete":
+		if w.Rkey == "" {
+			return fmt.Errorf("delete operations require an rkey")
+		}
+		if len(w.Value) != 0 {
+			return fmt.Errorf("delete operations can not have a record value")
+		}
+	default:
+		return fmt.Errorf("unknown action: %q", w.Action)
+	}
+	return nil
+}
+
+func applyWritesBody(writes []bulkWrite) []any {
+	var out []any
+	for _, w := range writes {
+		op := map[string]any{
+			"$type":      "com.atproto.repo.applyWrites#" + w.Action,
+			"collection": w.Collection,
+		}
+		if w.Rkey != "" {
+			op["rkey"] = w.Rkey
+		}
+		if w.Action != "delete" {
+			op["value"] = w.Value
+		}
+		out = append(out, op)
+	}
+	return out
+}
@@ -7,9 +7,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/mst"
 	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util"
 
 	"github.com/ipfs/go-cid"
 	cli "github.com/urfave/cli/v2"
@@ -19,6 +25,7 @@ var carCmd = &cli.Command{
 	Name:  "car",
 	Usage: "sub-commands to work with CAR files on local disk",
 	Subcommands: []*cli.Command{
+		carInspectCmd,
 		carUnpackCmd,
 	},
 }
@@ -123,3 +130,145 @@ var carUnpackCmd = &cli.Command{
 		return nil
 	},
 }
+
+var carInspectCmd = &cli.Command{
+	Name:  "inspect",
+	Usage: "summarize a repo export CAR file: commit, signature, MST shape and record counts",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "no-verify",
+			Usage: "skip resolving the DID to verify the commit signature",
+		},
+		&cli.StringSliceFlag{
+			Name:  "dump",
+			Usage: "print records as JSON, by path (collection/rkey) or whole collection",
+		},
+	},
+	ArgsUsage: `<car-file>`,
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		args, err := needArgs(cctx, "car-file")
+		if err != nil {
+			return err
+		}
+
+		carBytes, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+
+		// count blocks separately; the repo parser doesn't keep track of them
+		blocks, err := readCarBlocks(carBytes)
+		if err != nil {
+			return fmt.Errorf("reading CAR blocks: %w", err)
+		}
+
+		r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(carBytes))
+		if err != nil {
+			return err
+		}
+		sc := r.SignedCommit()
+
+		fmt.Printf("file:      %s (%d bytes, %d blocks)\n", args[0], len(carBytes), len(blocks))
+		fmt.Printf("did:       %s\n", sc.Did)
+		fmt.Printf("version:   %d\n", sc.Version)
+		fmt.Printf("rev:       %s\n", sc.Rev)
+		fmt.Printf("data:      %s\n", sc.Data)
+		if sc.Prev != nil {
+			fmt.Printf("prev:      %s\n", sc.Prev)
+		}
+
+		if cctx.Bool("no-verify") {
+			fmt.Println("signature: not checked")
+		} else {
+			fmt.Printf("signature: %s\n", verifyCommitSignature(ctx, &sc))
+		}
+
+		tree := mst.LoadMST(util.CborStore(r.Blockstore()), sc.Data)
+		st, err := tree.Stats(ctx)
+		if err != nil {
+			return fmt.Errorf("walking MST: %w", err)
+		}
+		fmt.Printf("mst:       %d nodes, %d leaves, depth %d, max fanout %d\n", st.Nodes, st.Leaves, st.Depth, st.MaxFanout)
+
+		counts := make(map[string]int)
+		err = r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+			coll, _, _ := strings.Cut(k, "/")
+			counts[coll]++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		var colls []string
+		for c := range counts {
+			colls = append(colls, c)
+		}
+		sort.Strings(colls)
+		fmt.Println("collections:")
+		for _, c := range colls {
+			fmt.Printf("  %-40s %d\n", c, counts[c])
+		}
+
+		for _, d := range cctx.StringSlice("dump") {
+			// a bare collection NSID dumps every record in it
+			exact := strings.Contains(d, "/")
+			prefix := d
+			if !exact {
+				prefix = d + "/"
+			}
+			found := false
+			err := r.ForEach(ctx, prefix, func(k string, v cid.Cid) error {
+				if (exact && k != d) || !strings.HasPrefix(k, prefix) {
+					return repo.ErrDoneIterating
+				}
+				found = true
+				blk, ok := blocks[v]
+				if !ok {
+					return fmt.Errorf("record block missing from CAR: %s", k)
+				}
+				rec, err := data.CBORToJSON(blk)
+				if err != nil {
+					return fmt.Errorf("decoding %s: %w", k, err)
+				}
+				fmt.Printf("\n%s (%s):\n%s\n", k, v, rec)
+				if exact {
+					return repo.ErrDoneIterating
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if !found {
+				fmt.Printf("\n%s: no records found\n", d)
+			}
+		}
+		return nil
+	},
+}
+
+// verifyCommitSignature checks the commit against the signing key in the
+// DID's current identity, and returns a human readable result.
+func verifyCommitSignature(ctx context.Context, sc *repo.SignedCommit) string {
+	did, err := syntax.ParseDID(sc.Did)
+	if err != nil {
+		return fmt.Sprintf("INVALID (bad DID: %s)", err)
+	}
+	ident, err := identity.DefaultDirectory().LookupDID(ctx, did)
+	if err != nil {
+		return fmt.Sprintf("UNKNOWN (resolving DID: %s)", err)
+	}
+	pub, err := ident.PublicKey()
+	if err != nil {
+		return fmt.Sprintf("UNKNOWN (no signing key: %s)", err)
+	}
+	unsigned, err := sc.Unsigned().BytesForSigning()
+	if err != nil {
+		return fmt.Sprintf("INVALID (encoding commit: %s)", err)
+	}
+	if err := pub.HashAndVerify(unsigned, sc.Sig); err != nil {
+		return fmt.Sprintf("INVALID (%s)", err)
+	}
+	return "valid (current key for " + did.String() + ")"
+}
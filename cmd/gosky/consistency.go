@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/gorilla/websocket"
+	"github.com/ipfs/go-cid"
+	cli "github.com/urfave/cli/v2"
+)
+
+var syncCheckStreamCmd = &cli.Command{
+	Name:      "check-stream",
+	Usage:     "watch a repo on the firehose and check that its commits chain correctly",
+	ArgsUsage: `<at-identifier> [<stream-url>]`,
+	Description: `Subscribes to a firehose (the account's PDS by default, or a relay) and
+follows commits for one repo, while periodically polling the PDS for the
+latest commit. Reports:
+
+  - commits whose "since" doesn't match the previously seen rev (gaps)
+  - revs which don't increase
+  - commit blocks which are missing, mismatched or badly signed
+  - commits the PDS reports which never showed up on the stream
+
+Problems are printed as they are found, and a summary is printed on exit.`,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "interval",
+			Usage: "how often to poll the PDS for the latest commit",
+			Value: 30 * time.Second,
+		},
+		&cli.Int64Flag{
+			Name:  "cursor",
+			Usage: "firehose sequence number to start from",
+			Value: -1,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		args, err := needArgs(cctx, "at-identifier")
+		if err != nil {
+			return err
+		}
+		atid, err := syntax.ParseAtIdentifier(args[0])
+		if err != nil {
+			return err
+		}
+
+		dir := identity.DefaultDirectory()
+		ident, err := dir.Lookup(ctx, *atid)
+		if err != nil {
+			return err
+		}
+		pds := ident.PDSEndpoint()
+		if pds == "" {
+			return fmt.Errorf("no PDS endpoint for identity")
+		}
+
+		u := cctx.Args().Get(1)
+		if u == "" {
+			u = pds
+		}
+		u = strings.Replace(u, "https://", "wss://", 1)
+		u = strings.Replace(u, "http://", "ws://", 1)
+		if !strings.Contains(u, "subscribeRepos") {
+			u = strings.TrimSuffix(u, "/") + "/xrpc/com.atproto.sync.subscribeRepos"
+		}
+		if c := cctx.Int64("cursor"); c >= 0 {
+			u = fmt.Sprintf("%s?cursor=%d", u, c)
+		}
+
+		sc := &streamChecker{
+			did:  ident.DID,
+			dir:  dir,
+			xrpc: &xrpc.Client{Client: cliutil.NewHttpClient(), Host: pds},
+		}
+		if sc.key, err = ident.PublicKey(); err != nil {
+			sc.report("no signing key for identity, signatures won't be checked: %s", err)
+		}
+
+		fmt.Fprintf(os.Stderr, "watching %s (PDS %s)\n", ident.DID, pds)
+		fmt.Fprintln(os.Stderr, "dialing: ", u)
+		con, _, err := websocket.DefaultDialer.Dial(u, http.Header{})
+		if err != nil {
+			return fmt.Errorf("dial failure: %w", err)
+		}
+		go func() {
+			<-ctx.Done()
+			_ = con.Close()
+		}()
+
+		go sc.pollLoop(ctx, cctx.Duration("interval"))
+
+		did := ident.DID.String()
+		rsc := &events.RepoStreamCallbacks{
+			RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
+				if evt.Repo == did {
+					sc.checkCommit(ctx, evt)
+				}
+				return nil
+			},
+			RepoHandle: func(evt *comatproto.SyncSubscribeRepos_Handle) error {
+				if evt.Did == did {
+					sc.refreshIdentity(ctx)
+					fmt.Printf("%s seq=%d handle changed to %s\n", evt.Time, evt.Seq, evt.Handle)
+				}
+				return nil
+			},
+			RepoTombstone: func(evt *comatproto.SyncSubscribeRepos_Tombstone) error {
+				if evt.Did == did {
+					sc.lockedReport("seq=%d repo tombstoned", evt.Seq)
+				}
+				return nil
+			},
+			RepoInfo: func(info *comatproto.SyncSubscribeRepos_Info) error {
+				fmt.Fprintf(os.Stderr, "INFO: %s: %v\n", info.Name, info.Message)
+				return nil
+			},
+			Error: func(errf *events.ErrorFrame) error {
+				return fmt.Errorf("error frame: %s: %s", errf.Error, errf.Message)
+			},
+		}
+		seqScheduler := sequential.NewScheduler(con.RemoteAddr().String(), rsc.EventHandler)
+		err = events.HandleRepoStream(ctx, con, seqScheduler)
+
+		sc.printSummary()
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	},
+}
+
+// streamChecker tracks the commit chain for a single repo, as seen on the
+// firehose and by polling the PDS.
+type streamChecker struct {
+	did  syntax.DID
+	dir  identity.Directory
+	xrpc *xrpc.Client
+
+	lk       sync.Mutex
+	key      crypto.PublicKey
+	lastRev  string
+	lastCid  string
+	commits  int
+	problems int
+
+	// a rev the PDS reported which the stream hadn't caught up to yet
+	pendingRev   string
+	pendingSince time.Time
+}
+
+func (sc *streamChecker) report(format string, args ...any) {
+	sc.problems++
+	fmt.Printf("%s PROBLEM: %s\n", time.Now().UTC().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
+
+func (sc *streamChecker) refreshIdentity(ctx context.Context) {
+	if err := sc.dir.Purge(ctx, sc.did.AtIdentifier()); err != nil {
+		return
+	}
+	ident, err := sc.dir.LookupDID(ctx, sc.did)
+	if err != nil {
+		return
+	}
+	if key, err := ident.PublicKey(); err == nil {
+		sc.lk.Lock()
+		sc.key = key
+		sc.lk.Unlock()
+	}
+}
+
+func (sc *streamChecker) checkCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) {
+	sig := sc.verifyCommitBlock(ctx, evt)
+
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+	sc.commits++
+
+	fmt.Printf("%s seq=%d rev=%s commit=%s ops=%d sig=%s\n", evt.Time, evt.Seq, evt.Rev, evt.Commit.String(), len(evt.Ops), sig)
+
+	if sc.lastRev != "" {
+		switch {
+		case evt.Since == nil:
+			sc.report("seq=%d rev=%s has no since, previous rev was %s", evt.Seq, evt.Rev, sc.lastRev)
+		case *evt.Since != sc.lastRev:
+			sc.report("seq=%d gap: since=%s but last seen rev was %s", evt.Seq, *evt.Since, sc.lastRev)
+		}
+		if evt.Rev <= sc.lastRev {
+			sc.report("seq=%d rev %s does not increase (last seen %s)", evt.Seq, evt.Rev, sc.lastRev)
+		}
+	}
+	if evt.TooBig {
+		sc.report("seq=%d rev=%s is tooBig; blocks must be fetched from the PDS", evt.Seq, evt.Rev)
+	}
+
+	sc.lastRev = evt.Rev
+	sc.lastCid = evt.Commit.String()
+	if sc.pendingRev != "" && sc.lastRev >= sc.pendingRev {
+		sc.pendingRev = ""
+	}
+}
+
+// verifyCommitBlock checks the signed commit carried in the event's blocks,
+// returning a short status for the log line. Problems are reported directly.
+func (sc *streamChecker) verifyCommitBlock(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) string {
+	if evt.TooBig {
+		return "skipped"
+	}
+
+	blocks, err := readCarBlocks(evt.Blocks)
+	if err != nil {
+		sc.lockedReport("seq=%d failed to parse blocks: %s", evt.Seq, err)
+		return "error"
+	}
+	blk, ok := blocks[cid.Cid(evt.Commit)]
+	if !ok {
+		sc.lockedReport("seq=%d commit block %s missing from event", evt.Seq, evt.Commit.String())
+		return "error"
+	}
+
+	var commit repo.SignedCommit
+	if err := commit.UnmarshalCBOR(bytes.NewReader(blk)); err != nil {
+		sc.lockedReport("seq=%d failed to decode commit: %s", evt.Seq, err)
+		return "error"
+	}
+	if commit.Did != sc.did.String() {
+		sc.lockedReport("seq=%d commit is for %s", evt.Seq, commit.Did)
+	}
+	if commit.Rev != evt.Rev {
+		sc.lockedReport("seq=%d commit rev %s doesn't match event rev %s", evt.Seq, commit.Rev, evt.Rev)
+	}
+
+	unsigned, err := commit.Unsigned().BytesForSigning()
+	if err != nil {
+		sc.lockedReport("seq=%d failed to encode commit: %s", evt.Seq, err)
+		return "error"
+	}
+
+	sc.lk.Lock()
+	key := sc.key
+	sc.lk.Unlock()
+	if key == nil {
+		return "unchecked"
+	}
+	if key.HashAndVerify(unsigned, commit.Sig) == nil {
+		return "ok"
+	}
+
+	// the signing key may have just been rotated
+	sc.refreshIdentity(ctx)
+	sc.lk.Lock()
+	key = sc.key
+	sc.lk.Unlock()
+	if err := key.HashAndVerify(unsigned, commit.Sig); err != nil {
+		sc.lockedReport("seq=%d rev=%s signature invalid: %s", evt.Seq, evt.Rev, err)
+		return "INVALID"
+	}
+	return "ok"
+}
+
+func (sc *streamChecker) lockedReport(format string, args ...any) {
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+	sc.report(format, args...)
+}
+
+func (sc *streamChecker) pollLoop(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		sc.poll(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (sc *streamChecker) poll(ctx context.Context, interval time.Duration) {
+	latest, err := comatproto.SyncGetLatestCommit(ctx, sc.xrpc, sc.did.String())
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "polling latest commit: %s\n", err)
+		}
+		return
+	}
+
+	// getRepoStatus is newer than most of the API this tool uses, so a
+	// failure here isn't treated as a problem
+	var status struct {
+		Active bool    `json:"active"`
+		Status *string `json:"status"`
+		Rev    *string `json:"rev"`
+	}
+	params := map[string]any{"did": sc.did.String()}
+	if err := sc.xrpc.Do(ctx, xrpc.Query, "", "com.atproto.sync.getRepoStatus", params, nil, &status); err == nil && !status.Active {
+		st := "inactive"
+		if status.Status != nil {
+			st = *status.Status
+		}
+		sc.lockedReport("PDS reports repo status %s", st)
+	}
+
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+
+	switch {
+	case sc.lastRev == "":
+		// nothing seen on the stream yet to compare against
+	case latest.Rev == sc.lastRev:
+		if latest.Cid != sc.lastCid {
+			sc.report("PDS latest commit %s differs from stream commit %s at rev %s", latest.Cid, sc.lastCid, latest.Rev)
+		}
+	case latest.Rev < sc.lastRev:
+		sc.report("PDS latest rev %s is behind the stream (%s)", latest.Rev, sc.lastRev)
+	default:
+		// the stream may just be lagging; only complain if it stays behind
+		// for a whole poll interval
+		if sc.pendingRev == "" {
+			sc.pendingRev = latest.Rev
+			sc.pendingSince = time.Now()
+		} else if time.Since(sc.pendingSince) >= interval {
+			sc.report("PDS has rev %s but the stream is still at %s after %s", sc.pendingRev, sc.lastRev, time.Since(sc.pendingSince).Round(time.Second))
+			sc.pendingRev = latest.Rev
+			sc.pendingSince = time.Now()
+		}
+	}
+}
+
+func (sc *streamChecker) printSummary() {
+	sc.lk.Lock()
+	defer sc.lk.Unlock()
+	fmt.Printf("\nchecked %d commits for %s, %d problems; last rev %s\n", sc.commits, sc.did, sc.problems, sc.lastRev)
+}
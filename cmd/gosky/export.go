@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/ipfs/go-cid"
+	cli "github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+)
+
+// exportManifest is written to manifest.json at the root of an export
+// directory, describing what was downloaded.
+type exportManifest struct {
+	Did         string    `json:"did"`
+	Handle      string    `json:"handle"`
+	PDS         string    `json:"pds"`
+	ExportedAt  time.Time `json:"exportedAt"`
+	RepoFile    string    `json:"repoFile"`
+	Blobs       int       `json:"blobs"`
+	FailedBlobs []string  `json:"failedBlobs,omitempty"`
+}
+
+var syncExportCmd = &cli.Command{
+	Name:  "export",
+	Usage: "download repo CAR and all blobs for an account into a backup directory",
+	Description: `Creates a directory with the layout:
+
+   <dir>/repo.car
+   <dir>/blobs/<cid>
+   <dir>/manifest.json
+
+Blobs which already exist in the directory are not downloaded again, so an
+interrupted export can be resumed by running the command again.`,
+	ArgsUsage: `<at-identifier> [<dir>]`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name: "host",
+		},
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "number of blobs to download in parallel",
+			Value: 4,
+		},
+		&cli.IntFlag{
+			Name:  "retries",
+			Usage: "number of times to retry a failed blob download",
+			Value: 3,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		args, err := needArgs(cctx, "at-identifier")
+		if err != nil {
+			return err
+		}
+		atid, err := syntax.ParseAtIdentifier(args[0])
+		if err != nil {
+			return err
+		}
+		ident, err := identity.DefaultDirectory().Lookup(ctx, *atid)
+		if err != nil {
+			return err
+		}
+
+		dir := cctx.Args().Get(1)
+		if dir == "" {
+			dir = ident.DID.String()
+		}
+		blobDir := filepath.Join(dir, "blobs")
+		if err := os.MkdirAll(blobDir, 0755); err != nil {
+			return err
+		}
+
+		xrpcc, err := cliutil.GetXrpcClient(cctx, false)
+		if err != nil {
+			return err
+		}
+		xrpcc.Host = ident.PDSEndpoint()
+		if h := cctx.String("host"); h != "" {
+			xrpcc.Host = h
+		}
+		if xrpcc.Host == "" {
+			return fmt.Errorf("no PDS endpoint for identity")
+		}
+
+		did := ident.DID.String()
+		log.Infof("exporting %s from %s to: %s", did, xrpcc.Host, dir)
+
+		repoBytes, err := comatproto.SyncGetRepo(ctx, xrpcc, did, "")
+		if err != nil {
+			return fmt.Errorf("fetching repo: %w", err)
+		}
+		if err := writeFileAtomic(filepath.Join(dir, "repo.car"), repoBytes); err != nil {
+			return err
+		}
+
+		retries := cctx.Int("retries")
+		var lk sync.Mutex
+		var failed []string
+		var count int
+
+		eg := new(errgroup.Group)
+		eg.SetLimit(cctx.Int("concurrency"))
+
+		var cursor string
+		for {
+			resp, err := comatproto.SyncListBlobs(ctx, xrpcc, cursor, did, 500, "")
+			if err != nil {
+				eg.Wait()
+				return fmt.Errorf("listing blobs: %w", err)
+			}
+
+			for _, c := range resp.Cids {
+				c := c
+				count++
+
+				// blob CIDs become file names, so make sure they are well formed
+				if _, err := cid.Decode(c); err != nil {
+					lk.Lock()
+					failed = append(failed, c)
+					lk.Unlock()
+					log.Errorf("skipping invalid blob CID %q: %s", c, err)
+					continue
+				}
+
+				fpath := filepath.Join(blobDir, c)
+				if _, err := os.Stat(fpath); err == nil {
+					continue
+				}
+
+				eg.Go(func() error {
+					if err := fetchBlobWithRetry(ctx, xrpcc, did, c, fpath, retries); err != nil {
+						log.Errorf("failed to download blob %s: %s", c, err)
+						lk.Lock()
+						failed = append(failed, c)
+						lk.Unlock()
+					}
+					return nil
+				})
+			}
+
+			if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Cids) == 0 {
+				break
+			}
+			cursor = *resp.Cursor
+		}
+		eg.Wait()
+
+		manifest := exportManifest{
+			Did:         did,
+			Handle:      ident.Handle.String(),
+			PDS:         xrpcc.Host,
+			ExportedAt:  time.Now().UTC(),
+			RepoFile:    "repo.car",
+			Blobs:       count,
+			FailedBlobs: failed,
+		}
+		b, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, "manifest.json"), b); err != nil {
+			return err
+		}
+
+		if len(failed) > 0 {
+			return fmt.Errorf("%d of %d blobs failed to download; re-run to retry", len(failed), count)
+		}
+		fmt.Printf("exported repo and %d blobs to %s\n", count, dir)
+		return nil
+	},
+}
+
+func fetchBlobWithRetry(ctx context.Context, xrpcc *xrpc.Client, did, c, fpath string, retries int) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+
+		var blob []byte
+		blob, err = comatproto.SyncGetBlob(ctx, xrpcc, c, did)
+		if err != nil {
+			continue
+		}
+		return writeFileAtomic(fpath, blob)
+	}
+	return err
+}
+
+// writeFileAtomic writes via a temp file and rename, so a partially written
+// file is never mistaken for a complete download.
+func writeFileAtomic(fpath string, b []byte) error {
+	tmp := fpath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fpath)
+}
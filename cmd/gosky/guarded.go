@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+// Irreversible commands (tombstoning a DID, deactivating an account) run in
+// two steps. The first (the default) is a dry run: it prints the consequences
+// and the exact operation which would be submitted, and writes them to a plan
+// file. The second (--execute) reads the plan back, checks it is still valid
+// and that any delay has passed, shows it again, and only submits once the
+// DID has been typed in.
+
+// how long a plan can be executed for, once its delay has passed
+const guardedPlanLifetime = 24 * time.Hour
+
+var guardedFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "plan",
+		Usage:    "plan file written by the dry run, and read back by --execute",
+		Required: true,
+	},
+	&cli.BoolFlag{
+		Name:  "execute",
+		Usage: "submit the operation from a plan written by an earlier dry run",
+	},
+	&cli.DurationFlag{
+		Name:  "delay",
+		Usage: "in the dry run, how long to wait before the plan can be executed",
+	},
+}
+
+type guardedPlan struct {
+	Action    string          `json:"action"`
+	DID       string          `json:"did"`
+	Operation json.RawMessage `json:"operation"`
+	CreatedAt time.Time       `json:"createdAt"`
+	NotBefore time.Time       `json:"notBefore"`
+}
+
+func writeGuardedPlan(cctx *cli.Context, action, did string, op any, warnings []string) error {
+	b, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	plan := guardedPlan{
+		Action:    action,
+		DID:       did,
+		Operation: b,
+		CreatedAt: now,
+		NotBefore: now.Add(cctx.Duration("delay")),
+	}
+
+	printGuardedPlan(os.Stdout, &plan, warnings)
+
+	out, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	fname := cctx.String("plan")
+	if err := os.WriteFile(fname, append(out, '\n'), 0600); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	fmt.Println("DRY RUN: nothing was submitted. To go ahead, run the same command again with:")
+	fmt.Printf("  --plan %s --execute\n", fname)
+	if plan.NotBefore.After(now) {
+		fmt.Printf("which will be possible from %s\n", plan.NotBefore.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// readGuardedPlan loads a plan for --execute, checking it is for this action
+// and DID, and is within its execution window.
+func readGuardedPlan(cctx *cli.Context, action, did string) (*guardedPlan, error) {
+	b, err := os.ReadFile(cctx.String("plan"))
+	if err != nil {
+		return nil, fmt.Errorf("reading plan (run without --execute first): %w", err)
+	}
+	var plan guardedPlan
+	if err := json.Unmarshal(b, &plan); err != nil {
+		return nil, fmt.Errorf("parsing plan: %w", err)
+	}
+	if plan.Action != action {
+		return nil, fmt.Errorf("plan is for %q, not %q", plan.Action, action)
+	}
+	if plan.DID != did {
+		return nil, fmt.Errorf("plan is for %s, not %s", plan.DID, did)
+	}
+
+	now := time.Now()
+	if now.Before(plan.NotBefore) {
+		return nil, fmt.Errorf("plan can't be executed until %s (in %s)", plan.NotBefore.Format(time.RFC3339), time.Until(plan.NotBefore).Round(time.Second))
+	}
+	if now.After(plan.NotBefore.Add(guardedPlanLifetime)) {
+		return nil, fmt.Errorf("plan expired at %s; do a new dry run", plan.NotBefore.Add(guardedPlanLifetime).Format(time.RFC3339))
+	}
+	return &plan, nil
+}
+
+func printGuardedPlan(w io.Writer, plan *guardedPlan, warnings []string) {
+	fmt.Fprintf(w, "action: %s\n", plan.Action)
+	fmt.Fprintf(w, "did:    %s\n", plan.DID)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "WARNING:")
+	for _, s := range warnings {
+		fmt.Fprintf(w, "  * %s\n", s)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "operation to be submitted:")
+	var pretty any
+	if err := json.Unmarshal(plan.Operation, &pretty); err == nil {
+		b, _ := json.MarshalIndent(pretty, "  ", "  ")
+		fmt.Fprintf(w, "  %s\n", b)
+	} else {
+		fmt.Fprintf(w, "  %s\n", plan.Operation)
+	}
+}
+
+// confirmGuardedPlan shows the plan again and has the user type the DID;
+// nothing short of the exact DID goes ahead.
+func confirmGuardedPlan(plan *guardedPlan, warnings []string) error {
+	printGuardedPlan(os.Stdout, plan, warnings)
+	fmt.Println()
+	fmt.Printf("type the DID (%s) to submit this operation: ", plan.DID)
+	inp := bufio.NewScanner(os.Stdin)
+	if !inp.Scan() || strings.TrimSpace(inp.Text()) != plan.DID {
+		return fmt.Errorf("aborted: DID didn't match")
+	}
+	return nil
+}
+
+// finishGuardedPlan removes a plan once it has been executed, so it can't be
+// submitted twice
+func finishGuardedPlan(cctx *cli.Context) {
+	if err := os.Remove(cctx.String("plan")); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to remove plan file: %s\n", err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	label "github.com/bluesky-social/indigo/api/label"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	"github.com/gorilla/websocket"
+	cli "github.com/urfave/cli/v2"
+)
+
+var labelCmd = &cli.Command{
+	Name:  "label",
+	Usage: "sub-commands for reading labels from a labeling service",
+	Subcommands: []*cli.Command{
+		labelQueryCmd,
+		labelSubscribeCmd,
+	},
+}
+
+var labelQueryCmd = &cli.Command{
+	Name:  "query",
+	Usage: "query labels with queryLabels, printing one JSON object per line",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "labeler",
+			Usage: "URL of the labeling service (defaults to --pds-host)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "uri",
+			Usage: "AT-URI patterns to match; a trailing '*' matches as a prefix",
+			Value: cli.NewStringSlice("*"),
+		},
+		&cli.StringSliceFlag{
+			Name:  "source",
+			Usage: "only return labels from these labeler DIDs",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Usage: "maximum number of labels to print (0 for all)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		xrpcc, err := cliutil.GetXrpcClient(cctx, false)
+		if err != nil {
+			return err
+		}
+		if h := cctx.String("labeler"); h != "" {
+			xrpcc.Host = h
+		}
+
+		limit := cctx.Int("limit")
+		count := 0
+		var cursor string
+		for {
+			out, err := comatproto.LabelQueryLabels(ctx, xrpcc, cursor, 250, cctx.StringSlice("source"), cctx.StringSlice("uri"))
+			if err != nil {
+				return err
+			}
+
+			for _, l := range out.Labels {
+				b, err := json.Marshal(l)
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(b))
+				count++
+				if limit > 0 && count >= limit {
+					return nil
+				}
+			}
+
+			if out.Cursor == nil || *out.Cursor == "" || len(out.Labels) == 0 {
+				return nil
+			}
+			cursor = *out.Cursor
+		}
+	},
+}
+
+var labelSubscribeCmd = &cli.Command{
+	Name:  "subscribe",
+	Usage: "stream labels from subscribeLabels as JSONL",
+	Description: `Prints one JSON object per label, with the "seq" of the event it arrived in.
+
+With --cursor-file, the last seen sequence number is saved to the file, and a
+restarted subscription resumes from it.`,
+	ArgsUsage: `<labeler-url>`,
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:  "cursor",
+			Usage: "sequence number to start from",
+			Value: -1,
+		},
+		&cli.StringFlag{
+			Name:  "cursor-file",
+			Usage: "file to resume from and record the last seen sequence number in",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		args, err := needArgs(cctx, "labeler-url")
+		if err != nil {
+			return err
+		}
+
+		u := args[0]
+		u = strings.Replace(u, "https://", "wss://", 1)
+		u = strings.Replace(u, "http://", "ws://", 1)
+		if !strings.Contains(u, "subscribeLabels") {
+			u = strings.TrimSuffix(u, "/") + "/xrpc/com.atproto.label.subscribeLabels"
+		}
+
+		cursor := cctx.Int64("cursor")
+		cursorFile := cctx.String("cursor-file")
+		if cursorFile != "" && !cctx.IsSet("cursor") {
+			b, err := os.ReadFile(cursorFile)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if len(b) > 0 {
+				cursor, err = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+				if err != nil {
+					return fmt.Errorf("parsing cursor file: %w", err)
+				}
+			}
+		}
+		if cursor >= 0 {
+			u = fmt.Sprintf("%s?cursor=%d", u, cursor)
+		}
+
+		fmt.Fprintln(os.Stderr, "dialing: ", u)
+		con, _, err := websocket.DefaultDialer.Dial(u, http.Header{})
+		if err != nil {
+			return fmt.Errorf("dial failure: %w", err)
+		}
+		go func() {
+			<-ctx.Done()
+			_ = con.Close()
+		}()
+
+		// the cursor file is only rewritten periodically, so a restart may
+		// replay a few seconds of labels but will never skip any
+		var lastSeq int64 = -1
+		var lastSaved time.Time
+		saveCursor := func(force bool) error {
+			if cursorFile == "" || lastSeq < 0 || (!force && time.Since(lastSaved) < 5*time.Second) {
+				return nil
+			}
+			lastSaved = time.Now()
+			return writeFileAtomic(cursorFile, []byte(strconv.FormatInt(lastSeq, 10)+"\n"))
+		}
+		defer saveCursor(true)
+
+		rsc := &events.RepoStreamCallbacks{
+			LabelLabels: func(evt *label.SubscribeLabels_Labels) error {
+				for _, l := range evt.Labels {
+					b, err := json.Marshal(l)
+					if err != nil {
+						return err
+					}
+					var out map[string]any
+					if err := json.Unmarshal(b, &out); err != nil {
+						return err
+					}
+					out["seq"] = evt.Seq
+
+					b, err = json.Marshal(out)
+					if err != nil {
+						return err
+					}
+					fmt.Println(string(b))
+				}
+
+				lastSeq = evt.Seq
+				return saveCursor(false)
+			},
+			LabelInfo: func(info *label.SubscribeLabels_Info) error {
+				fmt.Fprintf(os.Stderr, "INFO: %s: %v\n", info.Name, info.Message)
+				return nil
+			},
+			Error: func(errf *events.ErrorFrame) error {
+				return fmt.Errorf("error frame: %s: %s", errf.Error, errf.Message)
+			},
+		}
+		seqScheduler := sequential.NewScheduler(con.RemoteAddr().String(), rsc.EventHandler)
+		err = events.HandleRepoStream(ctx, con, seqScheduler)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	},
+}
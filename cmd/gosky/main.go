@@ -72,6 +72,17 @@ func run(args []string) {
 			Value:   "bsky.auth",
 			EnvVars: []string{"ATP_AUTH_FILE"},
 		},
+		&cli.StringFlag{
+			Name:    "profile",
+			Usage:   "named profile to take the PDS and credentials from (see 'gosky profile')",
+			EnvVars: []string{"ATP_PROFILE"},
+		},
+		&cli.StringFlag{
+			Name:    "oauth-session",
+			Usage:   "path to OAuth session file written by 'account login' (used unless --auth is set)",
+			Value:   cliutil.DefaultOAuthSessionPath(),
+			EnvVars: []string{"ATP_OAUTH_SESSION"},
+		},
 		&cli.StringFlag{
 			Name:    "plc",
 			Usage:   "method, hostname, and port of PLC registry",
@@ -79,6 +90,7 @@ func run(args []string) {
 			EnvVars: []string{"ATP_PLC_HOST"},
 		},
 	}
+	app.Before = loadConfig
 	app.Commands = []*cli.Command{
 		accountCmd,
 		adminCmd,
@@ -88,7 +100,14 @@ func run(args []string) {
 		debugCmd,
 		didCmd,
 		handleCmd,
+		labelCmd,
+		ozoneCmd,
+		plcCmd,
+		profileCmd,
+		recordCmd,
+		resolveBatchCmd,
 		syncCmd,
+		applyWritesCmd,
 		createFeedGeneratorCmd,
 		getRecordCmd,
 		listAllRecordsCmd,
@@ -136,7 +155,22 @@ var readRepoStreamCmd = &cli.Command{
 	Usage: "subscribe to a repo event stream",
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
-			Name: "json",
+			Name:  "json",
+			Usage: "shorthand for --output=json",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "output format: text, json, jsonl (one line per op, with decoded records), or cbor-hex",
+			Value:   streamOutputText,
+		},
+		&cli.StringSliceFlag{
+			Name:  "collection",
+			Usage: "only show ops in these collections (NSIDs, or prefixes like app.bsky.feed.*)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "did",
+			Usage: "only show events from these repos",
 		},
 		&cli.BoolFlag{
 			Name: "unpack",
@@ -148,6 +182,10 @@ var readRepoStreamCmd = &cli.Command{
 			Name:  "max-throughput",
 			Usage: "limit event consumption to a given # of req/sec (debug utility)",
 		},
+		&cli.BoolFlag{
+			Name:  "tui",
+			Usage: "interactive full-screen viewer, with pause, filter editing and a detail pane",
+		},
 	},
 	ArgsUsage: `[<repo> [cursor]]`,
 	Action: func(cctx *cli.Context) error {
@@ -169,8 +207,26 @@ var readRepoStreamCmd = &cli.Command{
 			return fmt.Errorf("dial failure: %w", err)
 		}
 
-		jsonfmt := cctx.Bool("json")
+		output, err := parseStreamOutput(cctx.String("output"))
+		if err != nil {
+			return err
+		}
+		if cctx.Bool("json") && !cctx.IsSet("output") {
+			output = streamOutputJSON
+		}
+		jsonfmt := output == streamOutputJSON
 		unpack := cctx.Bool("unpack")
+		filter := newStreamFilter(cctx.StringSlice("did"), cctx.StringSlice("collection"))
+
+		var limiter *rate.Limiter
+		if cctx.Float64("max-throughput") > 0 {
+			limiter = rate.NewLimiter(rate.Limit(cctx.Float64("max-throughput")), 1)
+		}
+
+		if cctx.Bool("tui") {
+			filterText := strings.Join(append(cctx.StringSlice("did"), cctx.StringSlice("collection")...), " ")
+			return runStreamTUI(ctx, con, arg, filter, filterText, limiter)
+		}
 
 		fmt.Fprintln(os.Stderr, "Stream Started", time.Now().Format(time.RFC3339))
 		defer func() {
@@ -208,17 +264,27 @@ var readRepoStreamCmd = &cli.Command{
 
 			return h, nil
 		}
-		var limiter *rate.Limiter
-		if cctx.Float64("max-throughput") > 0 {
-			limiter = rate.NewLimiter(rate.Limit(cctx.Float64("max-throughput")), 1)
-		}
-
 		rsc := &events.RepoStreamCallbacks{
 			RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
 				if limiter != nil {
 					limiter.Wait(ctx)
 				}
 
+				if !filter.matchDid(evt.Repo) {
+					return nil
+				}
+				ops := filter.filterOps(evt.Ops)
+				if len(ops) == 0 && len(evt.Ops) > 0 {
+					return nil
+				}
+
+				switch output {
+				case streamOutputCBORHex:
+					return printCBORHex(os.Stdout, "commit", evt)
+				case streamOutputJSONL:
+					return printCommitJSONL(os.Stdout, evt, ops)
+				}
+
 				if jsonfmt {
 					b, err := json.Marshal(evt)
 					if err != nil {
@@ -229,9 +295,10 @@ var readRepoStreamCmd = &cli.Command{
 						return err
 					}
 					out["blocks"] = fmt.Sprintf("[%d bytes]", len(evt.Blocks))
+					out["ops"] = ops
 
 					if unpack {
-						recs, err := unpackRecords(evt.Blocks, evt.Ops)
+						recs, err := unpackRecords(evt.Blocks, ops)
 						if err != nil {
 							fmt.Fprintln(os.Stderr, "failed to unpack records: ", err)
 						}
@@ -260,7 +327,7 @@ var readRepoStreamCmd = &cli.Command{
 					fmt.Printf("(%d) RepoAppend: %s %s (%s -> %s)\n", evt.Seq, evt.Repo, handle, pstr, evt.Commit.String())
 
 					if unpack {
-						recs, err := unpackRecords(evt.Blocks, evt.Ops)
+						recs, err := unpackRecords(evt.Blocks, ops)
 						if err != nil {
 							fmt.Fprintln(os.Stderr, "failed to unpack records: ", err)
 						}
@@ -278,6 +345,17 @@ var readRepoStreamCmd = &cli.Command{
 				return nil
 			},
 			RepoHandle: func(handle *comatproto.SyncSubscribeRepos_Handle) error {
+				if !filter.matchNonCommit(handle.Did) {
+					return nil
+				}
+
+				switch output {
+				case streamOutputCBORHex:
+					return printCBORHex(os.Stdout, "handle", handle)
+				case streamOutputJSONL:
+					return printEventJSONL(os.Stdout, "handle", handle)
+				}
+
 				if jsonfmt {
 					b, err := json.Marshal(handle)
 					if err != nil {
@@ -292,6 +370,13 @@ var readRepoStreamCmd = &cli.Command{
 
 			},
 			RepoInfo: func(info *comatproto.SyncSubscribeRepos_Info) error {
+				switch output {
+				case streamOutputCBORHex:
+					return printCBORHex(os.Stdout, "info", info)
+				case streamOutputJSONL:
+					return printEventJSONL(os.Stdout, "info", info)
+				}
+
 				if jsonfmt {
 					b, err := json.Marshal(info)
 					if err != nil {
@@ -305,6 +390,17 @@ var readRepoStreamCmd = &cli.Command{
 				return nil
 			},
 			RepoTombstone: func(tomb *comatproto.SyncSubscribeRepos_Tombstone) error {
+				if !filter.matchNonCommit(tomb.Did) {
+					return nil
+				}
+
+				switch output {
+				case streamOutputCBORHex:
+					return printCBORHex(os.Stdout, "tombstone", tomb)
+				case streamOutputJSONL:
+					return printEventJSONL(os.Stdout, "tombstone", tomb)
+				}
+
 				if jsonfmt {
 					b, err := json.Marshal(tomb)
 					if err != nil {
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+// steps of an account migration, in the order they are run. each step is
+// recorded in the state file once it completes, so an interrupted migration
+// picks up where it left off.
+const (
+	migrateStepCreateAccount = "create-account"
+	migrateStepImportRepo    = "import-repo"
+	migrateStepBlobs         = "transfer-blobs"
+	migrateStepPreferences   = "preferences"
+	migrateStepPLC           = "update-plc"
+	migrateStepActivate      = "activate"
+)
+
+var migrateSteps = []string{
+	migrateStepCreateAccount,
+	migrateStepImportRepo,
+	migrateStepBlobs,
+	migrateStepPreferences,
+	migrateStepPLC,
+	migrateStepActivate,
+}
+
+type migrationState struct {
+	Did        string          `json:"did"`
+	Handle     string          `json:"handle"`
+	NewPDSHost string          `json:"newPdsHost"`
+	NewAuth    *xrpc.AuthInfo  `json:"newAuth,omitempty"`
+	Completed  map[string]bool `json:"completed"`
+
+	// cursor into the old PDS's listBlobs output; pages before it have all
+	// been uploaded to the new PDS
+	BlobCursor    string `json:"blobCursor,omitempty"`
+	BlobsUploaded int    `json:"blobsUploaded"`
+
+	PLCTokenRequested bool `json:"plcTokenRequested,omitempty"`
+}
+
+func loadMigrationState(fname string) (*migrationState, error) {
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var st migrationState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("parsing migration state %s: %w", fname, err)
+	}
+	if st.Completed == nil {
+		st.Completed = make(map[string]bool)
+	}
+	return &st, nil
+}
+
+func (st *migrationState) save(fname string) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write to a temp file first so a crash never leaves a truncated checkpoint
+	tmp := fname + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+var migrateAccountCmd = &cli.Command{
+	Name:  "migrate",
+	Usage: "move the authenticated account to a new PDS",
+	Description: `Migrates the account in the current auth file (on --pds-host) to --new-pds-host,
+keeping the same DID. The steps are:
+
+   create-account, import-repo, transfer-blobs, preferences, update-plc, activate
+
+Progress is checkpointed to --state-file after each step, and re-running the
+command with the same state file resumes from the first incomplete step.
+
+Updating the PLC identity requires a confirmation token which the old PDS
+sends by email. The first run requests the token and stops; re-run with
+--plc-token to finish the migration.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "new-pds-host",
+			Usage:    "URL of the PDS to migrate to",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "new-pds-did",
+			Usage: "service DID of the new PDS (defaults to did:web of the host name)",
+		},
+		&cli.StringFlag{
+			Name:  "new-handle",
+			Usage: "handle to use on the new PDS (defaults to the current handle)",
+		},
+		&cli.StringFlag{
+			Name:  "email",
+			Usage: "email address for the new account",
+		},
+		&cli.StringFlag{
+			Name:  "password",
+			Usage: "password for the new account",
+		},
+		&cli.StringFlag{
+			Name:  "invite-code",
+			Usage: "invite code for the new PDS, if it requires one",
+		},
+		&cli.StringFlag{
+			Name:  "plc-token",
+			Usage: "PLC operation confirmation token, sent by email from the old PDS",
+		},
+		&cli.StringFlag{
+			Name:  "state-file",
+			Usage: "path of the migration checkpoint file (defaults to migration-<did>.json)",
+		},
+		&cli.BoolFlag{
+			Name:  "keep-old-active",
+			Usage: "don't deactivate the account on the old PDS after activating the new one",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+
+		oldc, err := cliutil.GetXrpcClient(cctx, true)
+		if err != nil {
+			return err
+		}
+
+		stateFile := cctx.String("state-file")
+		if stateFile == "" {
+			stateFile = fmt.Sprintf("migration-%s.json", oldc.Auth.Did)
+		}
+
+		st, err := loadMigrationState(stateFile)
+		if err != nil {
+			return err
+		}
+		if st == nil {
+			handle := cctx.String("new-handle")
+			if handle == "" {
+				handle = oldc.Auth.Handle
+			}
+			st = &migrationState{
+				Did:        oldc.Auth.Did,
+				Handle:     handle,
+				NewPDSHost: cctx.String("new-pds-host"),
+				Completed:  make(map[string]bool),
+			}
+		}
+		if st.Did != oldc.Auth.Did {
+			return fmt.Errorf("state file %s is for %s, but authenticated as %s", stateFile, st.Did, oldc.Auth.Did)
+		}
+		if st.NewPDSHost != cctx.String("new-pds-host") {
+			return fmt.Errorf("state file %s is for a migration to %s", stateFile, st.NewPDSHost)
+		}
+
+		m := &migration{
+			cctx:  cctx,
+			old:   oldc,
+			new:   &xrpc.Client{Client: cliutil.NewHttpClient(), Host: st.NewPDSHost},
+			state: st,
+			fname: stateFile,
+		}
+
+		for _, step := range migrateSteps {
+			if st.Completed[step] {
+				fmt.Printf("%s: already done\n", step)
+				continue
+			}
+
+			fmt.Printf("%s: running\n", step)
+			if err := m.run(ctx, step); err != nil {
+				if errors.Is(err, errMigrationPaused) {
+					return nil
+				}
+				return fmt.Errorf("migration step %s failed (re-run to resume): %w", step, err)
+			}
+
+			st.Completed[step] = true
+			if err := st.save(stateFile); err != nil {
+				return fmt.Errorf("saving migration state: %w", err)
+			}
+			fmt.Printf("%s: done\n", step)
+		}
+
+		fmt.Printf("migration of %s to %s complete\n", st.Did, st.NewPDSHost)
+		return nil
+	},
+}
+
+// returned by a step that needs user input before it can continue
+var errMigrationPaused = errors.New("migration paused")
+
+type migration struct {
+	cctx  *cli.Context
+	old   *xrpc.Client
+	new   *xrpc.Client
+	state *migrationState
+	fname string
+}
+
+func (m *migration) run(ctx context.Context, step string) error {
+	// every step after account creation talks to the new PDS as the account
+	if step != migrateStepCreateAccount {
+		if err := m.resumeNewSession(ctx); err != nil {
+			return err
+		}
+	}
+
+	switch step {
+	case migrateStepCreateAccount:
+		return m.createAccount(ctx)
+	case migrateStepImportRepo:
+		return m.importRepo(ctx)
+	case migrateStepBlobs:
+		return m.transferBlobs(ctx)
+	case migrateStepPreferences:
+		return m.transferPreferences(ctx)
+	case migrateStepPLC:
+		return m.updatePLC(ctx)
+	case migrateStepActivate:
+		return m.activate(ctx)
+	default:
+		return fmt.Errorf("unknown migration step: %s", step)
+	}
+}
+
+func (m *migration) newPDSDid() (string, error) {
+	if d := m.cctx.String("new-pds-did"); d != "" {
+		return d, nil
+	}
+
+	u, err := url.Parse(m.state.NewPDSHost)
+	if err != nil {
+		return "", fmt.Errorf("parsing new PDS host: %w", err)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("can't derive a service DID from %q, set --new-pds-did", m.state.NewPDSHost)
+	}
+	return "did:web:" + u.Hostname(), nil
+}
+
+func (m *migration) createAccount(ctx context.Context) error {
+	email := m.cctx.String("email")
+	password := m.cctx.String("password")
+	if email == "" || password == "" {
+		return fmt.Errorf("--email and --password are required to create the new account")
+	}
+
+	aud, err := m.newPDSDid()
+	if err != nil {
+		return err
+	}
+
+	// the old PDS vouches for our control of the DID with a short-lived
+	// service auth token scoped to the new PDS
+	var sa struct {
+		Token string `json:"token"`
+	}
+	params := map[string]interface{}{
+		"aud": aud,
+		"lxm": "com.atproto.server.createAccount",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+	if err := m.old.Do(ctx, xrpc.Query, "", "com.atproto.server.getServiceAuth", params, nil, &sa); err != nil {
+		return fmt.Errorf("getting service auth from old PDS: %w", err)
+	}
+
+	var invite *string
+	if inv := m.cctx.String("invite-code"); inv != "" {
+		invite = &inv
+	}
+
+	did := m.state.Did
+	sac := &xrpc.Client{
+		Client: m.new.Client,
+		Host:   m.new.Host,
+		Auth:   &xrpc.AuthInfo{AccessJwt: sa.Token},
+	}
+	acc, err := comatproto.ServerCreateAccount(ctx, sac, &comatproto.ServerCreateAccount_Input{
+		Did:        &did,
+		Email:      email,
+		Handle:     m.state.Handle,
+		InviteCode: invite,
+		Password:   password,
+	})
+	if err != nil {
+		return fmt.Errorf("creating account on new PDS: %w", err)
+	}
+
+	m.state.NewAuth = &xrpc.AuthInfo{
+		AccessJwt:  acc.AccessJwt,
+		RefreshJwt: acc.RefreshJwt,
+		Handle:     acc.Handle,
+		Did:        acc.Did,
+	}
+	m.new.Auth = m.state.NewAuth
+	return nil
+}
+
+// resumeNewSession refreshes the saved session on the new PDS, since a
+// resumed migration may be long past the access token's expiry.
+func (m *migration) resumeNewSession(ctx context.Context) error {
+	if m.state.NewAuth == nil {
+		return fmt.Errorf("no session for the new PDS in migration state")
+	}
+	if m.new.Auth != nil {
+		return nil
+	}
+
+	m.new.Auth = &xrpc.AuthInfo{AccessJwt: m.state.NewAuth.RefreshJwt}
+	nauth, err := comatproto.ServerRefreshSession(ctx, m.new)
+	if err != nil {
+		m.new.Auth = nil
+		return fmt.Errorf("refreshing session on new PDS: %w", err)
+	}
+
+	m.state.NewAuth = &xrpc.AuthInfo{
+		AccessJwt:  nauth.AccessJwt,
+		RefreshJwt: nauth.RefreshJwt,
+		Handle:     nauth.Handle,
+		Did:        nauth.Did,
+	}
+	m.new.Auth = m.state.NewAuth
+
+	// refresh tokens are single use, so persist the new one right away
+	return m.state.save(m.fname)
+}
+
+func (m *migration) importRepo(ctx context.Context) error {
+	carb, err := comatproto.SyncGetRepo(ctx, m.old, m.state.Did, "")
+	if err != nil {
+		return fmt.Errorf("exporting repo from old PDS: %w", err)
+	}
+
+	if err := m.new.Do(ctx, xrpc.Procedure, "application/vnd.ipld.car", "com.atproto.repo.importRepo", nil, bytes.NewReader(carb), nil); err != nil {
+		return fmt.Errorf("importing repo to new PDS: %w", err)
+	}
+
+	fmt.Printf("imported repo (%d bytes)\n", len(carb))
+	return nil
+}
+
+func (m *migration) transferBlobs(ctx context.Context) error {
+	for {
+		resp, err := comatproto.SyncListBlobs(ctx, m.old, m.state.BlobCursor, m.state.Did, 500, "")
+		if err != nil {
+			return fmt.Errorf("listing blobs on old PDS: %w", err)
+		}
+
+		for _, c := range resp.Cids {
+			blob, err := comatproto.SyncGetBlob(ctx, m.old, c, m.state.Did)
+			if err != nil {
+				return fmt.Errorf("fetching blob %s: %w", c, err)
+			}
+
+			if _, err := comatproto.RepoUploadBlob(ctx, m.new, bytes.NewReader(blob)); err != nil {
+				return fmt.Errorf("uploading blob %s: %w", c, err)
+			}
+			m.state.BlobsUploaded++
+		}
+
+		if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Cids) == 0 {
+			break
+		}
+
+		// uploads are idempotent, so checkpointing per page is enough: a
+		// resumed run re-uploads at most one page
+		m.state.BlobCursor = *resp.Cursor
+		if err := m.state.save(m.fname); err != nil {
+			return err
+		}
+		fmt.Printf("transferred %d blobs\n", m.state.BlobsUploaded)
+	}
+
+	fmt.Printf("transferred %d blobs\n", m.state.BlobsUploaded)
+	return nil
+}
+
+func (m *migration) transferPreferences(ctx context.Context) error {
+	// preferences are passed through as raw JSON, so that preference types
+	// this client doesn't know about survive the move
+	var prefs json.RawMessage
+	if err := m.old.Do(ctx, xrpc.Query, "", "app.bsky.actor.getPreferences", nil, nil, &prefs); err != nil {
+		return fmt.Errorf("fetching preferences from old PDS: %w", err)
+	}
+
+	if err := m.new.Do(ctx, xrpc.Procedure, "application/json", "app.bsky.actor.putPreferences", nil, prefs, nil); err != nil {
+		return fmt.Errorf("writing preferences to new PDS: %w", err)
+	}
+	return nil
+}
+
+func (m *migration) updatePLC(ctx context.Context) error {
+	token := m.cctx.String("plc-token")
+	if token == "" {
+		if !m.state.PLCTokenRequested {
+			if err := m.old.Do(ctx, xrpc.Procedure, "", "com.atproto.identity.requestPlcOperationSignature", nil, nil, nil); err != nil {
+				return fmt.Errorf("requesting PLC operation token: %w", err)
+			}
+			m.state.PLCTokenRequested = true
+			if err := m.state.save(m.fname); err != nil {
+				return err
+			}
+		}
+		fmt.Println("a PLC confirmation token was sent to the account's email; re-run with --plc-token to continue")
+		return errMigrationPaused
+	}
+
+	// the new PDS tells us which rotation keys, signing key and service
+	// endpoint it needs in the DID document
+	var creds map[string]any
+	if err := m.new.Do(ctx, xrpc.Query, "", "com.atproto.identity.getRecommendedDidCredentials", nil, nil, &creds); err != nil {
+		return fmt.Errorf("fetching recommended DID credentials from new PDS: %w", err)
+	}
+	creds["token"] = token
+
+	var signed struct {
+		Operation json.RawMessage `json:"operation"`
+	}
+	if err := m.old.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.identity.signPlcOperation", nil, creds, &signed); err != nil {
+		return fmt.Errorf("signing PLC operation on old PDS: %w", err)
+	}
+
+	body := map[string]any{"operation": signed.Operation}
+	if err := m.new.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.identity.submitPlcOperation", nil, body, nil); err != nil {
+		return fmt.Errorf("submitting PLC operation via new PDS: %w", err)
+	}
+	return nil
+}
+
+func (m *migration) activate(ctx context.Context) error {
+	if err := m.new.Do(ctx, xrpc.Procedure, "", "com.atproto.server.activateAccount", nil, nil, nil); err != nil {
+		return fmt.Errorf("activating account on new PDS: %w", err)
+	}
+
+	if m.cctx.Bool("keep-old-active") {
+		return nil
+	}
+
+	if err := m.old.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.server.deactivateAccount", nil, map[string]any{}, nil); err != nil {
+		return fmt.Errorf("deactivating account on old PDS (the new account is active): %w", err)
+	}
+	return nil
+}
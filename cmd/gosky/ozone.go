@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/agnostic"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+// The tools.ozone.* lexicons aren't generated in this repo, so requests and
+// responses are handled as generic JSON.
+
+var ozoneCmd = &cli.Command{
+	Name:  "ozone",
+	Usage: "sub-commands for ozone moderation services",
+	Description: `Requests are proxied to the ozone service (--ozone-did) by the account's PDS,
+using the account's auth, so the account must be a member of the ozone
+service. Alternatively, with --admin-password, requests go directly to
+--ozone-host with admin auth.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "ozone-did",
+			Usage:   "DID of the ozone service, to proxy requests to through the PDS",
+			EnvVars: []string{"OZONE_DID"},
+		},
+		&cli.StringFlag{
+			Name:    "ozone-host",
+			Usage:   "URL of the ozone service, for admin auth",
+			EnvVars: []string{"OZONE_HOST"},
+		},
+		&cli.StringFlag{
+			Name:    "admin-password",
+			Usage:   "use admin auth with --ozone-host, instead of proxying with account auth",
+			EnvVars: []string{"ATP_AUTH_ADMIN_PASSWORD"},
+		},
+	},
+	Subcommands: []*cli.Command{
+		ozoneQueryEventsCmd,
+		ozoneLabelCmd,
+		ozoneTakedownCmd,
+		ozoneRestoreCmd,
+		ozoneReportsCmd,
+	},
+}
+
+// ozoneClient returns a client for the ozone service, and the DID to record
+// as the creator of moderation events (empty with admin auth)
+func ozoneClient(cctx *cli.Context) (*xrpc.Client, string, error) {
+	if pw := cctx.String("admin-password"); pw != "" {
+		host := cctx.String("ozone-host")
+		if host == "" {
+			return nil, "", fmt.Errorf("--ozone-host is required with admin auth")
+		}
+		xrpcc, err := cliutil.GetXrpcClient(cctx, false)
+		if err != nil {
+			return nil, "", err
+		}
+		// xrpc.Client doesn't send AdminToken for tools.ozone methods, so
+		// admin auth is set up for this client alone
+		xrpcc.Host = host
+		xrpcc.Auth = nil
+		xrpcc.AdminToken = nil
+		if xrpcc.Headers == nil {
+			xrpcc.Headers = map[string]string{}
+		}
+		xrpcc.Headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte("admin:"+pw))
+		return xrpcc, "", nil
+	}
+
+	ozoneDID := cctx.String("ozone-did")
+	if ozoneDID == "" {
+		return nil, "", fmt.Errorf("--ozone-did is required (or --admin-password and --ozone-host)")
+	}
+	if _, err := syntax.ParseDID(ozoneDID); err != nil {
+		return nil, "", fmt.Errorf("invalid ozone DID: %w", err)
+	}
+	xrpcc, err := cliutil.GetXrpcClient(cctx, true)
+	if err != nil {
+		return nil, "", err
+	}
+	if xrpcc.Headers == nil {
+		xrpcc.Headers = map[string]string{}
+	}
+	xrpcc.Headers["atproto-proxy"] = ozoneDID + "#atproto_labeler"
+	var did string
+	if xrpcc.Auth != nil {
+		did = xrpcc.Auth.Did
+	}
+	return xrpcc, did, nil
+}
+
+// ozoneSubject converts an account (DID or handle) or record (AT-URI)
+// argument to a moderation subject. Record CIDs are looked up from the
+// record's PDS, unless given.
+func ozoneSubject(ctx context.Context, raw string, recordCID string) (map[string]any, error) {
+	dir := identity.DefaultDirectory()
+	if strings.HasPrefix(raw, "at://") {
+		aturi, err := syntax.ParseATURI(raw)
+		if err != nil {
+			return nil, err
+		}
+		if aturi.RecordKey() == "" {
+			return nil, fmt.Errorf("AT-URI subject must be a record: %s", raw)
+		}
+		ident, err := dir.Lookup(ctx, aturi.Authority())
+		if err != nil {
+			return nil, err
+		}
+		uri := fmt.Sprintf("at://%s/%s/%s", ident.DID, aturi.Collection(), aturi.RecordKey())
+		if recordCID == "" {
+			pdsc := &xrpc.Client{Host: ident.PDSEndpoint()}
+			if pdsc.Host == "" {
+				return nil, fmt.Errorf("no PDS for %s, to look up record CID (use --cid)", ident.DID)
+			}
+			out, err := agnostic.RepoGetRecord(ctx, pdsc, "", aturi.Collection().String(), ident.DID.String(), aturi.RecordKey().String())
+			if err != nil {
+				return nil, fmt.Errorf("looking up record CID: %w", err)
+			}
+			if out.Cid == nil {
+				return nil, fmt.Errorf("PDS didn't return a CID for %s (use --cid)", uri)
+			}
+			recordCID = *out.Cid
+		}
+		return map[string]any{
+			"$type": "com.atproto.repo.strongRef",
+			"uri":   uri,
+			"cid":   recordCID,
+		}, nil
+	}
+
+	atid, err := syntax.ParseAtIdentifier(raw)
+	if err != nil {
+		return nil, fmt.Errorf("subject must be a DID, handle, or AT-URI: %w", err)
+	}
+	did, err := atid.AsDID()
+	if err != nil {
+		ident, err := dir.Lookup(ctx, *atid)
+		if err != nil {
+			return nil, err
+		}
+		did = ident.DID
+	}
+	return map[string]any{
+		"$type": "com.atproto.admin.defs#repoRef",
+		"did":   did.String(),
+	}, nil
+}
+
+// emitOzoneEvent sends a moderation event on each subject in the command's
+// arguments, printing the resulting events
+func emitOzoneEvent(cctx *cli.Context, event map[string]any) error {
+	ctx := context.Background()
+	if cctx.Args().Len() == 0 {
+		return fmt.Errorf("need at least one subject")
+	}
+	xrpcc, createdBy, err := ozoneClient(cctx)
+	if err != nil {
+		return err
+	}
+	if s := cctx.String("created-by"); s != "" {
+		createdBy = s
+	}
+	if createdBy == "" {
+		return fmt.Errorf("--created-by is required when the account DID isn't known (eg, with admin auth)")
+	}
+	if c := cctx.String("comment"); c != "" {
+		event["comment"] = c
+	}
+
+	for _, arg := range cctx.Args().Slice() {
+		subject, err := ozoneSubject(ctx, arg, cctx.String("cid"))
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+		input := map[string]any{
+			"event":     event,
+			"subject":   subject,
+			"createdBy": createdBy,
+		}
+		var out json.RawMessage
+		if err := xrpcc.Do(ctx, xrpc.Procedure, "application/json", "tools.ozone.moderation.emitEvent", nil, input, &out); err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+		fmt.Println(string(out))
+	}
+	return nil
+}
+
+var ozoneEmitFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "comment",
+		Usage: "comment to record with the event",
+	},
+	&cli.StringFlag{
+		Name:  "created-by",
+		Usage: "DID to record as the moderator (defaults to the authenticated account)",
+	},
+	&cli.StringFlag{
+		Name:  "cid",
+		Usage: "CID of the record version, for AT-URI subjects (looked up if not given)",
+	},
+}
+
+var ozoneLabelCmd = &cli.Command{
+	Name:      "label",
+	Usage:     "add or remove labels on accounts or records",
+	ArgsUsage: `<subject>...`,
+	Flags: append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "add",
+			Usage: "label values to add",
+		},
+		&cli.StringSliceFlag{
+			Name:  "remove",
+			Usage: "label values to remove (negate)",
+		},
+	}, ozoneEmitFlags...),
+	Action: func(cctx *cli.Context) error {
+		add := cctx.StringSlice("add")
+		remove := cctx.StringSlice("remove")
+		if len(add) == 0 && len(remove) == 0 {
+			return fmt.Errorf("need labels to --add or --remove")
+		}
+		return emitOzoneEvent(cctx, map[string]any{
+			"$type":           "tools.ozone.moderation.defs#modEventLabel",
+			"createLabelVals": append([]string{}, add...),
+			"negateLabelVals": append([]string{}, remove...),
+		})
+	},
+}
+
+var ozoneTakedownCmd = &cli.Command{
+	Name:      "takedown",
+	Usage:     "take down accounts or records",
+	ArgsUsage: `<subject>...`,
+	Flags: append([]cli.Flag{
+		&cli.IntFlag{
+			Name:  "duration-hours",
+			Usage: "make the takedown temporary, lasting this many hours",
+		},
+	}, ozoneEmitFlags...),
+	Action: func(cctx *cli.Context) error {
+		event := map[string]any{
+			"$type": "tools.ozone.moderation.defs#modEventTakedown",
+		}
+		if h := cctx.Int("duration-hours"); h > 0 {
+			event["durationInHours"] = h
+		}
+		return emitOzoneEvent(cctx, event)
+	},
+}
+
+var ozoneRestoreCmd = &cli.Command{
+	Name:      "restore",
+	Usage:     "reverse takedowns of accounts or records",
+	ArgsUsage: `<subject>...`,
+	Flags:     ozoneEmitFlags,
+	Action: func(cctx *cli.Context) error {
+		return emitOzoneEvent(cctx, map[string]any{
+			"$type": "tools.ozone.moderation.defs#modEventReverseTakedown",
+		})
+	},
+}
+
+// paginateOzone calls a query method until there are no more results (or
+// limit is reached), printing each item in the field named list as a line of
+// JSON
+func paginateOzone(ctx context.Context, xrpcc *xrpc.Client, method string, params map[string]any, list string, limit int) error {
+	count := 0
+	for {
+		pageSize := 100
+		if limit > 0 && limit-count < pageSize {
+			pageSize = limit - count
+		}
+		params["limit"] = pageSize
+
+		var out map[string]json.RawMessage
+		if err := xrpcc.Do(ctx, xrpc.Query, "", method, params, nil, &out); err != nil {
+			return err
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(out[list], &items); err != nil {
+			return fmt.Errorf("decoding %s response: %w", method, err)
+		}
+		for _, it := range items {
+			fmt.Println(string(it))
+			count++
+		}
+
+		var cursor string
+		if out["cursor"] != nil {
+			if err := json.Unmarshal(out["cursor"], &cursor); err != nil {
+				return err
+			}
+		}
+		if cursor == "" || len(items) == 0 || (limit > 0 && count >= limit) {
+			return nil
+		}
+		params["cursor"] = cursor
+	}
+}
+
+var ozoneQueryEventsCmd = &cli.Command{
+	Name:  "query-events",
+	Usage: "list moderation events, newest first, printing one JSON object per line",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "subject",
+			Usage: "only events on this subject (DID or AT-URI)",
+		},
+		&cli.StringFlag{
+			Name:  "type",
+			Usage: "only events of this type, eg tools.ozone.moderation.defs#modEventTakedown",
+		},
+		&cli.StringFlag{
+			Name:  "created-by",
+			Usage: "only events by this moderator DID",
+		},
+		&cli.BoolFlag{
+			Name:  "asc",
+			Usage: "oldest first",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Usage: "maximum number of events to print (0 for all)",
+			Value: 50,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		xrpcc, _, err := ozoneClient(cctx)
+		if err != nil {
+			return err
+		}
+		params := map[string]any{}
+		for flag, param := range map[string]string{"subject": "subject", "type": "types", "created-by": "createdBy"} {
+			if v := cctx.String(flag); v != "" {
+				params[param] = v
+			}
+		}
+		if cctx.Bool("asc") {
+			params["sortDirection"] = "asc"
+		}
+		return paginateOzone(ctx, xrpcc, "tools.ozone.moderation.queryEvents", params, "events", cctx.Int("limit"))
+	},
+}
+
+var ozoneReportsCmd = &cli.Command{
+	Name:  "reports",
+	Usage: "search reported subjects, by review state, printing one JSON object per line",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "state",
+			Usage: "review state: open, escalated, closed, or none (any)",
+			Value: "open",
+		},
+		&cli.StringFlag{
+			Name:  "subject",
+			Usage: "only this subject (DID or AT-URI)",
+		},
+		&cli.StringFlag{
+			Name:  "comment",
+			Usage: "search moderator comments",
+		},
+		&cli.StringFlag{
+			Name:  "reported-after",
+			Usage: "only subjects reported after this timestamp",
+		},
+		&cli.StringFlag{
+			Name:  "reported-before",
+			Usage: "only subjects reported before this timestamp",
+		},
+		&cli.IntFlag{
+			Name:  "limit",
+			Usage: "maximum number of subjects to print (0 for all)",
+			Value: 50,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		xrpcc, _, err := ozoneClient(cctx)
+		if err != nil {
+			return err
+		}
+		params := map[string]any{
+			"sortField": "lastReportedAt",
+		}
+		switch st := cctx.String("state"); st {
+		case "open", "escalated", "closed":
+			params["reviewState"] = "tools.ozone.moderation.defs#review" + strings.ToUpper(st[:1]) + st[1:]
+		case "none", "":
+		default:
+			return fmt.Errorf("unknown review state: %s", st)
+		}
+		for flag, param := range map[string]string{"subject": "subject", "comment": "comment", "reported-after": "reportedAfter", "reported-before": "reportedBefore"} {
+			if v := cctx.String(flag); v != "" {
+				params[param] = v
+			}
+		}
+		return paginateOzone(ctx, xrpcc, "tools.ozone.moderation.queryStatuses", params, "subjectStatuses", cctx.Int("limit"))
+	},
+}
@@ -0,0 +1,869 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/plc"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+var plcCmd = &cli.Command{
+	Name:  "plc",
+	Usage: "sub-commands for inspecting and updating did:plc identities",
+	Description: `Operations can be signed with a locally held rotation key (--key-file, a
+multibase-encoded private key), or by the account's PDS (--via-pds, using a
+token from 'plc request-token'). For offline signing, 'plc update'
+without either writes the unsigned operation out, which can then be signed
+with 'plc sign' and sent with 'plc submit'.
+
+If a lower priority rotation key was used to take over a DID, 'plc recover'
+uses a higher priority key to replace the hostile operations, within 72 hours
+of the first of them.
+
+'plc tombstone' permanently deactivates a DID. It is always a dry run first;
+see its help for the confirmation steps.`,
+	Subcommands: []*cli.Command{
+		plcShowCmd,
+		plcRequestTokenCmd,
+		plcUpdateCmd,
+		plcSignCmd,
+		plcSubmitCmd,
+		plcRecoverCmd,
+		plcTombstoneCmd,
+		plcMnemonicCmd,
+		plcDeriveKeyCmd,
+	},
+}
+
+var plcShowCmd = &cli.Command{
+	Name:      "show",
+	Usage:     "print the current PLC operation for a DID",
+	ArgsUsage: `<did>`,
+	Action: func(cctx *cli.Context) error {
+		args, err := needArgs(cctx, "did")
+		if err != nil {
+			return err
+		}
+		did, err := syntax.ParseDID(args[0])
+		if err != nil {
+			return err
+		}
+
+		op, opCid, err := plc.FetchLastOperation(cctx.Context, cliutil.NewHttpClient(), cctx.String("plc"), did.String())
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "cid: %s\n", opCid)
+		jsonPrint(op)
+		return nil
+	},
+}
+
+var plcRequestTokenCmd = &cli.Command{
+	Name:  "request-token",
+	Usage: "ask the PDS to email a token for signing a PLC operation with --via-pds",
+	Action: func(cctx *cli.Context) error {
+		xrpcc, err := cliutil.GetXrpcClient(cctx, true)
+		if err != nil {
+			return err
+		}
+		if err := xrpcc.Do(cctx.Context, xrpc.Procedure, "", "com.atproto.identity.requestPlcOperationSignature", nil, nil, nil); err != nil {
+			return err
+		}
+
+		fmt.Println("a PLC operation token was sent to the account's email")
+		return nil
+	},
+}
+
+var plcUpdateCmd = &cli.Command{
+	Name:      "update",
+	Usage:     "build, sign and submit a PLC operation changing keys, PDS or handle",
+	ArgsUsage: `<did>`,
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "add-rotation-key",
+			Usage: "did:key to add as the highest priority rotation key",
+		},
+		&cli.StringSliceFlag{
+			Name:  "remove-rotation-key",
+			Usage: "did:key to remove from the rotation keys",
+		},
+		&cli.StringFlag{
+			Name:  "pds",
+			Usage: "new PDS endpoint URL",
+		},
+		&cli.StringFlag{
+			Name:  "handle",
+			Usage: "new handle",
+		},
+		&cli.StringFlag{
+			Name:  "signing-key",
+			Usage: "new atproto signing key, as a did:key",
+		},
+		&cli.StringFlag{
+			Name:  "key-file",
+			Usage: "file with a multibase-encoded private rotation key to sign with",
+		},
+		&cli.BoolFlag{
+			Name:  "via-pds",
+			Usage: "have the account's PDS sign the operation (requires --token and a session)",
+		},
+		&cli.StringFlag{
+			Name:  "token",
+			Usage: "PLC operation token emailed by the PDS, for --via-pds",
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "write the unsigned operation to this file instead of signing it",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "show the changes but don't sign or submit anything",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "don't ask for confirmation before submitting",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "allow operations which remove the signing rotation key",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := cctx.Context
+		args, err := needArgs(cctx, "did")
+		if err != nil {
+			return err
+		}
+		did, err := syntax.ParseDID(args[0])
+		if err != nil {
+			return err
+		}
+		if cctx.IsSet("key-file") && cctx.Bool("via-pds") {
+			return fmt.Errorf("--key-file and --via-pds can't be used together")
+		}
+
+		httpc := cliutil.NewHttpClient()
+		plcHost := cctx.String("plc")
+		prev, prevCid, err := plc.FetchLastOperation(ctx, httpc, plcHost, did.String())
+		if err != nil {
+			return err
+		}
+		if prev.Type == plc.OpTypeTombstone {
+			return fmt.Errorf("%s has been tombstoned", did)
+		}
+
+		op := prev.Copy()
+		op.Prev = &prevCid
+		if err := applyPlcChanges(cctx, op); err != nil {
+			return err
+		}
+		if err := op.Validate(); err != nil {
+			return err
+		}
+
+		changed := printPlcDiff(os.Stdout, prev, op)
+		if !changed {
+			return fmt.Errorf("operation makes no changes")
+		}
+		if cctx.Bool("dry-run") {
+			return nil
+		}
+
+		switch {
+		case cctx.Bool("via-pds"):
+			op, err = signPlcOperationViaPDS(ctx, cctx, op)
+			if err != nil {
+				return err
+			}
+			// the PDS may have adjusted the operation, so show what it
+			// actually signed before going ahead
+			if printPlcDiff(os.Stdout, prev, op) {
+				fmt.Println("(as signed by the PDS)")
+			}
+		case cctx.IsSet("key-file"):
+			key, err := loadPlcKeyFile(cctx.String("key-file"))
+			if err != nil {
+				return err
+			}
+			if err := checkPlcSigningKey(cctx, prev, op, key); err != nil {
+				return err
+			}
+			if err := op.Sign(key); err != nil {
+				return err
+			}
+		default:
+			return writePlcOperation(cctx.String("output"), op)
+		}
+
+		if _, err := op.VerifySignature(prev.RotationKeys); err != nil {
+			return fmt.Errorf("signed operation doesn't verify against the current rotation keys: %w", err)
+		}
+
+		if !cctx.Bool("yes") && !confirm("submit this operation?") {
+			return fmt.Errorf("aborted")
+		}
+		if err := plc.SubmitOperation(ctx, httpc, plcHost, did.String(), op); err != nil {
+			return err
+		}
+
+		fmt.Println("operation submitted")
+		return nil
+	},
+}
+
+var plcSignCmd = &cli.Command{
+	Name:      "sign",
+	Usage:     "sign an unsigned PLC operation with a local rotation key",
+	ArgsUsage: `<operation-file>`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "key-file",
+			Usage:    "file with a multibase-encoded private rotation key",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "write the signed operation to this file instead of stdout",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		args, err := needArgs(cctx, "operation-file")
+		if err != nil {
+			return err
+		}
+		op, err := readPlcOperation(args[0])
+		if err != nil {
+			return err
+		}
+		if err := op.Validate(); err != nil {
+			return err
+		}
+
+		key, err := loadPlcKeyFile(cctx.String("key-file"))
+		if err != nil {
+			return err
+		}
+		if err := op.Sign(key); err != nil {
+			return err
+		}
+		return writePlcOperation(cctx.String("output"), op)
+	},
+}
+
+var plcMnemonicCmd = &cli.Command{
+	Name:  "new-mnemonic",
+	Usage: "generate a mnemonic phrase to back up rotation keys on paper",
+	Description: `Prints a new 24 word phrase. Keys are derived from it with 'plc derive-key';
+anyone with the phrase (and passphrase, if one is used) can derive them.`,
+	Action: func(cctx *cli.Context) error {
+		m, err := crypto.GenerateMnemonic()
+		if err != nil {
+			return err
+		}
+		fmt.Println(m)
+		return nil
+	},
+}
+
+var plcDeriveKeyCmd = &cli.Command{
+	Name:  "derive-key",
+	Usage: "derive a rotation key from a mnemonic phrase read from stdin",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "path",
+			Usage: "derivation path; use a different one for each key",
+			Value: "plc/rotation/0",
+		},
+		&cli.StringFlag{
+			Name:  "curve",
+			Usage: "key type: k256 or p256",
+			Value: "k256",
+		},
+		&cli.StringFlag{
+			Name:    "passphrase",
+			Usage:   "optional passphrase, in addition to the mnemonic",
+			EnvVars: []string{"PLC_KEY_PASSPHRASE"},
+		},
+		&cli.StringFlag{
+			Name:  "key-file",
+			Usage: "write the multibase-encoded private key to this file, for use with --key-file",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		fmt.Fprintln(os.Stderr, "enter mnemonic:")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err := crypto.ValidateMnemonic(line); err != nil {
+			return err
+		}
+
+		var key crypto.PrivateKeyExportable
+		switch cctx.String("curve") {
+		case "k256":
+			key, err = crypto.DerivePrivateKeyK256(line, cctx.String("passphrase"), cctx.String("path"))
+		case "p256":
+			key, err = crypto.DerivePrivateKeyP256(line, cctx.String("passphrase"), cctx.String("path"))
+		default:
+			return fmt.Errorf("unknown curve %q", cctx.String("curve"))
+		}
+		if err != nil {
+			return err
+		}
+		pub, err := key.PublicKey()
+		if err != nil {
+			return err
+		}
+
+		if fname := cctx.String("key-file"); fname != "" {
+			var mb string
+			switch k := key.(type) {
+			case *crypto.PrivateKeyK256:
+				mb = k.Multibase()
+			case *crypto.PrivateKeyP256:
+				mb = k.Multibase()
+			}
+			if err := os.WriteFile(fname, []byte(mb+"\n"), 0600); err != nil {
+				return err
+			}
+		}
+		fmt.Println(pub.DIDKey())
+		return nil
+	},
+}
+
+var plcSubmitCmd = &cli.Command{
+	Name:      "submit",
+	Usage:     "submit a signed PLC operation to the directory",
+	ArgsUsage: `<did> <operation-file>`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "don't ask for confirmation before submitting",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := cctx.Context
+		args, err := needArgs(cctx, "did", "operation-file")
+		if err != nil {
+			return err
+		}
+		did, err := syntax.ParseDID(args[0])
+		if err != nil {
+			return err
+		}
+		op, err := readPlcOperation(args[1])
+		if err != nil {
+			return err
+		}
+		if op.Sig == "" {
+			return fmt.Errorf("operation is not signed")
+		}
+
+		httpc := cliutil.NewHttpClient()
+		plcHost := cctx.String("plc")
+		prev, prevCid, err := plc.FetchLastOperation(ctx, httpc, plcHost, did.String())
+		if err != nil {
+			return err
+		}
+		if op.Prev == nil || *op.Prev != prevCid {
+			return fmt.Errorf("operation doesn't follow the current head of the log (%s)", prevCid)
+		}
+		signer, err := op.VerifySignature(prev.RotationKeys)
+		if err != nil {
+			return fmt.Errorf("operation isn't signed by a current rotation key: %w", err)
+		}
+		fmt.Printf("signed by: %s\n", signer)
+
+		printPlcDiff(os.Stdout, prev, op)
+		if !cctx.Bool("yes") && !confirm("submit this operation?") {
+			return fmt.Errorf("aborted")
+		}
+		if err := plc.SubmitOperation(ctx, httpc, plcHost, did.String(), op); err != nil {
+			return err
+		}
+
+		fmt.Println("operation submitted")
+		return nil
+	},
+}
+
+var plcRecoverCmd = &cli.Command{
+	Name:      "recover",
+	Usage:     "use a higher priority rotation key to undo recent operations",
+	ArgsUsage: `<did>`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "key-file",
+			Usage:    "file with a multibase-encoded private rotation key to sign with",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "CID of the operation to roll back to (default: before the latest rotation key change not signed by this key)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "add-rotation-key",
+			Usage: "did:key to add as the highest priority rotation key",
+		},
+		&cli.StringSliceFlag{
+			Name:  "remove-rotation-key",
+			Usage: "did:key to remove from the rotation keys (eg, a compromised one)",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "show the recovery plan but don't sign or submit anything",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "don't ask for confirmation before submitting",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "allow operations which remove the signing rotation key",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := cctx.Context
+		args, err := needArgs(cctx, "did")
+		if err != nil {
+			return err
+		}
+		did, err := syntax.ParseDID(args[0])
+		if err != nil {
+			return err
+		}
+		key, err := loadPlcKeyFile(cctx.String("key-file"))
+		if err != nil {
+			return err
+		}
+		pub, err := key.PublicKey()
+		if err != nil {
+			return err
+		}
+
+		httpc := cliutil.NewHttpClient()
+		plcHost := cctx.String("plc")
+		entries, err := plc.FetchAuditLog(ctx, httpc, plcHost, did.String())
+		if err != nil {
+			return err
+		}
+		current, _, err := plc.FetchLastOperation(ctx, httpc, plcHost, did.String())
+		if err != nil {
+			return err
+		}
+
+		plan, err := plc.PlanRecovery(entries, pub.DIDKey(), cctx.String("to"), time.Now())
+		if err != nil {
+			return err
+		}
+		if err := applyPlcChanges(cctx, plan.Op); err != nil {
+			return err
+		}
+		if err := plan.Op.Validate(); err != nil {
+			return err
+		}
+
+		fmt.Printf("rolling back to: %s\n", plan.TargetCID)
+		fmt.Printf("nullifies %d operation(s), the first signed by %s:\n", len(plan.Nullified), plan.NullifiedSigner)
+		for _, e := range plan.Nullified {
+			fmt.Printf("  %s  %s\n", e.CreatedAt, e.CID)
+		}
+		fmt.Printf("recovery window closes: %s (in %s)\n", plan.Deadline.Format(time.RFC3339), time.Until(plan.Deadline).Round(time.Minute))
+		fmt.Println()
+		fmt.Println("changes from the current state:")
+		if !printPlcDiff(os.Stdout, current, plan.Op) {
+			fmt.Println("  (none)")
+		}
+		if cctx.Bool("dry-run") {
+			return nil
+		}
+
+		if err := checkPlcSigningKey(cctx, plan.Target, plan.Op, key); err != nil {
+			return err
+		}
+		if err := plan.Op.Sign(key); err != nil {
+			return err
+		}
+		if _, err := plan.Op.VerifySignature(plan.Target.RotationKeys); err != nil {
+			return fmt.Errorf("signed operation doesn't verify against the target's rotation keys: %w", err)
+		}
+
+		if !cctx.Bool("yes") && !confirm("submit this recovery operation?") {
+			return fmt.Errorf("aborted")
+		}
+		if err := plc.SubmitOperation(ctx, httpc, plcHost, did.String(), plan.Op); err != nil {
+			return err
+		}
+
+		fmt.Println("recovery operation submitted")
+		return nil
+	},
+}
+
+var plcTombstoneCmd = &cli.Command{
+	Name:      "tombstone",
+	Usage:     "permanently deactivate a DID (dry run, then --execute)",
+	ArgsUsage: `<did>`,
+	Description: `Without --execute, signs a tombstone operation and writes it to the --plan
+file, printing what it does, without submitting anything. Running again
+with --execute and the same --plan submits it, after the DID is typed in to
+confirm. With --delay on the dry run, the plan can only be executed once the
+delay has passed, leaving time to reconsider; plans expire a day after that.`,
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "key-file",
+			Usage: "file with a multibase-encoded private rotation key to sign with (for the dry run)",
+		},
+	}, guardedFlags...),
+	Action: func(cctx *cli.Context) error {
+		ctx := cctx.Context
+		args, err := needArgs(cctx, "did")
+		if err != nil {
+			return err
+		}
+		did, err := syntax.ParseDID(args[0])
+		if err != nil {
+			return err
+		}
+
+		httpc := cliutil.NewHttpClient()
+		plcHost := cctx.String("plc")
+		prev, prevCid, err := plc.FetchLastOperation(ctx, httpc, plcHost, did.String())
+		if err != nil {
+			return err
+		}
+		if prev.Type == plc.OpTypeTombstone {
+			return fmt.Errorf("%s has already been tombstoned", did)
+		}
+		warnings := plcTombstoneWarnings(prev)
+
+		if !cctx.Bool("execute") {
+			if !cctx.IsSet("key-file") {
+				return fmt.Errorf("the dry run needs --key-file to sign the tombstone")
+			}
+			key, err := loadPlcKeyFile(cctx.String("key-file"))
+			if err != nil {
+				return err
+			}
+			pub, err := key.PublicKey()
+			if err != nil {
+				return err
+			}
+			if !containsString(prev.RotationKeys, pub.DIDKey()) {
+				return fmt.Errorf("key %s is not a current rotation key for this DID", pub.DIDKey())
+			}
+
+			op := &plc.Operation{Type: plc.OpTypeTombstone, Prev: &prevCid}
+			if err := op.Sign(key); err != nil {
+				return err
+			}
+			return writeGuardedPlan(cctx, "plc-tombstone", did.String(), op, warnings)
+		}
+
+		plan, err := readGuardedPlan(cctx, "plc-tombstone", did.String())
+		if err != nil {
+			return err
+		}
+		op, err := plc.ParseOperation(plan.Operation)
+		if err != nil {
+			return err
+		}
+		if op.Type != plc.OpTypeTombstone {
+			return fmt.Errorf("plan operation is not a tombstone")
+		}
+		if op.Prev == nil || *op.Prev != prevCid {
+			return fmt.Errorf("the DID has been updated since the dry run (head is now %s); do a new dry run", prevCid)
+		}
+		if _, err := op.VerifySignature(prev.RotationKeys); err != nil {
+			return fmt.Errorf("tombstone isn't signed by a current rotation key: %w", err)
+		}
+
+		if err := confirmGuardedPlan(plan, warnings); err != nil {
+			return err
+		}
+		if err := plc.SubmitOperation(ctx, httpc, plcHost, did.String(), op); err != nil {
+			return err
+		}
+		finishGuardedPlan(cctx)
+
+		fmt.Println("tombstone submitted")
+		return nil
+	},
+}
+
+func plcTombstoneWarnings(prev *plc.Operation) []string {
+	out := []string{
+		"tombstoning is PERMANENT: the DID can never be updated or used again",
+		"the DID document will stop resolving, so the account's handle, PDS and signing key are lost, and apps treat the account as gone",
+		"only a higher priority rotation key can undo this, within 72 hours",
+	}
+	if len(prev.AlsoKnownAs) > 0 {
+		out = append(out, fmt.Sprintf("the DID is currently %s", strings.Join(prev.AlsoKnownAs, ", ")))
+	}
+	if svc, ok := prev.Services["atproto_pds"]; ok {
+		out = append(out, fmt.Sprintf("the DID is currently hosted on %s", svc.Endpoint))
+	}
+	return out
+}
+
+func containsString(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPlcChanges modifies op according to the 'plc update' flags.
+func applyPlcChanges(cctx *cli.Context, op *plc.Operation) error {
+	for _, k := range cctx.StringSlice("remove-rotation-key") {
+		idx := -1
+		for i, rk := range op.RotationKeys {
+			if rk == k {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			return fmt.Errorf("not a current rotation key: %s", k)
+		}
+		op.RotationKeys = append(op.RotationKeys[:idx], op.RotationKeys[idx+1:]...)
+	}
+
+	// keys are added in reverse, so that the first one given ends up first
+	add := cctx.StringSlice("add-rotation-key")
+	for i := len(add) - 1; i >= 0; i-- {
+		op.RotationKeys = append([]string{add[i]}, op.RotationKeys...)
+	}
+
+	if k := cctx.String("signing-key"); k != "" {
+		if op.VerificationMethods == nil {
+			op.VerificationMethods = make(map[string]string)
+		}
+		op.VerificationMethods["atproto"] = k
+	}
+
+	if h := cctx.String("handle"); h != "" {
+		handle, err := syntax.ParseHandle(h)
+		if err != nil {
+			return err
+		}
+		// replace the first at:// entry in place, keeping any other aliases
+		aka := "at://" + handle.Normalize().String()
+		replaced := false
+		for i, a := range op.AlsoKnownAs {
+			if strings.HasPrefix(a, "at://") {
+				op.AlsoKnownAs[i] = aka
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			op.AlsoKnownAs = append([]string{aka}, op.AlsoKnownAs...)
+		}
+	}
+
+	if p := cctx.String("pds"); p != "" {
+		if !strings.HasPrefix(p, "https://") && !strings.HasPrefix(p, "http://") {
+			return fmt.Errorf("PDS endpoint must be an http(s) URL: %s", p)
+		}
+		if op.Services == nil {
+			op.Services = make(map[string]plc.OpService)
+		}
+		op.Services["atproto_pds"] = plc.OpService{
+			Type:     "AtprotoPersonalDataServer",
+			Endpoint: strings.TrimSuffix(p, "/"),
+		}
+	}
+	return nil
+}
+
+// checkPlcSigningKey makes sure the local key can sign for the DID, and
+// guards against operations which remove that key from the rotation keys.
+func checkPlcSigningKey(cctx *cli.Context, prev, op *plc.Operation, key crypto.PrivateKey) error {
+	pub, err := key.PublicKey()
+	if err != nil {
+		return err
+	}
+	didKey := pub.DIDKey()
+
+	found := false
+	for _, k := range prev.RotationKeys {
+		if k == didKey {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("key %s is not a current rotation key for this DID", didKey)
+	}
+
+	for _, k := range op.RotationKeys {
+		if k == didKey {
+			return nil
+		}
+	}
+	if !cctx.Bool("force") {
+		return fmt.Errorf("operation removes the signing key %s from the rotation keys; use --force if this is intended", didKey)
+	}
+	return nil
+}
+
+func signPlcOperationViaPDS(ctx context.Context, cctx *cli.Context, op *plc.Operation) (*plc.Operation, error) {
+	token := cctx.String("token")
+	if token == "" {
+		return nil, fmt.Errorf("--via-pds requires --token")
+	}
+	xrpcc, err := cliutil.GetXrpcClient(cctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// the PDS fills in prev itself
+	body := map[string]any{
+		"token":               token,
+		"rotationKeys":        op.RotationKeys,
+		"alsoKnownAs":         op.AlsoKnownAs,
+		"verificationMethods": op.VerificationMethods,
+		"services":            op.Services,
+	}
+	var out struct {
+		Operation json.RawMessage `json:"operation"`
+	}
+	if err := xrpcc.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.identity.signPlcOperation", nil, body, &out); err != nil {
+		return nil, fmt.Errorf("signing operation on PDS: %w", err)
+	}
+	return plc.ParseOperation(out.Operation)
+}
+
+func loadPlcKeyFile(fname string) (crypto.PrivateKey, error) {
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crypto.ParsePrivateMultibase(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	return key, nil
+}
+
+func readPlcOperation(fname string) (*plc.Operation, error) {
+	var b []byte
+	var err error
+	if fname == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(fname)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plc.ParseOperation(b)
+}
+
+func writePlcOperation(fname string, op *plc.Operation) error {
+	b, err := json.MarshalIndent(op, "", "  ")
+	if err != nil {
+		return err
+	}
+	if fname == "" {
+		fmt.Println(string(b))
+		return nil
+	}
+	return os.WriteFile(fname, append(b, '\n'), 0644)
+}
+
+// printPlcDiff prints the differences between two operations, and returns
+// whether there were any.
+func printPlcDiff(w io.Writer, prev, op *plc.Operation) bool {
+	changed := false
+	list := func(name string, a, b []string) {
+		if strings.Join(a, "\n") == strings.Join(b, "\n") {
+			return
+		}
+		changed = true
+		fmt.Fprintf(w, "%s:\n", name)
+		for _, v := range a {
+			fmt.Fprintf(w, "  - %s\n", v)
+		}
+		for _, v := range b {
+			fmt.Fprintf(w, "  + %s\n", v)
+		}
+	}
+	mapDiff := func(name string, a, b map[string]string) {
+		var keys []string
+		for k := range a {
+			keys = append(keys, k)
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		header := false
+		for _, k := range keys {
+			av, aok := a[k]
+			bv, bok := b[k]
+			if aok == bok && av == bv {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(w, "%s:\n", name)
+				header = true
+				changed = true
+			}
+			if aok {
+				fmt.Fprintf(w, "  - %s: %s\n", k, av)
+			}
+			if bok {
+				fmt.Fprintf(w, "  + %s: %s\n", k, bv)
+			}
+		}
+	}
+	services := func(m map[string]plc.OpService) map[string]string {
+		out := make(map[string]string, len(m))
+		for k, v := range m {
+			out[k] = v.Type + " " + v.Endpoint
+		}
+		return out
+	}
+
+	if prev.Type != op.Type {
+		changed = true
+		fmt.Fprintf(w, "type:\n  - %s\n  + %s\n", prev.Type, op.Type)
+	}
+	list("rotationKeys", prev.RotationKeys, op.RotationKeys)
+	mapDiff("verificationMethods", prev.VerificationMethods, op.VerificationMethods)
+	list("alsoKnownAs", prev.AlsoKnownAs, op.AlsoKnownAs)
+	mapDiff("services", services(prev.Services), services(op.Services))
+	return changed
+}
+
+func confirm(prompt string) bool {
+	fmt.Printf("%s [y/N] ", prompt)
+	inp := bufio.NewScanner(os.Stdin)
+	if !inp.Scan() {
+		return false
+	}
+	ans := strings.ToLower(strings.TrimSpace(inp.Text()))
+	return ans == "y" || ans == "yes"
+}
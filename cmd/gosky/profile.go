@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+var profileCmd = &cli.Command{
+	Name:  "profile",
+	Usage: "sub-commands for managing named account profiles",
+	Description: `Profiles are stored in the shared indigo config file (indigo/config.json in
+the user config directory, or $INDIGO_CONFIG), with their credentials in the
+OS keychain if one is available, and otherwise in files readable only by the
+user, alongside the 'account login' OAuth session (set
+$INDIGO_CREDENTIAL_STORE, or "CredentialStore" in the config file, to
+"keyring" or "file" to choose). Select a profile per command with --profile
+(or ATP_PROFILE), or set a default with 'profile switch'.`,
+	Subcommands: []*cli.Command{
+		profileListCmd,
+		profileLoginCmd,
+		profileLogoutCmd,
+		profileSwitchCmd,
+	},
+}
+
+// loadConfig applies settings from the config file to flags which weren't
+// given, and fills in --profile from the config file's current profile, if
+// one wasn't given and no explicit credentials were passed.
+func loadConfig(cctx *cli.Context) error {
+	cfg, err := cliutil.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Apply(cctx, "gosky"); err != nil {
+		return err
+	}
+
+	if cctx.IsSet("profile") || cctx.IsSet("auth") {
+		return nil
+	}
+	if cfg.CurrentProfile == "" {
+		return nil
+	}
+	return cctx.Set("profile", cfg.CurrentProfile)
+}
+
+var profileListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "list configured profiles",
+	Action: func(cctx *cli.Context) error {
+		cfg, err := cliutil.LoadConfig()
+		if err != nil {
+			return err
+		}
+
+		var names []string
+		for name := range cfg.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			prof := cfg.Profiles[name]
+			mark := " "
+			if name == cfg.CurrentProfile {
+				mark = "*"
+			}
+			kind := "password"
+			if prof.OAuth || prof.OAuthSession != "" {
+				kind = "oauth"
+			}
+			store := "file"
+			if prof.Credential != "" {
+				store = prof.CredentialStore
+			}
+			fmt.Printf("%s %s\t%s\t%s\t%s\n", mark, name, prof.PDS, kind, store)
+		}
+		return nil
+	},
+}
+
+var profileLoginCmd = &cli.Command{
+	Name:      "login",
+	Usage:     "log in and save the session as a named profile",
+	ArgsUsage: `<profile> <handle-or-did> [<password>]`,
+	Description: `With a password (or app password), creates a session on the account's PDS
+(or --pds-host if set). Without one, logs in with OAuth in a web browser.`,
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		args, err := needArgs(cctx, "profile", "handle-or-did")
+		if err != nil {
+			return err
+		}
+		name, account := args[0], args[1]
+		password := cctx.Args().Get(2)
+		if err := cliutil.ValidateProfileName(name); err != nil {
+			return err
+		}
+
+		cfg, err := cliutil.LoadConfig()
+		if err != nil {
+			return err
+		}
+		if old := cfg.Profiles[name]; old != nil {
+			if err := old.RemoveCredentials(); err != nil {
+				return err
+			}
+		}
+
+		store, err := cliutil.DefaultCredentialStore()
+		if err != nil {
+			return err
+		}
+		key := cliutil.ProfileCredentialKey(name)
+
+		var prof *cliutil.Profile
+		if password == "" {
+			sess, err := cliutil.OAuthLogin(ctx, account, func(authURL string) {
+				fmt.Fprintf(os.Stderr, "open this URL in your browser to log in:\n\n  %s\n\n", authURL)
+			})
+			if err != nil {
+				return err
+			}
+			if err := sess.SaveToStore(store, key); err != nil {
+				return err
+			}
+			prof = &cliutil.Profile{PDS: sess.PDS, Credential: key, CredentialStore: store.Kind(), OAuth: true}
+		} else {
+			host := cctx.String("pds-host")
+			if !cctx.IsSet("pds-host") {
+				atid, err := syntax.ParseAtIdentifier(account)
+				if err != nil {
+					return err
+				}
+				ident, err := identity.DefaultDirectory().Lookup(ctx, *atid)
+				if err != nil {
+					return err
+				}
+				if host = ident.PDSEndpoint(); host == "" {
+					return fmt.Errorf("no PDS endpoint for identity")
+				}
+			}
+
+			xrpcc := &xrpc.Client{Client: cliutil.NewHttpClient(), Host: host}
+			ses, err := comatproto.ServerCreateSession(ctx, xrpcc, &comatproto.ServerCreateSession_Input{
+				Identifier: account,
+				Password:   password,
+			})
+			if err != nil {
+				return err
+			}
+
+			b, err := json.Marshal(&xrpc.AuthInfo{
+				AccessJwt:  ses.AccessJwt,
+				RefreshJwt: ses.RefreshJwt,
+				Handle:     ses.Handle,
+				Did:        ses.Did,
+			})
+			if err != nil {
+				return err
+			}
+			if err := store.Set(key, b); err != nil {
+				return err
+			}
+			prof = &cliutil.Profile{PDS: host, Credential: key, CredentialStore: store.Kind()}
+		}
+
+		if cfg.Profiles == nil {
+			cfg.Profiles = make(map[string]*cliutil.Profile)
+		}
+		cfg.Profiles[name] = prof
+		if cfg.CurrentProfile == "" {
+			cfg.CurrentProfile = name
+		}
+		if err := cliutil.WriteConfig(cfg); err != nil {
+			return err
+		}
+
+		fmt.Printf("saved profile %s (%s), credentials in %s store\n", name, prof.PDS, store.Kind())
+		return nil
+	},
+}
+
+var profileLogoutCmd = &cli.Command{
+	Name:      "logout",
+	Usage:     "end a profile's session and remove it",
+	ArgsUsage: `<profile>`,
+	Action: func(cctx *cli.Context) error {
+		args, err := needArgs(cctx, "profile")
+		if err != nil {
+			return err
+		}
+		name := args[0]
+
+		cfg, err := cliutil.LoadConfig()
+		if err != nil {
+			return err
+		}
+		prof := cfg.Profiles[name]
+		if prof == nil {
+			return fmt.Errorf("no such profile: %s", name)
+		}
+
+		// best effort: the local credentials are removed even if the PDS
+		// can't be reached
+		if auth, err := prof.LoadAuth(); err == nil && auth != nil {
+			auth.AccessJwt = auth.RefreshJwt
+			xrpcc := &xrpc.Client{Client: cliutil.NewHttpClient(), Host: prof.PDS, Auth: auth}
+			if err := comatproto.ServerDeleteSession(cctx.Context, xrpcc); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to delete session on PDS: %s\n", err)
+			}
+		}
+
+		if err := prof.RemoveCredentials(); err != nil {
+			return err
+		}
+		delete(cfg.Profiles, name)
+		if cfg.CurrentProfile == name {
+			cfg.CurrentProfile = ""
+		}
+		return cliutil.WriteConfig(cfg)
+	},
+}
+
+var profileSwitchCmd = &cli.Command{
+	Name:      "switch",
+	Usage:     "set the profile used when --profile isn't given",
+	ArgsUsage: `<profile>`,
+	Action: func(cctx *cli.Context) error {
+		args, err := needArgs(cctx, "profile")
+		if err != nil {
+			return err
+		}
+
+		cfg, err := cliutil.LoadConfig()
+		if err != nil {
+			return err
+		}
+		if cfg.Profiles[args[0]] == nil {
+			return fmt.Errorf("no such profile: %s", args[0])
+		}
+		cfg.CurrentProfile = args[0]
+		return cliutil.WriteConfig(cfg)
+	},
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	cli "github.com/urfave/cli/v2"
+)
+
+var recordCmd = &cli.Command{
+	Name:  "record",
+	Usage: "sub-commands for working with records in any collection",
+	Subcommands: []*cli.Command{
+		recordGetCmd,
+		recordListCmd,
+		recordPutCmd,
+		recordDeleteCmd,
+	},
+}
+
+var recordLexiconsFlag = &cli.StringFlag{
+	Name:  "lexicons",
+	Usage: "directory of lexicon schemas to validate records against before writing",
+}
+
+// publicRecordClient returns a client pointed at the PDS hosting the given
+// account, unless a PDS was set explicitly.
+func publicRecordClient(ctx context.Context, cctx *cli.Context, atid syntax.AtIdentifier) (*xrpc.Client, syntax.DID, error) {
+	ident, err := identity.DefaultDirectory().Lookup(ctx, atid)
+	if err != nil {
+		return nil, "", err
+	}
+
+	xrpcc, err := cliutil.GetXrpcClient(cctx, false)
+	if err != nil {
+		return nil, "", err
+	}
+	if !cctx.IsSet("pds-host") {
+		xrpcc.Host = ident.PDSEndpoint()
+		if xrpcc.Host == "" {
+			return nil, "", fmt.Errorf("no PDS endpoint for identity")
+		}
+	}
+	return xrpcc, ident.DID, nil
+}
+
+var recordGetCmd = &cli.Command{
+	Name:      "get",
+	Usage:     "fetch a single record by AT-URI and print it as JSON",
+	ArgsUsage: `<at-uri>`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "full",
+			Usage: "print the full getRecord response (uri, cid and value), not just the value",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		args, err := needArgs(cctx, "at-uri")
+		if err != nil {
+			return err
+		}
+		aturi, err := syntax.ParseATURI(args[0])
+		if err != nil {
+			return err
+		}
+		if aturi.Collection() == "" || aturi.RecordKey() == "" {
+			return fmt.Errorf("AT-URI must include a collection and record key")
+		}
+
+		xrpcc, did, err := publicRecordClient(ctx, cctx, aturi.Authority())
+		if err != nil {
+			return err
+		}
+
+		// records are handled as raw JSON, so collections without generated
+		// types round-trip untouched
+		var out struct {
+			Uri   string          `json:"uri"`
+			Cid   *string         `json:"cid,omitempty"`
+			Value json.RawMessage `json:"value"`
+		}
+		params := map[string]interface{}{
+			"repo":       did.String(),
+			"collection": aturi.Collection().String(),
+			"rkey":       aturi.RecordKey().String(),
+		}
+		if err := xrpcc.Do(ctx, xrpc.Query, "", "com.atproto.repo.getRecord", params, nil, &out); err != nil {
+			return err
+		}
+
+		if cctx.Bool("full") {
+			jsonPrint(out)
+		} else {
+			jsonPrint(out.Value)
+		}
+		return nil
+	},
+}
+
+var recordListCmd = &cli.Command{
+	Name:      "list",
+	Usage:     "list all records in a collection, as one JSON object per line",
+	ArgsUsage: `<at-identifier> <collection>`,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "limit",
+			Usage: "maximum number of records to print (0 for all)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		args, err := needArgs(cctx, "at-identifier", "collection")
+		if err != nil {
+			return err
+		}
+		atid, err := syntax.ParseAtIdentifier(args[0])
+		if err != nil {
+			return err
+		}
+		collection, err := syntax.ParseNSID(args[1])
+		if err != nil {
+			return err
+		}
+
+		xrpcc, did, err := publicRecordClient(ctx, cctx, *atid)
+		if err != nil {
+			return err
+		}
+
+		limit := cctx.Int("limit")
+		count := 0
+		var cursor string
+		for {
+			var resp struct {
+				Cursor  *string           `json:"cursor,omitempty"`
+				Records []json.RawMessage `json:"records"`
+			}
+			params := map[string]interface{}{
+				"repo":       did.String(),
+				"collection": collection.String(),
+				"limit":      100,
+			}
+			if cursor != "" {
+				params["cursor"] = cursor
+			}
+			if err := xrpcc.Do(ctx, xrpc.Query, "", "com.atproto.repo.listRecords", params, nil, &resp); err != nil {
+				return err
+			}
+
+			for _, rec := range resp.Records {
+				fmt.Println(string(rec))
+				count++
+				if limit > 0 && count >= limit {
+					return nil
+				}
+			}
+
+			if resp.Cursor == nil || *resp.Cursor == "" || len(resp.Records) == 0 {
+				return nil
+			}
+			cursor = *resp.Cursor
+		}
+	},
+}
+
+var recordPutCmd = &cli.Command{
+	Name:      "put",
+	Usage:     "create or overwrite a record from a JSON file (or '-' for stdin)",
+	ArgsUsage: `<collection> <json-file>`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "rkey",
+			Usage: "record key to write (a new record with a generated key is created if not set)",
+		},
+		recordLexiconsFlag,
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		args, err := needArgs(cctx, "collection", "json-file")
+		if err != nil {
+			return err
+		}
+
+		var b []byte
+		if args[1] == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(args[1])
+		}
+		if err != nil {
+			return err
+		}
+
+		cat, err := loadLexiconCatalog(cctx.String("lexicons"))
+		if err != nil {
+			return err
+		}
+
+		// reuse the apply-writes checks: NSID and rkey syntax, $type, and
+		// optional lexicon validation
+		w := bulkWrite{
+			Action:     "create",
+			Collection: args[0],
+			Rkey:       cctx.String("rkey"),
+			Value:      b,
+		}
+		if w.Rkey != "" {
+			w.Action = "update"
+		}
+		if err := w.normalize(cat); err != nil {
+			return err
+		}
+
+		xrpcc, err := cliutil.GetXrpcClient(cctx, true)
+		if err != nil {
+			return err
+		}
+
+		body := map[string]any{
+			"repo":       xrpcc.Auth.Did,
+			"collection": w.Collection,
+			"record":     w.Value,
+		}
+		method := "com.atproto.repo.createRecord"
+		if w.Rkey != "" {
+			body["rkey"] = w.Rkey
+			method = "com.atproto.repo.putRecord"
+		}
+
+		var out struct {
+			Uri string `json:"uri"`
+			Cid string `json:"cid"`
+		}
+		if err := xrpcc.Do(ctx, xrpc.Procedure, "application/json", method, nil, body, &out); err != nil {
+			return err
+		}
+
+		fmt.Println(out.Uri, out.Cid)
+		return nil
+	},
+}
+
+var recordDeleteCmd = &cli.Command{
+	Name:      "delete",
+	Usage:     "delete a record from the authenticated account's repo",
+	ArgsUsage: `<collection> <rkey>`,
+	Action: func(cctx *cli.Context) error {
+		ctx := context.Background()
+		args, err := needArgs(cctx, "collection", "rkey")
+		if err != nil {
+			return err
+		}
+
+		w := bulkWrite{Action: "delete", Collection: args[0], Rkey: args[1]}
+		if err := w.normalize(nil); err != nil {
+			return err
+		}
+
+		xrpcc, err := cliutil.GetXrpcClient(cctx, true)
+		if err != nil {
+			return err
+		}
+
+		body := map[string]any{
+			"repo":       xrpcc.Auth.Did,
+			"collection": w.Collection,
+			"rkey":       w.Rkey,
+		}
+		return xrpcc.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.repo.deleteRecord", nil, body, nil)
+	},
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	cli "github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+var resolveBatchCmd = &cli.Command{
+	Name:      "resolve-batch",
+	Usage:     "resolve a list of handles and DIDs, printing one JSON object per line",
+	ArgsUsage: `[<file>]`,
+	Description: `Reads one handle or DID per line from the file (or stdin), skipping blank
+lines and lines starting with '#'. Identifiers are resolved concurrently,
+so output lines are not in input order; each includes the "input" it was
+resolved from. Failures are printed with an "error" field rather than
+stopping the run.`,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "number of lookups to run at once",
+			Value: 8,
+		},
+		&cli.Float64Flag{
+			Name:  "rate",
+			Usage: "maximum lookups per second (0 for no limit)",
+			Value: 10,
+		},
+		&cli.BoolFlag{
+			Name:  "doc",
+			Usage: "include the full DID document in the output",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		ctx := cctx.Context
+
+		var in io.Reader = os.Stdin
+		if fname := cctx.Args().First(); fname != "" && fname != "-" {
+			fi, err := os.Open(fname)
+			if err != nil {
+				return err
+			}
+			defer fi.Close()
+			in = fi
+		}
+
+		dir := &identity.BaseDirectory{
+			PLCURL: cctx.String("plc"),
+			HTTPClient: http.Client{
+				Timeout: 15 * time.Second,
+			},
+			TryAuthoritativeDNS:   true,
+			SkipDNSDomainSuffixes: []string{".bsky.social"},
+		}
+
+		limiter := rate.NewLimiter(rate.Inf, 1)
+		if r := cctx.Float64("rate"); r > 0 {
+			limiter = rate.NewLimiter(rate.Limit(r), 1)
+		}
+
+		var outLk sync.Mutex
+		out := json.NewEncoder(os.Stdout)
+		emit := func(res *resolveResult) error {
+			outLk.Lock()
+			defer outLk.Unlock()
+			return out.Encode(res)
+		}
+
+		withDoc := cctx.Bool("doc")
+		eg, ctx := errgroup.WithContext(ctx)
+		eg.SetLimit(cctx.Int("concurrency"))
+
+		scan := bufio.NewScanner(in)
+		for scan.Scan() {
+			line := strings.TrimSpace(scan.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			eg.Go(func() error {
+				if err := limiter.Wait(ctx); err != nil {
+					return err
+				}
+				return emit(resolveIdentifier(ctx, dir, line, withDoc))
+			})
+		}
+		if err := scan.Err(); err != nil {
+			return err
+		}
+		return eg.Wait()
+	},
+}
+
+type resolveResult struct {
+	Input      string                `json:"input"`
+	DID        string                `json:"did,omitempty"`
+	Handle     string                `json:"handle,omitempty"`
+	PDS        string                `json:"pds,omitempty"`
+	SigningKey string                `json:"signingKey,omitempty"`
+	Doc        *identity.DIDDocument `json:"doc,omitempty"`
+	Error      string                `json:"error,omitempty"`
+}
+
+func resolveIdentifier(ctx context.Context, dir *identity.BaseDirectory, input string, withDoc bool) *resolveResult {
+	res := &resolveResult{Input: input}
+
+	atid, err := syntax.ParseAtIdentifier(strings.TrimPrefix(input, "@"))
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	ident, err := dir.Lookup(ctx, *atid)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	res.DID = ident.DID.String()
+	res.Handle = ident.Handle.String()
+	res.PDS = ident.PDSEndpoint()
+	if k, err := ident.PublicKey(); err == nil {
+		res.SigningKey = k.DIDKey()
+	}
+
+	if withDoc {
+		doc, err := dir.ResolveDID(ctx, ident.DID)
+		if err != nil {
+			res.Error = fmt.Sprintf("fetching DID document: %s", err)
+			return res
+		}
+		res.Doc = doc
+	}
+	return res
+}
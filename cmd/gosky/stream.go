@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// output encodings supported by read-stream
+const (
+	streamOutputText    = "text"
+	streamOutputJSON    = "json"
+	streamOutputJSONL   = "jsonl"
+	streamOutputCBORHex = "cbor-hex"
+)
+
+func parseStreamOutput(s string) (string, error) {
+	switch s {
+	case streamOutputText, streamOutputJSON, streamOutputJSONL, streamOutputCBORHex:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q (expected text, json, jsonl, or cbor-hex)", s)
+	}
+}
+
+// streamFilter selects which firehose events and ops get printed. A zero
+// value matches everything.
+type streamFilter struct {
+	dids        map[string]bool
+	collections []string
+}
+
+func newStreamFilter(dids, collections []string) *streamFilter {
+	f := &streamFilter{collections: collections}
+	if len(dids) > 0 {
+		f.dids = make(map[string]bool, len(dids))
+		for _, d := range dids {
+			f.dids[d] = true
+		}
+	}
+	return f
+}
+
+func (f *streamFilter) matchDid(did string) bool {
+	return f.dids == nil || f.dids[did]
+}
+
+// matchNonCommit reports whether an identity or account event for did should
+// be printed. Collection filters imply interest in records only, so these
+// events are dropped when any are set.
+func (f *streamFilter) matchNonCommit(did string) bool {
+	return len(f.collections) == 0 && f.matchDid(did)
+}
+
+// matchCollection accepts exact NSIDs, or prefixes ending in ".*" like
+// "app.bsky.feed.*".
+func (f *streamFilter) matchCollection(nsid string) bool {
+	if len(f.collections) == 0 {
+		return true
+	}
+	for _, c := range f.collections {
+		if strings.HasSuffix(c, ".*") {
+			if strings.HasPrefix(nsid, strings.TrimSuffix(c, "*")) {
+				return true
+			}
+		} else if c == nsid {
+			return true
+		}
+	}
+	return false
+}
+
+// filterOps returns the ops of a commit that match the collection filter, in
+// their original order.
+func (f *streamFilter) filterOps(ops []*comatproto.SyncSubscribeRepos_RepoOp) []*comatproto.SyncSubscribeRepos_RepoOp {
+	if len(f.collections) == 0 {
+		return ops
+	}
+	var out []*comatproto.SyncSubscribeRepos_RepoOp
+	for _, op := range ops {
+		coll, _, _ := strings.Cut(op.Path, "/")
+		if f.matchCollection(coll) {
+			out = append(out, op)
+		}
+	}
+	return out
+}
+
+// printCBORHex re-encodes the event body as CBOR and prints it hex encoded,
+// prefixed with the event kind, one event per line.
+func printCBORHex(w io.Writer, kind string, evt cbg.CBORMarshaler) error {
+	buf := new(bytes.Buffer)
+	if err := evt.MarshalCBOR(buf); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s %s\n", kind, hex.EncodeToString(buf.Bytes()))
+	return err
+}
+
+// printEventJSONL prints a non-commit event as a single JSON line with a
+// "kind" field added.
+func printEventJSONL(w io.Writer, kind string, evt any) error {
+	b, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	var out map[string]any
+	if err := json.Unmarshal(b, &out); err != nil {
+		return err
+	}
+	out["kind"] = kind
+
+	b, err = json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+type streamOpLine struct {
+	Kind       string          `json:"kind"`
+	Seq        int64           `json:"seq"`
+	Repo       string          `json:"repo"`
+	Rev        string          `json:"rev"`
+	Time       string          `json:"time"`
+	Action     string          `json:"action"`
+	Collection string          `json:"collection"`
+	Rkey       string          `json:"rkey"`
+	Cid        string          `json:"cid,omitempty"`
+	Record     json.RawMessage `json:"record,omitempty"`
+}
+
+// printCommitJSONL prints one JSON line per op, with the record for creates
+// and updates decoded from the commit's CAR slice. Records are decoded
+// generically, so collections without generated types come through intact.
+func printCommitJSONL(w io.Writer, evt *comatproto.SyncSubscribeRepos_Commit, ops []*comatproto.SyncSubscribeRepos_RepoOp) error {
+	var blocks map[cid.Cid][]byte
+	if len(evt.Blocks) > 0 {
+		blks, err := readCarBlocks(evt.Blocks)
+		if err != nil {
+			return fmt.Errorf("reading commit blocks: %w", err)
+		}
+		blocks = blks
+	}
+
+	for _, op := range ops {
+		coll, rkey, _ := strings.Cut(op.Path, "/")
+		line := streamOpLine{
+			Kind:       "commit",
+			Seq:        evt.Seq,
+			Repo:       evt.Repo,
+			Rev:        evt.Rev,
+			Time:       evt.Time,
+			Action:     op.Action,
+			Collection: coll,
+			Rkey:       rkey,
+		}
+
+		if op.Cid != nil {
+			c := cid.Cid(*op.Cid)
+			line.Cid = c.String()
+			if blk, ok := blocks[c]; ok {
+				rec, err := data.CBORToJSON(blk)
+				if err != nil {
+					return fmt.Errorf("decoding record %s: %w", op.Path, err)
+				}
+				line.Record = rec
+			}
+		}
+
+		b, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, string(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readCarBlocks(b []byte) (map[cid.Cid][]byte, error) {
+	carr, err := car.NewCarReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[cid.Cid][]byte)
+	for {
+		blk, err := carr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		out[blk.Cid()] = blk.RawData()
+	}
+	return out, nil
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/term"
+	"golang.org/x/time/rate"
+)
+
+// how many events the TUI keeps in memory; older ones scroll off the top
+const tuiMaxEvents = 10000
+
+const tuiHelp = "j/k move  g/G top/bottom  space pause  enter detail  J/K scroll detail  / filter  q quit"
+
+type tuiEvent struct {
+	did     string
+	summary string
+
+	commit *comatproto.SyncSubscribeRepos_Commit
+	raw    any
+
+	// rendered lazily, the first time the event is opened
+	detail []string
+}
+
+// streamTUI is the state of the interactive read-stream view. Events are
+// always kept unfiltered, so that editing the filter applies to everything
+// already received.
+type streamTUI struct {
+	url string
+
+	lk         sync.Mutex
+	events     []*tuiEvent
+	pending    []*tuiEvent
+	received   int
+	paused     bool
+	filter     *streamFilter
+	filterText string
+	status     string
+
+	sel          *tuiEvent
+	follow       bool
+	top          int
+	showDetail   bool
+	detailScroll int
+	editing      bool
+	input        []rune
+
+	lastCount int
+	lastTime  time.Time
+	rate      float64
+}
+
+// runStreamTUI consumes the stream on con and displays it full-screen until
+// the user quits or ctx is cancelled.
+func runStreamTUI(ctx context.Context, con *websocket.Conn, url string, filter *streamFilter, filterText string, limiter *rate.Limiter) error {
+	inFd := int(os.Stdin.Fd())
+	if !term.IsTerminal(inFd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return fmt.Errorf("--tui requires an interactive terminal")
+	}
+
+	oldState, err := term.MakeRaw(inFd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(inFd, oldState)
+
+	// alternate screen, hidden cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = con.Close()
+	}()
+
+	t := &streamTUI{
+		url:        url,
+		filter:     filter,
+		filterText: filterText,
+		follow:     true,
+		lastTime:   time.Now(),
+	}
+
+	streamErr := make(chan error, 1)
+	go func() {
+		rsc := t.callbacks(ctx, limiter)
+		seqScheduler := sequential.NewScheduler(con.RemoteAddr().String(), rsc.EventHandler)
+		streamErr <- events.HandleRepoStream(ctx, con, seqScheduler)
+	}()
+
+	keys := make(chan string, 16)
+	go readTUIKeys(keys)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-streamErr:
+			t.lk.Lock()
+			if err != nil {
+				t.status = fmt.Sprintf("stream closed: %s", err)
+			} else {
+				t.status = "stream closed"
+			}
+			t.lk.Unlock()
+		case k, ok := <-keys:
+			if !ok || !t.handleKey(k) {
+				return nil
+			}
+			t.render()
+		case <-ticker.C:
+			t.render()
+		}
+	}
+}
+
+func (t *streamTUI) callbacks(ctx context.Context, limiter *rate.Limiter) *events.RepoStreamCallbacks {
+	wait := func() {
+		if limiter != nil {
+			limiter.Wait(ctx)
+		}
+	}
+	return &events.RepoStreamCallbacks{
+		RepoCommit: func(evt *comatproto.SyncSubscribeRepos_Commit) error {
+			wait()
+			var ops []string
+			for _, op := range evt.Ops {
+				ops = append(ops, op.Action+" "+op.Path)
+			}
+			t.add(&tuiEvent{
+				did:     evt.Repo,
+				summary: fmt.Sprintf("%d commit %s %s", evt.Seq, evt.Repo, strings.Join(ops, ", ")),
+				commit:  evt,
+			})
+			return nil
+		},
+		RepoHandle: func(evt *comatproto.SyncSubscribeRepos_Handle) error {
+			wait()
+			t.add(&tuiEvent{
+				did:     evt.Did,
+				summary: fmt.Sprintf("%d handle %s -> %s", evt.Seq, evt.Did, evt.Handle),
+				raw:     evt,
+			})
+			return nil
+		},
+		RepoTombstone: func(evt *comatproto.SyncSubscribeRepos_Tombstone) error {
+			wait()
+			t.add(&tuiEvent{
+				did:     evt.Did,
+				summary: fmt.Sprintf("%d tombstone %s", evt.Seq, evt.Did),
+				raw:     evt,
+			})
+			return nil
+		},
+		RepoInfo: func(evt *comatproto.SyncSubscribeRepos_Info) error {
+			msg := ""
+			if evt.Message != nil {
+				msg = *evt.Message
+			}
+			t.add(&tuiEvent{
+				summary: fmt.Sprintf("info %s: %s", evt.Name, msg),
+				raw:     evt,
+			})
+			return nil
+		},
+		Error: func(errf *events.ErrorFrame) error {
+			return fmt.Errorf("error frame: %s: %s", errf.Error, errf.Message)
+		},
+	}
+}
+
+func (t *streamTUI) add(ev *tuiEvent) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	t.received++
+	if t.paused {
+		t.pending = append(t.pending, ev)
+		if len(t.pending) > tuiMaxEvents {
+			t.pending = t.pending[len(t.pending)-tuiMaxEvents:]
+		}
+		return
+	}
+	t.appendEvents(ev)
+}
+
+func (t *streamTUI) appendEvents(evs ...*tuiEvent) {
+	t.events = append(t.events, evs...)
+	if over := len(t.events) - tuiMaxEvents; over > 0 {
+		// copy so the dropped events can be garbage collected
+		t.events = append([]*tuiEvent(nil), t.events[over:]...)
+	}
+}
+
+func (t *streamTUI) matches(ev *tuiEvent) bool {
+	switch {
+	case ev.commit != nil:
+		if !t.filter.matchDid(ev.did) {
+			return false
+		}
+		return len(t.filter.collections) == 0 || len(t.filter.filterOps(ev.commit.Ops)) > 0
+	case ev.did == "":
+		return true
+	default:
+		return t.filter.matchNonCommit(ev.did)
+	}
+}
+
+// view returns the events matching the current filter, and the index of the
+// selected one (or -1 if there are none).
+func (t *streamTUI) view() ([]*tuiEvent, int) {
+	var out []*tuiEvent
+	idx := -1
+	for _, ev := range t.events {
+		if !t.matches(ev) {
+			continue
+		}
+		if ev == t.sel {
+			idx = len(out)
+		}
+		out = append(out, ev)
+	}
+	if len(out) == 0 {
+		return out, -1
+	}
+	switch {
+	case t.follow || t.sel == nil:
+		idx = len(out) - 1
+	case idx < 0:
+		// the selected event was filtered out or scrolled off; stay near the
+		// top rather than jumping to the newest event
+		idx = 0
+	}
+	return out, idx
+}
+
+func (t *streamTUI) move(delta int) {
+	evs, idx := t.view()
+	if idx < 0 {
+		return
+	}
+	idx += delta
+	if idx < 0 {
+		idx = 0
+	}
+	if idx > len(evs)-1 {
+		idx = len(evs) - 1
+	}
+	t.sel = evs[idx]
+	t.follow = idx == len(evs)-1 && delta > 0
+	t.detailScroll = 0
+}
+
+// handleKey processes a key press, and returns false when the user quits.
+func (t *streamTUI) handleKey(k string) bool {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	if t.editing {
+		switch k {
+		case "enter":
+			t.filterText = strings.TrimSpace(string(t.input))
+			t.filter = parseTUIFilter(t.filterText)
+			t.editing = false
+		case "esc":
+			t.editing = false
+		case "backspace":
+			if len(t.input) > 0 {
+				t.input = t.input[:len(t.input)-1]
+			}
+		case "ctrl-c":
+			return false
+		default:
+			if r := []rune(k); len(r) == 1 && unicode.IsPrint(r[0]) {
+				t.input = append(t.input, r[0])
+			}
+		}
+		return true
+	}
+
+	_, rows := t.size()
+	page := rows / 2
+	switch k {
+	case "q", "ctrl-c":
+		return false
+	case "j", "down":
+		t.move(1)
+	case "k", "up":
+		t.move(-1)
+	case "pgdn":
+		t.move(page)
+	case "pgup":
+		t.move(-page)
+	case "g":
+		t.move(-len(t.events))
+	case "G":
+		t.move(len(t.events))
+		t.follow = true
+	case " ", "p":
+		t.paused = !t.paused
+		if !t.paused {
+			t.appendEvents(t.pending...)
+			t.pending = nil
+		}
+	case "enter":
+		t.showDetail = !t.showDetail
+		t.detailScroll = 0
+	case "J":
+		t.detailScroll++
+	case "K":
+		if t.detailScroll > 0 {
+			t.detailScroll--
+		}
+	case "/":
+		t.editing = true
+		t.input = []rune(t.filterText)
+	}
+	return true
+}
+
+func (t *streamTUI) size() (int, int) {
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || rows < 5 || cols < 20 {
+		return 80, 24
+	}
+	return cols, rows
+}
+
+func (t *streamTUI) render() {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+
+	cols, rows := t.size()
+	now := time.Now()
+	if el := now.Sub(t.lastTime); el >= time.Second {
+		t.rate = float64(t.received-t.lastCount) / el.Seconds()
+		t.lastCount = t.received
+		t.lastTime = now
+	}
+
+	evs, idx := t.view()
+	if idx >= 0 {
+		t.sel = evs[idx]
+	}
+
+	listRows := rows - 2
+	detailRows := 0
+	if t.showDetail {
+		detailRows = listRows / 2
+		listRows -= detailRows
+	}
+
+	if idx < t.top {
+		t.top = idx
+	}
+	if idx >= t.top+listRows {
+		t.top = idx - listRows + 1
+	}
+	if t.top < 0 {
+		t.top = 0
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	line := func(s string, inverse bool) {
+		b.WriteString("\x1b[2K")
+		if inverse {
+			b.WriteString("\x1b[7m")
+		}
+		b.WriteString(tuiTruncate(s, cols))
+		if inverse {
+			b.WriteString("\x1b[0m")
+		}
+		b.WriteString("\r\n")
+	}
+
+	header := fmt.Sprintf(" %s | %d events | %.0f/s", t.url, t.received, t.rate)
+	if t.paused {
+		header += fmt.Sprintf(" | PAUSED (%d pending)", len(t.pending))
+	}
+	if t.filterText != "" {
+		header += " | filter: " + t.filterText
+	}
+	if t.status != "" {
+		header += " | " + t.status
+	}
+	line(header, true)
+
+	for i := 0; i < listRows; i++ {
+		n := t.top + i
+		if n < len(evs) {
+			line(evs[n].summary, n == idx)
+		} else {
+			line("", false)
+		}
+	}
+
+	if t.showDetail {
+		var detail []string
+		if t.sel != nil {
+			detail = t.sel.renderDetail()
+		}
+		if t.detailScroll > len(detail)-1 {
+			t.detailScroll = max(len(detail)-1, 0)
+		}
+		line(strings.Repeat("─", cols), false)
+		for i := 0; i < detailRows-1; i++ {
+			n := t.detailScroll + i
+			if n < len(detail) {
+				line(detail[n], false)
+			} else {
+				line("", false)
+			}
+		}
+	}
+
+	b.WriteString("\x1b[2K")
+	if t.editing {
+		b.WriteString(tuiTruncate("filter (DIDs and collections, space separated): "+string(t.input)+"_", cols))
+	} else {
+		b.WriteString(tuiTruncate(tuiHelp, cols))
+	}
+
+	os.Stdout.WriteString(b.String())
+}
+
+// renderDetail formats the full event, with commit records decoded.
+func (ev *tuiEvent) renderDetail() []string {
+	if ev.detail != nil {
+		return ev.detail
+	}
+
+	var out []string
+	if ev.commit != nil {
+		c := ev.commit
+		since := "<nil>"
+		if c.Since != nil {
+			since = *c.Since
+		}
+		out = append(out,
+			fmt.Sprintf("seq:    %d", c.Seq),
+			fmt.Sprintf("repo:   %s", c.Repo),
+			fmt.Sprintf("rev:    %s (since %s)", c.Rev, since),
+			fmt.Sprintf("commit: %s", c.Commit.String()),
+			fmt.Sprintf("time:   %s", c.Time),
+			fmt.Sprintf("blocks: %d bytes, tooBig=%v", len(c.Blocks), c.TooBig),
+		)
+
+		buf := new(bytes.Buffer)
+		if err := printCommitJSONL(buf, c, c.Ops); err != nil {
+			out = append(out, "", "failed to decode ops: "+err.Error())
+		}
+		for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if l == "" {
+				continue
+			}
+			ind := new(bytes.Buffer)
+			if err := json.Indent(ind, []byte(l), "", "  "); err != nil {
+				out = append(out, "", l)
+				continue
+			}
+			out = append(out, "")
+			out = append(out, strings.Split(ind.String(), "\n")...)
+		}
+	} else {
+		b, err := json.MarshalIndent(ev.raw, "", "  ")
+		if err != nil {
+			out = append(out, err.Error())
+		} else {
+			out = strings.Split(string(b), "\n")
+		}
+	}
+
+	ev.detail = out
+	return out
+}
+
+func parseTUIFilter(s string) *streamFilter {
+	var dids, collections []string
+	for _, tok := range strings.Fields(s) {
+		if strings.HasPrefix(tok, "did:") {
+			dids = append(dids, tok)
+		} else {
+			collections = append(collections, tok)
+		}
+	}
+	return newStreamFilter(dids, collections)
+}
+
+// tuiTruncate cuts s to fit in n columns, replacing control characters so
+// they can't mess up the display.
+func tuiTruncate(s string, n int) string {
+	out := make([]rune, 0, n)
+	for _, r := range s {
+		if len(out) >= n {
+			break
+		}
+		if r == '\t' {
+			r = ' '
+		}
+		if !unicode.IsPrint(r) && r != ' ' {
+			r = '?'
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// readTUIKeys decodes key presses from the raw terminal into names like
+// "up" or "enter", or the typed character itself.
+func readTUIKeys(keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		b := buf[:n]
+		for len(b) > 0 {
+			var k string
+			switch {
+			case bytes.HasPrefix(b, []byte("\x1b[A")):
+				k, b = "up", b[3:]
+			case bytes.HasPrefix(b, []byte("\x1b[B")):
+				k, b = "down", b[3:]
+			case bytes.HasPrefix(b, []byte("\x1b[5~")):
+				k, b = "pgup", b[4:]
+			case bytes.HasPrefix(b, []byte("\x1b[6~")):
+				k, b = "pgdn", b[4:]
+			case b[0] == 0x1b && len(b) > 1 && b[1] == '[':
+				// some other escape sequence; drop the rest of the read
+				b = nil
+				continue
+			case b[0] == 0x1b:
+				k, b = "esc", b[1:]
+			case b[0] == '\r' || b[0] == '\n':
+				k, b = "enter", b[1:]
+			case b[0] == 0x7f || b[0] == 0x08:
+				k, b = "backspace", b[1:]
+			case b[0] == 0x03:
+				k, b = "ctrl-c", b[1:]
+			default:
+				r, size := utf8.DecodeRune(b)
+				k, b = string(r), b[size:]
+			}
+			keys <- k
+		}
+	}
+}
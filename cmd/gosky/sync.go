@@ -17,6 +17,9 @@ var syncCmd = &cli.Command{
 	Name:  "sync",
 	Usage: "sub-commands for repo sync endpoints",
 	Subcommands: []*cli.Command{
+		syncAuditBlobsCmd,
+		syncCheckStreamCmd,
+		syncExportCmd,
 		syncGetRepoCmd,
 		syncGetRootCmd,
 		syncListReposCmd,
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/labeler"
@@ -141,6 +142,28 @@ func run(args []string) error {
 			Usage:   "SQRL API endpoint (full URL)",
 			EnvVars: []string{"LABELMAKER_SQRL_URL"},
 		},
+		&cli.StringFlag{
+			Name:    "hashmatch-url",
+			Usage:   "hash matching service endpoint (full URL); matches are escalated to moderation actions",
+			EnvVars: []string{"LABELMAKER_HASHMATCH_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "hashmatch-api-token",
+			Usage:   "bearer token for the hash matching service",
+			EnvVars: []string{"LABELMAKER_HASHMATCH_API_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "hashmatch-action",
+			Usage:   "what to do with hash matches: 'takedown' or 'report'",
+			EnvVars: []string{"LABELMAKER_HASHMATCH_ACTION"},
+			Value:   string(labeler.HashMatchTakedown),
+		},
+		&cli.DurationFlag{
+			Name:    "hashmatch-timeout",
+			Usage:   "timeout for each request to the hash matching service",
+			EnvVars: []string{"LABELMAKER_HASHMATCH_TIMEOUT"},
+			Value:   10 * time.Second,
+		},
 		&cli.IntFlag{
 			Name:    "max-carstore-connections",
 			EnvVars: []string{"MAX_CARSTORE_CONNECTIONS"},
@@ -263,6 +286,17 @@ func run(args []string) error {
 			srv.AddSQRLLabeler(sqrlURL)
 		}
 
+		if hashMatchURL := cctx.String("hashmatch-url"); hashMatchURL != "" {
+			hmc := labeler.DefaultHashMatchConfig()
+			hmc.Endpoint = hashMatchURL
+			hmc.ApiToken = cctx.String("hashmatch-api-token")
+			hmc.Action = labeler.HashMatchAction(cctx.String("hashmatch-action"))
+			hmc.Timeout = cctx.Duration("hashmatch-timeout")
+			if err := srv.AddHashMatcher(cctx.Context, hmc); err != nil {
+				return err
+			}
+		}
+
 		srv.SubscribeBGS(context.TODO(), bgsURL, useWss)
 		return srv.RunAPI(bind)
 	}
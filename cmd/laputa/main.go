@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/bluesky-social/indigo/api"
+	"github.com/bluesky-social/indigo/blobs"
 	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/pds"
 	"github.com/bluesky-social/indigo/plc"
 	"github.com/bluesky-social/indigo/util/cliutil"
@@ -15,6 +20,7 @@ import (
 
 	"github.com/carlmjohnson/versioninfo"
 	logging "github.com/ipfs/go-log"
+	"github.com/redis/go-redis/v9"
 	"github.com/urfave/cli/v2"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -79,6 +85,145 @@ func run(args []string) {
 			Value:   "jwtsecretplaceholder",
 			EnvVars: []string{"ATP_JWT_SECRET"},
 		},
+		&cli.StringFlag{
+			Name:    "smtp-addr",
+			Usage:   "host:port of SMTP server for account emails (password reset, email confirmation)",
+			EnvVars: []string{"PDS_SMTP_ADDR"},
+		},
+		&cli.StringFlag{
+			Name:    "smtp-username",
+			EnvVars: []string{"PDS_SMTP_USERNAME"},
+		},
+		&cli.StringFlag{
+			Name:    "smtp-password",
+			EnvVars: []string{"PDS_SMTP_PASSWORD"},
+		},
+		&cli.StringFlag{
+			Name:    "smtp-from",
+			Usage:   "sender address for account emails",
+			EnvVars: []string{"PDS_SMTP_FROM"},
+		},
+		&cli.StringFlag{
+			Name:    "s3-blob-endpoint",
+			Usage:   "S3 compatible service to store blobs in, instead of the data directory",
+			EnvVars: []string{"PDS_S3_BLOB_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Name:    "s3-blob-region",
+			Value:   "us-east-1",
+			EnvVars: []string{"PDS_S3_BLOB_REGION"},
+		},
+		&cli.StringFlag{
+			Name:    "s3-blob-bucket",
+			EnvVars: []string{"PDS_S3_BLOB_BUCKET"},
+		},
+		&cli.StringFlag{
+			Name:    "s3-blob-access-key",
+			EnvVars: []string{"PDS_S3_BLOB_ACCESS_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "s3-blob-secret-key",
+			EnvVars: []string{"PDS_S3_BLOB_SECRET_KEY"},
+		},
+		&cli.BoolFlag{
+			Name:    "s3-blob-path-style",
+			Usage:   "address the bucket as a path rather than a subdomain (needed by most non-AWS services)",
+			EnvVars: []string{"PDS_S3_BLOB_PATH_STYLE"},
+		},
+		&cli.BoolFlag{
+			Name:    "disable-ratelimits",
+			EnvVars: []string{"PDS_DISABLE_RATELIMITS"},
+		},
+		&cli.StringFlag{
+			Name:    "ratelimit-reads",
+			Usage:   "queries allowed per account (or IP, if unauthenticated) per window",
+			Value:   "3000/5m",
+			EnvVars: []string{"PDS_RATELIMIT_READS"},
+		},
+		&cli.StringFlag{
+			Name:    "ratelimit-writes",
+			Usage:   "procedures allowed per account (or IP, if unauthenticated) per window",
+			Value:   "5000/1h",
+			EnvVars: []string{"PDS_RATELIMIT_WRITES"},
+		},
+		&cli.StringFlag{
+			Name:    "ratelimit-create-session",
+			Usage:   "login attempts allowed per IP per window",
+			Value:   "30/5m",
+			EnvVars: []string{"PDS_RATELIMIT_CREATE_SESSION"},
+		},
+		&cli.StringFlag{
+			Name:    "ratelimit-redis-url",
+			Usage:   "redis to keep rate limit counters in, so they're shared between instances",
+			EnvVars: []string{"PDS_RATELIMIT_REDIS_URL"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "trusted-proxies",
+			Usage:   "IPs or CIDR ranges of reverse proxies whose X-Forwarded-For header is used for client IPs",
+			EnvVars: []string{"PDS_TRUSTED_PROXIES"},
+		},
+		&cli.StringFlag{
+			Name:  "disk-persister-dir",
+			Usage: "keep firehose events on disk in this directory so subscribers can play back from a cursor across restarts",
+		},
+		&cli.BoolFlag{
+			Name:    "firehose-compression",
+			Usage:   "offer permessage-deflate compression to firehose subscribers",
+			EnvVars: []string{"PDS_FIREHOSE_COMPRESSION"},
+		},
+		&cli.IntFlag{
+			Name:  "firehose-compression-level",
+			Usage: "flate compression level for firehose frames (1-9)",
+		},
+		&cli.IntFlag{
+			Name:  "firehose-buffer-size",
+			Usage: "number of events buffered for each firehose subscriber",
+		},
+		&cli.StringFlag{
+			Name:  "firehose-overflow",
+			Usage: "what to do when a subscriber's buffer fills: 'disconnect' or 'drop'",
+			Value: "disconnect",
+		},
+		&cli.DurationFlag{
+			Name:    "backup-interval",
+			Usage:   "how often to back up changed repos (0 to disable backups)",
+			EnvVars: []string{"PDS_BACKUP_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:    "backup-s3-bucket",
+			Usage:   "bucket to keep backups in, on the s3-blob-endpoint service; without one, backups go to data-dir/backups",
+			EnvVars: []string{"PDS_BACKUP_S3_BUCKET"},
+		},
+		&cli.StringSliceFlag{
+			Name:  "restore-account",
+			Usage: "DID of an account to restore from its latest backup before starting (\"all\" for every backed up account)",
+		},
+		&cli.StringSliceFlag{
+			Name:    "relays",
+			Usage:   "relays to request crawls from, and keep registered with (hostnames or base URLs)",
+			EnvVars: []string{"PDS_RELAYS"},
+		},
+		&cli.DurationFlag{
+			Name:    "relay-crawl-interval",
+			Usage:   "how often to request crawls again from relays which are consuming the firehose",
+			Value:   12 * time.Hour,
+			EnvVars: []string{"PDS_RELAY_CRAWL_INTERVAL"},
+		},
+		&cli.StringFlag{
+			Name:    "admin-password",
+			Usage:   "password for the admin XRPC routes (basic auth as user \"admin\"); admin routes are off without one",
+			EnvVars: []string{"PDS_ADMIN_PASSWORD"},
+		},
+		&cli.BoolFlag{
+			Name:    "invite-required",
+			Usage:   "require an invite code to create an account",
+			EnvVars: []string{"PDS_INVITE_REQUIRED"},
+		},
+		&cli.DurationFlag{
+			Name:    "invite-interval",
+			Usage:   "how often each account earns an invite code (0 for never)",
+			EnvVars: []string{"PDS_INVITE_INTERVAL"},
+		},
 		&cli.StringFlag{
 			Name:    "handle-domains",
 			Usage:   "comma-separated list of domain suffixes for handle registration",
@@ -127,6 +272,7 @@ func run(args []string) {
 		dbtracing := cctx.Bool("db-tracing")
 		datadir := cctx.String("data-dir")
 		csdir := filepath.Join(datadir, "carstore")
+		blobdir := filepath.Join(datadir, "blobs")
 		keypath := filepath.Join(datadir, "server.key")
 		jwtsecret := []byte(cctx.String("jwt-secret"))
 
@@ -182,6 +328,115 @@ func run(args []string) {
 			return err
 		}
 
+		if dpd := cctx.String("disk-persister-dir"); dpd != "" {
+			dp, err := events.NewDiskPersistence(dpd, "", db, events.DefaultDiskPersistOptions())
+			if err != nil {
+				return fmt.Errorf("setting up disk persister: %w", err)
+			}
+			srv.SetEventPersister(dp)
+		}
+
+		fhcfg := pds.FirehoseConfig{
+			Compression:      cctx.Bool("firehose-compression"),
+			CompressionLevel: cctx.Int("firehose-compression-level"),
+			BufferSize:       cctx.Int("firehose-buffer-size"),
+		}
+		switch cctx.String("firehose-overflow") {
+		case "disconnect":
+			fhcfg.Overflow = events.OverflowDisconnect
+		case "drop":
+			fhcfg.Overflow = events.OverflowDrop
+		default:
+			return fmt.Errorf("unknown firehose overflow policy %q", cctx.String("firehose-overflow"))
+		}
+		srv.SetFirehoseConfig(fhcfg)
+
+		if addr := cctx.String("smtp-addr"); addr != "" {
+			srv.SetMailer(pds.NewSMTPMailer(addr, cctx.String("smtp-username"), cctx.String("smtp-password"), cctx.String("smtp-from")))
+		}
+
+		if endpoint := cctx.String("s3-blob-endpoint"); endpoint != "" {
+			s3 := blobs.NewS3BlobStore(endpoint, cctx.String("s3-blob-region"), cctx.String("s3-blob-bucket"), cctx.String("s3-blob-access-key"), cctx.String("s3-blob-secret-key"))
+			s3.PathStyle = cctx.Bool("s3-blob-path-style")
+			srv.SetBlobStore(s3)
+		} else {
+			os.MkdirAll(blobdir, os.ModePerm)
+			srv.SetBlobStore(&blobs.DiskBlobStore{Dir: blobdir})
+		}
+
+		if err := srv.SetTrustedProxies(cctx.StringSlice("trusted-proxies")); err != nil {
+			return err
+		}
+
+		if !cctx.Bool("disable-ratelimits") {
+			var cfg pds.RateLimitConfig
+			if cfg.Reads, err = pds.ParseRateLimit(cctx.String("ratelimit-reads")); err != nil {
+				return err
+			}
+			if cfg.Writes, err = pds.ParseRateLimit(cctx.String("ratelimit-writes")); err != nil {
+				return err
+			}
+			if cfg.CreateSession, err = pds.ParseRateLimit(cctx.String("ratelimit-create-session")); err != nil {
+				return err
+			}
+
+			var store pds.RateLimitStore
+			if rurl := cctx.String("ratelimit-redis-url"); rurl != "" {
+				opts, err := redis.ParseURL(rurl)
+				if err != nil {
+					return fmt.Errorf("parsing redis url: %w", err)
+				}
+				store = pds.NewRedisRateLimitStore(redis.NewClient(opts), "pds:ratelimit:")
+			}
+			srv.SetRateLimits(cfg, store)
+		}
+
+		if pw := cctx.String("admin-password"); pw != "" {
+			srv.SetAdminPassword(pw)
+		}
+		srv.SetInviteConfig(pds.InviteConfig{
+			Required: cctx.Bool("invite-required"),
+			Interval: cctx.Duration("invite-interval"),
+		})
+
+		if bucket := cctx.String("backup-s3-bucket"); bucket != "" {
+			s3 := blobs.NewS3BlobStore(cctx.String("s3-blob-endpoint"), cctx.String("s3-blob-region"), bucket, cctx.String("s3-blob-access-key"), cctx.String("s3-blob-secret-key"))
+			s3.PathStyle = cctx.Bool("s3-blob-path-style")
+			srv.SetBackupStore(s3)
+		} else {
+			backupdir := filepath.Join(datadir, "backups")
+			os.MkdirAll(backupdir, os.ModePerm)
+			srv.SetBackupStore(&blobs.DiskBlobStore{Dir: backupdir})
+		}
+
+		if restore := cctx.StringSlice("restore-account"); len(restore) > 0 {
+			if len(restore) == 1 && restore[0] == "all" {
+				restore, err = srv.BackupAccountDids(context.Background())
+				if err != nil {
+					return err
+				}
+			}
+			for _, did := range restore {
+				if err := srv.RestoreAccount(context.Background(), did); err != nil {
+					return fmt.Errorf("restoring %s: %w", did, err)
+				}
+			}
+		}
+
+		if interval := cctx.Duration("backup-interval"); interval > 0 {
+			go srv.RunBackups(context.Background(), interval)
+		}
+
+		if relays := cctx.StringSlice("relays"); len(relays) > 0 {
+			if err := srv.SetRelays(pds.RelayConfig{
+				Hosts:    relays,
+				Interval: cctx.Duration("relay-crawl-interval"),
+			}); err != nil {
+				return err
+			}
+			go srv.RunRelayAnnouncer(context.Background())
+		}
+
 		return srv.RunAPI(":4989")
 	}
 
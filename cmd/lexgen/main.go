@@ -2,61 +2,12 @@ package main
 
 import (
 	"fmt"
-	"io/fs"
-	"os"
-	"path/filepath"
 	"strings"
 
 	lex "github.com/bluesky-social/indigo/lex"
 	cli "github.com/urfave/cli/v2"
 )
 
-func findSchemas(dir string) ([]string, error) {
-	var out []string
-	err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if info.IsDir() {
-			return nil
-		}
-
-		if strings.HasSuffix(path, ".json") {
-			out = append(out, path)
-		}
-
-		return nil
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	return out, nil
-
-}
-
-func expandArgs(args []string) ([]string, error) {
-	var out []string
-	for _, a := range args {
-		st, err := os.Stat(a)
-		if err != nil {
-			return nil, err
-		}
-		if st.IsDir() {
-			s, err := findSchemas(a)
-			if err != nil {
-				return nil, err
-			}
-			out = append(out, s...)
-		} else if strings.HasSuffix(a, ".json") {
-			out = append(out, a)
-		}
-	}
-
-	return out, nil
-}
-
 func main() {
 	app := cli.NewApp()
 
@@ -76,6 +27,10 @@ func main() {
 		&cli.StringSliceFlag{
 			Name: "types-import",
 		},
+		&cli.StringSliceFlag{
+			Name:  "import",
+			Usage: "map an NSID prefix to the Go import path of its generated types, as 'prefix:path' (in addition to the indigo defaults)",
+		},
 		&cli.StringFlag{
 			Name:  "package",
 			Value: "schemagen",
@@ -89,26 +44,20 @@ func main() {
 
 		prefix := cctx.String("prefix")
 
-		paths, err := expandArgs(cctx.Args().Slice())
+		schemas, err := lex.ReadSchemas(cctx.Args().Slice())
 		if err != nil {
 			return err
 		}
 
-		var schemas []*lex.Schema
-		for _, arg := range paths {
-			s, err := lex.ReadSchema(arg)
-			if err != nil {
-				return fmt.Errorf("failed to read file %q: %w", arg, err)
-			}
-
-			schemas = append(schemas, s)
-		}
-
 		pkgname := cctx.String("package")
 
-		imports := map[string]string{
-			"app.bsky":    "github.com/bluesky-social/indigo/api/bsky",
-			"com.atproto": "github.com/bluesky-social/indigo/api/atproto",
+		imports := lex.DefaultImports()
+		for _, p := range cctx.StringSlice("import") {
+			parts := strings.SplitN(p, ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid import mapping (expected 'prefix:path'): %q", p)
+			}
+			imports[parts[0]] = parts[1]
 		}
 
 		if cctx.Bool("gen-server") {
@@ -129,22 +78,14 @@ func main() {
 			}
 
 		} else {
-			defmap := lex.BuildExtDefMap(schemas, []string{"com.atproto", "app.bsky"})
-
-			// Run this twice as a hack to deal with indirect references referencing indirect references.
-			// This part of the codegen needs to be redone
-			lex.FixRecordReferences(schemas, defmap, prefix)
-			lex.FixRecordReferences(schemas, defmap, prefix)
-			for i, s := range schemas {
-				if !strings.HasPrefix(s.ID, prefix) {
-					continue
-				}
-
-				fname := filepath.Join(outdir, s.Name()+".go")
-
-				if err := lex.GenCodeForSchema(pkgname, prefix, fname, true, s, defmap, imports); err != nil {
-					return fmt.Errorf("failed to process schema %q: %w", paths[i], err)
-				}
+			cfg := lex.GenConfig{
+				Package: pkgname,
+				OutDir:  outdir,
+				Prefix:  prefix,
+				Imports: imports,
+			}
+			if err := lex.GenerateTypes(schemas, cfg); err != nil {
+				return err
 			}
 		}
 
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// suffix for CARs which are still being downloaded; they're renamed into
+// place once complete, so an interrupted run never leaves a truncated repo
+// that looks finished
+const partialSuffix = ".partial"
+
+var reposUnchanged = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "netsync_repos_unchanged_total",
+	Help: "Number of repos skipped because their latest commit was already downloaded",
+})
+
+var dedupBytesSaved = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "netsync_dedup_bytes_saved_total",
+	Help: "Number of bytes not stored because an identical CAR was already on disk",
+})
+
+var hostSlotWait = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name: "netsync_host_slot_wait_seconds",
+	Help: "Time spent waiting for a per-host concurrency slot",
+})
+
+// hostLimiter bounds the number of concurrent downloads from each host
+type hostLimiter struct {
+	limit int
+
+	lk    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: limit, slots: make(map[string]chan struct{})}
+}
+
+// Acquire waits for a slot for host, and returns a function to release it
+func (hl *hostLimiter) Acquire(ctx context.Context, host string) (func(), error) {
+	if hl.limit <= 0 {
+		return func() {}, nil
+	}
+	hl.lk.Lock()
+	slot, ok := hl.slots[host]
+	if !ok {
+		slot = make(chan struct{}, hl.limit)
+		hl.slots[host] = slot
+	}
+	hl.lk.Unlock()
+
+	start := time.Now()
+	select {
+	case slot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	hostSlotWait.Observe(time.Since(start).Seconds())
+	return func() { <-slot }, nil
+}
+
+// repoSource is where to download one repo from
+type repoSource struct {
+	// Host is scheme and host, eg https://bgs.bsky.social
+	Host string
+	// GetRepo is the full com.atproto.sync.getRepo endpoint
+	GetRepo string
+	// magic headers are only sent to the configured checkout host
+	Checkout bool
+}
+
+// source picks where to download a repo from: its PDS, if resolving PDSes,
+// and otherwise the checkout endpoint
+func (s *NetsyncState) source(ctx context.Context, repo string) (*repoSource, error) {
+	if s.dir == nil {
+		u, err := url.Parse(s.CheckoutPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checkout path: %w", err)
+		}
+		return &repoSource{Host: u.Scheme + "://" + u.Host, GetRepo: s.CheckoutPath, Checkout: true}, nil
+	}
+
+	did, err := syntax.ParseDID(repo)
+	if err != nil {
+		return nil, err
+	}
+	ident, err := s.dir.LookupDID(ctx, did)
+	if err != nil {
+		return nil, fmt.Errorf("resolving PDS: %w", err)
+	}
+	pds := ident.PDSEndpoint()
+	if pds == "" {
+		return nil, fmt.Errorf("no PDS endpoint in DID document")
+	}
+	u, err := url.Parse(pds)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid PDS endpoint: %q", pds)
+	}
+	host := u.Scheme + "://" + u.Host
+	return &repoSource{Host: host, GetRepo: host + "/xrpc/com.atproto.sync.getRepo"}, nil
+}
+
+type latestCommit struct {
+	Cid string `json:"cid"`
+	Rev string `json:"rev"`
+}
+
+// latestCommit asks the source for the repo's current commit, which is
+// cheap compared to downloading the whole repo again. Returns nil if the
+// source can't say.
+func (s *NetsyncState) latestCommit(ctx context.Context, src *repoSource, repo string) *latestCommit {
+	if !strings.HasSuffix(src.GetRepo, "com.atproto.sync.getRepo") {
+		return nil
+	}
+	endpoint := strings.TrimSuffix(src.GetRepo, "com.atproto.sync.getRepo") + "com.atproto.sync.getLatestCommit"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint+"?did="+url.QueryEscape(repo), nil)
+	if err != nil {
+		return nil
+	}
+	s.setHeaders(req, src)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var lc latestCommit
+	if err := json.NewDecoder(resp.Body).Decode(&lc); err != nil || lc.Cid == "" {
+		return nil
+	}
+	return &lc
+}
+
+func (s *NetsyncState) setHeaders(req *http.Request, src *repoSource) {
+	req.Header.Set("User-Agent", "jaz-atproto-netsync/0.0.1")
+	if src.Checkout && s.magicHeaderKey != "" && s.magicHeaderVal != "" {
+		req.Header.Set(s.magicHeaderKey, s.magicHeaderVal)
+	}
+}
+
+// dedup replaces the repo's freshly downloaded CAR with a hard link to an
+// identical one already on disk, if there is one, and otherwise indexes it.
+func (s *NetsyncState) dedup(repoState *RepoState) {
+	s.lk.Lock()
+	other, ok := s.hashes[repoState.SHA256]
+	if !ok {
+		s.hashes[repoState.SHA256] = repoState.Repo
+	}
+	s.lk.Unlock()
+	if !ok || other == repoState.Repo {
+		return
+	}
+
+	outPath := filepath.Join(s.outDir, repoState.Repo)
+	otherPath := filepath.Join(s.outDir, other)
+	if fi, err := os.Stat(otherPath); err != nil || fi.Size() != repoState.Size {
+		return
+	}
+
+	// link alongside, then rename over, so the repo's file is never missing
+	tmp := outPath + partialSuffix
+	if err := os.Link(otherPath, tmp); err != nil {
+		log.Warnw("failed to dedup repo", "repo", repoState.Repo, "err", err)
+		return
+	}
+	if err := os.Rename(tmp, outPath); err != nil {
+		os.Remove(tmp)
+		log.Warnw("failed to dedup repo", "repo", repoState.Repo, "err", err)
+		return
+	}
+	dedupBytesSaved.Add(float64(repoState.Size))
+}
+
+// ManifestEntry is one line of the output manifest, describing a
+// successfully downloaded repo
+type ManifestEntry struct {
+	Did        string    `json:"did"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	Commit     string    `json:"commit,omitempty"`
+	Rev        string    `json:"rev,omitempty"`
+	Host       string    `json:"host,omitempty"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// WriteManifest writes a JSON line for every successfully downloaded repo,
+// sorted by DID
+func (s *NetsyncState) WriteManifest(path string) error {
+	s.lk.RLock()
+	var entries []ManifestEntry
+	for _, rs := range s.FinishedRepos {
+		if rs.State != "success" && rs.State != "unchanged" {
+			continue
+		}
+		entries = append(entries, ManifestEntry{
+			Did:        rs.Repo,
+			Path:       filepath.Join(s.outDir, rs.Repo),
+			Size:       rs.Size,
+			SHA256:     rs.SHA256,
+			Commit:     rs.Commit,
+			Rev:        rs.Rev,
+			Host:       rs.Host,
+			FinishedAt: rs.FinishedAt,
+		})
+	}
+	s.lk.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Did < entries[j].Did })
+
+	return writeFileAtomic(path, func(f *os.File) error {
+		w := bufio.NewWriter(f)
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	})
+}
+
+// writeFileAtomic writes to a temporary file and renames it into place, so
+// readers (and resumed runs) never see a partially written file
+func writeFileAtomic(path string, write func(f *os.File) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -3,17 +3,22 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/identity"
+
 	logging "github.com/ipfs/go-log"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/prometheus/client_golang/prometheus"
@@ -83,6 +88,20 @@ func main() {
 			Value:   "",
 			EnvVars: []string{"MAGIC_HEADER_VAL"},
 		},
+		&cli.IntFlag{
+			Name:  "per-host-concurrency",
+			Usage: "maximum number of concurrent downloads from any one host (0 for no limit)",
+			Value: 4,
+		},
+		&cli.BoolFlag{
+			Name:  "resolve-pds",
+			Usage: "download repos directly from their PDS instead of the checkout endpoint",
+		},
+		&cli.StringFlag{
+			Name:  "manifest",
+			Usage: "path to write a JSON lines manifest of downloaded repos to",
+			Value: "manifest.jsonl",
+		},
 	}
 
 	app.Commands = []*cli.Command{
@@ -114,6 +133,50 @@ func main() {
 				return state.Save()
 			},
 		},
+		{
+			Name:  "refresh",
+			Usage: "requeue downloaded repos, to fetch any which have changed",
+			Action: func(cctx *cli.Context) error {
+				state := &NetsyncState{
+					StatePath: cctx.String("state-file"),
+				}
+
+				err := state.Resume()
+				if err != nil {
+					return err
+				}
+
+				// Unchanged repos are skipped cheaply on the next run, by
+				// comparing their latest commit
+				for _, repoState := range state.FinishedRepos {
+					if repoState.State == "success" || repoState.State == "unchanged" {
+						state.EnqueuedRepos[repoState.Repo] = &RepoState{
+							Repo:  repoState.Repo,
+							State: "enqueued",
+						}
+					}
+				}
+
+				return state.Save()
+			},
+		},
+		{
+			Name:  "manifest",
+			Usage: "write a manifest of downloaded repos from the state file",
+			Action: func(cctx *cli.Context) error {
+				state := &NetsyncState{
+					StatePath: cctx.String("state-file"),
+					outDir:    cctx.String("out-dir"),
+				}
+
+				err := state.Resume()
+				if err != nil {
+					return err
+				}
+
+				return state.WriteManifest(cctx.String("manifest"))
+			},
+		},
 		{
 			Name:   "playback",
 			Usage:  "playback the contents of a netsync output directory",
@@ -164,6 +227,14 @@ type RepoState struct {
 	Repo       string
 	State      string
 	FinishedAt time.Time
+
+	// Set once a repo has been downloaded, so later runs can skip it if it
+	// hasn't changed
+	Host   string `json:",omitempty"`
+	Commit string `json:",omitempty"`
+	Rev    string `json:",omitempty"`
+	Size   int64  `json:",omitempty"`
+	SHA256 string `json:",omitempty"`
 }
 
 type NetsyncState struct {
@@ -182,6 +253,10 @@ type NetsyncState struct {
 	limiter     *rate.Limiter
 	workerCount int
 	client      *http.Client
+	hosts       *hostLimiter
+	dir         identity.Directory
+	// CAR hashes to the first repo downloaded with that content
+	hashes map[string]string
 }
 
 type instrumentedReader struct {
@@ -214,19 +289,15 @@ func (s *NetsyncState) Save() error {
 	s.lk.RLock()
 	defer s.lk.RUnlock()
 
-	stateFile, err := os.OpenFile(s.StatePath, os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer stateFile.Close()
-
 	stateBytes, err := json.Marshal(s)
 	if err != nil {
 		return err
 	}
 
-	_, err = stateFile.Write(stateBytes)
-	return err
+	return writeFileAtomic(s.StatePath, func(f *os.File) error {
+		_, err := f.Write(stateBytes)
+		return err
+	})
 }
 
 func (s *NetsyncState) Resume() error {
@@ -274,19 +345,16 @@ var finishedJobs = promauto.NewGauge(prometheus.GaugeOpts{
 	Help: "Number of finished jobs",
 })
 
-func (s *NetsyncState) Finish(repo string, state string) {
+func (s *NetsyncState) Finish(repoState *RepoState) {
 	s.lk.Lock()
 	defer s.lk.Unlock()
 
-	s.FinishedRepos[repo] = &RepoState{
-		Repo:       repo,
-		State:      state,
-		FinishedAt: time.Now(),
-	}
+	repoState.FinishedAt = time.Now()
+	s.FinishedRepos[repoState.Repo] = repoState
 
 	finishedJobs.Set(float64(len(s.FinishedRepos)))
 
-	delete(s.EnqueuedRepos, repo)
+	delete(s.EnqueuedRepos, repoState.Repo)
 }
 
 func Netsync(cctx *cli.Context) error {
@@ -309,12 +377,18 @@ func Netsync(cctx *cli.Context) error {
 		client: &http.Client{
 			Timeout: 180 * time.Second,
 		},
+		hosts:  newHostLimiter(cctx.Int("per-host-concurrency")),
+		hashes: make(map[string]string),
 	}
 
 	if state.magicHeaderKey != "" && state.magicHeaderVal != "" {
 		log.Info("using magic header")
 	}
 
+	if cctx.Bool("resolve-pds") {
+		state.dir = identity.DefaultDirectory()
+	}
+
 	// Create out dir
 	err := os.MkdirAll(state.outDir, 0755)
 	if err != nil {
@@ -338,6 +412,12 @@ func Netsync(cctx *cli.Context) error {
 		state.FinishedRepos = make(map[string]*RepoState)
 	}
 
+	for _, repoState := range state.FinishedRepos {
+		if repoState.SHA256 != "" {
+			state.hashes[repoState.SHA256] = repoState.Repo
+		}
+	}
+
 	if err != nil {
 		// Read repo list
 		repoListFile, err := os.Open(cctx.String("repo-list"))
@@ -408,12 +488,13 @@ func Netsync(cctx *cli.Context) error {
 					log.Errorw("failed to save state", "err", err)
 				}
 				state.lk.RLock()
-				if len(state.EnqueuedRepos) == 0 {
+				remaining := len(state.EnqueuedRepos)
+				state.lk.RUnlock()
+				if remaining == 0 {
 					log.Info("no more repos to clone, shutting down")
 					close(state.exit)
 					return
 				}
-				state.lk.RUnlock()
 			}
 		}
 	}()
@@ -445,6 +526,13 @@ func Netsync(cctx *cli.Context) error {
 
 	state.wg.Wait()
 
+	if err := state.Save(); err != nil {
+		log.Errorw("failed to save state", "err", err)
+	}
+	if err := state.WriteManifest(cctx.String("manifest")); err != nil {
+		log.Errorw("failed to write manifest", "err", err)
+	}
+
 	log.Info("shut down successfully")
 
 	return nil
@@ -473,14 +561,14 @@ func (s *NetsyncState) worker(id int) error {
 			s.limiter.Wait(ctx)
 
 			// Clone repo
-			cloneState, err := s.cloneRepo(ctx, repo)
+			repoState, err := s.cloneRepo(ctx, repo)
 			if err != nil {
 				log.Errorw("failed to clone repo", "repo", repo, "err", err)
 			}
 
 			// Update state
-			s.Finish(repo, cloneState)
-			log.Infow("worker finished", "repo", repo, "status", cloneState)
+			s.Finish(repoState)
+			log.Infow("worker finished", "repo", repo, "status", repoState.State)
 		}
 	}
 }
@@ -495,40 +583,76 @@ var bytesProcessed = promauto.NewCounter(prometheus.CounterOpts{
 	Help: "Number of bytes processed",
 })
 
-func (s *NetsyncState) cloneRepo(ctx context.Context, repo string) (cloneState string, err error) {
+func (s *NetsyncState) cloneRepo(ctx context.Context, repo string) (repoState *RepoState, err error) {
 	log := log.With("repo", repo, "source", "cloneRepo")
 	log.Infow("cloning repo")
 
+	repoState = &RepoState{Repo: repo}
 	start := time.Now()
 	defer func() {
 		duration := time.Since(start)
-		repoCloneDuration.WithLabelValues(cloneState).Observe(duration.Seconds())
+		repoCloneDuration.WithLabelValues(repoState.State).Observe(duration.Seconds())
 	}()
 
-	var url = fmt.Sprintf("%s?did=%s", s.CheckoutPath, repo)
+	s.lk.RLock()
+	prev := s.FinishedRepos[repo]
+	s.lk.RUnlock()
+
+	src, err := s.source(ctx, repo)
+	if err != nil {
+		repoState.State = "failed (resolve)"
+		return repoState, err
+	}
+	repoState.Host = src.Host
+
+	release, err := s.hosts.Acquire(ctx, src.Host)
+	if err != nil {
+		repoState.State = "failed (host-limit)"
+		return repoState, err
+	}
+	defer release()
+
+	outPath := filepath.Join(s.outDir, repo)
+
+	// Skip repos which haven't changed since they were last downloaded
+	latest := s.latestCommit(ctx, src, repo)
+	if latest != nil {
+		repoState.Commit = latest.Cid
+		repoState.Rev = latest.Rev
+		if prev != nil && prev.Commit == latest.Cid && prev.SHA256 != "" {
+			if fi, err := os.Stat(outPath); err == nil && fi.Size() == prev.Size {
+				reposUnchanged.Inc()
+				unchanged := *prev
+				unchanged.State = "unchanged"
+				unchanged.Host = src.Host
+				unchanged.Rev = latest.Rev
+				return &unchanged, nil
+			}
+		}
+	}
+
+	var url = fmt.Sprintf("%s?did=%s", src.GetRepo, repo)
 
 	// Clone repo
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		cloneState = "failed (request-creation)"
-		return cloneState, fmt.Errorf("failed to create request: %w", err)
+		repoState.State = "failed (request-creation)"
+		return repoState, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Accept", "application/vnd.ipld.car")
-	req.Header.Set("User-Agent", "jaz-atproto-netsync/0.0.1")
-	if s.magicHeaderKey != "" && s.magicHeaderVal != "" {
-		req.Header.Set(s.magicHeaderKey, s.magicHeaderVal)
-	}
+	s.setHeaders(req, src)
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		cloneState = "failed (client.do)"
-		return cloneState, fmt.Errorf("failed to get repo: %w", err)
+		repoState.State = "failed (client.do)"
+		return repoState, fmt.Errorf("failed to get repo: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		cloneState = fmt.Sprintf("failed (status: %d)", resp.StatusCode)
-		return cloneState, fmt.Errorf("failed to get repo: %s", resp.Status)
+		resp.Body.Close()
+		repoState.State = fmt.Sprintf("failed (status: %d)", resp.StatusCode)
+		return repoState, fmt.Errorf("failed to get repo: %s", resp.Status)
 	}
 
 	instrumentedReader := instrumentedReader{
@@ -537,25 +661,38 @@ func (s *NetsyncState) cloneRepo(ctx context.Context, repo string) (cloneState s
 	}
 	defer instrumentedReader.Close()
 
-	// Write to file
-	outPath := fmt.Sprintf("%s/%s", s.outDir, repo)
-	outFile, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY, 0644)
+	// Write to a partial file, and only move it into place once complete
+	partialPath := outPath + partialSuffix
+	outFile, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
-		cloneState = "failed (file.open)"
-		return cloneState, fmt.Errorf("failed to open file: %w", err)
+		repoState.State = "failed (file.open)"
+		return repoState, fmt.Errorf("failed to open file: %w", err)
 	}
+	defer os.Remove(partialPath)
 
-	_, err = io.Copy(outFile, instrumentedReader)
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(outFile, hasher), instrumentedReader)
 	if err != nil {
-		cloneState = "failed (file.copy)"
-		return cloneState, fmt.Errorf("failed to copy file: %w", err)
+		outFile.Close()
+		repoState.State = "failed (file.copy)"
+		return repoState, fmt.Errorf("failed to copy file: %w", err)
 	}
 
 	err = outFile.Close()
 	if err != nil {
-		cloneState = "failed (file.close)"
-		return cloneState, fmt.Errorf("failed to close file: %w", err)
+		repoState.State = "failed (file.close)"
+		return repoState, fmt.Errorf("failed to close file: %w", err)
 	}
-	cloneState = "success"
-	return cloneState, nil
+
+	if err := os.Rename(partialPath, outPath); err != nil {
+		repoState.State = "failed (file.rename)"
+		return repoState, fmt.Errorf("failed to move file into place: %w", err)
+	}
+
+	repoState.Size = n
+	repoState.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	s.dedup(repoState)
+
+	repoState.State = "success"
+	return repoState, nil
 }
@@ -379,7 +379,7 @@ func Playback(cctx *cli.Context) error {
 			return fmt.Errorf("failed to walk path: %w", err)
 		}
 
-		if d.IsDir() {
+		if d.IsDir() || strings.HasSuffix(d.Name(), partialSuffix) {
 			return nil
 		}
 
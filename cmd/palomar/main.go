@@ -24,6 +24,7 @@ import (
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/search"
 	"github.com/bluesky-social/indigo/util/cliutil"
+	"github.com/bluesky-social/indigo/util/ratelimit"
 
 	"github.com/carlmjohnson/versioninfo"
 	es "github.com/opensearch-project/opensearch-go/v2"
@@ -80,6 +81,11 @@ func run(args []string) error {
 			Value:   "palomar_post",
 			EnvVars: []string{"ES_POST_INDEX"},
 		},
+		&cli.StringFlag{
+			Name:    "es-post-partition",
+			Usage:   "partition 'post' documents into separate indices behind the post index alias: 'month', 'day', or 'lang' (default: single index)",
+			EnvVars: []string{"ES_POST_PARTITION"},
+		},
 		&cli.StringFlag{
 			Name:    "es-profile-index",
 			Usage:   "ES index for 'profile' documents",
@@ -146,6 +152,11 @@ var runCmd = &cli.Command{
 			Value:   8,
 			EnvVars: []string{"PALOMAR_BGS_SYNC_RATE_LIMIT"},
 		},
+		&cli.BoolFlag{
+			Name:    "bgs-adaptive-rate-limit",
+			Usage:   "adjust repo sync concurrency and pacing from upstream rate limit responses, starting from bgs-sync-rate-limit",
+			EnvVars: []string{"PALOMAR_BGS_ADAPTIVE_RATE_LIMIT"},
+		},
 		&cli.IntFlag{
 			Name:    "index-max-concurrency",
 			Usage:   "max number of concurrent index requests (HTTP POST) to search index",
@@ -158,6 +169,16 @@ var runCmd = &cli.Command{
 			Value:   100,
 			EnvVars: []string{"PALOMAR_PLC_RATE_LIMIT"},
 		},
+		&cli.StringFlag{
+			Name:    "identity-snapshot",
+			Usage:   "file to load the identity cache from at startup, and periodically save it to",
+			EnvVars: []string{"PALOMAR_IDENTITY_SNAPSHOT"},
+		},
+		&cli.StringFlag{
+			Name:    "ranking-experiment",
+			Usage:   "JSON file defining a search ranking experiment (variants are assigned by session)",
+			EnvVars: []string{"PALOMAR_RANKING_EXPERIMENT"},
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
@@ -214,25 +235,57 @@ var runCmd = &cli.Command{
 		base := identity.BaseDirectory{
 			PLCURL: cctx.String("atp-plc-host"),
 			HTTPClient: http.Client{
-				Timeout: time.Second * 15,
+				Timeout:   time.Second * 15,
+				Transport: ratelimit.Transport(nil),
 			},
 			PLCLimiter:            rate.NewLimiter(rate.Limit(cctx.Int("plc-rate-limit")), 1),
 			TryAuthoritativeDNS:   true,
 			SkipDNSDomainSuffixes: []string{".bsky.social"},
 		}
 		dir := identity.NewCacheDirectory(&base, 1_500_000, time.Hour*24, time.Minute*2)
+		if path := cctx.String("identity-snapshot"); path != "" {
+			n, err := dir.LoadSnapshotFile(path, 0)
+			if err != nil {
+				// a cold cache is slower, but not fatal
+				slog.Warn("failed to load identity snapshot", "path", path, "err", err)
+			} else {
+				slog.Info("loaded identity snapshot", "path", path, "identities", n)
+			}
+			go func() {
+				for range time.Tick(15 * time.Minute) {
+					n, err := dir.SaveSnapshotFile(path)
+					if err != nil {
+						slog.Warn("failed to save identity snapshot", "path", path, "err", err)
+						continue
+					}
+					slog.Info("saved identity snapshot", "path", path, "identities", n)
+				}
+			}()
+		}
+
+		var experiment *search.Experiment
+		if path := cctx.String("ranking-experiment"); path != "" {
+			experiment, err = search.LoadExperiment(path)
+			if err != nil {
+				return fmt.Errorf("loading ranking experiment: %w", err)
+			}
+			slog.Info("running ranking experiment", "experiment", experiment.Name, "variants", len(experiment.Variants))
+		}
 
 		srv, err := search.NewServer(
 			db,
 			escli,
 			&dir,
 			search.Config{
-				BGSHost:             cctx.String("atp-bgs-host"),
-				ProfileIndex:        cctx.String("es-profile-index"),
-				PostIndex:           cctx.String("es-post-index"),
-				Logger:              logger,
-				BGSSyncRateLimit:    cctx.Int("bgs-sync-rate-limit"),
-				IndexMaxConcurrency: cctx.Int("index-max-concurrency"),
+				BGSHost:              cctx.String("atp-bgs-host"),
+				ProfileIndex:         cctx.String("es-profile-index"),
+				PostIndex:            cctx.String("es-post-index"),
+				PostPartition:        cctx.String("es-post-partition"),
+				Logger:               logger,
+				BGSSyncRateLimit:     cctx.Int("bgs-sync-rate-limit"),
+				BGSAdaptiveRateLimit: cctx.Bool("bgs-adaptive-rate-limit"),
+				IndexMaxConcurrency:  cctx.Int("index-max-concurrency"),
+				Experiment:           experiment,
 			},
 		)
 		if err != nil {
@@ -322,7 +375,7 @@ var searchPostCmd = &cli.Command{
 			context.Background(),
 			identity.DefaultDirectory(), // TODO: parse PLC arg
 			escli,
-			cctx.String("es-post-index"),
+			search.PostPartitions{Alias: cctx.String("es-post-index"), By: cctx.String("es-post-partition")},
 			strings.Join(cctx.Args().Slice(), " "),
 			0,
 			20,
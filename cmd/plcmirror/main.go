@@ -0,0 +1,119 @@
+// PLC directory mirror: tails an upstream directory's export into a local
+// database, verifying every operation, and serves the resolution and audit
+// log endpoints from there.
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/bluesky-social/indigo/plc/mirror"
+	"github.com/bluesky-social/indigo/util/cliutil"
+
+	_ "github.com/joho/godotenv/autoload"
+	_ "go.uber.org/automaxprocs"
+
+	"github.com/carlmjohnson/versioninfo"
+	logging "github.com/ipfs/go-log"
+	"github.com/urfave/cli/v2"
+)
+
+var log = logging.Logger("plcmirror")
+
+func main() {
+	if err := run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string) error {
+
+	app := cli.App{
+		Name:    "plcmirror",
+		Usage:   "verifying mirror of a PLC directory",
+		Version: versioninfo.Short(),
+	}
+
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:    "upstream",
+			Usage:   "method, hostname, and port of the PLC directory to mirror",
+			Value:   "https://plc.directory",
+			EnvVars: []string{"PLC_MIRROR_UPSTREAM", "ATP_PLC_HOST"},
+		},
+		&cli.StringFlag{
+			Name:    "database-url",
+			Value:   "sqlite://data/plcmirror/plc.db",
+			EnvVars: []string{"DATABASE_URL"},
+		},
+		&cli.IntFlag{
+			Name:    "max-db-connections",
+			Value:   20,
+			EnvVars: []string{"MAX_DB_CONNECTIONS"},
+		},
+		&cli.StringFlag{
+			Name:    "bind",
+			Usage:   "IP or address, and port, to listen on for HTTP APIs",
+			Value:   ":2582",
+			EnvVars: []string{"PLC_MIRROR_BIND"},
+		},
+		&cli.DurationFlag{
+			Name:    "poll-interval",
+			Usage:   "how long to wait before polling the upstream again, once caught up",
+			Value:   5 * time.Second,
+			EnvVars: []string{"PLC_MIRROR_POLL_INTERVAL"},
+		},
+		&cli.BoolFlag{
+			Name:    "no-sync",
+			Usage:   "only serve what is already in the database",
+			EnvVars: []string{"PLC_MIRROR_NO_SYNC"},
+		},
+	}
+
+	app.Action = runMirror
+
+	return app.Run(args)
+}
+
+func runMirror(cctx *cli.Context) error {
+	db, err := cliutil.SetupDatabase(cctx.String("database-url"), cctx.Int("max-db-connections"))
+	if err != nil {
+		return err
+	}
+
+	m, err := mirror.NewMirror(db, cctx.String("upstream"))
+	if err != nil {
+		return err
+	}
+	m.Consumer.PollInterval = cctx.Duration("poll-interval")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if !cctx.Bool("no-sync") {
+		go func() {
+			if err := m.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Errorw("mirror sync stopped", "err", err)
+			}
+		}()
+	}
+
+	srv := mirror.NewServer(m)
+	go func() {
+		<-ctx.Done()
+		sctx, scancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer scancel()
+		srv.Shutdown(sctx)
+	}()
+
+	log.Infow("serving PLC mirror", "bind", cctx.String("bind"), "upstream", cctx.String("upstream"))
+	if err := srv.Start(cctx.String("bind")); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -12,9 +12,11 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/events/schedulers/autoscaling"
 	"github.com/bluesky-social/indigo/sonar"
+	"github.com/bluesky-social/indigo/util/ratelimit"
 	"github.com/gorilla/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	_ "go.uber.org/automaxprocs"
@@ -62,6 +64,29 @@ func main() {
 			Usage: "path to cursor file",
 			Value: "sonar_cursor.json",
 		},
+		&cli.DurationFlag{
+			Name:  "rate-window",
+			Usage: "window over which per-collection and per-PDS rates are measured",
+			Value: time.Minute,
+		},
+		&cli.StringFlag{
+			Name:  "alert-rules",
+			Usage: "path to a JSON file of rate alert rules (defaults to alerting on any collection spiking 5x or dropping to zero)",
+		},
+		&cli.StringFlag{
+			Name:    "alert-webhook-url",
+			Usage:   "URL to POST rate alerts to (Slack incoming webhooks work); alerts are always logged",
+			EnvVars: []string{"SONAR_ALERT_WEBHOOK_URL"},
+		},
+		&cli.BoolFlag{
+			Name:  "track-pds-hosts",
+			Usage: "also track rates per PDS host, resolving the DID of every commit",
+		},
+		&cli.StringFlag{
+			Name:  "plc-host",
+			Usage: "PLC directory to resolve DIDs with, when tracking PDS hosts",
+			Value: identity.DefaultPLCURL,
+		},
 	}
 
 	app.Action = Sonar
@@ -107,6 +132,35 @@ func Sonar(cctx *cli.Context) error {
 		log.Fatalf("failed to create sonar: %+v", err)
 	}
 
+	rules := sonar.DefaultAlertRules()
+	if path := cctx.String("alert-rules"); path != "" {
+		rules, err = sonar.LoadAlertRules(path)
+		if err != nil {
+			log.Fatalf("failed to load alert rules: %+v", err)
+		}
+	}
+	alerters := []sonar.Alerter{&sonar.LogAlerter{Logger: rawlog.Sugar().With("source", "rate_alerts")}}
+	if webhook := cctx.String("alert-webhook-url"); webhook != "" {
+		alerters = append(alerters, sonar.NewWebhookAlerter(webhook))
+	}
+	s.Rates = sonar.NewRateMonitor(rawlog.Sugar().With("source", "rate_monitor"), u.String(), cctx.Duration("rate-window"), rules, alerters...)
+	go s.Rates.Run(ctx)
+
+	if cctx.Bool("track-pds-hosts") {
+		base := identity.BaseDirectory{
+			PLCURL: cctx.String("plc-host"),
+			HTTPClient: http.Client{
+				Timeout:   time.Second * 15,
+				Transport: ratelimit.Transport(nil),
+			},
+			TryAuthoritativeDNS: true,
+			// primary Bluesky PDS instance only supports HTTP resolution method
+			SkipDNSDomainSuffixes: []string{".bsky.social"},
+		}
+		cached := identity.NewCacheDirectory(&base, 100_000, time.Hour*24, time.Minute*2)
+		s.Directory = &cached
+	}
+
 	wg := sync.WaitGroup{}
 
 	scalingSettings := autoscaling.DefaultAutoscaleSettings()
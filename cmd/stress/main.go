@@ -5,14 +5,13 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	mathrand "math/rand"
 	"os"
 	"sync"
 	"time"
 
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
-	appbsky "github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/carstore"
-	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/testing"
 	"github.com/bluesky-social/indigo/util/cliutil"
@@ -75,6 +74,42 @@ var postingCmd = &cli.Command{
 		&cli.StringFlag{
 			Name: "invite",
 		},
+		&cli.StringFlag{
+			Name:  "record-mix",
+			Usage: "weighted record types to create, eg post=6,like=3,follow=1 (also repost, block, or full NSIDs)",
+			Value: "post",
+		},
+		&cli.IntFlag{
+			Name:  "text-size-min",
+			Usage: "minimum random bytes of post text (hex encoded, so twice as many characters)",
+			Value: 100,
+		},
+		&cli.IntFlag{
+			Name:  "text-size-max",
+			Usage: "maximum random bytes of post text",
+			Value: 100,
+		},
+		&cli.Float64Flag{
+			Name:  "delete-ratio",
+			Usage: "fraction of writes which delete a previously created record",
+		},
+		&cli.Float64Flag{
+			Name:  "update-ratio",
+			Usage: "fraction of writes which update a previously created post",
+		},
+		&cli.Float64Flag{
+			Name:  "invalid-ratio",
+			Usage: "fraction of writes which are deliberately invalid records",
+		},
+		&cli.StringFlag{
+			Name:  "invalid-mix",
+			Usage: "weighted kinds of invalid record: missing-field, wrong-type, oversized, type-mismatch, bad-collection, bad-ref",
+			Value: "missing-field,wrong-type,oversized,type-mismatch,bad-collection,bad-ref",
+		},
+		&cli.Int64Flag{
+			Name:  "seed",
+			Usage: "random seed for the write mix (defaults to the current time)",
+		},
 	},
 	Action: func(cctx *cli.Context) error {
 		xrpcc, err := cliutil.GetXrpcClient(cctx, false)
@@ -87,6 +122,17 @@ var postingCmd = &cli.Command{
 		quiet := cctx.Bool("quiet")
 		ctx := context.TODO()
 
+		mix, err := newWriteMix(cctx.String("record-mix"), cctx.String("invalid-mix"),
+			cctx.Int("text-size-min"), cctx.Int("text-size-max"),
+			cctx.Float64("delete-ratio"), cctx.Float64("update-ratio"), cctx.Float64("invalid-ratio"))
+		if err != nil {
+			return err
+		}
+		seed := cctx.Int64("seed")
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+
 		buf := make([]byte, 6)
 		rand.Read(buf)
 		id := hex.EncodeToString(buf)
@@ -121,30 +167,29 @@ var postingCmd = &cli.Command{
 			Did:        resp.Did,
 		}
 
+		fmt.Println("seed: ", seed)
+
+		var stats opStats
 		var wg sync.WaitGroup
 		for con := 0; con < concurrent; con++ {
 			wg.Add(1)
 			go func(worker int) {
 				defer wg.Done()
+				w := &mixWorker{
+					mix:   mix,
+					xrpcc: xrpcc,
+					stats: &stats,
+					rng:   mathrand.New(mathrand.NewSource(seed + int64(worker))),
+				}
 				for i := 0; i < count; i++ {
-					buf := make([]byte, 100)
-					rand.Read(buf)
-
-					res, err := comatproto.RepoCreateRecord(context.TODO(), xrpcc, &comatproto.RepoCreateRecord_Input{
-						Collection: "app.bsky.feed.post",
-						Repo:       xrpcc.Auth.Did,
-						Record: &lexutil.LexiconTypeDecoder{&appbsky.FeedPost{
-							Text:      hex.EncodeToString(buf),
-							CreatedAt: time.Now().Format(time.RFC3339),
-						}},
-					})
+					res, err := w.step(ctx)
 					if err != nil {
 						fmt.Printf("errored on worker %d loop %d: %s\n", worker, i, err)
-						return
+						continue
 					}
 
 					if !quiet {
-						fmt.Println(res.Cid, res.Uri)
+						fmt.Println(res)
 					}
 				}
 			}(con)
@@ -152,6 +197,8 @@ var postingCmd = &cli.Command{
 
 		wg.Wait()
 
+		stats.print()
+
 		return nil
 	},
 }
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// short names accepted in --record-mix, as well as full collection NSIDs
+var recordTypeNames = map[string]string{
+	"post":   "app.bsky.feed.post",
+	"like":   "app.bsky.feed.like",
+	"repost": "app.bsky.feed.repost",
+	"follow": "app.bsky.graph.follow",
+	"block":  "app.bsky.graph.block",
+}
+
+// invalid record kinds accepted in --invalid-mix
+var invalidModes = map[string]bool{
+	// post without the required createdAt
+	"missing-field": true,
+	// post with a number for its text
+	"wrong-type": true,
+	// post with text far over the length limit
+	"oversized": true,
+	// record whose $type doesn't match the collection
+	"type-mismatch": true,
+	// collection which isn't a valid NSID
+	"bad-collection": true,
+	// like with a subject which isn't an at:// URI
+	"bad-ref": true,
+}
+
+type weightedChoice struct {
+	name   string
+	weight float64
+}
+
+// parseWeights parses "a=3,b=1" into choices, with weights defaulting to 1
+func parseWeights(s string) ([]weightedChoice, error) {
+	var out []weightedChoice
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, w, found := strings.Cut(part, "=")
+		weight := 1.0
+		if found {
+			v, err := strconv.ParseFloat(w, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("invalid weight for %s: %q", name, w)
+			}
+			weight = v
+		}
+		out = append(out, weightedChoice{name: name, weight: weight})
+	}
+	return out, nil
+}
+
+func pickWeighted(rng *mathrand.Rand, choices []weightedChoice) string {
+	var total float64
+	for _, c := range choices {
+		total += c.weight
+	}
+	x := rng.Float64() * total
+	for _, c := range choices {
+		if x < c.weight {
+			return c.name
+		}
+		x -= c.weight
+	}
+	return choices[len(choices)-1].name
+}
+
+// writeMix describes the distribution of writes a posting worker makes
+type writeMix struct {
+	records []weightedChoice
+	invalid []weightedChoice
+
+	textMin, textMax int
+
+	deleteRatio  float64
+	updateRatio  float64
+	invalidRatio float64
+}
+
+func newWriteMix(recordMix, invalidMix string, textMin, textMax int, deleteRatio, updateRatio, invalidRatio float64) (*writeMix, error) {
+	records, err := parseWeights(recordMix)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("record mix can't be empty")
+	}
+	for i, r := range records {
+		if nsid, ok := recordTypeNames[r.name]; ok {
+			records[i].name = nsid
+		}
+		switch records[i].name {
+		case "app.bsky.feed.post", "app.bsky.feed.like", "app.bsky.feed.repost", "app.bsky.graph.follow", "app.bsky.graph.block":
+		default:
+			return nil, fmt.Errorf("unsupported record type: %s", r.name)
+		}
+	}
+
+	invalid, err := parseWeights(invalidMix)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range invalid {
+		if !invalidModes[m.name] {
+			return nil, fmt.Errorf("unknown invalid record kind: %s", m.name)
+		}
+	}
+	if invalidRatio > 0 && len(invalid) == 0 {
+		return nil, fmt.Errorf("invalid-ratio set without any invalid-mix kinds")
+	}
+
+	if textMin < 0 || textMax < textMin {
+		return nil, fmt.Errorf("invalid text size range %d-%d", textMin, textMax)
+	}
+	for name, r := range map[string]float64{"delete-ratio": deleteRatio, "update-ratio": updateRatio, "invalid-ratio": invalidRatio} {
+		if r < 0 || r > 1 {
+			return nil, fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	if deleteRatio+updateRatio+invalidRatio > 1 {
+		return nil, fmt.Errorf("delete, update and invalid ratios add up to more than 1")
+	}
+
+	return &writeMix{
+		records:      records,
+		invalid:      invalid,
+		textMin:      textMin,
+		textMax:      textMax,
+		deleteRatio:  deleteRatio,
+		updateRatio:  updateRatio,
+		invalidRatio: invalidRatio,
+	}, nil
+}
+
+// opStats counts the outcome of each kind of write
+type opStats struct {
+	lk     sync.Mutex
+	counts map[string]*opCount
+}
+
+type opCount struct {
+	ok     atomic.Int64
+	failed atomic.Int64
+	time   atomic.Int64
+}
+
+func (s *opStats) record(op string, took time.Duration, err error) {
+	s.lk.Lock()
+	if s.counts == nil {
+		s.counts = make(map[string]*opCount)
+	}
+	c, ok := s.counts[op]
+	if !ok {
+		c = &opCount{}
+		s.counts[op] = c
+	}
+	s.lk.Unlock()
+
+	if err != nil {
+		c.failed.Add(1)
+	} else {
+		c.ok.Add(1)
+	}
+	c.time.Add(int64(took))
+}
+
+func (s *opStats) print() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	var ops []string
+	for op := range s.counts {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+
+	fmt.Printf("%-32s %8s %8s %10s\n", "op", "ok", "failed", "avg")
+	for _, op := range ops {
+		c := s.counts[op]
+		n := c.ok.Load() + c.failed.Load()
+		avg := time.Duration(0)
+		if n > 0 {
+			avg = time.Duration(c.time.Load() / n)
+		}
+		fmt.Printf("%-32s %8d %8d %10s\n", op, c.ok.Load(), c.failed.Load(), avg.Round(time.Microsecond))
+	}
+	for _, op := range ops {
+		if strings.HasPrefix(op, "invalid:") && s.counts[op].ok.Load() > 0 {
+			fmt.Printf("WARNING: %d %s records were accepted\n", s.counts[op].ok.Load(), strings.TrimPrefix(op, "invalid:"))
+		}
+	}
+}
+
+type createdRecord struct {
+	collection string
+	rkey       string
+	uri        string
+	cid        string
+}
+
+// mixWorker makes writes to one repo according to a writeMix. Each worker
+// keeps track of the records it has created, to update, delete and
+// reference them.
+type mixWorker struct {
+	mix   *writeMix
+	xrpcc *xrpc.Client
+	stats *opStats
+	rng   *mathrand.Rand
+
+	records []createdRecord
+	posts   []createdRecord
+}
+
+func (w *mixWorker) step(ctx context.Context) (string, error) {
+	x := w.rng.Float64()
+	switch {
+	case x < w.mix.deleteRatio && len(w.records) > 0:
+		return w.delete(ctx)
+	case x < w.mix.deleteRatio+w.mix.updateRatio && len(w.posts) > 0:
+		return w.update(ctx)
+	case x < w.mix.deleteRatio+w.mix.updateRatio+w.mix.invalidRatio:
+		return w.invalid(ctx)
+	}
+	return w.create(ctx)
+}
+
+func (w *mixWorker) text() string {
+	n := w.mix.textMin
+	if w.mix.textMax > w.mix.textMin {
+		n += w.rng.Intn(w.mix.textMax - w.mix.textMin + 1)
+	}
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (w *mixWorker) create(ctx context.Context) (string, error) {
+	collection := pickWeighted(w.rng, w.mix.records)
+	now := time.Now().Format(util.ISO8601)
+	var rec lexutil.LexiconTypeDecoder
+	switch collection {
+	case "app.bsky.feed.like", "app.bsky.feed.repost":
+		if len(w.posts) == 0 {
+			// nothing to reference yet
+			collection = "app.bsky.feed.post"
+			rec.Val = &appbsky.FeedPost{Text: w.text(), CreatedAt: now}
+			break
+		}
+		p := w.posts[w.rng.Intn(len(w.posts))]
+		subj := &comatproto.RepoStrongRef{Uri: p.uri, Cid: p.cid}
+		if collection == "app.bsky.feed.like" {
+			rec.Val = &appbsky.FeedLike{Subject: subj, CreatedAt: now}
+		} else {
+			rec.Val = &appbsky.FeedRepost{Subject: subj, CreatedAt: now}
+		}
+	case "app.bsky.graph.follow":
+		rec.Val = &appbsky.GraphFollow{Subject: w.xrpcc.Auth.Did, CreatedAt: now}
+	case "app.bsky.graph.block":
+		rec.Val = &appbsky.GraphBlock{Subject: w.xrpcc.Auth.Did, CreatedAt: now}
+	default:
+		rec.Val = &appbsky.FeedPost{Text: w.text(), CreatedAt: now}
+	}
+
+	op := "create:" + collection
+	start := time.Now()
+	res, err := comatproto.RepoCreateRecord(ctx, w.xrpcc, &comatproto.RepoCreateRecord_Input{
+		Collection: collection,
+		Repo:       w.xrpcc.Auth.Did,
+		Record:     &rec,
+	})
+	w.stats.record(op, time.Since(start), err)
+	if err != nil {
+		return op, err
+	}
+
+	cr := createdRecord{collection: collection, rkey: res.Uri[strings.LastIndex(res.Uri, "/")+1:], uri: res.Uri, cid: res.Cid}
+	w.records = append(w.records, cr)
+	if collection == "app.bsky.feed.post" {
+		w.posts = append(w.posts, cr)
+	}
+	return op + " " + res.Uri, nil
+}
+
+func (w *mixWorker) update(ctx context.Context) (string, error) {
+	i := w.rng.Intn(len(w.posts))
+	p := w.posts[i]
+
+	op := "update:" + p.collection
+	start := time.Now()
+	res, err := comatproto.RepoPutRecord(ctx, w.xrpcc, &comatproto.RepoPutRecord_Input{
+		Collection: p.collection,
+		Repo:       w.xrpcc.Auth.Did,
+		Rkey:       p.rkey,
+		Record: &lexutil.LexiconTypeDecoder{Val: &appbsky.FeedPost{
+			Text:      w.text(),
+			CreatedAt: time.Now().Format(util.ISO8601),
+		}},
+	})
+	w.stats.record(op, time.Since(start), err)
+	if err != nil {
+		return op, err
+	}
+	w.posts[i].cid = res.Cid
+	return op + " " + res.Uri, nil
+}
+
+func (w *mixWorker) delete(ctx context.Context) (string, error) {
+	i := w.rng.Intn(len(w.records))
+	r := w.records[i]
+
+	op := "delete:" + r.collection
+	start := time.Now()
+	err := comatproto.RepoDeleteRecord(ctx, w.xrpcc, &comatproto.RepoDeleteRecord_Input{
+		Collection: r.collection,
+		Repo:       w.xrpcc.Auth.Did,
+		Rkey:       r.rkey,
+	})
+	w.stats.record(op, time.Since(start), err)
+	if err != nil {
+		return op, err
+	}
+
+	w.records = append(w.records[:i], w.records[i+1:]...)
+	for j, p := range w.posts {
+		if p.uri == r.uri {
+			w.posts = append(w.posts[:j], w.posts[j+1:]...)
+			break
+		}
+	}
+	return op + " " + r.uri, nil
+}
+
+// invalid sends a malformed createRecord. The typed lexicon structs can't
+// express most of these, so the request body is built by hand.
+func (w *mixWorker) invalid(ctx context.Context) (string, error) {
+	mode := pickWeighted(w.rng, w.mix.invalid)
+	now := time.Now().Format(util.ISO8601)
+
+	collection := "app.bsky.feed.post"
+	var record map[string]any
+	switch mode {
+	case "missing-field":
+		record = map[string]any{"$type": collection, "text": w.text()}
+	case "wrong-type":
+		record = map[string]any{"$type": collection, "text": 12345, "createdAt": now}
+	case "oversized":
+		record = map[string]any{"$type": collection, "text": strings.Repeat("a", 100_000), "createdAt": now}
+	case "type-mismatch":
+		record = map[string]any{"$type": "app.bsky.feed.like", "text": w.text(), "createdAt": now}
+	case "bad-collection":
+		collection = "not a collection"
+		record = map[string]any{"$type": collection, "text": w.text(), "createdAt": now}
+	case "bad-ref":
+		collection = "app.bsky.feed.like"
+		record = map[string]any{"$type": collection, "subject": map[string]any{"uri": "not-a-uri", "cid": "bafyinvalid"}, "createdAt": now}
+	}
+
+	op := "invalid:" + mode
+	var out comatproto.RepoCreateRecord_Output
+	start := time.Now()
+	err := w.xrpcc.Do(ctx, xrpc.Procedure, "application/json", "com.atproto.repo.createRecord", nil, map[string]any{
+		"collection": collection,
+		"repo":       w.xrpcc.Auth.Did,
+		"record":     record,
+	}, &out)
+	w.stats.record(op, time.Since(start), err)
+	if err == nil {
+		return op, fmt.Errorf("invalid record was accepted: %s", out.Uri)
+	}
+	// rejection is the expected outcome
+	return op + " rejected: " + err.Error(), nil
+}
@@ -77,6 +77,9 @@ type Server struct {
 	MaxEventsPerSecond int
 
 	PlaybackFile string
+
+	// Scenario, if set, overrides the event loop parameters
+	Scenario *Scenario
 }
 
 func main() {
@@ -115,6 +118,11 @@ func main() {
 			Value:   "key.raw",
 			EnvVars: []string{"KEY_FILE"},
 		},
+		&cli.StringFlag{
+			Name:    "scenario",
+			Usage:   "YAML scenario file; overrides num-users and total-events when reloading, and events-per-second when firing",
+			EnvVars: []string{"SUPERCOLLIDER_SCENARIO"},
+		},
 	}
 
 	app.Commands = []*cli.Command{
@@ -250,11 +258,23 @@ func Reload(cctx *cli.Context) error {
 		log.Fatalf("failed to generate verification method: %+v\n", err)
 	}
 
+	sc, err := loadScenarioFlag(cctx, log)
+	if err != nil {
+		log.Fatalf("failed to load scenario: %+v\n", err)
+	}
+
+	totalEvents := cctx.Int("total-events")
+
 	// Initialize fake account DIDs
 	dids := []string{}
-	for i := 0; i < cctx.Int("num-users"); i++ {
-		did := fmt.Sprintf("did:web:%s.%s", petname.Generate(4, "-"), cctx.String("hostname"))
-		dids = append(dids, did)
+	if sc != nil {
+		dids = newScenarioGenerator(sc).Dids(cctx.String("hostname"))
+		totalEvents = sc.Events
+	} else {
+		for i := 0; i < cctx.Int("num-users"); i++ {
+			did := fmt.Sprintf("did:web:%s.%s", petname.Generate(4, "-"), cctx.String("hostname"))
+			dids = append(dids, did)
+		}
 	}
 
 	// Instantiate Server
@@ -268,7 +288,8 @@ func Reload(cctx *cli.Context) error {
 		Dids:         dids,
 
 		Events:             em,
-		TotalDesiredEvents: cctx.Int("total-events"),
+		TotalDesiredEvents: totalEvents,
+		Scenario:           sc,
 	}
 
 	repoman.SetEventHandler(s.HandleRepoEvent, false)
@@ -318,6 +339,11 @@ func Reload(cctx *cli.Context) error {
 
 		log.Infof("writing events to %s", outFile)
 
+		var corrupter *eventCorrupter
+		if s.Scenario != nil {
+			corrupter = newEventCorrupter(s.Scenario, 1)
+		}
+
 		header := events.EventHeader{Op: events.EvtKindMessage}
 		for {
 			select {
@@ -342,6 +368,13 @@ func Reload(cctx *cli.Context) error {
 				case evt.RepoCommit != nil:
 					header.MsgType = "#commit"
 					obj = evt.RepoCommit
+					if corrupter != nil {
+						commit, kind := corrupter.Commit(evt.RepoCommit)
+						if kind != "" {
+							invalidEventsCounter.WithLabelValues(kind).Inc()
+						}
+						obj = commit
+					}
 				case evt.RepoHandle != nil:
 					header.MsgType = "#handle"
 					obj = evt.RepoHandle
@@ -464,6 +497,11 @@ func Fire(cctx *cli.Context) error {
 		log.Fatalf("failed to generate verification method: %+v\n", err)
 	}
 
+	sc, err := loadScenarioFlag(cctx, log)
+	if err != nil {
+		log.Fatalf("failed to load scenario: %+v\n", err)
+	}
+
 	// Instantiate Server
 	s := &Server{
 		Logger:             log,
@@ -472,6 +510,7 @@ func Fire(cctx *cli.Context) error {
 		MultibaseKey:       *vMethod.PublicKeyMultibase,
 		MaxEventsPerSecond: cctx.Int("events-per-second"),
 		PlaybackFile:       cctx.String("input-file"),
+		Scenario:           sc,
 	}
 
 	// HTTP Server setup and Middleware Plumbing
@@ -639,6 +678,11 @@ func (s *Server) EventGenerationLoop(ctx context.Context, cancel context.CancelF
 
 	s.Logger.Infof("generating %d events", s.TotalDesiredEvents)
 
+	if s.Scenario != nil {
+		s.scenarioGenerationLoop(ctx)
+		return
+	}
+
 	for i := 0; i < s.TotalDesiredEvents; i++ {
 		text := fake.SentencesN(3)
 		// Trim to 300 chars
@@ -666,6 +710,53 @@ func (s *Server) EventGenerationLoop(ctx context.Context, cancel context.CancelF
 	return
 }
 
+// scenarioGenerationLoop generates the scenario's mix of records
+func (s *Server) scenarioGenerationLoop(ctx context.Context) {
+	gen := newScenarioGenerator(s.Scenario)
+	for i := 0; i < s.TotalDesiredEvents; i++ {
+		op := gen.Next(s.Dids)
+		uid := models.Uid(op.account + 1)
+		if op.deletePath != "" {
+			collection, rkey, _ := strings.Cut(op.deletePath, "/")
+			if err := s.RepoManager.DeleteRecord(ctx, uid, collection, rkey); err != nil {
+				s.Logger.Errorf("failed to delete record: %+v\n", err)
+			} else {
+				eventsGeneratedCounter.Inc()
+			}
+		} else {
+			path, cc, err := s.RepoManager.CreateRecord(ctx, uid, op.collection, op.record)
+			if err != nil {
+				s.Logger.Errorf("failed to create record: %+v\n", err)
+			} else {
+				gen.Created(op, s.Dids[op.account], path, cc.String())
+				eventsGeneratedCounter.Inc()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			s.Logger.Infof("shutting down event generation loop on context done")
+			return
+		default:
+		}
+	}
+
+	s.Logger.Infof("event generation complete, shutting down")
+}
+
+func loadScenarioFlag(cctx *cli.Context, log *zap.SugaredLogger) (*Scenario, error) {
+	path := cctx.String("scenario")
+	if path == "" {
+		return nil, nil
+	}
+	sc, err := LoadScenario(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("running scenario %q (seed %d): %d accounts, %d events, %d rate ramps",
+		sc.Name, sc.Seed, sc.Accounts, sc.Events, len(sc.Ramps))
+	return sc, nil
+}
+
 // ATProto Handlers for DID Web
 
 // HandleAtprotoDid handles reverse-lookups (handle -> DID)
@@ -723,6 +814,14 @@ func (s *Server) HandleSubscribeRepos(c echo.Context) error {
 
 	limiter := rate.NewLimiter(rate.Limit(s.MaxEventsPerSecond), 10)
 
+	// scenarios follow their own rate schedule, starting with each connection
+	var corrupter *eventCorrupter
+	start := time.Now()
+	ramp := -1
+	if s.Scenario != nil {
+		corrupter = newEventCorrupter(s.Scenario, 2)
+	}
+
 	f, err := os.Open(s.PlaybackFile)
 	if err != nil {
 		s.Logger.Errorf("failed to open playback file: %+v\n", err)
@@ -759,8 +858,34 @@ func (s *Server) HandleSubscribeRepos(c echo.Context) error {
 			return err
 		}
 
+		if s.Scenario != nil && len(s.Scenario.Ramps) > 0 {
+			r, i := s.Scenario.RateAt(time.Since(start))
+			if i != ramp {
+				s.Logger.Infof("scenario %q entering ramp %d of %d", s.Scenario.Name, i+1, len(s.Scenario.Ramps))
+				ramp = i
+			}
+			// a zero rate would never let another event through
+			limiter.SetLimit(rate.Limit(max(r, 1)))
+		}
+
 		limiter.Wait(ctx)
 
+		if corrupter != nil {
+			if garbage := corrupter.MalformedFrame(); garbage != nil {
+				if _, err := wc.Write(garbage); err != nil {
+					return fmt.Errorf("failed to write malformed frame: %w", err)
+				}
+				if err := wc.Close(); err != nil {
+					return fmt.Errorf("failed to flush-close malformed frame: %w", err)
+				}
+				invalidEventsCounter.WithLabelValues("malformed_frame").Inc()
+				wc, err = conn.NextWriter(websocket.BinaryMessage)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
 		if err := header.UnmarshalCBOR(f); err != nil {
 			return fmt.Errorf("failed to read header: %w", err)
 		}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/icrowley/fake"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	cbg "github.com/whyrusleeping/cbor-gen"
+	"gopkg.in/yaml.v3"
+)
+
+var invalidEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "supercollider_invalid_events_total",
+	Help: "The total number of deliberately invalid events generated or sent",
+}, []string{"kind"})
+
+// Scenario describes a reproducible load test: which accounts and records
+// to generate (reload), and how fast to send them (fire)
+type Scenario struct {
+	Name string `yaml:"name"`
+	// Seed makes the generated accounts, record mix and invalid events
+	// reproducible; if zero, a seed is picked and logged
+	Seed int64 `yaml:"seed"`
+
+	Accounts int `yaml:"accounts"`
+	Events   int `yaml:"events"`
+	// RecordMix maps collections to relative weights
+	RecordMix map[string]float64 `yaml:"record_mix"`
+	// DeletePercent of events delete an earlier record instead of creating one
+	DeletePercent float64 `yaml:"delete_percent"`
+
+	// Ramps are played in order when firing; the last rate is held until the
+	// events run out
+	Ramps []RateRamp `yaml:"ramps"`
+
+	Invalid InvalidMix `yaml:"invalid"`
+}
+
+// RateRamp is a phase of a scenario, either at a constant Rate, or changing
+// linearly From one rate To another over Duration (events per second)
+type RateRamp struct {
+	Duration time.Duration `yaml:"duration"`
+	Rate     float64       `yaml:"rate"`
+	From     float64       `yaml:"from"`
+	To       float64       `yaml:"to"`
+}
+
+// InvalidMix is the percentage of events to make invalid in each way
+type InvalidMix struct {
+	// commit blocks cut short, so the CAR slice can't be parsed
+	TruncatedBlocks float64 `yaml:"truncated_blocks"`
+	// commits for a repo which doesn't exist
+	UnknownRepo float64 `yaml:"unknown_repo"`
+	// commits whose prev doesn't match the previous commit
+	BrokenPrev float64 `yaml:"broken_prev"`
+	// commits with an unparseable time
+	BadTimestamp float64 `yaml:"bad_timestamp"`
+	// frames of garbage bytes, sent alongside the real events when firing
+	MalformedFrame float64 `yaml:"malformed_frame"`
+}
+
+var scenarioCollections = map[string]bool{
+	"app.bsky.feed.post":    true,
+	"app.bsky.feed.like":    true,
+	"app.bsky.feed.repost":  true,
+	"app.bsky.graph.follow": true,
+	"app.bsky.graph.block":  true,
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sc Scenario
+	if err := yaml.Unmarshal(b, &sc); err != nil {
+		return nil, fmt.Errorf("parsing scenario: %w", err)
+	}
+	if err := sc.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario %q: %w", path, err)
+	}
+	if sc.Seed == 0 {
+		sc.Seed = time.Now().UnixNano()
+	}
+	return &sc, nil
+}
+
+func (sc *Scenario) Validate() error {
+	if sc.Accounts <= 0 {
+		return fmt.Errorf("accounts must be positive")
+	}
+	if sc.Events <= 0 {
+		return fmt.Errorf("events must be positive")
+	}
+	if len(sc.RecordMix) == 0 {
+		return fmt.Errorf("record_mix must list at least one collection")
+	}
+	for c, w := range sc.RecordMix {
+		if !scenarioCollections[c] {
+			return fmt.Errorf("unsupported collection in record_mix: %s", c)
+		}
+		if w < 0 {
+			return fmt.Errorf("negative weight for %s", c)
+		}
+	}
+	for i, r := range sc.Ramps {
+		if r.Duration <= 0 {
+			return fmt.Errorf("ramp %d: duration must be positive", i)
+		}
+		if r.Rate < 0 || r.From < 0 || r.To < 0 {
+			return fmt.Errorf("ramp %d: rates can't be negative", i)
+		}
+	}
+	for name, p := range map[string]float64{
+		"delete_percent":           sc.DeletePercent,
+		"invalid.truncated_blocks": sc.Invalid.TruncatedBlocks,
+		"invalid.unknown_repo":     sc.Invalid.UnknownRepo,
+		"invalid.broken_prev":      sc.Invalid.BrokenPrev,
+		"invalid.bad_timestamp":    sc.Invalid.BadTimestamp,
+		"invalid.malformed_frame":  sc.Invalid.MalformedFrame,
+	} {
+		if p < 0 || p > 100 {
+			return fmt.Errorf("%s must be a percentage", name)
+		}
+	}
+	if sc.Invalid.TruncatedBlocks+sc.Invalid.UnknownRepo+sc.Invalid.BrokenPrev+sc.Invalid.BadTimestamp > 100 {
+		return fmt.Errorf("invalid commit percentages add up to more than 100")
+	}
+	return nil
+}
+
+// RateAt returns the target events per second at a point in the scenario,
+// and which ramp it's in
+func (sc *Scenario) RateAt(elapsed time.Duration) (float64, int) {
+	if len(sc.Ramps) == 0 {
+		return 0, -1
+	}
+	for i, r := range sc.Ramps {
+		if elapsed < r.Duration {
+			if r.Rate > 0 {
+				return r.Rate, i
+			}
+			frac := float64(elapsed) / float64(r.Duration)
+			return r.From + (r.To-r.From)*frac, i
+		}
+		elapsed -= r.Duration
+	}
+	last := sc.Ramps[len(sc.Ramps)-1]
+	if last.Rate > 0 {
+		return last.Rate, len(sc.Ramps) - 1
+	}
+	return last.To, len(sc.Ramps) - 1
+}
+
+type recordRef struct {
+	did  string
+	path string
+	cid  string
+}
+
+// scenarioGenerator picks the records for a scenario. It isn't safe for
+// concurrent use.
+type scenarioGenerator struct {
+	sc  *Scenario
+	rng *rand.Rand
+
+	collections []string
+	weights     []float64
+	total       float64
+
+	// recent posts, to like and repost
+	posts []recordRef
+	// records created by each account, to delete
+	created map[int][]string
+}
+
+// how many posts and records per account are remembered for later events
+const generatorMemory = 1000
+
+func newScenarioGenerator(sc *Scenario) *scenarioGenerator {
+	g := &scenarioGenerator{
+		sc:      sc,
+		rng:     rand.New(rand.NewSource(sc.Seed)),
+		created: make(map[int][]string),
+	}
+	for c := range sc.RecordMix {
+		g.collections = append(g.collections, c)
+	}
+	// map order is random, and the picks need to be reproducible
+	sort.Strings(g.collections)
+	for _, c := range g.collections {
+		g.total += sc.RecordMix[c]
+		g.weights = append(g.weights, g.total)
+	}
+	return g
+}
+
+// Dids returns the scenario's account DIDs, under host
+func (g *scenarioGenerator) Dids(host string) []string {
+	dids := make([]string, g.sc.Accounts)
+	for i := range dids {
+		dids[i] = fmt.Sprintf("did:web:user%d.%s", i+1, host)
+	}
+	return dids
+}
+
+// generatedOp is either a record to create, or a record path to delete
+type generatedOp struct {
+	account    int
+	collection string
+	record     cbg.CBORMarshaler
+	deletePath string
+}
+
+func (g *scenarioGenerator) Next(dids []string) generatedOp {
+	account := g.rng.Intn(len(dids))
+	if g.sc.DeletePercent > 0 && g.rng.Float64()*100 < g.sc.DeletePercent {
+		if paths := g.created[account]; len(paths) > 0 {
+			i := g.rng.Intn(len(paths))
+			path := paths[i]
+			g.created[account] = append(paths[:i], paths[i+1:]...)
+			return generatedOp{account: account, deletePath: path}
+		}
+	}
+
+	collection := g.pickCollection()
+	now := time.Now().Format(util.ISO8601)
+	var rec cbg.CBORMarshaler
+	switch collection {
+	case "app.bsky.feed.like", "app.bsky.feed.repost":
+		if len(g.posts) == 0 {
+			collection = "app.bsky.feed.post"
+			rec = g.post(now)
+			break
+		}
+		p := g.posts[g.rng.Intn(len(g.posts))]
+		subj := &comatproto.RepoStrongRef{Uri: "at://" + p.did + "/" + p.path, Cid: p.cid}
+		if collection == "app.bsky.feed.like" {
+			rec = &bsky.FeedLike{CreatedAt: now, Subject: subj}
+		} else {
+			rec = &bsky.FeedRepost{CreatedAt: now, Subject: subj}
+		}
+	case "app.bsky.graph.follow":
+		rec = &bsky.GraphFollow{CreatedAt: now, Subject: dids[g.rng.Intn(len(dids))]}
+	case "app.bsky.graph.block":
+		rec = &bsky.GraphBlock{CreatedAt: now, Subject: dids[g.rng.Intn(len(dids))]}
+	default:
+		rec = g.post(now)
+	}
+	return generatedOp{account: account, collection: collection, record: rec}
+}
+
+// Created records the result of creating a generated record
+func (g *scenarioGenerator) Created(op generatedOp, did, path, cid string) {
+	g.created[op.account] = remember(g.created[op.account], path)
+	if op.collection == "app.bsky.feed.post" {
+		ref := recordRef{did: did, path: path, cid: cid}
+		if len(g.posts) >= generatorMemory {
+			g.posts[g.rng.Intn(len(g.posts))] = ref
+		} else {
+			g.posts = append(g.posts, ref)
+		}
+	}
+}
+
+func remember(paths []string, path string) []string {
+	if len(paths) >= generatorMemory {
+		paths = paths[1:]
+	}
+	return append(paths, path)
+}
+
+func (g *scenarioGenerator) pickCollection() string {
+	x := g.rng.Float64() * g.total
+	for i, w := range g.weights {
+		if x < w {
+			return g.collections[i]
+		}
+	}
+	return g.collections[len(g.collections)-1]
+}
+
+func (g *scenarioGenerator) post(now string) *bsky.FeedPost {
+	text := fake.SentencesN(3)
+	// Trim to 300 chars
+	if len(text) > 300 {
+		text = text[:300]
+	}
+	return &bsky.FeedPost{CreatedAt: now, Text: text}
+}
+
+// eventCorrupter makes a scenario's share of events invalid. It isn't safe
+// for concurrent use.
+type eventCorrupter struct {
+	mix InvalidMix
+	rng *rand.Rand
+}
+
+func newEventCorrupter(sc *Scenario, salt int64) *eventCorrupter {
+	// seeded separately from the generator, so changing the invalid mix
+	// doesn't change which records are generated
+	return &eventCorrupter{mix: sc.Invalid, rng: rand.New(rand.NewSource(sc.Seed ^ salt))}
+}
+
+// Commit returns evt, or an invalid copy of it, and the kind of corruption
+// applied ("" if none)
+func (ec *eventCorrupter) Commit(evt *comatproto.SyncSubscribeRepos_Commit) (*comatproto.SyncSubscribeRepos_Commit, string) {
+	x := ec.rng.Float64() * 100
+	out := *evt
+	switch {
+	case x < ec.mix.TruncatedBlocks:
+		out.Blocks = evt.Blocks[:len(evt.Blocks)/2]
+		return &out, "truncated_blocks"
+	case x < ec.mix.TruncatedBlocks+ec.mix.UnknownRepo:
+		out.Repo = fmt.Sprintf("did:web:missing%d.invalid", ec.rng.Int63())
+		return &out, "unknown_repo"
+	case x < ec.mix.TruncatedBlocks+ec.mix.UnknownRepo+ec.mix.BrokenPrev:
+		// point prev at the commit itself, which can't be right
+		prev := evt.Commit
+		out.Prev = &prev
+		return &out, "broken_prev"
+	case x < ec.mix.TruncatedBlocks+ec.mix.UnknownRepo+ec.mix.BrokenPrev+ec.mix.BadTimestamp:
+		out.Time = "not a timestamp"
+		return &out, "bad_timestamp"
+	}
+	return evt, ""
+}
+
+// MalformedFrame returns garbage to send as a frame, or nil if this event
+// shouldn't be accompanied by one
+func (ec *eventCorrupter) MalformedFrame() []byte {
+	if ec.mix.MalformedFrame == 0 || ec.rng.Float64()*100 >= ec.mix.MalformedFrame {
+		return nil
+	}
+	b := make([]byte, 16+ec.rng.Intn(256))
+	ec.rng.Read(b)
+	return b
+}
@@ -0,0 +1,269 @@
+package did
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/whyrusleeping/go-did"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// FetchedDocument is a DID document along with what is needed to check
+// whether it has changed since
+type FetchedDocument struct {
+	Doc *Document
+	// Revision identifies this version of the document: the ETag for
+	// did:web (if the server sent one), or the CID of the latest operation
+	// for did:plc
+	Revision string
+	// LastModified is the did:web server's Last-Modified header, if any
+	LastModified string
+	// FetchedAt is when this revision was first fetched
+	FetchedAt time.Time
+	// CheckedAt is when the document was last confirmed to be current
+	CheckedAt time.Time
+}
+
+// Age is how long it has been since the document was confirmed current
+func (fd *FetchedDocument) Age() time.Duration {
+	return time.Since(fd.CheckedAt)
+}
+
+// ConditionalFetcher resolves did:web and did:plc documents, caching them
+// and revalidating stale entries cheaply instead of refetching them: with
+// If-None-Match / If-Modified-Since for did:web, and by comparing the CID of
+// the latest PLC operation for did:plc.
+type ConditionalFetcher struct {
+	// Client is used for PLC directory requests
+	Client *http.Client
+	// WebClient is used for did:web documents. It defaults to an SSRF-safe
+	// client, which refuses to connect to internal addresses.
+	WebClient *http.Client
+	PLCHost   string
+	// Insecure fetches did:web documents over http, and from any address,
+	// for testing
+	Insecure bool
+	// MaxAge is how long a document is served from cache before it is
+	// revalidated
+	MaxAge time.Duration
+
+	cache *lru.ARCCache
+	// concurrent fetches of the same DID share a revalidation
+	lk       sync.Mutex
+	inflight map[string]*fetchCall
+}
+
+type fetchCall struct {
+	done chan struct{}
+	fd   *FetchedDocument
+	err  error
+}
+
+func NewConditionalFetcher(plcHost string, maxAge time.Duration, size int) *ConditionalFetcher {
+	c, err := lru.NewARC(size)
+	if err != nil {
+		panic(err)
+	}
+	return &ConditionalFetcher{
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		WebClient: safeWebClient,
+		PLCHost:   plcHost,
+		MaxAge:    maxAge,
+		cache:     c,
+		inflight:  make(map[string]*fetchCall),
+	}
+}
+
+// GetDocument implements Resolver
+func (f *ConditionalFetcher) GetDocument(ctx context.Context, didstr string) (*Document, error) {
+	fd, err := f.Fetch(ctx, didstr)
+	if err != nil {
+		return nil, err
+	}
+	return fd.Doc, nil
+}
+
+func (f *ConditionalFetcher) FlushCacheFor(didstr string) {
+	f.cache.Remove(didstr)
+}
+
+// Fetch returns the DID's document, from cache if it was checked within
+// MaxAge, and otherwise revalidating or refetching it.
+func (f *ConditionalFetcher) Fetch(ctx context.Context, didstr string) (*FetchedDocument, error) {
+	ctx, span := otel.Tracer("did").Start(ctx, "conditionalFetch")
+	defer span.End()
+
+	var cached *FetchedDocument
+	if v, ok := f.cache.Get(didstr); ok {
+		cached = v.(*FetchedDocument)
+		if cached.Age() < f.MaxAge {
+			span.SetAttributes(attribute.String("result", "cached"))
+			fetchResultsTotal.WithLabelValues("cached").Inc()
+			return cached, nil
+		}
+	}
+
+	f.lk.Lock()
+	call, ok := f.inflight[didstr]
+	if ok {
+		f.lk.Unlock()
+		select {
+		case <-call.done:
+			return call.fd, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call = &fetchCall{done: make(chan struct{})}
+	f.inflight[didstr] = call
+	f.lk.Unlock()
+
+	call.fd, call.err = f.fetch(ctx, didstr, cached)
+	if call.err == nil {
+		f.cache.Add(didstr, call.fd)
+	}
+
+	f.lk.Lock()
+	delete(f.inflight, didstr)
+	f.lk.Unlock()
+	close(call.done)
+
+	return call.fd, call.err
+}
+
+func (f *ConditionalFetcher) fetch(ctx context.Context, didstr string, cached *FetchedDocument) (*FetchedDocument, error) {
+	pdid, err := did.ParseDID(didstr)
+	if err != nil {
+		return nil, err
+	}
+	switch pdid.Protocol() {
+	case "web":
+		if err := checkValidDidWeb(pdid.Value()); err != nil {
+			return nil, err
+		}
+		proto, client := "https", f.WebClient
+		if f.Insecure {
+			proto, client = "http", f.Client
+		}
+		return f.fetchWeb(ctx, client, proto+"://"+pdid.Value()+"/.well-known/did.json", cached)
+	case "plc":
+		return f.fetchPLC(ctx, didstr, cached)
+	default:
+		return nil, fmt.Errorf("unsupported did method: %q", pdid.Protocol())
+	}
+}
+
+func (f *ConditionalFetcher) fetchWeb(ctx context.Context, client *http.Client, u string, cached *FetchedDocument) (*FetchedDocument, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		if cached.Revision != "" {
+			req.Header.Set("If-None-Match", cached.Revision)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	now := time.Now()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		fetchResultsTotal.WithLabelValues("not_modified").Inc()
+		return revalidated(cached, now), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch did request failed (status %d): %s", resp.StatusCode, resp.Status)
+	}
+
+	var doc Document
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	fetchResultsTotal.WithLabelValues("fetched").Inc()
+	return &FetchedDocument{
+		Doc:          &doc,
+		Revision:     resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    now,
+		CheckedAt:    now,
+	}, nil
+}
+
+func (f *ConditionalFetcher) fetchPLC(ctx context.Context, didstr string, cached *FetchedDocument) (*FetchedDocument, error) {
+	rev, err := f.lastPLCOperationCID(ctx, didstr)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if cached != nil && cached.Revision == rev {
+		fetchResultsTotal.WithLabelValues("not_modified").Inc()
+		return revalidated(cached, now), nil
+	}
+
+	fd, err := f.fetchWeb(ctx, f.Client, f.PLCHost+"/"+didstr, nil)
+	if err != nil {
+		return nil, err
+	}
+	fd.Revision = rev
+	fd.LastModified = ""
+	return fd, nil
+}
+
+// lastPLCOperationCID fetches the DID's latest operation and computes its
+// CID, which changes whenever the document does
+func (f *ConditionalFetcher) lastPLCOperationCID(ctx context.Context, didstr string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.PLCHost+"/"+didstr+"/log/last", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching last PLC operation failed (status %d): %s", resp.StatusCode, resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	obj, err := data.UnmarshalJSON(b)
+	if err != nil {
+		return "", fmt.Errorf("parsing last PLC operation: %w", err)
+	}
+	cb, err := data.MarshalCBOR(obj)
+	if err != nil {
+		return "", err
+	}
+	c, err := data.ComputeCID(cb)
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
+
+// revalidated returns a copy of a cached document marked as checked at now.
+// Cache entries are shared with callers, so aren't modified in place.
+func revalidated(cached *FetchedDocument, now time.Time) *FetchedDocument {
+	fd := *cached
+	fd.CheckedAt = now
+	return &fd
+}
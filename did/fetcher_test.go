@@ -0,0 +1,112 @@
+package did
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testPLCDoc = `{"@context":["https://www.w3.org/ns/did/v1"],"id":"did:plc:ewvi7nxzyoun6zhxrhs64oiz","alsoKnownAs":["at://alice.example.com"],"verificationMethod":[],"service":[]}`
+
+func TestConditionalFetcherPLC(t *testing.T) {
+	ctx := context.Background()
+
+	var docFetches, opFetches atomic.Int32
+	lastOp := `{"type":"plc_operation","rotationKeys":[],"verificationMethods":{},"alsoKnownAs":["at://alice.example.com"],"services":{},"prev":null,"sig":"aaaa"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/did:plc:ewvi7nxzyoun6zhxrhs64oiz":
+			docFetches.Add(1)
+			w.Write([]byte(testPLCDoc))
+		case "/did:plc:ewvi7nxzyoun6zhxrhs64oiz/log/last":
+			opFetches.Add(1)
+			w.Write([]byte(lastOp))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	f := NewConditionalFetcher(srv.URL, time.Hour, 100)
+	did := "did:plc:ewvi7nxzyoun6zhxrhs64oiz"
+
+	fd, err := f.Fetch(ctx, did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd.Revision == "" || fd.Doc.ID.String() != did {
+		t.Fatalf("unexpected document: %+v", fd)
+	}
+	rev := fd.Revision
+
+	// within MaxAge: no requests at all
+	if _, err := f.Fetch(ctx, did); err != nil {
+		t.Fatal(err)
+	}
+	if opFetches.Load() != 1 || docFetches.Load() != 1 {
+		t.Fatalf("expected one fetch of each, got %d ops and %d docs", opFetches.Load(), docFetches.Load())
+	}
+
+	// stale but unchanged: only the last operation is checked
+	f.MaxAge = 0
+	fd, err = f.Fetch(ctx, did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opFetches.Load() != 2 || docFetches.Load() != 1 {
+		t.Fatalf("expected revalidation without a document fetch, got %d ops and %d docs", opFetches.Load(), docFetches.Load())
+	}
+	if fd.Revision != rev || fd.CheckedAt.Before(fd.FetchedAt) {
+		t.Fatalf("unexpected revalidated document: %+v", fd)
+	}
+
+	// changed: the document is fetched again
+	lastOp = `{"type":"plc_operation","rotationKeys":[],"verificationMethods":{},"alsoKnownAs":["at://alice2.example.com"],"services":{},"prev":null,"sig":"aaaa"}`
+	fd, err = f.Fetch(ctx, did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if docFetches.Load() != 2 || fd.Revision == rev {
+		t.Fatalf("expected a new revision to be fetched, got %d docs and revision %s", docFetches.Load(), fd.Revision)
+	}
+}
+
+func TestConditionalFetcherWeb(t *testing.T) {
+	ctx := context.Background()
+
+	var full, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"id":"did:web:example.com"}`))
+	}))
+	defer srv.Close()
+
+	f := NewConditionalFetcher("", 0, 100)
+	fd, err := f.fetchWeb(ctx, f.Client, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd.Revision != `"v1"` {
+		t.Fatalf("unexpected revision: %q", fd.Revision)
+	}
+
+	again, err := f.fetchWeb(ctx, f.Client, srv.URL, fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Load() != 1 || notModified.Load() != 1 {
+		t.Fatalf("expected a conditional request, got %d full and %d not modified", full.Load(), notModified.Load())
+	}
+	if again.Doc != fd.Doc || again.FetchedAt != fd.FetchedAt {
+		t.Fatal("expected the cached document to be reused")
+	}
+}
@@ -9,3 +9,8 @@ var mrResolvedDidsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "multiresolver_resolved_dids_total",
 	Help: "Total number of DIDs resolved",
 }, []string{"resolver"})
+
+var fetchResultsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "did_conditional_fetch_results_total",
+	Help: "Results of DID document fetches: served from cache, revalidated unchanged, or fetched",
+}, []string{"result"})
@@ -6,17 +6,45 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 	"unicode"
 
+	"github.com/bluesky-social/indigo/util"
+
 	"github.com/whyrusleeping/go-did"
 	"go.opentelemetry.io/otel"
 )
 
 type WebResolver struct {
+	// Insecure fetches documents over http, and allows private addresses,
+	// for local testing
 	Insecure bool
+	// Client overrides the HTTP client. By default documents are fetched
+	// with an SSRF-safe client, which refuses to connect to internal
+	// addresses.
+	Client *http.Client
 	// TODO: cache? maybe at a different layer
 }
 
+var safeWebClient = newSafeWebClient()
+
+func newSafeWebClient() *http.Client {
+	cfg := util.DefaultHTTPClientConfig()
+	cfg.RetryMax = 0
+	cfg.Timeout = 10 * time.Second
+	return util.NewSafeHTTPClient(cfg)
+}
+
+func (wr *WebResolver) client() *http.Client {
+	if wr.Client != nil {
+		return wr.Client
+	}
+	if wr.Insecure {
+		return http.DefaultClient
+	}
+	return safeWebClient
+}
+
 func (wr *WebResolver) GetDocument(ctx context.Context, didstr string) (*Document, error) {
 	ctx, span := otel.Tracer("did").Start(ctx, "didWebGetDocument")
 	defer span.End()
@@ -36,7 +64,11 @@ func (wr *WebResolver) GetDocument(ctx context.Context, didstr string) (*Documen
 		proto = "http"
 	}
 
-	resp, err := http.Get(proto + "://" + val + "/.well-known/did.json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, proto+"://"+val+"/.well-known/did.json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := wr.client().Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -16,6 +16,7 @@ import (
 )
 
 type RepoStreamCallbacks struct {
+	RepoAccount   func(evt *comatproto.SyncSubscribeRepos_Account) error
 	RepoCommit    func(evt *comatproto.SyncSubscribeRepos_Commit) error
 	RepoHandle    func(evt *comatproto.SyncSubscribeRepos_Handle) error
 	RepoInfo      func(evt *comatproto.SyncSubscribeRepos_Info) error
@@ -28,6 +29,8 @@ type RepoStreamCallbacks struct {
 
 func (rsc *RepoStreamCallbacks) EventHandler(ctx context.Context, xev *XRPCStreamEvent) error {
 	switch {
+	case xev.RepoAccount != nil && rsc.RepoAccount != nil:
+		return rsc.RepoAccount(xev.RepoAccount)
 	case xev.RepoCommit != nil && rsc.RepoCommit != nil:
 		return rsc.RepoCommit(xev.RepoCommit)
 	case xev.RepoHandle != nil && rsc.RepoHandle != nil:
@@ -113,6 +116,9 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 	})
 
 	lastSeq := int64(-1)
+	// label batches have their own sequence numbers, even when multiplexed
+	// with repo events
+	lastLabelSeq := int64(-1)
 	for {
 		select {
 		case <-ctx.Done():
@@ -164,6 +170,22 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 				}); err != nil {
 					return err
 				}
+			case "#account":
+				var evt comatproto.SyncSubscribeRepos_Account
+				if err := evt.UnmarshalCBOR(r); err != nil {
+					return err
+				}
+
+				if evt.Seq < lastSeq {
+					log.Errorf("Got events out of order from stream (seq = %d, prev = %d)", evt.Seq, lastSeq)
+				}
+				lastSeq = evt.Seq
+
+				if err := sched.AddWork(ctx, evt.Did, &XRPCStreamEvent{
+					RepoAccount: &evt,
+				}); err != nil {
+					return err
+				}
 			case "#handle":
 				var evt comatproto.SyncSubscribeRepos_Handle
 				if err := evt.UnmarshalCBOR(r); err != nil {
@@ -224,17 +246,17 @@ func HandleRepoStream(ctx context.Context, con *websocket.Conn, sched Scheduler)
 				}); err != nil {
 					return err
 				}
-			case "#labebatch":
+			case "#labels", "#labebatch":
 				var evt label.SubscribeLabels_Labels
 				if err := evt.UnmarshalCBOR(r); err != nil {
 					return fmt.Errorf("reading Labels event: %w", err)
 				}
 
-				if evt.Seq < lastSeq {
-					log.Errorf("Got events out of order from stream (seq = %d, prev = %d)", evt.Seq, lastSeq)
+				if evt.Seq < lastLabelSeq {
+					log.Errorf("Got label events out of order from stream (seq = %d, prev = %d)", evt.Seq, lastLabelSeq)
 				}
 
-				lastSeq = evt.Seq
+				lastLabelSeq = evt.Seq
 
 				if err := sched.AddWork(ctx, "", &XRPCStreamEvent{
 					LabelLabels: &evt,
@@ -72,6 +72,9 @@ type RepoEventRecord struct {
 	Commit    *models.DbCID
 	Prev      *models.DbCID
 	NewHandle *string // NewHandle is only set if this is a handle change event
+	// AccountStatus is only set if this is an account status event; it is
+	// "active" for active accounts
+	AccountStatus *string
 
 	Time   time.Time
 	Blobs  []byte
@@ -167,6 +170,8 @@ func (p *DbPersistence) flushBatchLocked(ctx context.Context) error {
 			e.RepoHandle.Seq = int64(item.Seq)
 		case e.RepoTombstone != nil:
 			e.RepoTombstone.Seq = int64(item.Seq)
+		case e.RepoAccount != nil:
+			e.RepoAccount.Seq = int64(item.Seq)
 		default:
 			return fmt.Errorf("unknown event type")
 		}
@@ -216,6 +221,11 @@ func (p *DbPersistence) Persist(ctx context.Context, e *XRPCStreamEvent) error {
 		if err != nil {
 			return err
 		}
+	case e.RepoAccount != nil:
+		rer, err = p.RecordFromAccount(ctx, e.RepoAccount)
+		if err != nil {
+			return err
+		}
 	default:
 		return nil
 	}
@@ -264,6 +274,33 @@ func (p *DbPersistence) RecordFromTombstone(ctx context.Context, evt *comatproto
 	}, nil
 }
 
+func (p *DbPersistence) RecordFromAccount(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Account) (*RepoEventRecord, error) {
+	t, err := time.Parse(util.ISO8601, evt.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := p.uidForDid(ctx, evt.Did)
+	if err != nil {
+		return nil, err
+	}
+
+	status := "active"
+	if !evt.Active {
+		status = ""
+		if evt.Status != nil {
+			status = *evt.Status
+		}
+	}
+
+	return &RepoEventRecord{
+		Repo:          uid,
+		Type:          "repo_account",
+		Time:          t,
+		AccountStatus: &status,
+	}, nil
+}
+
 func (p *DbPersistence) RecordFromRepoCommit(ctx context.Context, evt *comatproto.SyncSubscribeRepos_Commit) (*RepoEventRecord, error) {
 	// TODO: hack hack hack
 	if len(evt.Ops) > 8192 {
@@ -398,6 +435,8 @@ func (p *DbPersistence) hydrateBatch(ctx context.Context, batch []*RepoEventReco
 				streamEvent, err = p.hydrateHandleChange(ctx, record)
 			case record.Type == "repo_tombstone":
 				streamEvent, err = p.hydrateTombstone(ctx, record)
+			case record.Type == "repo_account":
+				streamEvent, err = p.hydrateAccount(ctx, record)
 			default:
 				err = fmt.Errorf("unknown event type: %s", record.Type)
 			}
@@ -493,6 +532,28 @@ func (p *DbPersistence) hydrateTombstone(ctx context.Context, rer *RepoEventReco
 	}, nil
 }
 
+func (p *DbPersistence) hydrateAccount(ctx context.Context, rer *RepoEventRecord) (*XRPCStreamEvent, error) {
+	if rer.AccountStatus == nil {
+		return nil, fmt.Errorf("AccountStatus is nil")
+	}
+
+	did, err := p.didForUid(ctx, rer.Repo)
+	if err != nil {
+		return nil, err
+	}
+
+	evt := &comatproto.SyncSubscribeRepos_Account{
+		Did:    did,
+		Active: *rer.AccountStatus == "active",
+		Time:   rer.Time.Format(util.ISO8601),
+	}
+	if !evt.Active && *rer.AccountStatus != "" {
+		evt.Status = rer.AccountStatus
+	}
+
+	return &XRPCStreamEvent{RepoAccount: evt}, nil
+}
+
 func (p *DbPersistence) hydrateCommit(ctx context.Context, rer *RepoEventRecord) (*XRPCStreamEvent, error) {
 	if rer.Commit == nil {
 		return nil, fmt.Errorf("commit is nil")
@@ -276,6 +276,7 @@ const (
 	evtKindCommit    = 1
 	evtKindHandle    = 2
 	evtKindTombstone = 3
+	evtKindAccount   = 4
 )
 
 var emptyHeader = make([]byte, headerSize)
@@ -453,8 +454,10 @@ func (dp *DiskPersistence) doPersist(ctx context.Context, j persistJob) error {
 		e.RepoHandle.Seq = seq
 	case e.RepoTombstone != nil:
 		e.RepoTombstone.Seq = seq
+	case e.RepoAccount != nil:
+		e.RepoAccount.Seq = seq
 	default:
-		// only those three get peristed right now
+		// only those four get peristed right now
 		// we shouldnt actually ever get here...
 		return nil
 	}
@@ -511,6 +514,12 @@ func (dp *DiskPersistence) Persist(ctx context.Context, e *XRPCStreamEvent) erro
 		if err := e.RepoTombstone.MarshalCBOR(cw); err != nil {
 			return fmt.Errorf("failed to marshal: %w", err)
 		}
+	case e.RepoAccount != nil:
+		evtKind = evtKindAccount
+		did = e.RepoAccount.Did
+		if err := e.RepoAccount.MarshalCBOR(cw); err != nil {
+			return fmt.Errorf("failed to marshal: %w", err)
+		}
 	default:
 		return nil
 		// only those two get peristed right now
@@ -741,6 +750,15 @@ func (dp *DiskPersistence) readEventsFrom(ctx context.Context, since int64, fn s
 			if err := cb(&XRPCStreamEvent{RepoTombstone: &evt}); err != nil {
 				return nil, err
 			}
+		case evtKindAccount:
+			var evt atproto.SyncSubscribeRepos_Account
+			if err := evt.UnmarshalCBOR(io.LimitReader(bufr, h.Len64())); err != nil {
+				return nil, err
+			}
+			evt.Seq = h.Seq
+			if err := cb(&XRPCStreamEvent{RepoAccount: &evt}); err != nil {
+				return nil, err
+			}
 		default:
 			log.Warnw("unrecognized event kind coming from log file", "seq", h.Seq, "kind", h.Kind)
 			return nil, fmt.Errorf("halting on unrecognized event kind")
@@ -28,10 +28,24 @@ type EventManager struct {
 	subsLk sync.Mutex
 
 	bufferSize int
+	overflow   OverflowPolicy
 
 	persister EventPersistence
 }
 
+// OverflowPolicy says what to do with a subscriber whose buffer is full
+type OverflowPolicy int
+
+const (
+	// OverflowDisconnect sends the subscriber a ConsumerTooSlow error frame
+	// and drops it
+	OverflowDisconnect OverflowPolicy = iota
+	// OverflowDrop skips events until the subscriber catches up. The
+	// subscriber sees a gap in sequence numbers and can reconnect with a
+	// cursor to fill it.
+	OverflowDrop
+)
+
 func NewEventManager(persister EventPersistence) *EventManager {
 	em := &EventManager{
 		bufferSize: 32 << 10,
@@ -55,6 +69,23 @@ type Operation struct {
 	evt *XRPCStreamEvent
 }
 
+// SetBufferSize sets how many events are buffered for each new subscriber
+func (em *EventManager) SetBufferSize(n int) {
+	em.bufferSize = n
+}
+
+// SetOverflowPolicy sets what happens to subscribers that fall behind
+func (em *EventManager) SetOverflowPolicy(p OverflowPolicy) {
+	em.overflow = p
+}
+
+// SetPersister replaces the event persister. It must be called before any
+// events are added.
+func (em *EventManager) SetPersister(persister EventPersistence) {
+	em.persister = persister
+	persister.SetEventBroadcaster(em.broadcastEvent)
+}
+
 func (em *EventManager) Shutdown(ctx context.Context) error {
 	return em.persister.Shutdown(ctx)
 }
@@ -75,6 +106,10 @@ func (em *EventManager) broadcastEvent(evt *XRPCStreamEvent) {
 			case s.outgoing <- evt:
 			case <-s.done:
 			default:
+				if em.overflow == OverflowDrop {
+					s.droppedCounter.Inc()
+					continue
+				}
 				log.Warnw("dropping slow consumer due to event overflow", "bufferSize", len(s.outgoing), "ident", s.ident)
 				go func(torem *Subscriber) {
 					torem.lk.Lock()
@@ -122,6 +157,7 @@ type Subscriber struct {
 	ident            string
 	enqueuedCounter  prometheus.Counter
 	broadcastCounter prometheus.Counter
+	droppedCounter   prometheus.Counter
 }
 
 const (
@@ -136,6 +172,7 @@ type EventHeader struct {
 
 type XRPCStreamEvent struct {
 	Error         *ErrorFrame
+	RepoAccount   *comatproto.SyncSubscribeRepos_Account
 	RepoCommit    *comatproto.SyncSubscribeRepos_Commit
 	RepoHandle    *comatproto.SyncSubscribeRepos_Handle
 	RepoInfo      *comatproto.SyncSubscribeRepos_Info
@@ -144,6 +181,10 @@ type XRPCStreamEvent struct {
 	LabelLabels   *label.SubscribeLabels_Labels
 	LabelInfo     *label.SubscribeLabels_Info
 
+	// records in RepoCommit which failed lexicon validation; only set by
+	// LexiconValidatingCallbacks
+	InvalidRecords []InvalidRecord `json:"-" cborgen:"-"`
+
 	// some private fields for internal routing perf
 	PrivUid         models.Uid `json:"-" cborgen:"-"`
 	PrivPdsId       uint       `json:"-" cborgen:"-"`
@@ -181,6 +222,7 @@ func (em *EventManager) Subscribe(ctx context.Context, ident string, filter func
 		done:             done,
 		enqueuedCounter:  eventsEnqueued.WithLabelValues(ident),
 		broadcastCounter: eventsBroadcast.WithLabelValues(ident),
+		droppedCounter:   eventsDropped.WithLabelValues(ident),
 	}
 
 	sub.cleanup = sync.OnceFunc(func() {
@@ -272,6 +314,8 @@ func sequenceForEvent(evt *XRPCStreamEvent) int64 {
 	switch {
 	case evt == nil:
 		return -1
+	case evt.RepoAccount != nil:
+		return evt.RepoAccount.Seq
 	case evt.RepoCommit != nil:
 		return evt.RepoCommit.Seq
 	case evt.RepoHandle != nil:
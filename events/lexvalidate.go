@@ -0,0 +1,171 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/lexicon"
+	"github.com/bluesky-social/indigo/repomgr"
+
+	"github.com/ipfs/go-cid"
+	car "github.com/ipld/go-car/v2"
+)
+
+// What LexiconValidatingCallbacks does with commit ops whose records fail validation
+type InvalidRecordPolicy int
+
+const (
+	// Passes events through unchanged, listing invalid records in XRPCStreamEvent.InvalidRecords
+	InvalidRecordAnnotate InvalidRecordPolicy = iota
+	// Removes ops with invalid records from the commit, as well as listing them
+	InvalidRecordDrop
+)
+
+// A record in a commit event which failed lexicon validation
+type InvalidRecord struct {
+	// repo path of the record ("<collection>/<rkey>")
+	Path string
+	Cid  cid.Cid
+	Err  error
+}
+
+type LexiconValidatorConfig struct {
+	// Schemas records are validated against. Records in collections the
+	// catalog doesn't know about are passed through without validation.
+	Catalog lexicon.Catalog
+	Mode    lexicon.ValidationMode
+	Policy  InvalidRecordPolicy
+	// Host the events are from, for metrics
+	Host string
+}
+
+// LexiconValidatingCallbacks validates the records created or updated by
+// commit events against lexicon schemas, before passing events on to Next.
+// Invalid records are counted per collection and host, and annotated or
+// dropped depending on the policy.
+//
+// Annotations are only visible to handlers which take the whole
+// XRPCStreamEvent (not those in RepoStreamCallbacks).
+type LexiconValidatingCallbacks struct {
+	cfg  LexiconValidatorConfig
+	Next func(ctx context.Context, xev *XRPCStreamEvent) error
+}
+
+func NewLexiconValidatingCallbacks(cfg LexiconValidatorConfig, next func(ctx context.Context, xev *XRPCStreamEvent) error) *LexiconValidatingCallbacks {
+	return &LexiconValidatingCallbacks{
+		cfg:  cfg,
+		Next: next,
+	}
+}
+
+func (lv *LexiconValidatingCallbacks) EventHandler(ctx context.Context, xev *XRPCStreamEvent) error {
+	if evt := xev.RepoCommit; evt != nil {
+		invalid, err := lv.validateCommit(evt)
+		if err != nil {
+			log.Warnw("failed to validate commit records", "repo", evt.Repo, "seq", evt.Seq, "err", err)
+		}
+		xev.InvalidRecords = invalid
+		if len(invalid) > 0 && lv.cfg.Policy == InvalidRecordDrop {
+			dropInvalidOps(evt, invalid)
+		}
+	}
+	return lv.Next(ctx, xev)
+}
+
+// validateCommit returns the records in a commit which fail validation, in op
+// order. An error means the commit's blocks couldn't be read at all.
+func (lv *LexiconValidatingCallbacks) validateCommit(evt *comatproto.SyncSubscribeRepos_Commit) ([]InvalidRecord, error) {
+	var ops []*comatproto.SyncSubscribeRepos_RepoOp
+	// only the blocks of records which will actually be validated are kept
+	want := map[cid.Cid]bool{}
+	for _, op := range evt.Ops {
+		ek := repomgr.EventKind(op.Action)
+		if (ek != repomgr.EvtKindCreateRecord && ek != repomgr.EvtKindUpdateRecord) || op.Cid == nil {
+			continue
+		}
+		collection, _, _ := strings.Cut(op.Path, "/")
+		if _, err := lv.cfg.Catalog.Resolve(collection); err != nil {
+			continue
+		}
+		ops = append(ops, op)
+		want[cid.Cid(*op.Cid)] = true
+	}
+	if len(ops) == 0 || evt.TooBig {
+		return nil, nil
+	}
+
+	blocks, err := readWantedBlocks(evt.Blocks, want)
+	if err != nil {
+		return nil, err
+	}
+
+	var invalid []InvalidRecord
+	for _, op := range ops {
+		collection, _, _ := strings.Cut(op.Path, "/")
+		validatedRecordsCounter.WithLabelValues(lv.cfg.Host, collection).Inc()
+
+		c := cid.Cid(*op.Cid)
+		err := lv.validateRecord(collection, blocks[c])
+		if err == nil {
+			continue
+		}
+		invalidRecordsCounter.WithLabelValues(lv.cfg.Host, collection).Inc()
+		invalid = append(invalid, InvalidRecord{Path: op.Path, Cid: c, Err: err})
+	}
+	return invalid, nil
+}
+
+func (lv *LexiconValidatingCallbacks) validateRecord(collection string, blk []byte) error {
+	if blk == nil {
+		return fmt.Errorf("record block missing from commit")
+	}
+	rec, err := data.UnmarshalCBOR(blk)
+	if err != nil {
+		return fmt.Errorf("decoding record: %w", err)
+	}
+	_, err = lexicon.ValidateRecord(lv.cfg.Catalog, rec, collection, lv.cfg.Mode)
+	return err
+}
+
+// readWantedBlocks reads the blocks with the given CIDs out of a commit's CAR
+// slice
+func readWantedBlocks(carBytes []byte, want map[cid.Cid]bool) (map[cid.Cid][]byte, error) {
+	br, err := car.NewBlockReader(bytes.NewReader(carBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading commit blocks: %w", err)
+	}
+	blocks := make(map[cid.Cid][]byte, len(want))
+	for len(blocks) < len(want) {
+		blk, err := br.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("reading commit blocks: %w", err)
+		}
+		if want[blk.Cid()] {
+			blocks[blk.Cid()] = blk.RawData()
+		}
+	}
+	return blocks, nil
+}
+
+func dropInvalidOps(evt *comatproto.SyncSubscribeRepos_Commit, invalid []InvalidRecord) {
+	drop := make(map[string]bool, len(invalid))
+	for _, ir := range invalid {
+		drop[ir.Path] = true
+	}
+	ops := evt.Ops[:0:0]
+	for _, op := range evt.Ops {
+		if !drop[op.Path] {
+			ops = append(ops, op)
+		}
+	}
+	evt.Ops = ops
+}
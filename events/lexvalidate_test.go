@@ -0,0 +1,128 @@
+package events_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	atproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/bluesky-social/indigo/atproto/lexicon"
+	"github.com/bluesky-social/indigo/carstore"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	car "github.com/ipld/go-car"
+)
+
+const testNoteLexicon = `{
+  "lexicon": 1,
+  "id": "example.lexicon.note",
+  "defs": {
+    "main": {
+      "type": "record",
+      "key": "tid",
+      "record": {
+        "type": "object",
+        "required": ["text"],
+        "properties": {
+          "text": {"type": "string", "maxLength": 20}
+        }
+      }
+    }
+  }
+}`
+
+func testCommit(t *testing.T, recs map[string]map[string]any) *atproto.SyncSubscribeRepos_Commit {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	hb, err := cbor.DumpObject(&car.CarHeader{Roots: []cid.Cid{}, Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := carstore.LdWrite(buf, hb); err != nil {
+		t.Fatal(err)
+	}
+
+	evt := &atproto.SyncSubscribeRepos_Commit{Repo: "did:plc:abc", Seq: 1}
+	for _, path := range []string{"example.lexicon.note/1", "example.lexicon.note/2", "example.other.thing/3"} {
+		rec, ok := recs[path]
+		if !ok {
+			continue
+		}
+		b, err := data.MarshalCBOR(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := data.ComputeCID(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := carstore.LdWrite(buf, c.Bytes(), b); err != nil {
+			t.Fatal(err)
+		}
+		ll := lexutil.LexLink(c)
+		evt.Ops = append(evt.Ops, &atproto.SyncSubscribeRepos_RepoOp{Action: "create", Path: path, Cid: &ll})
+	}
+	evt.Ops = append(evt.Ops, &atproto.SyncSubscribeRepos_RepoOp{Action: "delete", Path: "example.lexicon.note/4"})
+	evt.Blocks = buf.Bytes()
+	return evt
+}
+
+func TestLexiconValidatingCallbacks(t *testing.T) {
+	var sf lexicon.SchemaFile
+	if err := json.Unmarshal([]byte(testNoteLexicon), &sf); err != nil {
+		t.Fatal(err)
+	}
+	cat := lexicon.NewBaseCatalog()
+	if err := cat.AddSchemaFile(sf); err != nil {
+		t.Fatal(err)
+	}
+
+	recs := map[string]map[string]any{
+		"example.lexicon.note/1": {"$type": "example.lexicon.note", "text": "hello"},
+		"example.lexicon.note/2": {"$type": "example.lexicon.note", "text": "this text is much too long for the schema"},
+		// not in the catalog, so not validated
+		"example.other.thing/3": {"$type": "example.other.thing"},
+	}
+
+	for _, policy := range []events.InvalidRecordPolicy{events.InvalidRecordAnnotate, events.InvalidRecordDrop} {
+		var got *events.XRPCStreamEvent
+		lv := events.NewLexiconValidatingCallbacks(events.LexiconValidatorConfig{
+			Catalog: &cat,
+			Mode:    lexicon.LenientMode,
+			Policy:  policy,
+			Host:    "test",
+		}, func(ctx context.Context, xev *events.XRPCStreamEvent) error {
+			got = xev
+			return nil
+		})
+
+		xev := &events.XRPCStreamEvent{RepoCommit: testCommit(t, recs)}
+		if err := lv.EventHandler(context.Background(), xev); err != nil {
+			t.Fatal(err)
+		}
+		if got == nil {
+			t.Fatal("event not passed on")
+		}
+		if len(got.InvalidRecords) != 1 || got.InvalidRecords[0].Path != "example.lexicon.note/2" {
+			t.Fatalf("unexpected invalid records: %v", got.InvalidRecords)
+		}
+
+		wantOps := 4
+		if policy == events.InvalidRecordDrop {
+			wantOps = 3
+		}
+		if len(got.RepoCommit.Ops) != wantOps {
+			t.Fatalf("policy %d: expected %d ops, got %d", policy, wantOps, len(got.RepoCommit.Ops))
+		}
+		for _, op := range got.RepoCommit.Ops {
+			if policy == events.InvalidRecordDrop && op.Path == "example.lexicon.note/2" {
+				t.Fatal("invalid op wasn't dropped")
+			}
+		}
+	}
+}
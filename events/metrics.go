@@ -24,3 +24,18 @@ var eventsBroadcast = promauto.NewCounterVec(prometheus.CounterOpts{
 	Name: "indigo_events_broadcast_total",
 	Help: "Total number of events broadcast to subscribers",
 }, []string{"pool"})
+
+var eventsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_events_dropped_total",
+	Help: "Total number of events dropped because a subscriber's buffer was full",
+}, []string{"pool"})
+
+var validatedRecordsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_events_lexicon_validated_records_total",
+	Help: "Total number of records in commit events validated against lexicons",
+}, []string{"host", "collection"})
+
+var invalidRecordsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_events_lexicon_invalid_records_total",
+	Help: "Total number of records in commit events which failed lexicon validation",
+}, []string{"host", "collection"})
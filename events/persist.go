@@ -39,6 +39,8 @@ func (mp *MemPersister) Persist(ctx context.Context, e *XRPCStreamEvent) error {
 	defer mp.lk.Unlock()
 	mp.seq++
 	switch {
+	case e.RepoAccount != nil:
+		e.RepoAccount.Seq = mp.seq
 	case e.RepoCommit != nil:
 		e.RepoCommit.Seq = mp.seq
 	case e.RepoHandle != nil:
@@ -2,6 +2,7 @@ package autoscaling
 
 import (
 	"context"
+	"math"
 	"sync"
 	"time"
 
@@ -13,9 +14,12 @@ import (
 
 var log = logging.Logger("autoscaling-scheduler")
 
-// Scheduler is a scheduler that will scale up and down the number of workers based on the throughput of the workers.
+// Scheduler is a scheduler that will scale up and down the number of workers
+// based on the rate items arrive at, how long they take to process, and how
+// long they wait for a worker.
 type Scheduler struct {
 	concurrency    int
+	minConcurrency int
 	maxConcurrency int
 
 	do func(context.Context, *events.XRPCStreamEvent) error
@@ -34,47 +38,79 @@ type Scheduler struct {
 	itemsProcessed prometheus.Counter
 	itemsActive    prometheus.Counter
 	workersActive  prometheus.Gauge
+	itemsPending   prometheus.Gauge
+	schedDelay     prometheus.Observer
 
 	// autoscaling
 	throughputManager  *ThroughputManager
+	bucketDuration     time.Duration
 	autoscaleFrequency time.Duration
+	targetDelay        time.Duration
 	autoscalerIn       chan struct{}
 	autoscalerOut      chan struct{}
+
+	// collected by workers since the last autoscaling decision
+	statsLk  sync.Mutex
+	waited   int
+	waitSum  time.Duration
+	finished int
+	procSum  time.Duration
+
+	// moving average of the time taken to process an item, in seconds; only
+	// used by the autoscaler goroutine
+	latency float64
 }
 
 type AutoscaleSettings struct {
 	Concurrency                 int
+	MinConcurrency              int
 	MaxConcurrency              int
 	AutoscaleFrequency          time.Duration
 	ThroughputBucketCount       int
 	ThroughputBucketDuration    time.Duration
 	MaximumBufferedItemsPerRepo int
+	TargetSchedulingDelay       time.Duration
 }
 
 // DefaultAutoscaleSettings returns the default autoscale settings.
 // Concurrency is the number of workers to start with.
-// MaxConcurrency is the maximum number of workers to scale up to.
-// AutoscaleFrequency is the frequency to check the average throughput.
+// MinConcurrency and MaxConcurrency bound the number of workers.
+// AutoscaleFrequency is how often to make a scaling decision.
 // ThroughputBucketCount is the number of buckets to use to calculate the average throughput.
 // ThroughputBucketDuration is the duration of each bucket.
+// TargetSchedulingDelay is how long an item may wait for a free worker before we scale up faster.
 // By default we check the average throughput over the last 60 seconds with 1 second buckets
 // We make an autoscaling decision every 5 seconds.
-// We start with 1 worker and scale up to 32 workers.
+// We start with 1 worker and scale between 1 and 32 workers.
 func DefaultAutoscaleSettings() AutoscaleSettings {
 	return AutoscaleSettings{
 		Concurrency:                 1,
+		MinConcurrency:              1,
 		MaxConcurrency:              32,
 		AutoscaleFrequency:          5 * time.Second,
 		ThroughputBucketCount:       60,
 		ThroughputBucketDuration:    time.Second,
 		MaximumBufferedItemsPerRepo: 100,
+		TargetSchedulingDelay:       500 * time.Millisecond,
 	}
 }
 
+// workers are sized for this multiple of the estimated load, so ordinary
+// bursts don't immediately queue up
+const scalingHeadroom = 1.25
+
 func NewScheduler(autoscaleSettings AutoscaleSettings, ident string, do func(context.Context, *events.XRPCStreamEvent) error) *Scheduler {
+	minConcurrency := max(1, autoscaleSettings.MinConcurrency)
+	maxConcurrency := max(minConcurrency, autoscaleSettings.MaxConcurrency)
+	targetDelay := autoscaleSettings.TargetSchedulingDelay
+	if targetDelay <= 0 {
+		targetDelay = DefaultAutoscaleSettings().TargetSchedulingDelay
+	}
+
 	p := &Scheduler{
-		concurrency:    autoscaleSettings.Concurrency,
-		maxConcurrency: autoscaleSettings.MaxConcurrency,
+		concurrency:    min(max(autoscaleSettings.Concurrency, minConcurrency), maxConcurrency),
+		minConcurrency: minConcurrency,
+		maxConcurrency: maxConcurrency,
 
 		do: do,
 
@@ -89,6 +125,8 @@ func NewScheduler(autoscaleSettings AutoscaleSettings, ident string, do func(con
 		itemsProcessed: schedulers.WorkItemsProcessed.WithLabelValues(ident, "autoscaling"),
 		itemsActive:    schedulers.WorkItemsActive.WithLabelValues(ident, "autoscaling"),
 		workersActive:  schedulers.WorkersActive.WithLabelValues(ident, "autoscaling"),
+		itemsPending:   schedulers.WorkItemsPending.WithLabelValues(ident, "autoscaling"),
+		schedDelay:     schedulers.SchedulingDelay.WithLabelValues(ident, "autoscaling"),
 
 		// autoscaling
 		// By default, the ThroughputManager will calculate the average throughput over the last 60 seconds.
@@ -96,7 +134,9 @@ func NewScheduler(autoscaleSettings AutoscaleSettings, ident string, do func(con
 			autoscaleSettings.ThroughputBucketCount,
 			autoscaleSettings.ThroughputBucketDuration,
 		),
+		bucketDuration:     autoscaleSettings.ThroughputBucketDuration,
 		autoscaleFrequency: autoscaleSettings.AutoscaleFrequency,
+		targetDelay:        targetDelay,
 		autoscalerIn:       make(chan struct{}),
 		autoscalerOut:      make(chan struct{}),
 	}
@@ -147,15 +187,60 @@ func (p *Scheduler) autoscale() {
 			close(p.autoscalerOut)
 			return
 		case <-tick.C:
-			avg := p.throughputManager.AvgThroughput()
-			if avg > float64(p.concurrency) && p.concurrency < p.maxConcurrency {
-				p.concurrency++
-				go p.worker()
-			} else if avg < float64(p.concurrency-1) && p.concurrency > 1 {
-				p.concurrency--
-				p.feeder <- &consumerTask{signal: "stop"}
-			}
+			p.rescale()
+		}
+	}
+}
+
+// rescale adjusts the number of workers. The estimate of how many are needed
+// is the arrival rate times the time each item takes to process (Little's
+// law), plus some headroom. Items waiting longer than the target for a free
+// worker mean the estimate is behind (eg, processing just got slower), so
+// then we also scale up by a quarter regardless. Scaling down is one worker
+// at a time, and only while nothing is waiting.
+func (p *Scheduler) rescale() {
+	p.statsLk.Lock()
+	waited, waitSum := p.waited, p.waitSum
+	finished, procSum := p.finished, p.procSum
+	p.waited, p.waitSum, p.finished, p.procSum = 0, 0, 0, 0
+	p.statsLk.Unlock()
+
+	if finished > 0 {
+		lat := procSum.Seconds() / float64(finished)
+		if p.latency == 0 {
+			p.latency = lat
+		} else {
+			p.latency = 0.7*p.latency + 0.3*lat
+		}
+	}
+
+	var delay time.Duration
+	if waited > 0 {
+		delay = waitSum / time.Duration(waited)
+	}
+
+	rate := p.throughputManager.AvgThroughput()
+	if p.bucketDuration > 0 {
+		rate /= p.bucketDuration.Seconds()
+	}
+	target := int(math.Ceil(rate * p.latency * scalingHeadroom))
+
+	behind := delay > p.targetDelay
+	if behind {
+		target = max(target, p.concurrency+max(1, p.concurrency/4))
+	}
+	target = min(max(target, p.minConcurrency), p.maxConcurrency)
+
+	switch {
+	case target > p.concurrency:
+		log.Debugf("scaling up %s from %d to %d workers (rate=%.1f/s latency=%.3fs delay=%s)", p.ident, p.concurrency, target, rate, p.latency, delay)
+		for p.concurrency < target {
+			p.concurrency++
+			go p.worker()
 		}
+	case target < p.concurrency && delay < p.targetDelay/2:
+		p.concurrency--
+		p.feeder <- &consumerTask{signal: "stop"}
 	}
 }
 
@@ -164,14 +249,17 @@ type consumerTask struct {
 	val    *events.XRPCStreamEvent
 	signal string
 	wg     *sync.WaitGroup
+	added  time.Time
 }
 
 func (p *Scheduler) AddWork(ctx context.Context, repo string, val *events.XRPCStreamEvent) error {
 	p.itemsAdded.Inc()
 	p.throughputManager.Add(1)
+	p.itemsPending.Inc()
 	t := &consumerTask{
-		repo: repo,
-		val:  val,
+		repo:  repo,
+		val:   val,
+		added: time.Now(),
 	}
 	p.lk.Lock()
 
@@ -202,6 +290,10 @@ func (p *Scheduler) worker() {
 	p.workerGroup.Add(1)
 	defer p.workerGroup.Done()
 	for work := range p.feeder {
+		// only items handed over by the feeder were waiting on a free
+		// worker; later items for the same repo also wait behind the
+		// earlier ones, which more workers wouldn't help with
+		fromFeeder := true
 		for work != nil {
 			// Check if the work item contains a signal to stop the worker.
 			if work.signal == "stop" {
@@ -210,12 +302,27 @@ func (p *Scheduler) worker() {
 				return
 			}
 
+			start := time.Now()
+			wait := start.Sub(work.added)
+			p.schedDelay.Observe(wait.Seconds())
+			p.itemsPending.Dec()
+
 			p.itemsActive.Inc()
 			if err := p.do(context.TODO(), work.val); err != nil {
 				log.Errorf("event handler failed: %s", err)
 			}
 			p.itemsProcessed.Inc()
 
+			p.statsLk.Lock()
+			if fromFeeder {
+				p.waited++
+				p.waitSum += wait
+				fromFeeder = false
+			}
+			p.finished++
+			p.procSum += time.Since(start)
+			p.statsLk.Unlock()
+
 			p.lk.Lock()
 			rem, ok := p.active[work.repo]
 			if !ok {
@@ -24,3 +24,14 @@ var WorkersActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
 	Name: "indigo_scheduler_workers_active",
 	Help: "Number of workers currently active",
 }, []string{"pool", "scheduler_type"})
+
+var WorkItemsPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "indigo_scheduler_work_items_pending",
+	Help: "Number of work items added but not yet picked up by a worker",
+}, []string{"pool", "scheduler_type"})
+
+var SchedulingDelay = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "indigo_scheduler_scheduling_delay_seconds",
+	Help:    "Time work items waited between being added and a worker starting on them",
+	Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+}, []string{"pool", "scheduler_type"})
@@ -25,6 +25,8 @@ func (yp *YoloPersister) Persist(ctx context.Context, e *XRPCStreamEvent) error
 	defer yp.lk.Unlock()
 	yp.seq++
 	switch {
+	case e.RepoAccount != nil:
+		e.RepoAccount.Seq = yp.seq
 	case e.RepoCommit != nil:
 		e.RepoCommit.Seq = yp.seq
 	case e.RepoHandle != nil:
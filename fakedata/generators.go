@@ -1,5 +1,5 @@
 // Helpers for randomly generated app.bsky.* content: accounts, posts, likes,
-// follows, mentions, etc
+// follows, mentions, etc. Generation is reproducible with SetSeed.
 
 package fakedata
 
@@ -7,11 +7,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"math/rand"
 	"time"
 
+	"github.com/bluesky-social/indigo/api/agnostic"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/xrpc"
 
@@ -42,13 +43,14 @@ func GenAccount(xrpcc *xrpc.Client, index int, accountType, domainSuffix string,
 	} else {
 		handleSuffix = ""
 	}
-	prefix := gofakeit.Username()
+	f := faker("account", accountType, index)
+	prefix := f.Username()
 	if len(prefix) > 10 {
 		prefix = prefix[0:10]
 	}
 	handle := fmt.Sprintf("%s-%s%d.%s", prefix, handleSuffix, index, domainSuffix)
-	email := gofakeit.Email()
-	password := gofakeit.Password(true, true, true, true, true, 24)
+	email := f.Email()
+	password := f.Password(true, true, true, true, true, 24)
 	ctx := context.TODO()
 	resp, err := comatproto.ServerCreateAccount(ctx, xrpcc, &comatproto.ServerCreateAccount_Input{
 		Email:      email,
@@ -76,17 +78,18 @@ func GenAccount(xrpcc *xrpc.Client, index int, accountType, domainSuffix string,
 
 func GenProfile(xrpcc *xrpc.Client, acc *AccountContext, genAvatar, genBanner bool) error {
 
-	desc := gofakeit.HipsterSentence(12)
+	f := accountFaker("profile", acc)
+	desc := f.HipsterSentence(12)
 	var name string
 	if acc.AccountType == "celebrity" {
-		name = gofakeit.CelebrityActor()
+		name = f.CelebrityActor()
 	} else {
-		name = gofakeit.Name()
+		name = f.Name()
 	}
 
 	var avatar *lexutil.LexBlob
 	if genAvatar {
-		img := gofakeit.ImagePng(200, 200)
+		img := f.ImagePng(200, 200)
 		resp, err := comatproto.RepoUploadBlob(context.TODO(), xrpcc, bytes.NewReader(img))
 		if err != nil {
 			return err
@@ -99,7 +102,7 @@ func GenProfile(xrpcc *xrpc.Client, acc *AccountContext, genAvatar, genBanner bo
 	}
 	var banner *lexutil.LexBlob
 	if genBanner {
-		img := gofakeit.ImageJpeg(800, 200)
+		img := f.ImageJpeg(800, 200)
 		resp, err := comatproto.RepoUploadBlob(context.TODO(), xrpcc, bytes.NewReader(img))
 		if err != nil {
 			return err
@@ -125,17 +128,20 @@ func GenProfile(xrpcc *xrpc.Client, acc *AccountContext, genAvatar, genBanner bo
 	return err
 }
 
-func GenPosts(xrpcc *xrpc.Client, catalog *AccountCatalog, acc *AccountContext, maxPosts int, fracImage float64, fracMention float64) error {
+// GenPosts creates up to maxPosts posts for an account, with the given
+// fractions of posts having an image or video, mentioning another account,
+// or restricting replies with a threadgate.
+func GenPosts(xrpcc *xrpc.Client, catalog *AccountCatalog, acc *AccountContext, maxPosts int, fracImage, fracVideo, fracMention, fracThreadgate float64) error {
 
-	var mention *appbsky.FeedPost_Entity
 	var tgt *AccountContext
 	var text string
 	ctx := context.TODO()
+	f := accountFaker("posts", acc)
 
 	if maxPosts < 1 {
 		return nil
 	}
-	count := rand.Intn(maxPosts)
+	count := f.Rand.Intn(maxPosts)
 
 	// celebrities make 2x the posts
 	if acc.AccountType == "celebrity" {
@@ -143,73 +149,145 @@ func GenPosts(xrpcc *xrpc.Client, catalog *AccountCatalog, acc *AccountContext,
 	}
 	t1 := MeasureIterations("generate posts")
 	for i := 0; i < count; i++ {
-		text = gofakeit.Sentence(10)
+		text = f.Sentence(10)
 		if len(text) > 200 {
 			text = text[0:200]
 		}
 
 		// half the time, mention a celeb
 		tgt = nil
-		mention = nil
-		if fracMention > 0.0 && rand.Float64() < fracMention/2 {
-			tgt = &catalog.Regulars[rand.Intn(len(catalog.Regulars))]
-		} else if fracMention > 0.0 && rand.Float64() < fracMention/2 {
-			tgt = &catalog.Celebs[rand.Intn(len(catalog.Celebs))]
+		var facets []*appbsky.RichtextFacet
+		if fracMention > 0.0 && f.Rand.Float64() < fracMention/2 {
+			tgt = &catalog.Regulars[f.Rand.Intn(len(catalog.Regulars))]
+		} else if fracMention > 0.0 && f.Rand.Float64() < fracMention/2 {
+			tgt = &catalog.Celebs[f.Rand.Intn(len(catalog.Celebs))]
 		}
 		if tgt != nil {
 			text = "@" + tgt.Auth.Handle + " " + text
-			mention = &appbsky.FeedPost_Entity{
-				Type:  "mention",
-				Value: tgt.Auth.Did,
-				Index: &appbsky.FeedPost_TextSlice{
-					Start: 0,
-					End:   int64(len(tgt.Auth.Handle) + 1),
-				},
-			}
+			facets = append(facets, mentionFacet(tgt, 0))
 		}
 
-		var images []*appbsky.EmbedImages_Image
-		if fracImage > 0.0 && rand.Float64() < fracImage {
-			img := gofakeit.ImageJpeg(800, 800)
-			resp, err := comatproto.RepoUploadBlob(context.TODO(), xrpcc, bytes.NewReader(img))
+		var uri string
+		if fracVideo > 0.0 && f.Rand.Float64() < fracVideo {
+			out, err := createVideoPost(ctx, xrpcc, f, text, facets)
 			if err != nil {
 				return err
 			}
-			images = append(images, &appbsky.EmbedImages_Image{
-				Alt: gofakeit.Lunch(),
-				Image: &lexutil.LexBlob{
-					Ref:      resp.Blob.Ref,
-					MimeType: "image/jpeg",
-					Size:     resp.Blob.Size,
-				},
+			uri = out.Uri
+		} else {
+			var images []*appbsky.EmbedImages_Image
+			if fracImage > 0.0 && f.Rand.Float64() < fracImage {
+				img := f.ImageJpeg(800, 800)
+				resp, err := comatproto.RepoUploadBlob(context.TODO(), xrpcc, bytes.NewReader(img))
+				if err != nil {
+					return err
+				}
+				images = append(images, &appbsky.EmbedImages_Image{
+					Alt: f.Lunch(),
+					Image: &lexutil.LexBlob{
+						Ref:      resp.Blob.Ref,
+						MimeType: "image/jpeg",
+						Size:     resp.Blob.Size,
+					},
+				})
+			}
+			post := appbsky.FeedPost{
+				Text:      text,
+				CreatedAt: time.Now().Format(time.RFC3339),
+				Facets:    facets,
+			}
+			if len(images) > 0 {
+				post.Embed = &appbsky.FeedPost_Embed{
+					EmbedImages: &appbsky.EmbedImages{
+						Images: images,
+					},
+				}
+			}
+			out, err := comatproto.RepoCreateRecord(ctx, xrpcc, &comatproto.RepoCreateRecord_Input{
+				Collection: "app.bsky.feed.post",
+				Repo:       acc.Auth.Did,
+				Record:     &lexutil.LexiconTypeDecoder{&post},
 			})
-		}
-		post := appbsky.FeedPost{
-			Text:      text,
-			CreatedAt: time.Now().Format(time.RFC3339),
-		}
-		if mention != nil {
-			post.Entities = []*appbsky.FeedPost_Entity{mention}
-		}
-		if len(images) > 0 {
-			post.Embed = &appbsky.FeedPost_Embed{
-				EmbedImages: &appbsky.EmbedImages{
-					Images: images,
-				},
+			if err != nil {
+				return err
 			}
+			uri = out.Uri
 		}
-		if _, err := comatproto.RepoCreateRecord(ctx, xrpcc, &comatproto.RepoCreateRecord_Input{
-			Collection: "app.bsky.feed.post",
-			Repo:       acc.Auth.Did,
-			Record:     &lexutil.LexiconTypeDecoder{&post},
-		}); err != nil {
-			return err
+
+		if fracThreadgate > 0.0 && f.Rand.Float64() < fracThreadgate {
+			if err := createThreadgate(ctx, xrpcc, f, uri); err != nil {
+				return err
+			}
 		}
 	}
 	t1(count)
 	return nil
 }
 
+// mentionFacet links the "@handle" at byte offset start of a post's text
+func mentionFacet(tgt *AccountContext, start int64) *appbsky.RichtextFacet {
+	return &appbsky.RichtextFacet{
+		Index: &appbsky.RichtextFacet_ByteSlice{
+			ByteStart: start,
+			ByteEnd:   start + int64(len(tgt.Auth.Handle)+1),
+		},
+		Features: []*appbsky.RichtextFacet_Features_Elem{{
+			RichtextFacet_Mention: &appbsky.RichtextFacet_Mention{
+				Did: tgt.Auth.Did,
+			},
+		}},
+	}
+}
+
+func createVideoPost(ctx context.Context, xrpcc *xrpc.Client, f *gofakeit.Faker, text string, facets []*appbsky.RichtextFacet) (*agnostic.RepoCreateRecord_Output, error) {
+	// 64-256 KB; real videos are bigger, but this keeps generation fast
+	video, err := uploadBlob(ctx, xrpcc, "video/mp4", fakeVideo(f, (64+f.Rand.Intn(192))*1024))
+	if err != nil {
+		return nil, err
+	}
+	ratios := []aspectRatio{{16, 9}, {9, 16}, {1, 1}, {4, 3}}
+	ratio := ratios[f.Rand.Intn(len(ratios))]
+	post := videoPost{
+		LexiconTypeID: "app.bsky.feed.post",
+		CreatedAt:     time.Now().Format(time.RFC3339),
+		Text:          text,
+		Facets:        facets,
+		Embed: &embedVideo{
+			LexiconTypeID: "app.bsky.embed.video",
+			Video:         video,
+			Alt:           f.HipsterSentence(6),
+			AspectRatio:   &ratio,
+		},
+	}
+	return createRecordJSON(ctx, xrpcc, "app.bsky.feed.post", "", &post)
+}
+
+// createThreadgate limits replies to a post: to mentioned accounts, to
+// accounts the author follows, both, or nobody
+func createThreadgate(ctx context.Context, xrpcc *xrpc.Client, f *gofakeit.Faker, postURI string) error {
+	aturi, err := syntax.ParseATURI(postURI)
+	if err != nil {
+		return err
+	}
+	mention := threadgateRule{LexiconTypeID: "app.bsky.feed.threadgate#mentionRule"}
+	following := threadgateRule{LexiconTypeID: "app.bsky.feed.threadgate#followingRule"}
+	choices := [][]threadgateRule{
+		{mention},
+		{following},
+		{mention, following},
+		{},
+	}
+	gate := threadgate{
+		LexiconTypeID: "app.bsky.feed.threadgate",
+		Post:          postURI,
+		Allow:         choices[f.Rand.Intn(len(choices))],
+		CreatedAt:     time.Now().Format(time.RFC3339),
+	}
+	// threadgates share the record key of the post they apply to
+	_, err = createRecordJSON(ctx, xrpcc, "app.bsky.feed.threadgate", aturi.RecordKey().String(), &gate)
+	return err
+}
+
 func CreateFollow(xrpcc *xrpc.Client, tgt *AccountContext) error {
 	follow := &appbsky.GraphFollow{
 		CreatedAt: time.Now().Format(time.RFC3339),
@@ -258,7 +336,11 @@ func CreateRepost(xrpcc *xrpc.Client, viewPost *appbsky.FeedDefs_FeedViewPost) e
 }
 
 func CreateReply(xrpcc *xrpc.Client, viewPost *appbsky.FeedDefs_FeedViewPost) error {
-	text := gofakeit.Sentence(10)
+	return createReply(xrpcc, faker("reply", viewPost.Post.Uri, 0), viewPost)
+}
+
+func createReply(xrpcc *xrpc.Client, f *gofakeit.Faker, viewPost *appbsky.FeedDefs_FeedViewPost) error {
+	text := f.Sentence(10)
 	if len(text) > 200 {
 		text = text[0:200]
 	}
@@ -301,14 +383,15 @@ func GenFollowsAndMutes(xrpcc *xrpc.Client, catalog *AccountCatalog, acc *Accoun
 		return fmt.Errorf("not enought regulars to pick maxMutes from")
 	}
 
+	f := accountFaker("graph", acc)
 	regCount := 0
 	celebCount := 0
 	if maxFollows >= 1 {
-		regCount = rand.Intn(maxFollows)
-		celebCount = rand.Intn(len(catalog.Celebs))
+		regCount = f.Rand.Intn(maxFollows)
+		celebCount = f.Rand.Intn(len(catalog.Celebs))
 	}
 	t1 := MeasureIterations("generate follows")
-	for idx := range rand.Perm(len(catalog.Celebs))[:celebCount] {
+	for _, idx := range f.Rand.Perm(len(catalog.Celebs))[:celebCount] {
 		tgt = &catalog.Celebs[idx]
 		if tgt.Auth.Did == acc.Auth.Did {
 			continue
@@ -317,7 +400,7 @@ func GenFollowsAndMutes(xrpcc *xrpc.Client, catalog *AccountCatalog, acc *Accoun
 			return err
 		}
 	}
-	for idx := range rand.Perm(len(catalog.Regulars))[:regCount] {
+	for _, idx := range f.Rand.Perm(len(catalog.Regulars))[:regCount] {
 		tgt = &catalog.Regulars[idx]
 		if tgt.Auth.Did == acc.Auth.Did {
 			continue
@@ -331,10 +414,10 @@ func GenFollowsAndMutes(xrpcc *xrpc.Client, catalog *AccountCatalog, acc *Accoun
 	// only muting other users, not celebs
 	muteCount := 0
 	if maxFollows >= 1 && maxMutes > 0 {
-		muteCount = rand.Intn(maxMutes)
+		muteCount = f.Rand.Intn(maxMutes)
 	}
 	t2 := MeasureIterations("generate mutes")
-	for idx := range rand.Perm(len(catalog.Regulars))[:muteCount] {
+	for _, idx := range f.Rand.Perm(len(catalog.Regulars))[:muteCount] {
 		tgt = &catalog.Regulars[idx]
 		if tgt.Auth.Did == acc.Auth.Did {
 			continue
@@ -357,6 +440,7 @@ func GenLikesRepostsReplies(xrpcc *xrpc.Client, acc *AccountContext, fracLike, f
 	if len(resp.Feed) > maxTimeline {
 		return fmt.Errorf("got too long timeline len=%d", len(resp.Feed))
 	}
+	f := accountFaker("interactions", acc)
 	for _, post := range resp.Feed {
 		// skip account's own posts
 		if post.Post.Author.Did == acc.Auth.Did {
@@ -364,18 +448,18 @@ func GenLikesRepostsReplies(xrpcc *xrpc.Client, acc *AccountContext, fracLike, f
 		}
 
 		// generate
-		if fracLike > 0.0 && rand.Float64() < fracLike {
+		if fracLike > 0.0 && f.Rand.Float64() < fracLike {
 			if err := CreateLike(xrpcc, post); err != nil {
 				return err
 			}
 		}
-		if fracRepost > 0.0 && rand.Float64() < fracRepost {
+		if fracRepost > 0.0 && f.Rand.Float64() < fracRepost {
 			if err := CreateRepost(xrpcc, post); err != nil {
 				return err
 			}
 		}
-		if fracReply > 0.0 && rand.Float64() < fracReply {
-			if err := CreateReply(xrpcc, post); err != nil {
+		if fracReply > 0.0 && f.Rand.Float64() < fracReply {
+			if err := createReply(xrpcc, f, post); err != nil {
 				return err
 			}
 		}
@@ -430,6 +514,7 @@ func BrowseAccount(xrpcc *xrpc.Client, acc *AccountContext) error {
 	if len(timelineResp.Feed) > timelineLen {
 		return fmt.Errorf("longer than expected timeline len=%d", len(timelineResp.Feed))
 	}
+	f := accountFaker("browse", acc)
 	t2 := MeasureIterations("timeline interactions")
 	for _, post := range timelineResp.Feed {
 		// skip account's own posts
@@ -437,12 +522,12 @@ func BrowseAccount(xrpcc *xrpc.Client, acc *AccountContext) error {
 			continue
 		}
 		// TODO: should we do something different here?
-		if rand.Float64() < 0.25 {
+		if f.Rand.Float64() < 0.25 {
 			_, err = appbsky.FeedGetPostThread(context.TODO(), xrpcc, 4, 80, post.Post.Uri)
 			if err != nil {
 				return err
 			}
-		} else if rand.Float64() < 0.25 {
+		} else if f.Rand.Float64() < 0.25 {
 			_, err = appbsky.ActorGetProfile(context.TODO(), xrpcc, post.Post.Author.Did)
 			if err != nil {
 				return err
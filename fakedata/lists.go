@@ -0,0 +1,178 @@
+package fakedata
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/brianvoe/gofakeit/v6"
+)
+
+const (
+	ListPurposeCurate    = "app.bsky.graph.defs#curatelist"
+	ListPurposeMod       = "app.bsky.graph.defs#modlist"
+	ListPurposeReference = "app.bsky.graph.defs#referencelist"
+)
+
+// createList creates a list record with up to maxSize members picked from the
+// catalog, returning the list's at-uri
+func createList(ctx context.Context, xrpcc *xrpc.Client, f *gofakeit.Faker, catalog *AccountCatalog, acc *AccountContext, purpose string, name string, maxSize int) (string, error) {
+	desc := f.HipsterSentence(8)
+	list := appbsky.GraphList{
+		CreatedAt:   time.Now().Format(time.RFC3339),
+		Name:        name,
+		Description: &desc,
+		Purpose:     &purpose,
+	}
+	out, err := comatproto.RepoCreateRecord(ctx, xrpcc, &comatproto.RepoCreateRecord_Input{
+		Collection: "app.bsky.graph.list",
+		Repo:       acc.Auth.Did,
+		Record:     &lexutil.LexiconTypeDecoder{Val: &list},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	combined := catalog.Combined()
+	size := 0
+	if maxSize >= 1 {
+		size = 1 + f.Rand.Intn(maxSize)
+	}
+	if size > len(combined) {
+		size = len(combined)
+	}
+	for _, idx := range f.Rand.Perm(len(combined))[:size] {
+		tgt := &combined[idx]
+		if tgt.Auth.Did == acc.Auth.Did {
+			continue
+		}
+		item := appbsky.GraphListitem{
+			CreatedAt: time.Now().Format(time.RFC3339),
+			List:      out.Uri,
+			Subject:   tgt.Auth.Did,
+		}
+		if _, err := comatproto.RepoCreateRecord(ctx, xrpcc, &comatproto.RepoCreateRecord_Input{
+			Collection: "app.bsky.graph.listitem",
+			Repo:       acc.Auth.Did,
+			Record:     &lexutil.LexiconTypeDecoder{Val: &item},
+		}); err != nil {
+			return "", err
+		}
+	}
+	return out.Uri, nil
+}
+
+// GenLists creates up to maxLists curation and moderation lists for an
+// account, each with up to maxListSize members
+func GenLists(xrpcc *xrpc.Client, catalog *AccountCatalog, acc *AccountContext, maxLists, maxListSize int) error {
+	if maxLists < 1 {
+		return nil
+	}
+	ctx := context.TODO()
+	f := accountFaker("lists", acc)
+
+	count := f.Rand.Intn(maxLists + 1)
+	t1 := MeasureIterations("generate lists")
+	for i := 0; i < count; i++ {
+		// mostly curation lists; about one in five is a mod list
+		purpose := ListPurposeCurate
+		name := capitalize(f.Adjective()) + " " + f.Noun() + " people"
+		if f.Rand.Float64() < 0.2 {
+			purpose = ListPurposeMod
+			name = "Muted: " + f.BuzzWord()
+		}
+		if _, err := createList(ctx, xrpcc, f, catalog, acc, purpose, name, maxListSize); err != nil {
+			return err
+		}
+	}
+	t1(count)
+	return nil
+}
+
+// GenStarterPacks creates up to maxPacks starter packs for an account. Each
+// pack is backed by a reference list of up to packSize accounts.
+func GenStarterPacks(xrpcc *xrpc.Client, catalog *AccountCatalog, acc *AccountContext, maxPacks, packSize int) error {
+	if maxPacks < 1 {
+		return nil
+	}
+	ctx := context.TODO()
+	f := accountFaker("starterpacks", acc)
+
+	count := f.Rand.Intn(maxPacks + 1)
+	t1 := MeasureIterations("generate starter packs")
+	for i := 0; i < count; i++ {
+		name := f.Noun() + " starter pack"
+		listURI, err := createList(ctx, xrpcc, f, catalog, acc, ListPurposeReference, name, packSize)
+		if err != nil {
+			return err
+		}
+		desc := f.HipsterSentence(10)
+		pack := starterpack{
+			LexiconTypeID: "app.bsky.graph.starterpack",
+			Name:          name,
+			Description:   &desc,
+			List:          listURI,
+			CreatedAt:     time.Now().Format(time.RFC3339),
+		}
+		if _, err := createRecordJSON(ctx, xrpcc, "app.bsky.graph.starterpack", "", &pack); err != nil {
+			return err
+		}
+	}
+	t1(count)
+	return nil
+}
+
+// label values which generated labelers declare some of
+var fakeLabelValues = []string{"spoiler", "satire", "low-quality", "ai-generated", "political", "graphic-media", "off-topic"}
+
+// GenLabeler declares the account as a labeler (app.bsky.labeler.service),
+// with a few of a fixed set of custom label values. This only creates the
+// declaration record; it doesn't add the labeler service to the DID document.
+func GenLabeler(xrpcc *xrpc.Client, acc *AccountContext) error {
+	f := accountFaker("labeler", acc)
+
+	count := 2 + f.Rand.Intn(len(fakeLabelValues)-1)
+	var values []string
+	var defs []labelValueDefinition
+	for _, idx := range f.Rand.Perm(len(fakeLabelValues))[:count] {
+		val := fakeLabelValues[idx]
+		values = append(values, val)
+
+		severities := []string{"inform", "alert", "none"}
+		blurs := []string{"content", "media", "none"}
+		defs = append(defs, labelValueDefinition{
+			Identifier:     val,
+			Severity:       severities[f.Rand.Intn(len(severities))],
+			Blurs:          blurs[f.Rand.Intn(len(blurs))],
+			DefaultSetting: "warn",
+			AdultOnly:      val == "graphic-media",
+			Locales: []labelValueLocale{{
+				Lang:        "en",
+				Name:        capitalize(strings.ReplaceAll(val, "-", " ")),
+				Description: f.HipsterSentence(8),
+			}},
+		})
+	}
+
+	svc := labelerService{
+		LexiconTypeID: "app.bsky.labeler.service",
+		Policies: labelerPolicies{
+			LabelValues:           values,
+			LabelValueDefinitions: defs,
+		},
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	return putRecordJSON(context.TODO(), xrpcc, "app.bsky.labeler.service", "self", &svc)
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
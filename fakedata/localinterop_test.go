@@ -12,15 +12,19 @@ import (
 )
 
 const (
-	pdsHost       = "http://localhost:2583"
-	adminPassword = "admin"
-	celebCount    = 2
-	regularCount  = 5
-	maxPosts      = 5
-	maxFollows    = 5
-	maxMutes      = 2
-	fracImage     = 0.3
-	fracMention   = 0.3
+	pdsHost        = "http://localhost:2583"
+	adminPassword  = "admin"
+	celebCount     = 2
+	regularCount   = 5
+	maxPosts       = 5
+	maxFollows     = 5
+	maxMutes       = 2
+	fracImage      = 0.3
+	fracVideo      = 0.1
+	fracMention    = 0.3
+	fracThreadgate = 0.1
+	maxLists       = 2
+	maxListSize    = 3
 )
 
 func genTestCatalog(t *testing.T, pdsHost string) AccountCatalog {
@@ -67,8 +71,11 @@ func TestFakedataLocalInterop(t *testing.T) {
 		}
 		assert.NoError(GenProfile(xrpcc, &acc, true, true))
 		assert.NoError(GenFollowsAndMutes(xrpcc, &catalog, &acc, maxFollows, maxMutes))
-		assert.NoError(GenPosts(xrpcc, &catalog, &acc, maxPosts, fracImage, fracMention))
+		assert.NoError(GenPosts(xrpcc, &catalog, &acc, maxPosts, fracImage, fracVideo, fracMention, fracThreadgate))
+		assert.NoError(GenLists(xrpcc, &catalog, &acc, maxLists, maxListSize))
+		assert.NoError(GenStarterPacks(xrpcc, &catalog, &acc, maxLists, maxListSize))
 	}
+	assert.NoError(GenLabeler(mustClient(t, &catalog.Regulars[0]), &catalog.Regulars[0]))
 
 	// generate interactions (additional posts, etc)
 	for _, acc := range combined {
@@ -77,7 +84,7 @@ func TestFakedataLocalInterop(t *testing.T) {
 			t.Fatal(err)
 		}
 		assert.NoError(GenFollowsAndMutes(xrpcc, &catalog, &acc, maxFollows, maxMutes))
-		assert.NoError(GenPosts(xrpcc, &catalog, &acc, maxPosts, fracImage, fracMention))
+		assert.NoError(GenPosts(xrpcc, &catalog, &acc, maxPosts, fracImage, fracVideo, fracMention, fracThreadgate))
 	}
 
 	// do browsing (read-only)
@@ -89,3 +96,11 @@ func TestFakedataLocalInterop(t *testing.T) {
 		assert.NoError(BrowseAccount(xrpcc, &acc))
 	}
 }
+
+func mustClient(t *testing.T, acc *AccountContext) *xrpc.Client {
+	xrpcc, err := AccountXrpcClient(pdsHost, acc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return xrpcc
+}
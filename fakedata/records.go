@@ -0,0 +1,143 @@
+package fakedata
+
+// Newer record types (video embeds, threadgates, starter packs, labeler
+// declarations) which don't have generated types in api/bsky. They are
+// written as plain JSON, and validated by the PDS against its lexicons.
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+
+	"github.com/bluesky-social/indigo/api/agnostic"
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	appbsky "github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/brianvoe/gofakeit/v6"
+)
+
+type aspectRatio struct {
+	Width  int64 `json:"width"`
+	Height int64 `json:"height"`
+}
+
+type embedVideo struct {
+	LexiconTypeID string           `json:"$type"`
+	Video         *lexutil.LexBlob `json:"video"`
+	Alt           string           `json:"alt,omitempty"`
+	AspectRatio   *aspectRatio     `json:"aspectRatio,omitempty"`
+}
+
+// an app.bsky.feed.post with a video embed, which appbsky.FeedPost_Embed
+// can't hold
+type videoPost struct {
+	LexiconTypeID string                   `json:"$type"`
+	CreatedAt     string                   `json:"createdAt"`
+	Text          string                   `json:"text"`
+	Facets        []*appbsky.RichtextFacet `json:"facets,omitempty"`
+	Langs         []string                 `json:"langs,omitempty"`
+	Embed         *embedVideo              `json:"embed"`
+}
+
+type threadgateRule struct {
+	LexiconTypeID string `json:"$type"`
+	List          string `json:"list,omitempty"`
+}
+
+type threadgate struct {
+	LexiconTypeID string           `json:"$type"`
+	Post          string           `json:"post"`
+	Allow         []threadgateRule `json:"allow"`
+	CreatedAt     string           `json:"createdAt"`
+}
+
+type starterpack struct {
+	LexiconTypeID string  `json:"$type"`
+	Name          string  `json:"name"`
+	Description   *string `json:"description,omitempty"`
+	List          string  `json:"list"`
+	CreatedAt     string  `json:"createdAt"`
+}
+
+type labelValueLocale struct {
+	Lang        string `json:"lang"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type labelValueDefinition struct {
+	Identifier     string             `json:"identifier"`
+	Severity       string             `json:"severity"`
+	Blurs          string             `json:"blurs"`
+	DefaultSetting string             `json:"defaultSetting,omitempty"`
+	AdultOnly      bool               `json:"adultOnly,omitempty"`
+	Locales        []labelValueLocale `json:"locales"`
+}
+
+type labelerPolicies struct {
+	LabelValues           []string               `json:"labelValues"`
+	LabelValueDefinitions []labelValueDefinition `json:"labelValueDefinitions,omitempty"`
+}
+
+type labelerService struct {
+	LexiconTypeID string          `json:"$type"`
+	Policies      labelerPolicies `json:"policies"`
+	CreatedAt     string          `json:"createdAt"`
+}
+
+// createRecordJSON creates a record from a plain JSON-able value. rkey may be
+// empty, for a TID.
+func createRecordJSON(ctx context.Context, xrpcc *xrpc.Client, collection, rkey string, rec any) (*agnostic.RepoCreateRecord_Output, error) {
+	input := agnostic.RepoCreateRecord_Input{
+		Repo:       xrpcc.Auth.Did,
+		Collection: collection,
+		Record:     rec,
+	}
+	if rkey != "" {
+		input.Rkey = &rkey
+	}
+	return agnostic.RepoCreateRecord(ctx, xrpcc, &input)
+}
+
+// putRecordJSON creates or replaces a record from a plain JSON-able value
+func putRecordJSON(ctx context.Context, xrpcc *xrpc.Client, collection, rkey string, rec any) error {
+	_, err := agnostic.RepoPutRecord(ctx, xrpcc, &agnostic.RepoPutRecord_Input{
+		Repo:       xrpcc.Auth.Did,
+		Collection: collection,
+		Rkey:       rkey,
+		Record:     rec,
+	})
+	return err
+}
+
+// uploadBlob uploads with an explicit content type; the generated
+// RepoUploadBlob doesn't send one, and the PDS checks video embeds' blob type
+func uploadBlob(ctx context.Context, xrpcc *xrpc.Client, mimeType string, data []byte) (*lexutil.LexBlob, error) {
+	var out comatproto.RepoUploadBlob_Output
+	if err := xrpcc.Do(ctx, xrpc.Procedure, mimeType, "com.atproto.repo.uploadBlob", nil, bytes.NewReader(data), &out); err != nil {
+		return nil, err
+	}
+	return &lexutil.LexBlob{
+		Ref:      out.Blob.Ref,
+		MimeType: mimeType,
+		Size:     out.Blob.Size,
+	}, nil
+}
+
+// fakeVideo returns an MP4 file type header followed by random bytes: enough
+// to look like video/mp4 to content sniffing, but not playable
+func fakeVideo(f *gofakeit.Faker, size int) []byte {
+	var buf bytes.Buffer
+	ftyp := []byte("ftypisom\x00\x00\x02\x00isomiso2avc1mp41")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ftyp)+4))
+	buf.Write(ftyp)
+
+	payload := make([]byte, size)
+	f.Rand.Read(payload)
+	binary.Write(&buf, binary.BigEndian, uint32(len(payload)+8))
+	buf.WriteString("free")
+	buf.Write(payload)
+	return buf.Bytes()
+}
@@ -0,0 +1,40 @@
+package fakedata
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/brianvoe/gofakeit/v6"
+)
+
+// seed for all generation; see SetSeed
+var seed int64
+
+// SetSeed makes generated data reproducible. With the same seed, and the same
+// account catalog, every account gets the same handle, profile, and posts, and
+// makes the same choices of whom to follow, mention, or add to lists. Zero
+// (the default) picks a new random seed for every step.
+//
+// Call it before generating anything; it isn't safe to change concurrently
+// with generation.
+func SetSeed(s int64) {
+	seed = s
+}
+
+// faker returns the random source for one generation step of one account.
+// Sources are derived from the seed, step, and account, rather than shared,
+// so results don't depend on the order accounts are processed in (eg, by
+// several parallel jobs).
+func faker(step, accountType string, index int) *gofakeit.Faker {
+	if seed == 0 {
+		return gofakeit.New(0)
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s/%s/%d", seed, step, accountType, index)
+	// gofakeit treats a zero seed as "random", so keep the low bit set
+	return gofakeit.New(int64(h.Sum64()>>1) | 1)
+}
+
+func accountFaker(step string, acc *AccountContext) *gofakeit.Faker {
+	return faker(step, acc.AccountType, acc.Index)
+}
@@ -0,0 +1,41 @@
+package fakedata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeededFaker(t *testing.T) {
+	assert := assert.New(t)
+	defer SetSeed(0)
+
+	SetSeed(1234)
+	a := faker("posts", "regular", 3)
+	b := faker("posts", "regular", 3)
+	assert.Equal(a.Sentence(10), b.Sentence(10))
+	assert.Equal(a.Rand.Perm(20), b.Rand.Perm(20))
+
+	// different steps and accounts get independent sources
+	assert.NotEqual(faker("posts", "regular", 3).Sentence(10), faker("posts", "regular", 4).Sentence(10))
+	assert.NotEqual(faker("posts", "regular", 3).Sentence(10), faker("profile", "regular", 3).Sentence(10))
+	assert.NotEqual(faker("posts", "regular", 3).Sentence(10), faker("posts", "celebrity", 3).Sentence(10))
+
+	// and a different seed gives different data
+	first := faker("account", "regular", 0).Username()
+	SetSeed(5678)
+	assert.NotEqual(first, faker("account", "regular", 0).Username())
+}
+
+func TestMentionFacet(t *testing.T) {
+	assert := assert.New(t)
+
+	acc := &AccountContext{}
+	acc.Auth.Handle = "alice.test"
+	acc.Auth.Did = "did:plc:ewvi7nxzyoun6zhxrhs64oiz"
+
+	text := "@" + acc.Auth.Handle + " hello"
+	facet := mentionFacet(acc, 0)
+	assert.Equal("@alice.test", text[facet.Index.ByteStart:facet.Index.ByteEnd])
+	assert.Equal(acc.Auth.Did, facet.Features[0].RichtextFacet_Mention.Did)
+}
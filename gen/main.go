@@ -58,6 +58,7 @@ func main() {
 
 	if err := cbg.WriteMapEncodersToFile("api/atproto/cbor_gen.go", "atproto",
 		atproto.RepoStrongRef{},
+		atproto.SyncSubscribeRepos_Account{},
 		atproto.SyncSubscribeRepos_Commit{},
 		atproto.SyncSubscribeRepos_Handle{},
 		atproto.SyncSubscribeRepos_Info{},
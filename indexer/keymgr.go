@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/bluesky-social/indigo/atproto/crypto"
 	did "github.com/whyrusleeping/go-did"
 	"go.opentelemetry.io/otel"
 )
@@ -12,6 +13,9 @@ type KeyManager struct {
 	didr DidResolver
 
 	signingKey *did.PrivKey
+
+	// if set, used for signing in place of signingKey
+	signer crypto.PrivateKey
 }
 
 type DidResolver interface {
@@ -25,6 +29,13 @@ func NewKeyManager(didr DidResolver, k *did.PrivKey) *KeyManager {
 	}
 }
 
+// SetSigningKey signs with an atproto crypto key instead of the key given to
+// NewKeyManager. This can be a crypto.SignerKey, to keep the key in a KMS or
+// HSM.
+func (km *KeyManager) SetSigningKey(k crypto.PrivateKey) {
+	km.signer = k
+}
+
 func (km *KeyManager) VerifyUserSignature(ctx context.Context, did string, sig []byte, msg []byte) error {
 	ctx, span := otel.Tracer("keymgr").Start(ctx, "verifySignature")
 	defer span.End()
@@ -61,6 +72,9 @@ func (km *KeyManager) getKey(ctx context.Context, did string) (*did.PubKey, erro
 }
 
 func (km *KeyManager) SignForUser(ctx context.Context, did string, msg []byte) ([]byte, error) {
+	if km.signer != nil {
+		return km.signer.HashAndSign(msg)
+	}
 	if km.signingKey == nil {
 		return nil, fmt.Errorf("key manager does not have a signing key, cannot sign")
 	}
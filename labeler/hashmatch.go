@@ -0,0 +1,384 @@
+package labeler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/carlmjohnson/versioninfo"
+	"gorm.io/gorm"
+)
+
+const (
+	modActionTakedown  = "com.atproto.admin.defs#takedown"
+	modReasonViolation = "com.atproto.moderation.defs#reasonViolation"
+)
+
+// What the labeler does with a blob which matches a hash bank
+type HashMatchAction string
+
+const (
+	// takes down the record (with the blob), and files a report resolved by the takedown
+	HashMatchTakedown HashMatchAction = "takedown"
+	// only files a report, for human review
+	HashMatchReport HashMatchAction = "report"
+)
+
+// HashMatchAudit is the audit log of the hash matching pipeline. A row is
+// written for every blob queued, every result (or failure) from the service,
+// and every moderation action taken on a match. Rows are never updated or
+// deleted by the labeler.
+type HashMatchAudit struct {
+	ID        uint64    `gorm:"primaryKey"`
+	CreatedAt time.Time `gorm:"not null;index"`
+	// one of: queued, dropped, error, no-match, match
+	Event     string `gorm:"not null"`
+	Did       string `gorm:"not null;index"`
+	Uri       string `gorm:"not null"`
+	RecordCid string `gorm:"not null"`
+	BlobCid   string `gorm:"not null;index"`
+	Attempt   int
+	// service response (for matches), or the error
+	Detail   string
+	ActionID *uint64
+	ReportID *uint64
+}
+
+// HashMatchConfig configures a HashMatcher
+type HashMatchConfig struct {
+	// URL blobs are submitted to (as a multipart form upload, in the "file" field)
+	Endpoint string
+	// if set, sent as a bearer token
+	ApiToken string
+	Action   HashMatchAction
+	// timeout for each submission to the service
+	Timeout time.Duration
+	// attempts per blob, on errors or timeouts
+	MaxAttempts int
+	// number of blobs waiting to be submitted, and how long to wait for space
+	// in the queue before giving up on a blob
+	QueueSize    int
+	QueueTimeout time.Duration
+	Workers      int
+}
+
+func DefaultHashMatchConfig() HashMatchConfig {
+	return HashMatchConfig{
+		Action:       HashMatchTakedown,
+		Timeout:      10 * time.Second,
+		MaxAttempts:  3,
+		QueueSize:    1000,
+		QueueTimeout: 5 * time.Second,
+		Workers:      4,
+	}
+}
+
+// Response of the hash matching service. Services with other response formats
+// need a small adapter in front of them.
+type HashMatchResp struct {
+	Matched bool             `json:"matched"`
+	Matches []HashMatchEntry `json:"matches,omitempty"`
+}
+
+type HashMatchEntry struct {
+	// name of the hash bank (list) which matched
+	Bank string `json:"bank"`
+	// hash algorithm, eg "pdq" or "photodna"
+	Algorithm string  `json:"algorithm,omitempty"`
+	Distance  float64 `json:"distance,omitempty"`
+}
+
+type hashMatchJob struct {
+	did       string
+	uri       string
+	recordCid string
+	blob      lexutil.LexBlob
+	blobBytes []byte
+}
+
+// HashMatcher submits image blobs to a hash matching service (eg, HMA or a
+// PhotoDNA-style API), and escalates matches directly to moderation actions.
+//
+// Unlike the other blob labelers, matching happens out of band from event
+// processing: blobs are queued and submitted by a pool of workers, with a
+// strict timeout on each request. Everything that happens to a blob is written
+// to the HashMatchAudit table, and failing to write the audit log is treated
+// as a failure to process the blob.
+type HashMatcher struct {
+	Client http.Client
+	cfg    HashMatchConfig
+	db     *gorm.DB
+	// DID that moderation actions and reports are created by
+	createdBy string
+
+	queue chan *hashMatchJob
+	wg    sync.WaitGroup
+}
+
+func NewHashMatcher(db *gorm.DB, createdBy string, cfg HashMatchConfig) (*HashMatcher, error) {
+	if cfg.Endpoint == "" {
+		return nil, fmt.Errorf("hash matching endpoint is required")
+	}
+	if cfg.Action != HashMatchTakedown && cfg.Action != HashMatchReport {
+		return nil, fmt.Errorf("unknown hash match action: %q", cfg.Action)
+	}
+	def := DefaultHashMatchConfig()
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = def.Timeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = def.MaxAttempts
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = def.QueueSize
+	}
+	if cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = def.QueueTimeout
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = def.Workers
+	}
+
+	// the audit log is mandatory; don't start without it
+	if err := db.AutoMigrate(HashMatchAudit{}); err != nil {
+		return nil, fmt.Errorf("migrating hash match audit table: %w", err)
+	}
+
+	return &HashMatcher{
+		// no retries in the client itself, so that Timeout is a hard bound on each attempt
+		Client:    http.Client{Timeout: cfg.Timeout},
+		cfg:       cfg,
+		db:        db,
+		createdBy: createdBy,
+		queue:     make(chan *hashMatchJob, cfg.QueueSize),
+	}, nil
+}
+
+// Start runs the worker pool, until ctx is cancelled or Close is called
+func (hm *HashMatcher) Start(ctx context.Context) {
+	for i := 0; i < hm.cfg.Workers; i++ {
+		hm.wg.Add(1)
+		go func() {
+			defer hm.wg.Done()
+			for {
+				select {
+				case job, ok := <-hm.queue:
+					if !ok {
+						return
+					}
+					hm.process(ctx, job)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Close stops accepting blobs, and waits for the queued ones to be processed.
+// Enqueue must not be called after Close.
+func (hm *HashMatcher) Close() {
+	close(hm.queue)
+	hm.wg.Wait()
+}
+
+// Enqueue queues a blob for matching. An error is returned if the blob
+// couldn't be queued (or the audit log written), in which case the caller
+// should treat the record as unprocessed.
+func (hm *HashMatcher) Enqueue(ctx context.Context, did, uri, recordCid string, blob lexutil.LexBlob, blobBytes []byte) error {
+	job := &hashMatchJob{
+		did:       did,
+		uri:       uri,
+		recordCid: recordCid,
+		blob:      blob,
+		blobBytes: blobBytes,
+	}
+
+	if err := hm.audit(job, "queued", 0, "", nil, nil); err != nil {
+		return err
+	}
+
+	t := time.NewTimer(hm.cfg.QueueTimeout)
+	defer t.Stop()
+	select {
+	case hm.queue <- job:
+		return nil
+	case <-t.C:
+	case <-ctx.Done():
+	}
+
+	log.Errorw("hash match queue full, blob not submitted", "did", did, "uri", uri, "cid", blob.Ref)
+	if err := hm.audit(job, "dropped", 0, "queue full", nil, nil); err != nil {
+		return err
+	}
+	return fmt.Errorf("hash match queue full (cid=%s)", blob.Ref)
+}
+
+func (hm *HashMatcher) process(ctx context.Context, job *hashMatchJob) {
+	var resp *HashMatchResp
+	var err error
+	for attempt := 1; attempt <= hm.cfg.MaxAttempts; attempt++ {
+		resp, err = hm.submit(ctx, job)
+		if err == nil {
+			if resp.Matched {
+				hm.handleMatch(job, attempt, resp)
+			} else if err := hm.audit(job, "no-match", attempt, "", nil, nil); err != nil {
+				log.Errorw("failed to write hash match audit log", "cid", job.blob.Ref, "err", err)
+			}
+			return
+		}
+
+		log.Warnw("hash match request failed", "cid", job.blob.Ref, "attempt", attempt, "err", err)
+		if err := hm.audit(job, "error", attempt, err.Error(), nil, nil); err != nil {
+			log.Errorw("failed to write hash match audit log", "cid", job.blob.Ref, "err", err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if attempt == hm.cfg.MaxAttempts {
+			break
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+	log.Errorw("giving up on hash matching blob", "did", job.did, "uri", job.uri, "cid", job.blob.Ref, "err", err)
+}
+
+func (hm *HashMatcher) submit(ctx context.Context, job *hashMatchJob) (*HashMatchResp, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", job.blob.Ref.String())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(job.blobBytes); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, hm.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", hm.cfg.Endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "labelmaker/"+versioninfo.Short())
+	if hm.cfg.ApiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+hm.cfg.ApiToken)
+	}
+
+	res, err := hm.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("hash match request failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("hash match request failed  statusCode=%d", res.StatusCode)
+	}
+
+	respBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hash match resp body: %v", err)
+	}
+	var respObj HashMatchResp
+	if err := json.Unmarshal(respBytes, &respObj); err != nil {
+		return nil, fmt.Errorf("failed to parse hash match resp JSON: %v", err)
+	}
+	return &respObj, nil
+}
+
+// handleMatch files a report on the record and, depending on the configured
+// action, takes it down, all in a single transaction with the audit log entry
+func (hm *HashMatcher) handleMatch(job *hashMatchJob, attempt int, resp *HashMatchResp) {
+	var banks []string
+	for _, m := range resp.Matches {
+		banks = append(banks, m.Bank)
+	}
+	detail, _ := json.Marshal(resp)
+	reason := fmt.Sprintf("automated hash match (banks: %s)", strings.Join(banks, ", "))
+
+	log.Warnw("hash match", "did", job.did, "uri", job.uri, "cid", job.blob.Ref, "banks", banks, "action", hm.cfg.Action)
+
+	err := hm.db.Transaction(func(tx *gorm.DB) error {
+		report := models.ModerationReport{
+			SubjectType:   "com.atproto.repo.recordRef",
+			SubjectDid:    job.did,
+			SubjectUri:    &job.uri,
+			SubjectCid:    &job.recordCid,
+			ReasonType:    modReasonViolation,
+			Reason:        &reason,
+			ReportedByDid: hm.createdBy,
+		}
+		if err := tx.Create(&report).Error; err != nil {
+			return err
+		}
+
+		var actionID *uint64
+		if hm.cfg.Action == HashMatchTakedown {
+			action := models.ModerationAction{
+				Action:       modActionTakedown,
+				SubjectType:  "com.atproto.repo.recordRef",
+				SubjectDid:   job.did,
+				SubjectUri:   &job.uri,
+				SubjectCid:   &job.recordCid,
+				Reason:       reason,
+				CreatedByDid: hm.createdBy,
+			}
+			if err := tx.Create(&action).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&models.ModerationActionSubjectBlobCid{ActionId: action.ID, Cid: job.blob.Ref.String()}).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&models.ModerationReportResolution{ReportId: report.ID, ActionId: action.ID, CreatedByDid: hm.createdBy}).Error; err != nil {
+				return err
+			}
+			actionID = &action.ID
+		}
+
+		return hm.auditTx(tx, job, "match", attempt, string(detail), actionID, &report.ID)
+	})
+	if err != nil {
+		// the match itself must not be lost, even if the database is unavailable
+		log.Errorw("failed to record hash match; manual action required", "did", job.did, "uri", job.uri, "cid", job.blob.Ref, "detail", string(detail), "err", err)
+	}
+}
+
+func (hm *HashMatcher) audit(job *hashMatchJob, event string, attempt int, detail string, actionID, reportID *uint64) error {
+	return hm.auditTx(hm.db, job, event, attempt, detail, actionID, reportID)
+}
+
+func (hm *HashMatcher) auditTx(tx *gorm.DB, job *hashMatchJob, event string, attempt int, detail string, actionID, reportID *uint64) error {
+	row := HashMatchAudit{
+		Event:     event,
+		Did:       job.did,
+		Uri:       job.uri,
+		RecordCid: job.recordCid,
+		BlobCid:   job.blob.Ref.String(),
+		Attempt:   attempt,
+		Detail:    detail,
+		ActionID:  actionID,
+		ReportID:  reportID,
+	}
+	if err := tx.Create(&row).Error; err != nil {
+		return fmt.Errorf("writing hash match audit log: %w", err)
+	}
+	return nil
+}
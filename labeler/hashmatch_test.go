@@ -0,0 +1,132 @@
+package labeler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/models"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+)
+
+func testHashMatchJob(t *testing.T) *hashMatchJob {
+	c, err := cid.Decode("bafkreiccldh766hwcnuxnf2wh6jgzepf2nlu2lvcllt63eww5p6chi4ity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &hashMatchJob{
+		did:       "did:plc:abc",
+		uri:       "at://did:plc:abc/app.bsky.feed.post/3k2a",
+		recordCid: "bafyreiclp443lavogvhj3d2ob2cxbfuscni2k5jk7bebjzg7khl3esabwq",
+		blob:      lexutil.LexBlob{Ref: lexutil.LexLink(c), MimeType: "image/jpeg", Size: 4},
+		blobBytes: []byte("jpeg"),
+	}
+}
+
+func TestHashMatchTakedown(t *testing.T) {
+	assert := assert.New(t)
+	lm := testLabelMaker(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"matched": true, "matches": [{"bank": "test-bank", "algorithm": "pdq"}]}`))
+	}))
+	defer srv.Close()
+
+	cfg := DefaultHashMatchConfig()
+	cfg.Endpoint = srv.URL
+	hm, err := NewHashMatcher(lm.db, lm.user.Did, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := testHashMatchJob(t)
+	hm.process(context.Background(), job)
+
+	var action models.ModerationAction
+	assert.NoError(lm.db.First(&action).Error)
+	assert.Equal(modActionTakedown, action.Action)
+	assert.Equal(job.uri, *action.SubjectUri)
+	assert.Equal(job.recordCid, *action.SubjectCid)
+
+	var blobRow models.ModerationActionSubjectBlobCid
+	assert.NoError(lm.db.Where("action_id = ?", action.ID).First(&blobRow).Error)
+	assert.Equal(job.blob.Ref.String(), blobRow.Cid)
+
+	var report models.ModerationReport
+	assert.NoError(lm.db.First(&report).Error)
+	var resolution models.ModerationReportResolution
+	assert.NoError(lm.db.Where("report_id = ?", report.ID).First(&resolution).Error)
+	assert.Equal(action.ID, resolution.ActionId)
+
+	var audit []HashMatchAudit
+	assert.NoError(lm.db.Find(&audit).Error)
+	assert.Equal(1, len(audit))
+	assert.Equal("match", audit[0].Event)
+	assert.Equal(action.ID, *audit[0].ActionID)
+	assert.Equal(report.ID, *audit[0].ReportID)
+}
+
+func TestHashMatchTimeout(t *testing.T) {
+	assert := assert.New(t)
+	lm := testLabelMaker(t)
+
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	cfg := DefaultHashMatchConfig()
+	cfg.Endpoint = srv.URL
+	cfg.Action = HashMatchReport
+	cfg.Timeout = 50 * time.Millisecond
+	cfg.MaxAttempts = 1
+	hm, err := NewHashMatcher(lm.db, lm.user.Did, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hm.process(context.Background(), testHashMatchJob(t))
+
+	var audit []HashMatchAudit
+	assert.NoError(lm.db.Find(&audit).Error)
+	assert.Equal(1, len(audit))
+	assert.Equal("error", audit[0].Event)
+
+	var count int64
+	assert.NoError(lm.db.Model(&models.ModerationReport{}).Count(&count).Error)
+	assert.Equal(int64(0), count)
+}
+
+func TestHashMatchQueueFull(t *testing.T) {
+	assert := assert.New(t)
+	lm := testLabelMaker(t)
+
+	cfg := DefaultHashMatchConfig()
+	cfg.Endpoint = "http://hashmatch-test.dummy"
+	cfg.QueueSize = 1
+	cfg.QueueTimeout = 10 * time.Millisecond
+	hm, err := NewHashMatcher(lm.db, lm.user.Did, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// workers not started, so the second blob doesn't fit
+	job := testHashMatchJob(t)
+	ctx := context.Background()
+	assert.NoError(hm.Enqueue(ctx, job.did, job.uri, job.recordCid, job.blob, job.blobBytes))
+	assert.Error(hm.Enqueue(ctx, job.did, job.uri, job.recordCid, job.blob, job.blobBytes))
+
+	var events []string
+	assert.NoError(lm.db.Model(&HashMatchAudit{}).Order("id asc").Pluck("event", &events).Error)
+	assert.Equal([]string{"queued", "queued", "dropped"}, events)
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/bluesky-social/indigo/pds"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/bluesky-social/indigo/util"
 	cbg "github.com/whyrusleeping/cbor-gen"
 
 	logging "github.com/ipfs/go-log"
@@ -50,6 +51,8 @@ type Server struct {
 	muNSFWImgLabeler    *MicroNSFWImgLabeler
 	hiveAILabeler       *HiveAILabeler
 	sqrlLabeler         *SQRLLabeler
+	hashMatcher         *HashMatcher
+	blobClient          *http.Client
 }
 
 type RepoConfig struct {
@@ -94,6 +97,7 @@ func NewServer(db *gorm.DB, cs *carstore.CarStore, repoUser RepoConfig, plcURL,
 		blobPdsURL:          blobPdsURL,
 		xrpcProxyURL:        proxyURL,
 		xrpcProxyAuthHeader: xrpcProxyAuthHeader,
+		blobClient:          util.RobustHTTPClient(),
 		// sluper configured below
 	}
 
@@ -144,6 +148,19 @@ func (s *Server) AddSQRLLabeler(url string) {
 	s.sqrlLabeler = &sl
 }
 
+// Hash matching escalates matches directly to moderation actions, rather than
+// labels. Matching runs in the background until ctx is cancelled.
+func (s *Server) AddHashMatcher(ctx context.Context, cfg HashMatchConfig) error {
+	log.Infof("configuring hash matcher url=%s action=%s", cfg.Endpoint, cfg.Action)
+	hm, err := NewHashMatcher(s.db, s.user.Did, cfg)
+	if err != nil {
+		return err
+	}
+	hm.Start(ctx)
+	s.hashMatcher = hm
+	return nil
+}
+
 // call this *after* all the labelers are configured
 func (s *Server) SubscribeBGS(ctx context.Context, bgsURL string, useWss bool) {
 	// subscribe our RepoEvent slurper to the BGS, to receive incoming records for labeler
@@ -177,7 +194,7 @@ func (s *Server) wantBlob(ctx context.Context, blob *lexutil.LexBlob) bool {
 	// images
 	if blob.MimeType == "image/png" || blob.MimeType == "image/jpeg" {
 		// only an image API is configured
-		if s.muNSFWImgLabeler != nil || s.hiveAILabeler != nil {
+		if s.muNSFWImgLabeler != nil || s.hiveAILabeler != nil || s.hashMatcher != nil {
 			return true
 		}
 	}
@@ -263,6 +280,12 @@ func (s *Server) labelRecord(ctx context.Context, did, nsid, uri, cidStr string,
 			return nil, err
 		}
 
+		if s.hashMatcher != nil {
+			if err := s.hashMatcher.Enqueue(ctx, did, uri, cidStr, blob, blobBytes); err != nil {
+				return nil, err
+			}
+		}
+
 		blobLabels, err := s.labelBlob(ctx, did, blob, blobBytes)
 		// TODO(bnewbold): again, instead of erroring, just log any download problems
 		if err != nil {
@@ -289,7 +312,11 @@ func (s *Server) downloadRepoBlob(ctx context.Context, did string, blob *lexutil
 	// for now, just fetching from configured PDS (aka our single PDS)
 	xrpcURL := fmt.Sprintf("%s/xrpc/com.atproto.sync.getBlob?did=%s&cid=%s", s.blobPdsURL, did, blob.Ref.String())
 
-	resp, err := http.Get(xrpcURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", xrpcURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.blobClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -478,5 +505,8 @@ func (s *Server) RunAPI(listen string) error {
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.hashMatcher != nil {
+		s.hashMatcher.Close()
+	}
 	return s.echo.Shutdown(ctx)
 }
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sort"
@@ -79,6 +80,65 @@ type TypeSchema struct {
 	Default any `json:"default"`
 	Minimum any `json:"minimum"`
 	Maximum any `json:"maximum"`
+
+	Deprecated Deprecation `json:"deprecated"`
+	Since      string      `json:"since"`
+}
+
+// Deprecation metadata for a Lexicon definition or field. In schema JSON this can be either a boolean, or a string explaining the deprecation (eg, naming a replacement).
+type Deprecation struct {
+	Deprecated bool
+	Message    string
+}
+
+func (d *Deprecation) UnmarshalJSON(b []byte) error {
+	var flag bool
+	if err := json.Unmarshal(b, &flag); err == nil {
+		d.Deprecated = flag
+		return nil
+	}
+	var msg string
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return fmt.Errorf("deprecated must be a boolean or string: %w", err)
+	}
+	d.Deprecated = true
+	d.Message = msg
+	return nil
+}
+
+// Returns the deprecation notice for this schema, if it is deprecated. Schemas are deprecated either explicitly (with the "deprecated" field), or by a description starting with "Deprecated:", following the Go convention.
+//
+// The second return value indicates that the notice came from the description, in which case the description does not need to be repeated.
+func (ts *TypeSchema) deprecationNotice() (string, bool, bool) {
+	if ts.Deprecated.Deprecated {
+		msg := ts.Deprecated.Message
+		if msg == "" {
+			msg = "this Lexicon definition is deprecated."
+		}
+		return msg, false, true
+	}
+	if strings.HasPrefix(ts.Description, "Deprecated:") {
+		return strings.TrimSpace(strings.TrimPrefix(ts.Description, "Deprecated:")), true, true
+	}
+	return "", false, false
+}
+
+// writes the doc comment paragraphs for deprecation and version metadata. fromDesc is true if the description is already written and starts with a deprecation notice; hasPrev is true if other comment lines have already been written.
+func (ts *TypeSchema) writeVersionComments(w io.Writer, indent string, fromDesc, hasPrev bool) {
+	pf := printerf(w)
+	para := func(format string, args ...any) {
+		if hasPrev {
+			pf("%s//\n", indent)
+		}
+		pf(indent+format, args...)
+		hasPrev = true
+	}
+	if msg, derived, ok := ts.deprecationNotice(); ok && !(derived && fromDesc) {
+		para("// Deprecated: %s\n", msg)
+	}
+	if ts.Since != "" {
+		para("// Since: %s\n", ts.Since)
+	}
 }
 
 func (s *Schema) Name() string {
@@ -226,6 +286,45 @@ func ReadSchema(f string) (*Schema, error) {
 	return &s, nil
 }
 
+// Reads all the Lexicon schema files at the given paths. Directories are walked recursively, and any files with a ".json" suffix are read.
+func ReadSchemas(paths []string) ([]*Schema, error) {
+	var files []string
+	for _, p := range paths {
+		st, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !st.IsDir() {
+			if strings.HasSuffix(p, ".json") {
+				files = append(files, p)
+			}
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.HasSuffix(path, ".json") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var out []*Schema
+	for _, f := range files {
+		s, err := ReadSchema(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %q: %w", f, err)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
 func BuildExtDefMap(ss []*Schema, prefixes []string) map[string]*ExtDef {
 	out := make(map[string]*ExtDef)
 	for _, s := range ss {
@@ -364,6 +463,75 @@ func GenCodeForSchema(pkg string, prefix string, fname string, reqcode bool, s *
 	return nil
 }
 
+// Configuration for generating Go types from an arbitrary set of Lexicon schemas, independent of the indigo repository layout.
+type GenConfig struct {
+	// Go package name for generated code
+	Package string
+	// directory that generated files are written to
+	OutDir string
+	// only schemas with IDs under this NSID prefix (eg, "com.example") have code generated. Other schemas are only used to resolve references.
+	Prefix string
+	// maps NSID prefixes of referenced schemas to the Go import path of the package containing their generated code
+	Imports map[string]string
+}
+
+// Import mapping for the Lexicons which have generated code in this repository.
+func DefaultImports() map[string]string {
+	return map[string]string{
+		"app.bsky":    "github.com/bluesky-social/indigo/api/bsky",
+		"com.atproto": "github.com/bluesky-social/indigo/api/atproto",
+	}
+}
+
+// Generates Go type definitions and XRPC client helpers for every schema under the configured prefix, writing one file per schema.
+//
+// The schemas slice should include any schemas referenced by the generated schemas, even if they are under a different prefix (and are mapped to an existing package via the Imports config).
+func GenerateTypes(schemas []*Schema, cfg GenConfig) error {
+	if cfg.Package == "" {
+		return fmt.Errorf("lexgen: output package name is required")
+	}
+	if cfg.OutDir == "" {
+		return fmt.Errorf("lexgen: output directory is required")
+	}
+	if cfg.Prefix == "" {
+		return fmt.Errorf("lexgen: schema prefix is required")
+	}
+
+	prefixes := []string{cfg.Prefix}
+	for k := range cfg.Imports {
+		if k != cfg.Prefix {
+			prefixes = append(prefixes, k)
+		}
+	}
+	// longest prefix wins, for nested namespaces
+	sort.Slice(prefixes, func(i, j int) bool {
+		return len(prefixes[i]) > len(prefixes[j])
+	})
+	defmap := BuildExtDefMap(schemas, prefixes)
+
+	// Run this twice as a hack to deal with indirect references referencing indirect references.
+	// This part of the codegen needs to be redone
+	FixRecordReferences(schemas, defmap, cfg.Prefix)
+	FixRecordReferences(schemas, defmap, cfg.Prefix)
+
+	if err := os.MkdirAll(cfg.OutDir, 0755); err != nil {
+		return err
+	}
+
+	for _, s := range schemas {
+		if !strings.HasPrefix(s.ID, cfg.Prefix) {
+			continue
+		}
+
+		fname := filepath.Join(cfg.OutDir, s.Name()+".go")
+
+		if err := GenCodeForSchema(cfg.Package, cfg.Prefix, fname, true, s, defmap, cfg.Imports); err != nil {
+			return fmt.Errorf("failed to process schema %q: %w", s.ID, err)
+		}
+	}
+	return nil
+}
+
 func writeDecoderRegister(w io.Writer, tps []outputType) error {
 	var buf bytes.Buffer
 	outf := printerf(&buf)
@@ -524,7 +692,11 @@ func (s *TypeSchema) WriteRPC(w io.Writer, typename string) error {
 			return err
 		}
 	}
+	s.writeVersionComments(w, "", false, true)
 	pf("func %s(%s) %s {\n", fname, params, out)
+	if msg, _, ok := s.deprecationNotice(); ok {
+		pf("\tutil.DeprecatedCall(%q, %q)\n\n", s.id, msg)
+	}
 
 	outvar := "nil"
 	errRet := "err"
@@ -1182,6 +1354,7 @@ func (ts *TypeSchema) writeTypeDefinition(name string, w io.Writer) error {
 	if ts.Description != "" {
 		pf("//\n// %s\n", ts.Description)
 	}
+	ts.writeVersionComments(w, "", true, true)
 
 	switch ts.Type {
 	case "string":
@@ -1261,9 +1434,13 @@ func (ts *TypeSchema) writeTypeDefinition(name string, w io.Writer) error {
 				cborOmit = ",omitempty"
 			}
 
-			if v.Description != "" {
+			if msg, derived, ok := v.deprecationNotice(); ok && derived {
+				// Go tooling only recognizes deprecation paragraphs at the start of a line
+				pf("\t// Deprecated: %s\n", msg)
+			} else if v.Description != "" {
 				pf("\t// %s: %s\n", k, v.Description)
 			}
+			v.writeVersionComments(w, "\t", true, v.Description != "")
 			pf("\t%s %s%s `json:\"%s%s\" cborgen:\"%s%s\"`\n", goname, ptr, tname, k, jsonOmit, k, cborOmit)
 			return nil
 		}); err != nil {
@@ -1285,8 +1462,18 @@ func (ts *TypeSchema) writeTypeDefinition(name string, w io.Writer) error {
 			pf("type %s struct {\n", name)
 			for _, r := range ts.Refs {
 				vname, tname := ts.namesFromRef(r)
+				if vname == unknownVariantField {
+					return fmt.Errorf("%s: union variant name conflicts with %s field", name, unknownVariantField)
+				}
 				pf("\t%s *%s\n", vname, tname)
 			}
+			keep, err := ts.keepsUnknownVariants()
+			if err != nil {
+				return err
+			}
+			if keep {
+				pf("\t%s *util.UnknownUnionVariant\n", unknownVariantField)
+			}
 			pf("}\n\n")
 		}
 	default:
@@ -1348,6 +1535,23 @@ func (ts *TypeSchema) writeTypeMethods(name string, w io.Writer) error {
 	}
 }
 
+// name of the generated union struct field which holds unrecognized variants
+const unknownVariantField = "Unknown"
+
+// Open unions with object variants keep the raw encoding of unrecognized
+// variants, so that records containing newer Lexicon variants round-trip
+// without data loss.
+func (ts *TypeSchema) keepsUnknownVariants() (bool, error) {
+	if ts.Type != "union" || ts.Closed || len(ts.Refs) == 0 {
+		return false, nil
+	}
+	reft, err := ts.lookupRef(ts.Refs[0])
+	if err != nil {
+		return false, err
+	}
+	return reft.Type != "string", nil
+}
+
 func forEachProp(t TypeSchema, cb func(k string, ts TypeSchema) error) error {
 	var keys []string
 	for k := range t.Properties {
@@ -1385,6 +1589,11 @@ func (ts *TypeSchema) writeJsonMarshalerEnum(name string, w io.Writer) error {
 		pf("\t\treturn json.Marshal(t.%s)\n\t}\n", vname)
 	}
 
+	if !ts.Closed {
+		pf("\tif t.%s != nil {\n", unknownVariantField)
+		pf("\t\treturn t.%s.MarshalJSON()\n\t}\n", unknownVariantField)
+	}
+
 	pf("\treturn nil, fmt.Errorf(\"cannot marshal empty enum\")\n}\n")
 	return nil
 }
@@ -1435,8 +1644,9 @@ func (ts *TypeSchema) writeJsonUnmarshalerEnum(name string, w io.Writer) error {
 	} else {
 		pf(`
 			default:
+				t.%s = &util.UnknownUnionVariant{Type: typ, JSON: append([]byte(nil), b...)}
 				return nil
-		`)
+		`, unknownVariantField)
 
 	}
 
@@ -1462,6 +1672,11 @@ func (ts *TypeSchema) writeCborMarshalerEnum(name string, w io.Writer) error {
 		pf("\t\treturn t.%s.MarshalCBOR(w)\n\t}\n", vname)
 	}
 
+	if !ts.Closed {
+		pf("\tif t.%s != nil {\n", unknownVariantField)
+		pf("\t\treturn t.%s.MarshalCBOR(w)\n\t}\n", unknownVariantField)
+	}
+
 	pf("\treturn fmt.Errorf(\"cannot cbor marshal empty enum\")\n}\n")
 	return nil
 }
@@ -1492,8 +1707,9 @@ func (ts *TypeSchema) writeCborUnmarshalerEnum(name string, w io.Writer) error {
 	} else {
 		pf(`
 			default:
+				t.%s = &util.UnknownUnionVariant{Type: typ, CBOR: b}
 				return nil
-		`)
+		`, unknownVariantField)
 
 	}
 
@@ -0,0 +1,22 @@
+package util
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// Controls whether generated client code for deprecated Lexicon endpoints logs a warning when called. Disabled by default.
+var LogDeprecatedCalls = false
+
+var deprecatedWarned sync.Map
+
+// Called by generated code at the start of XRPC client methods for deprecated endpoints. If [LogDeprecatedCalls] is enabled, logs a warning the first time each endpoint is called.
+func DeprecatedCall(nsid, msg string) {
+	if !LogDeprecatedCalls {
+		return
+	}
+	if _, loaded := deprecatedWarned.LoadOrStore(nsid, true); loaded {
+		return
+	}
+	slog.Warn("called deprecated XRPC endpoint", "nsid", nsid, "deprecation", msg)
+}
@@ -267,3 +267,33 @@ func (lb *LexBlob) UnmarshalCBOR(r io.Reader) error {
 
 	return nil
 }
+
+// Holds the raw encoded form of an open-union variant which was not
+// recognized when decoding (eg, a variant from a newer version of the
+// Lexicon). Generated union types keep this around so that the original data
+// can be re-emitted unchanged when marshaling.
+//
+// Only the encoding which the variant was originally decoded from is
+// retained, so data decoded from JSON can only be re-marshaled as JSON, and
+// likewise for CBOR.
+type UnknownUnionVariant struct {
+	// the $type of the variant, if any
+	Type string
+	JSON json.RawMessage
+	CBOR []byte
+}
+
+func (uv *UnknownUnionVariant) MarshalJSON() ([]byte, error) {
+	if uv.JSON == nil {
+		return nil, xerrors.Errorf("unknown union variant (%q) was not decoded from JSON", uv.Type)
+	}
+	return uv.JSON, nil
+}
+
+func (uv *UnknownUnionVariant) MarshalCBOR(w io.Writer) error {
+	if uv.CBOR == nil {
+		return xerrors.Errorf("unknown union variant (%q) was not decoded from CBOR", uv.Type)
+	}
+	_, err := w.Write(uv.CBOR)
+	return err
+}
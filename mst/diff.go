@@ -20,13 +20,23 @@ type DiffOp struct {
 // TODO: this code isn't great, should be rewritten on top of the baseline datastructures once functional and correct
 func DiffTrees(ctx context.Context, bs blockstore.Blockstore, from, to cid.Cid) ([]*DiffOp, error) {
 	cst := util.CborStore(bs)
+	return diffTrees(ctx, func(root cid.Cid) *MerkleSearchTree { return LoadMST(cst, root) }, from, to)
+}
+
+// DiffTrees is like the package-level DiffTrees, reading both trees from the
+// cache's store, through the cache. Diffing each new commit of a repo against
+// the previous one re-uses the nodes they have in common.
+func (nc *NodeCache) DiffTrees(ctx context.Context, from, to cid.Cid) ([]*DiffOp, error) {
+	return diffTrees(ctx, nc.LoadMST, from, to)
+}
 
+func diffTrees(ctx context.Context, load func(cid.Cid) *MerkleSearchTree, from, to cid.Cid) ([]*DiffOp, error) {
 	if from == cid.Undef {
-		return identityDiff(ctx, bs, to)
+		return identityDiff(ctx, load(to))
 	}
 
-	ft := LoadMST(cst, from)
-	tt := LoadMST(cst, to)
+	ft := load(from)
+	tt := load(to)
 
 	fents, err := ft.getEntries(ctx)
 	if err != nil {
@@ -185,10 +195,7 @@ func nodeEntriesEqual(a, b *nodeEntry) bool {
 	return false
 }
 
-func identityDiff(ctx context.Context, bs blockstore.Blockstore, root cid.Cid) ([]*DiffOp, error) {
-	cst := util.CborStore(bs)
-	tt := LoadMST(cst, root)
-
+func identityDiff(ctx context.Context, tt *MerkleSearchTree) ([]*DiffOp, error) {
 	var ops []*DiffOp
 	if err := tt.WalkLeavesFrom(ctx, "", func(key string, val cid.Cid) error {
 		ops = append(ops, &DiffOp{
@@ -137,6 +137,8 @@ type MerkleSearchTree struct {
 	layer    int
 	pointer  cid.Cid
 	validPtr bool
+	cache    *NodeCache // optional; see LoadMST on NodeCache
+	cacheGen uint64     // the cache generation the tree was loaded in
 }
 
 // NewEmptyMST reports a new empty MST using cst as its storage.
@@ -188,6 +190,9 @@ func (mst *MerkleSearchTree) getEntries(ctx context.Context) ([]nodeEntry, error
 
 	// otherwise this is a virtual/pointer struct and we need to hydrate from
 	// blockstore before returning entries
+	if mst.cache != nil && mst.validPtr {
+		return mst.cache.getEntries(ctx, mst)
+	}
 	if mst.pointer != cid.Undef {
 		var nd nodeData
 		if err := mst.cst.Get(ctx, mst.pointer, &nd); err != nil {
@@ -195,7 +200,7 @@ func (mst *MerkleSearchTree) getEntries(ctx context.Context) ([]nodeEntry, error
 		}
 		// NOTE(bnewbold): Typescript version computes layer in-place here, but
 		// the entriesFromNodeData() helper does that for us in golang
-		entries, err := entriesFromNodeData(ctx, &nd, mst.cst)
+		entries, err := entriesFromNodeData(ctx, &nd, mst.cst, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -209,8 +214,9 @@ func (mst *MerkleSearchTree) getEntries(ctx context.Context) ([]nodeEntry, error
 	return nil, fmt.Errorf("no entries or self-pointer (CID) on MerkleSearchTree")
 }
 
-// golang-specific helper that calls in to deserializeNodeData
-func entriesFromNodeData(ctx context.Context, nd *nodeData, cst cbor.IpldStore) ([]nodeEntry, error) {
+// golang-specific helper that calls in to deserializeNodeData. nc is optional;
+// if set, entries are allocated from it, and subtrees will use it.
+func entriesFromNodeData(ctx context.Context, nd *nodeData, cst cbor.IpldStore, nc *NodeCache) ([]nodeEntry, error) {
 	layer := -1
 	if len(nd.Entries) > 0 {
 		// NOTE(bnewbold): can compute the layer on the first KeySuffix, because for the first entry that field is a complete key
@@ -218,7 +224,7 @@ func entriesFromNodeData(ctx context.Context, nd *nodeData, cst cbor.IpldStore)
 		layer = leadingZerosOnHashBytes(firstLeaf.KeySuffix)
 	}
 
-	entries, err := deserializeNodeData(ctx, cst, nd, layer)
+	entries, err := deserializeNodeData(ctx, cst, nd, layer, nc)
 	if err != nil {
 		return nil, err
 	}
@@ -605,7 +611,7 @@ func (mst *MerkleSearchTree) deleteRecurse(ctx context.Context, k string) (*Merk
 			if err != nil {
 				return nil, err
 			}
-			return mst.newTree(append(append(entries[:ix-1], mkTreeEntry(merged)), entries[ix+2:]...)), nil
+			return mst.newTree(append(append(entries[:ix-1:ix-1], mkTreeEntry(merged)), entries[ix+2:]...)), nil
 		} else {
 			return mst.removeEntry(ctx, ix)
 		}
@@ -884,9 +890,9 @@ func (mst *MerkleSearchTree) appendMerge(ctx context.Context, omst *MerkleSearch
 			return nil, err
 		}
 
-		return mst.newTree(append(append(entries[:len(entries)-1], mkTreeEntry(merged)), tomergeEnts[1:]...)), nil
+		return mst.newTree(append(append(entries[:len(entries)-1:len(entries)-1], mkTreeEntry(merged)), tomergeEnts[1:]...)), nil
 	} else {
-		return mst.newTree(append(entries, tomergeEnts...)), nil
+		return mst.newTree(append(entries[:len(entries):len(entries)], tomergeEnts...)), nil
 	}
 }
 
@@ -970,6 +976,49 @@ func (mst *MerkleSearchTree) WalkLeavesFrom(ctx context.Context, from string, cb
 	return nil
 }
 
+// TreeStats summarizes the shape of a tree, as returned by Stats.
+type TreeStats struct {
+	Nodes     int // number of tree nodes (blocks)
+	Leaves    int // number of key/value entries
+	Depth     int // number of nodes on the longest path from the root
+	MaxFanout int // largest number of entries (leaves and subtrees) in a single node
+}
+
+// golang-specific helper which loads every node of the tree and reports its shape
+func (mst *MerkleSearchTree) Stats(ctx context.Context) (*TreeStats, error) {
+	var st TreeStats
+	if err := mst.collectStats(ctx, 1, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (mst *MerkleSearchTree) collectStats(ctx context.Context, depth int, st *TreeStats) error {
+	entries, err := mst.getEntries(ctx)
+	if err != nil {
+		return fmt.Errorf("get entries: %w", err)
+	}
+
+	st.Nodes++
+	if depth > st.Depth {
+		st.Depth = depth
+	}
+	if len(entries) > st.MaxFanout {
+		st.MaxFanout = len(entries)
+	}
+
+	for _, e := range entries {
+		if e.isLeaf() {
+			st.Leaves++
+		} else if e.isTree() {
+			if err := e.Tree.collectStats(ctx, depth+1, st); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 // TODO: Typescript: MST.list(count?, after?, before?) -> Leaf[]
 // TODO: Typescript: MST.listWithPrefix(prefix, count?) -> Leaf[]
 
@@ -9,12 +9,15 @@ import (
 	"os"
 	"regexp"
 	"sort"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bluesky-social/indigo/util"
 	cid "github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/ipld/go-car/v2"
 	"github.com/multiformats/go-multihash"
 	mh "github.com/multiformats/go-multihash"
@@ -89,6 +92,39 @@ func TestBasicMst(t *testing.T) {
 	}
 }
 
+func TestStats(t *testing.T) {
+	ctx := context.Background()
+
+	empty := cidMapToMst(t, memBs(), map[string]cid.Cid{})
+	st, err := empty.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Nodes != 1 || st.Leaves != 0 || st.Depth != 1 {
+		t.Fatalf("unexpected stats for empty tree: %+v", st)
+	}
+
+	vals := make(map[string]cid.Cid)
+	for i := int64(0); i < 500; i++ {
+		vals[randKey(i)] = randCid()
+	}
+	bs := memBs()
+	mt := cidMapToMst(t, bs, vals)
+
+	// stats should be the same for a freshly loaded (un-hydrated) copy
+	loaded := LoadMST(util.CborStore(bs), mustCidTree(t, mt))
+	st, err = loaded.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Leaves != len(vals) {
+		t.Fatalf("expected %d leaves, got %d", len(vals), st.Leaves)
+	}
+	if st.Depth < 2 || st.Nodes < 2 || st.MaxFanout == 0 {
+		t.Fatalf("unexpected stats for 500 entry tree: %+v", st)
+	}
+}
+
 func assertValues(t *testing.T, mst *MerkleSearchTree, vals map[string]cid.Cid) {
 	out := make(map[string]cid.Cid)
 	if err := mst.WalkLeavesFrom(context.TODO(), "", func(key string, val cid.Cid) error {
@@ -426,6 +462,134 @@ func testMapDiffs(t testing.TB, a, b map[string]string) {
 		diffDiff(diffs, exp)
 		t.Fatal("diffs not equal")
 	}
+
+	// same again, through a (tiny) node cache
+	nc := NewNodeCache(util.CborStore(bs), 4)
+	diffs, err = nc.DiffTrees(context.TODO(), cida, cidb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !compareDiffs(diffs, exp) {
+		t.Fatal("diffs through node cache not equal")
+	}
+}
+
+func TestNodeCache(t *testing.T) {
+	ctx := context.TODO()
+	vals := map[string]cid.Cid{}
+	for i := 0; i < 2000; i++ {
+		vals[fmt.Sprintf("app.bsky.feed.post/%06d", i)] = randCid()
+	}
+	bs := memBs()
+	root := mustCidTree(t, cidMapToMst(t, bs, vals))
+
+	nc := NewNodeCache(util.CborStore(bs), 16)
+	tree := nc.LoadMST(root)
+	assertValues(t, tree, vals)
+	if nc.Len() > 16 {
+		t.Fatalf("node cache grew past its size: %d", nc.Len())
+	}
+	_, misses := nc.Stats()
+
+	// a second tree from the same root hits the cached top of the tree
+	if _, err := nc.LoadMST(root).Get(ctx, "app.bsky.feed.post/000000"); err != nil {
+		t.Fatal(err)
+	}
+	hits, _ := nc.Stats()
+	if hits == 0 {
+		t.Fatalf("expected node cache hits (misses: %d)", misses)
+	}
+
+	// changing a cached tree must not change the cached nodes
+	k := "app.bsky.feed.post/000100"
+	changed, err := tree.Delete(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := changed.Get(ctx, k); err == nil {
+		t.Fatal("expected deleted key to be missing")
+	}
+	if nroot := mustCidTree(t, changed); nroot == root {
+		t.Fatal("expected a new root after delete")
+	}
+	assertValues(t, nc.LoadMST(root), vals)
+
+	// after a reset, the cache reads from the new store
+	other := map[string]cid.Cid{"app.bsky.feed.like/aaaa": randCid()}
+	bs2 := memBs()
+	root2 := mustCidTree(t, cidMapToMst(t, bs2, other))
+	nc.Reset(util.CborStore(bs2))
+	if nc.Len() != 0 {
+		t.Fatal("expected empty node cache after reset")
+	}
+	assertValues(t, nc.LoadMST(root2), other)
+	// trees loaded before the reset still work
+	assertValues(t, tree, vals)
+}
+
+func TestNodeCacheConcurrentReset(t *testing.T) {
+	ctx := context.TODO()
+	vals := map[string]cid.Cid{}
+	for i := 0; i < 500; i++ {
+		vals[fmt.Sprintf("app.bsky.feed.post/%06d", i)] = randCid()
+	}
+	bs := memBs()
+	root := mustCidTree(t, cidMapToMst(t, bs, vals))
+	// distinct stores with the same blocks, as for consecutive imports
+	stores := []cbor.IpldStore{util.CborStore(bs), util.CborStore(bs)}
+
+	nc := NewNodeCache(stores[0], 64)
+	count := func(tree *MerkleSearchTree) error {
+		n := 0
+		if err := tree.WalkLeavesFrom(ctx, "", func(key string, val cid.Cid) error {
+			if vals[key] != val {
+				return fmt.Errorf("wrong value for %s", key)
+			}
+			n++
+			return nil
+		}); err != nil {
+			return err
+		}
+		if n != len(vals) {
+			return fmt.Errorf("walked %d keys, expected %d", n, len(vals))
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// a tree from before the resets, read alongside fresh ones
+			old := nc.LoadMST(root)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, tree := range []*MerkleSearchTree{nc.LoadMST(root), old} {
+					if err := count(tree); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		nc.Reset(stores[i%2])
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
 }
 
 func diffDiff(a, b []*DiffOp) {
@@ -600,6 +764,39 @@ func BenchmarkDiffTrees(b *testing.B) {
 	}
 }
 
+func BenchmarkDiffTreesNodeCache(b *testing.B) {
+	b.ReportAllocs()
+	const size = 10000
+	ma := map[string]string{}
+	for i := 0; i < size; i++ {
+		ma[fmt.Sprintf("num/%02d", i)] = fmt.Sprint(i)
+	}
+	mb := maps.Clone(ma)
+	for i := 0; i < size/2; i++ {
+		switch i % 4 {
+		case 0, 1:
+		case 2:
+			delete(mb, fmt.Sprintf("num/%02d", i))
+		case 3:
+			ma[fmt.Sprintf("num/%02d", i)] = fmt.Sprint(i + 1)
+		}
+	}
+
+	bs := memBs()
+	cida := mustCidTree(b, cidMapToMst(b, bs, mapToCidMap(ma)))
+	cidb := mustCidTree(b, cidMapToMst(b, bs, mapToCidMap(mb)))
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		// a fresh cache each time, as for importing a new repo
+		nc := NewNodeCache(util.CborStore(bs), 1000)
+		if _, err := nc.DiffTrees(context.TODO(), cida, cidb); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 var countPrefixLenTests = []struct {
 	a, b string
 	want int
@@ -66,13 +66,43 @@ func layerForEntries(entries []nodeEntry) int {
 }
 
 // Typescript: deserializeNodeData(storage, data, layer)
-func deserializeNodeData(ctx context.Context, cst cbor.IpldStore, nd *nodeData, layer int) ([]nodeEntry, error) {
-	entries := []nodeEntry{}
+func deserializeNodeData(ctx context.Context, cst cbor.IpldStore, nd *nodeData, layer int, nc *NodeCache) ([]nodeEntry, error) {
+	var entries []nodeEntry
+	if nc != nil {
+		n := len(nd.Entries)
+		if nd.Left != nil {
+			n++
+		}
+		for _, e := range nd.Entries {
+			if e.Tree != nil {
+				n++
+			}
+		}
+		entries = nc.slab.allocEntries(n)
+	} else {
+		entries = []nodeEntry{}
+	}
+	subtree := func(ptr cid.Cid) *MerkleSearchTree {
+		if nc == nil {
+			return createMST(cst, ptr, nil, layer-1)
+		}
+		t := nc.slab.allocTree()
+		*t = MerkleSearchTree{
+			cst:      cst,
+			pointer:  ptr,
+			layer:    layer - 1,
+			validPtr: ptr.Defined(),
+			cache:    nc,
+			cacheGen: nc.gen.Load(),
+		}
+		return t
+	}
+
 	if nd.Left != nil {
 		// Note: like Typescript, this is actually a lazy load
 		entries = append(entries, nodeEntry{
 			Kind: entryTree,
-			Tree: createMST(cst, *nd.Left, nil, layer-1),
+			Tree: subtree(*nd.Left),
 		})
 	}
 
@@ -85,7 +115,12 @@ func deserializeNodeData(ctx context.Context, cst cbor.IpldStore, nd *nodeData,
 		keyb = append(keyb[:0], lastKey[:e.PrefixLen]...)
 		keyb = append(keyb, e.KeySuffix...)
 
-		keyStr := string(keyb)
+		var keyStr string
+		if nc != nil {
+			keyStr = nc.slab.internKey(keyb)
+		} else {
+			keyStr = string(keyb)
+		}
 		err := ensureValidMstKey(keyStr)
 		if err != nil {
 			return nil, err
@@ -100,7 +135,7 @@ func deserializeNodeData(ctx context.Context, cst cbor.IpldStore, nd *nodeData,
 		if e.Tree != nil {
 			entries = append(entries, nodeEntry{
 				Kind: entryTree,
-				Tree: createMST(cst, *e.Tree, nil, layer-1),
+				Tree: subtree(*e.Tree),
 				Key:  keyStr,
 			})
 		}
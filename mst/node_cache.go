@@ -0,0 +1,215 @@
+package mst
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+)
+
+// number of entries, subtree structs, and key bytes allocated at a time by a
+// NodeCache. Larger requests get their own allocation.
+const (
+	slabEntries  = 4096
+	slabTrees    = 1024
+	slabKeyBytes = 64 * 1024
+)
+
+// NodeCache is a bounded cache of decoded MST nodes, for bulk operations
+// (diffs, walks, imports) over large repos.
+//
+// Trees loaded through the cache don't hold on to their decoded entries;
+// the cache keeps the most recently used nodes, up to its size, and the rest
+// are decoded again if needed. Decoded entries, subtree pointers, and keys are
+// allocated in large chunks rather than one at a time, which greatly reduces
+// the number of objects the garbage collector has to track.
+//
+// A NodeCache reads from a single store at a time. It can be re-used for the
+// next repo by calling Reset, so a long-running backfiller needs only one per
+// worker. Trees loaded from the cache are meant for reading: mutations work,
+// but the resulting trees aren't cached. Trees can be read concurrently,
+// including while the cache is Reset.
+type NodeCache struct {
+	nodes *lru.Cache[cid.Cid, []nodeEntry]
+
+	lk   sync.Mutex
+	cst  cbor.IpldStore
+	slab nodeSlab
+	// gen counts resets; trees only use the cache in the generation they
+	// were loaded in. Written with lk held.
+	gen atomic.Uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// NewNodeCache creates a cache of up to size decoded nodes from cst
+func NewNodeCache(cst cbor.IpldStore, size int) *NodeCache {
+	if size <= 0 {
+		size = 10_000
+	}
+	nodes, err := lru.New[cid.Cid, []nodeEntry](size)
+	if err != nil {
+		// only possible with a non-positive size
+		panic(err)
+	}
+	return &NodeCache{cst: cst, nodes: nodes}
+}
+
+// LoadMST is like the package-level LoadMST, with the tree's nodes decoded
+// through the cache
+func (nc *NodeCache) LoadMST(root cid.Cid) *MerkleSearchTree {
+	nc.lk.Lock()
+	mst := createMST(nc.cst, root, nil, -1)
+	mst.cacheGen = nc.gen.Load()
+	nc.lk.Unlock()
+	mst.cache = nc
+	return mst
+}
+
+// Reset empties the cache and switches it to reading from cst. Trees loaded
+// before the reset keep working, without the cache: their nodes are decoded
+// again on every read.
+func (nc *NodeCache) Reset(cst cbor.IpldStore) {
+	nc.lk.Lock()
+	defer nc.lk.Unlock()
+	nc.nodes.Purge()
+	// chunks still in use by earlier trees are left to them
+	nc.slab = nodeSlab{}
+	nc.cst = cst
+	nc.gen.Add(1)
+}
+
+// Len returns the number of nodes in the cache
+func (nc *NodeCache) Len() int {
+	return nc.nodes.Len()
+}
+
+// Stats returns the number of cache hits and misses so far
+func (nc *NodeCache) Stats() (hits, misses int64) {
+	return nc.hits.Load(), nc.misses.Load()
+}
+
+// NodeCachePool hands out node caches to concurrent bulk operations (eg,
+// repo imports), so each has a cache of its own and the caches' allocations
+// are re-used from one operation to the next. The zero value is ready to use.
+type NodeCachePool struct {
+	// Size of each cache, or the NewNodeCache default if zero
+	Size int
+
+	pool   sync.Pool
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// Get returns an empty cache; Reset it to the store it's to read from
+func (p *NodeCachePool) Get() *NodeCache {
+	if nc, ok := p.pool.Get().(*NodeCache); ok {
+		return nc
+	}
+	return NewNodeCache(nil, p.Size)
+}
+
+// Put returns a cache to the pool once its trees are no longer used
+func (p *NodeCachePool) Put(nc *NodeCache) {
+	p.hits.Add(nc.hits.Swap(0))
+	p.misses.Add(nc.misses.Swap(0))
+	// don't keep the last store's blocks around
+	nc.Reset(nil)
+	p.pool.Put(nc)
+}
+
+// Stats returns the number of cache hits and misses of the caches put back
+// in the pool so far
+func (p *NodeCachePool) Stats() (hits, misses int64) {
+	return p.hits.Load(), p.misses.Load()
+}
+
+// getEntries returns a cache-backed tree's entries. Those trees (and their
+// entries) are shared between readers, so entries aren't stored on the tree:
+// they come from the cache or, for trees from before a Reset, are decoded
+// afresh.
+func (nc *NodeCache) getEntries(ctx context.Context, mst *MerkleSearchTree) ([]nodeEntry, error) {
+	current := func() bool { return nc.gen.Load() == mst.cacheGen }
+	if current() {
+		// checked again, as entries added after a Reset read from the new store
+		if entries, ok := nc.nodes.Get(mst.pointer); ok && current() {
+			nc.hits.Add(1)
+			return entries, nil
+		}
+	}
+	nc.misses.Add(1)
+
+	var nd nodeData
+	if err := mst.cst.Get(ctx, mst.pointer, &nd); err != nil {
+		return nil, err
+	}
+
+	nc.lk.Lock()
+	defer nc.lk.Unlock()
+	if !current() {
+		return entriesFromNodeData(ctx, &nd, mst.cst, nil)
+	}
+	entries, err := entriesFromNodeData(ctx, &nd, mst.cst, nc)
+	if err != nil {
+		return nil, err
+	}
+	nc.nodes.Add(mst.pointer, entries)
+	return entries, nil
+}
+
+// nodeSlab hands out pieces of larger allocations. Callers must hold the
+// NodeCache lock.
+type nodeSlab struct {
+	entries []nodeEntry
+	trees   []MerkleSearchTree
+	keys    []byte
+}
+
+// allocEntries returns an empty slice with capacity for exactly n entries, so
+// appending past n (eg, by a caller modifying a copy) never overwrites the
+// next node's entries
+func (s *nodeSlab) allocEntries(n int) []nodeEntry {
+	if n == 0 {
+		return []nodeEntry{}
+	}
+	if n > len(s.entries) {
+		if n > slabEntries/4 {
+			return make([]nodeEntry, 0, n)
+		}
+		s.entries = make([]nodeEntry, slabEntries)
+	}
+	out := s.entries[:0:n]
+	s.entries = s.entries[n:]
+	return out
+}
+
+func (s *nodeSlab) allocTree() *MerkleSearchTree {
+	if len(s.trees) == 0 {
+		s.trees = make([]MerkleSearchTree, slabTrees)
+	}
+	t := &s.trees[0]
+	s.trees = s.trees[1:]
+	return t
+}
+
+// internKey copies b to a string backed by the slab
+func (s *nodeSlab) internKey(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	if len(b) > len(s.keys) {
+		if len(b) > slabKeyBytes/4 {
+			return string(b)
+		}
+		s.keys = make([]byte, slabKeyBytes)
+	}
+	n := copy(s.keys, b)
+	str := unsafe.String(&s.keys[0], n)
+	s.keys = s.keys[n:]
+	return str
+}
@@ -0,0 +1,54 @@
+package pds
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SetAdminPassword turns on the admin XRPC routes, which authenticate with
+// HTTP basic auth as user "admin". Without a password they are unavailable.
+func (s *Server) SetAdminPassword(pw string) {
+	s.adminPassword = pw
+}
+
+// isAdminRoute reports whether the route accepts admin auth
+func isAdminRoute(path string) bool {
+	switch path {
+	case "/xrpc/com.atproto.server.createInviteCode", "/xrpc/com.atproto.server.createInviteCodes":
+		return true
+	}
+	return strings.HasPrefix(path, "/xrpc/com.atproto.admin.") || strings.HasPrefix(path, "/admin/")
+}
+
+// checkAdminAuth reports whether the request carries the admin password
+func (s *Server) checkAdminAuth(c echo.Context) bool {
+	if s.adminPassword == "" {
+		return false
+	}
+
+	authz := c.Request().Header.Get("Authorization")
+	if !strings.HasPrefix(authz, "Basic ") {
+		return false
+	}
+	creds, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authz, "Basic "))
+	if err != nil {
+		return false
+	}
+	user, pw, ok := strings.Cut(string(creds), ":")
+	if !ok || user != "admin" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(pw), []byte(s.adminPassword)) == 1
+}
+
+func (s *Server) requireAdmin(ctx context.Context) error {
+	if admin, _ := ctx.Value("admin").(bool); !admin {
+		return &XRPCError{Status: http.StatusUnauthorized, Name: "AuthenticationRequired", Message: "this route requires admin auth"}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package pds
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/models"
+	"gorm.io/gorm"
+)
+
+// appPasswordScope is the access scope of sessions created with an app
+// password. They can do everything a full session can except manage the
+// account.
+const appPasswordScope = "com.atproto.appPass"
+
+// AppPassword is a named, revocable password a user can hand to a third
+// party client instead of their account password
+type AppPassword struct {
+	gorm.Model
+	Uid          models.Uid `gorm:"uniqueIndex:idx_app_password_uid_name"`
+	Name         string     `gorm:"uniqueIndex:idx_app_password_uid_name"`
+	PasswordHash string
+}
+
+const appPasswordChars = "abcdefghijklmnopqrstuvwxyz234567"
+
+// app passwords look like "abcd-efgh-ijkl-mnop"
+func generateAppPassword() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	var sb strings.Builder
+	for i, c := range b {
+		if i > 0 && i%4 == 0 {
+			sb.WriteByte('-')
+		}
+		sb.WriteByte(appPasswordChars[int(c)%len(appPasswordChars)])
+	}
+	return sb.String()
+}
+
+func hashAppPassword(pw string) string {
+	h := sha256.Sum256([]byte(pw))
+	return hex.EncodeToString(h[:])
+}
+
+// checkAppPassword returns the name of the user's app password matching pw,
+// or "" if there isn't one
+func (s *Server) checkAppPassword(ctx context.Context, u *User, pw string) (string, error) {
+	var aps []AppPassword
+	if err := s.db.Find(&aps, "uid = ?", u.ID).Error; err != nil {
+		return "", err
+	}
+
+	hash := []byte(hashAppPassword(pw))
+	for _, ap := range aps {
+		if subtle.ConstantTimeCompare(hash, []byte(ap.PasswordHash)) == 1 {
+			return ap.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// appPasswordExists is checked on every request made with an app password
+// session, so revoking the password ends its sessions
+func (s *Server) appPasswordExists(ctx context.Context, u *User, name string) (bool, error) {
+	var count int64
+	if err := s.db.Model(AppPassword{}).Where("uid = ? AND name = ?", u.ID, name).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s *Server) handleComAtprotoServerCreateAppPassword(ctx context.Context, body *comatprototypes.ServerCreateAppPassword_Input) (*comatprototypes.ServerCreateAppPassword_AppPassword, error) {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "app password name is required"}
+	}
+
+	existing, err := s.appPasswordExists(ctx, u, name)
+	if err != nil {
+		return nil, err
+	}
+	if existing {
+		return nil, &XRPCError{Status: 400, Name: "DuplicateName", Message: "an app password with that name already exists"}
+	}
+
+	pw := generateAppPassword()
+	ap := AppPassword{
+		Uid:          u.ID,
+		Name:         name,
+		PasswordHash: hashAppPassword(pw),
+	}
+	if err := s.db.Create(&ap).Error; err != nil {
+		return nil, err
+	}
+
+	return &comatprototypes.ServerCreateAppPassword_AppPassword{
+		Name:      ap.Name,
+		Password:  pw,
+		CreatedAt: ap.CreatedAt.Format(time.RFC3339),
+	}, nil
+}
+
+func (s *Server) handleComAtprotoServerListAppPasswords(ctx context.Context) (*comatprototypes.ServerListAppPasswords_Output, error) {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var aps []AppPassword
+	if err := s.db.Order("created_at desc").Find(&aps, "uid = ?", u.ID).Error; err != nil {
+		return nil, err
+	}
+
+	out := &comatprototypes.ServerListAppPasswords_Output{
+		Passwords: []*comatprototypes.ServerListAppPasswords_AppPassword{},
+	}
+	for _, ap := range aps {
+		out.Passwords = append(out.Passwords, &comatprototypes.ServerListAppPasswords_AppPassword{
+			Name:      ap.Name,
+			CreatedAt: ap.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return out, nil
+}
+
+func (s *Server) handleComAtprotoServerRevokeAppPassword(ctx context.Context, body *comatprototypes.ServerRevokeAppPassword_Input) error {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	// hard delete, so the name can be reused
+	res := s.db.Unscoped().Where("uid = ? AND name = ?", u.ID, body.Name).Delete(&AppPassword{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return &XRPCError{Status: 400, Name: "InvalidRequest", Message: "no app password with that name"}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package pds
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+func TestAppPasswords(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := "http://" + ln.Addr().String()
+	go s.RunAPIWithListener(ln)
+	defer s.Shutdown(context.Background())
+
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    "test@foo.com",
+		Password: "password",
+		Handle:   "apppass.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(context.Background(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userCtx := context.WithValue(context.Background(), "user", u)
+
+	ap, err := s.handleComAtprotoServerCreateAppPassword(userCtx, &atproto.ServerCreateAppPassword_Input{Name: "my client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.handleComAtprotoServerCreateAppPassword(userCtx, &atproto.ServerCreateAppPassword_Input{Name: "my client"}); err == nil {
+		t.Fatal("expected a duplicate name to be rejected")
+	}
+	list, err := s.handleComAtprotoServerListAppPasswords(userCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Passwords) != 1 || list.Passwords[0].Name != "my client" {
+		t.Fatalf("unexpected app passwords: %v", list.Passwords)
+	}
+
+	sess, err := s.handleComAtprotoServerCreateSession(context.Background(), &atproto.ServerCreateSession_Input{
+		Identifier: o.Handle,
+		Password:   ap.Password,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.handleComAtprotoServerCreateSession(context.Background(), &atproto.ServerCreateSession_Input{
+		Identifier: o.Handle,
+		Password:   "abcd-efgh-ijkl-mnop",
+	}); err != ErrInvalidUsernameOrPassword {
+		t.Fatalf("expected ErrInvalidUsernameOrPassword, got %v", err)
+	}
+
+	callWith := func(method, path, token string, out any) int {
+		req, _ := http.NewRequest(method, host+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if out != nil && resp.StatusCode == 200 {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+	call := func(path string) int {
+		return callWith("GET", path, sess.AccessJwt, nil)
+	}
+	if status := call("/xrpc/com.atproto.server.getSession"); status != 200 {
+		t.Fatalf("getSession with an app password session failed: %d", status)
+	}
+	if status := call("/xrpc/com.atproto.server.listAppPasswords"); status != http.StatusForbidden {
+		t.Fatalf("expected account management to be denied, got %d", status)
+	}
+
+	// refresh tokens are only good for refreshing
+	if status := callWith("GET", "/xrpc/com.atproto.server.listAppPasswords", sess.RefreshJwt, nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected a refresh token to be rejected for account management, got %d", status)
+	}
+
+	// a refreshed app password session is still limited
+	var refreshed atproto.ServerRefreshSession_Output
+	if status := callWith("POST", "/xrpc/com.atproto.server.refreshSession", sess.RefreshJwt, &refreshed); status != 200 {
+		t.Fatalf("refreshSession failed: %d", status)
+	}
+	if status := callWith("GET", "/xrpc/com.atproto.server.getSession", refreshed.AccessJwt, nil); status != 200 {
+		t.Fatalf("getSession with a refreshed app password session failed: %d", status)
+	}
+	if status := callWith("GET", "/xrpc/com.atproto.server.listAppPasswords", refreshed.AccessJwt, nil); status != http.StatusForbidden {
+		t.Fatalf("expected account management to be denied after refresh, got %d", status)
+	}
+	if status := callWith("POST", "/xrpc/com.atproto.server.refreshSession", sess.RefreshJwt, nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected a used refresh token to be rejected, got %d", status)
+	}
+
+	if err := s.handleComAtprotoServerRevokeAppPassword(userCtx, &atproto.ServerRevokeAppPassword_Input{Name: "my client"}); err != nil {
+		t.Fatal(err)
+	}
+	if status := call("/xrpc/com.atproto.server.getSession"); status != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked app password session to be rejected, got %d", status)
+	}
+	if status := callWith("POST", "/xrpc/com.atproto.server.refreshSession", refreshed.RefreshJwt, nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked app password session not to refresh, got %d", status)
+	}
+}
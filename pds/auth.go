@@ -5,11 +5,18 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/bluesky-social/indigo/xrpc"
+
+	gojwt "github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
 	"github.com/lestrrat-go/jwx/v2/jwa"
 	"github.com/lestrrat-go/jwx/v2/jwt"
+	"gorm.io/gorm"
 )
 
 func makeToken(subject string, scope string, exp time.Time) jwt.Token {
@@ -23,8 +30,23 @@ func makeToken(subject string, scope string, exp time.Time) jwt.Token {
 }
 
 func (s *Server) createAuthTokenForUser(ctx context.Context, handle, did string) (*xrpc.AuthInfo, error) {
-	accessTok := makeToken(did, "com.atproto.access", time.Now().Add(24*time.Hour))
-	refreshTok := makeToken(did, "com.atproto.refresh", time.Now().Add(7*24*time.Hour))
+	return s.createSessionTokens(ctx, handle, did, "com.atproto.access", "")
+}
+
+// createAppPasswordTokenForUser creates a session limited to
+// appPasswordScope. The tokens carry the app password's name so the session
+// ends when it is revoked.
+func (s *Server) createAppPasswordTokenForUser(ctx context.Context, handle, did, appPassword string) (*xrpc.AuthInfo, error) {
+	return s.createSessionTokens(ctx, handle, did, appPasswordScope, appPassword)
+}
+
+func (s *Server) createSessionTokens(ctx context.Context, handle, did, scope, appPassword string) (*xrpc.AuthInfo, error) {
+	accessTok := makeToken(did, scope, time.Now().Add(24*time.Hour))
+	refreshTok := makeToken(did, refreshTokenScope, time.Now().Add(7*24*time.Hour))
+	if appPassword != "" {
+		accessTok.Set("appPassword", appPassword)
+		refreshTok.Set("appPassword", appPassword)
+	}
 
 	rval := make([]byte, 10)
 	rand.Read(rval)
@@ -67,3 +89,105 @@ func (s *Server) createCrossServerAuthToken(ctx context.Context, otherpds string
 		AccessJwt: string(accSig),
 	}, nil
 }
+
+// refreshTokenScope is the scope of refresh tokens, which are only good for
+// refreshTokenRoutes
+const refreshTokenScope = "com.atproto.refresh"
+
+var refreshTokenRoutes = map[string]bool{
+	"/xrpc/com.atproto.server.refreshSession": true,
+	"/xrpc/com.atproto.server.deleteSession":  true,
+}
+
+// RevokedToken is a refresh token which can't be used again (refresh tokens
+// are single use), kept until it would have expired anyway
+type RevokedToken struct {
+	gorm.Model
+	Jti       string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+}
+
+// invalidateToken revokes a refresh token, failing if it already was
+func (s *Server) invalidateToken(ctx context.Context, u *User, tok *gojwt.Token) error {
+	claims, ok := tok.Claims.(gojwt.MapClaims)
+	if !ok {
+		return fmt.Errorf("invalid token claims map")
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return fmt.Errorf("refresh token has no jti")
+	}
+	exp, err := toTime(claims["exp"])
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error; err != nil {
+		return err
+	}
+	// jti is unique, so only one of several concurrent refreshes succeeds
+	if err := s.db.Create(&RevokedToken{Jti: jti, ExpiresAt: exp}).Error; err != nil {
+		if revoked, rerr := s.tokenRevoked(ctx, tok); rerr == nil && revoked {
+			return echo.NewHTTPError(http.StatusUnauthorized, "refresh token has already been used")
+		}
+		return err
+	}
+	return nil
+}
+
+// tokenRevoked is whether a refresh token has been used already
+func (s *Server) tokenRevoked(ctx context.Context, tok *gojwt.Token) (bool, error) {
+	claims, ok := tok.Claims.(gojwt.MapClaims)
+	if !ok {
+		return false, fmt.Errorf("invalid token claims map")
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return false, nil
+	}
+	var count int64
+	if err := s.db.Model(RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// accountManagementRoutes can't be used with OAuth scopes or app
+// passwords, only with a full session
+var accountManagementRoutes = map[string]bool{
+	"/xrpc/com.atproto.server.createAppPassword":    true,
+	"/xrpc/com.atproto.server.listAppPasswords":     true,
+	"/xrpc/com.atproto.server.revokeAppPassword":    true,
+	"/xrpc/com.atproto.server.deleteAccount":        true,
+	"/xrpc/com.atproto.server.requestAccountDelete": true,
+	"/xrpc/com.atproto.server.requestEmailUpdate":   true,
+	"/xrpc/com.atproto.server.updateEmail":          true,
+	"/xrpc/com.atproto.server.createInviteCode":     true,
+	"/xrpc/com.atproto.server.createInviteCodes":    true,
+	"/xrpc/com.atproto.server.getServiceAuth":       true,
+	"/xrpc/com.atproto.server.deactivateAccount":    true,
+	"/xrpc/com.atproto.server.activateAccount":      true,
+}
+
+// scopeAllowsRoute reports whether a token with the given scope may call
+// route. App password sessions can't manage the account. OAuth scopes,
+// which always include "atproto", are limited further: "transition:generic"
+// grants everything but account management, and "atproto" alone only
+// identifies the account.
+func scopeAllowsRoute(scope, route string) bool {
+	if scope == appPasswordScope {
+		return !accountManagementRoutes[route]
+	}
+
+	scopes := strings.Fields(scope)
+	if !slices.Contains(scopes, "atproto") {
+		return true
+	}
+	if accountManagementRoutes[route] || strings.HasPrefix(route, "/xrpc/com.atproto.admin.") {
+		return false
+	}
+	if slices.Contains(scopes, "transition:generic") {
+		return true
+	}
+	return route == "/xrpc/com.atproto.server.getSession"
+}
@@ -0,0 +1,338 @@
+package pds
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bluesky-social/indigo/blobs"
+	"github.com/bluesky-social/indigo/models"
+	"gorm.io/gorm"
+)
+
+// Backups are kept in a StreamingBlobStore, under each account's DID with
+// object names in place of CIDs:
+//
+//	<did>/repo-<rev>.car       the signed repo
+//	<did>/latest.json          manifest of the newest backup
+//	_index/accounts.json       every DID that has been backed up
+//
+// Blobs aren't copied; the manifest records which blobs the account had, so
+// a restore can check they are still in the blob store.
+const (
+	backupLatestName = "latest.json"
+	backupIndexDid   = "_index"
+	backupIndexName  = "accounts.json"
+)
+
+// BackupManifest describes one backup of an account
+type BackupManifest struct {
+	Did       string       `json:"did"`
+	Handle    string       `json:"handle"`
+	Email     string       `json:"email"`
+	Rev       string       `json:"rev"`
+	Root      string       `json:"root"`
+	Car       string       `json:"car"`
+	CreatedAt string       `json:"createdAt"`
+	Blobs     []BackupBlob `json:"blobs"`
+}
+
+type BackupBlob struct {
+	Cid      string `json:"cid"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+}
+
+// RepoBackup records a backup this server made, so unchanged repos aren't
+// backed up again
+type RepoBackup struct {
+	gorm.Model
+	Uid models.Uid `gorm:"index"`
+	Rev string
+	Car string
+}
+
+// SetBackupStore sets where account backups are written to and restored
+// from
+func (s *Server) SetBackupStore(bs blobs.StreamingBlobStore) {
+	s.backups = bs
+}
+
+// RunBackups backs up every account each interval until ctx is done
+func (s *Server) RunBackups(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := s.BackupAll(ctx); err != nil {
+			log.Errorw("backup run failed", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// BackupAll backs up every account whose repo changed since its last backup
+func (s *Server) BackupAll(ctx context.Context) error {
+	if s.backups == nil {
+		return fmt.Errorf("no backup store configured")
+	}
+
+	var users []User
+	if err := s.db.Find(&users).Error; err != nil {
+		return err
+	}
+
+	var dids []string
+	var failed int
+	for i := range users {
+		u := &users[i]
+		if u.Did == "" {
+			continue
+		}
+		dids = append(dids, u.Did)
+
+		if _, err := s.backupAccount(ctx, u); err != nil {
+			log.Errorw("failed to back up account", "did", u.Did, "err", err)
+			failed++
+		}
+	}
+
+	idx, err := json.Marshal(dids)
+	if err != nil {
+		return err
+	}
+	if err := s.backups.PutBlob(ctx, backupIndexName, backupIndexDid, idx); err != nil {
+		return fmt.Errorf("writing backup index: %w", err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d accounts failed to back up", failed, len(dids))
+	}
+	return nil
+}
+
+// backupAccount writes the account's repo and manifest to the backup store.
+// It returns false if the repo hasn't changed since its last backup.
+func (s *Server) backupAccount(ctx context.Context, u *User) (bool, error) {
+	rev, err := s.repoman.GetRepoRev(ctx, u.ID)
+	if err != nil {
+		return false, fmt.Errorf("getting repo rev: %w", err)
+	}
+	if rev == "" {
+		// no repo yet, eg an account still migrating in
+		return false, nil
+	}
+
+	var last RepoBackup
+	if err := s.db.Order("id desc").Limit(1).Find(&last, "uid = ?", u.ID).Error; err != nil {
+		return false, err
+	}
+	if last.ID != 0 && last.Rev == rev {
+		return false, nil
+	}
+
+	root, err := s.repoman.GetRepoRoot(ctx, u.ID)
+	if err != nil {
+		return false, fmt.Errorf("getting repo root: %w", err)
+	}
+
+	// spool the CAR to disk, since the store wants its size up front
+	tmp, err := os.CreateTemp("", "pds-backup-")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := s.repoman.ReadRepo(ctx, u.ID, "", tmp); err != nil {
+		return false, fmt.Errorf("reading repo: %w", err)
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	carName := "repo-" + rev + ".car"
+	if err := s.backups.PutBlobStream(ctx, carName, u.Did, tmp, size); err != nil {
+		return false, fmt.Errorf("writing repo backup: %w", err)
+	}
+
+	var ubs []Blob
+	if err := s.db.Order("id asc").Find(&ubs, "uid = ?", u.ID).Error; err != nil {
+		return false, err
+	}
+	man := BackupManifest{
+		Did:       u.Did,
+		Handle:    u.Handle,
+		Email:     u.Email,
+		Rev:       rev,
+		Root:      root.String(),
+		Car:       carName,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Blobs:     []BackupBlob{},
+	}
+	for _, b := range ubs {
+		man.Blobs = append(man.Blobs, BackupBlob{Cid: b.Cid, MimeType: b.MimeType, Size: b.Size})
+	}
+
+	mb, err := json.Marshal(man)
+	if err != nil {
+		return false, err
+	}
+	if err := s.backups.PutBlob(ctx, backupLatestName, u.Did, mb); err != nil {
+		return false, fmt.Errorf("writing backup manifest: %w", err)
+	}
+
+	if err := s.db.Create(&RepoBackup{Uid: u.ID, Rev: rev, Car: carName}).Error; err != nil {
+		return false, err
+	}
+	log.Infow("backed up account", "did", u.Did, "rev", rev, "size", size, "blobs", len(man.Blobs))
+	return true, nil
+}
+
+// BackupAccountDids lists the accounts in the backup store
+func (s *Server) BackupAccountDids(ctx context.Context) ([]string, error) {
+	if s.backups == nil {
+		return nil, fmt.Errorf("no backup store configured")
+	}
+
+	b, err := s.backups.GetBlob(ctx, backupIndexName, backupIndexDid)
+	if err != nil {
+		return nil, fmt.Errorf("reading backup index: %w", err)
+	}
+	var dids []string
+	if err := json.Unmarshal(b, &dids); err != nil {
+		return nil, fmt.Errorf("parsing backup index: %w", err)
+	}
+	return dids, nil
+}
+
+// RestoreAccount recreates an account that this server has lost from its
+// latest backup. The account's password isn't backed up, so the restored
+// account has a random one and its owner has to reset it by email.
+func (s *Server) RestoreAccount(ctx context.Context, did string) error {
+	if s.backups == nil {
+		return fmt.Errorf("no backup store configured")
+	}
+
+	if _, err := s.lookupUserByDid(ctx, did); err == nil {
+		return fmt.Errorf("account %s already exists", did)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	mb, err := s.backups.GetBlob(ctx, backupLatestName, did)
+	if err != nil {
+		return fmt.Errorf("reading backup manifest: %w", err)
+	}
+	var man BackupManifest
+	if err := json.Unmarshal(mb, &man); err != nil {
+		return fmt.Errorf("parsing backup manifest: %w", err)
+	}
+	if man.Did != did {
+		return fmt.Errorf("backup manifest is for %s, not %s", man.Did, did)
+	}
+
+	pw := make([]byte, 32)
+	rand.Read(pw)
+	u := User{
+		Handle:   man.Handle,
+		Email:    man.Email,
+		Did:      man.Did,
+		Password: hex.EncodeToString(pw),
+	}
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&u).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.ActorInfo{
+			Uid:    u.ID,
+			Did:    u.Did,
+			Handle: sql.NullString{String: u.Handle, Valid: true},
+		}).Error
+	}); err != nil {
+		return err
+	}
+
+	// the repo goes in the carstore, which needn't share a database with
+	// the accounts, so a failed restore is undone rather than rolled back:
+	// otherwise the half restored account would block restoring it again
+	missing, err := s.restoreAccountData(ctx, &u, &man)
+	if err != nil {
+		if cerr := s.removeRestoredAccount(ctx, &u); cerr != nil {
+			log.Errorw("failed to clean up after failed restore", "did", did, "err", cerr)
+		}
+		return err
+	}
+	log.Infow("restored account from backup", "did", did, "rev", man.Rev, "backedUpAt", man.CreatedAt, "blobs", len(man.Blobs), "missingBlobs", missing)
+	return nil
+}
+
+// restoreAccountData imports a restored account's repo and blobs, returning
+// how many of its blobs are missing from the blob store
+func (s *Server) restoreAccountData(ctx context.Context, u *User, man *BackupManifest) (int, error) {
+	car, err := s.backups.GetBlobStream(ctx, man.Car, u.Did)
+	if err != nil {
+		return 0, fmt.Errorf("reading repo backup: %w", err)
+	}
+	defer car.Close()
+	if err := s.repoman.ImportNewRepo(ctx, u.ID, u.Did, car, nil); err != nil {
+		return 0, fmt.Errorf("importing repo backup: %w", err)
+	}
+
+	var missing int
+	for _, b := range man.Blobs {
+		if s.blobstore == nil {
+			missing++
+			continue
+		}
+		r, err := s.blobstore.GetBlobStream(ctx, b.Cid, u.Did)
+		if err != nil {
+			if errors.Is(err, blobs.NotFoundErr) {
+				missing++
+				continue
+			}
+			return 0, fmt.Errorf("checking blob %s: %w", b.Cid, err)
+		}
+		r.Close()
+
+		if err := s.db.Create(&Blob{Uid: u.ID, Cid: b.Cid, MimeType: b.MimeType, Size: b.Size}).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	if err := s.db.Create(&RepoBackup{Uid: u.ID, Rev: man.Rev, Car: man.Car}).Error; err != nil {
+		return 0, err
+	}
+	return missing, nil
+}
+
+// removeRestoredAccount deletes what a failed restore left behind
+func (s *Server) removeRestoredAccount(ctx context.Context, u *User) error {
+	if err := s.cs.WipeUserData(ctx, u.ID); err != nil {
+		return fmt.Errorf("wiping repo: %w", err)
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range []any{&Blob{}, &RepoBackup{}, &models.ActorInfo{}} {
+			if err := tx.Unscoped().Where("uid = ?", u.ID).Delete(m).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Delete(u).Error
+	})
+}
@@ -0,0 +1,141 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/blobs"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/util"
+	"gorm.io/gorm"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	bs := &blobs.DiskBlobStore{Dir: t.TempDir()}
+	backups := &blobs.DiskBlobStore{Dir: t.TempDir()}
+	s.SetBlobStore(bs)
+	s.SetBackupStore(backups)
+
+	bg := context.Background()
+	o, err := s.handleComAtprotoServerCreateAccount(bg, &atproto.ServerCreateAccount_Input{
+		Email:    "test@foo.com",
+		Password: "password",
+		Handle:   "backedup.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(bg, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(bg, "user", u)
+
+	up, err := s.handleComAtprotoRepoUploadBlob(ctx, bytes.NewReader([]byte("a picture")), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, err := s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{
+		Collection: "app.bsky.feed.post",
+		Repo:       o.Did,
+		Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{
+			Text:      "don't lose this",
+			CreatedAt: time.Now().Format(time.RFC3339),
+			Embed: &bsky.FeedPost_Embed{EmbedImages: &bsky.EmbedImages{
+				Images: []*bsky.EmbedImages_Image{{Alt: "pic", Image: up.Blob}},
+			}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.BackupAll(bg); err != nil {
+		t.Fatal(err)
+	}
+	if did, err := s.backupAccount(bg, u); err != nil || did {
+		t.Fatalf("expected an unchanged repo not to be backed up again (%v)", err)
+	}
+
+	// the server loses everything but its object storage
+	restored, cleanup2 := newTestServerWithPLC(t, s.plc)
+	defer cleanup2()
+	restored.SetBlobStore(bs)
+	restored.SetBackupStore(backups)
+
+	dids, err := restored.BackupAccountDids(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dids) != 1 || dids[0] != o.Did {
+		t.Fatalf("unexpected backup index: %v", dids)
+	}
+	// a restore that fails part way leaves nothing behind, so can be retried
+	carName := latestBackupCar(t, backups, o.Did)
+	car, err := backups.GetBlob(bg, carName, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backups.PutBlob(bg, carName, o.Did, car[:len(car)/2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.RestoreAccount(bg, o.Did); err == nil {
+		t.Fatal("expected restoring a truncated repo backup to fail")
+	}
+	if _, err := restored.lookupUserByDid(bg, o.Did); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("expected a failed restore to remove the account, got %v", err)
+	}
+	if err := backups.PutBlob(bg, carName, o.Did, car); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restored.RestoreAccount(bg, o.Did); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.RestoreAccount(bg, o.Did); err == nil {
+		t.Fatal("expected restoring an existing account to fail")
+	}
+
+	ru, err := restored.lookupUserByDid(bg, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ru.Handle != "backedup.test" || ru.Email != "test@foo.com" || ru.Password == "password" {
+		t.Fatalf("unexpected restored account: %+v", ru)
+	}
+
+	puri, err := util.ParseAtUri(post.Uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := restored.handleComAtprotoRepoGetRecord(bg, "", puri.Collection, o.Did, puri.Rkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *rec.Cid != post.Cid {
+		t.Fatalf("restored record has cid %s, expected %s", *rec.Cid, post.Cid)
+	}
+	if _, err := restored.handleComAtprotoSyncGetBlob(bg, up.Blob.Ref.String(), o.Did); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func latestBackupCar(t *testing.T, backups blobs.StreamingBlobStore, did string) string {
+	b, err := backups.GetBlob(context.Background(), backupLatestName, did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var man BackupManifest
+	if err := json.Unmarshal(b, &man); err != nil {
+		t.Fatal(err)
+	}
+	return man.Car
+}
@@ -0,0 +1,345 @@
+package pds
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/blobs"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repomgr"
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"gorm.io/gorm"
+)
+
+const maxBlobSize = 50 << 20
+
+// blobGracePeriod is how long an uploaded blob may go without a record
+// referring to it before it is garbage collected
+const blobGracePeriod = time.Hour
+
+// Blob is a blob a user has uploaded. Blobs are stored under their CID, so
+// uploading the same blob twice stores it once.
+type Blob struct {
+	gorm.Model
+	Uid      models.Uid `gorm:"uniqueIndex:idx_blob_uid_cid"`
+	Cid      string     `gorm:"uniqueIndex:idx_blob_uid_cid"`
+	MimeType string
+	Size     int64
+}
+
+// BlobRef records that a record in a user's repo refers to a blob
+type BlobRef struct {
+	ID     uint       `gorm:"primarykey"`
+	Uid    models.Uid `gorm:"index:idx_blob_ref_uid_cid"`
+	Cid    string     `gorm:"index:idx_blob_ref_uid_cid"`
+	Record string     `gorm:"index"`
+}
+
+// SetBlobStore sets where uploaded blobs are kept. Without one, blob
+// uploads are unavailable.
+func (s *Server) SetBlobStore(bs blobs.StreamingBlobStore) {
+	s.blobstore = bs
+}
+
+func (s *Server) handleComAtprotoRepoUploadBlob(ctx context.Context, r io.Reader, contentType string) (*comatprototypes.RepoUploadBlob_Output, error) {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.blobstore == nil {
+		return nil, fmt.Errorf("this server has no blob storage configured")
+	}
+
+	// spool to disk to find the CID before the blob goes to the store
+	tmp, err := os.CreateTemp("", "pds-blob-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, maxBlobSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading blob: %w", err)
+	}
+	if size > maxBlobSize {
+		return nil, &XRPCError{Status: 400, Name: "BlobTooLarge", Message: fmt.Sprintf("blobs can be at most %d bytes", maxBlobSize)}
+	}
+
+	mh, err := multihash.Encode(h.Sum(nil), multihash.SHA2_256)
+	if err != nil {
+		return nil, err
+	}
+	c := cid.NewCidV1(cid.Raw, mh)
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if contentType == "" || contentType == "*/*" {
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(tmp, head)
+		contentType = http.DetectContentType(head[:n])
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	var existing Blob
+	if err := s.db.Find(&existing, "uid = ? AND cid = ?", u.ID, c.String()).Error; err != nil {
+		return nil, err
+	}
+	if existing.ID == 0 {
+		if err := s.blobstore.PutBlobStream(ctx, c.String(), u.Did, tmp, size); err != nil {
+			return nil, fmt.Errorf("storing blob: %w", err)
+		}
+		if err := s.db.Create(&Blob{
+			Uid:      u.ID,
+			Cid:      c.String(),
+			MimeType: contentType,
+			Size:     size,
+		}).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		// restart the grace period
+		if err := s.db.Model(&existing).UpdateColumn("updated_at", time.Now()).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.collectOrphanedBlobs(ctx, u, time.Now().Add(-blobGracePeriod)); err != nil {
+		log.Errorw("blob garbage collection failed", "did", u.Did, "err", err)
+	}
+
+	return &comatprototypes.RepoUploadBlob_Output{
+		Blob: &lexutil.LexBlob{
+			Ref:      lexutil.LexLink(c),
+			MimeType: contentType,
+			Size:     size,
+		},
+	}, nil
+}
+
+func (s *Server) handleComAtprotoSyncGetBlob(ctx context.Context, cid string, did string) (io.Reader, error) {
+	if s.blobstore == nil {
+		return nil, blobs.NotFoundErr
+	}
+
+	u, err := s.lookupUserByDid(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRepoAvailable(u); err != nil {
+		return nil, err
+	}
+	down, err := s.blobTakenDown(ctx, u.Did, cid)
+	if err != nil {
+		return nil, err
+	}
+	if down {
+		return nil, &XRPCError{Status: 400, Name: "BlobNotFound", Message: "blob has been taken down"}
+	}
+
+	var b Blob
+	if err := s.db.First(&b, "uid = ? AND cid = ?", u.ID, cid).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &XRPCError{Status: 404, Name: "BlobNotFound", Message: "blob not found"}
+		}
+		return nil, err
+	}
+
+	r, err := s.blobstore.GetBlobStream(ctx, cid, did)
+	if err != nil {
+		return nil, err
+	}
+	return &closeOnEOF{r}, nil
+}
+
+// closeOnEOF closes the blob's reader once it has been read, since the
+// handler's caller only sees an io.Reader
+type closeOnEOF struct {
+	io.ReadCloser
+}
+
+func (c *closeOnEOF) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if err != nil {
+		c.ReadCloser.Close()
+	}
+	return n, err
+}
+
+func (s *Server) handleComAtprotoSyncListBlobs(ctx context.Context, cursor string, did string, limit int, since string) (*comatprototypes.SyncListBlobs_Output, error) {
+	u, err := s.lookupUserByDid(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRepoAvailable(u); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 1000 {
+		limit = 500
+	}
+
+	q := s.db.Model(&Blob{}).Where("uid = ?", u.ID).Order("id asc").Limit(limit)
+	if cursor != "" {
+		after, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "invalid cursor"}
+		}
+		q = q.Where("id > ?", after)
+	}
+
+	var bs []Blob
+	if err := q.Find(&bs).Error; err != nil {
+		return nil, err
+	}
+
+	out := &comatprototypes.SyncListBlobs_Output{Cids: []string{}}
+	for _, b := range bs {
+		out.Cids = append(out.Cids, b.Cid)
+	}
+	if len(bs) == limit {
+		next := strconv.FormatUint(uint64(bs[len(bs)-1].ID), 10)
+		out.Cursor = &next
+	}
+	return out, nil
+}
+
+// updateBlobRefs keeps track of which records refer to which blobs, and
+// deletes blobs that are no longer referred to by any record
+func (s *Server) updateBlobRefs(ctx context.Context, evt *repomgr.RepoEvent) error {
+	var released []string
+	for _, op := range evt.Ops {
+		rpath := op.Collection + "/" + op.Rkey
+
+		if op.Kind != repomgr.EvtKindCreateRecord {
+			var old []BlobRef
+			if err := s.db.Find(&old, "uid = ? AND record = ?", evt.User, rpath).Error; err != nil {
+				return err
+			}
+			for _, ref := range old {
+				released = append(released, ref.Cid)
+			}
+			if err := s.db.Where("uid = ? AND record = ?", evt.User, rpath).Delete(&BlobRef{}).Error; err != nil {
+				return err
+			}
+		}
+
+		if op.Kind == repomgr.EvtKindDeleteRecord || op.Record == nil {
+			continue
+		}
+
+		cids, err := recordBlobCids(op.Record)
+		if err != nil {
+			return fmt.Errorf("finding blobs in %s: %w", rpath, err)
+		}
+		for _, c := range cids {
+			if err := s.db.Create(&BlobRef{Uid: evt.User, Cid: c, Record: rpath}).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(released) == 0 {
+		return nil
+	}
+
+	var u User
+	if err := s.db.First(&u, "id = ?", evt.User).Error; err != nil {
+		return err
+	}
+	return s.deleteUnreferencedBlobs(ctx, &u, released)
+}
+
+// collectOrphanedBlobs deletes a user's blobs last uploaded before cutoff
+// that no record refers to
+func (s *Server) collectOrphanedBlobs(ctx context.Context, u *User, cutoff time.Time) error {
+	var cids []string
+	if err := s.db.Model(&Blob{}).
+		Where("uid = ? AND updated_at < ?", u.ID, cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM blob_refs WHERE blob_refs.uid = blobs.uid AND blob_refs.cid = blobs.cid)").
+		Pluck("cid", &cids).Error; err != nil {
+		return err
+	}
+
+	return s.deleteUnreferencedBlobs(ctx, u, cids)
+}
+
+func (s *Server) deleteUnreferencedBlobs(ctx context.Context, u *User, cids []string) error {
+	for _, c := range cids {
+		var refs int64
+		if err := s.db.Model(&BlobRef{}).Where("uid = ? AND cid = ?", u.ID, c).Count(&refs).Error; err != nil {
+			return err
+		}
+		if refs > 0 {
+			continue
+		}
+
+		if err := s.blobstore.DeleteBlob(ctx, c, u.Did); err != nil {
+			return fmt.Errorf("deleting blob %s: %w", c, err)
+		}
+		if err := s.db.Unscoped().Where("uid = ? AND cid = ?", u.ID, c).Delete(&Blob{}).Error; err != nil {
+			return err
+		}
+		log.Infow("deleted orphaned blob", "did", u.Did, "cid", c)
+	}
+	return nil
+}
+
+// recordBlobCids finds the blobs a record refers to, in both the current
+// ({"$type": "blob", "ref": {"$link": ...}}) and legacy ({"cid": ...,
+// "mimeType": ...}) forms
+func recordBlobCids(rec any) ([]string, error) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+
+	var out []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if v["$type"] == "blob" {
+				if ref, ok := v["ref"].(map[string]any); ok {
+					if link, ok := ref["$link"].(string); ok {
+						out = append(out, link)
+						return
+					}
+				}
+			}
+			if c, ok := v["cid"].(string); ok {
+				if _, ok := v["mimeType"].(string); ok {
+					out = append(out, c)
+					return
+				}
+			}
+			for _, sub := range v {
+				walk(sub)
+			}
+		case []any:
+			for _, sub := range v {
+				walk(sub)
+			}
+		}
+	}
+	walk(v)
+	return out, nil
+}
@@ -0,0 +1,109 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/blobs"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+)
+
+func TestBlobLifecycle(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	bs := &blobs.DiskBlobStore{Dir: t.TempDir()}
+	s.SetBlobStore(bs)
+
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    "test@foo.com",
+		Password: "password",
+		Handle:   "blobby.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(context.Background(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "user", u)
+
+	data := []byte("\x89PNG\r\n\x1a\n not really a png")
+	up, err := s.handleComAtprotoRepoUploadBlob(ctx, bytes.NewReader(data), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.Blob.MimeType != "image/png" || up.Blob.Size != int64(len(data)) {
+		t.Fatalf("unexpected blob: %+v", up.Blob)
+	}
+	// the same bytes always get the same CID
+	again, err := s.handleComAtprotoRepoUploadBlob(ctx, bytes.NewReader(data), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Blob.Ref.String() != up.Blob.Ref.String() {
+		t.Fatalf("expected identical CIDs, got %s and %s", up.Blob.Ref, again.Blob.Ref)
+	}
+
+	r, err := s.handleComAtprotoSyncGetBlob(ctx, up.Blob.Ref.String(), o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(r)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("got %q, expected %q", got, data)
+	}
+
+	post, err := s.handleComAtprotoRepoCreateRecord(ctx, &atproto.RepoCreateRecord_Input{
+		Collection: "app.bsky.feed.post",
+		Repo:       o.Did,
+		Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{
+			Text:      "look at this",
+			CreatedAt: time.Now().Format(time.RFC3339),
+			Embed: &bsky.FeedPost_Embed{EmbedImages: &bsky.EmbedImages{
+				Images: []*bsky.EmbedImages_Image{{Alt: "a picture", Image: up.Blob}},
+			}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// referenced blobs survive garbage collection
+	if err := s.collectOrphanedBlobs(ctx, u, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.handleComAtprotoSyncListBlobs(ctx, "", o.Did, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Cids) != 1 || list.Cids[0] != up.Blob.Ref.String() {
+		t.Fatalf("unexpected blob list: %v", list.Cids)
+	}
+
+	rkey := post.Uri[strings.LastIndex(post.Uri, "/")+1:]
+	if err := s.handleComAtprotoRepoDeleteRecord(ctx, &atproto.RepoDeleteRecord_Input{
+		Collection: "app.bsky.feed.post",
+		Repo:       o.Did,
+		Rkey:       rkey,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := bs.GetBlob(ctx, up.Blob.Ref.String(), o.Did); err != blobs.NotFoundErr {
+		t.Fatalf("expected the orphaned blob to be deleted, got %v", err)
+	}
+	list, err = s.handleComAtprotoSyncListBlobs(ctx, "", o.Did, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Cids) != 0 {
+		t.Fatalf("expected no blobs, got %v", list.Cids)
+	}
+}
@@ -0,0 +1,169 @@
+package pds
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// how far a DPoP proof's iat may be from the current time
+const dpopMaxSkew = 5 * time.Minute
+
+// server nonces rotate every dpopNonceWindow; the previous one is still
+// accepted, so clients have up to two windows to use a nonce
+const dpopNonceWindow = 3 * time.Minute
+
+// errUseDPoPNonce means the proof didn't carry the current server nonce; the
+// client should retry with the nonce from the DPoP-Nonce response header
+var errUseDPoPNonce = fmt.Errorf("use_dpop_nonce")
+
+type dpopProof struct {
+	// JWK thumbprint (RFC 7638) of the key the proof was signed with
+	Jkt string
+
+	Jti   string `json:"jti"`
+	Htm   string `json:"htm"`
+	Htu   string `json:"htu"`
+	Iat   int64  `json:"iat"`
+	Nonce string `json:"nonce"`
+	Ath   string `json:"ath"`
+}
+
+// dpopReplayCache remembers proof jtis until they are too old to be
+// accepted anyway
+type dpopReplayCache struct {
+	lk   sync.Mutex
+	seen map[string]time.Time
+}
+
+func newDPoPReplayCache() *dpopReplayCache {
+	return &dpopReplayCache{seen: make(map[string]time.Time)}
+}
+
+// add records a jti, returning false if it was already used
+func (rc *dpopReplayCache) add(jti string, now time.Time) bool {
+	rc.lk.Lock()
+	defer rc.lk.Unlock()
+
+	if _, ok := rc.seen[jti]; ok {
+		return false
+	}
+	if len(rc.seen) > 10000 {
+		for k, exp := range rc.seen {
+			if now.After(exp) {
+				delete(rc.seen, k)
+			}
+		}
+	}
+	rc.seen[jti] = now.Add(2 * dpopMaxSkew)
+	return true
+}
+
+func (s *Server) dpopNonceAt(t time.Time) string {
+	mac := hmac.New(sha256.New, s.jwtSigningKey)
+	fmt.Fprintf(mac, "dpop-nonce:%d", t.Unix()/int64(dpopNonceWindow/time.Second))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// dpopNonce returns the current server nonce, which is sent to clients in
+// the DPoP-Nonce header
+func (s *Server) dpopNonce() string {
+	return s.dpopNonceAt(time.Now())
+}
+
+func (s *Server) validDPoPNonce(nonce string) bool {
+	now := time.Now()
+	return nonce == s.dpopNonceAt(now) || nonce == s.dpopNonceAt(now.Add(-dpopNonceWindow))
+}
+
+// checkDPoPProof validates a DPoP proof (RFC 9449) for a request to htu
+// with method htm. If accessToken is set, the proof must be bound to it.
+func (s *Server) checkDPoPProof(proof, htm, htu, accessToken string) (*dpopProof, error) {
+	if proof == "" {
+		return nil, fmt.Errorf("missing DPoP proof")
+	}
+
+	msg, err := jws.Parse([]byte(proof))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DPoP proof: %w", err)
+	}
+	sigs := msg.Signatures()
+	if len(sigs) != 1 {
+		return nil, fmt.Errorf("invalid DPoP proof: expected one signature")
+	}
+	hdr := sigs[0].ProtectedHeaders()
+	if hdr.Type() != "dpop+jwt" {
+		return nil, fmt.Errorf("invalid DPoP proof: unexpected typ %q", hdr.Type())
+	}
+	if hdr.Algorithm() != jwa.ES256 {
+		return nil, fmt.Errorf("invalid DPoP proof: unsupported alg %q", hdr.Algorithm())
+	}
+	key := hdr.JWK()
+	if key == nil {
+		return nil, fmt.Errorf("invalid DPoP proof: missing jwk")
+	}
+	if _, ok := key.(jwk.ECDSAPrivateKey); ok {
+		return nil, fmt.Errorf("invalid DPoP proof: jwk must be a public key")
+	}
+
+	payload, err := jws.Verify([]byte(proof), jws.WithKey(jwa.ES256, key))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DPoP proof signature: %w", err)
+	}
+
+	var p dpopProof
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, fmt.Errorf("invalid DPoP proof claims: %w", err)
+	}
+
+	tp, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	p.Jkt = base64.RawURLEncoding.EncodeToString(tp)
+
+	if p.Htm != htm {
+		return nil, fmt.Errorf("DPoP proof is for method %s, not %s", p.Htm, htm)
+	}
+	if normalizeHtu(p.Htu) != normalizeHtu(htu) {
+		return nil, fmt.Errorf("DPoP proof is for %s, not %s", p.Htu, htu)
+	}
+	iat := time.Unix(p.Iat, 0)
+	if time.Since(iat) > dpopMaxSkew || time.Until(iat) > dpopMaxSkew {
+		return nil, fmt.Errorf("DPoP proof iat is too far from the current time")
+	}
+	if accessToken != "" {
+		ath := sha256.Sum256([]byte(accessToken))
+		if p.Ath != base64.RawURLEncoding.EncodeToString(ath[:]) {
+			return nil, fmt.Errorf("DPoP proof isn't bound to the access token")
+		}
+	}
+	if !s.validDPoPNonce(p.Nonce) {
+		return nil, errUseDPoPNonce
+	}
+	if p.Jti == "" || !s.dpopReplay.add(p.Jti, time.Now()) {
+		return nil, fmt.Errorf("DPoP proof has already been used")
+	}
+
+	return &p, nil
+}
+
+// normalizeHtu drops the query and fragment from a URL, which the htu claim
+// doesn't include
+func normalizeHtu(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return (&url.URL{Scheme: pu.Scheme, Host: pu.Host, Path: pu.Path}).String()
+}
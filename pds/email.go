@@ -0,0 +1,249 @@
+package pds
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/models"
+	"gorm.io/gorm"
+)
+
+const emailTokenLifetime = 15 * time.Minute
+
+// purposes of email tokens; a token is only good for the purpose it was
+// issued for
+const (
+	emailTokenConfirm       = "confirm_email"
+	emailTokenUpdate        = "update_email"
+	emailTokenResetPassword = "reset_password"
+)
+
+var (
+	ErrInvalidToken        = &XRPCError{Status: 400, Name: "InvalidToken", Message: "token is invalid"}
+	ErrExpiredToken        = &XRPCError{Status: 400, Name: "ExpiredToken", Message: "token has expired"}
+	ErrMailerNotConfigured = fmt.Errorf("this server can't send email")
+)
+
+// EmailToken is a single use token sent to a user's email address
+type EmailToken struct {
+	gorm.Model
+	Uid       models.Uid `gorm:"index"`
+	Purpose   string
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+}
+
+// SetMailer sets how account emails are sent. Without one, the email
+// confirmation, email update and password reset flows are unavailable.
+func (s *Server) SetMailer(m Mailer) {
+	s.mailer = m
+}
+
+func hashEmailToken(tok string) string {
+	h := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(tok))))
+	return hex.EncodeToString(h[:])
+}
+
+// issueEmailToken creates a token for a user, replacing any earlier token
+// for the same purpose. Tokens are short enough to type ("ABCDE-FGHIJ").
+func (s *Server) issueEmailToken(ctx context.Context, u *User, purpose string) (string, error) {
+	b := make([]byte, 7)
+	rand.Read(b)
+	raw := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)[:10]
+	tok := raw[:5] + "-" + raw[5:]
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("uid = ? AND purpose = ?", u.ID, purpose).Delete(&EmailToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&EmailToken{
+			Uid:       u.ID,
+			Purpose:   purpose,
+			TokenHash: hashEmailToken(tok),
+			ExpiresAt: time.Now().Add(emailTokenLifetime),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return tok, nil
+}
+
+// redeemEmailToken uses up a token, returning the user it was issued to. If
+// uid is non-zero, the token must have been issued to that user.
+func (s *Server) redeemEmailToken(ctx context.Context, purpose, tok string, uid models.Uid) (models.Uid, error) {
+	var et EmailToken
+	if err := s.db.First(&et, "token_hash = ? AND purpose = ?", hashEmailToken(tok), purpose).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+	if uid != 0 && et.Uid != uid {
+		return 0, ErrInvalidToken
+	}
+
+	res := s.db.Unscoped().Delete(&et)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected != 1 {
+		return 0, ErrInvalidToken
+	}
+	if time.Now().After(et.ExpiresAt) {
+		return 0, ErrExpiredToken
+	}
+	return et.Uid, nil
+}
+
+func (s *Server) sendEmailToken(ctx context.Context, u *User, purpose string, to string) error {
+	if s.mailer == nil {
+		return ErrMailerNotConfigured
+	}
+
+	tok, err := s.issueEmailToken(ctx, u, purpose)
+	if err != nil {
+		return err
+	}
+
+	var subject, action string
+	switch purpose {
+	case emailTokenConfirm:
+		subject, action = "Confirm your email", "confirm your email address"
+	case emailTokenUpdate:
+		subject, action = "Update your email", "change your email address"
+	case emailTokenResetPassword:
+		subject, action = "Reset your password", "reset your password"
+	}
+	body := fmt.Sprintf("Hi %s,\n\nUse this code to %s:\n\n    %s\n\nThe code expires in %d minutes. If you didn't ask for it, you can ignore this email.\n",
+		u.Handle, action, tok, int(emailTokenLifetime/time.Minute))
+
+	if err := s.mailer.SendMail(ctx, to, subject, body); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) handleComAtprotoServerRequestEmailConfirmation(ctx context.Context) error {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if u.EmailConfirmedAt != nil {
+		return nil
+	}
+
+	return s.sendEmailToken(ctx, u, emailTokenConfirm, u.Email)
+}
+
+func (s *Server) handleComAtprotoServerConfirmEmail(ctx context.Context, body *comatprototypes.ServerConfirmEmail_Input) error {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(body.Email, u.Email) {
+		return &XRPCError{Status: 400, Name: "InvalidEmail", Message: "email doesn't match the account's email"}
+	}
+
+	if _, err := s.redeemEmailToken(ctx, emailTokenConfirm, body.Token, u.ID); err != nil {
+		return err
+	}
+
+	return s.db.Model(User{}).Where("id = ?", u.ID).UpdateColumn("email_confirmed_at", time.Now()).Error
+}
+
+func (s *Server) handleComAtprotoServerRequestEmailUpdate(ctx context.Context) (*comatprototypes.ServerRequestEmailUpdate_Output, error) {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// a confirmed address has to approve the change
+	if u.EmailConfirmedAt == nil {
+		return &comatprototypes.ServerRequestEmailUpdate_Output{TokenRequired: false}, nil
+	}
+	if err := s.sendEmailToken(ctx, u, emailTokenUpdate, u.Email); err != nil {
+		return nil, err
+	}
+	return &comatprototypes.ServerRequestEmailUpdate_Output{TokenRequired: true}, nil
+}
+
+func (s *Server) handleComAtprotoServerUpdateEmail(ctx context.Context, body *comatprototypes.ServerUpdateEmail_Input) error {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if err := validateEmail(body.Email); err != nil {
+		return &XRPCError{Status: 400, Name: "InvalidEmail", Message: err.Error()}
+	}
+
+	if u.EmailConfirmedAt != nil {
+		if body.Token == nil || *body.Token == "" {
+			return &XRPCError{Status: 400, Name: "TokenRequired", Message: "confirmed email can only be changed with a token"}
+		}
+		if _, err := s.redeemEmailToken(ctx, emailTokenUpdate, *body.Token, u.ID); err != nil {
+			return err
+		}
+	}
+
+	var inUse int64
+	if err := s.db.Model(User{}).Where("LOWER(email) = LOWER(?) AND id != ?", body.Email, u.ID).Count(&inUse).Error; err != nil {
+		return err
+	}
+	if inUse > 0 {
+		return &XRPCError{Status: 400, Name: "InvalidEmail", Message: "email is already in use"}
+	}
+
+	// the new address hasn't been confirmed
+	return s.db.Model(User{}).Where("id = ?", u.ID).Updates(map[string]any{
+		"email":              body.Email,
+		"email_confirmed_at": nil,
+	}).Error
+}
+
+func (s *Server) handleComAtprotoServerRequestPasswordReset(ctx context.Context, body *comatprototypes.ServerRequestPasswordReset_Input) error {
+	if s.mailer == nil {
+		return ErrMailerNotConfigured
+	}
+
+	var u User
+	if err := s.db.Find(&u, "LOWER(email) = LOWER(?)", body.Email).Error; err != nil {
+		return err
+	}
+	// don't tell the caller whether the address has an account
+	if u.ID == 0 {
+		return nil
+	}
+
+	return s.sendEmailToken(ctx, &u, emailTokenResetPassword, u.Email)
+}
+
+func (s *Server) handleComAtprotoServerResetPassword(ctx context.Context, body *comatprototypes.ServerResetPassword_Input) error {
+	if body.Password == "" {
+		return &XRPCError{Status: 400, Name: "InvalidRequest", Message: "password is required"}
+	}
+
+	uid, err := s.redeemEmailToken(ctx, emailTokenResetPassword, body.Token, 0)
+	if err != nil {
+		return err
+	}
+
+	var u User
+	if err := s.db.First(&u, "id = ?", uid).Error; err != nil {
+		return err
+	}
+	if err := s.db.Model(User{}).Where("id = ?", uid).UpdateColumn("password", body.Password).Error; err != nil {
+		return err
+	}
+
+	// whoever knew the old password shouldn't keep access through OAuth
+	return s.db.Where("did = ?", u.Did).Delete(&OAuthSession{}).Error
+}
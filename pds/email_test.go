@@ -0,0 +1,126 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+type testMailer struct {
+	sent []string
+}
+
+func (m *testMailer) SendMail(ctx context.Context, to, subject, body string) error {
+	m.sent = append(m.sent, to+"\n"+body)
+	return nil
+}
+
+var emailTokenRe = regexp.MustCompile(`[A-Z2-7]{5}-[A-Z2-7]{5}`)
+
+func (m *testMailer) lastToken(t *testing.T) string {
+	t.Helper()
+	if len(m.sent) == 0 {
+		t.Fatal("no email sent")
+	}
+	tok := emailTokenRe.FindString(m.sent[len(m.sent)-1])
+	if tok == "" {
+		t.Fatalf("no token in email: %s", m.sent[len(m.sent)-1])
+	}
+	return tok
+}
+
+func TestEmailConfirmationAndPasswordReset(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	mailer := &testMailer{}
+	s.SetMailer(mailer)
+
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    "test@foo.com",
+		Password: "password",
+		Handle:   "mailman.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the auth middleware puts a fresh copy of the user in each request
+	userCtx := func() context.Context {
+		u, err := s.lookupUserByDid(context.Background(), o.Did)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return context.WithValue(context.Background(), "user", u)
+	}
+
+	if err := s.handleComAtprotoServerRequestEmailConfirmation(userCtx()); err != nil {
+		t.Fatal(err)
+	}
+	tok := mailer.lastToken(t)
+	err = s.handleComAtprotoServerConfirmEmail(userCtx(), &atproto.ServerConfirmEmail_Input{Email: "test@foo.com", Token: "AAAAA-AAAAA"})
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+	if err := s.handleComAtprotoServerConfirmEmail(userCtx(), &atproto.ServerConfirmEmail_Input{Email: "TEST@foo.com", Token: tok}); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := s.handleComAtprotoServerGetSession(userCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !*sess.EmailConfirmed {
+		t.Fatal("email should be confirmed")
+	}
+
+	// a confirmed email needs a token to change
+	upd, err := s.handleComAtprotoServerRequestEmailUpdate(userCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !upd.TokenRequired {
+		t.Fatal("expected a token to be required")
+	}
+	var xerr *XRPCError
+	err = s.handleComAtprotoServerUpdateEmail(userCtx(), &atproto.ServerUpdateEmail_Input{Email: "new@foo.com"})
+	if !errors.As(err, &xerr) || xerr.Name != "TokenRequired" {
+		t.Fatalf("expected TokenRequired, got %v", err)
+	}
+	tok = mailer.lastToken(t)
+	if err := s.handleComAtprotoServerUpdateEmail(userCtx(), &atproto.ServerUpdateEmail_Input{Email: "new@foo.com", Token: &tok}); err != nil {
+		t.Fatal(err)
+	}
+	sess, err = s.handleComAtprotoServerGetSession(userCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *sess.Email != "new@foo.com" || *sess.EmailConfirmed {
+		t.Fatalf("unexpected session after email update: %s %v", *sess.Email, *sess.EmailConfirmed)
+	}
+
+	// resets for unknown addresses succeed without sending anything
+	sent := len(mailer.sent)
+	if err := s.handleComAtprotoServerRequestPasswordReset(context.Background(), &atproto.ServerRequestPasswordReset_Input{Email: "nobody@foo.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.sent) != sent {
+		t.Fatal("sent a reset email to an unknown address")
+	}
+	if err := s.handleComAtprotoServerRequestPasswordReset(context.Background(), &atproto.ServerRequestPasswordReset_Input{Email: "new@foo.com"}); err != nil {
+		t.Fatal(err)
+	}
+	tok = mailer.lastToken(t)
+	if err := s.handleComAtprotoServerResetPassword(context.Background(), &atproto.ServerResetPassword_Input{Token: tok, Password: "newpassword"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.handleComAtprotoServerResetPassword(context.Background(), &atproto.ServerResetPassword_Input{Token: tok, Password: "again"}); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected a used token to be rejected, got %v", err)
+	}
+	if _, err := s.handleComAtprotoServerCreateSession(context.Background(), &atproto.ServerCreateSession_Input{
+		Identifier: o.Handle,
+		Password:   "newpassword",
+	}); err != nil {
+		t.Fatalf("login with the new password failed: %v", err)
+	}
+}
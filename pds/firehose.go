@@ -0,0 +1,39 @@
+package pds
+
+import (
+	"compress/flate"
+
+	"github.com/bluesky-social/indigo/events"
+)
+
+// FirehoseConfig controls how subscribeRepos is served
+type FirehoseConfig struct {
+	// Compression offers permessage-deflate to subscribers that ask for it
+	Compression bool
+	// CompressionLevel is a compress/flate level; zero means the default
+	CompressionLevel int
+	// BufferSize is how many events are buffered per subscriber; zero
+	// keeps the event manager's default
+	BufferSize int
+	// Overflow is what happens to subscribers that fill their buffer
+	Overflow events.OverflowPolicy
+}
+
+// SetFirehoseConfig sets how the firehose is served to subscribers
+func (s *Server) SetFirehoseConfig(cfg FirehoseConfig) {
+	if cfg.CompressionLevel == 0 {
+		cfg.CompressionLevel = flate.DefaultCompression
+	}
+	if cfg.BufferSize > 0 {
+		s.events.SetBufferSize(cfg.BufferSize)
+	}
+	s.events.SetOverflowPolicy(cfg.Overflow)
+	s.firehose = cfg
+}
+
+// SetEventPersister sets where firehose events are kept, so subscribers can
+// play back from a cursor. By default events are kept in memory and lost on
+// restart. It must be called before the server starts.
+func (s *Server) SetEventPersister(p events.EventPersistence) {
+	s.events.SetPersister(p)
+}
@@ -0,0 +1,146 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/gorilla/websocket"
+)
+
+func TestFirehosePlaybackCompressed(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	s.SetFirehoseConfig(FirehoseConfig{
+		Compression: true,
+		BufferSize:  16,
+		Overflow:    events.OverflowDrop,
+	})
+
+	ctx := context.Background()
+	o, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:    "test@foo.com",
+		Password: "password",
+		Handle:   "streamer.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(ctx, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := s.handleComAtprotoRepoCreateRecord(context.WithValue(ctx, "user", u), &atproto.RepoCreateRecord_Input{
+			Collection: "app.bsky.feed.post",
+			Repo:       o.Did,
+			Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{
+				Text:      "hello",
+				CreatedAt: time.Now().Format(time.RFC3339),
+			}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.RunAPIWithListener(ln)
+	defer s.Shutdown(ctx)
+
+	d := websocket.Dialer{EnableCompression: true}
+	con, resp, err := d.Dial("ws://"+ln.Addr().String()+"/xrpc/com.atproto.sync.subscribeRepos?cursor=1", http.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer con.Close()
+	if !strings.Contains(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate") {
+		t.Fatalf("expected compression to be negotiated, got %q", resp.Header.Get("Sec-Websocket-Extensions"))
+	}
+
+	// the account's first commit has seq 1, so playback resumes after it
+	var seqs []int64
+	con.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(seqs) < 3 {
+		_, msg, err := con.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(msg)
+		var header events.EventHeader
+		if err := header.UnmarshalCBOR(r); err != nil {
+			t.Fatal(err)
+		}
+		if header.MsgType != "#commit" {
+			continue
+		}
+		var commit atproto.SyncSubscribeRepos_Commit
+		if err := commit.UnmarshalCBOR(r); err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, commit.Seq)
+	}
+	for i, seq := range seqs {
+		if seq != int64(i+2) {
+			t.Fatalf("unexpected sequence numbers from playback: %v", seqs)
+		}
+	}
+}
+
+func TestFirehoseSubscriberIP(t *testing.T) {
+	for _, trusted := range []bool{false, true} {
+		s, cleanup := newTestServer(t)
+		defer cleanup()
+		if trusted {
+			if err := s.SetTrustedProxies([]string{"127.0.0.1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ctx := context.Background()
+		if _, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+			Email:    "test@foo.com",
+			Password: "password",
+			Handle:   "streamer.test",
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.RunAPIWithListener(ln)
+		defer s.Shutdown(ctx)
+
+		con, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/xrpc/com.atproto.sync.subscribeRepos?cursor=0", http.Header{
+			"X-Forwarded-For": []string{"203.0.113.5"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer con.Close()
+
+		// subscribers are counted before playback starts
+		con.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, _, err := con.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+		if got := s.subscribers.hasAny(map[string]bool{"203.0.113.5": true}); got != trusted {
+			t.Fatalf("subscriber counted as its X-Forwarded-For IP: %v (trusted proxy: %v)", got, trusted)
+		}
+		if got := s.subscribers.hasAny(map[string]bool{"127.0.0.1": true}); got == trusted {
+			t.Fatalf("subscriber counted as its connection's IP: %v (trusted proxy: %v)", got, trusted)
+		}
+	}
+}
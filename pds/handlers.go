@@ -6,14 +6,17 @@ import (
 	"database/sql"
 	"fmt"
 	"io"
+	"net/http"
 	"time"
 
 	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
 	appbskytypes "github.com/bluesky-social/indigo/api/bsky"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/xrpc"
+	gojwt "github.com/golang-jwt/jwt"
 	"github.com/ipfs/go-cid"
-	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/labstack/echo/v4"
 )
 
 func (s *Server) handleAppBskyActorGetProfile(ctx context.Context, actor string) (*appbskytypes.ActorDefs_ProfileViewDetailed, error) {
@@ -295,6 +298,30 @@ func (s *Server) handleComAtprotoServerCreateAccount(ctx context.Context, body *
 		// handle is available, lets go
 	}
 
+	code, err := s.claimInviteCode(ctx, body.InviteCode)
+	if err != nil {
+		return nil, err
+	}
+
+	var out *comatprototypes.ServerCreateAccount_Output
+	if body.Did != nil {
+		out, err = s.createMigratingAccount(ctx, body)
+	} else {
+		out, err = s.createAccount(ctx, body)
+	}
+	if err != nil {
+		s.releaseInviteCode(ctx, code)
+		return nil, err
+	}
+
+	if err := s.recordInviteCodeUse(ctx, code, out.Did); err != nil {
+		log.Errorw("failed to record invite code use", "code", code, "did", out.Did, "err", err)
+	}
+	return out, nil
+}
+
+func (s *Server) createAccount(ctx context.Context, body *comatprototypes.ServerCreateAccount_Input) (*comatprototypes.ServerCreateAccount_Output, error) {
+
 	var recoveryKey string
 	if body.RecoveryKey != nil {
 		recoveryKey = *body.RecoveryKey
@@ -350,17 +377,6 @@ func (s *Server) handleComAtprotoServerCreateAccount(ctx context.Context, body *
 	}, nil
 }
 
-func (s *Server) handleComAtprotoServerCreateInviteCode(ctx context.Context, body *comatprototypes.ServerCreateInviteCode_Input) (*comatprototypes.ServerCreateInviteCode_Output, error) {
-	u, err := s.getUser(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	_ = u
-
-	return nil, fmt.Errorf("invite codes not currently supported")
-}
-
 func (s *Server) handleComAtprotoServerRequestAccountDelete(ctx context.Context) error {
 	panic("not yet implemented")
 }
@@ -369,18 +385,6 @@ func (s *Server) handleComAtprotoServerDeleteAccount(ctx context.Context, body *
 	panic("not yet implemented")
 }
 
-func (s *Server) handleComAtprotoServerRequestPasswordReset(ctx context.Context, body *comatprototypes.ServerRequestPasswordReset_Input) error {
-	panic("not yet implemented")
-}
-
-func (s *Server) handleComAtprotoServerResetPassword(ctx context.Context, body *comatprototypes.ServerResetPassword_Input) error {
-	panic("not yet implemented")
-}
-
-func (s *Server) handleComAtprotoRepoUploadBlob(ctx context.Context, r io.Reader, contentType string) (*comatprototypes.RepoUploadBlob_Output, error) {
-	panic("not yet implemented")
-}
-
 func (s *Server) handleComAtprotoIdentityResolveHandle(ctx context.Context, handle string) (*comatprototypes.IdentityResolveHandle_Output, error) {
 	if handle == "" {
 		return &comatprototypes.IdentityResolveHandle_Output{Did: s.signingKey.Public().DID()}, nil
@@ -441,6 +445,18 @@ func (s *Server) handleComAtprotoRepoGetRecord(ctx context.Context, c string, co
 	if err != nil {
 		return nil, err
 	}
+	if err := s.checkRepoAvailable(targetUser); err != nil {
+		return nil, err
+	}
+
+	uri := "at://" + targetUser.Did + "/" + collection + "/" + rkey
+	down, err := s.recordTakenDown(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if down {
+		return nil, &XRPCError{Status: 400, Name: "RecordNotFound", Message: "record has been taken down"}
+	}
 
 	var maybeCid cid.Cid
 	if c != "" {
@@ -459,7 +475,7 @@ func (s *Server) handleComAtprotoRepoGetRecord(ctx context.Context, c string, co
 	ccstr := reccid.String()
 	return &comatprototypes.RepoGetRecord_Output{
 		Cid:   &ccstr,
-		Uri:   "at://" + targetUser.Did + "/" + collection + "/" + rkey,
+		Uri:   uri,
 		Value: &lexutil.LexiconTypeDecoder{rec},
 	}, nil
 }
@@ -473,7 +489,7 @@ func (s *Server) handleComAtprotoRepoPutRecord(ctx context.Context, input *comat
 }
 
 func (s *Server) handleComAtprotoServerDescribeServer(ctx context.Context) (*comatprototypes.ServerDescribeServer_Output, error) {
-	invcode := false
+	invcode := s.invites.Required
 	return &comatprototypes.ServerDescribeServer_Output{
 		InviteCodeRequired: &invcode,
 		AvailableUserDomains: []string{
@@ -490,12 +506,24 @@ func (s *Server) handleComAtprotoServerCreateSession(ctx context.Context, body *
 	if err != nil {
 		return nil, err
 	}
-
-	if body.Password != u.Password {
-		return nil, ErrInvalidUsernameOrPassword
+	if u.takenDown() {
+		return nil, ErrAccountTakedown
 	}
 
-	tok, err := s.createAuthTokenForUser(ctx, body.Identifier, u.Did)
+	var tok *xrpc.AuthInfo
+	if body.Password == u.Password {
+		tok, err = s.createAuthTokenForUser(ctx, body.Identifier, u.Did)
+	} else {
+		var name string
+		name, err = s.checkAppPassword(ctx, u, body.Password)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			return nil, ErrInvalidUsernameOrPassword
+		}
+		tok, err = s.createAppPasswordTokenForUser(ctx, body.Identifier, u.Did, name)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -518,9 +546,12 @@ func (s *Server) handleComAtprotoServerGetSession(ctx context.Context) (*comatpr
 		return nil, err
 	}
 
+	confirmed := u.EmailConfirmedAt != nil
 	return &comatprototypes.ServerGetSession_Output{
-		Handle: u.Handle,
-		Did:    u.Did,
+		Handle:         u.Handle,
+		Did:            u.Did,
+		Email:          &u.Email,
+		EmailConfirmed: &confirmed,
 	}, nil
 }
 
@@ -535,20 +566,41 @@ func (s *Server) handleComAtprotoServerRefreshSession(ctx context.Context) (*com
 		return nil, fmt.Errorf("scope not present in refresh token")
 	}
 
-	if scope != "com.atproto.refresh" {
+	if scope != refreshTokenScope {
 		return nil, fmt.Errorf("auth token did not have refresh scope")
 	}
 
-	tok, ok := ctx.Value("token").(*jwt.Token)
+	tok, ok := ctx.Value("token").(*gojwt.Token)
 	if !ok {
 		return nil, fmt.Errorf("internal auth error: token not set post auth check")
 	}
 
+	// app password sessions stay app password sessions, for as long as the
+	// app password exists
+	appPassword := ""
+	if claims, ok := tok.Claims.(gojwt.MapClaims); ok {
+		appPassword, _ = claims["appPassword"].(string)
+	}
+	if appPassword != "" {
+		exists, err := s.appPasswordExists(ctx, u, appPassword)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, echo.NewHTTPError(http.StatusUnauthorized, "app password has been revoked")
+		}
+	}
+
 	if err := s.invalidateToken(ctx, u, tok); err != nil {
 		return nil, err
 	}
 
-	outTok, err := s.createAuthTokenForUser(ctx, u.Handle, u.Did)
+	var outTok *xrpc.AuthInfo
+	if appPassword != "" {
+		outTok, err = s.createAppPasswordTokenForUser(ctx, u.Handle, u.Did, appPassword)
+	} else {
+		outTok, err = s.createAuthTokenForUser(ctx, u.Handle, u.Did)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -599,6 +651,9 @@ func (s *Server) handleComAtprotoSyncGetRepo(ctx context.Context, did string, si
 	if err != nil {
 		return nil, err
 	}
+	if err := s.checkRepoAvailable(targetUser); err != nil {
+		return nil, err
+	}
 
 	buf := new(bytes.Buffer)
 	if err := s.repoman.ReadRepo(ctx, targetUser.ID, since, buf); err != nil {
@@ -612,12 +667,6 @@ func (s *Server) handleAppBskyActorGetProfiles(ctx context.Context, actors []str
 	panic("nyi")
 }
 
-func (s *Server) handleComAtprotoAdminGetModerationAction(ctx context.Context, id int) (*comatprototypes.AdminDefs_ActionViewDetail, error) {
-	panic("nyi")
-}
-func (s *Server) handleComAtprotoAdminGetModerationActions(ctx context.Context, before string, limit int, subject string) (*comatprototypes.AdminGetModerationActions_Output, error) {
-	panic("nyi")
-}
 func (s *Server) handleComAtprotoAdminGetModerationReport(ctx context.Context, id int) (*comatprototypes.AdminDefs_ReportViewDetail, error) {
 	panic("nyi")
 }
@@ -626,25 +675,9 @@ func (s *Server) handleComAtprotoAdminGetModerationReports(ctx context.Context,
 	panic("nyi")
 }
 
-func (s *Server) handleComAtprotoAdminGetRecord(ctx context.Context, cid string, uri string) (*comatprototypes.AdminDefs_RecordViewDetail, error) {
-	panic("nyi")
-}
-
-func (s *Server) handleComAtprotoAdminGetRepo(ctx context.Context, did string) (*comatprototypes.AdminDefs_RepoViewDetail, error) {
-	panic("nyi")
-}
 func (s *Server) handleComAtprotoAdminResolveModerationReports(ctx context.Context, body *comatprototypes.AdminResolveModerationReports_Input) (*comatprototypes.AdminDefs_ActionView, error) {
 	panic("nyi")
 }
-func (s *Server) handleComAtprotoAdminReverseModerationAction(ctx context.Context, body *comatprototypes.AdminReverseModerationAction_Input) (*comatprototypes.AdminDefs_ActionView, error) {
-	panic("nyi")
-}
-func (s *Server) handleComAtprotoAdminSearchRepos(ctx context.Context, cursor string, invitedBy string, limit int, term string) (*comatprototypes.AdminSearchRepos_Output, error) {
-	panic("nyi")
-}
-func (s *Server) handleComAtprotoAdminTakeModerationAction(ctx context.Context, body *comatprototypes.AdminTakeModerationAction_Input) (*comatprototypes.AdminDefs_ActionView, error) {
-	panic("nyi")
-}
 
 func (s *Server) handleComAtprotoSyncGetBlocks(ctx context.Context, cids []string, did string) (io.Reader, error) {
 	panic("nyi")
@@ -658,14 +691,6 @@ func (s *Server) handleComAtprotoSyncRequestCrawl(ctx context.Context, body *com
 	panic("nyi")
 }
 
-func (s *Server) handleComAtprotoSyncGetBlob(ctx context.Context, cid string, did string) (io.Reader, error) {
-	panic("nyi")
-}
-
-func (s *Server) handleComAtprotoSyncListBlobs(ctx context.Context, cursor string, did string, limit int, since string) (*comatprototypes.SyncListBlobs_Output, error) {
-	panic("nyi")
-}
-
 func (s *Server) handleAppBskyActorSearchActors(ctx context.Context, cursor string, limit int, term string) (*appbskytypes.ActorSearchActors_Output, error) {
 	panic("nyi")
 }
@@ -699,26 +724,10 @@ func (s *Server) handleComAtprotoRepoDescribeRepo(ctx context.Context, repo stri
 	panic("nyi")
 }
 
-func (s *Server) handleComAtprotoAdminDisableInviteCodes(ctx context.Context, body *comatprototypes.AdminDisableInviteCodes_Input) error {
-	panic("nyi")
-}
-
-func (s *Server) handleComAtprotoAdminGetInviteCodes(ctx context.Context, cursor string, limit int, sort string) (*comatprototypes.AdminGetInviteCodes_Output, error) {
-	panic("nyi")
-}
-
 func (s *Server) handleComAtprotoLabelQueryLabels(ctx context.Context, cursor string, limit int, sources []string, uriPatterns []string) (*comatprototypes.LabelQueryLabels_Output, error) {
 	panic("nyi")
 }
 
-func (s *Server) handleComAtprotoServerCreateInviteCodes(ctx context.Context, body *comatprototypes.ServerCreateInviteCodes_Input) (*comatprototypes.ServerCreateInviteCodes_Output, error) {
-	panic("nyi")
-}
-
-func (s *Server) handleComAtprotoServerGetAccountInviteCodes(ctx context.Context, createAvailable bool, includeUsed bool) (*comatprototypes.ServerGetAccountInviteCodes_Output, error) {
-	panic("nyi")
-}
-
 func (s *Server) handleComAtprotoSyncListRepos(ctx context.Context, cursor string, limit int) (*comatprototypes.SyncListRepos_Output, error) {
 	panic("nyi")
 }
@@ -730,18 +739,6 @@ func (s *Server) handleComAtprotoAdminUpdateAccountEmail(ctx context.Context, bo
 func (s *Server) handleComAtprotoAdminUpdateAccountHandle(ctx context.Context, body *comatprototypes.AdminUpdateAccountHandle_Input) error {
 	panic("nyi")
 }
-
-func (s *Server) handleComAtprotoServerCreateAppPassword(ctx context.Context, body *comatprototypes.ServerCreateAppPassword_Input) (*comatprototypes.ServerCreateAppPassword_AppPassword, error) {
-	panic("nyi")
-}
-
-func (s *Server) handleComAtprotoServerListAppPasswords(ctx context.Context) (*comatprototypes.ServerListAppPasswords_Output, error) {
-	panic("nyi")
-}
-
-func (s *Server) handleComAtprotoServerRevokeAppPassword(ctx context.Context, body *comatprototypes.ServerRevokeAppPassword_Input) error {
-	panic("nyi")
-}
 func (s *Server) handleAppBskyActorGetPreferences(ctx context.Context) (*appbskytypes.ActorGetPreferences_Output, error) {
 	panic("nyi")
 }
@@ -771,14 +768,6 @@ func (s *Server) handleAppBskyGraphUnmuteActorList(ctx context.Context, body *ap
 	panic("nyi")
 }
 
-func (s *Server) handleComAtprotoAdminDisableAccountInvites(ctx context.Context, body *comatprototypes.AdminDisableAccountInvites_Input) error {
-	panic("nyi")
-}
-
-func (s *Server) handleComAtprotoAdminEnableAccountInvites(ctx context.Context, body *comatprototypes.AdminEnableAccountInvites_Input) error {
-	panic("nyi")
-}
-
 func (s *Server) handleAppBskyFeedDescribeFeedGenerator(ctx context.Context) (*appbskytypes.FeedDescribeFeedGenerator_Output, error) {
 	panic("nyi")
 }
@@ -38,13 +38,23 @@ func testCarStore(t *testing.T, db *gorm.DB) (*carstore.CarStore, func()) {
 }
 
 func newTestServer(t *testing.T) (*Server, func()) {
+	t.Helper()
+	return newTestServerWithPLC(t, nil)
+}
+
+// newTestServerWithPLC creates a test server using the given DID registry,
+// so several servers can share one. If didr is nil the server gets its own.
+func newTestServerWithPLC(t *testing.T, didr plc.PLCClient) (*Server, func()) {
 	t.Helper()
 	db, err := cliutil.SetupDatabase("sqlite://:memory:", 40)
 	if err != nil {
 		t.Fatal(err)
 	}
 	cs, cleanup := testCarStore(t, db)
-	fakePlc := plc.NewFakeDid(db)
+	fakePlc := didr
+	if fakePlc == nil {
+		fakePlc = plc.NewFakeDid(db)
+	}
 	raw, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,375 @@
+package pds
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"gorm.io/gorm"
+)
+
+// InviteConfig controls who may create an account on this server
+type InviteConfig struct {
+	// Required makes account creation need an invite code
+	Required bool
+	// Interval is how often each account earns an invite code to hand
+	// out. Zero means accounts only get codes an admin creates for them.
+	Interval time.Duration
+}
+
+// maxEarnedInvites caps how many codes an account earns at once, however
+// long it has been since it last asked for them
+const maxEarnedInvites = 5
+
+// SetInviteConfig sets the invite code policy. By default invite codes are
+// not required and accounts don't earn any.
+func (s *Server) SetInviteConfig(cfg InviteConfig) {
+	s.invites = cfg
+}
+
+// InviteCode is a code that lets AvailableUses accounts be created.
+// ForAccount and CreatedBy are a DID or "admin".
+type InviteCode struct {
+	gorm.Model
+	Code          string `gorm:"uniqueIndex"`
+	AvailableUses int
+	Uses          int
+	Disabled      bool
+	ForAccount    string `gorm:"index"`
+	CreatedBy     string
+}
+
+// InviteCodeUse records an account created with an invite code
+type InviteCodeUse struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Code      string `gorm:"index"`
+	UsedBy    string
+}
+
+// invite codes look like "pds-example-com-abcde-fghij"
+func (s *Server) generateInviteCode() string {
+	b := make([]byte, 10)
+	rand.Read(b)
+
+	var sb strings.Builder
+	if u, err := url.Parse(s.serviceUrl); err == nil && u.Hostname() != "" {
+		sb.WriteString(strings.ReplaceAll(u.Hostname(), ".", "-"))
+		sb.WriteByte('-')
+	}
+	for i, c := range b {
+		if i == 5 {
+			sb.WriteByte('-')
+		}
+		sb.WriteByte(appPasswordChars[int(c)%len(appPasswordChars)])
+	}
+	return sb.String()
+}
+
+func (s *Server) createInviteCodes(ctx context.Context, forAccount, createdBy string, count, uses int) ([]string, error) {
+	var codes []string
+	for i := 0; i < count; i++ {
+		ic := InviteCode{
+			Code:          s.generateInviteCode(),
+			AvailableUses: uses,
+			ForAccount:    forAccount,
+			CreatedBy:     createdBy,
+		}
+		if err := s.db.Create(&ic).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, ic.Code)
+	}
+	return codes, nil
+}
+
+// claimInviteCode takes one use of the invite code for an account about to
+// be created. Claims are atomic, so concurrent signups can't overuse a code.
+// It returns "" if invite codes aren't required.
+func (s *Server) claimInviteCode(ctx context.Context, code *string) (string, error) {
+	if !s.invites.Required {
+		return "", nil
+	}
+	if code == nil || *code == "" {
+		return "", &XRPCError{Status: 400, Name: "InvalidInviteCode", Message: "an invite code is required to create an account here"}
+	}
+
+	res := s.db.Model(&InviteCode{}).
+		Where("code = ? AND disabled = ? AND uses < available_uses", *code, false).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return "", &XRPCError{Status: 400, Name: "InvalidInviteCode", Message: "invite code is invalid, disabled or used up"}
+	}
+	return *code, nil
+}
+
+// releaseInviteCode gives back a claimed use when account creation fails
+func (s *Server) releaseInviteCode(ctx context.Context, code string) {
+	if code == "" {
+		return
+	}
+	if err := s.db.Model(&InviteCode{}).Where("code = ?", code).UpdateColumn("uses", gorm.Expr("uses - 1")).Error; err != nil {
+		log.Errorw("failed to release invite code", "code", code, "err", err)
+	}
+}
+
+func (s *Server) recordInviteCodeUse(ctx context.Context, code, did string) error {
+	if code == "" {
+		return nil
+	}
+	return s.db.Create(&InviteCodeUse{Code: code, UsedBy: did}).Error
+}
+
+// createEarnedInviteCodes gives the user the single use codes they have
+// earned since they last got some
+func (s *Server) createEarnedInviteCodes(ctx context.Context, u *User) error {
+	if s.invites.Interval <= 0 || u.InvitesDisabled {
+		return nil
+	}
+
+	since := u.CreatedAt
+	var last InviteCode
+	if err := s.db.Order("created_at desc").Limit(1).Find(&last, "for_account = ? AND created_by = ?", u.Did, u.Did).Error; err != nil {
+		return err
+	}
+	if last.ID != 0 {
+		since = last.CreatedAt
+	}
+
+	earned := int(time.Since(since) / s.invites.Interval)
+	if earned > maxEarnedInvites {
+		earned = maxEarnedInvites
+	}
+	if earned <= 0 {
+		return nil
+	}
+
+	_, err := s.createInviteCodes(ctx, u.Did, u.Did, earned, 1)
+	return err
+}
+
+func (s *Server) inviteCodeViews(ctx context.Context, codes []InviteCode) ([]*comatprototypes.ServerDefs_InviteCode, error) {
+	names := make([]string, 0, len(codes))
+	for _, ic := range codes {
+		names = append(names, ic.Code)
+	}
+
+	var uses []InviteCodeUse
+	if len(names) > 0 {
+		if err := s.db.Order("id asc").Find(&uses, "code IN ?", names).Error; err != nil {
+			return nil, err
+		}
+	}
+	byCode := make(map[string][]*comatprototypes.ServerDefs_InviteCodeUse)
+	for _, use := range uses {
+		byCode[use.Code] = append(byCode[use.Code], &comatprototypes.ServerDefs_InviteCodeUse{
+			UsedAt: use.CreatedAt.Format(time.RFC3339),
+			UsedBy: use.UsedBy,
+		})
+	}
+
+	out := []*comatprototypes.ServerDefs_InviteCode{}
+	for _, ic := range codes {
+		cuses := byCode[ic.Code]
+		if cuses == nil {
+			cuses = []*comatprototypes.ServerDefs_InviteCodeUse{}
+		}
+		out = append(out, &comatprototypes.ServerDefs_InviteCode{
+			Available:  int64(ic.AvailableUses),
+			Code:       ic.Code,
+			CreatedAt:  ic.CreatedAt.Format(time.RFC3339),
+			CreatedBy:  ic.CreatedBy,
+			Disabled:   ic.Disabled,
+			ForAccount: ic.ForAccount,
+			Uses:       cuses,
+		})
+	}
+	return out, nil
+}
+
+func (s *Server) handleComAtprotoServerCreateInviteCode(ctx context.Context, body *comatprototypes.ServerCreateInviteCode_Input) (*comatprototypes.ServerCreateInviteCode_Output, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if body.UseCount <= 0 {
+		return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "useCount must be positive"}
+	}
+
+	forAccount := "admin"
+	if body.ForAccount != nil && *body.ForAccount != "" {
+		forAccount = *body.ForAccount
+	}
+
+	codes, err := s.createInviteCodes(ctx, forAccount, "admin", 1, int(body.UseCount))
+	if err != nil {
+		return nil, err
+	}
+
+	return &comatprototypes.ServerCreateInviteCode_Output{Code: codes[0]}, nil
+}
+
+func (s *Server) handleComAtprotoServerCreateInviteCodes(ctx context.Context, body *comatprototypes.ServerCreateInviteCodes_Input) (*comatprototypes.ServerCreateInviteCodes_Output, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if body.UseCount <= 0 || body.CodeCount <= 0 {
+		return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "codeCount and useCount must be positive"}
+	}
+
+	accounts := body.ForAccounts
+	if len(accounts) == 0 {
+		accounts = []string{"admin"}
+	}
+
+	out := &comatprototypes.ServerCreateInviteCodes_Output{}
+	for _, acc := range accounts {
+		codes, err := s.createInviteCodes(ctx, acc, "admin", int(body.CodeCount), int(body.UseCount))
+		if err != nil {
+			return nil, err
+		}
+		out.Codes = append(out.Codes, &comatprototypes.ServerCreateInviteCodes_AccountCodes{
+			Account: acc,
+			Codes:   codes,
+		})
+	}
+	return out, nil
+}
+
+func (s *Server) handleComAtprotoServerGetAccountInviteCodes(ctx context.Context, createAvailable bool, includeUsed bool) (*comatprototypes.ServerGetAccountInviteCodes_Output, error) {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if createAvailable {
+		if err := s.createEarnedInviteCodes(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+
+	q := s.db.Where("for_account = ?", u.Did).Order("id asc")
+	if !includeUsed {
+		q = q.Where("uses < available_uses")
+	}
+	var codes []InviteCode
+	if err := q.Find(&codes).Error; err != nil {
+		return nil, err
+	}
+
+	views, err := s.inviteCodeViews(ctx, codes)
+	if err != nil {
+		return nil, err
+	}
+	return &comatprototypes.ServerGetAccountInviteCodes_Output{Codes: views}, nil
+}
+
+// handleComAtprotoAdminGetInviteCodes lists every invite code, newest first
+// or (with sort "usage") most used first. The cursor is an ID for "recent"
+// and an offset for "usage".
+func (s *Server) handleComAtprotoAdminGetInviteCodes(ctx context.Context, cursor string, limit int, sort string) (*comatprototypes.AdminGetInviteCodes_Output, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	var after int
+	if cursor != "" {
+		n, err := strconv.Atoi(cursor)
+		if err != nil || n < 0 {
+			return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "invalid cursor"}
+		}
+		after = n
+	}
+
+	q := s.db.Limit(limit)
+	switch sort {
+	case "", "recent":
+		q = q.Order("id desc")
+		if cursor != "" {
+			q = q.Where("id < ?", after)
+		}
+	case "usage":
+		q = q.Order("uses desc").Order("id desc").Offset(after)
+	default:
+		return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "sort must be recent or usage"}
+	}
+
+	var codes []InviteCode
+	if err := q.Find(&codes).Error; err != nil {
+		return nil, err
+	}
+
+	views, err := s.inviteCodeViews(ctx, codes)
+	if err != nil {
+		return nil, err
+	}
+	out := &comatprototypes.AdminGetInviteCodes_Output{Codes: views}
+	if len(codes) == limit {
+		next := strconv.Itoa(after + limit)
+		if sort != "usage" {
+			next = strconv.Itoa(int(codes[len(codes)-1].ID))
+		}
+		out.Cursor = &next
+	}
+	return out, nil
+}
+
+func (s *Server) handleComAtprotoAdminDisableInviteCodes(ctx context.Context, body *comatprototypes.AdminDisableInviteCodes_Input) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if len(body.Codes) > 0 {
+		if err := s.db.Model(&InviteCode{}).Where("code IN ?", body.Codes).UpdateColumn("disabled", true).Error; err != nil {
+			return err
+		}
+	}
+	if len(body.Accounts) > 0 {
+		if err := s.db.Model(&InviteCode{}).Where("for_account IN ?", body.Accounts).UpdateColumn("disabled", true).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleComAtprotoAdminDisableAccountInvites(ctx context.Context, body *comatprototypes.AdminDisableAccountInvites_Input) error {
+	return s.setAccountInvitesDisabled(ctx, body.Account, true, body.Note)
+}
+
+func (s *Server) handleComAtprotoAdminEnableAccountInvites(ctx context.Context, body *comatprototypes.AdminEnableAccountInvites_Input) error {
+	return s.setAccountInvitesDisabled(ctx, body.Account, false, body.Note)
+}
+
+func (s *Server) setAccountInvitesDisabled(ctx context.Context, account string, disabled bool, note *string) error {
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	u, err := s.lookupUserByDid(ctx, account)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &XRPCError{Status: 404, Name: "AccountNotFound", Message: "no such account"}
+		}
+		return err
+	}
+	if err := s.db.Model(u).UpdateColumn("invites_disabled", disabled).Error; err != nil {
+		return err
+	}
+
+	var n string
+	if note != nil {
+		n = *note
+	}
+	log.Infow("updated account invites", "did", u.Did, "disabled", disabled, "note", n)
+	return nil
+}
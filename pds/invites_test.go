@@ -0,0 +1,138 @@
+package pds
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+func TestInviteCodes(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	s.SetAdminPassword("hunter2")
+	s.SetInviteConfig(InviteConfig{Required: true, Interval: time.Hour})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := "http://" + ln.Addr().String()
+	go s.RunAPIWithListener(ln)
+	defer s.Shutdown(context.Background())
+
+	ctx := context.Background()
+
+	bad := "wrong"
+	if _, err := atproto.ServerCreateInviteCode(ctx, &xrpc.Client{Host: host, AdminToken: &bad}, &atproto.ServerCreateInviteCode_Input{UseCount: 1}); err == nil {
+		t.Fatal("expected a wrong admin password to be rejected")
+	}
+
+	pw := "hunter2"
+	admin := &xrpc.Client{Host: host, AdminToken: &pw}
+	ic, err := atproto.ServerCreateInviteCode(ctx, admin, &atproto.ServerCreateInviteCode_Input{UseCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc, err := s.handleComAtprotoServerDescribeServer(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.InviteCodeRequired == nil || !*desc.InviteCodeRequired {
+		t.Fatal("expected describeServer to say invite codes are required")
+	}
+
+	if _, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:    "nocode@foo.com",
+		Password: "password",
+		Handle:   "nocode.test",
+	}); err == nil {
+		t.Fatal("expected account creation without an invite code to fail")
+	}
+
+	o, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:      "invited@foo.com",
+		Password:   "password",
+		Handle:     "invited.test",
+		InviteCode: &ic.Code,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:      "second@foo.com",
+		Password:   "password",
+		Handle:     "second.test",
+		InviteCode: &ic.Code,
+	}); err == nil {
+		t.Fatal("expected a used up invite code to be rejected")
+	}
+
+	all, err := atproto.AdminGetInviteCodes(ctx, admin, "", 10, "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all.Codes) != 1 || len(all.Codes[0].Uses) != 1 || all.Codes[0].Uses[0].UsedBy != o.Did {
+		t.Fatalf("unexpected invite codes: %+v", all.Codes)
+	}
+
+	// a new account hasn't earned any codes yet; an older one has
+	u, err := s.lookupUserByDid(ctx, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userCtx := context.WithValue(ctx, "user", u)
+	mine, err := s.handleComAtprotoServerGetAccountInviteCodes(userCtx, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mine.Codes) != 0 {
+		t.Fatalf("expected no earned codes, got %d", len(mine.Codes))
+	}
+
+	u.CreatedAt = time.Now().Add(-150 * time.Minute)
+	mine, err = s.handleComAtprotoServerGetAccountInviteCodes(userCtx, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mine.Codes) != 2 {
+		t.Fatalf("expected two earned codes, got %d", len(mine.Codes))
+	}
+	mine, err = s.handleComAtprotoServerGetAccountInviteCodes(userCtx, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mine.Codes) != 2 {
+		t.Fatalf("expected asking again not to earn more codes, got %d", len(mine.Codes))
+	}
+
+	earned := mine.Codes[0].Code
+	if err := atproto.AdminDisableInviteCodes(ctx, admin, &atproto.AdminDisableInviteCodes_Input{Codes: []string{earned}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:      "disabled@foo.com",
+		Password:   "password",
+		Handle:     "disabled.test",
+		InviteCode: &earned,
+	}); err == nil {
+		t.Fatal("expected a disabled invite code to be rejected")
+	}
+
+	if err := atproto.AdminDisableAccountInvites(ctx, admin, &atproto.AdminDisableAccountInvites_Input{Account: o.Did}); err != nil {
+		t.Fatal(err)
+	}
+	u, err = s.lookupUserByDid(ctx, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.InvitesDisabled {
+		t.Fatal("expected account invites to be disabled")
+	}
+}
@@ -0,0 +1,593 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/crypto/serviceauth"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	didres "github.com/bluesky-social/indigo/did"
+	"github.com/bluesky-social/indigo/models"
+	"github.com/bluesky-social/indigo/repo"
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-car"
+	"github.com/labstack/echo/v4"
+	"github.com/whyrusleeping/go-did"
+	"go.opentelemetry.io/otel"
+)
+
+// The account migration lexicons don't have generated types in this tree
+// yet, so their handlers and input/output types live here.
+
+// maxServiceAuthLifetime is the longest a service auth token may be valid
+const maxServiceAuthLifetime = time.Hour
+
+// deactivatedBlockedRoutes are rejected for deactivated accounts, so a repo
+// can't change while it is being moved
+var deactivatedBlockedRoutes = map[string]bool{
+	"/xrpc/com.atproto.repo.createRecord": true,
+	"/xrpc/com.atproto.repo.putRecord":    true,
+	"/xrpc/com.atproto.repo.deleteRecord": true,
+	"/xrpc/com.atproto.repo.applyWrites":  true,
+}
+
+var ErrAccountDeactivated = &XRPCError{Status: 400, Name: "AccountDeactivated", Message: "account is deactivated"}
+
+type serverGetServiceAuthOutput struct {
+	Token string `json:"token"`
+}
+
+type serverCheckAccountStatusOutput struct {
+	Activated          bool   `json:"activated"`
+	ValidDid           bool   `json:"validDid"`
+	RepoCommit         string `json:"repoCommit"`
+	RepoRev            string `json:"repoRev"`
+	RepoBlocks         int64  `json:"repoBlocks"`
+	IndexedRecords     int64  `json:"indexedRecords"`
+	PrivateStateValues int64  `json:"privateStateValues"`
+	ExpectedBlobs      int64  `json:"expectedBlobs"`
+	ImportedBlobs      int64  `json:"importedBlobs"`
+}
+
+type repoListMissingBlobsRecordBlob struct {
+	Cid       string `json:"cid"`
+	RecordUri string `json:"recordUri"`
+}
+
+type repoListMissingBlobsOutput struct {
+	Cursor *string                           `json:"cursor,omitempty"`
+	Blobs  []*repoListMissingBlobsRecordBlob `json:"blobs"`
+}
+
+type identityGetRecommendedDidCredentialsOutput struct {
+	RotationKeys        []string          `json:"rotationKeys"`
+	AlsoKnownAs         []string          `json:"alsoKnownAs"`
+	VerificationMethods map[string]string `json:"verificationMethods"`
+	Services            map[string]any    `json:"services"`
+}
+
+func (s *Server) registerMigrationHandlers(e *echo.Echo) {
+	e.GET("/xrpc/com.atproto.server.getServiceAuth", s.HandleComAtprotoServerGetServiceAuth)
+	e.GET("/xrpc/com.atproto.server.checkAccountStatus", s.HandleComAtprotoServerCheckAccountStatus)
+	e.POST("/xrpc/com.atproto.server.activateAccount", s.HandleComAtprotoServerActivateAccount)
+	e.POST("/xrpc/com.atproto.server.deactivateAccount", s.HandleComAtprotoServerDeactivateAccount)
+	e.POST("/xrpc/com.atproto.repo.importRepo", s.HandleComAtprotoRepoImportRepo)
+	e.GET("/xrpc/com.atproto.repo.listMissingBlobs", s.HandleComAtprotoRepoListMissingBlobs)
+	e.GET("/xrpc/com.atproto.identity.getRecommendedDidCredentials", s.HandleComAtprotoIdentityGetRecommendedDidCredentials)
+}
+
+// serviceDid is the did:web of this server, which service auth tokens for
+// it are addressed to
+func (s *Server) serviceDid() string {
+	host := s.serviceUrl
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host, _, _ = strings.Cut(host, "/")
+	host, _, _ = strings.Cut(host, ":")
+	return "did:web:" + host
+}
+
+// atprotoSigningKey is the server's signing key as an atproto/crypto key,
+// for minting service auth tokens
+func atprotoSigningKey(k *did.PrivKey) (crypto.PrivateKey, error) {
+	switch k.Type {
+	case did.KeyTypeP256:
+		ek, ok := k.Raw.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unexpected P-256 key type: %T", k.Raw)
+		}
+		return crypto.ParsePrivateBytesP256(ek.D.FillBytes(make([]byte, 32)))
+	case did.KeyTypeSecp256k1:
+		b, err := k.RawBytes()
+		if err != nil {
+			return nil, err
+		}
+		return crypto.ParsePrivateBytesK256(b)
+	default:
+		return nil, fmt.Errorf("unsupported key type for service auth: %s", k.Type)
+	}
+}
+
+// createServiceAuthToken signs a token vouching that the bearer acts for
+// iss, with the key this server signs iss's repo with
+func (s *Server) createServiceAuthToken(iss, aud, lxm string, exp time.Time) (string, error) {
+	key, err := atprotoSigningKey(s.signingKey)
+	if err != nil {
+		return "", err
+	}
+	did, err := syntax.ParseDID(iss)
+	if err != nil {
+		return "", err
+	}
+	return serviceauth.Mint(key, did, aud, lxm, time.Until(exp))
+}
+
+// serviceAuthValidator checks service auth tokens addressed to this server.
+// It is made on first use, as serviceUrl can be set after NewServer, and is
+// kept so tokens can't be replayed. Tokens must name the method they're
+// for.
+func (s *Server) serviceAuthValidator() *serviceauth.Validator {
+	s.serviceAuthOnce.Do(func() {
+		s.serviceAuth = &serviceauth.Validator{
+			Audience:      s.serviceDid(),
+			Dir:           &resolverDirectory{didr: s.plc},
+			RequireLxm:    true,
+			RejectReplays: true,
+		}
+	})
+	return s.serviceAuth
+}
+
+// verifyServiceAuthToken checks a service auth token addressed to this
+// server against its issuer's DID document, returning the issuer. Each
+// token is only accepted once.
+func (s *Server) verifyServiceAuthToken(ctx context.Context, tok, lxm string) (string, error) {
+	iss, err := s.serviceAuthValidator().Validate(ctx, tok, lxm)
+	if errors.Is(err, serviceauth.ErrInvalidToken) {
+		return "", &XRPCError{Status: 401, Name: "InvalidToken", Message: err.Error()}
+	}
+	if err != nil {
+		return "", err
+	}
+	return iss.String(), nil
+}
+
+// resolverDirectory is an identity.Directory resolving DIDs with the
+// server's DID resolver, so service auth is checked against the same
+// documents as everything else. Handles aren't needed.
+type resolverDirectory struct {
+	didr didres.Resolver
+}
+
+func (d *resolverDirectory) LookupDID(ctx context.Context, did syntax.DID) (*identity.Identity, error) {
+	doc, err := d.didr.GetDocument(ctx, did.String())
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var idoc identity.DIDDocument
+	if err := json.Unmarshal(b, &idoc); err != nil {
+		return nil, err
+	}
+	ident := identity.ParseIdentity(&idoc)
+	return &ident, nil
+}
+
+func (d *resolverDirectory) LookupHandle(ctx context.Context, h syntax.Handle) (*identity.Identity, error) {
+	return nil, fmt.Errorf("handle resolution not supported: %s", h)
+}
+
+func (d *resolverDirectory) Lookup(ctx context.Context, a syntax.AtIdentifier) (*identity.Identity, error) {
+	did, err := a.AsDID()
+	if err != nil {
+		return nil, fmt.Errorf("handle resolution not supported: %s", a)
+	}
+	return d.LookupDID(ctx, did)
+}
+
+func (d *resolverDirectory) Purge(ctx context.Context, a syntax.AtIdentifier) error {
+	d.didr.FlushCacheFor(a.String())
+	return nil
+}
+
+// createMigratingAccount creates an account for a DID that already exists,
+// moving here from another PDS. The old PDS vouches for the DID with a
+// service auth token. The account starts deactivated and without a repo,
+// which importRepo then provides.
+func (s *Server) createMigratingAccount(ctx context.Context, body *comatprototypes.ServerCreateAccount_Input) (*comatprototypes.ServerCreateAccount_Output, error) {
+	auth, _ := ctx.Value("auth").(string)
+	tok := strings.TrimPrefix(auth, "Bearer ")
+	if tok == "" {
+		return nil, &XRPCError{Status: 401, Name: "AuthenticationRequired", Message: "creating an account for an existing DID requires service auth"}
+	}
+
+	iss, err := s.verifyServiceAuthToken(ctx, tok, "com.atproto.server.createAccount")
+	if err != nil {
+		return nil, err
+	}
+	if iss != *body.Did {
+		return nil, &XRPCError{Status: 401, Name: "InvalidToken", Message: "service auth token was issued for a different DID"}
+	}
+
+	if _, err := s.lookupUserByDid(ctx, iss); err == nil {
+		return nil, &XRPCError{Status: 400, Name: "AlreadyExists", Message: "an account for this DID already exists"}
+	}
+
+	now := time.Now()
+	u := User{
+		Handle:        body.Handle,
+		Password:      body.Password,
+		Email:         body.Email,
+		Did:           iss,
+		DeactivatedAt: &now,
+	}
+	if err := s.db.Create(&u).Error; err != nil {
+		return nil, err
+	}
+
+	ai := &models.ActorInfo{
+		Uid:    u.ID,
+		Did:    u.Did,
+		Handle: sql.NullString{String: body.Handle, Valid: true},
+	}
+	if err := s.db.Create(ai).Error; err != nil {
+		return nil, err
+	}
+
+	authTok, err := s.createAuthTokenForUser(ctx, u.Handle, u.Did)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infow("created account for migration", "did", u.Did, "handle", u.Handle)
+	return &comatprototypes.ServerCreateAccount_Output{
+		Handle:     u.Handle,
+		Did:        u.Did,
+		AccessJwt:  authTok.AccessJwt,
+		RefreshJwt: authTok.RefreshJwt,
+	}, nil
+}
+
+func (s *Server) handleComAtprotoServerGetServiceAuth(ctx context.Context, aud, lxm string, exp int64) (*serverGetServiceAuthOutput, error) {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if aud == "" {
+		return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "aud is required"}
+	}
+
+	// a createAccount token lets another PDS take the account, so only a
+	// full session can ask for one. Other sessions must name the method,
+	// so they can't get a token good for any.
+	if scope, _ := ctx.Value("authScope").(string); scope != "com.atproto.access" {
+		if lxm == "" {
+			return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "lxm is required for this session"}
+		}
+		if lxm == "com.atproto.server.createAccount" {
+			return nil, &XRPCError{Status: 403, Name: "InvalidToken", Message: "this session can't authorize an account migration"}
+		}
+	}
+
+	expires := time.Now().Add(time.Minute)
+	if exp != 0 {
+		expires = time.Unix(exp, 0)
+		if time.Until(expires) > maxServiceAuthLifetime {
+			return nil, &XRPCError{Status: 400, Name: "BadExpiration", Message: "service auth tokens can't be valid for more than an hour"}
+		}
+		if !expires.After(time.Now()) {
+			return nil, &XRPCError{Status: 400, Name: "BadExpiration", Message: "exp is in the past"}
+		}
+	}
+
+	tok, err := s.createServiceAuthToken(u.Did, aud, lxm, expires)
+	if err != nil {
+		return nil, err
+	}
+	return &serverGetServiceAuthOutput{Token: tok}, nil
+}
+
+func (s *Server) handleComAtprotoRepoImportRepo(ctx context.Context, r io.Reader) error {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if u.DeactivatedAt == nil {
+		return &XRPCError{Status: 400, Name: "InvalidRequest", Message: "repos can only be imported into a deactivated account"}
+	}
+
+	if err := s.repoman.ImportNewRepo(ctx, u.ID, u.Did, r, nil); err != nil {
+		return fmt.Errorf("importing repo: %w", err)
+	}
+
+	log.Infow("imported repo", "did", u.Did)
+	return nil
+}
+
+func (s *Server) handleComAtprotoRepoListMissingBlobs(ctx context.Context, cursor string, limit int) (*repoListMissingBlobsOutput, error) {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 1000 {
+		limit = 500
+	}
+
+	q := s.db.Model(&BlobRef{}).
+		Where("uid = ?", u.ID).
+		Where("NOT EXISTS (SELECT 1 FROM blobs WHERE blobs.uid = blob_refs.uid AND blobs.cid = blob_refs.cid AND blobs.deleted_at IS NULL)").
+		Order("id asc").
+		Limit(limit)
+	if cursor != "" {
+		after, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "invalid cursor"}
+		}
+		q = q.Where("id > ?", after)
+	}
+
+	var refs []BlobRef
+	if err := q.Find(&refs).Error; err != nil {
+		return nil, err
+	}
+
+	out := &repoListMissingBlobsOutput{Blobs: []*repoListMissingBlobsRecordBlob{}}
+	for _, ref := range refs {
+		out.Blobs = append(out.Blobs, &repoListMissingBlobsRecordBlob{
+			Cid:       ref.Cid,
+			RecordUri: "at://" + u.Did + "/" + ref.Record,
+		})
+	}
+	if len(refs) == limit {
+		next := strconv.FormatUint(uint64(refs[len(refs)-1].ID), 10)
+		out.Cursor = &next
+	}
+	return out, nil
+}
+
+// didPointsHere reports whether the account's DID document has this
+// server's signing key, ie whether repo commits signed here will verify
+func (s *Server) didPointsHere(ctx context.Context, u *User) bool {
+	doc, err := s.plc.GetDocument(ctx, u.Did)
+	if err != nil {
+		log.Warnw("resolving account DID", "did", u.Did, "err", err)
+		return false
+	}
+	pk, err := doc.GetPublicKey("#atproto")
+	if err != nil {
+		return false
+	}
+	return pk.DID() == s.signingKey.Public().DID()
+}
+
+func (s *Server) handleComAtprotoServerCheckAccountStatus(ctx context.Context) (*serverCheckAccountStatusOutput, error) {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &serverCheckAccountStatusOutput{
+		Activated: u.DeactivatedAt == nil,
+		ValidDid:  s.didPointsHere(ctx, u),
+	}
+
+	if err := s.db.Model(&BlobRef{}).Where("uid = ?", u.ID).Distinct("cid").Count(&out.ExpectedBlobs).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(&Blob{}).Where("uid = ?", u.ID).Count(&out.ImportedBlobs).Error; err != nil {
+		return nil, err
+	}
+
+	head, err := s.repoman.GetRepoRoot(ctx, u.ID)
+	if err != nil || !head.Defined() {
+		// no repo imported yet
+		return out, nil
+	}
+	out.RepoCommit = head.String()
+	if out.RepoRev, err = s.repoman.GetRepoRev(ctx, u.ID); err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := s.repoman.ReadRepo(ctx, u.ID, "", buf); err != nil {
+		return nil, err
+	}
+	carb := buf.Bytes()
+
+	cr, err := car.NewCarReader(bytes.NewReader(carb))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, err := cr.Next(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		out.RepoBlocks++
+	}
+
+	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(carb))
+	if err != nil {
+		return nil, err
+	}
+	if err := r.ForEach(ctx, "", func(k string, v cid.Cid) error {
+		out.IndexedRecords++
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (s *Server) handleComAtprotoServerActivateAccount(ctx context.Context) error {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if u.DeactivatedAt == nil {
+		return nil
+	}
+
+	if !s.didPointsHere(ctx, u) {
+		return &XRPCError{Status: 400, Name: "InvalidRequest", Message: "the account's DID document doesn't have this server's signing key yet"}
+	}
+	if _, err := s.repoman.GetRepoRoot(ctx, u.ID); err != nil {
+		return &XRPCError{Status: 400, Name: "InvalidRequest", Message: "the account has no repo; import it first"}
+	}
+
+	if err := s.db.Model(User{}).Where("id = ?", u.ID).UpdateColumn("deactivated_at", nil).Error; err != nil {
+		return err
+	}
+	log.Infow("activated account", "did", u.Did)
+
+	u.DeactivatedAt = nil
+	return s.emitAccountEvent(ctx, u)
+}
+
+func (s *Server) handleComAtprotoServerDeactivateAccount(ctx context.Context) error {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return err
+	}
+	if u.DeactivatedAt != nil {
+		return nil
+	}
+
+	now := time.Now()
+	if err := s.db.Model(User{}).Where("id = ?", u.ID).UpdateColumn("deactivated_at", now).Error; err != nil {
+		return err
+	}
+	log.Infow("deactivated account", "did", u.Did)
+
+	u.DeactivatedAt = &now
+	return s.emitAccountEvent(ctx, u)
+}
+
+func (s *Server) handleComAtprotoIdentityGetRecommendedDidCredentials(ctx context.Context) (*identityGetRecommendedDidCredentialsOutput, error) {
+	u, err := s.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key := s.signingKey.Public().DID()
+	return &identityGetRecommendedDidCredentialsOutput{
+		RotationKeys:        []string{key},
+		AlsoKnownAs:         []string{"at://" + u.Handle},
+		VerificationMethods: map[string]string{"atproto": key},
+		Services: map[string]any{
+			"atproto_pds": map[string]string{
+				"type":     "AtprotoPersonalDataServer",
+				"endpoint": s.serviceUrl,
+			},
+		},
+	}, nil
+}
+
+func (s *Server) HandleComAtprotoServerGetServiceAuth(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoServerGetServiceAuth")
+	defer span.End()
+
+	var exp int64
+	if p := c.QueryParam("exp"); p != "" {
+		var err error
+		exp, err = strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+	out, handleErr := s.handleComAtprotoServerGetServiceAuth(ctx, c.QueryParam("aud"), c.QueryParam("lxm"), exp)
+	if handleErr != nil {
+		return handleErr
+	}
+	return c.JSON(200, out)
+}
+
+func (s *Server) HandleComAtprotoServerCheckAccountStatus(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoServerCheckAccountStatus")
+	defer span.End()
+
+	out, handleErr := s.handleComAtprotoServerCheckAccountStatus(ctx)
+	if handleErr != nil {
+		return handleErr
+	}
+	return c.JSON(200, out)
+}
+
+func (s *Server) HandleComAtprotoServerActivateAccount(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoServerActivateAccount")
+	defer span.End()
+
+	if handleErr := s.handleComAtprotoServerActivateAccount(ctx); handleErr != nil {
+		return handleErr
+	}
+	return nil
+}
+
+func (s *Server) HandleComAtprotoServerDeactivateAccount(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoServerDeactivateAccount")
+	defer span.End()
+
+	if handleErr := s.handleComAtprotoServerDeactivateAccount(ctx); handleErr != nil {
+		return handleErr
+	}
+	return nil
+}
+
+func (s *Server) HandleComAtprotoRepoImportRepo(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoRepoImportRepo")
+	defer span.End()
+
+	if handleErr := s.handleComAtprotoRepoImportRepo(ctx, c.Request().Body); handleErr != nil {
+		return handleErr
+	}
+	return nil
+}
+
+func (s *Server) HandleComAtprotoRepoListMissingBlobs(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoRepoListMissingBlobs")
+	defer span.End()
+
+	var limit int
+	if p := c.QueryParam("limit"); p != "" {
+		var err error
+		limit, err = strconv.Atoi(p)
+		if err != nil {
+			return err
+		}
+	}
+	out, handleErr := s.handleComAtprotoRepoListMissingBlobs(ctx, c.QueryParam("cursor"), limit)
+	if handleErr != nil {
+		return handleErr
+	}
+	return c.JSON(200, out)
+}
+
+func (s *Server) HandleComAtprotoIdentityGetRecommendedDidCredentials(c echo.Context) error {
+	ctx, span := otel.Tracer("server").Start(c.Request().Context(), "HandleComAtprotoIdentityGetRecommendedDidCredentials")
+	defer span.End()
+
+	out, handleErr := s.handleComAtprotoIdentityGetRecommendedDidCredentials(ctx)
+	if handleErr != nil {
+		return handleErr
+	}
+	return c.JSON(200, out)
+}
@@ -0,0 +1,144 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/plc"
+)
+
+func TestAccountMigration(t *testing.T) {
+	oldPDS, cleanup := newTestServer(t)
+	defer cleanup()
+	newPDS, cleanup2 := newTestServerWithPLC(t, oldPDS.plc)
+	defer cleanup2()
+	newPDS.serviceUrl = "https://newpds.test"
+
+	bg := context.Background()
+	o, err := oldPDS.handleComAtprotoServerCreateAccount(bg, &atproto.ServerCreateAccount_Input{
+		Email:    "test@foo.com",
+		Password: "password",
+		Handle:   "mover.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userCtx := func(s *Server) context.Context {
+		u, err := s.lookupUserByDid(bg, o.Did)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.WithValue(bg, "user", u)
+		return context.WithValue(ctx, "authScope", "com.atproto.access")
+	}
+
+	if _, err := oldPDS.handleComAtprotoRepoCreateRecord(userCtx(oldPDS), &atproto.RepoCreateRecord_Input{
+		Collection: "app.bsky.feed.post",
+		Repo:       o.Did,
+		Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{
+			Text:      "moving soon",
+			CreatedAt: time.Now().Format(time.RFC3339),
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the old PDS vouches for the DID to the new one
+	sa, err := oldPDS.handleComAtprotoServerGetServiceAuth(userCtx(oldPDS), newPDS.serviceDid(), "com.atproto.server.createAccount", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongAud, err := oldPDS.handleComAtprotoServerGetServiceAuth(userCtx(oldPDS), "did:web:elsewhere.test", "com.atproto.server.createAccount", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unbound, err := oldPDS.handleComAtprotoServerGetServiceAuth(userCtx(oldPDS), newPDS.serviceDid(), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// limited sessions can't get a migration token, nor one for any method
+	appPassCtx := context.WithValue(userCtx(oldPDS), "authScope", appPasswordScope)
+	for _, lxm := range []string{"com.atproto.server.createAccount", ""} {
+		if _, err := oldPDS.handleComAtprotoServerGetServiceAuth(appPassCtx, newPDS.serviceDid(), lxm, 0); err == nil {
+			t.Fatalf("expected an app password session to be refused a token for %q", lxm)
+		}
+	}
+
+	input := &atproto.ServerCreateAccount_Input{
+		Did:      &o.Did,
+		Email:    "test@foo.com",
+		Password: "password",
+		Handle:   "mover.test",
+	}
+	if _, err := newPDS.handleComAtprotoServerCreateAccount(context.WithValue(bg, "auth", "Bearer "+wrongAud.Token), input); err == nil {
+		t.Fatal("expected a token for another server to be rejected")
+	}
+	if _, err := newPDS.handleComAtprotoServerCreateAccount(context.WithValue(bg, "auth", "Bearer "+unbound.Token), input); err == nil {
+		t.Fatal("expected a token not bound to createAccount to be rejected")
+	}
+	if _, err := newPDS.handleComAtprotoServerCreateAccount(context.WithValue(bg, "auth", "Bearer "+sa.Token), input); err != nil {
+		t.Fatal(err)
+	}
+	// and the token is single use
+	replay := *input
+	replay.Handle = "mover2.test"
+	_, err = newPDS.handleComAtprotoServerCreateAccount(context.WithValue(bg, "auth", "Bearer "+sa.Token), &replay)
+	var xerr *XRPCError
+	if !errors.As(err, &xerr) || xerr.Name != "InvalidToken" {
+		t.Fatalf("expected a replayed token to be rejected, got %v", err)
+	}
+
+	repoCar, err := oldPDS.handleComAtprotoSyncGetRepo(bg, o.Did, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newPDS.handleComAtprotoRepoImportRepo(userCtx(newPDS), repoCar); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := newPDS.handleComAtprotoServerCheckAccountStatus(userCtx(newPDS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Activated || status.ValidDid || status.IndexedRecords == 0 || status.RepoBlocks == 0 {
+		t.Fatalf("unexpected account status after import: %+v", status)
+	}
+
+	// until the DID points at the new PDS, it can't take over
+	if err := newPDS.handleComAtprotoServerActivateAccount(userCtx(newPDS)); err == nil {
+		t.Fatal("expected activation to fail before the DID is updated")
+	}
+	if err := oldPDS.db.Model(&plc.FakeDidMapping{}).Where("did = ?", o.Did).Updates(map[string]any{
+		"pub_key_mbase": newPDS.signingKey.Public().MultibaseString(),
+		"key_type":      newPDS.signingKey.KeyType(),
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := newPDS.handleComAtprotoServerActivateAccount(userCtx(newPDS)); err != nil {
+		t.Fatal(err)
+	}
+	if err := oldPDS.handleComAtprotoServerDeactivateAccount(userCtx(oldPDS)); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = newPDS.handleComAtprotoServerCheckAccountStatus(userCtx(newPDS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Activated || !status.ValidDid {
+		t.Fatalf("unexpected account status after activation: %+v", status)
+	}
+	old, err := oldPDS.handleComAtprotoServerCheckAccountStatus(userCtx(oldPDS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Activated {
+		t.Fatal("expected the old account to be deactivated")
+	}
+}
@@ -0,0 +1,632 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	comatprototypes "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/ipfs/go-cid"
+	"gorm.io/gorm"
+)
+
+const (
+	modActionTakedown    = "com.atproto.admin.defs#takedown"
+	modActionFlag        = "com.atproto.admin.defs#flag"
+	modActionAcknowledge = "com.atproto.admin.defs#acknowledge"
+	modActionEscalate    = "com.atproto.admin.defs#escalate"
+)
+
+var ErrAccountTakedown = &XRPCError{Status: http.StatusUnauthorized, Name: "AccountTakedown", Message: "account has been taken down"}
+
+var ErrRepoTakendown = &XRPCError{Status: http.StatusBadRequest, Name: "RepoTakendown", Message: "repo has been taken down"}
+
+// ModerationAction is an action an admin took against an account, a record
+// or some blobs. Actions are never deleted, so together with their
+// reversals they are the audit trail of moderation on this server. Only
+// takedowns change what the server does; the other actions are notes.
+type ModerationAction struct {
+	gorm.Model
+	Action     string
+	SubjectDid string `gorm:"index"`
+	// SubjectUri and SubjectCid are set for actions on a record
+	SubjectUri string `gorm:"index"`
+	SubjectCid string
+	// SubjectBlobCids is a comma separated list of blobs the action covers
+	SubjectBlobCids string
+	Reason          string
+	CreatedBy       string
+
+	DurationInHours *int64
+	// ExpiresAt is when a temporary action (eg a suspension) ends
+	ExpiresAt *time.Time
+
+	ReversedAt     *time.Time
+	ReversedBy     string
+	ReversalReason string
+}
+
+func (a *ModerationAction) active() bool {
+	return a.ReversedAt == nil && (a.ExpiresAt == nil || time.Now().Before(*a.ExpiresAt))
+}
+
+func (a *ModerationAction) blobCids() []string {
+	if a.SubjectBlobCids == "" {
+		return []string{}
+	}
+	return strings.Split(a.SubjectBlobCids, ",")
+}
+
+// takenDown reports whether the account is taken down or suspended
+func (u *User) takenDown() bool {
+	return u.TakedownActionID != 0 && (u.TakedownUntil == nil || time.Now().Before(*u.TakedownUntil))
+}
+
+// accountStatus is the account's status as reported in #account events,
+// or "" if it is active
+func (u *User) accountStatus() string {
+	switch {
+	case u.takenDown() && u.TakedownUntil != nil:
+		return "suspended"
+	case u.takenDown():
+		return "takendown"
+	case u.DeactivatedAt != nil:
+		return "deactivated"
+	default:
+		return ""
+	}
+}
+
+// emitAccountEvent tells firehose consumers about a change to the account's
+// status. Suspensions end on their own, without an event.
+func (s *Server) emitAccountEvent(ctx context.Context, u *User) error {
+	evt := &comatprototypes.SyncSubscribeRepos_Account{
+		Did:    u.Did,
+		Active: true,
+		Time:   time.Now().Format(util.ISO8601),
+	}
+	if status := u.accountStatus(); status != "" {
+		evt.Active = false
+		evt.Status = &status
+	}
+
+	if err := s.events.AddEvent(ctx, &events.XRPCStreamEvent{RepoAccount: evt}); err != nil {
+		return fmt.Errorf("failed to push account event: %w", err)
+	}
+	return nil
+}
+
+// checkRepoAvailable rejects reads of a taken down repo
+func (s *Server) checkRepoAvailable(u *User) error {
+	if u.takenDown() {
+		return ErrRepoTakendown
+	}
+	return nil
+}
+
+func (s *Server) recordTakenDown(ctx context.Context, uri string) (bool, error) {
+	var actions []ModerationAction
+	if err := s.db.Find(&actions, "subject_uri = ? AND action = ? AND reversed_at IS NULL", uri, modActionTakedown).Error; err != nil {
+		return false, err
+	}
+	for _, a := range actions {
+		if a.active() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Server) blobTakenDown(ctx context.Context, did, c string) (bool, error) {
+	var actions []ModerationAction
+	if err := s.db.Find(&actions, "subject_did = ? AND action = ? AND reversed_at IS NULL AND subject_blob_cids LIKE ?", did, modActionTakedown, "%"+c+"%").Error; err != nil {
+		return false, err
+	}
+	for _, a := range actions {
+		if !a.active() {
+			continue
+		}
+		for _, bc := range a.blobCids() {
+			if bc == c {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (s *Server) handleComAtprotoAdminTakeModerationAction(ctx context.Context, body *comatprototypes.AdminTakeModerationAction_Input) (*comatprototypes.AdminDefs_ActionView, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	switch body.Action {
+	case modActionTakedown, modActionFlag, modActionAcknowledge, modActionEscalate:
+	default:
+		return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: fmt.Sprintf("unsupported moderation action %q", body.Action)}
+	}
+	if body.Subject == nil {
+		return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "subject is required"}
+	}
+
+	act := ModerationAction{
+		Action:          body.Action,
+		Reason:          body.Reason,
+		CreatedBy:       body.CreatedBy,
+		DurationInHours: body.DurationInHours,
+		SubjectBlobCids: strings.Join(body.SubjectBlobCids, ","),
+	}
+	switch {
+	case body.Subject.AdminDefs_RepoRef != nil:
+		act.SubjectDid = body.Subject.AdminDefs_RepoRef.Did
+		if len(body.SubjectBlobCids) > 0 {
+			return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "blobs can only be actioned along with a record"}
+		}
+	case body.Subject.RepoStrongRef != nil:
+		puri, err := util.ParseAtUri(body.Subject.RepoStrongRef.Uri)
+		if err != nil {
+			return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: err.Error()}
+		}
+		act.SubjectDid = puri.Did
+		act.SubjectUri = body.Subject.RepoStrongRef.Uri
+		act.SubjectCid = body.Subject.RepoStrongRef.Cid
+	default:
+		return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "unsupported subject type"}
+	}
+	if body.DurationInHours != nil {
+		if *body.DurationInHours <= 0 {
+			return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "durationInHours must be positive"}
+		}
+		exp := time.Now().Add(time.Duration(*body.DurationInHours) * time.Hour)
+		act.ExpiresAt = &exp
+	}
+
+	u, err := s.lookupUserByDid(ctx, act.SubjectDid)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &XRPCError{Status: 404, Name: "RepoNotFound", Message: "no such account on this server"}
+		}
+		return nil, err
+	}
+
+	if err := s.db.Create(&act).Error; err != nil {
+		return nil, err
+	}
+	log.Infow("moderation action taken", "id", act.ID, "action", act.Action, "did", act.SubjectDid, "uri", act.SubjectUri, "by", act.CreatedBy, "reason", act.Reason)
+
+	if act.Action == modActionTakedown && act.SubjectUri == "" {
+		u.TakedownActionID = act.ID
+		u.TakedownUntil = act.ExpiresAt
+		if err := s.db.Model(u).Select("takedown_action_id", "takedown_until").Updates(u).Error; err != nil {
+			return nil, err
+		}
+		if err := s.emitAccountEvent(ctx, u); err != nil {
+			log.Errorw("failed to emit account event", "did", u.Did, "err", err)
+		}
+	}
+
+	return actionView(&act), nil
+}
+
+func (s *Server) handleComAtprotoAdminReverseModerationAction(ctx context.Context, body *comatprototypes.AdminReverseModerationAction_Input) (*comatprototypes.AdminDefs_ActionView, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	act, err := s.getModerationAction(ctx, uint(body.Id))
+	if err != nil {
+		return nil, err
+	}
+	if act.ReversedAt != nil {
+		return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "moderation action has already been reversed"}
+	}
+
+	now := time.Now()
+	act.ReversedAt = &now
+	act.ReversedBy = body.CreatedBy
+	act.ReversalReason = body.Reason
+	if err := s.db.Save(act).Error; err != nil {
+		return nil, err
+	}
+	log.Infow("moderation action reversed", "id", act.ID, "action", act.Action, "did", act.SubjectDid, "uri", act.SubjectUri, "by", act.ReversedBy, "reason", act.ReversalReason)
+
+	if act.Action == modActionTakedown && act.SubjectUri == "" {
+		u, err := s.lookupUserByDid(ctx, act.SubjectDid)
+		if err != nil {
+			return nil, err
+		}
+		if u.TakedownActionID == act.ID {
+			u.TakedownActionID = 0
+			u.TakedownUntil = nil
+			if err := s.db.Model(u).Select("takedown_action_id", "takedown_until").Updates(u).Error; err != nil {
+				return nil, err
+			}
+			if err := s.emitAccountEvent(ctx, u); err != nil {
+				log.Errorw("failed to emit account event", "did", u.Did, "err", err)
+			}
+		}
+	}
+
+	return actionView(act), nil
+}
+
+func (s *Server) getModerationAction(ctx context.Context, id uint) (*ModerationAction, error) {
+	var act ModerationAction
+	if err := s.db.First(&act, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &XRPCError{Status: 404, Name: "NotFound", Message: "no such moderation action"}
+		}
+		return nil, err
+	}
+	return &act, nil
+}
+
+func (s *Server) handleComAtprotoAdminGetModerationAction(ctx context.Context, id int) (*comatprototypes.AdminDefs_ActionViewDetail, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	act, err := s.getModerationAction(ctx, uint(id))
+	if err != nil {
+		return nil, err
+	}
+
+	v := actionView(act)
+	out := &comatprototypes.AdminDefs_ActionViewDetail{
+		Action:          v.Action,
+		CreatedAt:       v.CreatedAt,
+		CreatedBy:       v.CreatedBy,
+		DurationInHours: v.DurationInHours,
+		Id:              v.Id,
+		Reason:          v.Reason,
+		ResolvedReports: []*comatprototypes.AdminDefs_ReportView{},
+		Reversal:        v.Reversal,
+		Subject:         &comatprototypes.AdminDefs_ActionViewDetail_Subject{},
+		SubjectBlobs:    []*comatprototypes.AdminDefs_BlobView{},
+	}
+
+	u, err := s.lookupUserByDid(ctx, act.SubjectDid)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if act.SubjectUri != "" {
+			out.Subject.AdminDefs_RecordViewNotFound = &comatprototypes.AdminDefs_RecordViewNotFound{Uri: act.SubjectUri}
+		} else {
+			out.Subject.AdminDefs_RepoViewNotFound = &comatprototypes.AdminDefs_RepoViewNotFound{Did: act.SubjectDid}
+		}
+		return out, nil
+	}
+
+	if act.SubjectUri != "" {
+		rv, err := s.adminRecordView(ctx, u, act.SubjectUri)
+		if err != nil {
+			return nil, err
+		}
+		if rv == nil {
+			out.Subject.AdminDefs_RecordViewNotFound = &comatprototypes.AdminDefs_RecordViewNotFound{Uri: act.SubjectUri}
+		} else {
+			out.Subject.AdminDefs_RecordView = rv
+		}
+	} else {
+		rv, err := s.adminRepoView(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		out.Subject.AdminDefs_RepoView = rv
+	}
+
+	for _, bc := range act.blobCids() {
+		var b Blob
+		if err := s.db.Find(&b, "uid = ? AND cid = ?", u.ID, bc).Error; err != nil {
+			return nil, err
+		}
+		if b.ID == 0 {
+			continue
+		}
+		out.SubjectBlobs = append(out.SubjectBlobs, &comatprototypes.AdminDefs_BlobView{
+			Cid:       b.Cid,
+			CreatedAt: b.CreatedAt.Format(time.RFC3339),
+			MimeType:  b.MimeType,
+			Size:      b.Size,
+		})
+	}
+
+	return out, nil
+}
+
+// handleComAtprotoAdminGetModerationActions lists moderation actions, newest
+// first, optionally only those on one subject (a DID or an at:// URI)
+func (s *Server) handleComAtprotoAdminGetModerationActions(ctx context.Context, cursor string, limit int, subject string) (*comatprototypes.AdminGetModerationActions_Output, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	q := s.db.Order("id desc").Limit(limit)
+	switch {
+	case strings.HasPrefix(subject, "at://"):
+		q = q.Where("subject_uri = ?", subject)
+	case subject != "":
+		q = q.Where("subject_did = ? AND subject_uri = ''", subject)
+	}
+	if cursor != "" {
+		before, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "invalid cursor"}
+		}
+		q = q.Where("id < ?", before)
+	}
+
+	var acts []ModerationAction
+	if err := q.Find(&acts).Error; err != nil {
+		return nil, err
+	}
+
+	out := &comatprototypes.AdminGetModerationActions_Output{Actions: []*comatprototypes.AdminDefs_ActionView{}}
+	for i := range acts {
+		out.Actions = append(out.Actions, actionView(&acts[i]))
+	}
+	if len(acts) == limit {
+		next := strconv.FormatUint(uint64(acts[len(acts)-1].ID), 10)
+		out.Cursor = &next
+	}
+	return out, nil
+}
+
+func (s *Server) handleComAtprotoAdminGetRepo(ctx context.Context, did string) (*comatprototypes.AdminDefs_RepoViewDetail, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	u, err := s.lookupUserByDid(ctx, did)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &XRPCError{Status: 404, Name: "RepoNotFound", Message: "no such account on this server"}
+		}
+		return nil, err
+	}
+
+	rv, err := s.adminRepoView(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	var acts []ModerationAction
+	if err := s.db.Order("id desc").Find(&acts, "subject_did = ? AND subject_uri = ''", u.Did).Error; err != nil {
+		return nil, err
+	}
+	actions := []*comatprototypes.AdminDefs_ActionView{}
+	for i := range acts {
+		actions = append(actions, actionView(&acts[i]))
+	}
+
+	var codes []InviteCode
+	if err := s.db.Order("id asc").Find(&codes, "for_account = ?", u.Did).Error; err != nil {
+		return nil, err
+	}
+	invites, err := s.inviteCodeViews(ctx, codes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &comatprototypes.AdminDefs_RepoViewDetail{
+		Did:             rv.Did,
+		Email:           rv.Email,
+		Handle:          rv.Handle,
+		IndexedAt:       rv.IndexedAt,
+		Invites:         invites,
+		InvitesDisabled: rv.InvitesDisabled,
+		Moderation: &comatprototypes.AdminDefs_ModerationDetail{
+			Actions:       actions,
+			CurrentAction: rv.Moderation.CurrentAction,
+			Reports:       []*comatprototypes.AdminDefs_ReportView{},
+		},
+		RelatedRecords: rv.RelatedRecords,
+	}, nil
+}
+
+func (s *Server) handleComAtprotoAdminGetRecord(ctx context.Context, c string, uri string) (*comatprototypes.AdminDefs_RecordViewDetail, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	puri, err := util.ParseAtUri(uri)
+	if err != nil {
+		return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: err.Error()}
+	}
+	u, err := s.lookupUserByDid(ctx, puri.Did)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &XRPCError{Status: 404, Name: "RecordNotFound", Message: "no such record on this server"}
+		}
+		return nil, err
+	}
+
+	rv, err := s.adminRecordView(ctx, u, uri)
+	if err != nil {
+		return nil, err
+	}
+	if rv == nil || (c != "" && rv.Cid != c) {
+		return nil, &XRPCError{Status: 404, Name: "RecordNotFound", Message: "no such record on this server"}
+	}
+
+	var acts []ModerationAction
+	if err := s.db.Order("id desc").Find(&acts, "subject_uri = ?", uri).Error; err != nil {
+		return nil, err
+	}
+	actions := []*comatprototypes.AdminDefs_ActionView{}
+	for i := range acts {
+		actions = append(actions, actionView(&acts[i]))
+	}
+
+	return &comatprototypes.AdminDefs_RecordViewDetail{
+		Blobs:     []*comatprototypes.AdminDefs_BlobView{},
+		Cid:       rv.Cid,
+		IndexedAt: rv.IndexedAt,
+		Moderation: &comatprototypes.AdminDefs_ModerationDetail{
+			Actions:       actions,
+			CurrentAction: rv.Moderation.CurrentAction,
+			Reports:       []*comatprototypes.AdminDefs_ReportView{},
+		},
+		Repo:  rv.Repo,
+		Uri:   rv.Uri,
+		Value: rv.Value,
+	}, nil
+}
+
+// handleComAtprotoAdminSearchRepos finds accounts by handle prefix, exact
+// email address or DID
+func (s *Server) handleComAtprotoAdminSearchRepos(ctx context.Context, cursor string, invitedBy string, limit int, term string) (*comatprototypes.AdminSearchRepos_Output, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	q := s.db.Order("id asc").Limit(limit)
+	if term != "" {
+		term = strings.ToLower(strings.TrimPrefix(term, "@"))
+		q = q.Where("handle LIKE ? OR lower(email) = ? OR did = ?", term+"%", term, term)
+	}
+	if invitedBy != "" {
+		q = q.Where("did IN (SELECT used_by FROM invite_code_uses JOIN invite_codes ON invite_codes.code = invite_code_uses.code WHERE invite_codes.for_account = ?)", invitedBy)
+	}
+	if cursor != "" {
+		after, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, &XRPCError{Status: 400, Name: "InvalidRequest", Message: "invalid cursor"}
+		}
+		q = q.Where("id > ?", after)
+	}
+
+	var users []User
+	if err := q.Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	out := &comatprototypes.AdminSearchRepos_Output{Repos: []*comatprototypes.AdminDefs_RepoView{}}
+	for i := range users {
+		rv, err := s.adminRepoView(ctx, &users[i])
+		if err != nil {
+			return nil, err
+		}
+		out.Repos = append(out.Repos, rv)
+	}
+	if len(users) == limit {
+		next := strconv.FormatUint(uint64(users[len(users)-1].ID), 10)
+		out.Cursor = &next
+	}
+	return out, nil
+}
+
+func (s *Server) adminRepoView(ctx context.Context, u *User) (*comatprototypes.AdminDefs_RepoView, error) {
+	email := u.Email
+	disabled := u.InvitesDisabled
+	rv := &comatprototypes.AdminDefs_RepoView{
+		Did:             u.Did,
+		Email:           &email,
+		Handle:          u.Handle,
+		IndexedAt:       u.CreatedAt.Format(time.RFC3339),
+		InvitesDisabled: &disabled,
+		Moderation:      &comatprototypes.AdminDefs_Moderation{},
+		RelatedRecords:  []*lexutil.LexiconTypeDecoder{},
+	}
+	if u.takenDown() {
+		act, err := s.getModerationAction(ctx, u.TakedownActionID)
+		if err != nil {
+			return nil, err
+		}
+		rv.Moderation.CurrentAction = &comatprototypes.AdminDefs_ActionViewCurrent{
+			Action:          &act.Action,
+			DurationInHours: act.DurationInHours,
+			Id:              int64(act.ID),
+		}
+	}
+	return rv, nil
+}
+
+// adminRecordView returns the record's view, or nil if it doesn't exist
+func (s *Server) adminRecordView(ctx context.Context, u *User, uri string) (*comatprototypes.AdminDefs_RecordView, error) {
+	puri, err := util.ParseAtUri(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	reccid, rec, err := s.repoman.GetRecord(ctx, u.ID, puri.Collection, puri.Rkey, cid.Undef)
+	if err != nil {
+		return nil, nil
+	}
+
+	repo, err := s.adminRepoView(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := &comatprototypes.AdminDefs_RecordView{
+		BlobCids:   []string{},
+		Cid:        reccid.String(),
+		IndexedAt:  u.CreatedAt.Format(time.RFC3339),
+		Moderation: &comatprototypes.AdminDefs_Moderation{},
+		Repo:       repo,
+		Uri:        uri,
+		Value:      &lexutil.LexiconTypeDecoder{Val: rec},
+	}
+	if blobs, err := recordBlobCids(rec); err == nil {
+		rv.BlobCids = append(rv.BlobCids, blobs...)
+	}
+
+	var acts []ModerationAction
+	if err := s.db.Order("id desc").Find(&acts, "subject_uri = ? AND reversed_at IS NULL", uri).Error; err != nil {
+		return nil, err
+	}
+	for i := range acts {
+		if acts[i].active() {
+			rv.Moderation.CurrentAction = &comatprototypes.AdminDefs_ActionViewCurrent{
+				Action:          &acts[i].Action,
+				DurationInHours: acts[i].DurationInHours,
+				Id:              int64(acts[i].ID),
+			}
+			break
+		}
+	}
+	return rv, nil
+}
+
+func actionView(a *ModerationAction) *comatprototypes.AdminDefs_ActionView {
+	action := a.Action
+	v := &comatprototypes.AdminDefs_ActionView{
+		Action:            &action,
+		CreatedAt:         a.CreatedAt.Format(time.RFC3339),
+		CreatedBy:         a.CreatedBy,
+		DurationInHours:   a.DurationInHours,
+		Id:                int64(a.ID),
+		Reason:            a.Reason,
+		ResolvedReportIds: []int64{},
+		Subject:           &comatprototypes.AdminDefs_ActionView_Subject{},
+		SubjectBlobCids:   a.blobCids(),
+	}
+	if a.SubjectUri != "" {
+		v.Subject.RepoStrongRef = &comatprototypes.RepoStrongRef{Uri: a.SubjectUri, Cid: a.SubjectCid}
+	} else {
+		v.Subject.AdminDefs_RepoRef = &comatprototypes.AdminDefs_RepoRef{Did: a.SubjectDid}
+	}
+	if a.ReversedAt != nil {
+		v.Reversal = &comatprototypes.AdminDefs_ActionReversal{
+			CreatedAt: a.ReversedAt.Format(time.RFC3339),
+			CreatedBy: a.ReversedBy,
+			Reason:    a.ReversalReason,
+		}
+	}
+	return v
+}
@@ -0,0 +1,167 @@
+package pds
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+func TestModeration(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	s.SetAdminPassword("hunter2")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := "http://" + ln.Addr().String()
+	go s.RunAPIWithListener(ln)
+	defer s.Shutdown(context.Background())
+
+	ctx := context.Background()
+	pw := "hunter2"
+	admin := &xrpc.Client{Host: host, AdminToken: &pw}
+
+	o, err := s.handleComAtprotoServerCreateAccount(ctx, &atproto.ServerCreateAccount_Input{
+		Email:    "Abuser@foo.com",
+		Password: "password",
+		Handle:   "abuser.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.lookupUserByDid(ctx, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, err := s.handleComAtprotoRepoCreateRecord(context.WithValue(ctx, "user", u), &atproto.RepoCreateRecord_Input{
+		Collection: "app.bsky.feed.post",
+		Repo:       o.Did,
+		Record: &lexutil.LexiconTypeDecoder{Val: &bsky.FeedPost{
+			Text:      "something awful",
+			CreatedAt: time.Now().Format(time.RFC3339),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := atproto.AdminSearchRepos(ctx, admin, "", "", 10, "", "abuser@foo.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found.Repos) != 1 || found.Repos[0].Did != o.Did {
+		t.Fatalf("expected to find the account by email, got %+v", found.Repos)
+	}
+
+	acctEvts, cancel, err := s.events.Subscribe(ctx, "test", func(evt *events.XRPCStreamEvent) bool {
+		return evt.RepoAccount != nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	nextAccountEvent := func() *atproto.SyncSubscribeRepos_Account {
+		select {
+		case evt := <-acctEvts:
+			return evt.RepoAccount
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an #account event")
+			return nil
+		}
+	}
+
+	// take down the record
+	recAct, err := atproto.AdminTakeModerationAction(ctx, admin, &atproto.AdminTakeModerationAction_Input{
+		Action:    modActionTakedown,
+		CreatedBy: "did:plc:moderator",
+		Reason:    "spam",
+		Subject: &atproto.AdminTakeModerationAction_Input_Subject{
+			RepoStrongRef: &atproto.RepoStrongRef{Uri: post.Uri, Cid: post.Cid},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	puri, err := util.ParseAtUri(post.Uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.handleComAtprotoRepoGetRecord(ctx, "", puri.Collection, o.Did, puri.Rkey); err == nil {
+		t.Fatal("expected the taken down record to be hidden")
+	}
+
+	// then suspend the account
+	hours := int64(24)
+	act, err := atproto.AdminTakeModerationAction(ctx, admin, &atproto.AdminTakeModerationAction_Input{
+		Action:          modActionTakedown,
+		CreatedBy:       "did:plc:moderator",
+		Reason:          "repeated spam",
+		DurationInHours: &hours,
+		Subject: &atproto.AdminTakeModerationAction_Input_Subject{
+			AdminDefs_RepoRef: &atproto.AdminDefs_RepoRef{Did: o.Did},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt := nextAccountEvent(); evt.Active || evt.Status == nil || *evt.Status != "suspended" {
+		t.Fatalf("unexpected account event: %+v", evt)
+	}
+
+	if _, err := s.handleComAtprotoServerCreateSession(ctx, &atproto.ServerCreateSession_Input{
+		Identifier: "abuser.test",
+		Password:   "password",
+	}); err != ErrAccountTakedown {
+		t.Fatalf("expected login to be refused, got %v", err)
+	}
+	if _, err := s.handleComAtprotoSyncGetRepo(ctx, o.Did, ""); err != ErrRepoTakendown {
+		t.Fatalf("expected repo to be unavailable, got %v", err)
+	}
+
+	detail, err := atproto.AdminGetRepo(ctx, admin, o.Did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Moderation.CurrentAction == nil || detail.Moderation.CurrentAction.Id != act.Id {
+		t.Fatalf("expected the suspension to be the current action: %+v", detail.Moderation)
+	}
+
+	if _, err := atproto.AdminReverseModerationAction(ctx, admin, &atproto.AdminReverseModerationAction_Input{
+		Id:        act.Id,
+		CreatedBy: "did:plc:moderator",
+		Reason:    "appealed",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if evt := nextAccountEvent(); !evt.Active || evt.Status != nil {
+		t.Fatalf("unexpected account event: %+v", evt)
+	}
+	if _, err := s.handleComAtprotoServerCreateSession(ctx, &atproto.ServerCreateSession_Input{
+		Identifier: "abuser.test",
+		Password:   "password",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the audit trail keeps both actions and the reversal
+	trail, err := atproto.AdminGetModerationActions(ctx, admin, "", 10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trail.Actions) != 2 || trail.Actions[0].Id != act.Id || trail.Actions[1].Id != recAct.Id {
+		t.Fatalf("unexpected moderation actions: %+v", trail.Actions)
+	}
+	if trail.Actions[0].Reversal == nil || trail.Actions[0].Reversal.Reason != "appealed" {
+		t.Fatalf("expected the suspension's reversal to be recorded: %+v", trail.Actions[0])
+	}
+}
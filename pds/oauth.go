@@ -0,0 +1,725 @@
+package pds
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/util"
+
+	gojwt "github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"gorm.io/gorm"
+)
+
+// The PDS is its own OAuth authorization server (atproto OAuth profile):
+// clients push their authorization request (PAR), the user approves it on
+// the consent page, and the client exchanges the code for DPoP-bound
+// tokens. Clients are identified by the URL of their metadata document;
+// only public clients ("token_endpoint_auth_method": "none") are supported.
+
+const (
+	oauthRequestLifetime = 5 * time.Minute
+	oauthCodeLifetime    = time.Minute
+	oauthAccessLifetime  = time.Hour
+	oauthRefreshLifetime = 30 * 24 * time.Hour
+
+	oauthRequestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+	// client metadata documents larger than this are refused
+	maxClientMetadataSize = 64 << 10
+)
+
+var oauthScopesSupported = []string{"atproto", "transition:generic"}
+
+// OAuthRequest is a pushed authorization request, which becomes an
+// authorization code once the user approves it
+type OAuthRequest struct {
+	gorm.Model
+	RequestURI    string `gorm:"uniqueIndex"`
+	ClientID      string
+	RedirectURI   string
+	Scope         string
+	State         string
+	CodeChallenge string
+	LoginHint     string
+	// JWK thumbprint of the DPoP key used for the request, if any
+	DpopJkt   string
+	ExpiresAt time.Time
+
+	// set when the user approves the request
+	Code string `gorm:"index"`
+	Did  string
+}
+
+// OAuthSession is a client's grant of access to an account, held by its
+// (single use, rotating) refresh token
+type OAuthSession struct {
+	gorm.Model
+	RefreshTokenHash string `gorm:"uniqueIndex"`
+	ClientID         string
+	Did              string `gorm:"index"`
+	Scope            string
+	DpopJkt          string
+	ExpiresAt        time.Time
+}
+
+type oauthClientMetadata struct {
+	ClientID                string   `json:"client_id"`
+	ClientName              string   `json:"client_name,omitempty"`
+	ClientURI               string   `json:"client_uri,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types"`
+	Scope                   string   `json:"scope"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	ApplicationType         string   `json:"application_type"`
+	DpopBoundAccessTokens   bool     `json:"dpop_bound_access_tokens"`
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+	Sub          string `json:"sub"`
+}
+
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func newOAuthError(code, format string, args ...any) *oauthError {
+	return &oauthError{Code: code, Description: fmt.Sprintf(format, args...)}
+}
+
+// oauthIssuer is the PDS's public URL, which is both the authorization
+// server issuer and the resource server
+func (s *Server) oauthIssuer(c echo.Context) string {
+	if s.serviceUrl != "" {
+		return strings.TrimSuffix(s.serviceUrl, "/")
+	}
+	return c.Scheme() + "://" + c.Request().Host
+}
+
+func (s *Server) HandleOAuthProtectedResource(c echo.Context) error {
+	issuer := s.oauthIssuer(c)
+	return c.JSON(200, map[string]any{
+		"resource":                 issuer,
+		"authorization_servers":    []string{issuer},
+		"scopes_supported":         oauthScopesSupported,
+		"bearer_methods_supported": []string{"header"},
+	})
+}
+
+func (s *Server) HandleOAuthServerMetadata(c echo.Context) error {
+	issuer := s.oauthIssuer(c)
+	return c.JSON(200, map[string]any{
+		"issuer":                                         issuer,
+		"authorization_endpoint":                         issuer + "/oauth/authorize",
+		"token_endpoint":                                 issuer + "/oauth/token",
+		"pushed_authorization_request_endpoint":          issuer + "/oauth/par",
+		"require_pushed_authorization_requests":          true,
+		"response_types_supported":                       []string{"code"},
+		"response_modes_supported":                       []string{"query"},
+		"grant_types_supported":                          []string{"authorization_code", "refresh_token"},
+		"code_challenge_methods_supported":               []string{"S256"},
+		"token_endpoint_auth_methods_supported":          []string{"none"},
+		"scopes_supported":                               oauthScopesSupported,
+		"subject_types_supported":                        []string{"public"},
+		"dpop_signing_alg_values_supported":              []string{"ES256"},
+		"authorization_response_iss_parameter_supported": true,
+		"client_id_metadata_document_supported":          true,
+	})
+}
+
+// oauthRespondError writes an OAuth error response. DPoP nonce errors are
+// given the nonce to retry with.
+func (s *Server) oauthRespondError(c echo.Context, status int, err error) error {
+	var oe *oauthError
+	switch {
+	case errors.Is(err, errUseDPoPNonce):
+		oe = newOAuthError("use_dpop_nonce", "authorization server requires nonce in DPoP proof")
+	case errors.As(err, &oe):
+	default:
+		oe = newOAuthError("server_error", "%s", err)
+		status = http.StatusInternalServerError
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(status, oe)
+}
+
+// resolveOAuthClient fetches and validates a client's metadata. Loopback
+// clients ("http://localhost") for native apps have no metadata document;
+// their redirect URIs and scope come from the client ID's query instead.
+func (s *Server) resolveOAuthClient(ctx context.Context, clientID string) (*oauthClientMetadata, error) {
+	u, err := url.Parse(clientID)
+	if err != nil || u.Fragment != "" {
+		return nil, newOAuthError("invalid_client", "invalid client_id")
+	}
+
+	if u.Scheme == "http" && u.Host == "localhost" && (u.Path == "" || u.Path == "/") {
+		q := u.Query()
+		md := &oauthClientMetadata{
+			ClientID:                clientID,
+			ClientName:              "Native client",
+			RedirectURIs:            q["redirect_uri"],
+			GrantTypes:              []string{"authorization_code", "refresh_token"},
+			ResponseTypes:           []string{"code"},
+			Scope:                   q.Get("scope"),
+			TokenEndpointAuthMethod: "none",
+			ApplicationType:         "native",
+			DpopBoundAccessTokens:   true,
+		}
+		if len(md.RedirectURIs) == 0 {
+			md.RedirectURIs = []string{"http://127.0.0.1/", "http://[::1]/"}
+		}
+		if md.Scope == "" {
+			md.Scope = "atproto"
+		}
+		return md, nil
+	}
+
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, newOAuthError("invalid_client", "client_id must be an https URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, clientID, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.oauthHTTPClient.Do(req)
+	if err != nil {
+		return nil, newOAuthError("invalid_client", "fetching client metadata: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newOAuthError("invalid_client", "fetching client metadata: status %d", resp.StatusCode)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxClientMetadataSize+1))
+	if err != nil {
+		return nil, newOAuthError("invalid_client", "fetching client metadata: %s", err)
+	}
+	if len(b) > maxClientMetadataSize {
+		return nil, newOAuthError("invalid_client", "client metadata is too large")
+	}
+	var md oauthClientMetadata
+	if err := json.Unmarshal(b, &md); err != nil {
+		return nil, newOAuthError("invalid_client", "invalid client metadata: %s", err)
+	}
+
+	switch {
+	case md.ClientID != clientID:
+		return nil, newOAuthError("invalid_client", "client metadata client_id mismatch")
+	case md.TokenEndpointAuthMethod != "" && md.TokenEndpointAuthMethod != "none":
+		return nil, newOAuthError("invalid_client", "only public clients are supported")
+	case !md.DpopBoundAccessTokens:
+		return nil, newOAuthError("invalid_client", "client must use DPoP-bound access tokens")
+	case len(md.RedirectURIs) == 0:
+		return nil, newOAuthError("invalid_client", "client metadata has no redirect_uris")
+	case !slices.Contains(md.GrantTypes, "authorization_code"):
+		return nil, newOAuthError("invalid_client", "client doesn't use the authorization_code grant")
+	case !slices.Contains(strings.Fields(md.Scope), "atproto"):
+		return nil, newOAuthError("invalid_client", "client scope must include atproto")
+	}
+	return &md, nil
+}
+
+// newOAuthHTTPClient is the client for fetching client metadata. Client IDs
+// are URLs anyone can submit, so it won't connect to private, loopback or
+// link-local addresses.
+func newOAuthHTTPClient() *http.Client {
+	cfg := util.DefaultHTTPClientConfig()
+	cfg.RetryMax = 0
+	cfg.Timeout = 10 * time.Second
+	return util.NewSafeHTTPClient(cfg)
+}
+
+func isLoopbackHost(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// redirectAllowed checks a redirect URI against the client's registered
+// ones. Loopback redirects may use any port, since native apps listen on
+// whatever port is free.
+func (md *oauthClientMetadata) redirectAllowed(redirectURI string) bool {
+	ru, err := url.Parse(redirectURI)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range md.RedirectURIs {
+		if allowed == redirectURI {
+			return true
+		}
+		au, err := url.Parse(allowed)
+		if err != nil {
+			continue
+		}
+		if ru.Scheme == "http" && au.Scheme == "http" && isLoopbackHost(ru.Hostname()) && ru.Hostname() == au.Hostname() &&
+			(au.Path == "/" || au.Path == ru.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOAuthScope validates a requested scope against what the client and
+// server support
+func checkOAuthScope(requested, clientScope string) error {
+	scopes := strings.Fields(requested)
+	if !slices.Contains(scopes, "atproto") {
+		return newOAuthError("invalid_scope", "scope must include atproto")
+	}
+	allowed := strings.Fields(clientScope)
+	for _, sc := range scopes {
+		if !slices.Contains(oauthScopesSupported, sc) {
+			return newOAuthError("invalid_scope", "unsupported scope %q", sc)
+		}
+		if !slices.Contains(allowed, sc) {
+			return newOAuthError("invalid_scope", "scope %q isn't in the client's metadata", sc)
+		}
+	}
+	return nil
+}
+
+func randomOAuthToken(prefix string) string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return prefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashRefreshToken(tok string) string {
+	h := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(h[:])
+}
+
+// HandleOAuthPAR handles pushed authorization requests
+func (s *Server) HandleOAuthPAR(c echo.Context) error {
+	ctx := c.Request().Context()
+	c.Response().Header().Set("DPoP-Nonce", s.dpopNonce())
+	issuer := s.oauthIssuer(c)
+
+	var jkt string
+	if proof := c.Request().Header.Get("DPoP"); proof != "" {
+		p, err := s.checkDPoPProof(proof, http.MethodPost, issuer+"/oauth/par", "")
+		if err != nil {
+			return s.oauthRespondError(c, 400, wrapDPoPError(err))
+		}
+		jkt = p.Jkt
+	}
+
+	if rt := c.FormValue("response_type"); rt != "code" {
+		return s.oauthRespondError(c, 400, newOAuthError("unsupported_response_type", "response_type must be code"))
+	}
+	if c.FormValue("code_challenge") == "" || c.FormValue("code_challenge_method") != "S256" {
+		return s.oauthRespondError(c, 400, newOAuthError("invalid_request", "PKCE with S256 is required"))
+	}
+
+	clientID := c.FormValue("client_id")
+	md, err := s.resolveOAuthClient(ctx, clientID)
+	if err != nil {
+		return s.oauthRespondError(c, 400, err)
+	}
+
+	redirectURI := c.FormValue("redirect_uri")
+	if redirectURI == "" && len(md.RedirectURIs) == 1 {
+		redirectURI = md.RedirectURIs[0]
+	}
+	if !md.redirectAllowed(redirectURI) {
+		return s.oauthRespondError(c, 400, newOAuthError("invalid_request", "redirect_uri isn't registered for the client"))
+	}
+
+	scope := c.FormValue("scope")
+	if scope == "" {
+		scope = "atproto"
+	}
+	if err := checkOAuthScope(scope, md.Scope); err != nil {
+		return s.oauthRespondError(c, 400, err)
+	}
+
+	req := &OAuthRequest{
+		RequestURI:    randomOAuthToken(oauthRequestURIPrefix + "req-"),
+		ClientID:      clientID,
+		RedirectURI:   redirectURI,
+		Scope:         scope,
+		State:         c.FormValue("state"),
+		CodeChallenge: c.FormValue("code_challenge"),
+		LoginHint:     c.FormValue("login_hint"),
+		DpopJkt:       jkt,
+		ExpiresAt:     time.Now().Add(oauthRequestLifetime),
+	}
+	if err := s.db.Create(req).Error; err != nil {
+		return s.oauthRespondError(c, 500, err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]any{
+		"request_uri": req.RequestURI,
+		"expires_in":  int64(oauthRequestLifetime / time.Second),
+	})
+}
+
+func wrapDPoPError(err error) error {
+	if errors.Is(err, errUseDPoPNonce) {
+		return err
+	}
+	return newOAuthError("invalid_dpop_proof", "%s", err)
+}
+
+// pendingOAuthRequest loads a pushed request which hasn't been approved yet
+func (s *Server) pendingOAuthRequest(requestURI, clientID string) (*OAuthRequest, error) {
+	var req OAuthRequest
+	if err := s.db.First(&req, "request_uri = ?", requestURI).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, newOAuthError("invalid_request", "unknown request_uri")
+		}
+		return nil, err
+	}
+	if req.Code != "" || time.Now().After(req.ExpiresAt) {
+		return nil, newOAuthError("invalid_request", "request_uri has expired")
+	}
+	if clientID != "" && clientID != req.ClientID {
+		return nil, newOAuthError("invalid_request", "client_id doesn't match the request")
+	}
+	return &req, nil
+}
+
+var oauthConsentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in to {{.ClientName}}</title>
+<style>
+body { font-family: sans-serif; max-width: 28em; margin: 3em auto; padding: 0 1em; }
+input { display: block; width: 100%; margin: 0.3em 0 1em; padding: 0.4em; box-sizing: border-box; }
+.error { color: #b00; }
+.client { color: #555; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>Sign in to {{.ClientName}}</h1>
+<p class="client">{{.ClientID}}</p>
+<p>This app is asking for access to your account:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="request_uri" value="{{.RequestURI}}">
+<input type="hidden" name="client_id" value="{{.ClientID}}">
+<label>Handle or DID <input name="identifier" value="{{.LoginHint}}" autocomplete="username"></label>
+<label>Password <input name="password" type="password" autocomplete="current-password"></label>
+<button name="decision" value="approve">Approve</button>
+<button name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+var oauthScopeDescriptions = map[string]string{
+	"atproto":            "Identify you by your account",
+	"transition:generic": "Read and write your data, except account management",
+}
+
+func (s *Server) renderOAuthConsent(c echo.Context, status int, req *OAuthRequest, loginError string) error {
+	name := req.ClientID
+	if md, err := s.resolveOAuthClient(c.Request().Context(), req.ClientID); err == nil && md.ClientName != "" {
+		name = md.ClientName
+	}
+	var scopes []string
+	for _, sc := range strings.Fields(req.Scope) {
+		if d, ok := oauthScopeDescriptions[sc]; ok {
+			scopes = append(scopes, d)
+		}
+	}
+
+	c.Response().Header().Set("Content-Type", "text/html; charset=utf-8")
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("X-Frame-Options", "DENY")
+	c.Response().WriteHeader(status)
+	return oauthConsentTemplate.Execute(c.Response(), map[string]any{
+		"ClientName": name,
+		"ClientID":   req.ClientID,
+		"Scopes":     scopes,
+		"LoginHint":  req.LoginHint,
+		"RequestURI": req.RequestURI,
+		"Error":      loginError,
+	})
+}
+
+// HandleOAuthAuthorize shows the consent page for a pushed request
+func (s *Server) HandleOAuthAuthorize(c echo.Context) error {
+	req, err := s.pendingOAuthRequest(c.QueryParam("request_uri"), c.QueryParam("client_id"))
+	if err != nil {
+		return s.oauthRespondError(c, 400, err)
+	}
+	return s.renderOAuthConsent(c, 200, req, "")
+}
+
+// HandleOAuthAuthorizeSubmit handles the consent form, sending the user back
+// to the client with either a code or an error
+func (s *Server) HandleOAuthAuthorizeSubmit(c echo.Context) error {
+	ctx := c.Request().Context()
+	req, err := s.pendingOAuthRequest(c.FormValue("request_uri"), c.FormValue("client_id"))
+	if err != nil {
+		return s.oauthRespondError(c, 400, err)
+	}
+
+	redirect, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		return s.oauthRespondError(c, 500, err)
+	}
+	q := redirect.Query()
+	q.Set("iss", s.oauthIssuer(c))
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+
+	if c.FormValue("decision") != "approve" {
+		if err := s.db.Unscoped().Delete(req).Error; err != nil {
+			return s.oauthRespondError(c, 500, err)
+		}
+		q.Set("error", "access_denied")
+		redirect.RawQuery = q.Encode()
+		return c.Redirect(http.StatusFound, redirect.String())
+	}
+
+	u, err := s.lookupUser(ctx, c.FormValue("identifier"))
+	if err != nil || u.Password != c.FormValue("password") {
+		return s.renderOAuthConsent(c, http.StatusUnauthorized, req, "Invalid handle or password")
+	}
+
+	code := randomOAuthToken("cod-")
+	if err := s.db.Model(req).Updates(map[string]any{
+		"code":       code,
+		"did":        u.Did,
+		"expires_at": time.Now().Add(oauthCodeLifetime),
+	}).Error; err != nil {
+		return s.oauthRespondError(c, 500, err)
+	}
+
+	q.Set("code", code)
+	redirect.RawQuery = q.Encode()
+	return c.Redirect(http.StatusFound, redirect.String())
+}
+
+// HandleOAuthToken exchanges authorization codes and refresh tokens for
+// access tokens
+func (s *Server) HandleOAuthToken(c echo.Context) error {
+	c.Response().Header().Set("DPoP-Nonce", s.dpopNonce())
+	c.Response().Header().Set("Cache-Control", "no-store")
+	issuer := s.oauthIssuer(c)
+
+	proof, err := s.checkDPoPProof(c.Request().Header.Get("DPoP"), http.MethodPost, issuer+"/oauth/token", "")
+	if err != nil {
+		return s.oauthRespondError(c, 400, wrapDPoPError(err))
+	}
+
+	var sess *OAuthSession
+	var refreshToken string
+	switch c.FormValue("grant_type") {
+	case "authorization_code":
+		sess, refreshToken, err = s.redeemOAuthCode(c, proof)
+	case "refresh_token":
+		sess, refreshToken, err = s.rotateOAuthRefreshToken(c, proof)
+	default:
+		err = newOAuthError("unsupported_grant_type", "unsupported grant_type")
+	}
+	if err != nil {
+		return s.oauthRespondError(c, 400, err)
+	}
+
+	access, err := s.createOAuthAccessToken(issuer, sess)
+	if err != nil {
+		return s.oauthRespondError(c, 500, err)
+	}
+
+	return c.JSON(200, &oauthTokenResponse{
+		AccessToken:  access,
+		TokenType:    "DPoP",
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(oauthAccessLifetime / time.Second),
+		Scope:        sess.Scope,
+		Sub:          sess.Did,
+	})
+}
+
+func (s *Server) redeemOAuthCode(c echo.Context, proof *dpopProof) (*OAuthSession, string, error) {
+	code := c.FormValue("code")
+	if code == "" {
+		return nil, "", newOAuthError("invalid_request", "code is required")
+	}
+
+	// requests get a code (and an account) when they're approved; pending
+	// ones have neither, so can't match
+	var req OAuthRequest
+	if err := s.db.First(&req, "code = ? AND code != '' AND did != ''", code).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", newOAuthError("invalid_grant", "unknown authorization code")
+		}
+		return nil, "", err
+	}
+
+	challenge := sha256.Sum256([]byte(c.FormValue("code_verifier")))
+	switch {
+	case time.Now().After(req.ExpiresAt):
+		return nil, "", newOAuthError("invalid_grant", "authorization code expired")
+	case c.FormValue("client_id") != req.ClientID:
+		return nil, "", newOAuthError("invalid_grant", "client_id doesn't match the authorization code")
+	case c.FormValue("redirect_uri") != req.RedirectURI:
+		return nil, "", newOAuthError("invalid_grant", "redirect_uri doesn't match the authorization request")
+	case base64.RawURLEncoding.EncodeToString(challenge[:]) != req.CodeChallenge:
+		return nil, "", newOAuthError("invalid_grant", "invalid code_verifier")
+	case req.DpopJkt != "" && req.DpopJkt != proof.Jkt:
+		return nil, "", newOAuthError("invalid_dpop_proof", "DPoP key doesn't match the authorization request")
+	}
+
+	// codes are single use; only the client holding the verifier can use
+	// one up, so others can't cancel an authorization
+	res := s.db.Unscoped().Delete(&req)
+	if res.Error != nil {
+		return nil, "", res.Error
+	}
+	if res.RowsAffected != 1 {
+		return nil, "", newOAuthError("invalid_grant", "authorization code already used")
+	}
+
+	refreshToken := randomOAuthToken("ref-")
+	sess := &OAuthSession{
+		RefreshTokenHash: hashRefreshToken(refreshToken),
+		ClientID:         req.ClientID,
+		Did:              req.Did,
+		Scope:            req.Scope,
+		DpopJkt:          proof.Jkt,
+		ExpiresAt:        time.Now().Add(oauthRefreshLifetime),
+	}
+	if err := s.db.Create(sess).Error; err != nil {
+		return nil, "", err
+	}
+	return sess, refreshToken, nil
+}
+
+func (s *Server) rotateOAuthRefreshToken(c echo.Context, proof *dpopProof) (*OAuthSession, string, error) {
+	oldHash := hashRefreshToken(c.FormValue("refresh_token"))
+
+	var sess OAuthSession
+	if err := s.db.First(&sess, "refresh_token_hash = ?", oldHash).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", newOAuthError("invalid_grant", "unknown refresh token")
+		}
+		return nil, "", err
+	}
+	switch {
+	case time.Now().After(sess.ExpiresAt):
+		return nil, "", newOAuthError("invalid_grant", "refresh token expired")
+	case c.FormValue("client_id") != sess.ClientID:
+		return nil, "", newOAuthError("invalid_grant", "client_id doesn't match the refresh token")
+	case proof.Jkt != sess.DpopJkt:
+		return nil, "", newOAuthError("invalid_dpop_proof", "DPoP key doesn't match the session")
+	}
+
+	refreshToken := randomOAuthToken("ref-")
+	res := s.db.Model(&OAuthSession{}).
+		Where("id = ? AND refresh_token_hash = ?", sess.ID, oldHash).
+		Updates(map[string]any{
+			"refresh_token_hash": hashRefreshToken(refreshToken),
+			"expires_at":         time.Now().Add(oauthRefreshLifetime),
+		})
+	if res.Error != nil {
+		return nil, "", res.Error
+	}
+	if res.RowsAffected != 1 {
+		return nil, "", newOAuthError("invalid_grant", "refresh token already used")
+	}
+	return &sess, refreshToken, nil
+}
+
+func (s *Server) createOAuthAccessToken(issuer string, sess *OAuthSession) (string, error) {
+	tok := makeToken(sess.Did, sess.Scope, time.Now().Add(oauthAccessLifetime))
+	tok.Set("aud", issuer)
+	tok.Set("client_id", sess.ClientID)
+	tok.Set("cnf", map[string]string{"jkt": sess.DpopJkt})
+
+	sig, err := jwt.Sign(tok, jwt.WithKey(jwa.HS256, s.jwtSigningKey))
+	if err != nil {
+		return "", fmt.Errorf("signing access token: %w", err)
+	}
+	return string(sig), nil
+}
+
+// dpopAuthMiddleware authenticates requests made with DPoP-bound OAuth
+// access tokens ("Authorization: DPoP ..."). The token is handed on to
+// userCheckMiddleware the same way the JWT middleware hands on session
+// tokens; other requests pass through untouched.
+func (s *Server) dpopAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		authz := c.Request().Header.Get("Authorization")
+		if !strings.HasPrefix(authz, "DPoP ") {
+			return next(c)
+		}
+		token := strings.TrimPrefix(authz, "DPoP ")
+		c.Response().Header().Set("DPoP-Nonce", s.dpopNonce())
+
+		unauthorized := func(code string, err error) error {
+			c.Response().Header().Set("WWW-Authenticate", fmt.Sprintf("DPoP error=%q", code))
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": code, "message": err.Error()})
+		}
+
+		tok, err := gojwt.Parse(token, func(t *gojwt.Token) (any, error) {
+			if _, ok := t.Method.(*gojwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %s", t.Header["alg"])
+			}
+			return s.jwtSigningKey, nil
+		})
+		if err != nil {
+			return unauthorized("invalid_token", err)
+		}
+		claims, _ := tok.Claims.(gojwt.MapClaims)
+		cnf, _ := claims["cnf"].(map[string]any)
+		jkt, _ := cnf["jkt"].(string)
+		if jkt == "" {
+			return unauthorized("invalid_token", fmt.Errorf("token isn't DPoP-bound"))
+		}
+		if !claims.VerifyAudience(s.oauthIssuer(c), true) {
+			return unauthorized("invalid_token", fmt.Errorf("token isn't for this server"))
+		}
+
+		htu := s.oauthIssuer(c) + c.Request().URL.Path
+		proof, err := s.checkDPoPProof(c.Request().Header.Get("DPoP"), c.Request().Method, htu, token)
+		if errors.Is(err, errUseDPoPNonce) {
+			return unauthorized("use_dpop_nonce", err)
+		}
+		if err != nil {
+			return unauthorized("invalid_dpop_proof", err)
+		}
+		if proof.Jkt != jkt {
+			return unauthorized("invalid_dpop_proof", fmt.Errorf("DPoP key doesn't match the token"))
+		}
+
+		c.Set("user", tok)
+		c.Set("dpop", true)
+		return next(c)
+	}
+}
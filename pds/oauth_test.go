@@ -0,0 +1,294 @@
+package pds
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/util"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+type testDPoPClient struct {
+	t     *testing.T
+	key   *ecdsa.PrivateKey
+	nonce string
+	http  *http.Client
+}
+
+func (dc *testDPoPClient) proof(method, htu, token string) string {
+	pub, err := jwk.FromRaw(dc.key.Public())
+	if err != nil {
+		dc.t.Fatal(err)
+	}
+	hdrs := jws.NewHeaders()
+	hdrs.Set(jws.TypeKey, "dpop+jwt")
+	hdrs.Set(jws.JWKKey, pub)
+
+	claims := map[string]any{
+		"jti": randomOAuthToken(""),
+		"htm": method,
+		"htu": htu,
+		"iat": time.Now().Unix(),
+	}
+	if dc.nonce != "" {
+		claims["nonce"] = dc.nonce
+	}
+	if token != "" {
+		ath := sha256.Sum256([]byte(token))
+		claims["ath"] = base64.RawURLEncoding.EncodeToString(ath[:])
+	}
+	payload, _ := json.Marshal(claims)
+
+	sig, err := jws.Sign(payload, jws.WithKey(jwa.ES256, dc.key, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		dc.t.Fatal(err)
+	}
+	return string(sig)
+}
+
+// post sends a form with a DPoP proof, retrying once with the server's
+// nonce if asked to
+func (dc *testDPoPClient) post(endpoint string, form url.Values) (int, map[string]any) {
+	for attempt := 0; ; attempt++ {
+		req, _ := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("DPoP", dc.proof("POST", endpoint, ""))
+		resp, err := dc.http.Do(req)
+		if err != nil {
+			dc.t.Fatal(err)
+		}
+		var out map[string]any
+		json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if n := resp.Header.Get("DPoP-Nonce"); n != "" {
+			dc.nonce = n
+		}
+		if out["error"] == "use_dpop_nonce" && attempt == 0 {
+			continue
+		}
+		return resp.StatusCode, out
+	}
+}
+
+func TestOAuthAuthorizationFlow(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := "http://" + ln.Addr().String()
+	s.serviceUrl = issuer
+	go s.RunAPIWithListener(ln)
+	defer s.Shutdown(context.Background())
+
+	o, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    "test@foo.com",
+		Password: "password",
+		Handle:   "oauthuser.test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := &testDPoPClient{t: t, key: key, http: &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+
+	redirectURI := "http://127.0.0.1:4567/callback"
+	clientID := "http://localhost?" + url.Values{
+		"redirect_uri": {"http://127.0.0.1/callback"},
+		"scope":        {"atproto transition:generic"},
+	}.Encode()
+	verifier := randomOAuthToken("")
+	challenge := sha256.Sum256([]byte(verifier))
+
+	status, par := dc.post(issuer+"/oauth/par", url.Values{
+		"client_id":             {clientID},
+		"response_type":         {"code"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"atproto transition:generic"},
+		"state":                 {"xyz"},
+		"login_hint":            {o.Handle},
+	})
+	if status != http.StatusCreated {
+		t.Fatalf("PAR failed: %d %v", status, par)
+	}
+	requestURI, _ := par["request_uri"].(string)
+
+	resp, err := dc.http.Get(issuer + "/oauth/authorize?" + url.Values{"client_id": {clientID}, "request_uri": {requestURI}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || !strings.Contains(string(page), o.Handle) {
+		t.Fatalf("unexpected consent page: %d %s", resp.StatusCode, page)
+	}
+
+	approve := func(password string) *http.Response {
+		resp, err := dc.http.PostForm(issuer+"/oauth/authorize", url.Values{
+			"request_uri": {requestURI},
+			"client_id":   {clientID},
+			"identifier":  {o.Handle},
+			"password":    {password},
+			"decision":    {"approve"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := approve("wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong password to be rejected, got %d", resp.StatusCode)
+	}
+	// a token request without a code doesn't match (or cancel) a pending
+	// authorization
+	if status, _ := dc.post(issuer+"/oauth/token", url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {""},
+		"client_id":  {clientID},
+	}); status != 400 {
+		t.Fatalf("expected an empty code to be rejected, got %d", status)
+	}
+	resp = approve("password")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect, got %d", resp.StatusCode)
+	}
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Host != "127.0.0.1:4567" || loc.Query().Get("state") != "xyz" || loc.Query().Get("iss") != issuer {
+		t.Fatalf("unexpected redirect: %s", loc)
+	}
+
+	tokenForm := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {loc.Query().Get("code")},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {clientID},
+		"code_verifier": {verifier},
+	}
+	// nor does a wrong verifier use up the code
+	badVerifier := url.Values{}
+	for k, v := range tokenForm {
+		badVerifier[k] = v
+	}
+	badVerifier.Set("code_verifier", "not-the-verifier")
+	if status, _ := dc.post(issuer+"/oauth/token", badVerifier); status != 400 {
+		t.Fatalf("expected a wrong code_verifier to be rejected, got %d", status)
+	}
+	status, tok := dc.post(issuer+"/oauth/token", tokenForm)
+	if status != 200 || tok["token_type"] != "DPoP" || tok["sub"] != o.Did {
+		t.Fatalf("token exchange failed: %d %v", status, tok)
+	}
+	if status, _ := dc.post(issuer+"/oauth/token", tokenForm); status != 400 {
+		t.Fatalf("expected a reused code to be rejected, got %d", status)
+	}
+
+	call := func(path, scheme string, dpop bool) int {
+		req, _ := http.NewRequest("GET", issuer+path, nil)
+		access := tok["access_token"].(string)
+		req.Header.Set("Authorization", scheme+" "+access)
+		if dpop {
+			req.Header.Set("DPoP", dc.proof("GET", issuer+path, access))
+		}
+		resp, err := dc.http.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := call("/xrpc/com.atproto.server.getSession", "DPoP", true); status != 200 {
+		t.Fatalf("getSession with DPoP token failed: %d", status)
+	}
+	if status := call("/xrpc/com.atproto.server.getSession", "Bearer", false); status != http.StatusUnauthorized {
+		t.Fatalf("expected DPoP-bound token without a proof to be rejected, got %d", status)
+	}
+	if status := call("/xrpc/com.atproto.server.listAppPasswords", "DPoP", true); status != http.StatusForbidden {
+		t.Fatalf("expected account management to be out of scope, got %d", status)
+	}
+
+	refreshForm := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tok["refresh_token"].(string)},
+		"client_id":     {clientID},
+	}
+	status, refreshed := dc.post(issuer+"/oauth/token", refreshForm)
+	if status != 200 || refreshed["refresh_token"] == tok["refresh_token"] {
+		t.Fatalf("refresh failed: %d %v", status, refreshed)
+	}
+	if status, _ := dc.post(issuer+"/oauth/token", refreshForm); status != 400 {
+		t.Fatalf("expected a reused refresh token to be rejected, got %d", status)
+	}
+}
+
+func TestScopeAllowsRoute(t *testing.T) {
+	cases := []struct {
+		scope, route string
+		allowed      bool
+	}{
+		{"com.atproto.access", "/xrpc/com.atproto.server.createAppPassword", true},
+		{"com.atproto.appPass", "/xrpc/com.atproto.repo.createRecord", true},
+		{"com.atproto.appPass", "/xrpc/com.atproto.server.createAppPassword", false},
+		{"com.atproto.appPass", "/xrpc/com.atproto.server.getServiceAuth", false},
+		{"com.atproto.appPass", "/xrpc/com.atproto.server.deactivateAccount", false},
+		{"atproto transition:generic", "/xrpc/com.atproto.server.activateAccount", false},
+		{"atproto", "/xrpc/com.atproto.server.getSession", true},
+		{"atproto", "/xrpc/com.atproto.repo.createRecord", false},
+		{"atproto transition:generic", "/xrpc/com.atproto.repo.createRecord", true},
+		{"atproto transition:generic", "/xrpc/com.atproto.server.deleteAccount", false},
+		{"atproto transition:generic", "/xrpc/com.atproto.admin.getRepo", false},
+	}
+	for _, c := range cases {
+		if got := scopeAllowsRoute(c.scope, c.route); got != c.allowed {
+			t.Errorf("scopeAllowsRoute(%q, %q) = %v, want %v", c.scope, c.route, got, c.allowed)
+		}
+	}
+}
+
+func TestOAuthClientMetadataNotFetchedFromPrivateAddresses(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	fetched := false
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+	}))
+	defer srv.Close()
+
+	_, err := s.resolveOAuthClient(context.Background(), srv.URL+"/client-metadata.json")
+	if err == nil || !strings.Contains(err.Error(), util.ErrDisallowedAddress.Error()) {
+		t.Fatalf("expected a loopback client_id to be refused, got %v", err)
+	}
+	if fetched {
+		t.Fatal("client metadata was fetched from a loopback address")
+	}
+}
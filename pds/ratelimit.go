@@ -0,0 +1,223 @@
+package pds
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/util"
+	"github.com/bluesky-social/indigo/util/ratelimit"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimit is a budget of Limit requests per Window
+type RateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// ParseRateLimit parses a budget written as "<limit>/<window>", eg "3000/5m"
+func ParseRateLimit(s string) (RateLimit, error) {
+	limit, window, err := ratelimit.ParseBudget(s)
+	if err != nil {
+		return RateLimit{}, err
+	}
+	return RateLimit{Limit: limit, Window: window}, nil
+}
+
+// RateLimitConfig sets the budgets for each class of XRPC route. Requests
+// are counted per account when authenticated and per IP otherwise, except
+// createSession, which is always counted per IP. A zero budget is unlimited.
+type RateLimitConfig struct {
+	// Reads are queries (GET requests)
+	Reads RateLimit
+	// Writes are procedures (any other method)
+	Writes RateLimit
+	// CreateSession is login attempts
+	CreateSession RateLimit
+}
+
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Reads:         RateLimit{Limit: 3000, Window: 5 * time.Minute},
+		Writes:        RateLimit{Limit: 5000, Window: time.Hour},
+		CreateSession: RateLimit{Limit: 30, Window: 5 * time.Minute},
+	}
+}
+
+// RateLimitStore counts requests in fixed windows. Hit counts a request
+// against key, returning the count so far in the current window and when
+// the window ends.
+type RateLimitStore interface {
+	Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+}
+
+type rateLimiter struct {
+	cfg   RateLimitConfig
+	store RateLimitStore
+}
+
+// SetRateLimits turns on rate limiting of XRPC routes. The store is shared
+// between server instances (eg a RedisRateLimitStore) or, if nil, kept in
+// memory.
+func (s *Server) SetRateLimits(cfg RateLimitConfig, store RateLimitStore) {
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+	s.ratelimiter = &rateLimiter{cfg: cfg, store: store}
+}
+
+// SetTrustedProxies sets the reverse proxies (IPs or CIDR ranges) whose
+// X-Forwarded-For header gives client IPs, for rate limits. Otherwise the
+// connection's address is used.
+func (s *Server) SetTrustedProxies(proxies []string) error {
+	extractor, err := util.TrustedProxyIPExtractor(proxies)
+	if err != nil {
+		return err
+	}
+	s.ipExtractor = extractor
+	return nil
+}
+
+func (rl *rateLimiter) classify(c echo.Context) (string, RateLimit, string) {
+	ip := c.RealIP()
+	if c.Path() == "/xrpc/com.atproto.server.createSession" {
+		return "createSession", rl.cfg.CreateSession, "ip:" + ip
+	}
+
+	subject := "ip:" + ip
+	if did, ok := c.Request().Context().Value("did").(string); ok && did != "" {
+		subject = "did:" + strings.TrimPrefix(did, "did:")
+	}
+	if c.Request().Method == http.MethodGet {
+		return "read", rl.cfg.Reads, subject
+	}
+	return "write", rl.cfg.Writes, subject
+}
+
+func (s *Server) rateLimitMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		rl := s.ratelimiter
+		if rl == nil || !strings.HasPrefix(c.Path(), "/xrpc/") || c.Path() == "/xrpc/_health" {
+			return next(c)
+		}
+
+		class, budget, subject := rl.classify(c)
+		if budget.Limit <= 0 {
+			return next(c)
+		}
+
+		count, reset, err := rl.store.Hit(c.Request().Context(), class+":"+subject, budget.Window)
+		if err != nil {
+			// don't take the server down with the limiter
+			log.Errorw("rate limit check failed", "class", class, "err", err)
+			return next(c)
+		}
+
+		remaining := budget.Limit - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		h := c.Response().Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(budget.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		h.Set("RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", budget.Limit, int(budget.Window/time.Second)))
+
+		if count > budget.Limit {
+			h.Set("Retry-After", strconv.Itoa(int(time.Until(reset).Seconds())+1))
+			return &XRPCError{Status: http.StatusTooManyRequests, Name: "RateLimitExceeded", Message: "rate limit exceeded"}
+		}
+		return next(c)
+	}
+}
+
+// MemoryRateLimitStore keeps counters in process, for single instance
+// deployments
+type MemoryRateLimitStore struct {
+	lk       sync.Mutex
+	counters map[string]*rateCounter
+	hits     int
+}
+
+type rateCounter struct {
+	count int
+	reset time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		counters: make(map[string]*rateCounter),
+	}
+}
+
+func (m *MemoryRateLimitStore) Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	now := time.Now()
+
+	// sweep out finished windows every so often
+	m.hits++
+	if m.hits%10000 == 0 {
+		for k, c := range m.counters {
+			if !now.Before(c.reset) {
+				delete(m.counters, k)
+			}
+		}
+	}
+
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.reset) {
+		c = &rateCounter{reset: now.Add(window)}
+		m.counters[key] = c
+	}
+	c.count++
+	return c.count, c.reset, nil
+}
+
+// RedisRateLimitStore keeps counters in Redis, so several PDS instances
+// share budgets
+type RedisRateLimitStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewRedisRateLimitStore(client redis.UniversalClient, prefix string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// redisRateLimitScript counts a hit, starting the window on the first one,
+// and returns the count and the window's remaining milliseconds
+var redisRateLimitScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {n, ttl}
+`)
+
+func (r *RedisRateLimitStore) Hit(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	res, err := redisRateLimitScript.Run(ctx, r.client, []string{r.prefix + key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if len(res) != 2 {
+		return 0, time.Time{}, fmt.Errorf("unexpected rate limit script result: %v", res)
+	}
+	return int(res[0]), time.Now().Add(time.Duration(res[1]) * time.Millisecond), nil
+}
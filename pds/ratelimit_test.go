@@ -0,0 +1,162 @@
+package pds
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRateLimitCreateSession(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	s.SetRateLimits(RateLimitConfig{CreateSession: RateLimit{Limit: 2, Window: time.Minute}}, nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := "http://" + ln.Addr().String()
+	go s.RunAPIWithListener(ln)
+	defer s.Shutdown(context.Background())
+
+	if _, err := s.handleComAtprotoServerCreateAccount(context.Background(), &atproto.ServerCreateAccount_Input{
+		Email:    "test@foo.com",
+		Password: "password",
+		Handle:   "limited.test",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func() *http.Response {
+		body := `{"identifier": "limited.test", "password": "password"}`
+		resp, err := http.Post(host+"/xrpc/com.atproto.server.createSession", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp := login(); resp.StatusCode != 200 {
+			t.Fatalf("login %d failed: %d", i, resp.StatusCode)
+		}
+	}
+	resp := login()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the third login to be limited, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("RateLimit-Limit") != "2" || resp.Header.Get("RateLimit-Remaining") != "0" || resp.Header.Get("RateLimit-Policy") != "2;w=60" {
+		t.Fatalf("unexpected rate limit headers: %v", resp.Header)
+	}
+
+	// other route classes have their own budgets, and these are unlimited
+	hresp, err := http.Get(host + "/xrpc/com.atproto.server.describeServer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hresp.Body.Close()
+	if hresp.StatusCode != 200 || hresp.Header.Get("RateLimit-Limit") != "" {
+		t.Fatalf("unexpected describeServer response: %d %v", hresp.StatusCode, hresp.Header)
+	}
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	for _, trusted := range []bool{false, true} {
+		s, cleanup := newTestServer(t)
+		defer cleanup()
+		s.SetRateLimits(RateLimitConfig{Reads: RateLimit{Limit: 1, Window: time.Minute}}, nil)
+		if trusted {
+			if err := s.SetTrustedProxies([]string{"127.0.0.1"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		host := "http://" + ln.Addr().String()
+		go s.RunAPIWithListener(ln)
+		defer s.Shutdown(context.Background())
+
+		describe := func(xff string) int {
+			req, err := http.NewRequest("GET", host+"/xrpc/com.atproto.server.describeServer", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if xff != "" {
+				req.Header.Set("X-Forwarded-For", xff)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		if code := describe("203.0.113.1"); code != 200 {
+			t.Fatalf("first request failed: %d", code)
+		}
+		// a different X-Forwarded-For only counts as a different client when
+		// it's from a trusted proxy
+		code := describe("203.0.113.2")
+		if trusted && code != 200 {
+			t.Fatalf("expected a forwarded client to have its own budget, got %d", code)
+		}
+		if !trusted && code != http.StatusTooManyRequests {
+			t.Fatalf("expected a spoofed X-Forwarded-For to be ignored, got %d", code)
+		}
+	}
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := NewRedisRateLimitStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "rl:")
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		n, reset, err := store.Hit(ctx, "write:did:plc:abc", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Fatalf("expected count %d, got %d", i, n)
+		}
+		if until := time.Until(reset); until <= 0 || until > time.Minute {
+			t.Fatalf("unexpected reset %s", reset)
+		}
+	}
+
+	mr.FastForward(time.Minute + time.Second)
+	n, _, err := store.Hit(ctx, "write:did:plc:abc", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected a new window after expiry, got count %d", n)
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	rl, err := ParseRateLimit("3000/5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rl.Limit != 3000 || rl.Window != 5*time.Minute {
+		t.Fatalf("unexpected rate limit: %+v", rl)
+	}
+	for _, bad := range []string{"3000", "x/5m", "10/forever", "0/1m"} {
+		if _, err := ParseRateLimit(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
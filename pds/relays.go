@@ -0,0 +1,292 @@
+package pds
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	comatproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Relay is a relay this PDS announces itself to with requestCrawl
+type Relay struct {
+	gorm.Model
+	// base URL of the relay, eg "https://bsky.network"
+	Host             string `gorm:"unique"`
+	LastCrawlRequest time.Time
+	LastCrawlError   string
+	// last time the relay was seen subscribed to the firehose
+	LastConsumed time.Time
+}
+
+// RelayConfig controls how the PDS keeps itself registered with relays
+type RelayConfig struct {
+	// relay hostnames or base URLs; https is assumed without a scheme
+	Hosts []string
+	// how often crawls are requested again from relays which are consuming
+	// the firehose; zero means every 12 hours
+	Interval time.Duration
+	// how often relay subscriptions are checked; zero means every minute
+	CheckInterval time.Duration
+	// how long a relay can be unsubscribed before crawls are requested again
+	// (which is also the minimum time between requests); zero means 5 minutes
+	Grace time.Duration
+}
+
+// RelayStatus is the registration state of a relay
+type RelayStatus struct {
+	Host             string     `json:"host"`
+	Consuming        bool       `json:"consuming"`
+	LastConsumed     *time.Time `json:"lastConsumed,omitempty"`
+	LastCrawlRequest *time.Time `json:"lastCrawlRequest,omitempty"`
+	LastCrawlError   string     `json:"lastCrawlError,omitempty"`
+}
+
+// subscriberSet tracks the current firehose subscribers, so relays which
+// are consuming the firehose can be told apart from those which aren't
+type subscriberSet struct {
+	lk     sync.Mutex
+	nextID uint64
+	ips    map[uint64]string
+}
+
+func (ss *subscriberSet) add(ip string) func() {
+	ss.lk.Lock()
+	defer ss.lk.Unlock()
+	if ss.ips == nil {
+		ss.ips = make(map[uint64]string)
+	}
+	id := ss.nextID
+	ss.nextID++
+	ss.ips[id] = ip
+	return func() {
+		ss.lk.Lock()
+		defer ss.lk.Unlock()
+		delete(ss.ips, id)
+	}
+}
+
+func (ss *subscriberSet) hasAny(ips map[string]bool) bool {
+	ss.lk.Lock()
+	defer ss.lk.Unlock()
+	for _, ip := range ss.ips {
+		if ips[ip] {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRelays sets the relays this PDS registers with. Relays are only
+// contacted once RunRelayAnnouncer is started.
+func (s *Server) SetRelays(cfg RelayConfig) error {
+	if cfg.Interval == 0 {
+		cfg.Interval = 12 * time.Hour
+	}
+	if cfg.CheckInterval == 0 {
+		cfg.CheckInterval = time.Minute
+	}
+	if cfg.Grace == 0 {
+		cfg.Grace = 5 * time.Minute
+	}
+
+	if err := s.db.AutoMigrate(&Relay{}); err != nil {
+		return err
+	}
+
+	var hosts []string
+	for _, h := range cfg.Hosts {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		if !strings.Contains(h, "://") {
+			h = "https://" + h
+		}
+		u, err := url.Parse(h)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid relay host %q", h)
+		}
+		h = u.Scheme + "://" + u.Host
+		if err := s.db.Where(Relay{Host: h}).FirstOrCreate(&Relay{}).Error; err != nil {
+			return fmt.Errorf("registering relay %s: %w", h, err)
+		}
+		hosts = append(hosts, h)
+	}
+	cfg.Hosts = hosts
+	s.relays = cfg
+	return nil
+}
+
+// RunRelayAnnouncer requests crawls from every configured relay, then keeps
+// checking that they are consuming the firehose until ctx is done. Relays
+// which go away (or never connect) are asked to crawl again after the grace
+// period, and the rest are re-announced every interval.
+func (s *Server) RunRelayAnnouncer(ctx context.Context) {
+	if len(s.relays.Hosts) == 0 {
+		return
+	}
+
+	// whatever happened while we were down, announce ourselves again
+	s.RequestCrawls(ctx)
+
+	t := time.NewTicker(s.relays.CheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := s.checkRelays(ctx); err != nil {
+			log.Errorw("checking relays failed", "err", err)
+		}
+	}
+}
+
+// RequestCrawls asks every configured relay to crawl this PDS now
+func (s *Server) RequestCrawls(ctx context.Context) {
+	for _, h := range s.relays.Hosts {
+		var r Relay
+		if err := s.db.First(&r, "host = ?", h).Error; err != nil {
+			log.Errorw("loading relay failed", "relay", h, "err", err)
+			continue
+		}
+		s.requestCrawl(ctx, &r)
+	}
+}
+
+func (s *Server) checkRelays(ctx context.Context) error {
+	now := time.Now()
+	for _, h := range s.relays.Hosts {
+		var r Relay
+		if err := s.db.First(&r, "host = ?", h).Error; err != nil {
+			return err
+		}
+
+		consuming, err := s.relayConsuming(ctx, r.Host)
+		if err != nil {
+			log.Warnw("resolving relay failed", "relay", r.Host, "err", err)
+		}
+
+		switch {
+		case consuming:
+			if err := s.db.Model(&r).Update("last_consumed", now).Error; err != nil {
+				return err
+			}
+			if now.Sub(r.LastCrawlRequest) > s.relays.Interval {
+				s.requestCrawl(ctx, &r)
+			}
+		case now.Sub(r.LastCrawlRequest) > s.relays.Grace && now.Sub(r.LastConsumed) > s.relays.Grace:
+			log.Infow("relay not consuming firehose, requesting crawl", "relay", r.Host, "lastConsumed", r.LastConsumed)
+			s.requestCrawl(ctx, &r)
+		}
+	}
+	return nil
+}
+
+// relayConsuming reports whether any current firehose subscriber connects
+// from one of the relay's addresses. Relays which connect from addresses
+// other than the ones their hostname resolves to are never seen as
+// consuming, and are just re-announced after each grace period.
+func (s *Server) relayConsuming(ctx context.Context, host string) (bool, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return false, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return false, err
+	}
+	ips := make(map[string]bool)
+	for _, a := range addrs {
+		ips[a.IP.String()] = true
+	}
+	return s.subscribers.hasAny(ips), nil
+}
+
+func (s *Server) requestCrawl(ctx context.Context, r *Relay) {
+	c := &xrpc.Client{
+		Host:   r.Host,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+
+	var errStr string
+	err := comatproto.SyncRequestCrawl(ctx, c, &comatproto.SyncRequestCrawl_Input{Hostname: s.crawlHostname()})
+	if err != nil {
+		log.Warnw("requestCrawl failed", "relay", r.Host, "err", err)
+		errStr = err.Error()
+	} else {
+		log.Infow("requested crawl", "relay", r.Host)
+	}
+
+	if err := s.db.Model(r).Updates(map[string]any{
+		"last_crawl_request": time.Now(),
+		"last_crawl_error":   errStr,
+	}).Error; err != nil {
+		log.Errorw("recording crawl request failed", "relay", r.Host, "err", err)
+	}
+}
+
+// crawlHostname is the hostname relays should crawl, from the service URL
+func (s *Server) crawlHostname() string {
+	if u, err := url.Parse(s.serviceUrl); err == nil && strings.Contains(s.serviceUrl, "://") {
+		return u.Host
+	}
+	return s.serviceUrl
+}
+
+// RelayStatuses returns the registration state of every configured relay
+func (s *Server) RelayStatuses(ctx context.Context) ([]RelayStatus, error) {
+	out := []RelayStatus{}
+	for _, h := range s.relays.Hosts {
+		var r Relay
+		if err := s.db.First(&r, "host = ?", h).Error; err != nil {
+			return nil, err
+		}
+		consuming, _ := s.relayConsuming(ctx, r.Host)
+		st := RelayStatus{
+			Host:           r.Host,
+			Consuming:      consuming,
+			LastCrawlError: r.LastCrawlError,
+		}
+		if !r.LastConsumed.IsZero() {
+			st.LastConsumed = &r.LastConsumed
+		}
+		if !r.LastCrawlRequest.IsZero() {
+			st.LastCrawlRequest = &r.LastCrawlRequest
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+func (s *Server) HandleAdminRelays(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	out, err := s.RelayStatuses(ctx)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]any{"relays": out})
+}
+
+func (s *Server) HandleAdminRelaysRequestCrawl(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := s.requireAdmin(ctx); err != nil {
+		return err
+	}
+	s.RequestCrawls(ctx)
+	return s.HandleAdminRelays(c)
+}
@@ -0,0 +1,89 @@
+package pds
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+)
+
+func TestRelayAnnouncer(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	s.serviceUrl = "https://pds.example.com"
+
+	var lk sync.Mutex
+	var requested []string
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body atproto.SyncRequestCrawl_Input
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		lk.Lock()
+		requested = append(requested, body.Hostname)
+		lk.Unlock()
+	}))
+	defer relay.Close()
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	if err := s.SetRelays(RelayConfig{Hosts: []string{relay.URL, broken.URL}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	s.RequestCrawls(ctx)
+	if len(requested) != 1 || requested[0] != "pds.example.com" {
+		t.Fatalf("unexpected crawl requests: %v", requested)
+	}
+
+	st, err := s.RelayStatuses(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st) != 2 {
+		t.Fatalf("expected 2 relays, got %d", len(st))
+	}
+	if st[0].Consuming || st[0].LastCrawlRequest == nil || st[0].LastCrawlError != "" {
+		t.Fatalf("unexpected status: %+v", st[0])
+	}
+	if st[1].LastCrawlError == "" {
+		t.Fatal("expected failed crawl request to be recorded")
+	}
+
+	// both relays are served from localhost, so a local subscriber counts
+	// as each of them consuming the firehose
+	done := s.subscribers.add("127.0.0.1")
+	if err := s.checkRelays(ctx); err != nil {
+		t.Fatal(err)
+	}
+	st, err = s.RelayStatuses(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st[0].Consuming || st[0].LastConsumed == nil {
+		t.Fatalf("expected relay to be consuming: %+v", st[0])
+	}
+	if len(requested) != 1 {
+		t.Fatal("consuming relay shouldn't be asked to crawl again yet")
+	}
+
+	// once it goes away, it's re-announced after the grace period
+	done()
+	s.relays.Grace = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	if err := s.checkRelays(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(requested) != 2 {
+		t.Fatalf("expected crawl to be requested again after relay went away, got %d requests", len(requested))
+	}
+}
@@ -10,12 +10,16 @@ import (
 	"net/http"
 	"net/mail"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bluesky-social/indigo/api/atproto"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	bsky "github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/crypto/serviceauth"
+	"github.com/bluesky-social/indigo/blobs"
 	"github.com/bluesky-social/indigo/carstore"
 	"github.com/bluesky-social/indigo/events"
 	"github.com/bluesky-social/indigo/indexer"
@@ -33,7 +37,6 @@ import (
 	logging "github.com/ipfs/go-log"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/whyrusleeping/go-did"
 	"gorm.io/gorm"
 )
@@ -57,6 +60,30 @@ type Server struct {
 	serviceUrl   string
 
 	plc plc.PLCClient
+
+	mailer      Mailer
+	blobstore   blobs.StreamingBlobStore
+	ratelimiter *rateLimiter
+	ipExtractor echo.IPExtractor
+
+	adminPassword string
+	invites       InviteConfig
+
+	backups blobs.StreamingBlobStore
+
+	firehose    FirehoseConfig
+	subscribers subscriberSet
+
+	relays RelayConfig
+
+	// checks service auth tokens addressed to this server; see
+	// serviceAuthValidator
+	serviceAuthOnce sync.Once
+	serviceAuth     *serviceauth.Validator
+
+	// OAuth authorization server state
+	dpopReplay      *dpopReplayCache
+	oauthHTTPClient *http.Client
 }
 
 const UserActorDeclCid = "bafyreid27zk7lbis4zw5fz4podbvbs4fc5ivwji3dmrwa6zggnj4bnd57u"
@@ -72,6 +99,17 @@ const serverListenerBootTimeout = 5 * time.Second
 func NewServer(db *gorm.DB, cs *carstore.CarStore, serkey *did.PrivKey, handleSuffix, serviceUrl string, didr plc.PLCClient, jwtkey []byte) (*Server, error) {
 	db.AutoMigrate(&User{})
 	db.AutoMigrate(&Peering{})
+	db.AutoMigrate(&OAuthRequest{})
+	db.AutoMigrate(&OAuthSession{})
+	db.AutoMigrate(&EmailToken{})
+	db.AutoMigrate(&AppPassword{})
+	db.AutoMigrate(&RevokedToken{})
+	db.AutoMigrate(&Blob{})
+	db.AutoMigrate(&BlobRef{})
+	db.AutoMigrate(&InviteCode{})
+	db.AutoMigrate(&InviteCodeUse{})
+	db.AutoMigrate(&ModerationAction{})
+	db.AutoMigrate(&RepoBackup{})
 
 	evtman := events.NewEventManager(events.NewMemPersister())
 
@@ -98,12 +136,20 @@ func NewServer(db *gorm.DB, cs *carstore.CarStore, serkey *did.PrivKey, handleSu
 		serviceUrl:     serviceUrl,
 		jwtSigningKey:  jwtkey,
 		enforcePeering: false,
+
+		dpopReplay:      newDPoPReplayCache(),
+		oauthHTTPClient: newOAuthHTTPClient(),
 	}
 
 	repoman.SetEventHandler(func(ctx context.Context, evt *repomgr.RepoEvent) {
 		if err := ix.HandleRepoEvent(ctx, evt); err != nil {
 			log.Errorw("handle repo event failed", "user", evt.User, "err", err)
 		}
+		if s.blobstore != nil {
+			if err := s.updateBlobRefs(ctx, evt); err != nil {
+				log.Errorw("updating blob references failed", "user", evt.User, "err", err)
+			}
+		}
 	}, true)
 
 	//ix.SendRemoteFollow = s.sendRemoteFollow
@@ -293,12 +339,27 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 	e := echo.New()
 	s.echo = e
 	e.HideBanner = true
+	e.IPExtractor = s.ipExtractor
+	if e.IPExtractor == nil {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Format: "method=${method}, uri=${uri}, status=${status} latency=${latency_human}\n",
 	}))
 
 	cfg := middleware.JWTConfig{
 		Skipper: func(c echo.Context) bool {
+			// already authenticated with a DPoP-bound OAuth token
+			if _, ok := c.Get("user").(*gojwt.Token); ok {
+				return true
+			}
+
+			if isAdminRoute(c.Path()) && s.checkAdminAuth(c) {
+				ctx := context.WithValue(c.Request().Context(), "admin", true)
+				c.SetRequest(c.Request().WithContext(ctx))
+				return true
+			}
+
 			switch c.Path() {
 			case "/xrpc/_health":
 				return true
@@ -309,17 +370,25 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 			case "/xrpc/com.atproto.identity.resolveHandle":
 				return true
 			case "/xrpc/com.atproto.server.createAccount":
+				// accounts migrating here authenticate with a service
+				// auth token from their old PDS
+				ctx := context.WithValue(c.Request().Context(), "auth", c.Request().Header.Get("Authorization"))
+				c.SetRequest(c.Request().WithContext(ctx))
 				return true
 			case "/xrpc/com.atproto.server.createSession":
 				return true
 			case "/xrpc/com.atproto.server.describeServer":
 				return true
+			case "/xrpc/com.atproto.server.requestPasswordReset", "/xrpc/com.atproto.server.resetPassword":
+				return true
 			case "/xrpc/app.bsky.actor.getProfile":
 				fmt.Println("TODO: currently not requiring auth on get profile endpoint")
 				return true
 			case "/xrpc/com.atproto.sync.getRepo":
 				fmt.Println("TODO: currently not requiring auth on get repo endpoint")
 				return true
+			case "/xrpc/com.atproto.sync.getBlob", "/xrpc/com.atproto.sync.listBlobs":
+				return true
 			case "/xrpc/com.atproto.peering.follow", "/events":
 				auth := c.Request().Header.Get("Authorization")
 
@@ -331,6 +400,10 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 				return true
 			case "/.well-known/atproto-did":
 				return true
+			case "/.well-known/oauth-protected-resource", "/.well-known/oauth-authorization-server":
+				return true
+			case "/oauth/par", "/oauth/authorize", "/oauth/token":
+				return true
 			default:
 				return false
 			}
@@ -349,15 +422,36 @@ func (s *Server) RunAPIWithListener(listen net.Listener) error {
 			return
 		}
 
+		var xerr *XRPCError
+		if errors.As(err, &xerr) {
+			ctx.JSON(xerr.Status, map[string]any{"error": xerr.Name, "message": xerr.Message})
+			return
+		}
+
+		var herr *echo.HTTPError
+		if errors.As(err, &herr) {
+			ctx.JSON(herr.Code, map[string]any{"error": http.StatusText(herr.Code), "message": herr.Message})
+			return
+		}
+
 		ctx.Response().WriteHeader(500)
 	}
 
-	e.Use(middleware.JWTWithConfig(cfg), s.userCheckMiddleware)
+	e.Use(s.dpopAuthMiddleware, middleware.JWTWithConfig(cfg), s.userCheckMiddleware, s.rateLimitMiddleware)
 	s.RegisterHandlersComAtproto(e)
 	s.RegisterHandlersAppBsky(e)
+	s.registerMigrationHandlers(e)
 	e.GET("/xrpc/com.atproto.sync.subscribeRepos", s.EventsHandler)
 	e.GET("/xrpc/_health", s.HandleHealthCheck)
+	e.GET("/admin/relays", s.HandleAdminRelays)
+	e.POST("/admin/relays/requestCrawl", s.HandleAdminRelaysRequestCrawl)
 	e.GET("/.well-known/atproto-did", s.HandleResolveDid)
+	e.GET("/.well-known/oauth-protected-resource", s.HandleOAuthProtectedResource)
+	e.GET("/.well-known/oauth-authorization-server", s.HandleOAuthServerMetadata)
+	e.POST("/oauth/par", s.HandleOAuthPAR)
+	e.GET("/oauth/authorize", s.HandleOAuthAuthorize)
+	e.POST("/oauth/authorize", s.HandleOAuthAuthorizeSubmit)
+	e.POST("/oauth/token", s.HandleOAuthToken)
 
 	// In order to support booting on random ports in tests, we need to tell the
 	// Echo instance it's already got a port, and then use its StartServer
@@ -408,6 +502,19 @@ type User struct {
 	Email       string
 	Did         string `gorm:"uniqueIndex"`
 	PDS         uint
+
+	// EmailConfirmedAt is set once the user confirms their email address
+	EmailConfirmedAt *time.Time
+	// DeactivatedAt is set while the account is deactivated, eg while it
+	// is being migrated to or from this server
+	DeactivatedAt *time.Time
+	// InvitesDisabled stops the user being given invite codes
+	InvitesDisabled bool
+	// TakedownActionID is the moderation action that took the account
+	// down, if it is taken down or suspended
+	TakedownActionID uint
+	// TakedownUntil is when a suspension ends; it is nil for takedowns
+	TakedownUntil *time.Time
 }
 
 type RefreshToken struct {
@@ -500,6 +607,18 @@ func (s *Server) lookupUserByDid(ctx context.Context, did string) (*User, error)
 
 var ErrNoSuchUser = fmt.Errorf("no such user")
 
+// XRPCError is an error returned to clients with an XRPC error name, as
+// {"error": Name, "message": Message}
+type XRPCError struct {
+	Status  int
+	Name    string
+	Message string
+}
+
+func (e *XRPCError) Error() string {
+	return e.Name + ": " + e.Message
+}
+
 func (s *Server) lookupUserByHandle(ctx context.Context, handle string) (*User, error) {
 	var u User
 	if err := s.db.Find(&u, "handle = ?", handle).Error; err != nil {
@@ -527,11 +646,54 @@ func (s *Server) userCheckMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 			return fmt.Errorf("invalid token: %w", err)
 		}
 
+		// DPoP-bound tokens are only good with a proof, which
+		// dpopAuthMiddleware checked
+		if claims, ok := user.Claims.(gojwt.MapClaims); ok && claims["cnf"] != nil && c.Get("dpop") != true {
+			return echo.NewHTTPError(http.StatusUnauthorized, "DPoP-bound token used without a DPoP proof")
+		}
+
+		if (scope == refreshTokenScope) != refreshTokenRoutes[c.Path()] {
+			return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprintf("wrong kind of token for %s", c.Path()))
+		}
+		if scope == refreshTokenScope {
+			revoked, err := s.tokenRevoked(ctx, user)
+			if err != nil {
+				return err
+			}
+			if revoked {
+				return echo.NewHTTPError(http.StatusUnauthorized, "refresh token has already been used")
+			}
+		}
+
+		if !scopeAllowsRoute(scope, c.Path()) {
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("token scope doesn't allow %s", c.Path()))
+		}
+
 		u, err := s.lookupUser(ctx, did)
 		if err != nil {
 			return err
 		}
 
+		if claims, ok := user.Claims.(gojwt.MapClaims); ok {
+			if name, ok := claims["appPassword"].(string); ok {
+				exists, err := s.appPasswordExists(ctx, u, name)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					return echo.NewHTTPError(http.StatusUnauthorized, "app password has been revoked")
+				}
+			}
+		}
+
+		if u.takenDown() {
+			return ErrAccountTakedown
+		}
+
+		if u.DeactivatedAt != nil && deactivatedBlockedRoutes[c.Path()] {
+			return ErrAccountDeactivated
+		}
+
 		ctx = context.WithValue(ctx, "authScope", scope)
 		ctx = context.WithValue(ctx, "user", u)
 		ctx = context.WithValue(ctx, "did", did)
@@ -591,10 +753,6 @@ func (s *Server) validateHandle(handle string) error {
 	return nil
 }
 
-func (s *Server) invalidateToken(ctx context.Context, u *User, tok *jwt.Token) error {
-	panic("nyi")
-}
-
 type Peering struct {
 	gorm.Model
 	Host     string
@@ -603,10 +761,33 @@ type Peering struct {
 }
 
 func (s *Server) EventsHandler(c echo.Context) error {
-	conn, err := websocket.Upgrade(c.Response().Writer, c.Request(), c.Response().Header(), 1<<10, 1<<10)
+	var since *int64
+	if sinceVal := c.QueryParam("cursor"); sinceVal != "" {
+		sval, err := strconv.ParseInt(sinceVal, 10, 64)
+		if err != nil {
+			return &XRPCError{Status: http.StatusBadRequest, Name: "InvalidRequest", Message: "cursor must be an integer"}
+		}
+		since = &sval
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    1 << 10,
+		WriteBufferSize:   10 << 10,
+		EnableCompression: s.firehose.Compression,
+	}
+	conn, err := upgrader.Upgrade(c.Response().Writer, c.Request(), c.Response().Header())
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
+
+	if s.firehose.Compression {
+		// only takes effect if the subscriber negotiated compression
+		conn.EnableWriteCompression(true)
+		if err := conn.SetCompressionLevel(s.firehose.CompressionLevel); err != nil {
+			return err
+		}
+	}
 
 	var peering *Peering
 	if s.enforcePeering {
@@ -618,11 +799,27 @@ func (s *Server) EventsHandler(c echo.Context) error {
 		}
 	}
 
-	ctx := c.Request().Context()
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
 
-	ident := c.RealIP() + "-" + c.Request().UserAgent()
+	// read and discard anything the subscriber sends, so control frames get
+	// handled and we notice when it goes away
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	// the client IP is only taken from X-Forwarded-For for trusted proxies
+	// (see SetTrustedProxies), so subscribers can't pose as a relay
+	ip := c.RealIP()
+	ident := ip + "-" + c.Request().UserAgent()
+	defer s.subscribers.add(ip)()
 
-	evts, cancel, err := s.events.Subscribe(ctx, ident, func(evt *events.XRPCStreamEvent) bool {
+	evts, evtsCancel, err := s.events.Subscribe(ctx, ident, func(evt *events.XRPCStreamEvent) bool {
 		if !s.enforcePeering {
 			return true
 		}
@@ -637,14 +834,25 @@ func (s *Server) EventsHandler(c echo.Context) error {
 		}
 
 		return false
-	}, nil)
+	}, since)
 	if err != nil {
 		return err
 	}
-	defer cancel()
+	defer evtsCancel()
 
 	header := events.EventHeader{Op: events.EvtKindMessage}
-	for evt := range evts {
+	for {
+		var evt *events.XRPCStreamEvent
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-evts:
+			if !ok {
+				return nil
+			}
+			evt = e
+		}
+
 		wc, err := conn.NextWriter(websocket.BinaryMessage)
 		if err != nil {
 			return err
@@ -656,6 +864,9 @@ func (s *Server) EventsHandler(c echo.Context) error {
 		case evt.Error != nil:
 			header.Op = events.EvtKindErrorFrame
 			obj = evt.Error
+		case evt.RepoAccount != nil:
+			header.MsgType = "#account"
+			obj = evt.RepoAccount
 		case evt.RepoCommit != nil:
 			header.MsgType = "#commit"
 			obj = evt.RepoCommit
@@ -687,8 +898,6 @@ func (s *Server) EventsHandler(c echo.Context) error {
 			return fmt.Errorf("failed to flush-close our event write: %w", err)
 		}
 	}
-
-	return nil
 }
 
 func (s *Server) UpdateUserHandle(ctx context.Context, u *User, handle string) error {
@@ -0,0 +1,215 @@
+package plc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	"gorm.io/gorm"
+)
+
+var log = logging.Logger("plc")
+
+// ExportCheckpointStore persists how far an ExportConsumer has got through
+// the export feed, so it can carry on from there after a restart.
+type ExportCheckpointStore interface {
+	// LoadExportCursor returns the saved cursor, or "" to start from the
+	// beginning of the feed
+	LoadExportCursor(ctx context.Context) (string, error)
+	SaveExportCursor(ctx context.Context, after string) error
+}
+
+// ExportCursor is the row saved by GormExportCheckpoints
+type ExportCursor struct {
+	Name      string `gorm:"primarykey"`
+	After     string
+	UpdatedAt time.Time
+}
+
+// GormExportCheckpoints stores export cursors in a database table, keyed by
+// consumer name so several consumers can share a database.
+type GormExportCheckpoints struct {
+	db   *gorm.DB
+	name string
+}
+
+func NewGormExportCheckpoints(db *gorm.DB, name string) (*GormExportCheckpoints, error) {
+	if err := db.AutoMigrate(&ExportCursor{}); err != nil {
+		return nil, err
+	}
+	return &GormExportCheckpoints{db: db, name: name}, nil
+}
+
+func (g *GormExportCheckpoints) LoadExportCursor(ctx context.Context) (string, error) {
+	var cur ExportCursor
+	if err := g.db.WithContext(ctx).Where("name = ?", g.name).Take(&cur).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return cur.After, nil
+}
+
+func (g *GormExportCheckpoints) SaveExportCursor(ctx context.Context, after string) error {
+	return g.db.WithContext(ctx).Save(&ExportCursor{Name: g.name, After: after}).Error
+}
+
+// ExportHandler is called for each new entry of the export feed, in order.
+// Returning an error stops the consumer before that entry, so it will be
+// handed over again on the next poll. After a restart, entries sharing the
+// checkpointed timestamp may be handed over a second time, so handlers should
+// be idempotent.
+type ExportHandler func(ctx context.Context, e *LogEntry) error
+
+// ExportConsumer follows a PLC directory's /export feed, which lists every
+// operation on every DID in the order the directory accepted them.
+//
+// The feed is paged by createdAt timestamp. Several entries can share a
+// timestamp, so the consumer overlaps pages slightly and skips entries it has
+// already handled.
+type ExportConsumer struct {
+	Host    string
+	Client  *http.Client
+	Handler ExportHandler
+	// Checkpoints is optional; without it the consumer starts from the
+	// beginning of the feed every time it is created
+	Checkpoints  ExportCheckpointStore
+	PageSize     int
+	PollInterval time.Duration
+
+	loaded bool
+	after  string
+	// CIDs of handled entries with createdAt equal to after
+	seen map[string]bool
+}
+
+func NewExportConsumer(host string, checkpoints ExportCheckpointStore, handler ExportHandler) *ExportConsumer {
+	return &ExportConsumer{
+		Host:         host,
+		Client:       &http.Client{Timeout: time.Minute},
+		Handler:      handler,
+		Checkpoints:  checkpoints,
+		PageSize:     1000,
+		PollInterval: 5 * time.Second,
+		seen:         make(map[string]bool),
+	}
+}
+
+// Cursor returns the createdAt of the last entry handled
+func (c *ExportConsumer) Cursor() string {
+	return c.after
+}
+
+// Run polls the feed until ctx is cancelled. Errors are logged and retried
+// after PollInterval.
+func (c *ExportConsumer) Run(ctx context.Context) error {
+	for {
+		n, err := c.Poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Errorw("polling PLC export", "host", c.Host, "err", err)
+		}
+
+		// a full page means there is probably more waiting
+		if err != nil || n < c.PageSize {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.PollInterval):
+			}
+		}
+	}
+}
+
+// Poll fetches one page of the feed and hands each new entry to the Handler,
+// then saves the checkpoint. It returns the number of entries on the page,
+// including any already seen.
+func (c *ExportConsumer) Poll(ctx context.Context) (int, error) {
+	if !c.loaded && c.Checkpoints != nil {
+		after, err := c.Checkpoints.LoadExportCursor(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("loading export checkpoint: %w", err)
+		}
+		c.after = after
+	}
+	c.loaded = true
+
+	entries, err := FetchExport(ctx, c.Client, c.Host, c.after, c.PageSize)
+	if err != nil {
+		return 0, err
+	}
+
+	start := c.after
+	var herr error
+	for i := range entries {
+		e := &entries[i]
+		if e.CreatedAt == c.after && c.seen[e.CID] {
+			continue
+		}
+		if herr = c.Handler(ctx, e); herr != nil {
+			break
+		}
+		if e.CreatedAt != c.after {
+			c.after = e.CreatedAt
+			c.seen = make(map[string]bool)
+		}
+		c.seen[e.CID] = true
+	}
+
+	if c.Checkpoints != nil && c.after != start {
+		if err := c.Checkpoints.SaveExportCursor(ctx, c.after); err != nil {
+			return 0, fmt.Errorf("saving export checkpoint: %w", err)
+		}
+	}
+	if herr != nil {
+		return 0, herr
+	}
+	return len(entries), nil
+}
+
+// FetchExport fetches one page of a PLC directory's export feed, starting
+// after the given createdAt timestamp ("" for the start of the feed).
+func FetchExport(ctx context.Context, c *http.Client, host, after string, count int) ([]LogEntry, error) {
+	q := url.Values{"count": []string{strconv.Itoa(count)}}
+	if after != "" {
+		q.Set("after", after)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, host+"/export?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching PLC export: %s", resp.Status)
+	}
+
+	// the feed is JSON lines, some longer than bufio's default limit
+	var out []LogEntry
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e LogEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("parsing PLC export entry: %w", err)
+		}
+		out = append(out, e)
+	}
+	return out, sc.Err()
+}
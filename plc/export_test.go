@@ -0,0 +1,100 @@
+package plc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestExportConsumer(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	entries := []LogEntry{
+		{Did: "did:plc:one", CID: "cid1", CreatedAt: "2024-01-01T00:00:00.000Z"},
+		{Did: "did:plc:two", CID: "cid2", CreatedAt: "2024-01-01T00:00:01.000Z"},
+		{Did: "did:plc:three", CID: "cid3", CreatedAt: "2024-01-01T00:00:01.000Z"},
+		{Did: "did:plc:four", CID: "cid4", CreatedAt: "2024-01-01T00:00:02.000Z"},
+	}
+	for i := range entries {
+		entries[i].Operation = json.RawMessage(`{}`)
+	}
+
+	// pages include entries at the "after" timestamp, so the consumer has to
+	// skip the overlap
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+		after := r.URL.Query().Get("after")
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if count == 0 {
+				break
+			}
+			if e.CreatedAt < after {
+				continue
+			}
+			enc.Encode(e)
+			count--
+		}
+	}))
+	defer srv.Close()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints, err := NewGormExportCheckpoints(db, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handled []string
+	failOn := "cid4"
+	handler := func(ctx context.Context, e *LogEntry) error {
+		if e.CID == failOn {
+			return fmt.Errorf("handler failed")
+		}
+		handled = append(handled, e.CID)
+		return nil
+	}
+
+	c := NewExportConsumer(srv.URL, checkpoints, handler)
+	c.PageSize = 3
+
+	n, err := c.Poll(ctx)
+	assert.NoError(err)
+	assert.Equal(3, n)
+	assert.Equal([]string{"cid1", "cid2", "cid3"}, handled)
+	assert.Equal("2024-01-01T00:00:01.000Z", c.Cursor())
+
+	// the handler error stops the page, and the entry isn't skipped
+	_, err = c.Poll(ctx)
+	assert.Error(err)
+	assert.Equal([]string{"cid1", "cid2", "cid3"}, handled)
+
+	failOn = ""
+	_, err = c.Poll(ctx)
+	assert.NoError(err)
+	assert.Equal([]string{"cid1", "cid2", "cid3", "cid4"}, handled)
+
+	after, err := checkpoints.LoadExportCursor(ctx)
+	assert.NoError(err)
+	assert.Equal("2024-01-01T00:00:02.000Z", after)
+
+	// a new consumer carries on from the checkpoint, seeing the entry at the
+	// checkpointed timestamp again
+	handled = nil
+	c2 := NewExportConsumer(srv.URL, checkpoints, handler)
+	n, err = c2.Poll(ctx)
+	assert.NoError(err)
+	assert.Equal(1, n)
+	assert.Equal([]string{"cid4"}, handled)
+}
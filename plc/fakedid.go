@@ -49,8 +49,8 @@ func (fd *FakeDid) GetDocument(ctx context.Context, udid string) (*did.Document,
 
 		VerificationMethod: []did.VerificationMethod{
 			did.VerificationMethod{
-				ID:                 "#signingKey",
-				Type:               rec.KeyType,
+				ID:                 "#atproto",
+				Type:               did.KeyTypeMultikey, // PubKeyMbase includes the key type
 				PublicKeyMultibase: &rec.PubKeyMbase,
 				Controller:         rec.Did,
 			},
@@ -0,0 +1,21 @@
+package mirror
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var opsIngested = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "plcmirror_operations_ingested_total",
+	Help: "Total number of operations accepted from the upstream directory",
+})
+
+var opsRejected = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "plcmirror_operations_rejected_total",
+	Help: "Total number of operations from the upstream directory which failed verification",
+})
+
+var mirrorLag = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "plcmirror_lag_seconds",
+	Help: "Age of the newest operation mirrored from the upstream directory",
+})
@@ -0,0 +1,276 @@
+// Package mirror keeps a verified local copy of a PLC directory, and serves
+// the directory's read endpoints from it.
+//
+// The mirror tails the upstream directory's /export feed, checks every
+// operation against the DID's existing log (hash chain, signatures, and the
+// rules for recovery forks), and stores accepted operations in a SQL
+// database. Operations which don't verify are logged and dropped, so a
+// misbehaving upstream can't make the mirror serve forged documents.
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/plc"
+
+	logging "github.com/ipfs/go-log"
+	"gorm.io/gorm"
+)
+
+var log = logging.Logger("plcmirror")
+
+// Operation is one accepted operation, in the order it was received
+type Operation struct {
+	ID  uint   `gorm:"primarykey"`
+	Did string `gorm:"index"`
+	Cid string `gorm:"uniqueIndex"`
+	// Prev is the CID of the operation this one follows; empty for genesis
+	Prev string
+	// Operation is the operation JSON exactly as the upstream served it
+	Operation []byte
+	Nullified bool
+	// Signer is the did:key which signed the operation, when known (the
+	// signatures on legacy "create" operations can't be checked)
+	Signer    string
+	CreatedAt time.Time `gorm:"index"`
+}
+
+type Mirror struct {
+	db *gorm.DB
+
+	// Consumer follows the upstream directory's export feed. Its position is
+	// checkpointed in the mirror's database.
+	Consumer *plc.ExportConsumer
+}
+
+// NewMirror creates a mirror of the upstream directory, eg
+// "https://plc.directory"
+func NewMirror(db *gorm.DB, upstream string) (*Mirror, error) {
+	if err := db.AutoMigrate(&Operation{}); err != nil {
+		return nil, err
+	}
+	checkpoints, err := plc.NewGormExportCheckpoints(db, "plcmirror")
+	if err != nil {
+		return nil, err
+	}
+	m := &Mirror{db: db}
+	m.Consumer = plc.NewExportConsumer(upstream, checkpoints, m.handleEntry)
+	return m, nil
+}
+
+// Run tails the upstream export until ctx is cancelled
+func (m *Mirror) Run(ctx context.Context) error {
+	return m.Consumer.Run(ctx)
+}
+
+// SyncPage fetches and ingests one page of the upstream export, returning
+// how many entries the page had
+func (m *Mirror) SyncPage(ctx context.Context) (int, error) {
+	return m.Consumer.Poll(ctx)
+}
+
+func (m *Mirror) handleEntry(ctx context.Context, e *plc.LogEntry) error {
+	if err := m.Ingest(ctx, e); err != nil {
+		var rej *RejectedError
+		if !errors.As(err, &rej) {
+			return err
+		}
+		log.Warnw("rejected operation from upstream", "did", e.Did, "cid", e.CID, "reason", rej.Reason)
+		opsRejected.Inc()
+	}
+	if t, err := time.Parse(time.RFC3339Nano, e.CreatedAt); err == nil {
+		mirrorLag.Set(time.Since(t).Seconds())
+	}
+	return nil
+}
+
+// RejectedError is returned by Ingest for operations which fail
+// verification
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return "operation rejected: " + e.Reason
+}
+
+func reject(format string, args ...any) error {
+	return &RejectedError{Reason: fmt.Sprintf(format, args...)}
+}
+
+// Ingest verifies a single log entry against what the mirror already has,
+// and stores it. Entries already stored are ignored.
+func (m *Mirror) Ingest(ctx context.Context, e *plc.LogEntry) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&Operation{}).Where("cid = ?", e.CID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		rec, err := m.verify(tx, e)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(rec).Error; err != nil {
+			return err
+		}
+		opsIngested.Inc()
+		return nil
+	})
+}
+
+func (m *Mirror) verify(tx *gorm.DB, e *plc.LogEntry) (*Operation, error) {
+	op, err := plc.ParseOperation(e.Operation)
+	if err != nil {
+		return nil, reject("unparseable operation: %s", err)
+	}
+	created, err := time.Parse(time.RFC3339Nano, e.CreatedAt)
+	if err != nil {
+		return nil, reject("bad createdAt: %s", err)
+	}
+
+	var head struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(e.Operation, &head)
+	legacy := head.Type == plc.OpTypeLegacyCreate
+
+	rec := &Operation{
+		Did:       e.Did,
+		Cid:       e.CID,
+		Operation: e.Operation,
+		Nullified: e.Nullified,
+		CreatedAt: created,
+	}
+
+	if !legacy {
+		c, err := op.CID()
+		if err != nil {
+			return nil, reject("computing cid: %s", err)
+		}
+		if c.String() != e.CID {
+			return nil, reject("cid mismatch: computed %s", c)
+		}
+	}
+
+	if op.Prev == nil {
+		var count int64
+		if err := tx.Model(&Operation{}).Where("did = ?", e.Did).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, reject("second genesis operation")
+		}
+		if legacy {
+			// signed over an encoding we don't reproduce; trust the upstream
+			return rec, nil
+		}
+		did, err := op.GenesisDID()
+		if err != nil {
+			return nil, reject("%s", err)
+		}
+		if did != e.Did {
+			return nil, reject("genesis operation is for %s", did)
+		}
+		signer, err := op.VerifySignature(op.RotationKeys)
+		if err != nil {
+			return nil, reject("bad genesis signature")
+		}
+		rec.Signer = signer
+		return rec, nil
+	}
+
+	if legacy {
+		return nil, reject("legacy create operation with prev")
+	}
+
+	var prev Operation
+	if err := tx.Where("did = ? AND cid = ?", e.Did, *op.Prev).Take(&prev).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, reject("unknown prev %s", *op.Prev)
+		}
+		return nil, err
+	}
+	prevOp, err := plc.ParseOperation(prev.Operation)
+	if err != nil {
+		return nil, err
+	}
+	if prevOp.Type == plc.OpTypeTombstone {
+		return nil, reject("prev is a tombstone")
+	}
+	signer, err := op.VerifySignature(prevOp.RotationKeys)
+	if err != nil {
+		return nil, reject("not signed by a rotation key of prev")
+	}
+	rec.Prev = prev.Cid
+	rec.Signer = signer
+
+	if e.Nullified {
+		// already replaced upstream; it doesn't change the DID's state
+		return rec, nil
+	}
+	if prev.Nullified {
+		return nil, reject("prev is nullified")
+	}
+
+	// anything after prev is being replaced by a recovery operation
+	var after []Operation
+	if err := tx.Where("did = ? AND id > ? AND NOT nullified", e.Did, prev.ID).Order("id asc").Find(&after).Error; err != nil {
+		return nil, err
+	}
+	if len(after) > 0 {
+		first := after[0]
+		if created.Sub(first.CreatedAt) > plc.RecoveryWindow {
+			return nil, reject("recovery window for %s has passed", first.Cid)
+		}
+		if keyIndex(prevOp.RotationKeys, signer) >= keyIndex(prevOp.RotationKeys, first.Signer) {
+			return nil, reject("recovery operation not signed by a higher priority key")
+		}
+		ids := make([]uint, len(after))
+		for i, a := range after {
+			ids[i] = a.ID
+		}
+		if err := tx.Model(&Operation{}).Where("id IN ?", ids).Update("nullified", true).Error; err != nil {
+			return nil, err
+		}
+		log.Infow("recovery operation nullified later operations", "did", e.Did, "cid", e.CID, "nullified", len(after))
+	}
+	return rec, nil
+}
+
+// keyIndex is the priority of a rotation key: lower is higher priority
+func keyIndex(keys []string, k string) int {
+	for i, kk := range keys {
+		if kk == k {
+			return i
+		}
+	}
+	return len(keys)
+}
+
+// Log returns all operations for a DID, oldest first, including nullified
+// ones
+func (m *Mirror) Log(ctx context.Context, did string) ([]Operation, error) {
+	var ops []Operation
+	if err := m.db.WithContext(ctx).Where("did = ?", did).Order("id asc").Find(&ops).Error; err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+// LastOperation returns the DID's current operation, or
+// gorm.ErrRecordNotFound if the mirror doesn't know the DID
+func (m *Mirror) LastOperation(ctx context.Context, did string) (*plc.Operation, error) {
+	var last Operation
+	if err := m.db.WithContext(ctx).Where("did = ? AND NOT nullified", did).Order("id desc").Take(&last).Error; err != nil {
+		return nil, err
+	}
+	return plc.ParseOperation(last.Operation)
+}
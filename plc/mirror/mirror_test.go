@@ -0,0 +1,239 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/plc"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type testKey struct {
+	priv   crypto.PrivateKey
+	didKey string
+}
+
+func newTestKey(t *testing.T) testKey {
+	priv, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{priv: priv, didKey: pub.DIDKey()}
+}
+
+func newTestMirror(t *testing.T, upstream string) *Mirror {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMirror(db, upstream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func signedEntry(t *testing.T, did string, op *plc.Operation, key testKey, created time.Time) plc.LogEntry {
+	if err := op.Sign(key.priv); err != nil {
+		t.Fatal(err)
+	}
+	if did == "" {
+		d, err := op.GenesisDID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		did = d
+	}
+	c, err := op.CID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plc.LogEntry{
+		Did:       did,
+		Operation: b,
+		CID:       c.String(),
+		CreatedAt: created.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func testOp(rotation []string, handle string, prev *string) *plc.Operation {
+	return &plc.Operation{
+		Type:                plc.OpTypeOperation,
+		RotationKeys:        rotation,
+		VerificationMethods: map[string]string{"atproto": rotation[0]},
+		AlsoKnownAs:         []string{"at://" + handle},
+		Services: map[string]plc.OpService{
+			"atproto_pds": {Type: "AtprotoPersonalDataServer", Endpoint: "https://pds.example.com"},
+		},
+		Prev: prev,
+	}
+}
+
+func TestMirrorVerification(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	m := newTestMirror(t, "")
+
+	recovery := newTestKey(t)
+	rotation := newTestKey(t)
+	attacker := newTestKey(t)
+	keys := []string{recovery.didKey, rotation.didKey}
+	start := time.Now().Add(-time.Hour)
+
+	genesis := signedEntry(t, "", testOp(keys, "alice.example.com", nil), rotation, start)
+	did := genesis.Did
+	assert.NoError(m.Ingest(ctx, &genesis))
+
+	var rej *RejectedError
+
+	// not signed by a rotation key
+	forged := signedEntry(t, did, testOp(keys, "evil.example.com", &genesis.CID), attacker, start.Add(time.Minute))
+	assert.True(errors.As(m.Ingest(ctx, &forged), &rej))
+
+	// a genesis operation claiming a different DID
+	wrongDid := signedEntry(t, did, testOp(keys, "bob.example.com", nil), rotation, start)
+	wrongDid.Did = "did:plc:aaaaaaaaaaaaaaaaaaaaaaaa"
+	assert.True(errors.As(m.Ingest(ctx, &wrongDid), &rej))
+
+	// tampered with after signing: the CID no longer matches
+	update := signedEntry(t, did, testOp(keys, "alice2.example.com", &genesis.CID), rotation, start.Add(2*time.Minute))
+	tampered := update
+	tampered.CID = genesis.CID[:len(genesis.CID)-4] + "aaaa"
+	assert.True(errors.As(m.Ingest(ctx, &tampered), &rej))
+
+	assert.NoError(m.Ingest(ctx, &update))
+	// ingesting again is a no-op
+	assert.NoError(m.Ingest(ctx, &update))
+
+	last, err := m.LastOperation(ctx, did)
+	assert.NoError(err)
+	assert.Equal([]string{"at://alice2.example.com"}, last.AlsoKnownAs)
+
+	// the lower priority key can't override itself
+	fork := signedEntry(t, did, testOp(keys, "fork.example.com", &genesis.CID), rotation, start.Add(3*time.Minute))
+	assert.True(errors.As(m.Ingest(ctx, &fork), &rej))
+
+	// but the recovery key can
+	recoverOp := signedEntry(t, did, testOp(keys, "alice.example.com", &genesis.CID), recovery, start.Add(4*time.Minute))
+	assert.NoError(m.Ingest(ctx, &recoverOp))
+
+	last, err = m.LastOperation(ctx, did)
+	assert.NoError(err)
+	assert.Equal([]string{"at://alice.example.com"}, last.AlsoKnownAs)
+
+	ops, err := m.Log(ctx, did)
+	assert.NoError(err)
+	assert.Len(ops, 3)
+	assert.False(ops[0].Nullified)
+	assert.True(ops[1].Nullified)
+	assert.False(ops[2].Nullified)
+	assert.Equal(recovery.didKey, ops[2].Signer)
+}
+
+func TestMirrorRecoveryWindow(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	m := newTestMirror(t, "")
+
+	recovery := newTestKey(t)
+	rotation := newTestKey(t)
+	keys := []string{recovery.didKey, rotation.didKey}
+	start := time.Now().Add(-30 * 24 * time.Hour)
+
+	genesis := signedEntry(t, "", testOp(keys, "alice.example.com", nil), rotation, start)
+	update := signedEntry(t, genesis.Did, testOp(keys, "alice2.example.com", &genesis.CID), rotation, start.Add(time.Hour))
+	late := signedEntry(t, genesis.Did, testOp(keys, "alice.example.com", &genesis.CID), recovery, start.Add(time.Hour+plc.RecoveryWindow+time.Minute))
+
+	assert.NoError(m.Ingest(ctx, &genesis))
+	assert.NoError(m.Ingest(ctx, &update))
+	var rej *RejectedError
+	assert.True(errors.As(m.Ingest(ctx, &late), &rej))
+}
+
+func TestMirrorSyncAndServe(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	rotation := newTestKey(t)
+	attacker := newTestKey(t)
+	keys := []string{rotation.didKey}
+	start := time.Now().Add(-time.Hour)
+
+	genesis := signedEntry(t, "", testOp(keys, "alice.example.com", nil), rotation, start)
+	forged := signedEntry(t, genesis.Did, testOp(keys, "evil.example.com", &genesis.CID), attacker, start.Add(time.Minute))
+	other := signedEntry(t, "", testOp(keys, "bob.example.com", nil), rotation, start.Add(2*time.Minute))
+	tomb := &plc.Operation{Type: plc.OpTypeTombstone, Prev: &other.CID}
+	tombstone := signedEntry(t, other.Did, tomb, rotation, start.Add(3*time.Minute))
+
+	entries := []plc.LogEntry{genesis, forged, other, tombstone}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/export", r.URL.Path)
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if r.URL.Query().Get("after") >= e.CreatedAt {
+				continue
+			}
+			enc.Encode(e)
+		}
+	}))
+	defer upstream.Close()
+
+	m := newTestMirror(t, upstream.URL)
+	n, err := m.SyncPage(ctx)
+	assert.NoError(err)
+	assert.Equal(4, n)
+	n, err = m.SyncPage(ctx)
+	assert.NoError(err)
+	assert.Equal(0, n)
+
+	srv := httptest.NewServer(NewServer(m).Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/" + genesis.Did)
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	var doc didDocument
+	assert.NoError(json.NewDecoder(resp.Body).Decode(&doc))
+	resp.Body.Close()
+	assert.Equal(genesis.Did, doc.DID.String())
+	assert.Equal([]string{"at://alice.example.com"}, doc.AlsoKnownAs)
+	assert.Equal(genesis.Did+"#atproto", doc.VerificationMethod[0].ID)
+	assert.Equal("https://pds.example.com", doc.Service[0].ServiceEndpoint)
+
+	audit, err := plc.FetchAuditLog(ctx, http.DefaultClient, srv.URL, genesis.Did)
+	assert.NoError(err)
+	assert.Len(audit, 1)
+
+	resp, err = http.Get(srv.URL + "/" + other.Did)
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusGone, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/did:plc:aaaaaaaaaaaaaaaaaaaaaaaa")
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(http.StatusNotFound, resp.StatusCode)
+
+	// a second mirror can follow the first
+	chained := newTestMirror(t, srv.URL)
+	n, err = chained.SyncPage(ctx)
+	assert.NoError(err)
+	assert.Equal(3, n)
+}
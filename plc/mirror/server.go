@@ -0,0 +1,256 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/plc"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// Server serves the read-only parts of the PLC directory HTTP API from a
+// Mirror, so it can be used as the PLC host by any client
+type Server struct {
+	mirror *Mirror
+	echo   *echo.Echo
+}
+
+func NewServer(m *Mirror) *Server {
+	s := &Server{mirror: m}
+
+	e := echo.New()
+	e.HideBanner = true
+	e.Use(middleware.Recover())
+	e.Use(middleware.CORS())
+	e.GET("/_health", s.handleHealth)
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	e.GET("/export", s.handleExport)
+	e.GET("/:did", s.handleResolve)
+	e.GET("/:did/data", s.handleData)
+	e.GET("/:did/log", s.handleLog)
+	e.GET("/:did/log/audit", s.handleAuditLog)
+	e.GET("/:did/log/last", s.handleLastOp)
+	s.echo = e
+
+	return s
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.echo
+}
+
+func (s *Server) Start(listen string) error {
+	return s.echo.Start(listen)
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.echo.Shutdown(ctx)
+}
+
+func (s *Server) handleHealth(c echo.Context) error {
+	if err := s.mirror.db.Exec("SELECT 1").Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"status": "error", "msg": "can't connect to database"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func errorResponse(c echo.Context, code int, msg string) error {
+	return c.JSON(code, map[string]string{"message": msg})
+}
+
+// lastOp loads the DID's current operation, writing the error response and
+// returning nil if there isn't a usable one
+func (s *Server) lastOp(c echo.Context, allowTombstone bool) (string, *plc.Operation, error) {
+	did := c.Param("did")
+	if _, err := syntax.ParseDID(did); err != nil || !strings.HasPrefix(did, "did:plc:") {
+		return did, nil, errorResponse(c, http.StatusBadRequest, "invalid DID: "+did)
+	}
+	op, err := s.mirror.LastOperation(c.Request().Context(), did)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return did, nil, errorResponse(c, http.StatusNotFound, "DID not registered: "+did)
+	}
+	if err != nil {
+		return did, nil, err
+	}
+	if op.Type == plc.OpTypeTombstone && !allowTombstone {
+		return did, nil, errorResponse(c, http.StatusGone, "DID not available: "+did)
+	}
+	return did, op, nil
+}
+
+type didDocument struct {
+	Context []string `json:"@context"`
+	identity.DIDDocument
+}
+
+func (s *Server) handleResolve(c echo.Context) error {
+	did, op, err := s.lastOp(c, false)
+	if op == nil {
+		return err
+	}
+
+	doc := didDocument{
+		Context: []string{
+			"https://www.w3.org/ns/did/v1",
+			"https://w3id.org/security/multikey/v1",
+			"https://w3id.org/security/suites/secp256k1-2019/v1",
+		},
+		DIDDocument: identity.DIDDocument{
+			DID:         syntax.DID(did),
+			AlsoKnownAs: op.AlsoKnownAs,
+		},
+	}
+	for _, k := range sortedKeys(op.VerificationMethods) {
+		doc.VerificationMethod = append(doc.VerificationMethod, identity.DocVerificationMethod{
+			ID:                 did + "#" + k,
+			Type:               "Multikey",
+			Controller:         did,
+			PublicKeyMultibase: strings.TrimPrefix(op.VerificationMethods[k], "did:key:"),
+		})
+	}
+	for _, k := range sortedKeys(op.Services) {
+		doc.Service = append(doc.Service, identity.DocService{
+			ID:              "#" + k,
+			Type:            op.Services[k].Type,
+			ServiceEndpoint: op.Services[k].Endpoint,
+		})
+	}
+	return c.JSON(http.StatusOK, doc)
+}
+
+func (s *Server) handleData(c echo.Context) error {
+	did, op, err := s.lastOp(c, false)
+	if op == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"did":                 did,
+		"rotationKeys":        op.RotationKeys,
+		"verificationMethods": op.VerificationMethods,
+		"alsoKnownAs":         op.AlsoKnownAs,
+		"services":            op.Services,
+	})
+}
+
+func (s *Server) handleLastOp(c echo.Context) error {
+	_, op, err := s.lastOp(c, true)
+	if op == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, op)
+}
+
+// auditLog loads the DID's full log, writing a 404 and returning nil if the
+// DID isn't known
+func (s *Server) auditLog(c echo.Context) ([]Operation, error) {
+	ops, err := s.mirror.Log(c.Request().Context(), c.Param("did"))
+	if err != nil {
+		return nil, err
+	}
+	if len(ops) == 0 {
+		return nil, errorResponse(c, http.StatusNotFound, "DID not registered: "+c.Param("did"))
+	}
+	return ops, nil
+}
+
+func (s *Server) handleLog(c echo.Context) error {
+	ops, err := s.auditLog(c)
+	if ops == nil {
+		return err
+	}
+	out := []*plc.Operation{}
+	for _, o := range ops {
+		if o.Nullified {
+			continue
+		}
+		op, err := plc.ParseOperation(o.Operation)
+		if err != nil {
+			return err
+		}
+		out = append(out, op)
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+func (s *Server) handleAuditLog(c echo.Context) error {
+	ops, err := s.auditLog(c)
+	if ops == nil {
+		return err
+	}
+	out := make([]plc.LogEntry, len(ops))
+	for i, o := range ops {
+		out[i] = o.LogEntry()
+	}
+	return c.JSON(http.StatusOK, out)
+}
+
+// handleExport serves the mirror's own copy of the log in the upstream's
+// export format, so mirrors can be chained
+func (s *Server) handleExport(c echo.Context) error {
+	count := 1000
+	if v := c.QueryParam("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return errorResponse(c, http.StatusBadRequest, "invalid count")
+		}
+		if n < count {
+			count = n
+		}
+	}
+
+	q := s.mirror.db.WithContext(c.Request().Context()).Order("created_at asc, id asc").Limit(count)
+	if v := c.QueryParam("after"); v != "" {
+		after, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return errorResponse(c, http.StatusBadRequest, "invalid after timestamp")
+		}
+		q = q.Where("created_at > ?", after)
+	}
+	var ops []Operation
+	if err := q.Find(&ops).Error; err != nil {
+		return err
+	}
+
+	resp := c.Response()
+	resp.Header().Set(echo.HeaderContentType, "application/jsonl")
+	resp.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(resp)
+	for _, o := range ops {
+		if err := enc.Encode(o.LogEntry()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogEntry converts the stored operation back to the directory's format
+func (o *Operation) LogEntry() plc.LogEntry {
+	return plc.LogEntry{
+		Did:       o.Did,
+		Operation: o.Operation,
+		CID:       o.Cid,
+		Nullified: o.Nullified,
+		CreatedAt: o.CreatedAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
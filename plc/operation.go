@@ -0,0 +1,360 @@
+package plc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+	"github.com/bluesky-social/indigo/atproto/data"
+
+	"github.com/ipfs/go-cid"
+)
+
+const (
+	OpTypeOperation    = "plc_operation"
+	OpTypeTombstone    = "plc_tombstone"
+	OpTypeLegacyCreate = "create"
+)
+
+// MaxRotationKeys is the most rotation keys the PLC directory accepts on a DID.
+const MaxRotationKeys = 5
+
+type OpService struct {
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+}
+
+// Operation is a signed (or not-yet-signed) PLC operation. Tombstones only use
+// the Type, Prev and Sig fields.
+type Operation struct {
+	Type                string               `json:"type"`
+	RotationKeys        []string             `json:"rotationKeys"`
+	VerificationMethods map[string]string    `json:"verificationMethods"`
+	AlsoKnownAs         []string             `json:"alsoKnownAs"`
+	Services            map[string]OpService `json:"services"`
+	Prev                *string              `json:"prev"`
+	Sig                 string               `json:"sig,omitempty"`
+}
+
+// MarshalJSON always includes all plc_operation fields (the signature covers
+// empty lists and maps too), and only the relevant fields of tombstones.
+func (op Operation) MarshalJSON() ([]byte, error) {
+	if op.Type == OpTypeTombstone {
+		return json.Marshal(struct {
+			Type string  `json:"type"`
+			Prev *string `json:"prev"`
+			Sig  string  `json:"sig,omitempty"`
+		}{op.Type, op.Prev, op.Sig})
+	}
+
+	type fullOp struct {
+		Type                string               `json:"type"`
+		RotationKeys        []string             `json:"rotationKeys"`
+		VerificationMethods map[string]string    `json:"verificationMethods"`
+		AlsoKnownAs         []string             `json:"alsoKnownAs"`
+		Services            map[string]OpService `json:"services"`
+		Prev                *string              `json:"prev"`
+		Sig                 string               `json:"sig,omitempty"`
+	}
+	out := fullOp(op)
+	if out.RotationKeys == nil {
+		out.RotationKeys = []string{}
+	}
+	if out.VerificationMethods == nil {
+		out.VerificationMethods = map[string]string{}
+	}
+	if out.AlsoKnownAs == nil {
+		out.AlsoKnownAs = []string{}
+	}
+	if out.Services == nil {
+		out.Services = map[string]OpService{}
+	}
+	return json.Marshal(out)
+}
+
+// legacyCreateOp is the original "create" operation format, which still
+// appears at the start of older DIDs' logs.
+type legacyCreateOp struct {
+	SigningKey  string  `json:"signingKey"`
+	RecoveryKey string  `json:"recoveryKey"`
+	Handle      string  `json:"handle"`
+	Service     string  `json:"service"`
+	Prev        *string `json:"prev"`
+	Sig         string  `json:"sig"`
+}
+
+// ParseOperation decodes an operation from JSON. Legacy "create" operations
+// are converted to the equivalent plc_operation, with the signature dropped
+// (it was made over the legacy encoding).
+func ParseOperation(b []byte) (*Operation, error) {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(b, &head); err != nil {
+		return nil, err
+	}
+
+	switch head.Type {
+	case OpTypeOperation, OpTypeTombstone:
+		var op Operation
+		if err := json.Unmarshal(b, &op); err != nil {
+			return nil, err
+		}
+		return &op, nil
+	case OpTypeLegacyCreate:
+		var lop legacyCreateOp
+		if err := json.Unmarshal(b, &lop); err != nil {
+			return nil, err
+		}
+		return &Operation{
+			Type:                OpTypeOperation,
+			RotationKeys:        []string{lop.RecoveryKey, lop.SigningKey},
+			VerificationMethods: map[string]string{"atproto": lop.SigningKey},
+			AlsoKnownAs:         []string{"at://" + lop.Handle},
+			Services: map[string]OpService{
+				"atproto_pds": {Type: "AtprotoPersonalDataServer", Endpoint: lop.Service},
+			},
+			Prev: lop.Prev,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown PLC operation type: %q", head.Type)
+	}
+}
+
+// encodes the operation as DAG-CBOR, optionally leaving out the signature
+func (op *Operation) cbor(withSig bool) ([]byte, error) {
+	b, err := json.Marshal(op)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := data.UnmarshalJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	if !withSig {
+		delete(obj, "sig")
+	}
+	return data.MarshalCBOR(obj)
+}
+
+// UnsignedBytes returns the DAG-CBOR bytes which get signed.
+func (op *Operation) UnsignedBytes() ([]byte, error) {
+	return op.cbor(false)
+}
+
+// CID returns the CID of the signed operation, which the next operation in
+// the log refers to in its "prev" field.
+func (op *Operation) CID() (cid.Cid, error) {
+	if op.Sig == "" {
+		return cid.Undef, fmt.Errorf("operation is not signed")
+	}
+	b, err := op.cbor(true)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return data.ComputeCID(b)
+}
+
+// GenesisDID returns the did:plc identifier which a signed genesis operation
+// creates: the base32 SHA-256 hash of the signed operation, truncated to 24
+// characters.
+func (op *Operation) GenesisDID() (string, error) {
+	if op.Prev != nil {
+		return "", fmt.Errorf("not a genesis operation")
+	}
+	if op.Sig == "" {
+		return "", fmt.Errorf("operation is not signed")
+	}
+	b, err := op.cbor(true)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	enc := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(h[:]))
+	return "did:plc:" + enc[:24], nil
+}
+
+// Sign signs the operation in place with the given key.
+func (op *Operation) Sign(key crypto.PrivateKey) error {
+	b, err := op.UnsignedBytes()
+	if err != nil {
+		return err
+	}
+	sig, err := key.HashAndSign(b)
+	if err != nil {
+		return err
+	}
+	op.Sig = base64.RawURLEncoding.EncodeToString(sig)
+	return nil
+}
+
+// VerifySignature checks the signature against a list of did:key rotation
+// keys, and returns the one which made it.
+func (op *Operation) VerifySignature(rotationKeys []string) (string, error) {
+	sig, err := base64.RawURLEncoding.DecodeString(op.Sig)
+	if err != nil {
+		return "", fmt.Errorf("decoding signature: %w", err)
+	}
+	b, err := op.UnsignedBytes()
+	if err != nil {
+		return "", err
+	}
+	for _, k := range rotationKeys {
+		pub, err := crypto.ParsePublicDIDKey(k)
+		if err != nil {
+			continue
+		}
+		if err := pub.HashAndVerify(b, sig); err == nil {
+			return k, nil
+		}
+	}
+	return "", crypto.ErrInvalidSignature
+}
+
+// Validate does basic sanity checks on a plc_operation before it is signed,
+// to catch mistakes which the directory would reject, or which would lock
+// the account out.
+func (op *Operation) Validate() error {
+	if op.Type != OpTypeOperation {
+		return nil
+	}
+	if len(op.RotationKeys) == 0 {
+		return fmt.Errorf("operation must have at least one rotation key")
+	}
+	if len(op.RotationKeys) > MaxRotationKeys {
+		return fmt.Errorf("operation has %d rotation keys (max %d)", len(op.RotationKeys), MaxRotationKeys)
+	}
+	seen := make(map[string]bool)
+	for _, k := range op.RotationKeys {
+		if _, err := crypto.ParsePublicDIDKey(k); err != nil {
+			return fmt.Errorf("invalid rotation key %q: %w", k, err)
+		}
+		if seen[k] {
+			return fmt.Errorf("duplicate rotation key: %s", k)
+		}
+		seen[k] = true
+	}
+	for name, k := range op.VerificationMethods {
+		if _, err := crypto.ParsePublicDIDKey(k); err != nil {
+			return fmt.Errorf("invalid verification method %q: %w", name, err)
+		}
+	}
+	for name, svc := range op.Services {
+		if _, err := url.Parse(svc.Endpoint); err != nil || svc.Endpoint == "" {
+			return fmt.Errorf("invalid endpoint for service %q: %q", name, svc.Endpoint)
+		}
+	}
+	return nil
+}
+
+// Copy returns a deep copy of the operation, without signature.
+func (op *Operation) Copy() *Operation {
+	out := &Operation{
+		Type:         op.Type,
+		RotationKeys: append([]string(nil), op.RotationKeys...),
+		AlsoKnownAs:  append([]string(nil), op.AlsoKnownAs...),
+		Prev:         op.Prev,
+	}
+	if op.VerificationMethods != nil {
+		out.VerificationMethods = make(map[string]string, len(op.VerificationMethods))
+		for k, v := range op.VerificationMethods {
+			out.VerificationMethods[k] = v
+		}
+	}
+	if op.Services != nil {
+		out.Services = make(map[string]OpService, len(op.Services))
+		for k, v := range op.Services {
+			out.Services[k] = v
+		}
+	}
+	return out
+}
+
+// LogEntry is one entry of a DID's audit log, as returned by the directory's
+// /:did/log/audit endpoint.
+type LogEntry struct {
+	Did       string          `json:"did"`
+	Operation json.RawMessage `json:"operation"`
+	CID       string          `json:"cid"`
+	Nullified bool            `json:"nullified"`
+	CreatedAt string          `json:"createdAt"`
+}
+
+// FetchAuditLog fetches the full operation log for a DID from a PLC directory.
+func FetchAuditLog(ctx context.Context, c *http.Client, host, did string) ([]LogEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, host+"/"+url.PathEscape(did)+"/log/audit", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("fetching audit log for %s: %d: %s", did, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	var out []LogEntry
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FetchLastOperation returns the most recent non-nullified operation for a
+// DID, and its CID.
+func FetchLastOperation(ctx context.Context, c *http.Client, host, did string) (*Operation, string, error) {
+	entries, err := FetchAuditLog(ctx, c, host, did)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Nullified {
+			continue
+		}
+		op, err := ParseOperation(entries[i].Operation)
+		if err != nil {
+			return nil, "", err
+		}
+		return op, entries[i].CID, nil
+	}
+	return nil, "", fmt.Errorf("no operations in log for %s", did)
+}
+
+// SubmitOperation sends a signed operation for a DID to a PLC directory.
+func SubmitOperation(ctx context.Context, c *http.Client, host, did string, op *Operation) error {
+	body, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, host+"/"+url.PathEscape(did), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("PLC directory rejected operation: %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package plc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+)
+
+func TestOperationSignVerify(t *testing.T) {
+	rotation, err := crypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotationPub, err := rotation.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GeneratePrivateKeyP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, err := other.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	op := &Operation{
+		Type:                OpTypeOperation,
+		RotationKeys:        []string{otherPub.DIDKey(), rotationPub.DIDKey()},
+		VerificationMethods: map[string]string{"atproto": otherPub.DIDKey()},
+		AlsoKnownAs:         []string{"at://alice.example.com"},
+		Services: map[string]OpService{
+			"atproto_pds": {Type: "AtprotoPersonalDataServer", Endpoint: "https://pds.example.com"},
+		},
+	}
+	if err := op.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := op.Sign(rotation); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := op.VerifySignature(op.RotationKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer != rotationPub.DIDKey() {
+		t.Fatalf("wrong signing key reported: %s", signer)
+	}
+
+	// JSON round trip must preserve the signed bytes and CID
+	b, err := json.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseOperation(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c1, err := op.CID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := parsed.CID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != c2 {
+		t.Fatalf("CID changed after JSON round trip: %s != %s", c1, c2)
+	}
+
+	parsed.AlsoKnownAs = []string{"at://mallory.example.com"}
+	if _, err := parsed.VerifySignature(parsed.RotationKeys); err == nil {
+		t.Fatal("modified operation should not verify")
+	}
+}
+
+func TestOperationValidate(t *testing.T) {
+	op := &Operation{Type: OpTypeOperation}
+	if err := op.Validate(); err == nil {
+		t.Fatal("operation without rotation keys should be invalid")
+	}
+
+	op.RotationKeys = []string{"did:key:zNotAKey"}
+	if err := op.Validate(); err == nil {
+		t.Fatal("operation with a bad rotation key should be invalid")
+	}
+}
@@ -0,0 +1,163 @@
+package plc
+
+import (
+	"fmt"
+	"time"
+)
+
+// RecoveryWindow is how long after an operation a higher priority rotation
+// key can still replace it, nullifying it and anything which followed.
+const RecoveryWindow = 72 * time.Hour
+
+// RecoveryPlan describes an operation which rolls a DID back to an earlier
+// state, replacing (nullifying) the operations since then.
+type RecoveryPlan struct {
+	DID string
+	// Target is the operation whose state is being restored. The recovery
+	// operation follows it in the log.
+	Target    *Operation
+	TargetCID string
+	// Nullified are the entries the recovery operation will replace, oldest
+	// first
+	Nullified []LogEntry
+	// NullifiedSigner is the rotation key which signed the first nullified
+	// operation
+	NullifiedSigner string
+	// Deadline is when the recovery window closes
+	Deadline time.Time
+	// Op is the unsigned recovery operation, a copy of Target. It can be
+	// modified before signing, eg to remove a compromised key.
+	Op *Operation
+}
+
+// PlanRecovery works out how to use recoveryKey (a did:key) to roll back a
+// DID, given its audit log.
+//
+// If targetCID is empty, the most recent operation which changed the
+// rotation keys, and wasn't signed by recoveryKey, is taken to be the
+// hostile one, and the DID is rolled back to the operation before it.
+//
+// An error is returned if recoveryKey can't override the operations being
+// replaced: it must be a rotation key of the target, higher priority than
+// the key which signed the first replaced operation, and the first replaced
+// operation must be less than RecoveryWindow older than now.
+func PlanRecovery(entries []LogEntry, recoveryKey string, targetCID string, now time.Time) (*RecoveryPlan, error) {
+	var active []LogEntry
+	var ops []*Operation
+	for _, e := range entries {
+		if e.Nullified {
+			continue
+		}
+		op, err := ParseOperation(e.Operation)
+		if err != nil {
+			return nil, fmt.Errorf("parsing operation %s: %w", e.CID, err)
+		}
+		active = append(active, e)
+		ops = append(ops, op)
+	}
+	if len(active) == 0 {
+		return nil, fmt.Errorf("empty operation log")
+	}
+
+	// signer of operation i, checked against the keys of the one before
+	signer := func(i int) (string, error) {
+		k, err := ops[i].VerifySignature(ops[i-1].RotationKeys)
+		if err != nil {
+			return "", fmt.Errorf("operation %s doesn't verify: %w", active[i].CID, err)
+		}
+		return k, nil
+	}
+
+	target := -1
+	if targetCID == "" {
+		for i := len(ops) - 1; i > 0; i-- {
+			if equalStrings(ops[i].RotationKeys, ops[i-1].RotationKeys) && ops[i].Type != OpTypeTombstone {
+				continue
+			}
+			s, err := signer(i)
+			if err != nil {
+				return nil, err
+			}
+			if s != recoveryKey {
+				target = i - 1
+				break
+			}
+		}
+		if target < 0 {
+			return nil, fmt.Errorf("no rotation key changes found to recover from")
+		}
+	} else {
+		for i, e := range active {
+			if e.CID == targetCID {
+				target = i
+			}
+		}
+		if target < 0 {
+			return nil, fmt.Errorf("operation %s is not in the active log", targetCID)
+		}
+		if target == len(active)-1 {
+			return nil, fmt.Errorf("operation %s is already the current state", targetCID)
+		}
+	}
+
+	t := ops[target]
+	if t.Type == OpTypeTombstone {
+		return nil, fmt.Errorf("can't recover to a tombstone")
+	}
+
+	first, err := signer(target + 1)
+	if err != nil {
+		return nil, err
+	}
+	ki := rotationKeyIndex(t.RotationKeys, recoveryKey)
+	if ki < 0 {
+		return nil, fmt.Errorf("%s is not a rotation key of operation %s", recoveryKey, active[target].CID)
+	}
+	if si := rotationKeyIndex(t.RotationKeys, first); ki >= si {
+		return nil, fmt.Errorf("%s does not have priority over %s, which signed %s", recoveryKey, first, active[target+1].CID)
+	}
+
+	created, err := time.Parse(time.RFC3339Nano, active[target+1].CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("parsing createdAt of %s: %w", active[target+1].CID, err)
+	}
+	deadline := created.Add(RecoveryWindow)
+	if now.After(deadline) {
+		return nil, fmt.Errorf("recovery window for %s closed at %s", active[target+1].CID, deadline.Format(time.RFC3339))
+	}
+
+	op := t.Copy()
+	op.Prev = &active[target].CID
+	return &RecoveryPlan{
+		DID:             active[target].Did,
+		Target:          t,
+		TargetCID:       active[target].CID,
+		Nullified:       active[target+1:],
+		NullifiedSigner: first,
+		Deadline:        deadline,
+		Op:              op,
+	}, nil
+}
+
+// rotationKeyIndex is the priority of a key (lower is higher priority), or
+// -1 if it isn't one of the keys
+func rotationKeyIndex(keys []string, k string) int {
+	for i, kk := range keys {
+		if kk == k {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
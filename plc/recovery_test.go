@@ -0,0 +1,113 @@
+package plc
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/crypto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recoveryTestKey struct {
+	priv   crypto.PrivateKey
+	didKey string
+}
+
+func newRecoveryTestKey(t *testing.T) recoveryTestKey {
+	priv, err := crypto.GeneratePrivateKeyP256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recoveryTestKey{priv: priv, didKey: pub.DIDKey()}
+}
+
+// appendOp signs op with key, chains it to the end of the log, and returns
+// the new log
+func appendOp(t *testing.T, entries []LogEntry, op *Operation, key recoveryTestKey, created time.Time) []LogEntry {
+	if len(entries) > 0 {
+		op.Prev = &entries[len(entries)-1].CID
+	}
+	if err := op.Sign(key.priv); err != nil {
+		t.Fatal(err)
+	}
+	c, err := op.CID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(entries, LogEntry{
+		Did:       "did:plc:ewvi7nxzyoun6zhxrhs64oiz",
+		Operation: b,
+		CID:       c.String(),
+		CreatedAt: created.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+func TestPlanRecovery(t *testing.T) {
+	assert := assert.New(t)
+
+	recovery := newRecoveryTestKey(t)
+	pds := newRecoveryTestKey(t)
+	attacker := newRecoveryTestKey(t)
+	start := time.Now().Add(-24 * time.Hour)
+
+	op := func(rotation []string, handle string) *Operation {
+		return &Operation{
+			Type:                OpTypeOperation,
+			RotationKeys:        rotation,
+			VerificationMethods: map[string]string{"atproto": pds.didKey},
+			AlsoKnownAs:         []string{"at://" + handle},
+		}
+	}
+
+	var entries []LogEntry
+	entries = appendOp(t, entries, op([]string{recovery.didKey, pds.didKey}, "alice.example.com"), pds, start)
+	entries = appendOp(t, entries, op([]string{recovery.didKey, pds.didKey}, "alice2.example.com"), pds, start.Add(time.Hour))
+	// the PDS key is compromised, and used to lock out the recovery key
+	entries = appendOp(t, entries, op([]string{attacker.didKey}, "evil.example.com"), pds, start.Add(2*time.Hour))
+	entries = appendOp(t, entries, op([]string{attacker.didKey}, "evil2.example.com"), attacker, start.Add(3*time.Hour))
+
+	plan, err := PlanRecovery(entries, recovery.didKey, "", time.Now())
+	assert.NoError(err)
+	assert.Equal(entries[1].CID, plan.TargetCID)
+	assert.Equal(entries[1].CID, *plan.Op.Prev)
+	assert.Len(plan.Nullified, 2)
+	assert.Equal(pds.didKey, plan.NullifiedSigner)
+	assert.Equal([]string{"at://alice2.example.com"}, plan.Op.AlsoKnownAs)
+	assert.Equal(start.Add(2*time.Hour+RecoveryWindow).Unix(), plan.Deadline.Unix())
+
+	assert.NoError(plan.Op.Sign(recovery.priv))
+	signer, err := plan.Op.VerifySignature(plan.Target.RotationKeys)
+	assert.NoError(err)
+	assert.Equal(recovery.didKey, signer)
+
+	// explicit target
+	plan, err = PlanRecovery(entries, recovery.didKey, entries[0].CID, time.Now())
+	assert.NoError(err)
+	assert.Len(plan.Nullified, 3)
+
+	// the PDS key doesn't have priority over itself
+	_, err = PlanRecovery(entries, pds.didKey, entries[1].CID, time.Now())
+	assert.Error(err)
+
+	// too late
+	_, err = PlanRecovery(entries, recovery.didKey, "", start.Add(2*time.Hour+RecoveryWindow+time.Minute))
+	assert.Error(err)
+
+	// not in the log
+	_, err = PlanRecovery(entries, recovery.didKey, "bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm", time.Now())
+	assert.Error(err)
+
+	// nothing to recover from
+	_, err = PlanRecovery(entries[:2], recovery.didKey, "", time.Now())
+	assert.Error(err)
+}
@@ -48,6 +48,8 @@ type Repo struct {
 	repoCid cid.Cid
 
 	mst *mst.MerkleSearchTree
+	// optional; see SetNodeCache
+	nodes *mst.NodeCache
 
 	dirty bool
 }
@@ -313,13 +315,29 @@ func (r *Repo) getMst(ctx context.Context) (*mst.MerkleSearchTree, error) {
 	return t, nil
 }
 
+// SetNodeCache has ForEach and DiffSince read the repo's tree through nc,
+// which is Reset to read from the repo's blocks. It's meant for bulk reads of
+// whole repos, such as imports and backfills.
+func (r *Repo) SetNodeCache(nc *mst.NodeCache) {
+	nc.Reset(r.cst)
+	r.nodes = nc
+}
+
+// loadMst loads the tree at root for reading, through the node cache if set
+func (r *Repo) loadMst(root cid.Cid) *mst.MerkleSearchTree {
+	if r.nodes != nil {
+		return r.nodes.LoadMST(root)
+	}
+	return mst.LoadMST(r.cst, root)
+}
+
 var ErrDoneIterating = fmt.Errorf("done iterating")
 
 func (r *Repo) ForEach(ctx context.Context, prefix string, cb func(k string, v cid.Cid) error) error {
 	ctx, span := otel.Tracer("repo").Start(ctx, "ForEach")
 	defer span.End()
 
-	t := mst.LoadMST(r.cst, r.sc.Data)
+	t := r.loadMst(r.sc.Data)
 
 	if err := t.WalkLeavesFrom(ctx, prefix, cb); err != nil {
 		if err != ErrDoneIterating {
@@ -390,6 +408,9 @@ func (r *Repo) DiffSince(ctx context.Context, oldrepo cid.Cid) ([]*mst.DiffOp, e
 		return nil, err
 	}
 
+	if r.nodes != nil {
+		return r.nodes.DiffTrees(ctx, oldTree, curptr)
+	}
 	return mst.DiffTrees(ctx, r.bs, oldTree, curptr)
 }
 
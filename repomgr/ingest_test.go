@@ -149,6 +149,9 @@ func TestIngestWithGap(t *testing.T) {
 	if err := repoman.ImportNewRepo(ctx, 1, did, buf, nil); err != nil {
 		t.Fatal(err)
 	}
+	if _, misses := repoman.nodeCaches.Stats(); misses == 0 {
+		t.Fatal("expected the import to be diffed through a node cache")
+	}
 }
 
 func doPost(t *testing.T, cs *carstore.CarStore, did string, prev *string, postid int) ([]byte, cid.Cid, string, string) {
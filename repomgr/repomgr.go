@@ -61,6 +61,9 @@ type RepoManager struct {
 
 	events         func(context.Context, *RepoEvent)
 	hydrateRecords bool
+
+	// for diffing imported repos
+	nodeCaches mst.NodeCachePool
 }
 
 type ActorInfo struct {
@@ -785,6 +788,9 @@ func (rm *RepoManager) ImportNewRepo(ctx context.Context, user models.Uid, repoD
 		if err != nil {
 			return fmt.Errorf("opening new repo: %w", err)
 		}
+		nc := rm.nodeCaches.Get()
+		defer rm.nodeCaches.Put(nc)
+		r.SetNodeCache(nc)
 
 		scom := r.SignedCommit()
 
@@ -0,0 +1,209 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Post sort orders a RankingVariant can use
+const (
+	PostSortRecent    = "recent"
+	PostSortRelevance = "relevance"
+)
+
+// RankingVariant is a named way of ranking search results: which fields are
+// matched (with what boosts), extra boosts for matching documents, and how
+// posts are sorted. The zero value ranks the same way as with no experiment.
+type RankingVariant struct {
+	Name string `json:"name"`
+	// share of sessions which get this variant, relative to the other
+	// variants in the experiment
+	Weight int `json:"weight"`
+
+	// fields the query is matched against, with optional boosts (eg,
+	// "text^2"); defaults to "everything"
+	Fields []string `json:"fields,omitempty"`
+	// "and" (the default) or "or"
+	DefaultOperator string `json:"defaultOperator,omitempty"`
+	// added as optional clauses, so matching documents score higher
+	Boosts []RankingBoost `json:"boosts,omitempty"`
+
+	// "recent" (the default) sorts posts newest first; "relevance" sorts them
+	// by score
+	PostSort string `json:"postSort,omitempty"`
+	// with relevance sort, how quickly post scores decay with age, as a
+	// duration (eg "72h"); no decay if empty
+	RecencyScale string `json:"recencyScale,omitempty"`
+}
+
+// RankingBoost raises the score of documents where a field has a value
+type RankingBoost struct {
+	Field string  `json:"field"`
+	Value any     `json:"value"`
+	Boost float64 `json:"boost"`
+}
+
+// Experiment splits search sessions between ranking variants. The first
+// variant is the control, which requests without a session get.
+type Experiment struct {
+	Name     string           `json:"name"`
+	Variants []RankingVariant `json:"variants"`
+
+	totalWeight int
+}
+
+// LoadExperiment reads an experiment definition from a JSON file
+func LoadExperiment(path string) (*Experiment, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ex Experiment
+	if err := json.Unmarshal(b, &ex); err != nil {
+		return nil, fmt.Errorf("parsing experiment %s: %w", path, err)
+	}
+	if err := ex.Validate(); err != nil {
+		return nil, err
+	}
+	return &ex, nil
+}
+
+func (ex *Experiment) Validate() error {
+	if ex.Name == "" {
+		return fmt.Errorf("experiment must have a name")
+	}
+	if len(ex.Variants) == 0 {
+		return fmt.Errorf("experiment %s has no variants", ex.Name)
+	}
+	seen := make(map[string]bool)
+	ex.totalWeight = 0
+	for _, v := range ex.Variants {
+		if v.Name == "" || seen[v.Name] {
+			return fmt.Errorf("experiment %s: variant names must be non-empty and unique", ex.Name)
+		}
+		seen[v.Name] = true
+		if v.Weight < 0 {
+			return fmt.Errorf("experiment %s: variant %s has a negative weight", ex.Name, v.Name)
+		}
+		switch v.PostSort {
+		case "", PostSortRecent, PostSortRelevance:
+		default:
+			return fmt.Errorf("experiment %s: variant %s has unknown post sort %q", ex.Name, v.Name, v.PostSort)
+		}
+		switch v.DefaultOperator {
+		case "", "and", "or":
+		default:
+			return fmt.Errorf("experiment %s: variant %s has unknown default operator %q", ex.Name, v.Name, v.DefaultOperator)
+		}
+		if v.RecencyScale != "" {
+			if _, err := time.ParseDuration(v.RecencyScale); err != nil {
+				return fmt.Errorf("experiment %s: variant %s has invalid recency scale: %w", ex.Name, v.Name, err)
+			}
+		}
+		ex.totalWeight += v.Weight
+	}
+	if ex.totalWeight == 0 {
+		return fmt.Errorf("experiment %s: variant weights must not all be zero", ex.Name)
+	}
+	return nil
+}
+
+// Assign picks the variant for a session. The same session always gets the
+// same variant (for as long as the experiment is unchanged), and sessions
+// are split between variants according to their weights.
+func (ex *Experiment) Assign(session string) *RankingVariant {
+	if session == "" {
+		return &ex.Variants[0]
+	}
+	h := fnv.New64a()
+	h.Write([]byte(ex.Name))
+	h.Write([]byte{0})
+	h.Write([]byte(session))
+	bucket := int(h.Sum64() % uint64(ex.totalWeight))
+	for i := range ex.Variants {
+		bucket -= ex.Variants[i].Weight
+		if bucket < 0 {
+			return &ex.Variants[i]
+		}
+	}
+	return &ex.Variants[0]
+}
+
+func (v *RankingVariant) fields() []string {
+	if v == nil || len(v.Fields) == 0 {
+		return []string{"everything"}
+	}
+	return v.Fields
+}
+
+func (v *RankingVariant) defaultOperator() string {
+	if v == nil || v.DefaultOperator == "" {
+		return "and"
+	}
+	return v.DefaultOperator
+}
+
+func (v *RankingVariant) boostClauses() []any {
+	if v == nil {
+		return nil
+	}
+	var out []any
+	for _, b := range v.Boosts {
+		out = append(out, map[string]any{
+			"term": map[string]any{
+				b.Field: map[string]any{"value": b.Value, "boost": b.Boost},
+			},
+		})
+	}
+	return out
+}
+
+var experimentQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "search_experiment_queries_total",
+	Help: "Number of search queries per ranking experiment variant",
+}, []string{"experiment", "variant", "kind"})
+
+var experimentZeroResults = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "search_experiment_zero_results_total",
+	Help: "Number of search queries with no results per ranking experiment variant",
+}, []string{"experiment", "variant", "kind"})
+
+var experimentResults = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "search_experiment_results",
+	Help:    "Number of results returned per ranking experiment variant",
+	Buckets: []float64{0, 1, 5, 10, 25, 50, 100},
+}, []string{"experiment", "variant", "kind"})
+
+var experimentTopScore = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "search_experiment_top_score",
+	Help:    "Score of the first result per ranking experiment variant",
+	Buckets: prometheus.ExponentialBuckets(0.25, 2, 10),
+}, []string{"experiment", "variant", "kind"})
+
+var experimentDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "search_experiment_query_duration_seconds",
+	Help:    "Search query latency per ranking experiment variant",
+	Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+}, []string{"experiment", "variant", "kind"})
+
+// observeVariant records the metrics of one query answered with a variant
+func (ex *Experiment) observeVariant(v *RankingVariant, kind string, resp *EsSearchResponse, took time.Duration) {
+	labels := []string{ex.Name, v.Name, kind}
+	experimentQueries.WithLabelValues(labels...).Inc()
+	experimentDuration.WithLabelValues(labels...).Observe(took.Seconds())
+	experimentResults.WithLabelValues(labels...).Observe(float64(len(resp.Hits.Hits)))
+	if len(resp.Hits.Hits) == 0 {
+		experimentZeroResults.WithLabelValues(labels...).Inc()
+		return
+	}
+	// not scored when sorted by time
+	if score := resp.Hits.Hits[0].Score; score > 0 {
+		experimentTopScore.WithLabelValues(labels...).Observe(score)
+	}
+}
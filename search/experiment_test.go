@@ -0,0 +1,99 @@
+package search
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testExperiment(t *testing.T) *Experiment {
+	ex := &Experiment{
+		Name: "recency",
+		Variants: []RankingVariant{
+			{Name: "control", Weight: 3},
+			{
+				Name:         "relevance",
+				Weight:       1,
+				Fields:       []string{"text^2", "everything"},
+				Boosts:       []RankingBoost{{Field: "lang_code_iso2", Value: "en", Boost: 1.5}},
+				PostSort:     PostSortRelevance,
+				RecencyScale: "72h",
+			},
+		},
+	}
+	if err := ex.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return ex
+}
+
+func TestExperimentAssign(t *testing.T) {
+	assert := assert.New(t)
+	ex := testExperiment(t)
+
+	assert.Equal("control", ex.Assign("").Name)
+
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		session := fmt.Sprintf("did:plc:user%d", i)
+		v := ex.Assign(session)
+		assert.Equal(v.Name, ex.Assign(session).Name)
+		counts[v.Name]++
+	}
+	// weighted 3:1, give or take
+	assert.InDelta(3000, counts["control"], 200)
+	assert.InDelta(1000, counts["relevance"], 200)
+}
+
+func TestExperimentValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	bad := []Experiment{
+		{Variants: []RankingVariant{{Name: "a", Weight: 1}}},
+		{Name: "x"},
+		{Name: "x", Variants: []RankingVariant{{Name: "a", Weight: 1}, {Name: "a", Weight: 1}}},
+		{Name: "x", Variants: []RankingVariant{{Name: "a"}}},
+		{Name: "x", Variants: []RankingVariant{{Name: "a", Weight: 1, PostSort: "oldest"}}},
+		{Name: "x", Variants: []RankingVariant{{Name: "a", Weight: 1, RecencyScale: "3 days"}}},
+	}
+	for _, ex := range bad {
+		assert.Error(ex.Validate(), ex)
+	}
+
+	path := filepath.Join(t.TempDir(), "experiment.json")
+	assert.NoError(os.WriteFile(path, []byte(`{"name": "x", "variants": [{"name": "a", "weight": 1, "defaultOperator": "or"}]}`), 0644))
+	ex, err := LoadExperiment(path)
+	assert.NoError(err)
+	assert.Equal("or", ex.Assign("session").defaultOperator())
+}
+
+func TestVariantQueries(t *testing.T) {
+	assert := assert.New(t)
+	ex := testExperiment(t)
+
+	// the control (and no experiment at all) is the default ranking
+	assert.Equal(postsQuery("cats", nil, 0, 25, nil), postsQuery("cats", nil, 0, 25, &ex.Variants[0]))
+	assert.Equal(profilesQuery("cats", nil, 0, 25, nil), profilesQuery("cats", nil, 0, 25, &ex.Variants[0]))
+
+	q := postsQuery("cats", nil, 0, 25, nil)
+	assert.Contains(q, "sort")
+
+	v := &ex.Variants[1]
+	q = postsQuery("cats", nil, 0, 25, v)
+	assert.NotContains(q, "sort")
+	fs := q["query"].(map[string]any)["function_score"].(map[string]any)
+	gauss := fs["functions"].([]any)[0].(map[string]any)["gauss"].(map[string]any)
+	assert.Equal("259200s", gauss["created_at"].(map[string]any)["scale"])
+
+	boolQuery := fs["query"].(map[string]any)["bool"].(map[string]any)
+	sqs := boolQuery["must"].(map[string]any)["simple_query_string"].(map[string]any)
+	assert.Equal([]string{"text^2", "everything"}, sqs["fields"])
+	assert.Equal(v.boostClauses(), boolQuery["should"])
+
+	q = profilesQuery("cats", nil, 0, 25, v)
+	should := q["query"].(map[string]any)["bool"].(map[string]any)["should"].([]any)
+	assert.Equal(3, len(should))
+}
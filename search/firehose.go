@@ -105,7 +105,7 @@ func (s *Server) RunIndexer(ctx context.Context) error {
 			job, err := s.bfs.GetJob(ctx, evt.Repo)
 			if job == nil && err == nil {
 				logEvt.Info("enqueueing backfill job for new repo")
-				if err := s.bfs.EnqueueJob(evt.Repo); err != nil {
+				if err := s.bfs.EnqueueJobWithPriority(evt.Repo, backfill.PriorityActive); err != nil {
 					logEvt.Warn("failed to enqueue backfill job", "err", err)
 				}
 			}
@@ -141,14 +141,14 @@ func (s *Server) RunIndexer(ctx context.Context) error {
 						profilesReceived.Inc()
 					}
 
-					if err := s.handleOp(ctx, ek, evt.Seq, op.Path, evt.Repo, &rc, rec); err != nil {
+					if err := s.handleOp(ctx, ek, evt.Seq, evt.Rev, evt.Since, op.Path, evt.Repo, &rc, rec); err != nil {
 						// TODO: handle this case (instead of return nil)
 						logOp.Error("failed to handle event op", "err", err)
 						return nil
 					}
 
 				case repomgr.EvtKindDeleteRecord:
-					if err := s.handleOp(ctx, ek, evt.Seq, op.Path, evt.Repo, nil, nil); err != nil {
+					if err := s.handleOp(ctx, ek, evt.Seq, evt.Rev, evt.Since, op.Path, evt.Repo, nil, nil); err != nil {
 						// TODO: handle this case (instead of return nil)
 						logOp.Error("failed to handle delete", "err", err)
 						return nil
@@ -311,26 +311,35 @@ func (s *Server) handleDelete(ctx context.Context, rawDID, path string) error {
 	return nil
 }
 
-func (s *Server) handleOp(ctx context.Context, op repomgr.EventKind, seq int64, path string, did string, rcid *cid.Cid, rec typegen.CBORMarshaler) error {
+func (s *Server) handleOp(ctx context.Context, op repomgr.EventKind, seq int64, rev string, since *string, path string, did string, rcid *cid.Cid, rec typegen.CBORMarshaler) error {
 	var err error
 	if !strings.Contains(path, "app.bsky.feed.post") && !strings.Contains(path, "app.bsky.actor.profile") {
 		return nil
 	}
 
+	// Ops buffered during a backfill are replayed in rev order once it's done
+	bufferOp := func() (bool, error) {
+		return s.bfs.BufferCommit(ctx, did, &backfill.BufferedCommit{
+			Rev:   rev,
+			Since: since,
+			Ops:   []*backfill.BufferedOp{{Kind: string(op), Path: path, Record: &rec, Cid: rcid}},
+		})
+	}
+
 	if op == repomgr.EvtKindCreateRecord || op == repomgr.EvtKindUpdateRecord {
 		s.logger.Debug("processing create record op", "seq", seq, "did", did, "path", path)
 
 		// Try to buffer the op, if it fails, we need to create a backfill job
-		_, err := s.bfs.BufferOp(ctx, did, string(op), path, &rec, rcid)
+		_, err := bufferOp()
 		if err == backfill.ErrJobNotFound {
 			s.logger.Debug("no backfill job found for repo, creating one", "did", did)
 
-			if err := s.bfs.EnqueueJob(did); err != nil {
+			if err := s.bfs.EnqueueJobWithPriority(did, backfill.PriorityActive); err != nil {
 				return fmt.Errorf("enqueueing backfill job: %w", err)
 			}
 
 			// Try to buffer the op again so it gets picked up by the backfill job
-			_, err = s.bfs.BufferOp(ctx, did, string(op), path, &rec, rcid)
+			_, err = bufferOp()
 			if err != nil {
 				return fmt.Errorf("buffering backfill op: %w", err)
 			}
@@ -342,16 +351,16 @@ func (s *Server) handleOp(ctx context.Context, op repomgr.EventKind, seq int64,
 		s.logger.Debug("processing delete record op", "seq", seq, "did", did, "path", path)
 
 		// Try to buffer the op, if it fails, we need to create a backfill job
-		_, err := s.bfs.BufferOp(ctx, did, string(op), path, &rec, rcid)
+		_, err := bufferOp()
 		if err == backfill.ErrJobNotFound {
 			s.logger.Debug("no backfill job found for repo, creating one", "did", did)
 
-			if err := s.bfs.EnqueueJob(did); err != nil {
+			if err := s.bfs.EnqueueJobWithPriority(did, backfill.PriorityActive); err != nil {
 				return fmt.Errorf("enqueueing backfill job: %w", err)
 			}
 
 			// Try to buffer the op again so it gets picked up by the backfill job
-			_, err = s.bfs.BufferOp(ctx, did, string(op), path, &rec, rcid)
+			_, err = bufferOp()
 			if err != nil {
 				return fmt.Errorf("buffering backfill op: %w", err)
 			}
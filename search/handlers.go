@@ -6,14 +6,17 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	appbsky "github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/backfill"
 
 	"github.com/labstack/echo/v4"
 	otel "go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var tracer = otel.Tracer("search")
@@ -85,7 +88,10 @@ func (s *Server) handleSearchPostsSkeleton(e echo.Context) error {
 
 	span.SetAttributes(attribute.Int("offset", offset), attribute.Int("limit", limit))
 
-	out, err := s.SearchPosts(ctx, q, offset, limit)
+	session := searchSession(e)
+	s.setVariantHeader(ctx, e, session)
+
+	out, err := s.SearchPosts(ctx, q, session, offset, limit)
 	if err != nil {
 		span.SetAttributes(attribute.String("error", fmt.Sprintf("failed to SearchPosts: %s", err)))
 		span.SetStatus(codes.Error, err.Error())
@@ -128,7 +134,12 @@ func (s *Server) handleSearchActorsSkeleton(e echo.Context) error {
 		attribute.Bool("typeahead", typeahead),
 	)
 
-	out, err := s.SearchProfiles(ctx, q, typeahead, offset, limit)
+	session := searchSession(e)
+	if !typeahead {
+		s.setVariantHeader(ctx, e, session)
+	}
+
+	out, err := s.SearchProfiles(ctx, q, session, typeahead, offset, limit)
 	if err != nil {
 		span.SetAttributes(attribute.String("error", fmt.Sprintf("failed to SearchProfiles: %s", err)))
 		span.SetStatus(codes.Error, err.Error())
@@ -171,7 +182,7 @@ func (s *Server) handleIndexRepos(e echo.Context) error {
 	for _, did := range dids {
 		job, err := s.bfs.GetJob(ctx, did)
 		if job == nil && err == nil {
-			err := s.bfs.EnqueueJob(did)
+			err := s.bfs.EnqueueJobWithPriority(did, backfill.PriorityAdmin)
 			if err != nil {
 				errs = append(errs, IndexError{
 					DID: did,
@@ -182,6 +193,16 @@ func (s *Server) handleIndexRepos(e echo.Context) error {
 			successes++
 			continue
 		}
+		// explicitly requested repos jump the queue if they haven't started yet
+		if job != nil && job.State() == backfill.StateEnqueued {
+			if err := s.bfs.SetJobPriority(ctx, did, backfill.PriorityAdmin); err != nil {
+				errs = append(errs, IndexError{
+					DID: did,
+					Err: err.Error(),
+				})
+				continue
+			}
+		}
 		skipped++
 	}
 
@@ -193,14 +214,98 @@ func (s *Server) handleIndexRepos(e echo.Context) error {
 	})
 }
 
-func (s *Server) SearchPosts(ctx context.Context, q string, offset, size int) (*appbsky.UnspeccedSearchPostsSkeleton_Output, error) {
+func (s *Server) handleTrendingTerms(e echo.Context) error {
+	ctx, span := tracer.Start(e.Request().Context(), "handleTrendingTerms")
+	defer span.End()
+
+	params := TrendingParams{
+		Field: strings.TrimSpace(e.QueryParam("field")),
+		Lang:  strings.TrimSpace(e.QueryParam("lang")),
+	}
+	for name, dst := range map[string]*time.Duration{"window": &params.Window, "baseline": &params.Baseline} {
+		if v := strings.TrimSpace(e.QueryParam(name)); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return e.JSON(400, map[string]any{
+					"error": fmt.Sprintf("invalid value for '%s': %s", name, err),
+				})
+			}
+			*dst = d
+		}
+	}
+	if l := strings.TrimSpace(e.QueryParam("limit")); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil {
+			return e.JSON(400, map[string]any{
+				"error": fmt.Sprintf("invalid value for 'limit': %s", err),
+			})
+		}
+		params.Size = min(v, 100)
+	}
+	if sw := strings.TrimSpace(e.QueryParam("stopwords")); sw != "" {
+		params.Stopwords = strings.Split(sw, ",")
+	}
+	if err := params.normalize(); err != nil {
+		return e.JSON(400, map[string]any{
+			"error": err.Error(),
+		})
+	}
+
+	span.SetAttributes(attribute.String("field", params.Field), attribute.String("lang", params.Lang))
+
+	out, err := s.TrendingTerms(ctx, params)
+	if err != nil {
+		span.SetAttributes(attribute.String("error", fmt.Sprintf("failed to TrendingTerms: %s", err)))
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	span.SetAttributes(attribute.Int("terms.length", len(out.Terms)))
+
+	return e.JSON(200, out)
+}
+
+// searchSession identifies the session a search belongs to, for assigning
+// ranking experiment variants: an explicit session parameter, or the viewer
+func searchSession(e echo.Context) string {
+	if session := strings.TrimSpace(e.QueryParam("session")); session != "" {
+		return session
+	}
+	return strings.TrimSpace(e.QueryParam("viewer"))
+}
+
+// setVariantHeader tells the client which ranking variant answered, so it can
+// be correlated with client-side engagement
+func (s *Server) setVariantHeader(ctx context.Context, e echo.Context, session string) {
+	if s.experiment == nil {
+		return
+	}
+	v := s.experiment.Assign(session)
+	e.Response().Header().Set("X-Search-Variant", s.experiment.Name+"/"+v.Name)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("experiment", s.experiment.Name),
+		attribute.String("variant", v.Name),
+	)
+}
+
+// SearchPosts runs a post search. With a ranking experiment configured, the
+// variant is picked from the session (which may be empty).
+func (s *Server) SearchPosts(ctx context.Context, q, session string, offset, size int) (*appbsky.UnspeccedSearchPostsSkeleton_Output, error) {
 	ctx, span := tracer.Start(ctx, "SearchPosts")
 	defer span.End()
 
-	resp, err := DoSearchPosts(ctx, s.dir, s.escli, s.postIndex, q, offset, size)
+	var v *RankingVariant
+	if s.experiment != nil {
+		v = s.experiment.Assign(session)
+	}
+	start := time.Now()
+	resp, err := DoSearchPostsVariant(ctx, s.dir, s.escli, s.postParts, q, offset, size, v)
 	if err != nil {
 		return nil, err
 	}
+	if v != nil {
+		s.experiment.observeVariant(v, "posts", resp, time.Since(start))
+	}
 
 	posts := []*appbsky.UnspeccedDefs_SkeletonSearchPost{}
 	for _, r := range resp.Hits.Hits {
@@ -231,7 +336,9 @@ func (s *Server) SearchPosts(ctx context.Context, q string, offset, size int) (*
 	return &out, nil
 }
 
-func (s *Server) SearchProfiles(ctx context.Context, q string, typeahead bool, offset, size int) (*appbsky.UnspeccedSearchActorsSkeleton_Output, error) {
+// SearchProfiles runs a profile search. Ranking experiments don't apply to
+// typeahead searches.
+func (s *Server) SearchProfiles(ctx context.Context, q, session string, typeahead bool, offset, size int) (*appbsky.UnspeccedSearchActorsSkeleton_Output, error) {
 	ctx, span := tracer.Start(ctx, "SearchProfiles")
 	defer span.End()
 
@@ -240,7 +347,15 @@ func (s *Server) SearchProfiles(ctx context.Context, q string, typeahead bool, o
 	if typeahead {
 		resp, err = DoSearchProfilesTypeahead(ctx, s.escli, s.profileIndex, q, size)
 	} else {
-		resp, err = DoSearchProfiles(ctx, s.dir, s.escli, s.profileIndex, q, offset, size)
+		var v *RankingVariant
+		if s.experiment != nil {
+			v = s.experiment.Assign(session)
+		}
+		start := time.Now()
+		resp, err = DoSearchProfilesVariant(ctx, s.dir, s.escli, s.profileIndex, q, offset, size, v)
+		if err == nil && v != nil {
+			s.experiment.observeVariant(v, "profiles", resp, time.Since(start))
+		}
 	}
 	if err != nil {
 		return nil, err
@@ -274,3 +389,10 @@ func (s *Server) SearchProfiles(ctx context.Context, q string, typeahead bool, o
 	}
 	return &out, nil
 }
+
+func (s *Server) TrendingTerms(ctx context.Context, params TrendingParams) (*TrendingResult, error) {
+	ctx, span := tracer.Start(ctx, "TrendingTerms")
+	defer span.End()
+
+	return DoTrendingTerms(ctx, s.escli, s.postParts, params)
+}
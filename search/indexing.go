@@ -27,11 +27,27 @@ func (s *Server) deletePost(ctx context.Context, ident *identity.Identity, rkey
 	log := s.logger.With("repo", ident.DID, "rkey", rkey, "op", "deletePost")
 	log.Info("deleting post from index")
 	docID := fmt.Sprintf("%s_%s", ident.DID.String(), rkey)
-	req := esapi.DeleteRequest{
+	var req esapi.Request = esapi.DeleteRequest{
 		Index:      s.postIndex,
 		DocumentID: docID,
 		Refresh:    "true",
 	}
+	if s.postParts.Partitioned() {
+		// we don't know which partition the post is in, and documents can't
+		// be deleted by ID through an alias of several indices
+		b, err := json.Marshal(map[string]any{
+			"query": map[string]any{"ids": map[string]any{"values": []string{docID}}},
+		})
+		if err != nil {
+			return err
+		}
+		refresh := true
+		req = esapi.DeleteByQueryRequest{
+			Index:   []string{s.postIndex},
+			Body:    bytes.NewReader(b),
+			Refresh: &refresh,
+		}
+	}
 
 	res, err := req.Do(ctx, s.escli)
 	if err != nil {
@@ -80,7 +96,7 @@ func (s *Server) indexPost(ctx context.Context, ident *identity.Identity, rec *a
 
 	log.Debug("indexing post")
 	req := esapi.IndexRequest{
-		Index:      s.postIndex,
+		Index:      s.postParts.IndexFor(&doc),
 		DocumentID: doc.DocId(),
 		Body:       bytes.NewReader(b),
 	}
@@ -33,6 +33,28 @@ func ParseQuery(ctx context.Context, dir identity.Directory, raw string) (string
 			})
 			continue
 		}
+		if strings.HasPrefix(p, "since:") || strings.HasPrefix(p, "until:") {
+			// date (2024-03-01) or full timestamp
+			k, v, _ := strings.Cut(p, ":")
+			if _, err := parseFilterTime(v); err != nil {
+				keep = append(keep, p)
+				continue
+			}
+			op := "gte"
+			if k == "until" {
+				op = "lt"
+			}
+			filters = append(filters, map[string]interface{}{
+				"range": map[string]interface{}{"created_at": map[string]interface{}{op: v}},
+			})
+			continue
+		}
+		if strings.HasPrefix(p, "lang:") && len(p) == 7 {
+			filters = append(filters, map[string]interface{}{
+				"term": map[string]interface{}{"lang_code_iso2": strings.ToLower(p[5:])},
+			})
+			continue
+		}
 		if strings.HasPrefix(p, "from:") && len(p) > 6 {
 			handle, err := syntax.ParseHandle(p[5:])
 			if err != nil {
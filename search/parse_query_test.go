@@ -45,4 +45,18 @@ func TestParseQuery(t *testing.T) {
 	q, f = ParseQuery(ctx, &dir, p4)
 	assert.Equal("*", q)
 	assert.Equal(1, len(f))
+
+	p5 := "eclipse since:2024-04-08 until:2024-04-09T00:00:00Z lang:EN"
+	q, f = ParseQuery(ctx, &dir, p5)
+	assert.Equal("eclipse", q)
+	assert.Equal([]map[string]interface{}{
+		{"range": map[string]interface{}{"created_at": map[string]interface{}{"gte": "2024-04-08"}}},
+		{"range": map[string]interface{}{"created_at": map[string]interface{}{"lt": "2024-04-09T00:00:00Z"}}},
+		{"term": map[string]interface{}{"lang_code_iso2": "en"}},
+	}, f)
+
+	p6 := "since:yesterday"
+	q, f = ParseQuery(ctx, &dir, p6)
+	assert.Equal(p6, q)
+	assert.Empty(f)
 }
@@ -0,0 +1,257 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/util"
+
+	es "github.com/opensearch-project/opensearch-go/v2"
+	esapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
+)
+
+const (
+	// PartitionNone keeps all posts in a single index
+	PartitionNone = ""
+	// PartitionMonthly writes posts to one index per month of created_at
+	PartitionMonthly = "month"
+	// PartitionDaily writes posts to one index per day of created_at
+	PartitionDaily = "day"
+	// PartitionLanguage writes posts to one index per (two-letter) language
+	PartitionLanguage = "lang"
+)
+
+// posts in more than one language, and posts with no language, get their own
+// partitions in PartitionLanguage
+const (
+	partitionMultiLang = "mul"
+	partitionNoLang    = "und"
+)
+
+// queries spanning more time partitions than this just search the alias
+const maxPrunedPartitions = 62
+
+// PostPartitions describes how the post index is laid out. With partitioning,
+// posts are written to separate indices ("<alias>-2024.03", "<alias>-en",
+// etc), which are all members of Alias. Searches go to the alias, or to just
+// the partitions which can match the query's filters. Old time partitions can
+// be deleted to enforce retention, and shard counts tuned per partition.
+type PostPartitions struct {
+	Alias string
+	By    string
+}
+
+func (p PostPartitions) Partitioned() bool {
+	return p.By != PartitionNone
+}
+
+func (p PostPartitions) validate() error {
+	switch p.By {
+	case PartitionNone, PartitionMonthly, PartitionDaily, PartitionLanguage:
+		return nil
+	default:
+		return fmt.Errorf("unsupported post index partitioning: %s", p.By)
+	}
+}
+
+func (p PostPartitions) timeSuffix(t time.Time) string {
+	if p.By == PartitionDaily {
+		return t.UTC().Format("2006.01.02")
+	}
+	return t.UTC().Format("2006.01")
+}
+
+// IndexFor returns the concrete index a post document is written to
+func (p PostPartitions) IndexFor(doc *PostDoc) string {
+	switch p.By {
+	case PartitionMonthly, PartitionDaily:
+		// posts without a valid created_at can't match time ranges anyway, so
+		// it doesn't matter which partition they end up in
+		ts := doc.DocIndexTs
+		if doc.CreatedAt != nil {
+			ts = *doc.CreatedAt
+		}
+		t, err := util.ParseTimestamp(ts)
+		if err != nil {
+			t = time.Now()
+		}
+		return p.Alias + "-" + p.timeSuffix(t)
+	case PartitionLanguage:
+		lang := partitionNoLang
+		if len(doc.LangCodeIso2) == 1 {
+			lang = strings.ToLower(doc.LangCodeIso2[0])
+		} else if len(doc.LangCodeIso2) > 1 {
+			lang = partitionMultiLang
+		}
+		return p.Alias + "-" + lang
+	default:
+		return p.Alias
+	}
+}
+
+// SearchIndex returns the index expression to search for a query with the
+// given filters: a comma-separated list of the partitions which could hold
+// matching posts, or the alias if they can't be narrowed down.
+func (p PostPartitions) SearchIndex(filters []map[string]any) string {
+	switch p.By {
+	case PartitionMonthly, PartitionDaily:
+		since, until := filterTimeRange(filters)
+		// created_at can be anything (including the future), so both ends of
+		// the range are needed to bound which partitions match
+		if since.IsZero() || until.IsZero() || until.Before(since) {
+			return p.Alias
+		}
+		var names []string
+		for t := since; ; {
+			names = append(names, p.Alias+"-"+p.timeSuffix(t))
+			if len(names) > maxPrunedPartitions {
+				return p.Alias
+			}
+			if p.By == PartitionDaily {
+				t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			} else {
+				t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			}
+			if t.After(until) {
+				break
+			}
+		}
+		return strings.Join(names, ",")
+	case PartitionLanguage:
+		lang := filterLang(filters)
+		if lang == "" {
+			return p.Alias
+		}
+		return p.Alias + "-" + lang + "," + p.Alias + "-" + partitionMultiLang
+	default:
+		return p.Alias
+	}
+}
+
+// filterTimeRange intersects any created_at range filters. Either end is zero
+// if unbounded.
+func filterTimeRange(filters []map[string]any) (time.Time, time.Time) {
+	var since, until time.Time
+	for _, f := range filters {
+		rng, ok := f["range"].(map[string]any)
+		if !ok {
+			continue
+		}
+		bounds, ok := rng["created_at"].(map[string]any)
+		if !ok {
+			continue
+		}
+		for op, v := range bounds {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			t, err := parseFilterTime(s)
+			if err != nil {
+				continue
+			}
+			switch op {
+			case "gte", "gt":
+				if since.IsZero() || t.After(since) {
+					since = t
+				}
+			case "lte", "lt":
+				if until.IsZero() || t.Before(until) {
+					until = t
+				}
+			}
+		}
+	}
+	return since.UTC(), until.UTC()
+}
+
+func parseFilterTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// filterLang returns the language of a lang_code_iso2 term filter, if there
+// is exactly one
+func filterLang(filters []map[string]any) string {
+	lang := ""
+	for _, f := range filters {
+		term, ok := f["term"].(map[string]any)
+		if !ok {
+			continue
+		}
+		v, ok := term["lang_code_iso2"].(string)
+		if !ok {
+			continue
+		}
+		if lang != "" && lang != strings.ToLower(v) {
+			// contradictory filters; let the search sort it out
+			return ""
+		}
+		lang = strings.ToLower(v)
+	}
+	return lang
+}
+
+// ensurePartitionTemplate creates (or updates) an index template, so that
+// partitions are created with the post schema, as members of the alias, the
+// first time a post is written to them.
+func (p PostPartitions) ensurePartitionTemplate(ctx context.Context, escli *es.Client, schemaJSON string) error {
+	// a concrete index with the alias's name (eg, from before partitioning
+	// was enabled) would prevent the alias from being created
+	resp, err := escli.Indices.Exists([]string{p.Alias}, escli.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode == 200 {
+		resp, err := escli.Indices.ExistsAlias([]string{p.Alias}, escli.Indices.ExistsAlias.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == 404 {
+			return fmt.Errorf("post index %s exists and is not an alias; it can't be partitioned in place", p.Alias)
+		}
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(schemaJSON), &schema); err != nil {
+		return fmt.Errorf("parsing post index schema: %w", err)
+	}
+	tmpl := map[string]any{
+		"index_patterns": []string{p.Alias + "-*"},
+		"template": map[string]any{
+			"settings": schema["settings"],
+			"mappings": schema["mappings"],
+			"aliases":  map[string]any{p.Alias: map[string]any{}},
+		},
+	}
+	b, err := json.Marshal(tmpl)
+	if err != nil {
+		return err
+	}
+
+	req := esapi.IndicesPutIndexTemplateRequest{
+		Name: p.Alias,
+		Body: bytes.NewReader(b),
+	}
+	res, err := req.Do(ctx, escli)
+	if err != nil {
+		return fmt.Errorf("failed to put post partition template: %w", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if res.IsError() {
+		return fmt.Errorf("failed to put post partition template, code=%d: %s", res.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostPartitionsIndexFor(t *testing.T) {
+	assert := assert.New(t)
+	createdAt := "2024-03-09T23:30:00-05:00"
+	doc := PostDoc{
+		DocIndexTs:   "2024-04-01T00:00:00.000Z",
+		CreatedAt:    &createdAt,
+		LangCodeIso2: []string{"EN"},
+	}
+
+	assert.Equal("palomar_post", PostPartitions{Alias: "palomar_post"}.IndexFor(&doc))
+	assert.Equal("palomar_post-2024.03", PostPartitions{Alias: "palomar_post", By: PartitionMonthly}.IndexFor(&doc))
+	// partitions are by UTC day
+	assert.Equal("palomar_post-2024.03.10", PostPartitions{Alias: "palomar_post", By: PartitionDaily}.IndexFor(&doc))
+	assert.Equal("palomar_post-en", PostPartitions{Alias: "palomar_post", By: PartitionLanguage}.IndexFor(&doc))
+
+	doc.CreatedAt = nil
+	doc.LangCodeIso2 = []string{"en", "ja"}
+	assert.Equal("palomar_post-2024.04", PostPartitions{Alias: "palomar_post", By: PartitionMonthly}.IndexFor(&doc))
+	assert.Equal("palomar_post-mul", PostPartitions{Alias: "palomar_post", By: PartitionLanguage}.IndexFor(&doc))
+	doc.LangCodeIso2 = nil
+	assert.Equal("palomar_post-und", PostPartitions{Alias: "palomar_post", By: PartitionLanguage}.IndexFor(&doc))
+
+	assert.Error(PostPartitions{Alias: "palomar_post", By: "year"}.validate())
+}
+
+func TestPostPartitionsSearchIndex(t *testing.T) {
+	assert := assert.New(t)
+	since := func(v string) map[string]any {
+		return map[string]any{"range": map[string]any{"created_at": map[string]any{"gte": v}}}
+	}
+	until := func(v string) map[string]any {
+		return map[string]any{"range": map[string]any{"created_at": map[string]any{"lt": v}}}
+	}
+	lang := func(v string) map[string]any {
+		return map[string]any{"term": map[string]any{"lang_code_iso2": v}}
+	}
+
+	monthly := PostPartitions{Alias: "p", By: PartitionMonthly}
+	assert.Equal("p", monthly.SearchIndex(nil))
+	// open-ended ranges can't be pruned
+	assert.Equal("p", monthly.SearchIndex([]map[string]any{since("2024-01-15")}))
+	assert.Equal("p", monthly.SearchIndex([]map[string]any{until("2024-01-15")}))
+	assert.Equal("p-2023.12,p-2024.01,p-2024.02", monthly.SearchIndex([]map[string]any{since("2023-12-31"), until("2024-02-01")}))
+	// ranges are intersected
+	assert.Equal("p-2024.01", monthly.SearchIndex([]map[string]any{since("2023-12-31"), until("2024-02-01"), since("2024-01-10T00:00:00Z"), until("2024-01-20")}))
+
+	daily := PostPartitions{Alias: "p", By: PartitionDaily}
+	assert.Equal("p-2024.02.28,p-2024.02.29,p-2024.03.01", daily.SearchIndex([]map[string]any{since("2024-02-28T12:00:00Z"), until("2024-03-01T01:00:00Z")}))
+	assert.Equal("p", daily.SearchIndex([]map[string]any{since("2023-01-01"), until("2024-01-01")}))
+
+	langs := PostPartitions{Alias: "p", By: PartitionLanguage}
+	assert.Equal("p", langs.SearchIndex([]map[string]any{since("2024-01-01")}))
+	assert.Equal("p-ja,p-mul", langs.SearchIndex([]map[string]any{lang("JA")}))
+	assert.Equal("p", langs.SearchIndex([]map[string]any{lang("ja"), lang("en")}))
+
+	assert.Equal("p", PostPartitions{Alias: "p"}.SearchIndex([]map[string]any{lang("ja")}))
+}
@@ -7,11 +7,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"go.opentelemetry.io/otel/attribute"
 
 	es "github.com/opensearch-project/opensearch-go/v2"
+	esapi "github.com/opensearch-project/opensearch-go/v2/opensearchapi"
 )
 
 type EsSearchHit struct {
@@ -34,6 +37,8 @@ type EsSearchResponse struct {
 	Took     int          `json:"took"`
 	TimedOut bool         `json:"timed_out"`
 	Hits     EsSearchHits `json:"hits"`
+
+	Aggregations map[string]json.RawMessage `json:"aggregations,omitempty"`
 }
 
 type UserResult struct {
@@ -55,7 +60,13 @@ func checkParams(offset, size int) error {
 	return nil
 }
 
-func DoSearchPosts(ctx context.Context, dir identity.Directory, escli *es.Client, index, q string, offset, size int) (*EsSearchResponse, error) {
+func DoSearchPosts(ctx context.Context, dir identity.Directory, escli *es.Client, posts PostPartitions, q string, offset, size int) (*EsSearchResponse, error) {
+	return DoSearchPostsVariant(ctx, dir, escli, posts, q, offset, size, nil)
+}
+
+// DoSearchPostsVariant is DoSearchPosts, ranked with a variant from a ranking
+// experiment (or the default ranking, if v is nil)
+func DoSearchPostsVariant(ctx context.Context, dir identity.Directory, escli *es.Client, posts PostPartitions, q string, offset, size int, v *RankingVariant) (*EsSearchResponse, error) {
 	ctx, span := tracer.Start(ctx, "DoSearchPosts")
 	defer span.End()
 
@@ -63,23 +74,62 @@ func DoSearchPosts(ctx context.Context, dir identity.Directory, escli *es.Client
 		return nil, err
 	}
 	queryStr, filters := ParseQuery(ctx, dir, q)
+	return doSearch(ctx, escli, posts.SearchIndex(filters), postsQuery(queryStr, filters, offset, size, v))
+}
+
+func postsQuery(queryStr string, filters []map[string]interface{}, offset, size int, v *RankingVariant) map[string]interface{} {
 	basic := map[string]interface{}{
 		"simple_query_string": map[string]interface{}{
 			"query":            queryStr,
-			"fields":           []string{"everything"},
+			"fields":           v.fields(),
 			"flags":            "AND|NOT|OR|PHRASE|PRECEDENCE|WHITESPACE",
-			"default_operator": "and",
+			"default_operator": v.defaultOperator(),
 			"lenient":          true,
 			"analyze_wildcard": false,
 		},
 	}
-	query := map[string]interface{}{
-		"query": map[string]interface{}{
-			"bool": map[string]interface{}{
-				"must":   basic,
-				"filter": filters,
-			},
-		},
+	boolQuery := map[string]interface{}{
+		"must":   basic,
+		"filter": filters,
+	}
+	if boosts := v.boostClauses(); len(boosts) > 0 {
+		boolQuery["should"] = boosts
+		boolQuery["minimum_should_match"] = 0
+	}
+	var query interface{} = map[string]interface{}{
+		"bool": boolQuery,
+	}
+
+	if v != nil && v.PostSort == PostSortRelevance {
+		if v.RecencyScale != "" {
+			// scale is validated when the experiment is loaded
+			scale, _ := time.ParseDuration(v.RecencyScale)
+			query = map[string]interface{}{
+				"function_score": map[string]interface{}{
+					"query": query,
+					"functions": []interface{}{
+						map[string]interface{}{
+							"gauss": map[string]interface{}{
+								"created_at": map[string]interface{}{
+									"origin": "now",
+									"scale":  fmt.Sprintf("%ds", int64(scale.Seconds())),
+								},
+							},
+						},
+					},
+					"boost_mode": "multiply",
+				},
+			}
+		}
+		return map[string]interface{}{
+			"query": query,
+			"size":  size,
+			"from":  offset,
+		}
+	}
+
+	return map[string]interface{}{
+		"query": query,
 		"sort": map[string]any{
 			"created_at": map[string]any{
 				"order": "desc",
@@ -88,11 +138,15 @@ func DoSearchPosts(ctx context.Context, dir identity.Directory, escli *es.Client
 		"size": size,
 		"from": offset,
 	}
-
-	return doSearch(ctx, escli, index, query)
 }
 
 func DoSearchProfiles(ctx context.Context, dir identity.Directory, escli *es.Client, index, q string, offset, size int) (*EsSearchResponse, error) {
+	return DoSearchProfilesVariant(ctx, dir, escli, index, q, offset, size, nil)
+}
+
+// DoSearchProfilesVariant is DoSearchProfiles, ranked with a variant from a
+// ranking experiment (or the default ranking, if v is nil)
+func DoSearchProfilesVariant(ctx context.Context, dir identity.Directory, escli *es.Client, index, q string, offset, size int, v *RankingVariant) (*EsSearchResponse, error) {
 	ctx, span := tracer.Start(ctx, "DoSearchProfiles")
 	defer span.End()
 
@@ -101,25 +155,32 @@ func DoSearchProfiles(ctx context.Context, dir identity.Directory, escli *es.Cli
 	}
 
 	queryStr, filters := ParseQuery(ctx, dir, q)
+	return doSearch(ctx, escli, index, profilesQuery(queryStr, filters, offset, size, v))
+}
+
+func profilesQuery(queryStr string, filters []map[string]interface{}, offset, size int, v *RankingVariant) map[string]interface{} {
 	basic := map[string]interface{}{
 		"simple_query_string": map[string]interface{}{
 			"query":            queryStr,
-			"fields":           []string{"everything"},
+			"fields":           v.fields(),
 			"flags":            "AND|NOT|OR|PHRASE|PRECEDENCE|WHITESPACE",
-			"default_operator": "and",
+			"default_operator": v.defaultOperator(),
 			"lenient":          true,
 			"analyze_wildcard": false,
 		},
 	}
 
-	query := map[string]interface{}{
+	should := []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"has_avatar": true}},
+		map[string]interface{}{"term": map[string]interface{}{"has_banner": true}},
+	}
+	should = append(should, v.boostClauses()...)
+
+	return map[string]interface{}{
 		"query": map[string]interface{}{
 			"bool": map[string]interface{}{
-				"must": basic,
-				"should": []interface{}{
-					map[string]interface{}{"term": map[string]interface{}{"has_avatar": true}},
-					map[string]interface{}{"term": map[string]interface{}{"has_banner": true}},
-				},
+				"must":                 basic,
+				"should":               should,
 				"minimum_should_match": 0,
 				"filter":               filters,
 				"boost":                0.5,
@@ -128,8 +189,6 @@ func DoSearchProfiles(ctx context.Context, dir identity.Directory, escli *es.Cli
 		"size": size,
 		"from": offset,
 	}
-
-	return doSearch(ctx, escli, index, query)
 }
 
 func DoSearchProfilesTypeahead(ctx context.Context, escli *es.Client, index, q string, size int) (*EsSearchResponse, error) {
@@ -194,12 +253,18 @@ func doSearch(ctx context.Context, escli *es.Client, index string, query interfa
 	}
 	slog.Info("sending query", "index", index, "query", string(b))
 
-	// Perform the search request.
-	res, err := escli.Search(
+	opts := []func(*esapi.SearchRequest){
 		escli.Search.WithContext(ctx),
 		escli.Search.WithIndex(index),
 		escli.Search.WithBody(bytes.NewBuffer(b)),
-	)
+	}
+	if strings.Contains(index, ",") {
+		// a list of post partitions, some of which may not exist (yet)
+		opts = append(opts, escli.Search.WithIgnoreUnavailable(true))
+	}
+
+	// Perform the search request.
+	res, err := escli.Search(opts...)
 	if err != nil {
 		return nil, fmt.Errorf("search query error: %w", err)
 	}
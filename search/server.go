@@ -28,12 +28,14 @@ type Server struct {
 	escli        *es.Client
 	postIndex    string
 	profileIndex string
+	postParts    PostPartitions
 	db           *gorm.DB
 	bgshost      string
 	bgsxrpc      *xrpc.Client
 	dir          identity.Directory
 	echo         *echo.Echo
 	logger       *slog.Logger
+	experiment   *Experiment
 
 	bfs *backfill.Gormstore
 	bf  *backfill.Backfiller
@@ -45,12 +47,16 @@ type LastSeq struct {
 }
 
 type Config struct {
-	BGSHost             string
-	ProfileIndex        string
-	PostIndex           string
-	Logger              *slog.Logger
-	BGSSyncRateLimit    int
-	IndexMaxConcurrency int
+	BGSHost              string
+	ProfileIndex         string
+	PostIndex            string
+	PostPartition        string
+	Logger               *slog.Logger
+	BGSSyncRateLimit     int
+	BGSAdaptiveRateLimit bool
+	IndexMaxConcurrency  int
+	// optional; splits searches between ranking variants
+	Experiment *Experiment
 }
 
 func NewServer(db *gorm.DB, escli *es.Client, dir identity.Directory, config Config) (*Server, error) {
@@ -75,15 +81,22 @@ func NewServer(db *gorm.DB, escli *es.Client, dir identity.Directory, config Con
 		Host: bgshttp,
 	}
 
+	postParts := PostPartitions{Alias: config.PostIndex, By: config.PostPartition}
+	if err := postParts.validate(); err != nil {
+		return nil, err
+	}
+
 	s := &Server{
 		escli:        escli,
 		profileIndex: config.ProfileIndex,
 		postIndex:    config.PostIndex,
+		postParts:    postParts,
 		db:           db,
 		bgshost:      config.BGSHost, // NOTE: the original URL, not 'bgshttp'
 		bgsxrpc:      bgsxrpc,
 		dir:          dir,
 		logger:       logger,
+		experiment:   config.Experiment,
 	}
 
 	bfstore := backfill.NewGormstore(db)
@@ -94,13 +107,14 @@ func NewServer(db *gorm.DB, escli *es.Client, dir identity.Directory, config Con
 	} else {
 		opts.SyncRequestsPerSecond = 8
 	}
+	opts.AdaptiveRateControl = config.BGSAdaptiveRateLimit
 	opts.CheckoutPath = fmt.Sprintf("%s/xrpc/com.atproto.sync.getRepo", bgshttp)
 	if config.IndexMaxConcurrency > 0 {
 		opts.ParallelRecordCreates = config.IndexMaxConcurrency
 	} else {
 		opts.ParallelRecordCreates = 20
 	}
-	opts.NSIDFilter = "app.bsky."
+	opts.Collections = []string{"app.bsky.feed.post", "app.bsky.actor.profile"}
 	bf := backfill.NewBackfiller(
 		"search",
 		bfstore,
@@ -124,13 +138,22 @@ var palomarProfileSchemaJSON string
 
 func (s *Server) EnsureIndices(ctx context.Context) error {
 
-	indices := []struct {
+	type index struct {
 		Name       string
 		SchemaJSON string
-	}{
-		{Name: s.postIndex, SchemaJSON: palomarPostSchemaJSON},
+	}
+	indices := []index{
 		{Name: s.profileIndex, SchemaJSON: palomarProfileSchemaJSON},
 	}
+	if s.postParts.Partitioned() {
+		// partitions are created on demand from a template
+		s.logger.Info("ensuring opensearch post partition template", "alias", s.postIndex, "partition", s.postParts.By)
+		if err := s.postParts.ensurePartitionTemplate(ctx, s.escli, palomarPostSchemaJSON); err != nil {
+			return err
+		}
+	} else {
+		indices = append(indices, index{Name: s.postIndex, SchemaJSON: palomarPostSchemaJSON})
+	}
 	for _, idx := range indices {
 		resp, err := s.escli.Indices.Exists([]string{idx.Name})
 		if err != nil {
@@ -203,6 +226,7 @@ func (s *Server) RunAPI(listen string) error {
 	e.GET("/xrpc/app.bsky.unspecced.searchPostsSkeleton", s.handleSearchPostsSkeleton)
 	e.GET("/xrpc/app.bsky.unspecced.searchActorsSkeleton", s.handleSearchActorsSkeleton)
 	e.GET("/xrpc/app.bsky.unspecced.indexRepos", s.handleIndexRepos)
+	e.GET("/xrpc/app.bsky.unspecced.getTrendingTerms", s.handleTrendingTerms)
 	s.echo = e
 
 	s.logger.Info("starting search API daemon", "bind", listen)
@@ -211,6 +235,7 @@ func (s *Server) RunAPI(listen string) error {
 
 func (s *Server) RunMetrics(listen string) error {
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/backfill/status", s.bf.StatusHandler())
 	return http.ListenAndServe(listen, nil)
 }
 
@@ -0,0 +1,363 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	es "github.com/opensearch-project/opensearch-go/v2"
+)
+
+const (
+	// TrendingFieldTags ranks hashtags (and other post tags)
+	TrendingFieldTags = "tag"
+	// TrendingFieldText ranks words from post text
+	TrendingFieldText = "text"
+)
+
+// DefaultStopwords are left out of trending terms from post text, in addition
+// to any stopwords passed in TrendingParams. Terms are compared after the
+// index's analyzer has lower-cased and folded them.
+var DefaultStopwords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "from",
+	"has", "have", "he", "her", "his", "i", "if", "in", "is", "it", "its",
+	"just", "me", "my", "no", "not", "of", "on", "or", "our", "she", "so",
+	"that", "the", "their", "them", "then", "there", "they", "this", "to",
+	"was", "we", "were", "what", "when", "who", "will", "with", "you", "your",
+	// link fragments
+	"http", "https", "www", "com",
+}
+
+type TrendingParams struct {
+	// Field is TrendingFieldTags or TrendingFieldText. Defaults to tags.
+	Field string
+	// Window is the recent period to find trending terms in. Defaults to 1h.
+	Window time.Duration
+	// Baseline is the longer period which the window is compared against, to
+	// tell trending terms apart from ones which are always common. Defaults
+	// to 24h.
+	Baseline time.Duration
+	// Lang restricts posts to a single language (two-letter code)
+	Lang string
+	// Stopwords are excluded, as well as DefaultStopwords
+	Stopwords []string
+	// Size is the number of terms to return. Defaults to 10.
+	Size int
+	// Buckets is the number of histogram buckets the window is split into,
+	// for computing velocity. Defaults to 6.
+	Buckets int
+	// MinCount is the minimum number of posts in the window for a term to be
+	// included. Defaults to 3.
+	MinCount int
+}
+
+type TrendingTerm struct {
+	Term string `json:"term"`
+	// Count is the number of posts in the window containing the term
+	Count int64 `json:"count"`
+	// BaselineCount is the number of posts in the baseline period
+	BaselineCount int64 `json:"baselineCount"`
+	// Score is the significance of the term in the window, relative to the
+	// baseline. Terms are ranked by score.
+	Score float64 `json:"score"`
+	// Velocity is the change in posts per hour between the first and second
+	// half of the window. Positive for terms which are still picking up.
+	Velocity float64 `json:"velocity"`
+	// Histogram is the post count per bucket over the window, oldest first
+	Histogram []int64 `json:"histogram"`
+}
+
+type TrendingResult struct {
+	Since time.Time      `json:"since"`
+	Until time.Time      `json:"until"`
+	Terms []TrendingTerm `json:"terms"`
+}
+
+func (p *TrendingParams) normalize() error {
+	if p.Field == "" {
+		p.Field = TrendingFieldTags
+	}
+	if p.Field != TrendingFieldTags && p.Field != TrendingFieldText {
+		return fmt.Errorf("unsupported trending field: %s", p.Field)
+	}
+	if p.Window <= 0 {
+		p.Window = time.Hour
+	}
+	if p.Baseline <= 0 {
+		p.Baseline = 24 * time.Hour
+	}
+	if p.Baseline <= p.Window {
+		return fmt.Errorf("trending baseline (%s) must be longer than window (%s)", p.Baseline, p.Window)
+	}
+	if p.Size <= 0 {
+		p.Size = 10
+	}
+	if p.Size > 100 {
+		return fmt.Errorf("disallowed size parameter")
+	}
+	if p.Buckets <= 0 {
+		p.Buckets = 6
+	}
+	if p.MinCount <= 0 {
+		p.MinCount = 3
+	}
+	return nil
+}
+
+func (p *TrendingParams) stopwords() []string {
+	words := []string{}
+	seen := map[string]bool{}
+	for _, list := range [][]string{DefaultStopwords, p.Stopwords} {
+		for _, w := range list {
+			w = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(w), "#"))
+			if w != "" && !seen[w] {
+				seen[w] = true
+				words = append(words, w)
+			}
+		}
+	}
+	return words
+}
+
+func (p *TrendingParams) filters(since time.Time, until time.Time) []map[string]any {
+	filters := []map[string]any{
+		{"range": map[string]any{"created_at": map[string]any{
+			"gte": since.Format(time.RFC3339),
+			"lte": until.Format(time.RFC3339),
+		}}},
+	}
+	if p.Lang != "" {
+		filters = append(filters, map[string]any{"term": map[string]any{"lang_code_iso2": p.Lang}})
+	}
+	return filters
+}
+
+// trendingRankQuery finds the terms which are significantly more common in the
+// window than in the baseline period
+func trendingRankQuery(p *TrendingParams, now time.Time) map[string]any {
+	since := now.Add(-p.Window)
+	sig := map[string]any{
+		"field":         p.Field,
+		"size":          p.Size,
+		"min_doc_count": p.MinCount,
+		"exclude":       p.stopwords(),
+		"background_filter": map[string]any{
+			"bool": map[string]any{"filter": p.filters(now.Add(-p.Baseline), now)},
+		},
+	}
+
+	var aggs map[string]any
+	if p.Field == TrendingFieldText {
+		// significant_text re-analyzes post text, so only look at a sample of
+		// documents; reposted boilerplate would otherwise dominate
+		sig["filter_duplicate_text"] = true
+		aggs = map[string]any{
+			"sample": map[string]any{
+				"sampler": map[string]any{"shard_size": 2000},
+				"aggs": map[string]any{
+					"trending": map[string]any{"significant_text": sig},
+				},
+			},
+		}
+	} else {
+		aggs = map[string]any{
+			"trending": map[string]any{"significant_terms": sig},
+		}
+	}
+
+	return map[string]any{
+		"size": 0,
+		"query": map[string]any{
+			"bool": map[string]any{"filter": p.filters(since, now)},
+		},
+		"aggs": aggs,
+	}
+}
+
+// trendingHistogramQuery counts posts over time, within the window, for each
+// of the given terms
+func trendingHistogramQuery(p *TrendingParams, now time.Time, terms []string) map[string]any {
+	since := now.Add(-p.Window)
+	interval := max(time.Second, p.Window/time.Duration(p.Buckets))
+
+	named := map[string]any{}
+	for _, t := range terms {
+		if p.Field == TrendingFieldText {
+			named[t] = map[string]any{"match": map[string]any{"text": t}}
+		} else {
+			named[t] = map[string]any{"term": map[string]any{"tag": t}}
+		}
+	}
+	bounds := map[string]any{"min": since.UnixMilli(), "max": now.UnixMilli()}
+
+	return map[string]any{
+		"size": 0,
+		"query": map[string]any{
+			"bool": map[string]any{"filter": p.filters(since, now)},
+		},
+		"aggs": map[string]any{
+			"terms": map[string]any{
+				"filters": map[string]any{"filters": named},
+				"aggs": map[string]any{
+					"histogram": map[string]any{
+						"date_histogram": map[string]any{
+							"field":           "created_at",
+							"fixed_interval":  fmt.Sprintf("%ds", int64(interval.Seconds())),
+							"min_doc_count":   0,
+							"offset":          fmt.Sprintf("%dms", since.UnixMilli()%interval.Milliseconds()),
+							"extended_bounds": bounds,
+							"hard_bounds":     bounds,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+type esSignificantTerms struct {
+	Buckets []struct {
+		Key      string  `json:"key"`
+		DocCount int64   `json:"doc_count"`
+		BgCount  int64   `json:"bg_count"`
+		Score    float64 `json:"score"`
+	} `json:"buckets"`
+}
+
+type esTermHistograms struct {
+	Buckets map[string]struct {
+		DocCount  int64 `json:"doc_count"`
+		Histogram struct {
+			Buckets []struct {
+				Key      int64 `json:"key"`
+				DocCount int64 `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"histogram"`
+	} `json:"buckets"`
+}
+
+// parseTrendingRank extracts the ranked terms from a trendingRankQuery response
+func parseTrendingRank(p *TrendingParams, resp *EsSearchResponse) ([]TrendingTerm, error) {
+	raw := resp.Aggregations["trending"]
+	if p.Field == TrendingFieldText {
+		var sample struct {
+			Trending json.RawMessage `json:"trending"`
+		}
+		if err := json.Unmarshal(resp.Aggregations["sample"], &sample); err != nil {
+			return nil, fmt.Errorf("decoding trending sample aggregation: %w", err)
+		}
+		raw = sample.Trending
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("trending aggregation missing from search response")
+	}
+
+	var sig esSignificantTerms
+	if err := json.Unmarshal(raw, &sig); err != nil {
+		return nil, fmt.Errorf("decoding trending aggregation: %w", err)
+	}
+	terms := []TrendingTerm{}
+	for _, b := range sig.Buckets {
+		terms = append(terms, TrendingTerm{
+			Term:          b.Key,
+			Count:         b.DocCount,
+			BaselineCount: b.BgCount,
+			Score:         b.Score,
+		})
+	}
+	sort.SliceStable(terms, func(i, j int) bool { return terms[i].Score > terms[j].Score })
+	return terms, nil
+}
+
+// addTrendingHistograms fills in the histogram and velocity of each term from
+// a trendingHistogramQuery response
+func addTrendingHistograms(p *TrendingParams, resp *EsSearchResponse, terms []TrendingTerm) error {
+	var hists esTermHistograms
+	if err := json.Unmarshal(resp.Aggregations["terms"], &hists); err != nil {
+		return fmt.Errorf("decoding trending histogram aggregation: %w", err)
+	}
+	for i := range terms {
+		b, ok := hists.Buckets[terms[i].Term]
+		if !ok {
+			continue
+		}
+		counts := make([]int64, 0, len(b.Histogram.Buckets))
+		for _, hb := range b.Histogram.Buckets {
+			counts = append(counts, hb.DocCount)
+		}
+		terms[i].Histogram = counts
+		terms[i].Velocity = trendingVelocity(counts, p.Window)
+	}
+	return nil
+}
+
+// trendingVelocity is the difference in posts per hour between the second
+// and first half of the histogram. With an odd number of buckets the middle
+// one is left out.
+func trendingVelocity(counts []int64, window time.Duration) float64 {
+	half := len(counts) / 2
+	if half == 0 {
+		return 0
+	}
+	var earlier, recent int64
+	for i := 0; i < half; i++ {
+		earlier += counts[i]
+		recent += counts[len(counts)-1-i]
+	}
+	hours := window.Hours() * float64(half) / float64(len(counts))
+	return float64(recent-earlier) / hours
+}
+
+// DoTrendingTerms finds the terms (hashtags, or words in post text) which are
+// unusually common in recent posts, compared to a longer baseline period.
+func DoTrendingTerms(ctx context.Context, escli *es.Client, posts PostPartitions, params TrendingParams) (*TrendingResult, error) {
+	ctx, span := tracer.Start(ctx, "DoTrendingTerms")
+	defer span.End()
+
+	if err := params.normalize(); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.String("field", params.Field),
+		attribute.String("window", params.Window.String()),
+		attribute.String("lang", params.Lang),
+	)
+
+	// round to the second, so identical requests hit the search cache
+	now := time.Now().UTC().Truncate(time.Second)
+	// the baseline covers the window, so is all that needs searching
+	index := posts.SearchIndex(params.filters(now.Add(-params.Baseline), now))
+	resp, err := doSearch(ctx, escli, index, trendingRankQuery(&params, now))
+	if err != nil {
+		return nil, err
+	}
+	terms, err := parseTrendingRank(&params, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(terms) > 0 {
+		names := make([]string, len(terms))
+		for i, t := range terms {
+			names[i] = t.Term
+		}
+		resp, err = doSearch(ctx, escli, index, trendingHistogramQuery(&params, now, names))
+		if err != nil {
+			return nil, err
+		}
+		if err := addTrendingHistograms(&params, resp, terms); err != nil {
+			return nil, err
+		}
+	}
+
+	return &TrendingResult{
+		Since: now.Add(-params.Window),
+		Until: now,
+		Terms: terms,
+	}, nil
+}
@@ -0,0 +1,86 @@
+package search
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrendingRankQuery(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	p := TrendingParams{Lang: "en", Stopwords: []string{"#Bluesky", " the "}}
+	assert.NoError(p.normalize())
+	q := trendingRankQuery(&p, now)
+
+	sig := q["aggs"].(map[string]any)["trending"].(map[string]any)["significant_terms"].(map[string]any)
+	assert.Equal("tag", sig["field"])
+	exclude := sig["exclude"].([]string)
+	assert.Contains(exclude, "bluesky")
+	assert.Equal(len(DefaultStopwords)+1, len(exclude))
+
+	filters := q["query"].(map[string]any)["bool"].(map[string]any)["filter"].([]map[string]any)
+	assert.Equal("2024-03-01T11:00:00Z", filters[0]["range"].(map[string]any)["created_at"].(map[string]any)["gte"])
+	assert.Equal(map[string]any{"term": map[string]any{"lang_code_iso2": "en"}}, filters[1])
+
+	// text is sampled
+	p = TrendingParams{Field: TrendingFieldText}
+	assert.NoError(p.normalize())
+	q = trendingRankQuery(&p, now)
+	sample := q["aggs"].(map[string]any)["sample"].(map[string]any)
+	assert.Contains(sample["aggs"].(map[string]any)["trending"], "significant_text")
+
+	p = TrendingParams{Field: "emoji"}
+	assert.Error(p.normalize())
+	p = TrendingParams{Window: 2 * time.Hour, Baseline: time.Hour}
+	assert.Error(p.normalize())
+}
+
+func TestTrendingParseResponses(t *testing.T) {
+	assert := assert.New(t)
+	p := TrendingParams{}
+	assert.NoError(p.normalize())
+
+	var rank EsSearchResponse
+	assert.NoError(json.Unmarshal([]byte(`{
+		"took": 3,
+		"hits": {"hits": []},
+		"aggregations": {"trending": {"doc_count": 500, "bg_count": 12000, "buckets": [
+			{"key": "eclipse", "doc_count": 40, "bg_count": 45, "score": 0.8},
+			{"key": "caturday", "doc_count": 25, "bg_count": 60, "score": 1.1}
+		]}}
+	}`), &rank))
+	terms, err := parseTrendingRank(&p, &rank)
+	assert.NoError(err)
+	assert.Equal(2, len(terms))
+	assert.Equal("caturday", terms[0].Term)
+	assert.Equal(int64(60), terms[0].BaselineCount)
+
+	var hist EsSearchResponse
+	assert.NoError(json.Unmarshal([]byte(`{
+		"aggregations": {"terms": {"buckets": {
+			"eclipse": {"doc_count": 40, "histogram": {"buckets": [
+				{"key": 1, "doc_count": 1}, {"key": 2, "doc_count": 2}, {"key": 3, "doc_count": 3},
+				{"key": 4, "doc_count": 6}, {"key": 5, "doc_count": 12}, {"key": 6, "doc_count": 16}
+			]}}
+		}}}
+	}`), &hist))
+	assert.NoError(addTrendingHistograms(&p, &hist, terms))
+	assert.Nil(terms[0].Histogram)
+	assert.Equal([]int64{1, 2, 3, 6, 12, 16}, terms[1].Histogram)
+	// 28 more posts over half an hour
+	assert.InDelta(56.0, terms[1].Velocity, 0.001)
+}
+
+func TestTrendingVelocity(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(0.0, trendingVelocity(nil, time.Hour))
+	assert.Equal(0.0, trendingVelocity([]int64{5}, time.Hour))
+	assert.InDelta(-8.0, trendingVelocity([]int64{5, 1}, time.Hour), 0.001)
+	// middle bucket is ignored
+	assert.InDelta(3.0, trendingVelocity([]int64{0, 100, 1}, time.Hour), 0.001)
+}
@@ -72,3 +72,18 @@ var lastRecordCreatedEvtProcessedGapGauge = promauto.NewGaugeVec(prometheus.Gaug
 	Name: "sonar_last_record_created_evt_processed_gap",
 	Help: "The gap between the last record's record timestamp and when it was processed by sonar",
 }, []string{"socket_url"})
+
+var collectionRateGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sonar_collection_rate",
+	Help: "Ops per second for each collection, over the last rate window",
+}, []string{"collection", "socket_url"})
+
+var pdsRateGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "sonar_pds_rate",
+	Help: "Commits per second from each PDS host, over the last rate window",
+}, []string{"pds_host", "socket_url"})
+
+var rateAlertsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "sonar_rate_alerts_total",
+	Help: "The total number of rate alerts raised by Sonar",
+}, []string{"kind", "socket_url"})
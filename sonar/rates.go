@@ -0,0 +1,294 @@
+package sonar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/util"
+	"github.com/goccy/go-json"
+	"go.uber.org/zap"
+)
+
+// Rate keys are either a collection NSID, or a PDS host prefixed with "pds:"
+const pdsKeyPrefix = "pds:"
+
+// AlertRule configures when rate alerts fire for matching keys
+type AlertRule struct {
+	// Match is a collection NSID, "pds:<host>" for a single PDS, "pds:*"
+	// for every PDS, or "*" for every collection
+	Match string `json:"match"`
+	// SpikeFactor alerts when the rate over a window is more than this many
+	// times the baseline; 0 disables spike alerts
+	SpikeFactor float64 `json:"spike_factor"`
+	// AlertOnZero alerts when the rate drops to zero
+	AlertOnZero bool `json:"alert_on_zero"`
+	// MinBaseline is the baseline rate (per second) below which no alerts
+	// are raised, since low-traffic keys are too noisy to alert on
+	MinBaseline float64 `json:"min_baseline"`
+}
+
+// DefaultAlertRules alert on any collection spiking 5x or stopping
+// entirely, once it averages at least one event per second
+func DefaultAlertRules() []AlertRule {
+	return []AlertRule{
+		{Match: "*", SpikeFactor: 5, AlertOnZero: true, MinBaseline: 1},
+	}
+}
+
+// LoadAlertRules reads a JSON list of AlertRule from a file
+func LoadAlertRules(path string) ([]AlertRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []AlertRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("parsing alert rules: %w", err)
+	}
+	return rules, nil
+}
+
+const (
+	AlertSpike     = "spike"
+	AlertZero      = "zero"
+	AlertRecovered = "recovered"
+)
+
+type Alert struct {
+	Kind     string    `json:"kind"`
+	Key      string    `json:"key"`
+	Rate     float64   `json:"rate"`
+	Baseline float64   `json:"baseline"`
+	At       time.Time `json:"at"`
+	Source   string    `json:"source"`
+}
+
+func (a *Alert) String() string {
+	switch a.Kind {
+	case AlertSpike:
+		return fmt.Sprintf("%s: %s rate spiked to %.2f/s (baseline %.2f/s)", a.Source, a.Key, a.Rate, a.Baseline)
+	case AlertZero:
+		return fmt.Sprintf("%s: %s rate dropped to zero (baseline %.2f/s)", a.Source, a.Key, a.Baseline)
+	default:
+		return fmt.Sprintf("%s: %s rate back to normal at %.2f/s (baseline %.2f/s)", a.Source, a.Key, a.Rate, a.Baseline)
+	}
+}
+
+// Alerter delivers rate alerts
+type Alerter interface {
+	Alert(ctx context.Context, a *Alert) error
+}
+
+// LogAlerter writes alerts to the log
+type LogAlerter struct {
+	Logger *zap.SugaredLogger
+}
+
+func (la *LogAlerter) Alert(ctx context.Context, a *Alert) error {
+	la.Logger.Warnw("rate alert", "kind", a.Kind, "key", a.Key, "rate", a.Rate, "baseline", a.Baseline)
+	return nil
+}
+
+// WebhookAlerter POSTs alerts as JSON. The body has a "text" field, so
+// Slack incoming webhooks can be used directly, as well as the alert fields.
+type WebhookAlerter struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookAlerter(url string) *WebhookAlerter {
+	return &WebhookAlerter{URL: url, Client: util.SafeHTTPClient()}
+}
+
+func (wa *WebhookAlerter) Alert(ctx context.Context, a *Alert) error {
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+		*Alert
+	}{a.String(), a})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wa.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := wa.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("alert webhook failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// RateMonitor counts events per key over fixed windows, tracks a moving
+// baseline rate for each key, and raises alerts when a window's rate is
+// far from the baseline.
+type RateMonitor struct {
+	Window   time.Duration
+	Rules    []AlertRule
+	Alerters []Alerter
+	// Source labels alerts and metrics, usually the firehose URL
+	Source string
+	Logger *zap.SugaredLogger
+
+	lk       sync.Mutex
+	counts   map[string]int64
+	baseline map[string]float64
+	alerting map[string]string
+}
+
+// weight of the latest window in the baseline moving average
+const baselineAlpha = 0.1
+
+func NewRateMonitor(logger *zap.SugaredLogger, source string, window time.Duration, rules []AlertRule, alerters ...Alerter) *RateMonitor {
+	return &RateMonitor{
+		Window:   window,
+		Rules:    rules,
+		Alerters: alerters,
+		Source:   source,
+		Logger:   logger,
+		counts:   make(map[string]int64),
+		baseline: make(map[string]float64),
+		alerting: make(map[string]string),
+	}
+}
+
+// ObserveCollection counts an event for a collection
+func (rm *RateMonitor) ObserveCollection(collection string) {
+	rm.observe(collection)
+}
+
+// ObservePDS counts an event from a PDS host
+func (rm *RateMonitor) ObservePDS(host string) {
+	rm.observe(pdsKeyPrefix + host)
+}
+
+func (rm *RateMonitor) observe(key string) {
+	rm.lk.Lock()
+	rm.counts[key]++
+	rm.lk.Unlock()
+}
+
+// Run evaluates rates every Window until ctx is cancelled
+func (rm *RateMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(rm.Window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, a := range rm.Evaluate(time.Now()) {
+				rateAlertsCounter.WithLabelValues(a.Kind, rm.Source).Inc()
+				for _, al := range rm.Alerters {
+					if err := al.Alert(ctx, a); err != nil {
+						rm.Logger.Errorf("failed to send rate alert: %+v", err)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Evaluate closes the current window, updating rate gauges and baselines,
+// and returns any alerts which should be raised
+func (rm *RateMonitor) Evaluate(now time.Time) []*Alert {
+	rm.lk.Lock()
+	counts := rm.counts
+	rm.counts = make(map[string]int64, len(counts))
+	rm.lk.Unlock()
+
+	// keys without events this window still need checking for drops
+	keys := make(map[string]bool, len(counts))
+	for k := range counts {
+		keys[k] = true
+	}
+	for k := range rm.baseline {
+		keys[k] = true
+	}
+
+	var alerts []*Alert
+	for k := range keys {
+		rate := float64(counts[k]) / rm.Window.Seconds()
+		base, seen := rm.baseline[k]
+
+		if isPDSKey(k) {
+			pdsRateGauge.WithLabelValues(k[len(pdsKeyPrefix):], rm.Source).Set(rate)
+		} else {
+			collectionRateGauge.WithLabelValues(k, rm.Source).Set(rate)
+		}
+
+		if seen {
+			if a := rm.check(k, rate, base, now); a != nil {
+				alerts = append(alerts, a)
+			}
+			base = baselineAlpha*rate + (1-baselineAlpha)*base
+		} else {
+			base = rate
+		}
+
+		// forget keys which have gone quiet, once any alert is resolved
+		if base < 1e-3 && rm.alerting[k] == "" {
+			delete(rm.baseline, k)
+			continue
+		}
+		rm.baseline[k] = base
+	}
+	return alerts
+}
+
+func (rm *RateMonitor) check(key string, rate, base float64, now time.Time) *Alert {
+	rule := rm.ruleFor(key)
+	if rule == nil {
+		return nil
+	}
+
+	state := ""
+	switch {
+	case base < rule.MinBaseline:
+	case rule.AlertOnZero && rate == 0:
+		state = AlertZero
+	case rule.SpikeFactor > 0 && rate > base*rule.SpikeFactor:
+		state = AlertSpike
+	}
+
+	prev := rm.alerting[key]
+	if state == prev {
+		return nil
+	}
+	if state == "" {
+		delete(rm.alerting, key)
+		state = AlertRecovered
+	} else {
+		rm.alerting[key] = state
+	}
+	return &Alert{Kind: state, Key: key, Rate: rate, Baseline: base, At: now, Source: rm.Source}
+}
+
+// ruleFor picks the most specific rule matching a key
+func (rm *RateMonitor) ruleFor(key string) *AlertRule {
+	var wildcard *AlertRule
+	for i := range rm.Rules {
+		r := &rm.Rules[i]
+		switch {
+		case r.Match == key:
+			return r
+		case r.Match == "*" && !isPDSKey(key), r.Match == pdsKeyPrefix+"*" && isPDSKey(key):
+			wildcard = r
+		}
+	}
+	return wildcard
+}
+
+func isPDSKey(key string) bool {
+	return len(key) > len(pdsKeyPrefix) && key[:len(pdsKeyPrefix)] == pdsKeyPrefix
+}
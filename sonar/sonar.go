@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net/url"
 	"os"
 	"strings"
 	"sync"
@@ -12,6 +13,8 @@ import (
 	"github.com/araddon/dateparse"
 	comatproto "github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	lexutil "github.com/bluesky-social/indigo/lex/util"
 	"github.com/goccy/go-json"
 	"github.com/labstack/gommon/log"
@@ -29,6 +32,12 @@ type Sonar struct {
 	ProgMux    sync.Mutex
 	Logger     *zap.SugaredLogger
 	CursorFile string
+
+	// Rates, if set, tracks per-collection (and per-PDS, if Directory is
+	// also set) event rates
+	Rates *RateMonitor
+	// Directory resolves the PDS host of each repo, for per-PDS rates
+	Directory identity.Directory
 }
 
 type Progress struct {
@@ -195,8 +204,17 @@ func (s *Sonar) HandleRepoCommit(ctx context.Context, evt *comatproto.SyncSubscr
 	lastEvtProcessedAtGauge.WithLabelValues(s.SocketURL).Set(float64(processedAt.UnixNano()))
 	lastEvtCreatedEvtProcessedGapGauge.WithLabelValues(s.SocketURL).Set(float64(processedAt.Sub(evtCreatedAt).Seconds()))
 
+	if s.Rates != nil && s.Directory != nil {
+		if host := s.pdsHost(ctx, evt.Repo); host != "" {
+			s.Rates.ObservePDS(host)
+		}
+	}
+
 	for _, op := range evt.Ops {
 		collection := strings.Split(op.Path, "/")[0]
+		if s.Rates != nil {
+			s.Rates.ObserveCollection(collection)
+		}
 
 		ek := repomgr.EventKind(op.Action)
 		log = log.With("action", op.Action, "collection", collection)
@@ -276,3 +294,24 @@ func (s *Sonar) HandleRepoCommit(ctx context.Context, evt *comatproto.SyncSubscr
 	eventProcessingDurationHistogram.WithLabelValues(s.SocketURL).Observe(time.Since(processedAt).Seconds())
 	return nil
 }
+
+// pdsHost returns the hostname of a repo's PDS, or "" if it can't be
+// resolved
+func (s *Sonar) pdsHost(ctx context.Context, repo string) string {
+	did, err := syntax.ParseDID(repo)
+	if err != nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	ident, err := s.Directory.LookupDID(ctx, did)
+	if err != nil {
+		s.Logger.Debugf("failed to resolve PDS for %s: %+v", repo, err)
+		return ""
+	}
+	u, err := url.Parse(ident.PDSEndpoint())
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Host
+}
@@ -146,3 +146,38 @@ func TestPostToJson(t *testing.T) {
 
 	fmt.Println(string(outb))
 }
+
+func TestFeedPostUnknownEmbed(t *testing.T) {
+	assert := assert.New(t)
+
+	jsonStr := `{"$type":"app.bsky.feed.post","createdAt":"2023-03-29T20:59:19.417Z","embed":{"$type":"app.example.embed.future","value":123},"text":"hello"}`
+
+	var fp appbsky.FeedPost
+	assert.NoError(json.Unmarshal([]byte(jsonStr), &fp))
+	assert.NotNil(fp.Embed.Unknown)
+	assert.Equal("app.example.embed.future", fp.Embed.Unknown.Type)
+
+	out, err := json.Marshal(&fp)
+	assert.NoError(err)
+	assert.Equal(jsonStr, string(out))
+
+	// CBOR round-trip preserves the unknown variant bytes
+	var unknownEmbed lexutil.CborChecker
+	unknownEmbed.Type = "app.example.embed.future"
+	unknownBuf := new(bytes.Buffer)
+	assert.NoError(unknownEmbed.MarshalCBOR(unknownBuf))
+
+	fp.Embed.Unknown = &lexutil.UnknownUnionVariant{Type: unknownEmbed.Type, CBOR: unknownBuf.Bytes()}
+	cborBuf := new(bytes.Buffer)
+	assert.NoError(fp.MarshalCBOR(cborBuf))
+
+	var fpAgain appbsky.FeedPost
+	assert.NoError(fpAgain.UnmarshalCBOR(bytes.NewReader(cborBuf.Bytes())))
+	assert.NotNil(fpAgain.Embed.Unknown)
+	assert.Equal("app.example.embed.future", fpAgain.Embed.Unknown.Type)
+	assert.Equal(unknownBuf.Bytes(), fpAgain.Embed.Unknown.CBOR)
+
+	// can't re-encode JSON-decoded data as CBOR
+	fp.Embed.Unknown = &lexutil.UnknownUnionVariant{Type: unknownEmbed.Type, JSON: []byte(`{}`)}
+	assert.Error(fp.MarshalCBOR(new(bytes.Buffer)))
+}
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	atproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/xrpc"
 	"github.com/ipfs/go-cid"
@@ -162,13 +163,19 @@ func TestBGSMultiPDS(t *testing.T) {
 
 	time.Sleep(time.Second)
 
+	evts := b1.Events(t, -1)
+	defer evts.Cancel()
+
 	p2.RequestScraping(t, b1)
 	time.Sleep(time.Millisecond * 50)
 
 	// Now, the bgs will discover a gap, and have to catch up somehow
-	socialSim(t, users2, 1, 0)
+	last := socialSim(t, users2, 1, 0)
 
-	time.Sleep(time.Second)
+	// pds 2 plays back its history from the bgs's cursor before this post,
+	// and the bgs only emits a commit once it has indexed it, so once the
+	// post's commit comes out, its author's earlier posts are indexed too
+	waitForCommit(t, evts, users2[4].DID(), last[len(last)-1].Uri)
 
 	// we expect the bgs to learn about posts that it didnt directly see from
 	// repos its already partially scraped, as long as its seen *something* after the missing post
@@ -180,6 +187,38 @@ func TestBGSMultiPDS(t *testing.T) {
 	}
 }
 
+// waitForCommit waits for the commit creating a record to come out of a
+// firehose
+func waitForCommit(t *testing.T, es *EventStream, did, uri string) {
+	t.Helper()
+	aturi, err := syntax.ParseATURI(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := aturi.Collection().String() + "/" + aturi.RecordKey().String()
+
+	found := make(chan struct{})
+	go func() {
+		for {
+			evt := es.Next()
+			if evt.RepoCommit == nil || evt.RepoCommit.Repo != did {
+				continue
+			}
+			for _, op := range evt.RepoCommit.Ops {
+				if op.Path == path {
+					close(found)
+					return
+				}
+			}
+		}
+	}()
+	select {
+	case <-found:
+	case <-time.After(30 * time.Second):
+		t.Fatalf("timed out waiting for commit of %s", uri)
+	}
+}
+
 func TestBGSMultiGap(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping BGS test in 'short' test mode")
@@ -0,0 +1,242 @@
+package testing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	atproto "github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/plc"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Network is a complete atproto network running in-process, for end-to-end
+// tests: a fake DID directory, one or more PDS instances, and a relay
+// subscribed to all of them. Nothing outside the test process is needed.
+//
+// Accounts created with NewAccount are also added to Directory, so code
+// under test which resolves identities with atproto/identity can be pointed
+// at it.
+type Network struct {
+	PLC       *plc.FakeDid
+	PDS       []*TestPDS
+	Relay     *TestBGS
+	Directory identity.Directory
+
+	dir    *networkDirectory
+	plcDir string
+}
+
+type NetworkConfig struct {
+	// PDSCount is the number of PDS instances to run. Each gets its own
+	// handle suffix: ".pds0", ".pds1", etc. Defaults to 1.
+	PDSCount int
+}
+
+// MustSetupNetwork starts a Network, failing the test if it can't, and tears
+// it down when the test finishes.
+func MustSetupNetwork(t *testing.T, cfg NetworkConfig) *Network {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	n, err := SetupNetwork(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Cleanup)
+
+	return n
+}
+
+// SetupNetwork starts a Network. Call Cleanup when done with it.
+func SetupNetwork(ctx context.Context, cfg NetworkConfig) (*Network, error) {
+	fd, plcDir, err := setupPLC()
+	if err != nil {
+		return nil, err
+	}
+	dir := &networkDirectory{inner: identity.NewMockDirectory()}
+	n := &Network{
+		PLC:       fd,
+		Directory: dir,
+		dir:       dir,
+		plcDir:    plcDir,
+	}
+
+	count := max(1, cfg.PDSCount)
+	for i := 0; i < count; i++ {
+		p, err := SetupPDS(ctx, fmt.Sprintf(".pds%d", i), fd)
+		if err != nil {
+			n.Cleanup()
+			return nil, err
+		}
+		p.start()
+		n.PDS = append(n.PDS, p)
+	}
+
+	relay, err := SetupBGS(ctx, fd)
+	if err != nil {
+		n.Cleanup()
+		return nil, err
+	}
+	relay.start()
+	n.Relay = relay
+
+	for _, p := range n.PDS {
+		relay.tr.TrialHosts = append(relay.tr.TrialHosts, p.RawHost())
+		if err := atproto.SyncRequestCrawl(ctx, n.RelayClient(), &atproto.SyncRequestCrawl_Input{Hostname: p.RawHost()}); err != nil {
+			n.Cleanup()
+			return nil, fmt.Errorf("relay crawl request for %s: %w", p.RawHost(), err)
+		}
+	}
+
+	return n, nil
+}
+
+func (n *Network) Cleanup() {
+	if n.Relay != nil {
+		n.Relay.Cleanup()
+	}
+	for _, p := range n.PDS {
+		p.Cleanup()
+	}
+	if n.plcDir != "" {
+		_ = os.RemoveAll(n.plcDir)
+	}
+}
+
+// RelayClient returns an unauthenticated XRPC client for the relay
+func (n *Network) RelayClient() *xrpc.Client {
+	return &xrpc.Client{Host: "http://" + n.Relay.Host()}
+}
+
+// NewAccount creates an account with the handle name+".pdsN" on the Nth
+// PDS, and registers it in the network's Directory.
+func (n *Network) NewAccount(ctx context.Context, pds int, name string) (*TestUser, error) {
+	if pds < 0 || pds >= len(n.PDS) {
+		return nil, fmt.Errorf("no such PDS: %d", pds)
+	}
+	p := n.PDS[pds]
+
+	u, err := p.NewUser(name + fmt.Sprintf(".pds%d", pds))
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := n.PLC.GetDocument(ctx, u.DID())
+	if err != nil {
+		return nil, err
+	}
+	did, err := syntax.ParseDID(u.DID())
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syntax.ParseHandle(u.Handle())
+	if err != nil {
+		return nil, err
+	}
+	ident := identity.Identity{
+		DID:         did,
+		Handle:      handle,
+		AlsoKnownAs: []string{"at://" + handle.String()},
+		Services: map[string]identity.Service{
+			"atproto_pds": {
+				Type: "AtprotoPersonalDataServer",
+				URL:  p.HTTPHost(),
+			},
+		},
+		Keys: map[string]identity.Key{},
+	}
+	for i := range doc.VerificationMethod {
+		if vm := &doc.VerificationMethod[i]; vm.PublicKeyMultibase != nil {
+			ident.Keys["atproto"] = identity.Key{
+				Type:               "Multikey",
+				PublicKeyMultibase: *vm.PublicKeyMultibase,
+			}
+			break
+		}
+	}
+	n.dir.Insert(ident)
+
+	return u, nil
+}
+
+func (n *Network) MustNewAccount(t *testing.T, pds int, name string) *TestUser {
+	t.Helper()
+
+	u, err := n.NewAccount(context.TODO(), pds, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+// WaitForRelay blocks until the relay has caught up with the user's latest
+// commit on their PDS, or the context is done.
+func (n *Network) WaitForRelay(ctx context.Context, u *TestUser) error {
+	head, err := atproto.SyncGetHead(ctx, u.client, u.DID())
+	if err != nil {
+		return err
+	}
+
+	// polling, so don't use the default client's retries with backoff
+	relay := n.RelayClient()
+	relay.Client = &http.Client{Timeout: 5 * time.Second}
+	for {
+		got, err := atproto.SyncGetLatestCommit(ctx, relay, u.DID())
+		if err == nil && got.Cid == head.Root {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return fmt.Errorf("waiting for relay to sync %s: %w", u.DID(), err)
+			}
+			return fmt.Errorf("waiting for relay to sync %s: at commit %s, expected %s", u.DID(), got.Cid, head.Root)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// networkDirectory is a MockDirectory which is safe to add accounts to while
+// code under test is using it
+type networkDirectory struct {
+	lk    sync.RWMutex
+	inner identity.MockDirectory
+}
+
+var _ identity.Directory = (*networkDirectory)(nil)
+
+func (d *networkDirectory) Insert(ident identity.Identity) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.inner.Insert(ident)
+}
+
+func (d *networkDirectory) LookupHandle(ctx context.Context, h syntax.Handle) (*identity.Identity, error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	return d.inner.LookupHandle(ctx, h)
+}
+
+func (d *networkDirectory) LookupDID(ctx context.Context, did syntax.DID) (*identity.Identity, error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	return d.inner.LookupDID(ctx, did)
+}
+
+func (d *networkDirectory) Lookup(ctx context.Context, a syntax.AtIdentifier) (*identity.Identity, error) {
+	d.lk.RLock()
+	defer d.lk.RUnlock()
+	return d.inner.Lookup(ctx, a)
+}
+
+func (d *networkDirectory) Purge(ctx context.Context, a syntax.AtIdentifier) error {
+	return nil
+}
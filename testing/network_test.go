@@ -0,0 +1,49 @@
+package testing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetwork(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping network test in 'short' test mode")
+	}
+	assert := assert.New(t)
+	n := MustSetupNetwork(t, NetworkConfig{PDSCount: 2})
+
+	alice := n.MustNewAccount(t, 0, "alice")
+	bob := n.MustNewAccount(t, 1, "bob")
+	assert.Equal("alice.pds0", alice.Handle())
+
+	// accounts resolve through the network's directory
+	ctx := context.Background()
+	ident, err := n.Directory.LookupHandle(ctx, syntax.Handle("bob.pds1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(bob.DID(), ident.DID.String())
+	assert.Equal(n.PDS[1].HTTPHost(), ident.PDSEndpoint())
+	_, err = ident.PublicKey()
+	assert.NoError(err)
+
+	// and the relay picks up records from both PDS instances
+	post := alice.Post(t, "hello from pds0")
+	bob.Like(t, post)
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := n.WaitForRelay(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.WaitForRelay(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+	_, err = n.Relay.bgs.Index.GetPost(ctx, post.Uri)
+	assert.NoError(err)
+}
@@ -78,7 +78,7 @@ func TestPDSFakedata(t *testing.T) {
 		// TODO: golang PDS does not support putRecord
 		//assert.NoError(fakedata.GenProfile(xrpcc, &acc, genAvatar, genBanner))
 		assert.NoError(fakedata.GenFollowsAndMutes(xrpcc, &catalog, &acc, maxFollows, maxMutes))
-		assert.NoError(fakedata.GenPosts(xrpcc, &catalog, &acc, maxPosts, fracImage, fracMention))
+		assert.NoError(fakedata.GenPosts(xrpcc, &catalog, &acc, maxPosts, fracImage, 0, fracMention, 0))
 	}
 
 	// generate interactions (additional posts, etc)
@@ -89,7 +89,7 @@ func TestPDSFakedata(t *testing.T) {
 			t.Fatal(err)
 		}
 		assert.NoError(fakedata.GenFollowsAndMutes(xrpcc, &catalog, &acc, maxFollows, maxMutes))
-		assert.NoError(fakedata.GenPosts(xrpcc, &catalog, &acc, maxPosts, fracImage, fracMention))
+		assert.NoError(fakedata.GenPosts(xrpcc, &catalog, &acc, maxPosts, fracImage, 0, fracMention, 0))
 	}
 
 	// do browsing (read-only)
@@ -149,6 +149,10 @@ func SetupPDS(ctx context.Context, suffix string, plc plc.PLCClient) (*TestPDS,
 }
 
 func (tp *TestPDS) Run(t *testing.T) {
+	tp.start()
+}
+
+func (tp *TestPDS) start() {
 	// TODO: rig this up so it t.Fatals if the RunAPI call fails immediately
 	go func() {
 		if err := tp.server.RunAPIWithListener(tp.listener); err != nil {
@@ -249,6 +253,16 @@ func (u *TestUser) DID() string {
 	return u.did
 }
 
+func (u *TestUser) Handle() string {
+	return u.handle
+}
+
+// Client returns an XRPC client for the user's PDS, authenticated as the
+// user
+func (u *TestUser) Client() *xrpc.Client {
+	return u.client
+}
+
 func (u *TestUser) Post(t *testing.T, body string) *atproto.RepoStrongRef {
 	t.Helper()
 
@@ -359,23 +373,34 @@ func (u *TestUser) DoRebase(t *testing.T) {
 }
 
 func TestPLC(t *testing.T) *plc.FakeDid {
+	fd, _, err := setupPLC()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd
+}
+
+// setupPLC returns a fake DID directory, and the temporary directory it
+// stores its state in
+func setupPLC() (*plc.FakeDid, string, error) {
 	// TODO: just do in memory...
 	tdir, err := os.MkdirTemp("", "plcserv")
 	if err != nil {
-		t.Fatal(err)
+		return nil, "", err
 	}
 
 	db, err := gorm.Open(sqlite.Open(filepath.Join(tdir, "plc.sqlite")))
 	if err != nil {
-		t.Fatal(err)
+		return nil, "", err
 	}
-	return plc.NewFakeDid(db)
+	return plc.NewFakeDid(db), tdir, nil
 }
 
 type TestBGS struct {
 	bgs *bgs.BGS
 	tr  *api.TestHandleResolver
 	db  *gorm.DB
+	dir string
 
 	// listener is owned by by the BGS structure and should be closed by
 	// shutting down the BGS.
@@ -461,6 +486,7 @@ func SetupBGS(ctx context.Context, didr plc.PLCClient) (*TestBGS, error) {
 	}
 
 	return &TestBGS{
+		dir:      dir,
 		db:       maindb,
 		bgs:      b,
 		tr:       tr,
@@ -469,6 +495,10 @@ func SetupBGS(ctx context.Context, didr plc.PLCClient) (*TestBGS, error) {
 }
 
 func (b *TestBGS) Run(t *testing.T) {
+	b.start()
+}
+
+func (b *TestBGS) start() {
 	go func() {
 		if err := b.bgs.StartWithListener(b.listener); err != nil {
 			fmt.Println(err)
@@ -477,6 +507,14 @@ func (b *TestBGS) Run(t *testing.T) {
 	time.Sleep(time.Millisecond * 10)
 }
 
+func (b *TestBGS) Cleanup() {
+	b.bgs.Shutdown()
+	_ = b.listener.Close()
+	if b.dir != "" {
+		_ = os.RemoveAll(b.dir)
+	}
+}
+
 func (b *TestBGS) BanDomain(t *testing.T, d string) {
 	t.Helper()
 
@@ -0,0 +1,180 @@
+package cliutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/urfave/cli/v2"
+)
+
+// CliConfig is the config file shared by indigo's command-line tools.
+//
+// Settings are applied with the precedence: command-line flag, then
+// environment variable, then the tool's section of the config file, then the
+// config file's defaults, then the flag's built-in default.
+type CliConfig struct {
+	filename string
+	PDS      string
+
+	// name of the profile used when --profile isn't given
+	CurrentProfile string              `json:",omitempty"`
+	Profiles       map[string]*Profile `json:",omitempty"`
+
+	// CredentialStore is where new credentials are saved ("keyring" or
+	// "file"); by default, the OS keychain if one is available
+	CredentialStore string `json:",omitempty"`
+
+	// Defaults are flag values (by flag name) for every tool, and Apps are
+	// flag values for a single tool (by app name, then flag name)
+	Defaults map[string]string            `json:",omitempty"`
+	Apps     map[string]map[string]string `json:",omitempty"`
+}
+
+// ConfigPath is the shared config file: $INDIGO_CONFIG if set, and otherwise
+// indigo/config.json in the user config directory ($XDG_CONFIG_HOME, or
+// ~/.config, on Linux).
+func ConfigPath() (string, error) {
+	if p := os.Getenv("INDIGO_CONFIG"); p != "" {
+		return p, nil
+	}
+	d, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find user config directory: %w", err)
+	}
+	return filepath.Join(d, "indigo", "config.json"), nil
+}
+
+// legacyConfigPath is where gosky kept its config before it was shared
+func legacyConfigPath() (string, error) {
+	d, err := homedir.Dir()
+	if err != nil {
+		return "", fmt.Errorf("cannot read Home directory")
+	}
+
+	return filepath.Join(d, ".gosky"), nil
+}
+
+// readConfig reads the config file, falling back to the legacy ~/.gosky file
+// (which is migrated the next time the config is written). Returns nil if
+// there is no config file.
+func readConfig() (*CliConfig, error) {
+	f, err := ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(f)
+	if os.IsNotExist(err) && os.Getenv("INDIGO_CONFIG") == "" {
+		legacy, lerr := legacyConfigPath()
+		if lerr != nil {
+			return nil, nil
+		}
+		b, err = os.ReadFile(legacy)
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var out CliConfig
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("parsing config file: %w", err)
+	}
+
+	out.filename = f
+	return &out, nil
+}
+
+// LoadConfig reads the config file, returning an empty config (which
+// WriteConfig will create) if there isn't one yet.
+func LoadConfig() (*CliConfig, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg != nil {
+		return cfg, nil
+	}
+
+	f, err := ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	return &CliConfig{filename: f}, nil
+}
+
+var Config *CliConfig
+
+func TryReadConfig() {
+	cfg, err := readConfig()
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		Config = cfg
+	}
+}
+
+func WriteConfig(cfg *CliConfig) error {
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cfg.filename), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(cfg.filename, b, 0600)
+}
+
+// Setting returns the config file's value for a flag of the named app, if
+// there is one
+func (cfg *CliConfig) Setting(app, flag string) (string, bool) {
+	if v, ok := cfg.Apps[app][flag]; ok {
+		return v, true
+	}
+	v, ok := cfg.Defaults[flag]
+	return v, ok
+}
+
+// ApplyConfig sets any flags which weren't given on the command line or in
+// the environment from the config file. Use it as (or from) an app or
+// command Before hook; it applies to the flags of the command being run.
+func ApplyConfig(app string) cli.BeforeFunc {
+	return func(cctx *cli.Context) error {
+		cfg, err := readConfig()
+		if err != nil {
+			return err
+		}
+		if cfg == nil {
+			return nil
+		}
+		return cfg.Apply(cctx, app)
+	}
+}
+
+// Apply sets the context's unset flags from the config file
+func (cfg *CliConfig) Apply(cctx *cli.Context, app string) error {
+	flags := cctx.App.Flags
+	if cctx.Command != nil && len(cctx.Command.Flags) > 0 {
+		flags = cctx.Command.Flags
+	}
+	for _, f := range flags {
+		names := f.Names()
+		if len(names) == 0 || cctx.IsSet(names[0]) {
+			continue
+		}
+		v, ok := cfg.Setting(app, names[0])
+		if !ok {
+			continue
+		}
+		if err := cctx.Set(names[0], v); err != nil {
+			return fmt.Errorf("config file setting %s: %w", names[0], err)
+		}
+	}
+	return nil
+}
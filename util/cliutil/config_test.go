@@ -0,0 +1,171 @@
+package cliutil
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluesky-social/indigo/xrpc"
+
+	"github.com/urfave/cli/v2"
+)
+
+// isolate points the config and credential locations at a temp dir
+func isolate(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	t.Setenv("INDIGO_CONFIG", "")
+	t.Setenv("INDIGO_CREDENTIAL_STORE", "file")
+	return dir
+}
+
+func TestConfigLegacyMigration(t *testing.T) {
+	dir := isolate(t)
+
+	legacy := `{"PDS":"https://pds.example.com","CurrentProfile":"alice"}`
+	if err := os.WriteFile(filepath.Join(dir, ".gosky"), []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CurrentProfile != "alice" {
+		t.Fatalf("legacy config not read: %+v", cfg)
+	}
+	if err := WriteConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// written to the new location
+	p, err := ConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != filepath.Join(dir, "config", "indigo", "config.json") {
+		t.Fatalf("unexpected config path %s", p)
+	}
+	if _, err := os.Stat(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyConfigPrecedence(t *testing.T) {
+	isolate(t)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Defaults = map[string]string{"pds-host": "https://default.example.com", "plc": "https://plc.example.com"}
+	cfg.Apps = map[string]map[string]string{"testapp": {"pds-host": "https://app.example.com"}}
+	if err := WriteConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) map[string]string {
+		got := make(map[string]string)
+		app := &cli.App{
+			Name: "testapp",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "pds-host", Value: "https://builtin.example.com", EnvVars: []string{"TEST_PDS_HOST"}},
+				&cli.StringFlag{Name: "plc", Value: "https://plc.directory"},
+				&cli.StringFlag{Name: "other", Value: "unchanged"},
+			},
+			Before: ApplyConfig("testapp"),
+			Action: func(cctx *cli.Context) error {
+				for _, f := range []string{"pds-host", "plc", "other"} {
+					got[f] = cctx.String(f)
+				}
+				return nil
+			},
+		}
+		if err := app.Run(append([]string{"testapp"}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	got := run()
+	if got["pds-host"] != "https://app.example.com" || got["plc"] != "https://plc.example.com" || got["other"] != "unchanged" {
+		t.Fatalf("config not applied: %v", got)
+	}
+
+	t.Setenv("TEST_PDS_HOST", "https://env.example.com")
+	if got := run(); got["pds-host"] != "https://env.example.com" {
+		t.Fatalf("env should override config: %v", got)
+	}
+
+	if got := run("--pds-host", "https://flag.example.com"); got["pds-host"] != "https://flag.example.com" {
+		t.Fatalf("flag should override env: %v", got)
+	}
+}
+
+func TestFileCredentialStore(t *testing.T) {
+	fs := &FileStore{Dir: filepath.Join(t.TempDir(), "creds")}
+
+	if _, err := fs.Get("missing"); err != ErrCredentialNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if err := fs.Set("profile.alice", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.Get("profile.alice")
+	if err != nil || string(b) != "secret" {
+		t.Fatalf("unexpected credential %q: %v", b, err)
+	}
+	fi, err := os.Stat(filepath.Join(fs.Dir, "profile.alice.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("credential file is readable by others: %s", fi.Mode())
+	}
+	if err := fs.Delete("profile.alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Get("profile.alice"); err != ErrCredentialNotFound {
+		t.Fatalf("expected deleted credential, got %v", err)
+	}
+
+	if err := fs.Set("../escape", []byte("x")); err == nil {
+		t.Fatal("expected invalid key to be rejected")
+	}
+}
+
+func TestProfileStoredAuth(t *testing.T) {
+	isolate(t)
+
+	store, err := DefaultCredentialStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.Kind() != "file" {
+		t.Fatalf("expected file store, got %s", store.Kind())
+	}
+
+	key := ProfileCredentialKey("alice")
+	b, _ := json.Marshal(&xrpc.AuthInfo{Did: "did:plc:ewvi7nxzyoun6zhxrhs64oiz", Handle: "alice.example.com"})
+	if err := store.Set(key, b); err != nil {
+		t.Fatal(err)
+	}
+
+	prof := &Profile{PDS: "https://pds.example.com", Credential: key, CredentialStore: store.Kind()}
+	auth, err := prof.LoadAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Handle != "alice.example.com" {
+		t.Fatalf("unexpected auth: %+v", auth)
+	}
+
+	if err := prof.RemoveCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := prof.LoadAuth(); err == nil {
+		t.Fatal("expected credentials to be removed")
+	}
+}
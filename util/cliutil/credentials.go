@@ -0,0 +1,158 @@
+package cliutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/zalando/go-keyring"
+)
+
+// ErrCredentialNotFound is returned by a CredentialStore when there is no
+// credential under a key
+var ErrCredentialNotFound = errors.New("credential not found")
+
+// CredentialStore holds secrets (session tokens, OAuth sessions) for
+// command-line tools, keyed by name.
+type CredentialStore interface {
+	// Kind is "keyring" or "file", as recorded in profiles
+	Kind() string
+	Get(key string) ([]byte, error)
+	Set(key string, val []byte) error
+	Delete(key string) error
+}
+
+// service name credentials are stored under in the OS keychain
+const keyringService = "indigo"
+
+// KeyringStore keeps credentials in the OS keychain: the Secret Service
+// (GNOME Keyring, KWallet) on Linux, Keychain on macOS, and Credential
+// Manager on Windows.
+type KeyringStore struct {
+	Service string
+}
+
+func (ks *KeyringStore) Kind() string {
+	return "keyring"
+}
+
+func (ks *KeyringStore) Get(key string) ([]byte, error) {
+	v, err := keyring.Get(ks.Service, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(v), nil
+}
+
+func (ks *KeyringStore) Set(key string, val []byte) error {
+	return keyring.Set(ks.Service, key, string(val))
+}
+
+func (ks *KeyringStore) Delete(key string) error {
+	err := keyring.Delete(ks.Service, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// FileStore keeps credentials in JSON files readable only by the user, for
+// systems without a usable keychain (eg, headless servers). These are the
+// same files as OAuth sessions saved with OAuthSession.Save: key
+// "oauth-session" is the session at DefaultOAuthSessionPath.
+type FileStore struct {
+	Dir string
+}
+
+var credentialKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,127}$`)
+
+func (fs *FileStore) Kind() string {
+	return "file"
+}
+
+func (fs *FileStore) path(key string) (string, error) {
+	if !credentialKeyRegex.MatchString(key) {
+		return "", fmt.Errorf("invalid credential key %q", key)
+	}
+	return filepath.Join(fs.Dir, key+".json"), nil
+}
+
+func (fs *FileStore) Get(key string) ([]byte, error) {
+	p, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCredentialNotFound
+	}
+	return b, err
+}
+
+func (fs *FileStore) Set(key string, val []byte) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	return writeCredentialFile(p, val)
+}
+
+func (fs *FileStore) Delete(key string) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// OpenCredentialStore returns the store of the given kind ("keyring" or
+// "file"). An empty kind picks the keychain if one is available, and files
+// otherwise.
+func OpenCredentialStore(kind string) (CredentialStore, error) {
+	switch kind {
+	case "keyring":
+		return &KeyringStore{Service: keyringService}, nil
+	case "file":
+		dir, err := CredentialDir()
+		if err != nil {
+			return nil, err
+		}
+		return &FileStore{Dir: dir}, nil
+	case "":
+		if keyringAvailable() {
+			return OpenCredentialStore("keyring")
+		}
+		return OpenCredentialStore("file")
+	default:
+		return nil, fmt.Errorf("unknown credential store %q (keyring or file)", kind)
+	}
+}
+
+// DefaultCredentialStore is where new credentials are saved: the store named
+// by $INDIGO_CREDENTIAL_STORE, or by the config file, if either is set, and
+// the keychain if available otherwise.
+func DefaultCredentialStore() (CredentialStore, error) {
+	kind := os.Getenv("INDIGO_CREDENTIAL_STORE")
+	if kind == "" {
+		if cfg, err := readConfig(); err == nil && cfg != nil {
+			kind = cfg.CredentialStore
+		}
+	}
+	return OpenCredentialStore(kind)
+}
+
+// keyringAvailable checks for a working keychain by looking up a key which
+// doesn't exist: a working keychain says so, and a missing or locked one
+// errors some other way
+func keyringAvailable() bool {
+	_, err := keyring.Get(keyringService, "availability-check")
+	return errors.Is(err, keyring.ErrNotFound)
+}
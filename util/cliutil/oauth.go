@@ -0,0 +1,646 @@
+package cliutil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// scope requested by CLI logins; "transition:generic" grants the same access
+// as an app password
+const OAuthScope = "atproto transition:generic"
+
+// OAuthSession is a logged-in OAuth session, as persisted between CLI runs.
+type OAuthSession struct {
+	Did    string `json:"did"`
+	Handle string `json:"handle"`
+	PDS    string `json:"pds"`
+
+	Issuer        string `json:"issuer"`
+	TokenEndpoint string `json:"tokenEndpoint"`
+	ClientID      string `json:"clientId"`
+
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+
+	// PKCS#8 DER encoding of the P-256 DPoP key, base64 encoded
+	DPoPKey         string `json:"dpopKey"`
+	AuthDPoPNonce   string `json:"authDpopNonce,omitempty"`
+	ServerDPoPNonce string `json:"serverDpopNonce,omitempty"`
+
+	// sessions are saved either to a file, or to a credential store
+	filename string
+	store    CredentialStore
+	storeKey string
+	key      *ecdsa.PrivateKey
+	lk       sync.Mutex
+}
+
+// CredentialDir is where sessions are stored, under the user's config
+// directory: the default OAuth session, and the file credential store.
+func CredentialDir() (string, error) {
+	d, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, "gosky"), nil
+}
+
+// DefaultOAuthSessionPath returns the location OAuth sessions are stored at
+// by default, which is the file credential store's "oauth-session" key.
+func DefaultOAuthSessionPath() string {
+	d, err := CredentialDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(d, "oauth-session.json")
+}
+
+// LoadOAuthSession reads a session saved by a previous login. Returns nil
+// (and no error) if fname is empty or doesn't exist.
+func LoadOAuthSession(fname string) (*OAuthSession, error) {
+	if fname == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	sess, err := parseOAuthSession(b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing oauth session %s: %w", fname, err)
+	}
+	sess.filename = fname
+	return sess, nil
+}
+
+// LoadStoredOAuthSession reads a session saved to a credential store.
+// Returns nil (and no error) if there is no session under key.
+func LoadStoredOAuthSession(store CredentialStore, key string) (*OAuthSession, error) {
+	b, err := store.Get(key)
+	if errors.Is(err, ErrCredentialNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sess, err := parseOAuthSession(b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing stored oauth session %s: %w", key, err)
+	}
+	sess.store, sess.storeKey = store, key
+	return sess, nil
+}
+
+func parseOAuthSession(b []byte) (*OAuthSession, error) {
+	var sess OAuthSession
+	if err := json.Unmarshal(b, &sess); err != nil {
+		return nil, err
+	}
+	if err := sess.loadKey(); err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (s *OAuthSession) loadKey() error {
+	der, err := base64.StdEncoding.DecodeString(s.DPoPKey)
+	if err != nil {
+		return fmt.Errorf("decoding DPoP key: %w", err)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return fmt.Errorf("parsing DPoP key: %w", err)
+	}
+	ek, ok := k.(*ecdsa.PrivateKey)
+	if !ok || ek.Curve != elliptic.P256() {
+		return fmt.Errorf("DPoP key must be a P-256 key")
+	}
+	s.key = ek
+	return nil
+}
+
+// Save writes the session (including its DPoP private key) to fname, readable
+// only by the current user.
+func (s *OAuthSession) Save(fname string) error {
+	s.filename = fname
+	s.store, s.storeKey = nil, ""
+	return s.save()
+}
+
+// SaveToStore saves the session to a credential store, and keeps it there
+// as tokens are refreshed
+func (s *OAuthSession) SaveToStore(store CredentialStore, key string) error {
+	s.filename = ""
+	s.store, s.storeKey = store, key
+	return s.save()
+}
+
+func (s *OAuthSession) save() error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if s.store != nil {
+		return s.store.Set(s.storeKey, b)
+	}
+	return writeCredentialFile(s.filename, b)
+}
+
+// writeCredentialFile writes a session file readable only by the current
+// user
+func writeCredentialFile(fname string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(fname), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(fname, b, 0600)
+}
+
+// XrpcClient returns a client for the session's PDS which authenticates
+// requests with DPoP-bound access tokens, refreshing them as needed.
+func (s *OAuthSession) XrpcClient() *xrpc.Client {
+	base := NewHttpClient()
+	return &xrpc.Client{
+		Client: &http.Client{
+			Transport: &dpopTransport{sess: s, base: base.Transport},
+		},
+		Host: s.PDS,
+		// the transport sets the real Authorization header; this just lets
+		// commands find the account's identifiers
+		Auth: &xrpc.AuthInfo{
+			Did:    s.Did,
+			Handle: s.Handle,
+		},
+	}
+}
+
+type dpopTransport struct {
+	sess *OAuthSession
+	base http.RoundTripper
+}
+
+func (t *dpopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s := t.sess
+	s.lk.Lock()
+	if time.Now().Add(30 * time.Second).After(s.ExpiresAt) {
+		if err := s.refresh(req.Context()); err != nil {
+			s.lk.Unlock()
+			return nil, fmt.Errorf("refreshing oauth session: %w", err)
+		}
+	}
+	token, nonce := s.AccessToken, s.ServerDPoPNonce
+	s.lk.Unlock()
+
+	resp, err := t.do(req, token, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// the PDS may demand a (new) nonce; retry once if the body can be replayed
+	newNonce := resp.Header.Get("DPoP-Nonce")
+	if newNonce == "" || newNonce == nonce {
+		return resp, nil
+	}
+	s.lk.Lock()
+	s.ServerDPoPNonce = newNonce
+	s.lk.Unlock()
+
+	if resp.StatusCode != http.StatusUnauthorized || !strings.Contains(resp.Header.Get("WWW-Authenticate"), "use_dpop_nonce") {
+		return resp, nil
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return t.do(retry, token, newNonce)
+}
+
+func (t *dpopTransport) do(req *http.Request, token, nonce string) (*http.Response, error) {
+	proof, err := t.sess.dpopProof(req.Method, req.URL, nonce, token)
+	if err != nil {
+		return nil, err
+	}
+
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "DPoP "+token)
+	r.Header.Set("DPoP", proof)
+	return t.base.RoundTrip(r)
+}
+
+// dpopProof builds a DPoP proof JWT (RFC 9449) for a single request. If
+// accessToken is non-empty, its hash is bound into the proof.
+func (s *OAuthSession) dpopProof(method string, u *url.URL, nonce, accessToken string) (string, error) {
+	htu := url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}
+
+	pub, err := s.key.PublicKey.ECDH()
+	if err != nil {
+		return "", err
+	}
+	// uncompressed point: 0x04 || X || Y
+	pt := pub.Bytes()
+	header := map[string]any{
+		"typ": "dpop+jwt",
+		"alg": "ES256",
+		"jwk": map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   b64url(pt[1:33]),
+			"y":   b64url(pt[33:]),
+		},
+	}
+	claims := map[string]any{
+		"jti": randomToken(16),
+		"htm": method,
+		"htu": htu.String(),
+		"iat": time.Now().Unix(),
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if accessToken != "" {
+		ath := sha256.Sum256([]byte(accessToken))
+		claims["ath"] = b64url(ath[:])
+	}
+
+	hb, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := b64url(hb) + "." + b64url(cb)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + b64url(append(pad32(r.Bytes()), pad32(sig.Bytes())...)), nil
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+	Sub          string `json:"sub"`
+}
+
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *oauthError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth error %s: %s", e.Code, e.Description)
+	}
+	return "oauth error " + e.Code
+}
+
+// postForm sends a DPoP-authenticated form POST to the authorization server,
+// retrying once if the server asks for a nonce.
+func (s *OAuthSession) postForm(ctx context.Context, endpoint string, form url.Values, out any) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		proof, err := s.dpopProof(http.MethodPost, u, s.AuthDPoPNonce, "")
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("DPoP", proof)
+
+		resp, err := NewHttpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if n := resp.Header.Get("DPoP-Nonce"); n != "" {
+			s.AuthDPoPNonce = n
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return json.Unmarshal(b, out)
+		}
+
+		var oe oauthError
+		if err := json.Unmarshal(b, &oe); err != nil || oe.Code == "" {
+			return fmt.Errorf("%s: unexpected status %d", endpoint, resp.StatusCode)
+		}
+		if oe.Code == "use_dpop_nonce" && attempt == 0 {
+			continue
+		}
+		return &oe
+	}
+}
+
+func (s *OAuthSession) applyToken(tok *oauthTokenResponse) error {
+	if !strings.EqualFold(tok.TokenType, "DPoP") {
+		return fmt.Errorf("unexpected oauth token type: %s", tok.TokenType)
+	}
+	if tok.Sub != "" && tok.Sub != s.Did {
+		return fmt.Errorf("oauth token issued for %s, expected %s", tok.Sub, s.Did)
+	}
+	s.AccessToken = tok.AccessToken
+	if tok.RefreshToken != "" {
+		s.RefreshToken = tok.RefreshToken
+	}
+	s.ExpiresAt = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return nil
+}
+
+// refresh swaps the refresh token for a new access token and persists the
+// result, since refresh tokens are single use. Caller must hold the lock.
+func (s *OAuthSession) refresh(ctx context.Context) error {
+	if s.RefreshToken == "" {
+		return fmt.Errorf("oauth session expired and has no refresh token; log in again")
+	}
+
+	var tok oauthTokenResponse
+	err := s.postForm(ctx, s.TokenEndpoint, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.RefreshToken},
+		"client_id":     {s.ClientID},
+	}, &tok)
+	if err != nil {
+		return err
+	}
+	if err := s.applyToken(&tok); err != nil {
+		return err
+	}
+
+	if s.filename != "" || s.store != nil {
+		return s.save()
+	}
+	return nil
+}
+
+type oauthServerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	PAREndpoint           string `json:"pushed_authorization_request_endpoint"`
+}
+
+func fetchJSON(ctx context.Context, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := NewHttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: status %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// OAuthLogin runs an interactive OAuth authorization code flow (with PAR,
+// PKCE and DPoP) for the given handle or DID, as a loopback client listening
+// on 127.0.0.1 for the redirect. The authorization URL is passed to prompt,
+// which should show it to the user.
+func OAuthLogin(ctx context.Context, account string, prompt func(authURL string)) (*OAuthSession, error) {
+	atid, err := syntax.ParseAtIdentifier(account)
+	if err != nil {
+		return nil, err
+	}
+	ident, err := identity.DefaultDirectory().Lookup(ctx, *atid)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", account, err)
+	}
+	pds := ident.PDSEndpoint()
+	if pds == "" {
+		return nil, fmt.Errorf("no PDS endpoint in identity for %s", ident.DID)
+	}
+
+	var prm struct {
+		AuthorizationServers []string `json:"authorization_servers"`
+	}
+	if err := fetchJSON(ctx, pds+"/.well-known/oauth-protected-resource", &prm); err != nil {
+		return nil, err
+	}
+	if len(prm.AuthorizationServers) == 0 {
+		return nil, fmt.Errorf("PDS %s doesn't list an authorization server", pds)
+	}
+	issuer := prm.AuthorizationServers[0]
+
+	var meta oauthServerMetadata
+	if err := fetchJSON(ctx, issuer+"/.well-known/oauth-authorization-server", &meta); err != nil {
+		return nil, err
+	}
+	if meta.Issuer != issuer {
+		return nil, fmt.Errorf("authorization server metadata issuer mismatch: %s != %s", meta.Issuer, issuer)
+	}
+	if meta.PAREndpoint == "" {
+		return nil, fmt.Errorf("authorization server %s doesn't support pushed authorization requests", issuer)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+	redirectURI := fmt.Sprintf("http://%s/callback", ln.Addr().String())
+
+	// loopback clients have no metadata document; the client ID carries the
+	// redirect URI and scope instead
+	clientID := "http://localhost?" + url.Values{
+		"redirect_uri": {redirectURI},
+		"scope":        {OAuthScope},
+	}.Encode()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sess := &OAuthSession{
+		Did:           ident.DID.String(),
+		Handle:        ident.Handle.String(),
+		PDS:           pds,
+		Issuer:        issuer,
+		TokenEndpoint: meta.TokenEndpoint,
+		ClientID:      clientID,
+		DPoPKey:       base64.StdEncoding.EncodeToString(der),
+		key:           key,
+	}
+
+	verifier := randomToken(32)
+	challenge := sha256.Sum256([]byte(verifier))
+	state := randomToken(16)
+
+	var par struct {
+		RequestURI string `json:"request_uri"`
+	}
+	err = sess.postForm(ctx, meta.PAREndpoint, url.Values{
+		"client_id":             {clientID},
+		"response_type":         {"code"},
+		"code_challenge":        {b64url(challenge[:])},
+		"code_challenge_method": {"S256"},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {OAuthScope},
+		"state":                 {state},
+		"login_hint":            {account},
+	}, &par)
+	if err != nil {
+		return nil, fmt.Errorf("pushed authorization request: %w", err)
+	}
+
+	prompt(meta.AuthorizationEndpoint + "?" + url.Values{
+		"client_id":   {clientID},
+		"request_uri": {par.RequestURI},
+	}.Encode())
+
+	code, err := waitForRedirect(ctx, ln, state, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	var tok oauthTokenResponse
+	err = sess.postForm(ctx, meta.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {clientID},
+		"code_verifier": {verifier},
+	}, &tok)
+	if err != nil {
+		return nil, fmt.Errorf("exchanging authorization code: %w", err)
+	}
+	if tok.Sub != sess.Did {
+		return nil, fmt.Errorf("oauth token issued for %q, expected %s", tok.Sub, sess.Did)
+	}
+	if err := sess.applyToken(&tok); err != nil {
+		return nil, err
+	}
+
+	return sess, nil
+}
+
+// waitForRedirect serves the loopback redirect URI until the authorization
+// server sends the browser back, and returns the authorization code.
+func waitForRedirect(ctx context.Context, ln net.Listener, state, issuer string) (string, error) {
+	type result struct {
+		code string
+		err  error
+	}
+	done := make(chan result, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var res result
+		switch {
+		case q.Get("error") != "":
+			res.err = &oauthError{Code: q.Get("error"), Description: q.Get("error_description")}
+		case q.Get("state") != state:
+			res.err = fmt.Errorf("oauth redirect state mismatch")
+		case q.Get("iss") != "" && q.Get("iss") != issuer:
+			res.err = fmt.Errorf("oauth redirect from unexpected issuer: %s", q.Get("iss"))
+		case q.Get("code") == "":
+			res.err = fmt.Errorf("oauth redirect is missing a code")
+		default:
+			res.code = q.Get("code")
+		}
+
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintln(w, "Login complete, you can close this window.")
+		}
+		select {
+		case done <- res:
+		default:
+		}
+	})
+
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	select {
+	case res := <-done:
+		return res.code, res.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func b64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func pad32(b []byte) []byte {
+	if len(b) >= 32 {
+		return b
+	}
+	out := make([]byte, 32)
+	copy(out[32-len(b):], b)
+	return out
+}
+
+func randomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b64url(b)
+}
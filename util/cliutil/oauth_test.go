@@ -0,0 +1,125 @@
+package cliutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestOAuthSession(t *testing.T) *OAuthSession {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &OAuthSession{
+		Did:     "did:plc:abc123",
+		DPoPKey: base64.StdEncoding.EncodeToString(der),
+		key:     key,
+	}
+}
+
+func TestDPoPProof(t *testing.T) {
+	sess := newTestOAuthSession(t)
+
+	u, err := url.Parse("https://pds.example.com/xrpc/com.atproto.repo.createRecord?foo=bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := sess.dpopProof("POST", u, "nonce-1", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(proof, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected three JWT segments, got %d", len(parts))
+	}
+
+	var claims map[string]any
+	cb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(cb, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["htu"] != "https://pds.example.com/xrpc/com.atproto.repo.createRecord" {
+		t.Fatalf("htu should not include the query: %v", claims["htu"])
+	}
+	if claims["htm"] != "POST" || claims["nonce"] != "nonce-1" {
+		t.Fatalf("unexpected claims: %v", claims)
+	}
+	ath := sha256.Sum256([]byte("token"))
+	if claims["ath"] != b64url(ath[:]) {
+		t.Fatalf("unexpected ath: %v", claims["ath"])
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sig) != 64 {
+		t.Fatalf("expected 64 byte ES256 signature, got %d", len(sig))
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&sess.key.PublicKey, digest[:], r, s) {
+		t.Fatal("proof signature did not verify")
+	}
+}
+
+func TestOAuthSessionSaveLoad(t *testing.T) {
+	sess := newTestOAuthSession(t)
+	fname := filepath.Join(t.TempDir(), "gosky", "oauth-session.json")
+	if err := sess.Save(fname); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadOAuthSession(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Did != sess.Did || !loaded.key.Equal(sess.key) {
+		t.Fatal("loaded session doesn't match saved session")
+	}
+
+	missing, err := LoadOAuthSession(filepath.Join(t.TempDir(), "nope.json"))
+	if err != nil || missing != nil {
+		t.Fatalf("expected no session and no error, got %v, %v", missing, err)
+	}
+}
+
+func TestOAuthSessionInFileStore(t *testing.T) {
+	isolate(t)
+	sess := newTestOAuthSession(t)
+	if err := sess.Save(DefaultOAuthSessionPath()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the default session is in the file credential store
+	store, err := OpenCredentialStore("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadStoredOAuthSession(store, "oauth-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded == nil || loaded.Did != sess.Did || !loaded.key.Equal(sess.key) {
+		t.Fatal("stored session doesn't match saved session")
+	}
+}
@@ -0,0 +1,137 @@
+package cliutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/bluesky-social/indigo/xrpc"
+)
+
+// Profile is a named set of credentials for one account on one PDS. The
+// credentials themselves live in a CredentialStore (or, for older profiles,
+// separate files readable only by the user), so the config file itself can be
+// shared or checked in.
+type Profile struct {
+	PDS string
+	// Credential is the profile's key in the credential store of kind
+	// CredentialStore, holding either a password session (xrpc.AuthInfo
+	// JSON) or, if OAuth is set, an OAuth session
+	Credential      string `json:",omitempty"`
+	CredentialStore string `json:",omitempty"`
+	OAuth           bool   `json:",omitempty"`
+
+	// credential files, from before profiles used a credential store
+	AuthFile     string `json:",omitempty"`
+	OAuthSession string `json:",omitempty"`
+}
+
+var profileNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+// ValidateProfileName checks that a profile name is safe to use in file names.
+func ValidateProfileName(name string) error {
+	if !profileNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q (letters, digits, '.', '_' and '-' only)", name)
+	}
+	return nil
+}
+
+// LoadProfile looks up a named profile in the config file.
+func LoadProfile(name string) (*Profile, error) {
+	cfg, err := readConfig()
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	if cfg == nil || cfg.Profiles[name] == nil {
+		return nil, fmt.Errorf("no such profile: %s", name)
+	}
+	return cfg.Profiles[name], nil
+}
+
+// ProfileCredentialKey is the credential store key for a profile's
+// credentials
+func ProfileCredentialKey(name string) string {
+	return "profile." + name
+}
+
+// credentialStore opens the store holding the profile's credentials, or
+// returns nil if it doesn't use one
+func (p *Profile) credentialStore() (CredentialStore, error) {
+	if p.Credential == "" {
+		return nil, nil
+	}
+	return OpenCredentialStore(p.CredentialStore)
+}
+
+func (p *Profile) hasPasswordAuth() bool {
+	if p.OAuth || p.OAuthSession != "" {
+		return false
+	}
+	return p.Credential != "" || p.AuthFile != ""
+}
+
+// LoadAuth returns the profile's password session, or nil if it has none
+// (eg, it's an OAuth profile)
+func (p *Profile) LoadAuth() (*xrpc.AuthInfo, error) {
+	if !p.hasPasswordAuth() {
+		return nil, nil
+	}
+	store, err := p.credentialStore()
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		if p.AuthFile == "" {
+			return nil, nil
+		}
+		return ReadAuth(p.AuthFile)
+	}
+	b, err := store.Get(p.Credential)
+	if err != nil {
+		return nil, fmt.Errorf("reading credential %s from %s store: %w", p.Credential, store.Kind(), err)
+	}
+	var auth xrpc.AuthInfo
+	if err := json.Unmarshal(b, &auth); err != nil {
+		return nil, err
+	}
+	return &auth, nil
+}
+
+// LoadOAuthSession returns the profile's OAuth session, or nil if it has none
+func (p *Profile) LoadOAuthSession() (*OAuthSession, error) {
+	if p.OAuthSession != "" {
+		return LoadOAuthSession(p.OAuthSession)
+	}
+	if !p.OAuth {
+		return nil, nil
+	}
+	store, err := p.credentialStore()
+	if err != nil || store == nil {
+		return nil, err
+	}
+	return LoadStoredOAuthSession(store, p.Credential)
+}
+
+// RemoveCredentials deletes the profile's stored credentials and credential
+// files, if they exist.
+func (p *Profile) RemoveCredentials() error {
+	store, err := p.credentialStore()
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		if err := store.Delete(p.Credential); err != nil {
+			return err
+		}
+	}
+	for _, f := range []string{p.AuthFile, p.OAuthSession} {
+		if f == "" {
+			continue
+		}
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
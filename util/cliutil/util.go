@@ -12,7 +12,6 @@ import (
 	"github.com/bluesky-social/indigo/api"
 	"github.com/bluesky-social/indigo/did"
 	"github.com/bluesky-social/indigo/xrpc"
-	homedir "github.com/mitchellh/go-homedir"
 	"github.com/urfave/cli/v2"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
@@ -48,57 +47,57 @@ func NewHttpClient() *http.Client {
 	}
 }
 
-type CliConfig struct {
-	filename string
-	PDS      string
-}
-
-func readGoskyConfig() (*CliConfig, error) {
-	d, err := homedir.Dir()
-	if err != nil {
-		return nil, fmt.Errorf("cannot read Home directory")
-	}
-
-	f := filepath.Join(d, ".gosky")
-
-	b, err := os.ReadFile(f)
-	if os.IsNotExist(err) {
-		return nil, nil
-	}
-
-	var out CliConfig
-	if err := json.Unmarshal(b, &out); err != nil {
-		return nil, err
-	}
-
-	out.filename = f
-	return &out, nil
-}
-
-var Config *CliConfig
-
-func TryReadConfig() {
-	cfg, err := readGoskyConfig()
-	if err != nil {
-		fmt.Println(err)
-	} else {
-		Config = cfg
+func GetXrpcClient(cctx *cli.Context, authreq bool) (*xrpc.Client, error) {
+	h := "http://localhost:4989"
+	if pdsurl := cctx.String("pds-host"); pdsurl != "" {
+		h = pdsurl
 	}
-}
 
-func WriteConfig(cfg *CliConfig) error {
-	b, err := json.Marshal(cfg)
-	if err != nil {
-		return err
+	// a named profile supplies the PDS and credentials, unless they were
+	// passed explicitly
+	var prof *Profile
+	if name := cctx.String("profile"); name != "" {
+		p, err := LoadProfile(name)
+		if err != nil {
+			return nil, err
+		}
+		if p.PDS != "" && !cctx.IsSet("pds-host") {
+			h = p.PDS
+		}
+		prof = p
 	}
 
-	return os.WriteFile(cfg.filename, b, 0664)
-}
+	// an OAuth login is used unless auth info was passed explicitly
+	if !cctx.IsSet("auth") {
+		var sess *OAuthSession
+		var err error
+		if prof != nil {
+			sess, err = prof.LoadOAuthSession()
+		} else {
+			sess, err = LoadOAuthSession(cctx.String("oauth-session"))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("loading oauth session: %w", err)
+		}
+		if sess != nil {
+			c := sess.XrpcClient()
+			if cctx.IsSet("pds-host") {
+				c.Host = h
+			}
+			return c, nil
+		}
 
-func GetXrpcClient(cctx *cli.Context, authreq bool) (*xrpc.Client, error) {
-	h := "http://localhost:4989"
-	if pdsurl := cctx.String("pds-host"); pdsurl != "" {
-		h = pdsurl
+		if prof != nil && prof.hasPasswordAuth() {
+			auth, err := prof.LoadAuth()
+			if err != nil && authreq {
+				return nil, fmt.Errorf("loading profile auth: %w", err)
+			}
+			return &xrpc.Client{
+				Client: NewHttpClient(),
+				Host:   h,
+				Auth:   auth,
+			}, nil
+		}
 	}
 
 	auth, err := loadAuthFromEnv(cctx, authreq)
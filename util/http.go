@@ -1,15 +1,37 @@
 package util
 
 import (
+	"context"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/bluesky-social/indigo/util/ratelimit"
+
 	"github.com/hashicorp/go-retryablehttp"
 	logging "github.com/ipfs/go-log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 var log = logging.Logger("http")
 
+var httpClientRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_http_client_requests_total",
+	Help: "Total number of HTTP client request attempts, including retries",
+}, []string{"client"})
+
+var httpClientRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_http_client_retries_total",
+	Help: "Total number of HTTP client request retries",
+}, []string{"client"})
+
+var httpClientResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "indigo_http_client_responses_total",
+	Help: "Total number of HTTP client responses, by status code",
+}, []string{"client", "code"})
+
 type LeveledZap struct {
 	inner *logging.ZapEventLogger
 }
@@ -31,9 +53,140 @@ func (l LeveledZap) Debug(msg string, keysAndValues ...interface{}) {
 	l.inner.Debugw(msg, keysAndValues...)
 }
 
+// HTTPClientConfig controls the clients built by NewHTTPClient. Zero values
+// fall back to the stdlib defaults (no timeout, no connection limit, etc),
+// so start from DefaultHTTPClientConfig and adjust.
+type HTTPClientConfig struct {
+	// RetryMax is the number of retries after the first attempt; 0 disables
+	// retries
+	RetryMax     int
+	RetryWaitMin time.Duration
+	RetryWaitMax time.Duration
+	// RetryPolicy decides whether a response or error is retried. Defaults
+	// to retrying connection errors, 5xx (except 501) and 429
+	RetryPolicy retryablehttp.CheckRetry
+
+	// Timeout is for the whole request, including retries and reading the
+	// body
+	Timeout               time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+
+	MaxConnsPerHost     int
+	MaxIdleConnsPerHost int
+
+	// DialContext replaces the transport's dialer (DialTimeout is then
+	// ignored)
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Name labels the client's prometheus metrics. Metrics are only recorded
+	// when it is set.
+	Name string
+	// RequestHook is called before every attempt; attempt is 0 for the first
+	RequestHook func(req *http.Request, attempt int)
+	// ResponseHook is called with the response to every attempt which got
+	// one
+	ResponseHook func(resp *http.Response)
+
+	// RateLimits, if set, paces requests to stay within the quota each host
+	// advertises in ratelimit-* headers, tracked together with every other
+	// client using the same registry. Nil disables this.
+	RateLimits *ratelimit.Registry
+
+	// set by NewSafeHTTPClient
+	noProxy bool
+}
+
+// DefaultHTTPClientConfig is the configuration used by RobustHTTPClient.
+func DefaultHTTPClientConfig() HTTPClientConfig {
+	return HTTPClientConfig{
+		RetryMax:            3,
+		RetryWaitMin:        1 * time.Second,
+		RetryWaitMax:        10 * time.Second,
+		Timeout:             30 * time.Second,
+		DialTimeout:         30 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConnsPerHost: 10,
+		RateLimits:          ratelimit.Default,
+	}
+}
+
+// NewHTTPClient builds a client with the stdlib http.Client interface, and
+// Hashicorp retryablehttp logic internally. Intermediate failures are logged
+// at WARN level.
+func NewHTTPClient(cfg HTTPClientConfig) *http.Client {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if cfg.noProxy {
+		transport.Proxy = nil
+	}
+	if cfg.DialContext != nil {
+		transport.DialContext = cfg.DialContext
+	} else {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+
+	var rt http.RoundTripper = transport
+	if cfg.RateLimits != nil {
+		// below the retries, so each attempt waits for budget
+		rt = cfg.RateLimits.Transport(transport)
+	}
+
+	retryClient := retryablehttp.NewClient()
+	retryClient.HTTPClient = &http.Client{Transport: rt}
+	retryClient.RetryMax = cfg.RetryMax
+	retryClient.RetryWaitMin = cfg.RetryWaitMin
+	retryClient.RetryWaitMax = cfg.RetryWaitMax
+	if cfg.RetryPolicy != nil {
+		retryClient.CheckRetry = cfg.RetryPolicy
+	}
+	retryClient.Logger = retryablehttp.LeveledLogger(LeveledZap{log})
+
+	name := cfg.Name
+	retryClient.RequestLogHook = func(_ retryablehttp.Logger, req *http.Request, attempt int) {
+		if name != "" {
+			httpClientRequests.WithLabelValues(name).Inc()
+			if attempt > 0 {
+				httpClientRetries.WithLabelValues(name).Inc()
+			}
+		}
+		if cfg.RequestHook != nil {
+			cfg.RequestHook(req, attempt)
+		}
+	}
+	retryClient.ResponseLogHook = func(_ retryablehttp.Logger, resp *http.Response) {
+		if name != "" {
+			httpClientResponses.WithLabelValues(name, strconv.Itoa(resp.StatusCode)).Inc()
+		}
+		if cfg.ResponseHook != nil {
+			cfg.ResponseHook(resp)
+		}
+	}
+
+	client := retryClient.StandardClient()
+	client.Timeout = cfg.Timeout
+	return client
+}
+
 // Generates an HTTP client with decent general-purpose defaults around
-// timeouts and retries. The returned client has the stdlib http.Client
-// interface, but has Hashicorp retryablehttp logic internally.
+// timeouts and retries (see DefaultHTTPClientConfig). The returned client has
+// the stdlib http.Client interface, but has Hashicorp retryablehttp logic
+// internally.
 //
 // This client will retry on connection errors, 5xx status (except 501), and
 // 429 Backoff requests (respecting 'Retry-After' header). It will log
@@ -42,17 +195,9 @@ func (l LeveledZap) Debug(msg string, keysAndValues ...interface{}) {
 //
 // This should be usable for XRPC clients, and other general inter-service
 // client needs. CLI tools might want shorter timeouts and fewer retries by
-// default.
+// default; use NewHTTPClient for those.
 func RobustHTTPClient() *http.Client {
-
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = 3
-	retryClient.RetryWaitMin = 1 * time.Second
-	retryClient.RetryWaitMax = 10 * time.Second
-	retryClient.Logger = retryablehttp.LeveledLogger(LeveledZap{log})
-	client := retryClient.StandardClient()
-	client.Timeout = 30 * time.Second
-	return client
+	return NewHTTPClient(DefaultHTTPClientConfig())
 }
 
 // For use in local integration tests. Short timeouts, no retries, etc
@@ -0,0 +1,53 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClientRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := DefaultHTTPClientConfig()
+	cfg.RetryWaitMin = time.Millisecond
+	cfg.RetryWaitMax = time.Millisecond
+	cfg.Name = "test"
+	var attempts, responses int
+	cfg.RequestHook = func(req *http.Request, attempt int) { attempts = attempt + 1 }
+	cfg.ResponseHook = func(resp *http.Response) { responses++ }
+
+	resp, err := NewHTTPClient(cfg).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %d", resp.StatusCode)
+	}
+	if attempts != 3 || responses != 3 {
+		t.Fatalf("expected 3 attempts and responses, got %d and %d", attempts, responses)
+	}
+
+	// without retries, the first failure is returned
+	calls.Store(0)
+	cfg.RetryMax = 0
+	resp, err = NewHTTPClient(cfg).Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected an error")
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected a single attempt, got %d", calls.Load())
+	}
+}
@@ -0,0 +1,26 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseBudget parses a request budget written as "<count>/<window>", eg
+// "3000/5m", as servers take them in flags
+func ParseBudget(s string) (int, time.Duration, error) {
+	n, w, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("rate limit %q should look like 3000/5m", s)
+	}
+	count, err := strconv.Atoi(n)
+	if err != nil || count <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate limit count %q", n)
+	}
+	window, err := time.ParseDuration(w)
+	if err != nil || window <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate limit window %q", w)
+	}
+	return count, window, nil
+}
@@ -0,0 +1,27 @@
+package ratelimit
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var waitDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "indigo_ratelimit_wait_seconds",
+	Help:    "Time requests spent waiting for a host's rate limit budget",
+	Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+})
+
+var refused = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "indigo_ratelimit_refused_total",
+	Help: "Requests refused locally because a host's budget wouldn't refill in time",
+})
+
+var ratelimited = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "indigo_ratelimit_429_total",
+	Help: "HTTP 429 responses seen from remote hosts",
+})
+
+var hostsTracked = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "indigo_ratelimit_hosts",
+	Help: "Number of hosts with a tracked rate limit budget",
+})
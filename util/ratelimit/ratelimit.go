@@ -0,0 +1,336 @@
+// Package ratelimit shares knowledge of remote hosts' rate limits between all
+// the HTTP clients in a process.
+//
+// Hosts in the network (PDS instances, relays, AppViews) advertise their
+// quotas with ratelimit-limit, ratelimit-remaining, and ratelimit-reset
+// response headers. A process usually talks to the same host through several
+// clients (XRPC calls, identity resolution, blob fetches), each of which
+// would otherwise burn through the quota independently and only find out
+// when they start getting 429s. A Registry tracks the budget per host from
+// every response it sees, and Transport makes requests wait for it, so that
+// together they stay within the quota.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrBudgetExhausted is returned (wrapped in a *LimitedError) when a host's
+// budget won't refill before the request's context deadline, or within
+// Config.MaxWait.
+var ErrBudgetExhausted = errors.New("host rate limit budget exhausted")
+
+// LimitedError is returned by Wait and the Transport when a request was
+// refused locally, without being sent
+type LimitedError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *LimitedError) Error() string {
+	return fmt.Sprintf("%s: %s (until %s)", ErrBudgetExhausted, e.Host, e.Until.Format(time.RFC3339))
+}
+
+func (e *LimitedError) Unwrap() error {
+	return ErrBudgetExhausted
+}
+
+type Config struct {
+	// Reserve is the number of requests in each window left unused, as
+	// headroom for other processes sharing the same quota (eg, several
+	// replicas behind one egress IP)
+	Reserve int
+	// MaxWait bounds how long a request will wait for a host's budget to
+	// refill; longer waits fail immediately instead. Zero means waiting as
+	// long as the request context allows.
+	MaxWait time.Duration
+	// DefaultBackoff is how long a host is paused after a 429 which doesn't
+	// say when to retry
+	DefaultBackoff time.Duration
+	// IdleExpiry is how long a host's state is kept after its window ends
+	// without hearing from it again
+	IdleExpiry time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Reserve:        5,
+		DefaultBackoff: 10 * time.Second,
+		IdleExpiry:     10 * time.Minute,
+	}
+}
+
+// Registry tracks the rate limit budget of every host a process talks to.
+// It is safe for concurrent use; most programs should use Default instead of
+// making their own.
+type Registry struct {
+	cfg Config
+
+	lk      sync.Mutex
+	hosts   map[string]*hostBudget
+	inserts int
+}
+
+// Default is the process-wide registry, used by Transport and by
+// util.DefaultHTTPClientConfig
+var Default = NewRegistry(DefaultConfig())
+
+func NewRegistry(cfg Config) *Registry {
+	return &Registry{
+		cfg:   cfg,
+		hosts: make(map[string]*hostBudget),
+	}
+}
+
+type hostBudget struct {
+	lk          sync.Mutex
+	limiter     *rate.Limiter
+	limit       int
+	remaining   int
+	reset       time.Time
+	policy      string
+	pausedUntil time.Time
+	lastSeen    time.Time
+}
+
+// HostStatus is a snapshot of the registry's view of a host
+type HostStatus struct {
+	Host        string    `json:"host"`
+	Limit       int       `json:"limit"`
+	Remaining   int       `json:"remaining"`
+	Reset       time.Time `json:"reset"`
+	Policy      string    `json:"policy,omitempty"`
+	Rate        float64   `json:"rate"`
+	PausedUntil time.Time `json:"pausedUntil"`
+}
+
+// lookup returns the budget for host, if it has ever advertised one
+func (r *Registry) lookup(host string) *hostBudget {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return r.hosts[host]
+}
+
+// get returns the budget for host, creating it if needed
+func (r *Registry) get(host string) *hostBudget {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	h, ok := r.hosts[host]
+	if !ok {
+		h = &hostBudget{limiter: rate.NewLimiter(rate.Inf, 1)}
+		r.hosts[host] = h
+		r.inserts++
+		// identity resolution touches lots of hosts once each, so
+		// occasionally drop the ones we've stopped hearing from
+		if r.inserts%1000 == 0 {
+			r.expireLocked()
+		}
+	}
+	return h
+}
+
+func (r *Registry) expireLocked() {
+	now := time.Now()
+	for host, h := range r.hosts {
+		h.lk.Lock()
+		idle := now.Sub(h.lastSeen) > r.cfg.IdleExpiry && now.After(h.reset) && now.After(h.pausedUntil)
+		h.lk.Unlock()
+		if idle {
+			delete(r.hosts, host)
+		}
+	}
+	hostsTracked.Set(float64(len(r.hosts)))
+}
+
+// Wait blocks until a request to host fits within its budget. Hosts which
+// haven't advertised a rate limit are never delayed.
+func (r *Registry) Wait(ctx context.Context, host string) error {
+	h := r.lookup(host)
+	if h == nil {
+		return nil
+	}
+
+	start := time.Now()
+	for {
+		now := time.Now()
+		h.lk.Lock()
+		if !h.reset.IsZero() && !now.Before(h.reset) && h.limiter.Limit() != rate.Inf {
+			// the window is over; don't pace to it until we hear the next one
+			h.limiter.SetLimit(rate.Inf)
+		}
+		until := h.pausedUntil
+		if !until.After(now) && now.Before(h.reset) && h.remaining <= r.cfg.Reserve {
+			// our own requests have used up what's left of this window
+			until = h.reset
+		}
+		if !until.After(now) {
+			h.remaining--
+			h.lk.Unlock()
+			break
+		}
+		h.lk.Unlock()
+
+		if err := r.sleep(ctx, host, until); err != nil {
+			return err
+		}
+	}
+
+	// then pace within the window
+	res := h.limiter.Reserve()
+	if d := res.Delay(); d > 0 {
+		if err := r.sleep(ctx, host, time.Now().Add(d)); err != nil {
+			res.Cancel()
+			return err
+		}
+	}
+	if waited := time.Now().Sub(start); waited > 0 {
+		waitDuration.Observe(waited.Seconds())
+	}
+	return nil
+}
+
+// sleep waits until the given time, unless that's longer than the request
+// is allowed to wait
+func (r *Registry) sleep(ctx context.Context, host string, until time.Time) error {
+	d := time.Until(until)
+	if r.cfg.MaxWait > 0 && d > r.cfg.MaxWait {
+		refused.Inc()
+		return &LimitedError{Host: host, Until: until}
+	}
+	if deadline, ok := ctx.Deadline(); ok && until.After(deadline) {
+		refused.Inc()
+		return &LimitedError{Host: host, Until: until}
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Observe updates host's budget from the headers of a response it sent
+func (r *Registry) Observe(host string, resp *http.Response) {
+	now := time.Now()
+	hdr := resp.Header
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		ratelimited.Inc()
+		until, ok := retryAfter(hdr, now)
+		if !ok || !until.After(now) {
+			until = now.Add(r.cfg.DefaultBackoff)
+		}
+		h := r.get(host)
+		h.lk.Lock()
+		h.lastSeen = now
+		if until.After(h.pausedUntil) {
+			h.pausedUntil = until
+		}
+		h.lk.Unlock()
+		return
+	}
+
+	remaining, err := strconv.Atoi(hdr.Get("ratelimit-remaining"))
+	if err != nil {
+		return
+	}
+	reset, ok := parseReset(hdr.Get("ratelimit-reset"), now)
+	if !ok || !reset.After(now) {
+		return
+	}
+	limit, _ := strconv.Atoi(hdr.Get("ratelimit-limit"))
+
+	h := r.get(host)
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	h.lastSeen = now
+
+	// responses to concurrent requests arrive in any order, so within a
+	// window only ever lower the count; a later reset is a new window
+	sameWindow := h.reset.Sub(reset).Abs() < 2*time.Second
+	switch {
+	case sameWindow && remaining >= h.remaining:
+		return
+	case sameWindow:
+		h.remaining = remaining
+	case reset.After(h.reset):
+		h.remaining = remaining
+		h.reset = reset
+	default:
+		// older window than we already know about
+		return
+	}
+	if limit > 0 {
+		h.limit = limit
+	}
+	h.policy = hdr.Get("ratelimit-policy")
+
+	// spread what's left over the rest of the window, rather than letting a
+	// burst use it all and then stalling every client until the reset
+	usable := h.remaining - r.cfg.Reserve
+	if usable <= 0 {
+		h.limiter.SetLimit(rate.Inf)
+		return
+	}
+	h.limiter.SetLimit(rate.Limit(float64(usable) / reset.Sub(now).Seconds()))
+	h.limiter.SetBurst(max(1, usable/10))
+}
+
+// Status returns a snapshot of every host the registry knows about
+func (r *Registry) Status() []HostStatus {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+
+	out := make([]HostStatus, 0, len(r.hosts))
+	for host, h := range r.hosts {
+		h.lk.Lock()
+		out = append(out, HostStatus{
+			Host:        host,
+			Limit:       h.limit,
+			Remaining:   h.remaining,
+			Reset:       h.reset,
+			Policy:      h.policy,
+			Rate:        float64(h.limiter.Limit()),
+			PausedUntil: h.pausedUntil,
+		})
+		h.lk.Unlock()
+	}
+	return out
+}
+
+// retryAfter parses a Retry-After header, or failing that ratelimit-reset
+func retryAfter(hdr http.Header, now time.Time) (time.Time, bool) {
+	if v := hdr.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			return now.Add(time.Duration(secs) * time.Second), true
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return t, true
+		}
+	}
+	return parseReset(hdr.Get("ratelimit-reset"), now)
+}
+
+// parseReset handles ratelimit-reset as either a unix timestamp (as sent by
+// the PDS) or a number of seconds from now (as in the IETF draft).
+func parseReset(v string, now time.Time) (time.Time, bool) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return time.Time{}, false
+	}
+	if n > 1_000_000_000 {
+		return time.Unix(n, 0), true
+	}
+	return now.Add(time.Duration(n) * time.Second), true
+}
@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func response(code int, hdr map[string]string) *http.Response {
+	resp := &http.Response{StatusCode: code, Header: make(http.Header)}
+	for k, v := range hdr {
+		resp.Header.Set(k, v)
+	}
+	return resp
+}
+
+func TestUnknownHostNotDelayed(t *testing.T) {
+	reg := NewRegistry(DefaultConfig())
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := reg.Wait(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.Status()) != 0 {
+		t.Fatal("expected no tracked hosts")
+	}
+}
+
+func TestExhaustedBudgetRefused(t *testing.T) {
+	reg := NewRegistry(DefaultConfig())
+	reset := time.Now().Add(time.Minute)
+	reg.Observe("pds.example.com", response(200, map[string]string{
+		"ratelimit-limit":     "100",
+		"ratelimit-remaining": "3",
+		"ratelimit-reset":     strconv.FormatInt(reset.Unix(), 10),
+	}))
+
+	// within the reserve, so requests wait for the window to reset, which
+	// is past the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := reg.Wait(ctx, "pds.example.com")
+	if !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("expected budget exhausted, got: %v", err)
+	}
+
+	// other hosts are unaffected
+	if err := reg.Wait(ctx, "other.example.com"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalRequestsCountAgainstBudget(t *testing.T) {
+	reg := NewRegistry(DefaultConfig())
+	remaining := func() int {
+		return reg.Status()[0].Remaining
+	}
+	reg.Observe("pds.example.com", response(200, map[string]string{
+		"ratelimit-remaining": "1000",
+		"ratelimit-reset":     "60",
+	}))
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := reg.Wait(ctx, "pds.example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if remaining() != 998 {
+		t.Fatalf("expected 998 remaining, got %d", remaining())
+	}
+
+	// a stale response from earlier in the window doesn't add budget back
+	reg.Observe("pds.example.com", response(200, map[string]string{
+		"ratelimit-remaining": "999",
+		"ratelimit-reset":     "60",
+	}))
+	if remaining() != 998 {
+		t.Fatalf("expected 998 remaining, got %d", remaining())
+	}
+	reg.Observe("pds.example.com", response(200, map[string]string{
+		"ratelimit-remaining": "900",
+		"ratelimit-reset":     "60",
+	}))
+	if remaining() != 900 {
+		t.Fatalf("expected 900 remaining, got %d", remaining())
+	}
+}
+
+func TestPacing(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Reserve = 0
+	reg := NewRegistry(cfg)
+	reg.Observe("pds.example.com", response(200, map[string]string{
+		"ratelimit-remaining": "2",
+		"ratelimit-reset":     "60",
+	}))
+
+	// two requests left in a minute are spread out, not sent back to back
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := reg.Wait(ctx, "pds.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Wait(ctx, "pds.example.com"); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("expected budget exhausted, got: %v", err)
+	}
+}
+
+func TestTooManyRequestsPauses(t *testing.T) {
+	reg := NewRegistry(DefaultConfig())
+	reg.Observe("pds.example.com", response(429, map[string]string{"Retry-After": "30"}))
+
+	status := reg.Status()
+	if len(status) != 1 || time.Until(status[0].PausedUntil) < 25*time.Second {
+		t.Fatalf("expected host to be paused: %+v", status)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := reg.Wait(ctx, "pds.example.com"); !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("expected budget exhausted, got: %v", err)
+	}
+}
+
+func TestTransportSharesBudget(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("ratelimit-remaining", "0")
+		w.Header().Set("ratelimit-reset", "120")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	reg := NewRegistry(DefaultConfig())
+	a := &http.Client{Transport: reg.Transport(nil), Timeout: 5 * time.Second}
+	b := &http.Client{Transport: reg.Transport(nil), Timeout: 5 * time.Second}
+
+	resp, err := a.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// the second client learns from the first client's response
+	_, err = b.Get(srv.URL)
+	if !errors.Is(err, ErrBudgetExhausted) {
+		t.Fatalf("expected budget exhausted, got: %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected a single request to reach the server, got %d", calls.Load())
+	}
+}
+
+func TestParseReset(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	if v, ok := parseReset("1700000060", now); !ok || !v.Equal(now.Add(time.Minute)) {
+		t.Fatalf("unix timestamp: %v %v", v, ok)
+	}
+	if v, ok := parseReset("60", now); !ok || !v.Equal(now.Add(time.Minute)) {
+		t.Fatalf("delta seconds: %v %v", v, ok)
+	}
+	if _, ok := parseReset("soon", now); ok {
+		t.Fatal("expected invalid reset")
+	}
+}
@@ -0,0 +1,37 @@
+package ratelimit
+
+import (
+	"net/http"
+)
+
+type transport struct {
+	reg  *Registry
+	base http.RoundTripper
+}
+
+// Transport wraps base (http.DefaultTransport if nil) so that requests wait
+// for their host's budget in the registry, and responses update it.
+func (r *Registry) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{reg: r, base: base}
+}
+
+// Transport wraps base with the Default registry
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return Default.Transport(base)
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := t.reg.Wait(req.Context(), host); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.reg.Observe(host, resp)
+	return resp, nil
+}
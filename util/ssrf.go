@@ -0,0 +1,138 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// ErrDisallowedAddress is returned (wrapped) when a SafeDialer refuses to
+// connect to an address
+var ErrDisallowedAddress = errors.New("destination address not allowed")
+
+// address ranges which aren't private, loopback or link-local as far as
+// netip is concerned, but still shouldn't be reachable from user-supplied URLs
+var disallowedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, and broadcast
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("2002::/16"),       // 6to4, which can embed private IPv4
+	netip.MustParsePrefix("2001::/32"),       // Teredo, likewise
+	netip.MustParsePrefix("fec0::/10"),       // deprecated site-local
+	netip.MustParsePrefix("::ffff:0:0:0/96"), // IPv4-translated
+}
+
+// IsPublicAddr reports whether ip is a globally routable unicast address:
+// not loopback, link-local, private (RFC 1918 or IPv6 ULA), multicast, or
+// otherwise reserved.
+func IsPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, p := range disallowedPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// SafeDialer only connects to public addresses, for services which fetch
+// URLs supplied by users (did:web documents, webhooks, link previews, etc).
+//
+// The check happens on the address actually being connected to, after DNS
+// resolution, so a hostname which resolves (or re-resolves) to an internal
+// address is refused too.
+type SafeDialer struct {
+	Dialer net.Dialer
+	// Allow lists otherwise disallowed ranges which may be connected to, eg an
+	// internal network the service is expected to reach
+	Allow []netip.Prefix
+}
+
+func NewSafeDialer(allow ...netip.Prefix) *SafeDialer {
+	return &SafeDialer{
+		Dialer: net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		},
+		Allow: allow,
+	}
+}
+
+// Allowed reports whether the dialer may connect to ip
+func (d *SafeDialer) Allowed(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range d.Allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return IsPublicAddr(ip)
+}
+
+func (d *SafeDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("%w: network %s", ErrDisallowedAddress, network)
+	}
+	dialer := d.Dialer
+	dialer.Control = d.control
+	return dialer.DialContext(ctx, network, address)
+}
+
+func (d *SafeDialer) control(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrDisallowedAddress, address)
+	}
+	if !d.Allowed(ap.Addr()) {
+		return fmt.Errorf("%w: %s", ErrDisallowedAddress, ap.Addr())
+	}
+	return nil
+}
+
+// SafeHTTPClient is RobustHTTPClient, but connecting through a SafeDialer.
+// Refused connections aren't retried.
+func SafeHTTPClient(allow ...netip.Prefix) *http.Client {
+	return NewSafeHTTPClient(DefaultHTTPClientConfig(), allow...)
+}
+
+// NewSafeHTTPClient builds a client like NewHTTPClient, but connecting
+// through a SafeDialer. Any DialContext in cfg is replaced, and proxies are
+// not used, since they would bypass the address checks.
+func NewSafeHTTPClient(cfg HTTPClientConfig, allow ...netip.Prefix) *http.Client {
+	d := NewSafeDialer(allow...)
+	if cfg.DialTimeout > 0 {
+		d.Dialer.Timeout = cfg.DialTimeout
+	}
+	cfg.DialContext = d.DialContext
+	cfg.noProxy = true
+
+	policy := cfg.RetryPolicy
+	if policy == nil {
+		policy = retryablehttp.DefaultRetryPolicy
+	}
+	cfg.RetryPolicy = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if errors.Is(err, ErrDisallowedAddress) {
+			return false, err
+		}
+		return policy(ctx, resp, err)
+	}
+	return NewHTTPClient(cfg)
+}
@@ -0,0 +1,63 @@
+package util
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	cases := map[string]bool{
+		"8.8.8.8":              true,
+		"2606:4700::1111":      true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"255.255.255.255":      false,
+		"224.0.0.1":            false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:8.8.8.8":       true,
+		"2002:7f00:1::1":       false,
+		"::ffff:0:192.168.0.1": false,
+	}
+	for s, public := range cases {
+		if IsPublicAddr(netip.MustParseAddr(s)) != public {
+			t.Errorf("IsPublicAddr(%s) should be %v", s, public)
+		}
+	}
+}
+
+func TestSafeHTTPClient(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	defer srv.Close()
+
+	_, err := SafeHTTPClient().Get(srv.URL)
+	if !errors.Is(err, ErrDisallowedAddress) {
+		t.Fatalf("expected ErrDisallowedAddress, got: %v", err)
+	}
+	if calls.Load() != 0 {
+		t.Fatal("request should not have reached the server")
+	}
+
+	resp, err := SafeHTTPClient(netip.MustParsePrefix("127.0.0.0/8")).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if calls.Load() != 1 {
+		t.Fatal("expected the allowlisted request to reach the server")
+	}
+}
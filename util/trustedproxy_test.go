@@ -0,0 +1,43 @@
+package util
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxyIPExtractor(t *testing.T) {
+	cases := []struct {
+		name    string
+		proxies []string
+		remote  string
+		xff     string
+		ip      string
+	}{
+		{"no proxies", nil, "198.51.100.7:1234", "203.0.113.5", "198.51.100.7"},
+		{"trusted proxy", []string{"198.51.100.7"}, "198.51.100.7:1234", "203.0.113.5", "203.0.113.5"},
+		{"trusted range", []string{"198.51.100.0/24"}, "198.51.100.7:1234", "203.0.113.5", "203.0.113.5"},
+		{"trusted IPv6 proxy", []string{"2001:db8::1"}, "[2001:db8::1]:1234", "203.0.113.5", "203.0.113.5"},
+		{"untrusted remote", []string{"198.51.100.0/24"}, "192.0.2.9:1234", "203.0.113.5", "192.0.2.9"},
+		{"loopback isn't trusted unless listed", []string{"198.51.100.0/24"}, "127.0.0.1:1234", "203.0.113.5", "127.0.0.1"},
+		{"client's own X-Forwarded-For", []string{"198.51.100.7"}, "198.51.100.7:1234", "192.0.2.1, 203.0.113.5", "203.0.113.5"},
+	}
+	for _, c := range cases {
+		extract, err := TrustedProxyIPExtractor(c.proxies)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = c.remote
+		req.Header.Set("X-Forwarded-For", c.xff)
+		if got := extract(req); got != c.ip {
+			t.Errorf("%s: got client IP %s, expected %s", c.name, got, c.ip)
+		}
+	}
+
+	for _, bad := range []string{"nope", "198.51.100.0/33", "2001:db8::/129"} {
+		if _, err := TrustedProxyIPExtractor([]string{bad}); err == nil {
+			t.Errorf("expected trusted proxy %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package xrpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+type CacheConfig struct {
+	// Size is the maximum number of responses kept. Defaults to 1000.
+	Size int
+	// TTL is how long responses are kept, unless a method has its own TTL,
+	// or the server asks for less with Cache-Control. Defaults to 30s.
+	TTL time.Duration
+	// MethodTTL overrides TTL for specific methods (NSIDs). A zero or
+	// negative TTL disables caching for that method.
+	MethodTTL map[string]time.Duration
+	// MaxEntryBytes is the size of the largest response body which will be
+	// cached. Defaults to 1 MiB.
+	MaxEntryBytes int
+	// StaleTTL is how long after expiring a response is kept for use when
+	// the server can't be reached or fails (with a 5xx status), so that
+	// brief outages aren't visible to clients. Zero disables this.
+	StaleTTL time.Duration
+	// Store holds the cached responses. Defaults to an in-memory LRU of Size
+	// entries; a shared store (like RedisCacheStore) lets several processes
+	// use one cache.
+	Store CacheStore
+}
+
+// CacheStore is where a ResponseCache keeps responses. Implementations must
+// be safe for concurrent use.
+type CacheStore interface {
+	// Get returns an entry, which may have expired
+	Get(ctx context.Context, key string) (CacheEntry, bool)
+	// Add stores an entry, which can be dropped after keep
+	Add(ctx context.Context, key string, ent CacheEntry, keep time.Duration)
+	Purge(ctx context.Context)
+}
+
+type CacheEntry struct {
+	Body    []byte    `json:"body"`
+	Expires time.Time `json:"expires"`
+}
+
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		Size:          1000,
+		TTL:           30 * time.Second,
+		MaxEntryBytes: 1 << 20,
+	}
+}
+
+// ResponseCache holds the bodies of recent successful query (GET) responses,
+// so that identical queries made by a Client within a short time don't all go
+// to the server. It is safe for concurrent use, and can be shared between
+// clients.
+//
+// Responses are cached per host, per authenticated account, and per set of
+// extra headers, as those can all change the response. Admin requests and
+// procedures (POST) are never cached, nor are responses the server marks
+// no-store or no-cache.
+//
+// Concurrent misses for the same query share a single request to the server,
+// so a popular query expiring doesn't send a burst of identical requests.
+type ResponseCache struct {
+	cfg    CacheConfig
+	store  CacheStore
+	flight singleflight.Group
+
+	hits   atomic.Int64
+	misses atomic.Int64
+	stale  atomic.Int64
+}
+
+// lruCacheStore is the default, in-memory CacheStore
+type lruCacheStore struct {
+	entries *lru.Cache[string, lruEntry]
+}
+
+type lruEntry struct {
+	CacheEntry
+	drop time.Time
+}
+
+func (s *lruCacheStore) Get(ctx context.Context, key string) (CacheEntry, bool) {
+	ent, ok := s.entries.Get(key)
+	if !ok {
+		return CacheEntry{}, false
+	}
+	if time.Now().After(ent.drop) {
+		s.entries.Remove(key)
+		return CacheEntry{}, false
+	}
+	return ent.CacheEntry, true
+}
+
+func (s *lruCacheStore) Add(ctx context.Context, key string, ent CacheEntry, keep time.Duration) {
+	s.entries.Add(key, lruEntry{CacheEntry: ent, drop: time.Now().Add(keep)})
+}
+
+func (s *lruCacheStore) Purge(ctx context.Context) {
+	s.entries.Purge()
+}
+
+func NewResponseCache(cfg CacheConfig) *ResponseCache {
+	def := DefaultCacheConfig()
+	if cfg.Size <= 0 {
+		cfg.Size = def.Size
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = def.TTL
+	}
+	if cfg.MaxEntryBytes <= 0 {
+		cfg.MaxEntryBytes = def.MaxEntryBytes
+	}
+	store := cfg.Store
+	if store == nil {
+		entries, err := lru.New[string, lruEntry](cfg.Size)
+		if err != nil {
+			// only possible with a non-positive size
+			panic(err)
+		}
+		store = &lruCacheStore{entries: entries}
+	}
+	return &ResponseCache{cfg: cfg, store: store}
+}
+
+// ttl returns how long responses for method may be cached, or zero if they
+// may not be
+func (rc *ResponseCache) ttl(method string) time.Duration {
+	if ttl, ok := rc.cfg.MethodTTL[method]; ok {
+		return max(0, ttl)
+	}
+	return rc.cfg.TTL
+}
+
+// get returns a fresh cached response, or else a stale one (which is only
+// used if the server fails) if there is one
+func (rc *ResponseCache) get(ctx context.Context, key string) (body []byte, fresh bool, stale []byte) {
+	ent, ok := rc.store.Get(ctx, key)
+	if ok && time.Now().Before(ent.Expires) {
+		rc.hits.Add(1)
+		return ent.Body, true, nil
+	}
+	rc.misses.Add(1)
+	if ok && time.Now().Before(ent.Expires.Add(rc.cfg.StaleTTL)) {
+		return nil, false, ent.Body
+	}
+	return nil, false, nil
+}
+
+func (rc *ResponseCache) put(ctx context.Context, key string, method string, hdr http.Header, body []byte) {
+	if len(body) > rc.cfg.MaxEntryBytes {
+		return
+	}
+	ttl, ok := cacheControlTTL(hdr, rc.ttl(method))
+	if !ok || ttl <= 0 {
+		return
+	}
+	rc.store.Add(ctx, key, CacheEntry{Body: body, Expires: time.Now().Add(ttl)}, ttl+rc.cfg.StaleTTL)
+}
+
+// errServerFailure marks errors (connection failures and 5xx responses) for
+// which a stale response may be used instead
+type errServerFailure struct {
+	err error
+}
+
+func (e *errServerFailure) Error() string { return e.err.Error() }
+func (e *errServerFailure) Unwrap() error { return e.err }
+
+// fetch makes a request for a cache miss, through do. Concurrent misses for
+// the same key wait for a single request; they share its outcome, including
+// the error if the context of the goroutine making it is cancelled. If it
+// fails with a server failure and stale is set, stale is returned instead.
+func (rc *ResponseCache) fetch(ctx context.Context, key, method string, stale []byte, do func() ([]byte, http.Header, error)) ([]byte, error) {
+	v, err, _ := rc.flight.Do(key, func() (any, error) {
+		body, hdr, err := do()
+		if err != nil {
+			return nil, err
+		}
+		rc.put(ctx, key, method, hdr, body)
+		return body, nil
+	})
+	if err != nil {
+		var sf *errServerFailure
+		if !errors.As(err, &sf) {
+			return nil, err
+		}
+		if stale != nil {
+			rc.stale.Add(1)
+			return stale, nil
+		}
+		return nil, sf.err
+	}
+	return v.([]byte), nil
+}
+
+// Stats returns the number of cache hits and misses so far
+func (rc *ResponseCache) Stats() (hits, misses int64) {
+	return rc.hits.Load(), rc.misses.Load()
+}
+
+// StaleHits returns how many times a stale response was used because the
+// server failed
+func (rc *ResponseCache) StaleHits() int64 {
+	return rc.stale.Load()
+}
+
+// Purge empties the cache
+func (rc *ResponseCache) Purge() {
+	rc.store.Purge(context.Background())
+}
+
+// cacheKey identifies a query, including everything about the client which
+// could change the response
+func (c *Client) cacheKey(method, paramStr string) string {
+	var sb strings.Builder
+	sb.WriteString(c.Host)
+	sb.WriteString("/xrpc/")
+	sb.WriteString(method)
+	sb.WriteString(paramStr)
+	if c.Auth != nil {
+		// responses include viewer state, so differ between accounts
+		sb.WriteString(" did=")
+		sb.WriteString(c.Auth.Did)
+	}
+	if len(c.Headers) > 0 {
+		keys := make([]string, 0, len(c.Headers))
+		for k := range c.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			sb.WriteString(" ")
+			sb.WriteString(strings.ToLower(k))
+			sb.WriteString("=")
+			sb.WriteString(c.Headers[k])
+		}
+	}
+	return sb.String()
+}
+
+// cacheControlTTL limits ttl to what the response's Cache-Control header
+// allows. Returns false if the response must not be cached at all.
+func cacheControlTTL(hdr http.Header, ttl time.Duration) (time.Duration, bool) {
+	for _, cc := range hdr.Values("Cache-Control") {
+		for _, dir := range strings.Split(cc, ",") {
+			name, val, _ := strings.Cut(strings.TrimSpace(dir), "=")
+			switch strings.ToLower(name) {
+			case "no-store", "no-cache":
+				return 0, false
+			case "max-age":
+				secs, err := strconv.Atoi(strings.Trim(val, `"`))
+				if err != nil {
+					continue
+				}
+				if d := time.Duration(secs) * time.Second; d < ttl {
+					ttl = d
+				}
+			}
+		}
+	}
+	return ttl, true
+}
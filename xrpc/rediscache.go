@@ -0,0 +1,67 @@
+package xrpc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisCacheStore keeps cached responses in Redis, so several processes (eg,
+// replicas of a service behind a load balancer) share one cache, and it
+// survives restarts. The cache is best-effort: Redis errors are treated as
+// misses, and failed writes are dropped.
+type RedisCacheStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisCacheStore creates a store keeping responses under keys starting
+// with prefix
+func NewRedisCacheStore(client redis.UniversalClient, prefix string) *RedisCacheStore {
+	return &RedisCacheStore{client: client, prefix: prefix}
+}
+
+// cache keys include query parameters, so can be long; they are hashed
+func (s *RedisCacheStore) redisKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return s.prefix + hex.EncodeToString(h[:])
+}
+
+// values are the expiry time (unix milliseconds, big endian) then the body
+func (s *RedisCacheStore) Get(ctx context.Context, key string) (CacheEntry, bool) {
+	b, err := s.client.Get(ctx, s.redisKey(key)).Bytes()
+	if err != nil || len(b) < 8 {
+		return CacheEntry{}, false
+	}
+	return CacheEntry{
+		Expires: time.UnixMilli(int64(binary.BigEndian.Uint64(b[:8]))),
+		Body:    b[8:],
+	}, true
+}
+
+func (s *RedisCacheStore) Add(ctx context.Context, key string, ent CacheEntry, keep time.Duration) {
+	v := make([]byte, 8+len(ent.Body))
+	binary.BigEndian.PutUint64(v[:8], uint64(ent.Expires.UnixMilli()))
+	copy(v[8:], ent.Body)
+	s.client.Set(ctx, s.redisKey(key), v, keep)
+}
+
+// Purge removes every response under the store's prefix
+func (s *RedisCacheStore) Purge(ctx context.Context) {
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", 1000).Iterator()
+	var batch []string
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) >= 1000 {
+			s.client.Del(ctx, batch...)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		s.client.Del(ctx, batch...)
+	}
+}
@@ -0,0 +1,61 @@
+package xrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisCacheStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rclient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write([]byte(`{"actor":"` + r.URL.Query().Get("actor") + `"}`))
+	}))
+	defer srv.Close()
+
+	// two caches sharing one store, as two processes would
+	newClient := func() *Client {
+		cfg := DefaultCacheConfig()
+		cfg.Store = NewRedisCacheStore(rclient, "xrpc:")
+		return &Client{Host: srv.URL, Client: srv.Client(), Cache: NewResponseCache(cfg)}
+	}
+	a, b := newClient(), newClient()
+
+	for _, c := range []*Client{a, b} {
+		var out map[string]string
+		if err := c.Do(ctx, Query, "", "app.bsky.actor.getProfile", map[string]any{"actor": "alice.test"}, nil, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out["actor"] != "alice.test" {
+			t.Fatalf("unexpected response: %v", out)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected the second client to be served from redis, got %d requests", calls.Load())
+	}
+
+	// entries are dropped by redis once they can't be used
+	keys := mr.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("expected one key, got %v", keys)
+	}
+	if ttl := mr.TTL(keys[0]); ttl <= 0 || ttl > 30*time.Second {
+		t.Fatalf("unexpected key ttl: %s", ttl)
+	}
+
+	a.Cache.Purge()
+	if len(mr.Keys()) != 0 {
+		t.Fatal("expected purge to remove keys")
+	}
+}
@@ -22,6 +22,8 @@ type Client struct {
 	Host       string
 	UserAgent  *string
 	Headers    map[string]string
+	// Cache, if set, is used for query (GET) responses
+	Cache *ResponseCache
 }
 
 func (c *Client) getClient() *http.Client {
@@ -100,6 +102,20 @@ func (c *Client) Do(ctx context.Context, kind XRPCRequestType, inpenc string, me
 		paramStr = "?" + makeParams(params)
 	}
 
+	useAdmin := c.AdminToken != nil && (strings.HasPrefix(method, "com.atproto.admin.") || method == "com.atproto.account.createInviteCode" || method == "com.atproto.server.createInviteCode" || method == "com.atproto.server.createInviteCodes")
+
+	var cacheKey string
+	var stale []byte
+	if c.Cache != nil && kind == Query && bodyobj == nil && !useAdmin && c.Cache.ttl(method) > 0 {
+		cacheKey = c.cacheKey(method, paramStr)
+		var cached []byte
+		var fresh bool
+		cached, fresh, stale = c.Cache.get(ctx, cacheKey)
+		if fresh {
+			return decodeOutput(bytes.NewReader(cached), int64(len(cached)), out)
+		}
+	}
+
 	req, err := http.NewRequest(m, c.Host+"/xrpc/"+method+paramStr, body)
 	if err != nil {
 		return err
@@ -121,12 +137,41 @@ func (c *Client) Do(ctx context.Context, kind XRPCRequestType, inpenc string, me
 	}
 
 	// use admin auth if we have it configured and are doing a request that requires it
-	if c.AdminToken != nil && (strings.HasPrefix(method, "com.atproto.admin.") || method == "com.atproto.account.createInviteCode" || method == "com.atproto.server.createInviteCodes") {
+	if useAdmin {
 		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:"+*c.AdminToken)))
 	} else if c.Auth != nil {
 		req.Header.Set("Authorization", "Bearer "+c.Auth.AccessJwt)
 	}
 
+	if cacheKey != "" {
+		// the whole body is read, as it may be shared with other callers
+		// waiting on the same query (bodies too big to cache aren't kept)
+		b, err := c.Cache.fetch(ctx, cacheKey, method, stale, func() ([]byte, http.Header, error) {
+			resp, err := c.getClient().Do(req.WithContext(ctx))
+			if err != nil {
+				return nil, nil, &errServerFailure{fmt.Errorf("request failed: %w", err)}
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != 200 {
+				err := responseError(resp)
+				if resp.StatusCode >= 500 {
+					err = &errServerFailure{err}
+				}
+				return nil, nil, err
+			}
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return nil, nil, fmt.Errorf("reading response body: %w", err)
+			}
+			return b, resp.Header, nil
+		})
+		if err != nil {
+			return err
+		}
+		return decodeOutput(bytes.NewReader(b), int64(len(b)), out)
+	}
+
 	resp, err := c.getClient().Do(req.WithContext(ctx))
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
@@ -135,28 +180,39 @@ func (c *Client) Do(ctx context.Context, kind XRPCRequestType, inpenc string, me
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		var xe XRPCError
-		if err := json.NewDecoder(resp.Body).Decode(&xe); err != nil {
-			return fmt.Errorf("failed to decode xrpc error message (status: %d): %w", resp.StatusCode, err)
-		}
-		return fmt.Errorf("XRPC ERROR %d: %w", resp.StatusCode, &xe)
+		return responseError(resp)
 	}
 
+	return decodeOutput(resp.Body, resp.ContentLength, out)
+}
+
+// responseError decodes the error from a non-200 response
+func responseError(resp *http.Response) error {
+	var xe XRPCError
+	if err := json.NewDecoder(resp.Body).Decode(&xe); err != nil {
+		return fmt.Errorf("failed to decode xrpc error message (status: %d): %w", resp.StatusCode, err)
+	}
+	return fmt.Errorf("XRPC ERROR %d: %w", resp.StatusCode, &xe)
+}
+
+// decodeOutput reads a successful response body into out: either raw bytes
+// into a *bytes.Buffer, or JSON into anything else
+func decodeOutput(body io.Reader, contentLength int64, out interface{}) error {
 	if out != nil {
 		if buf, ok := out.(*bytes.Buffer); ok {
-			if resp.ContentLength < 0 {
-				_, err := io.Copy(buf, resp.Body)
+			if contentLength < 0 {
+				_, err := io.Copy(buf, body)
 				if err != nil {
 					return fmt.Errorf("reading response body: %w", err)
 				}
 			} else {
-				n, err := io.CopyN(buf, resp.Body, resp.ContentLength)
+				n, err := io.CopyN(buf, body, contentLength)
 				if err != nil {
-					return fmt.Errorf("reading length delimited response body (%d < %d): %w", n, resp.ContentLength, err)
+					return fmt.Errorf("reading length delimited response body (%d < %d): %w", n, contentLength, err)
 				}
 			}
 		} else {
-			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			if err := json.NewDecoder(body).Decode(out); err != nil {
 				return fmt.Errorf("decoding xrpc response: %w", err)
 			}
 		}
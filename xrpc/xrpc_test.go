@@ -1,7 +1,14 @@
 package xrpc
 
 import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // TestMakeParams tests the makeParams function.
@@ -57,3 +64,175 @@ func TestMakeParams(t *testing.T) {
 		})
 	}
 }
+
+func TestResponseCache(t *testing.T) {
+	var calls atomic.Int32
+	cacheControl := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"InvalidRequest","message":"nope"}`))
+			return
+		}
+		w.Write([]byte(`{"path":"` + r.URL.Path + `","actor":"` + r.URL.Query().Get("actor") + `"}`))
+	}))
+	defer srv.Close()
+
+	cfg := DefaultCacheConfig()
+	cfg.MethodTTL = map[string]time.Duration{"app.bsky.feed.getTimeline": 0}
+	cache := NewResponseCache(cfg)
+	c := &Client{Host: srv.URL, Client: srv.Client(), Cache: cache}
+	ctx := context.Background()
+
+	query := func(client *Client, method string, params map[string]any) map[string]string {
+		t.Helper()
+		var out map[string]string
+		if err := client.Do(ctx, Query, "", method, params, nil, &out); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	expectCalls := func(n int32) {
+		t.Helper()
+		if calls.Load() != n {
+			t.Fatalf("expected %d requests to reach the server, got %d", n, calls.Load())
+		}
+	}
+
+	out := query(c, "app.bsky.actor.getProfile", map[string]any{"actor": "alice.test"})
+	if out["actor"] != "alice.test" {
+		t.Fatalf("unexpected response: %v", out)
+	}
+	query(c, "app.bsky.actor.getProfile", map[string]any{"actor": "alice.test"})
+	expectCalls(1)
+	var buf bytes.Buffer
+	if err := c.Do(ctx, Query, "", "app.bsky.actor.getProfile", map[string]any{"actor": "alice.test"}, nil, &buf); err != nil {
+		t.Fatal(err)
+	}
+	expectCalls(1)
+	if !strings.Contains(buf.String(), "alice.test") {
+		t.Fatalf("unexpected raw response: %s", buf.String())
+	}
+
+	// different params, or a different account, are different queries
+	query(c, "app.bsky.actor.getProfile", map[string]any{"actor": "bob.test"})
+	expectCalls(2)
+	authed := &Client{Host: srv.URL, Client: srv.Client(), Cache: cache, Auth: &AuthInfo{Did: "did:plc:abc"}}
+	query(authed, "app.bsky.actor.getProfile", map[string]any{"actor": "alice.test"})
+	expectCalls(3)
+
+	// disabled per-method
+	query(c, "app.bsky.feed.getTimeline", nil)
+	query(c, "app.bsky.feed.getTimeline", nil)
+	expectCalls(5)
+
+	// errors aren't cached
+	for i := 0; i < 2; i++ {
+		if err := c.Do(ctx, Query, "", "app.bsky.actor.getProfile", map[string]any{"fail": "y"}, nil, nil); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	expectCalls(7)
+
+	// and neither are responses the server says not to
+	cacheControl = "private, no-store"
+	query(c, "app.bsky.actor.getProfiles", nil)
+	query(c, "app.bsky.actor.getProfiles", nil)
+	expectCalls(9)
+	cacheControl = "max-age=0"
+	query(c, "app.bsky.actor.getSuggestions", nil)
+	query(c, "app.bsky.actor.getSuggestions", nil)
+	expectCalls(11)
+
+	hits, misses := cache.Stats()
+	if hits != 2 || misses != 9 {
+		t.Fatalf("unexpected stats: %d hits, %d misses", hits, misses)
+	}
+}
+
+func TestCacheControlTTL(t *testing.T) {
+	hdr := http.Header{}
+	hdr.Set("Cache-Control", "public, max-age=10")
+	if ttl, ok := cacheControlTTL(hdr, time.Minute); !ok || ttl != 10*time.Second {
+		t.Fatalf("max-age should limit TTL: %s %v", ttl, ok)
+	}
+	if ttl, ok := cacheControlTTL(hdr, time.Second); !ok || ttl != time.Second {
+		t.Fatalf("max-age should not extend TTL: %s %v", ttl, ok)
+	}
+	hdr.Set("Cache-Control", "No-Cache")
+	if _, ok := cacheControlTTL(hdr, time.Minute); ok {
+		t.Fatal("no-cache responses should not be cached")
+	}
+}
+
+func TestResponseCacheStale(t *testing.T) {
+	var calls atomic.Int32
+	var failing atomic.Bool
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Query().Get("slow") != "" {
+			<-release
+		}
+		if failing.Load() {
+			w.WriteHeader(502)
+			w.Write([]byte(`{"error":"UpstreamFailure"}`))
+			return
+		}
+		w.Write([]byte(`{"n":"` + r.URL.Query().Get("n") + `"}`))
+	}))
+	defer srv.Close()
+
+	cfg := DefaultCacheConfig()
+	cfg.TTL = 10 * time.Millisecond
+	cfg.StaleTTL = time.Minute
+	cfg.MethodTTL = map[string]time.Duration{"app.bsky.actor.getProfiles": time.Minute}
+	cache := NewResponseCache(cfg)
+	c := &Client{Host: srv.URL, Client: srv.Client(), Cache: cache}
+	ctx := context.Background()
+
+	var out map[string]string
+	if err := c.Do(ctx, Query, "", "app.bsky.actor.getProfile", map[string]any{"n": "1"}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// expired, but the server is down, so the stale response is used
+	failing.Store(true)
+	out = nil
+	if err := c.Do(ctx, Query, "", "app.bsky.actor.getProfile", map[string]any{"n": "1"}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["n"] != "1" || calls.Load() != 2 || cache.StaleHits() != 1 {
+		t.Fatalf("expected stale response: %v, %d calls, %d stale", out, calls.Load(), cache.StaleHits())
+	}
+	// with nothing cached, the error comes through
+	if err := c.Do(ctx, Query, "", "app.bsky.actor.getProfile", map[string]any{"n": "2"}, nil, nil); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("expected server error, got %v", err)
+	}
+	failing.Store(false)
+
+	// concurrent misses share one request
+	calls.Store(0)
+	errs := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func() {
+			var out map[string]string
+			errs <- c.Do(ctx, Query, "", "app.bsky.actor.getProfiles", map[string]any{"n": "3", "slow": "y"}, nil, &out)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected concurrent misses to share a request, got %d", calls.Load())
+	}
+}